@@ -0,0 +1,70 @@
+package beelog
+
+import "time"
+
+const (
+	// adaptiveTargetInterval is the desired time between two consecutive reduces on
+	// Adaptive config. The effective period is adjusted to the number of commands
+	// expected to arrive within this interval, under the observed command rate.
+	adaptiveTargetInterval = time.Second
+
+	// adaptiveSmoothFactor sets the weight of the prior period on each adjustment,
+	// avoiding abrupt changes on bursty workloads.
+	adaptiveSmoothFactor = 0.5
+)
+
+// adaptivePeriod tracks the command rate and table occupancy observed between
+// reduces, adjusting the effective reduce period on Adaptive configs.
+type adaptivePeriod struct {
+	min, max, cur uint32
+	lastReduce    time.Time
+}
+
+func newAdaptivePeriod(cfg *LogConfig) *adaptivePeriod {
+	return &adaptivePeriod{
+		min:        cfg.MinPeriod,
+		max:        cfg.MaxPeriod,
+		cur:        cfg.MinPeriod,
+		lastReduce: time.Now(),
+	}
+}
+
+// adjust computes the next effective period, considering that 'cmds' commands were
+// logged since the last reduce, resulting in 'occ' unique keys on the structure.
+// High command rates increase the period, amortizing reduce costs, while idle
+// periods shrink it, persisting recent writes sooner. Tables with high occupancy
+// (i.e. few overwrites) gain little from larger periods, and are also shrinked.
+func (ap *adaptivePeriod) adjust(cmds uint32, occ int) {
+	now := time.Now()
+	elapsed := now.Sub(ap.lastReduce).Seconds()
+	ap.lastReduce = now
+
+	if cmds == 0 || elapsed <= 0 {
+		return
+	}
+	rate := float64(cmds) / elapsed
+	next := rate * adaptiveTargetInterval.Seconds()
+
+	occRatio := float64(occ) / float64(cmds)
+	if occRatio > 1 {
+		occRatio = 1
+	}
+	next *= 1 - occRatio/2
+
+	next = adaptiveSmoothFactor*float64(ap.cur) + (1-adaptiveSmoothFactor)*next
+	switch {
+	case next < float64(ap.min):
+		ap.cur = ap.min
+
+	case next > float64(ap.max):
+		ap.cur = ap.max
+
+	default:
+		ap.cur = uint32(next)
+	}
+}
+
+// period returns the current effective period.
+func (ap *adaptivePeriod) period() uint32 {
+	return ap.cur
+}
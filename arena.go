@@ -0,0 +1,106 @@
+package beelog
+
+const (
+	// number of nodes on the first slab of an avlArena, doubled on each new slab
+	// up to 'maxArenaSlabSize'.
+	minArenaSlabSize = 64
+	maxArenaSlabSize = 4096
+)
+
+// AllocStats reports node allocations of a structure, distinguishing nodes served
+// from recycled memory.
+type AllocStats struct {
+	// number of nodes allocated since the structure creation
+	Allocs uint64
+
+	// number of allocations served from memory recycled by 'Reset()' calls
+	Reused uint64
+
+	// number of slabs retained by the structure, and their total node capacity
+	Slabs    int
+	Capacity int
+}
+
+// avlSlab groups a fixed number of tree entries, key states and update list nodes,
+// allocated together to amortize allocations of each logged command.
+type avlSlab struct {
+	entries []avlTreeEntry
+	states  []State
+	nodes   []listNode
+	used    int
+}
+
+func newAVLSlab(size int) *avlSlab {
+	return &avlSlab{
+		entries: make([]avlTreeEntry, size),
+		states:  make([]State, size),
+		nodes:   make([]listNode, size),
+	}
+}
+
+// avlArena allocates the nodes of an AVLTreeHT from slabs, which are retained after a
+// 'reset()' call and recycled by subsequent insertions instead of garbage collected.
+// Not safe for concurrent use, mutual exclusion is done by outer scope.
+type avlArena struct {
+	slabs []*avlSlab
+	cur   int
+
+	// number of slabs filled since the last reset, whose memory is being reused
+	recycled int
+	stats    AllocStats
+}
+
+// alloc returns a zeroed tree entry, state and list node for a new logged command.
+func (ar *avlArena) alloc() (*avlTreeEntry, *State, *listNode) {
+	if len(ar.slabs) == 0 || ar.slabs[ar.cur].used == len(ar.slabs[ar.cur].entries) {
+		ar.nextSlab()
+	}
+	sl := ar.slabs[ar.cur]
+	i := sl.used
+	sl.used++
+
+	ar.stats.Allocs++
+	if ar.cur < ar.recycled {
+		ar.stats.Reused++
+	}
+	return &sl.entries[i], &sl.states[i], &sl.nodes[i]
+}
+
+// nextSlab advances to the next retained slab, or allocates a new one if every
+// retained slab is full.
+func (ar *avlArena) nextSlab() {
+	if len(ar.slabs) > 0 && ar.cur+1 < len(ar.slabs) {
+		ar.cur++
+		return
+	}
+
+	size := minArenaSlabSize
+	if n := len(ar.slabs); n > 0 {
+		size = 2 * len(ar.slabs[n-1].entries)
+		if size > maxArenaSlabSize {
+			size = maxArenaSlabSize
+		}
+	}
+	ar.slabs = append(ar.slabs, newAVLSlab(size))
+	ar.cur = len(ar.slabs) - 1
+	ar.stats.Slabs++
+	ar.stats.Capacity += size
+}
+
+// reset recycles every allocated node. Slabs are zeroed to release references
+// to commands logged on them, allowing their collection.
+func (ar *avlArena) reset() {
+	if len(ar.slabs) == 0 {
+		return
+	}
+	for _, sl := range ar.slabs[:ar.cur+1] {
+		for i := 0; i < sl.used; i++ {
+			sl.entries[i] = avlTreeEntry{}
+			sl.states[i] = State{}
+			sl.nodes[i] = listNode{}
+		}
+		sl.used = 0
+	}
+	ar.recycled = len(ar.slabs)
+	ar.cur = 0
+}
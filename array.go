@@ -1,8 +1,9 @@
 package beelog
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 
@@ -11,20 +12,27 @@ import (
 
 // ArrayHT ...
 type ArrayHT struct {
-	arr *[]listEntry
-	aux *stateTable
-	mu  sync.RWMutex
+	arr  *[]listEntry
+	aux  *stateTable
+	mu   sync.RWMutex
+	canc context.CancelFunc
 	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
 }
 
 // NewArrayHT ...
 func NewArrayHT() *ArrayHT {
 	ht := make(stateTable, 0)
-	return &ArrayHT{
-		logData: logData{config: DefaultLogConfig()},
+	ar := &ArrayHT{
+		logData: newLogData(DefaultLogConfig()),
 		arr:     &[]listEntry{},
 		aux:     &ht,
 	}
+	ar.ctx, ar.canc = context.WithCancel(context.Background())
+	return ar
 }
 
 // NewArrayHTWithConfig ...
@@ -35,17 +43,31 @@ func NewArrayHTWithConfig(cfg *LogConfig) (*ArrayHT, error) {
 	}
 
 	ht := make(stateTable, 0)
-	var sz uint32
-	if sz = cfg.Period; sz < 1000 {
-		sz = 1000
-	}
-	sl := make([]listEntry, 0, 2*sz)
+	sl := make([]listEntry, 0, arrayCapacity(cfg))
 
-	return &ArrayHT{
-		logData: logData{config: cfg},
+	ar := &ArrayHT{
+		logData: newLogData(cfg),
 		arr:     &sl,
 		aux:     &ht,
-	}, nil
+	}
+
+	if err := ar.restoreOnInit(ar.logCtx); err != nil {
+		return nil, err
+	}
+
+	if err := ar.initMeasure("array"); err != nil {
+		return nil, err
+	}
+
+	if err := ar.restoreJournal(ar.LogCtx); err != nil {
+		return nil, err
+	}
+
+	ar.ctx, ar.canc = context.WithCancel(context.Background())
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(ar.ctx, cfg, &ar.tick, ar.reduceOnTick)
+	}
+	return ar, nil
 }
 
 // Str returns a string representation of the array state, used for debug purposes.
@@ -62,20 +84,93 @@ func (ar *ArrayHT) Str() string {
 
 // Len returns the list length.
 func (ar *ArrayHT) Len() uint64 {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.length()
+}
+
+func (ar *ArrayHT) length() uint64 {
 	return uint64(len(*ar.arr))
 }
 
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (ar *ArrayHT) FirstIndex() uint64 {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (ar *ArrayHT) LastIndex() uint64 {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (ar *ArrayHT) Debug() DebugInfo {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.debugInfo("array", uint64(len(*ar.arr)))
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (ar *ArrayHT) Stats() (Stats, error) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.stateTableStats(*ar.aux, listEntryBytes)
+}
+
 // Log records the occurence of command 'cmd' on the provided index. Writes are
 // mapped as a new node on the underlying array, with a pointer to the newly inserted
 // state update on the update list for its particular key.
 func (ar *ArrayHT) Log(cmd pb.Command) error {
+	return ar.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (ar *ArrayHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return ar.seq.sequence(ctx, cmd, ar.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (ar *ArrayHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ar.mu.Lock()
 	defer ar.mu.Unlock()
+	if ar.ignoresRead(&cmd) {
+		return nil
+	}
+	ar.measureBegin()
+	ar.countLogged(&cmd)
+	ar.recordPinned(&cmd)
+
+	if err := ar.journalCommand(cmd); err != nil {
+		return err
+	}
 
-	if cmd.Op != pb.Command_SET {
-		// TODO: treat 'ar.first' attribution on GETs
+	if isRangeDelete(cmd.Op) {
+		// matching states are only discarded during reduce
+		ar.tombs.add(cmd)
 		ar.last = cmd.Id
-		return ar.mayTriggerReduce()
+		ar.measureLogged()
+		if ar.config.Tick == Immediately {
+			return ar.reduceLogCtx(ctx, ar.first, ar.last)
+		}
+		return ar.mayTriggerReduce(ctx)
+	}
+
+	if !isWriteOp(cmd.Op) {
+		ar.attributeFirst(cmd.Id)
+		ar.last = cmd.Id
+		ar.measureLogged()
+		return ar.mayTriggerReduce(ctx)
 	}
 
 	entry := listEntry{
@@ -99,39 +194,79 @@ func (ar *ArrayHT) Log(cmd pb.Command) error {
 	entry.ptr = lNode
 
 	// adjust first structure index
-	if ar.Len() == 0 {
-		ar.first = cmd.Id
-	}
+	ar.attributeFirst(cmd.Id)
 
 	// insert new entry on the main list
 	*ar.arr = append(*ar.arr, entry)
 
 	// adjust last index once inserted
 	ar.last = cmd.Id
+	ar.measureLogged()
 
 	// immediately recovery entirely reduces the log to its minimal format
 	if ar.config.Tick == Immediately {
-		return ar.ReduceLog(ar.first, ar.last)
+		return ar.reduceLogCtx(ctx, ar.first, ar.last)
 	}
-	return ar.mayTriggerReduce()
+	return ar.mayTriggerReduce(ctx)
 }
 
 // Recov returns a compacted log of commands, following the requested [p, n]
-// interval if 'Delayed' reduce is configured. On different period configurations,
-// the entire reduced log is always returned. On persistent configuration (i.e.
+// interval. 'Delayed' configs reduce the requested interval, while on different
+// period configurations the most recently reduced states are trimmed to [p, n],
+// merging every segment overlapping it on KeepAll configs. On persistent configuration (i.e.
 // 'inmem' false) the entire log is loaded and then unmarshaled, consider using
 // 'RecovBytes' calls instead.
 func (ar *ArrayHT) Recov(p, n uint64) ([]pb.Command, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	return ar.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (ar *ArrayHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	sd, err := ar.recovCtx(ctx, p, n, false)
+	return sd.Snapshot.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log.
+func (ar *ArrayHT) RecovWithResult(p, n uint64) (RecovResult, error) {
+	sd, err := ar.recovCtx(context.Background(), p, n, false)
+	return sd.Snapshot, err
+}
+
+// RecovSnapshotAndDelta is analogous to 'Recov', but also returns every write logged
+// within [p, n] after the recovered reduced state, not reduced.
+func (ar *ArrayHT) RecovSnapshotAndDelta(p, n uint64) (SnapshotDelta, error) {
+	return ar.recovCtx(context.Background(), p, n, true)
+}
+
+// recovCtx recovers the reduced state of [p, n], along with the commands logged after
+// it if 'delta' is set.
+func (ar *ArrayHT) recovCtx(ctx context.Context, p, n uint64, delta bool) (SnapshotDelta, error) {
+	p, n, err := ar.checkInterval(p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
 	}
+	if err := ctx.Err(); err != nil {
+		return SnapshotDelta{}, err
+	}
+	ar.hookRecovery(p, n)
 	ar.mu.RLock()
 	defer ar.mu.RUnlock()
 
-	if err := ar.mayExecuteLazyReduce(p, n); err != nil {
-		return nil, err
+	if err := ar.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return SnapshotDelta{}, err
 	}
-	return ar.retrieveLog()
+	cmds, err := ar.retrieveIntervalLogCtx(ctx, p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
+	}
+	res := ar.coverage(p, n, true)
+	res.Cmds = cmds
+	if !delta {
+		return SnapshotDelta{Snapshot: res}, nil
+	}
+	return ar.snapshotAndDelta(res, p, n, ar.deltaLog), nil
 }
 
 // RecovBytes returns an already serialized log, parsed from persistent storage
@@ -140,55 +275,190 @@ func (ar *ArrayHT) Recov(p, n uint64) ([]pb.Command, error) {
 // interpretation from the byte stream follows a simple slicing protocol, where
 // the size of each command is binary encoded before the raw pbuff.
 func (ar *ArrayHT) RecovBytes(p, n uint64) ([]byte, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	res, err := ar.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log.
+func (ar *ArrayHT) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
+	p, n, err := ar.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
 	}
+	ar.hookRecovery(p, n)
 	ar.mu.RLock()
 	defer ar.mu.RUnlock()
 
-	if err := ar.mayExecuteLazyReduce(p, n); err != nil {
+	if err := ar.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return RecovResult{}, err
+	}
+	raw, err := ar.retrieveRawLog(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := ar.coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (ar *ArrayHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := ar.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	ar.hookRecovery(p, n)
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	if err := ar.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return ar.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (ar *ArrayHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := ar.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (ar *ArrayHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := ar.RecovFiltered(p, n, f)
+	if err != nil {
 		return nil, err
 	}
-	return ar.retrieveRawLog(p, n)
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (ar *ArrayHT) RecovSince(id uint64) ([]pb.Command, error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.length() == 0 || id >= ar.last {
+		return []pb.Command{}, nil
+	}
+
+	// the structure retains every logged state, even after reduce, so only the
+	// interval after 'id' is reduced, starting from the lower bound of 'id' + 1
+	// since 'id' may not be present on the structure
+	return ar.applyRangeDeletes(GreedyArrayHT(ar, id+1, ar.last), id+1, ar.last), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (ar *ArrayHT) Snapshot() (*Snapshot, error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.length() == 0 {
+		return newSnapshot(ar.last, nil), nil
+	}
+	cmds := ar.applyRangeDeletes(GreedyArrayHT(ar, ar.first, ar.last), ar.first, ar.last)
+	return newSnapshot(ar.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (ar *ArrayHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := ar.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written, or if its state was discarded by a later range
+// delete.
+func (ar *ArrayHT) Get(key string) (pb.Command, bool) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.getState(*ar.aux, key)
+}
+
+// Keys returns every key with a state retained on the array, in ascending order.
+func (ar *ArrayHT) Keys() []string {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	return ar.stateKeys(*ar.aux)
 }
 
 // ReduceLog applies the configured reduce algorithm and updates the current log state.
 // Must only be called within mutual exclusion scope.
 func (ar *ArrayHT) ReduceLog(p, n uint64) error {
-	cmds, err := ApplyReduceAlgo(ar, ar.config.Alg, p, n)
+	return ar.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (ar *ArrayHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := ar.hookReduceStart(p, n)
+	cmds, err := ar.applyReduce(ar, p, n)
+	ar.hookReduceDone(p, n, start, len(cmds), err)
 	if err != nil {
 		return err
 	}
-	return ar.updateLogState(cmds, p, n, false)
+	if err := ar.updateLogStateCtx(ctx, cmds, p, n, 0); err != nil {
+		return err
+	}
+	if ar.config.TruncateAfterReduce {
+		ar.truncate(n)
+	}
+	ar.measurePersisted(ar.takeMeasure())
+	return nil
+}
+
+// retainedStates returns every state logged within [p, n], reduced by registered
+// reducers. Must only be called within mutual exclusion scope.
+func (ar *ArrayHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return ar.aux.statesWithin(p, n), nil
 }
 
 // mayTriggerReduce possibly triggers the reduce algorithm based on config params
 // (e.g. interval period reached). Must only be called within mutual exclusion scope.
-func (ar *ArrayHT) mayTriggerReduce() error {
-	if ar.config.Tick != Interval {
+func (ar *ArrayHT) mayTriggerReduce(ctx context.Context) error {
+	if ar.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		ar.count++
 		return nil
 	}
-	ar.count++
-	if ar.count >= ar.config.Period {
-		ar.count = 0
-		return ar.ReduceLog(ar.first, ar.last)
+	if !ar.config.Tick.isPeriodic() {
+		return nil
+	}
+	if ar.reachedReducePeriod(len(*ar.aux)) {
+		return ar.reduceLogCtx(ctx, ar.first, ar.last)
 	}
 	return nil
 }
 
 // mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
 // 'config.Period' wasnt reached yet.
-func (ar *ArrayHT) mayExecuteLazyReduce(p, n uint64) error {
+func (ar *ArrayHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
 	if ar.config.Tick == Delayed {
-		err := ar.ReduceLog(p, n)
+		err := ar.reduceLogCtx(ctx, p, n)
 		if err != nil {
 			return err
 		}
 
-	} else if ar.config.Tick == Interval && !ar.firstReduceExists() {
+	} else if ar.config.Tick.isScheduled() && !ar.firstReduceExists() {
 		// must reduce the entire structure, just the desired interval would
-		// be incoherent with the Interval config
-		err := ar.ReduceLog(ar.first, ar.last)
+		// be incoherent with scheduled configs
+		err := ar.reduceLogCtx(ctx, ar.first, ar.last)
 		if err != nil {
 			return err
 		}
@@ -196,27 +466,124 @@ func (ar *ArrayHT) mayExecuteLazyReduce(p, n uint64) error {
 	return nil
 }
 
-// TODO: later improve with an initial guess near 'ind' pos
-func (ar *ArrayHT) searchEntryPosByIndex(ind uint64) uint64 {
-	start := int64(0)
-	last := int64(ar.Len()) - 1
-	var mid int64
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (ar *ArrayHT) reduceOnTick() {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
 
-	for last > start {
-		mid = start + (last-start)/2
-		ent := (*ar.arr)[mid]
+	if ar.count == 0 {
+		return
+	}
 
-		if ent.ind == ind {
-			return uint64(mid)
+	// on failure, the counter is retained to retry on the next tick
+	err := ar.config.Retry.do(context.Background(), func() error {
+		return ar.ReduceLog(ar.first, ar.last)
+	})
+	if err != nil {
+		ar.reportReduceErr(err)
+		return
+	}
+	ar.count = 0
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once any in-flight reduce is finished.
+func (ar *ArrayHT) SetConfig(cfg *LogConfig) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return ar.reconfigure(ar.ctx, cfg, &ar.tick, ar.reduceOnTick)
+}
 
-		} else if ind > ent.ind { // greater
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (ar *ArrayHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	cfg := ar.config.clone()
+	fn(cfg)
+	return ar.reconfigure(ar.ctx, cfg, &ar.tick, ar.reduceOnTick)
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, and flushes
+// any pending group commit.
+func (ar *ArrayHT) Shutdown() {
+	if ar.canc != nil {
+		ar.canc()
+	}
+	if ar.gc != nil {
+		ar.gc.close()
+	}
+	ar.closeJournal()
+	ar.closeMeasure()
+}
+
+// searchEntryPosByIndex returns the position of the first entry with index equal or
+// greater than 'ind', or the array length if none is found, and whether its index
+// equals 'ind'.
+// TODO: later improve with an initial guess near 'ind' pos
+func (ar *ArrayHT) searchEntryPosByIndex(ind uint64) (uint64, bool) {
+	start := uint64(0)
+	last := ar.length()
+
+	for last > start {
+		mid := start + (last-start)/2
+		if ind > (*ar.arr)[mid].ind { // greater
 			start = mid + 1
 
-		} else { // less
-			last = mid - 1
+		} else { // less or equal, still the lower bound candidate
+			last = mid
 		}
 	}
-	return uint64(mid)
+	return start, start < ar.length() && (*ar.arr)[start].ind == ind
+}
+
+// truncate drops every entry indexed up to 'n' and their states, on TruncateAfterReduce
+// config, re-slicing the array past them. Must only be called within mutual exclusion
+// scope.
+func (ar *ArrayHT) truncate(n uint64) {
+	var i int
+	for ; i < len(*ar.arr) && (*ar.arr)[i].ind <= n; i++ {
+		ar.aux.popState((*ar.arr)[i].key)
+	}
+	ar.compact(i)
+
+	ar.resetFirst(0, false)
+	if len(*ar.arr) > 0 {
+		ar.resetFirst((*ar.arr)[0].ind, true)
+	}
+	ar.tombs = ar.tombs.after(n)
+}
+
+// compact drops the first 'i' entries of the array, moving the retained ones to the
+// beginning of its backing array, which is reused by later appends instead of growing
+// indefinitely. Arrays grown by a burst of writes past four times their configured
+// capacity are reallocated. Must only be called within mutual exclusion scope.
+func (ar *ArrayHT) compact(i int) {
+	rem := copy(*ar.arr, (*ar.arr)[i:])
+	if c := arrayCapacity(ar.config); cap(*ar.arr) > 4*c && rem < c {
+		sl := make([]listEntry, rem, c)
+		copy(sl, *ar.arr)
+		*ar.arr = sl
+		return
+	}
+
+	// stale entries would retain dropped states until overwritten
+	stale := (*ar.arr)[rem:]
+	for j := range stale {
+		stale[j] = listEntry{}
+	}
+	*ar.arr = (*ar.arr)[:rem]
+}
+
+// arrayCapacity returns the initial capacity of ArrayHT entries for 'cfg', twice its
+// reduce period, considering a minimum period of 1000.
+func arrayCapacity(cfg *LogConfig) int {
+	sz := cfg.Period
+	if sz < 1000 {
+		sz = 1000
+	}
+	return 2 * int(sz)
 }
 
 func (ar *ArrayHT) resetVisitedValues() {
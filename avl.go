@@ -1,8 +1,10 @@
 package beelog
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 
@@ -25,16 +27,25 @@ type AVLTreeHT struct {
 	aux  *stateTable
 	len  uint64
 	mu   sync.RWMutex
+	canc context.CancelFunc
+	mem  avlArena
+	path []*avlTreeEntry // parent stack of iterative inserts
 	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
 }
 
 // NewAVLTreeHT ...
 func NewAVLTreeHT() *AVLTreeHT {
 	ht := make(stateTable, 0)
-	return &AVLTreeHT{
+	av := &AVLTreeHT{
 		aux:     &ht,
-		logData: logData{config: DefaultLogConfig()},
+		logData: newLogData(DefaultLogConfig()),
 	}
+	av.ctx, av.canc = context.WithCancel(context.Background())
+	return av
 }
 
 // NewAVLTreeHTWithConfig ...
@@ -45,10 +56,28 @@ func NewAVLTreeHTWithConfig(cfg *LogConfig) (*AVLTreeHT, error) {
 	}
 
 	ht := make(stateTable, 0)
-	return &AVLTreeHT{
+	av := &AVLTreeHT{
 		aux:     &ht,
-		logData: logData{config: cfg},
-	}, nil
+		logData: newLogData(cfg),
+	}
+
+	if err := av.restoreOnInit(av.logCtx); err != nil {
+		return nil, err
+	}
+
+	if err := av.initMeasure("avl"); err != nil {
+		return nil, err
+	}
+
+	if err := av.restoreJournal(av.LogCtx); err != nil {
+		return nil, err
+	}
+
+	av.ctx, av.canc = context.WithCancel(context.Background())
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(av.ctx, cfg, &av.tick, av.reduceOnTick)
+	}
+	return av, nil
 }
 
 // Str implements a BFS on the AVLTree, returning a string representation for the
@@ -79,40 +108,109 @@ func (av *AVLTreeHT) Str() string {
 
 // Len returns the lenght, number of nodes on the tree.
 func (av *AVLTreeHT) Len() uint64 {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.length()
+}
+
+func (av *AVLTreeHT) length() uint64 {
 	return av.len
 }
 
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (av *AVLTreeHT) FirstIndex() uint64 {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (av *AVLTreeHT) LastIndex() uint64 {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (av *AVLTreeHT) Debug() DebugInfo {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.debugInfo("avl", av.len)
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (av *AVLTreeHT) Stats() (Stats, error) {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.stateTableStats(*av.aux, avlEntryBytes)
+}
+
 // Log records the occurence of command 'cmd' on the provided index. Writes are
 // mapped into a new node on the AVL tree, with a pointer to the newly inserted
 // state update on the update list for its particular key.
 func (av *AVLTreeHT) Log(cmd pb.Command) error {
+	return av.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (av *AVLTreeHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return av.seq.sequence(ctx, cmd, av.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (av *AVLTreeHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	av.mu.Lock()
 	defer av.mu.Unlock()
+	if av.ignoresRead(&cmd) {
+		return nil
+	}
+	av.measureBegin()
+	av.countLogged(&cmd)
+	av.recordPinned(&cmd)
 
-	if cmd.Op != pb.Command_SET {
-		// TODO: treat 'av.first' attribution on GETs
-		av.last = cmd.Id
-		return av.mayTriggerReduce()
+	if err := av.journalCommand(cmd); err != nil {
+		return err
 	}
 
-	entry := &avlTreeEntry{
-		ind: cmd.Id,
-		key: cmd.Key,
+	if isRangeDelete(cmd.Op) {
+		// matching states are only discarded during reduce
+		av.tombs.add(cmd)
+		av.last = cmd.Id
+		av.measureLogged()
+		if av.config.Tick == Immediately {
+			return av.reduceLogCtx(ctx, av.first, av.last)
+		}
+		return av.mayTriggerReduce(ctx)
 	}
 
-	// a write cmd always references a new state on the aux hash table
-	st := &State{
-		ind: cmd.Id,
-		cmd: cmd,
+	if !isWriteOp(cmd.Op) {
+		av.attributeFirst(cmd.Id)
+		av.last = cmd.Id
+		av.measureLogged()
+		return av.mayTriggerReduce(ctx)
 	}
 
+	// a write cmd always references a new state on the aux hash table, all
+	// allocated from the tree arena
+	entry, st, nd := av.mem.alloc()
+	entry.ind, entry.key = cmd.Id, cmd.Key
+	st.ind, st.cmd = cmd.Id, cmd
+
 	_, exists := (*av.aux)[cmd.Key]
 	if !exists {
 		(*av.aux)[cmd.Key] = &list{}
 	}
 
 	// add state to the list of updates in that particular key
-	lNode := (*av.aux)[cmd.Key].push(st)
+	nd.val = st
+	lNode := (*av.aux)[cmd.Key].pushNode(nd)
 	entry.ptr = lNode
 
 	ok := av.insert(entry)
@@ -122,30 +220,72 @@ func (av *AVLTreeHT) Log(cmd pb.Command) error {
 
 	// adjust last index once inserted
 	av.last = cmd.Id
+	av.measureLogged()
 
 	// Immediately recovery entirely reduces the log to its minimal format
 	if av.config.Tick == Immediately {
-		return av.ReduceLog(av.first, av.last)
+		return av.reduceLogCtx(ctx, av.first, av.last)
 	}
-	return av.mayTriggerReduce()
+	return av.mayTriggerReduce(ctx)
 }
 
 // Recov returns a compacted log of commands, following the requested [p, n]
-// interval if 'Delayed' reduce is configured. On different period configurations,
-// the entire reduced log is always returned. On persistent configuration (i.e.
+// interval. 'Delayed' configs reduce the requested interval, while on different
+// period configurations the most recently reduced states are trimmed to [p, n],
+// merging every segment overlapping it on KeepAll configs. On persistent configuration (i.e.
 // 'inmem' false) the entire log is loaded and then unmarshaled, consider using
 // 'RecovBytes' calls instead.
 func (av *AVLTreeHT) Recov(p, n uint64) ([]pb.Command, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	return av.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (av *AVLTreeHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	sd, err := av.recovCtx(ctx, p, n, false)
+	return sd.Snapshot.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log.
+func (av *AVLTreeHT) RecovWithResult(p, n uint64) (RecovResult, error) {
+	sd, err := av.recovCtx(context.Background(), p, n, false)
+	return sd.Snapshot, err
+}
+
+// RecovSnapshotAndDelta is analogous to 'Recov', but also returns every write logged
+// within [p, n] after the recovered reduced state, not reduced.
+func (av *AVLTreeHT) RecovSnapshotAndDelta(p, n uint64) (SnapshotDelta, error) {
+	return av.recovCtx(context.Background(), p, n, true)
+}
+
+// recovCtx recovers the reduced state of [p, n], along with the commands logged after
+// it if 'delta' is set.
+func (av *AVLTreeHT) recovCtx(ctx context.Context, p, n uint64, delta bool) (SnapshotDelta, error) {
+	p, n, err := av.checkInterval(p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return SnapshotDelta{}, err
 	}
+	av.hookRecovery(p, n)
 	av.mu.RLock()
 	defer av.mu.RUnlock()
 
-	if err := av.mayExecuteLazyReduce(p, n); err != nil {
-		return nil, err
+	if err := av.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return SnapshotDelta{}, err
+	}
+	cmds, err := av.retrieveIntervalLogCtx(ctx, p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
 	}
-	return av.retrieveLog()
+	res := av.coverage(p, n, true)
+	res.Cmds = cmds
+	if !delta {
+		return SnapshotDelta{Snapshot: res}, nil
+	}
+	return av.snapshotAndDelta(res, p, n, av.deltaLog), nil
 }
 
 // RecovBytes returns an already serialized log, parsed from persistent storage
@@ -154,55 +294,190 @@ func (av *AVLTreeHT) Recov(p, n uint64) ([]pb.Command, error) {
 // interpretation from the byte stream follows a simple slicing protocol, where
 // the size of each command is binary encoded before the raw pbuff.
 func (av *AVLTreeHT) RecovBytes(p, n uint64) ([]byte, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	res, err := av.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log.
+func (av *AVLTreeHT) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
+	p, n, err := av.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	av.hookRecovery(p, n)
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+
+	if err := av.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return RecovResult{}, err
 	}
+	raw, err := av.retrieveRawLog(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := av.coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (av *AVLTreeHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := av.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	av.hookRecovery(p, n)
 	av.mu.RLock()
 	defer av.mu.RUnlock()
 
-	if err := av.mayExecuteLazyReduce(p, n); err != nil {
+	if err := av.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return av.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (av *AVLTreeHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := av.Recov(p, n)
+	if err != nil {
 		return nil, err
 	}
-	return av.retrieveRawLog(p, n)
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (av *AVLTreeHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := av.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (av *AVLTreeHT) RecovSince(id uint64) ([]pb.Command, error) {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	if av.length() == 0 || id >= av.last {
+		return []pb.Command{}, nil
+	}
+
+	// the structure retains every logged state, even after reduce, so only the
+	// interval after 'id' is reduced, starting from the lower bound of 'id' + 1
+	// since 'id' may not be present on the structure
+	return av.applyRangeDeletes(IterDFSAVLTreeHT(av, id+1, av.last), id+1, av.last), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (av *AVLTreeHT) Snapshot() (*Snapshot, error) {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	if av.length() == 0 {
+		return newSnapshot(av.last, nil), nil
+	}
+	cmds := av.applyRangeDeletes(IterDFSAVLTreeHT(av, av.first, av.last), av.first, av.last)
+	return newSnapshot(av.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (av *AVLTreeHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := av.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written, or if its state was discarded by a later range
+// delete or by a tree Reset.
+func (av *AVLTreeHT) Get(key string) (pb.Command, bool) {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.getState(*av.aux, key)
+}
+
+// Keys returns every key with a state retained on the tree, in ascending order.
+func (av *AVLTreeHT) Keys() []string {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.stateKeys(*av.aux)
 }
 
 // ReduceLog applies the configured reduce algorithm and updates the current log state.
 // Must only be called within mutual exclusion scope.
 func (av *AVLTreeHT) ReduceLog(p, n uint64) error {
-	cmds, err := ApplyReduceAlgo(av, av.config.Alg, p, n)
+	return av.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (av *AVLTreeHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := av.hookReduceStart(p, n)
+	cmds, err := av.applyReduce(av, p, n)
+	av.hookReduceDone(p, n, start, len(cmds), err)
 	if err != nil {
 		return err
 	}
-	return av.updateLogState(cmds, p, n, false)
+	if err := av.updateLogStateCtx(ctx, cmds, p, n, 0); err != nil {
+		return err
+	}
+	if av.config.TruncateAfterReduce {
+		av.truncate(n)
+	}
+	av.measurePersisted(av.takeMeasure())
+	return nil
+}
+
+// retainedStates returns every state logged within [p, n], reduced by registered
+// reducers. Must only be called within mutual exclusion scope.
+func (av *AVLTreeHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return av.aux.statesWithin(p, n), nil
 }
 
 // mayTriggerReduce possibly triggers the reduce algorithm based on config params
 // (e.g. interval period reached). Must only be called within mutual exclusion scope.
-func (av *AVLTreeHT) mayTriggerReduce() error {
-	if av.config.Tick != Interval {
+func (av *AVLTreeHT) mayTriggerReduce(ctx context.Context) error {
+	if av.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		av.count++
 		return nil
 	}
-	av.count++
-	if av.count >= av.config.Period {
-		av.count = 0
-		return av.ReduceLog(av.first, av.last)
+	if !av.config.Tick.isPeriodic() {
+		return nil
+	}
+	if av.reachedReducePeriod(len(*av.aux)) {
+		return av.reduceLogCtx(ctx, av.first, av.last)
 	}
 	return nil
 }
 
 // mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
 // 'config.Period' wasnt reached yet.
-func (av *AVLTreeHT) mayExecuteLazyReduce(p, n uint64) error {
+func (av *AVLTreeHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
 	if av.config.Tick == Delayed {
-		err := av.ReduceLog(p, n)
+		err := av.reduceLogCtx(ctx, p, n)
 		if err != nil {
 			return err
 		}
 
-	} else if av.config.Tick == Interval && !av.firstReduceExists() {
+	} else if av.config.Tick.isScheduled() && !av.firstReduceExists() {
 		// must reduce the entire structure, just the desired interval would
-		// be incoherent with the Interval config
-		err := av.ReduceLog(av.first, av.last)
+		// be incoherent with scheduled configs
+		err := av.reduceLogCtx(ctx, av.first, av.last)
 		if err != nil {
 			return err
 		}
@@ -210,6 +485,161 @@ func (av *AVLTreeHT) mayExecuteLazyReduce(p, n uint64) error {
 	return nil
 }
 
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (av *AVLTreeHT) reduceOnTick() {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	if av.count == 0 {
+		return
+	}
+
+	// on failure, the counter is retained to retry on the next tick
+	err := av.config.Retry.do(context.Background(), func() error {
+		return av.ReduceLog(av.first, av.last)
+	})
+	if err != nil {
+		av.reportReduceErr(err)
+		return
+	}
+	av.count = 0
+}
+
+// Reset discards every command logged on the tree, recycling the memory of its nodes
+// on subsequent insertions instead of allocating new ones. The last reduced log state
+// is retained. Intended to be called once the tree state is reduced, where no prior
+// reduced log or key state is referenced anymore.
+func (av *AVLTreeHT) Reset() {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	ht := make(stateTable, len(*av.aux))
+	av.aux = &ht
+	av.root = nil
+	av.len = 0
+	av.resetFirst(0, false)
+	av.last = 0
+	av.count = 0
+	av.tombs = nil
+	av.mem.reset()
+}
+
+// RetireKey removes every state logged for 'key' from the tree, rebalancing it, so
+// nodes of deleted or expired keys are not retained indefinitely. Later reduces and
+// recoveries disregard the retired states, only retained by already persisted log
+// states. Returns the number of removed nodes.
+func (av *AVLTreeHT) RetireKey(key string) int {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	l, ok := (*av.aux)[key]
+	if !ok {
+		return 0
+	}
+
+	var n int
+	for nd := l.first; nd != nil; nd = nd.next {
+		var found bool
+		av.root, found = av.recurRemove(av.root, nd.val.(*State).ind)
+		if found {
+			av.len--
+			n++
+		}
+	}
+	delete(*av.aux, key)
+	av.updateFirstIndex()
+	return n
+}
+
+// Prune removes every node indexed before 'p' from the tree, along with their states
+// and range deletes, rebuilding a balanced tree from the retained nodes on O(n)
+// operations. Intended to be called once the log up to 'p' is reduced and persisted,
+// bounding the size of long-running trees. Recoveries of intervals starting before 'p',
+// and Get calls over keys only updated before it, disregard the removed states. Returns
+// the number of removed nodes.
+func (av *AVLTreeHT) Prune(p uint64) int {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+	return av.prune(p)
+}
+
+// truncate removes every node covered by the persisted interval ending at 'n', on
+// TruncateAfterReduce config. Must only be called within mutual exclusion scope.
+func (av *AVLTreeHT) truncate(n uint64) {
+	av.prune(n + 1)
+}
+
+// prune is analogous to 'Prune', but must only be called within mutual exclusion
+// scope.
+func (av *AVLTreeHT) prune(p uint64) int {
+	kept := make([]*avlTreeEntry, 0, av.len)
+	inorderAVL(av.root, func(e *avlTreeEntry) {
+		if e.ind >= p {
+			kept = append(kept, e)
+		}
+	})
+	removed := int(av.len) - len(kept)
+	if removed == 0 {
+		return 0
+	}
+
+	av.root = buildBalancedAVL(kept)
+	av.len = uint64(len(kept))
+
+	for key, l := range *av.aux {
+		l.removeIf(func(v interface{}) bool {
+			return v.(*State).ind < p
+		})
+		if l.len == 0 {
+			delete(*av.aux, key)
+		}
+	}
+
+	av.tombs = av.tombs.after(p - 1)
+
+	av.updateFirstIndex()
+	return removed
+}
+
+// AllocStats returns node allocation statistics of the tree.
+func (av *AVLTreeHT) AllocStats() AllocStats {
+	av.mu.RLock()
+	defer av.mu.RUnlock()
+	return av.mem.stats
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once any in-flight reduce is finished.
+func (av *AVLTreeHT) SetConfig(cfg *LogConfig) error {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+	return av.reconfigure(av.ctx, cfg, &av.tick, av.reduceOnTick)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (av *AVLTreeHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+	cfg := av.config.clone()
+	fn(cfg)
+	return av.reconfigure(av.ctx, cfg, &av.tick, av.reduceOnTick)
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, and flushes
+// any pending group commit.
+func (av *AVLTreeHT) Shutdown() {
+	if av.canc != nil {
+		av.canc()
+	}
+	if av.gc != nil {
+		av.gc.close()
+	}
+	av.closeJournal()
+	av.closeMeasure()
+}
+
 // insert recursively inserts a node on the tree structure on O(lg n) operations,
 // where 'n' is the number of elements in the tree.
 func (av *AVLTreeHT) insert(node *avlTreeEntry) bool {
@@ -217,7 +647,15 @@ func (av *AVLTreeHT) insert(node *avlTreeEntry) bool {
 	if av.root == nil {
 		av.root = node
 		av.len++
-		av.first = node.ind
+		av.attributeFirst(node.ind)
+		return true
+	}
+
+	if av.config.IterativeInsert {
+		if !av.iterInsert(node) {
+			return false
+		}
+		av.len++
 		return true
 	}
 
@@ -276,29 +714,84 @@ func (av *AVLTreeHT) recurInsert(root, node *avlTreeEntry) *avlTreeEntry {
 		return nil
 	}
 
+	return av.rebalance(root, node.ind)
+}
+
+// iterInsert is an iterative procedure for insert operation, descending the tree while
+// recording visited nodes on an explicit parent stack, later unwound to update heights
+// and rebalance. Unwinding stops once a subtree retains its prior height, since its
+// ancestors are unaffected. Avoids recursion overhead on deep trees.
+func (av *AVLTreeHT) iterInsert(node *avlTreeEntry) bool {
+	path := av.path[:0]
+	for nd := av.root; nd != nil; {
+		path = append(path, nd)
+		if node.ind < nd.ind {
+			nd = nd.left
+
+		} else if node.ind > nd.ind {
+			nd = nd.right
+
+		} else {
+			// Equal keys are not allowed in BST
+			av.path = path
+			return false
+		}
+	}
+	av.path = path
+
+	parent := path[len(path)-1]
+	if node.ind < parent.ind {
+		parent.left = node
+	} else {
+		parent.right = node
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		root := path[i]
+		prev := root.height
+		rt := av.rebalance(root, node.ind)
+
+		if i == 0 {
+			av.root = rt
+		} else if par := path[i-1]; par.left == root {
+			par.left = rt
+		} else {
+			par.right = rt
+		}
+
+		if rt.height == prev {
+			break
+		}
+	}
+	return true
+}
+
+// rebalance updates the height of 'root' after the insertion of index 'ind' on one
+// of its subtrees, applying the necessary rotations. Returns the new subtree root.
+func (av *AVLTreeHT) rebalance(root *avlTreeEntry, ind uint64) *avlTreeEntry {
 	root.height = 1 + max(getHeight(root.left), getHeight(root.right))
 
 	// If this node becomes unbalanced, then there are 4 cases
 	balance := getBalanceFactor(root)
 
 	// Left Left Case
-	if balance > 1 && node.ind < root.left.ind {
+	if balance > 1 && ind < root.left.ind {
 		return av.rightRotate(root)
 	}
 
 	// Right Right Case
-	if balance < -1 && node.ind > root.right.ind {
+	if balance < -1 && ind > root.right.ind {
 		return av.leftRotate(root)
 	}
 
 	// Left Right Case
-	if balance > 1 && node.ind > root.left.ind {
+	if balance > 1 && ind > root.left.ind {
 		root.left = av.leftRotate(root.left)
 		return av.rightRotate(root)
 	}
 
 	// Right Left Case
-	if balance < -1 && node.ind < root.right.ind {
+	if balance < -1 && ind < root.right.ind {
 		root.right = av.rightRotate(root.right)
 		return av.leftRotate(root)
 	}
@@ -348,3 +841,100 @@ func stringRecurBFS(queue []*avlTreeEntry, res []string) []string {
 	}
 	return stringRecurBFS(queue[1:], res)
 }
+
+// recurRemove is a recursive procedure removing the node of index 'ind' from the subtree
+// of 'root', applying the necessary rotations. Returns the new subtree root, and false
+// if no node was found.
+func (av *AVLTreeHT) recurRemove(root *avlTreeEntry, ind uint64) (*avlTreeEntry, bool) {
+	if root == nil {
+		return nil, false
+	}
+
+	var found bool
+	if ind < root.ind {
+		root.left, found = av.recurRemove(root.left, ind)
+
+	} else if ind > root.ind {
+		root.right, found = av.recurRemove(root.right, ind)
+
+	} else {
+		found = true
+		if root.left == nil {
+			return root.right, true
+		} else if root.right == nil {
+			return root.left, true
+		}
+
+		// nodes with two children are replaced by their in-order successor, whose
+		// content is moved since no other reference is kept to tree entries
+		succ := root.right
+		for succ.left != nil {
+			succ = succ.left
+		}
+		root.ind, root.key, root.ptr = succ.ind, succ.key, succ.ptr
+		root.right, _ = av.recurRemove(root.right, succ.ind)
+	}
+
+	if !found {
+		return root, false
+	}
+	return av.rebalanceRemoval(root), true
+}
+
+// rebalanceRemoval updates the height of 'root' after a removal on one of its subtrees,
+// applying the necessary rotations. Unlike insertions, the rotation is chosen by the
+// balance of the taller child. Returns the new subtree root.
+func (av *AVLTreeHT) rebalanceRemoval(root *avlTreeEntry) *avlTreeEntry {
+	root.height = 1 + max(getHeight(root.left), getHeight(root.right))
+	balance := getBalanceFactor(root)
+
+	if balance > 1 {
+		// Left Right Case
+		if getBalanceFactor(root.left) < 0 {
+			root.left = av.leftRotate(root.left)
+		}
+		return av.rightRotate(root)
+	}
+
+	if balance < -1 {
+		// Right Left Case
+		if getBalanceFactor(root.right) > 0 {
+			root.right = av.rightRotate(root.right)
+		}
+		return av.leftRotate(root)
+	}
+	return root
+}
+
+// updateFirstIndex sets the first index as the smallest one retained on the tree, or
+// zero if empty.
+func (av *AVLTreeHT) updateFirstIndex() {
+	av.resetFirst(0, false)
+	for nd := av.root; nd != nil; nd = nd.left {
+		av.resetFirst(nd.ind, true)
+	}
+}
+
+// inorderAVL visits every node under 'root' on ascending index order.
+func inorderAVL(root *avlTreeEntry, visit func(*avlTreeEntry)) {
+	if root == nil {
+		return
+	}
+	inorderAVL(root.left, visit)
+	visit(root)
+	inorderAVL(root.right, visit)
+}
+
+// buildBalancedAVL links the index-ordered 'ents' into a perfectly balanced tree,
+// returning its root.
+func buildBalancedAVL(ents []*avlTreeEntry) *avlTreeEntry {
+	if len(ents) == 0 {
+		return nil
+	}
+	mid := len(ents) / 2
+	root := ents[mid]
+	root.left = buildBalancedAVL(ents[:mid])
+	root.right = buildBalancedAVL(ents[mid+1:])
+	root.height = 1 + max(getHeight(root.left), getHeight(root.right))
+	return root
+}
@@ -0,0 +1,247 @@
+// Package beelogtest provides utilities for testing beelog structures and reduce
+// algorithms, such as differential testing across structures.
+package beelogtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// DiffConfig configures a differential test. Zero values are replaced by defaults.
+type DiffConfig struct {
+	// size of the random command stream, its number of distinct keys, and the
+	// percentage of writes (i.e. SETs) among its commands
+	Cmds         uint64
+	Keys         int
+	WritePercent int
+
+	// seed of the random command stream, reproducing failures of prior runs
+	Seed int64
+
+	// reduce intervals exercised, every one if none is provided
+	Ticks []bl.ReduceInterval
+
+	// reduce period on Interval ticks, and bounds of Adaptive ticks
+	Period uint32
+
+	// reduce period on TimeInterval ticks
+	Duration time.Duration
+}
+
+func (dc *DiffConfig) setDefaults() {
+	if dc.Cmds == 0 {
+		dc.Cmds = 2000
+	}
+	if dc.Keys == 0 {
+		dc.Keys = 100
+	}
+	if dc.WritePercent == 0 {
+		dc.WritePercent = 50
+	}
+	if len(dc.Ticks) == 0 {
+		dc.Ticks = []bl.ReduceInterval{bl.Immediately, bl.Delayed, bl.Interval, bl.Adaptive, bl.TimeInterval}
+	}
+	if dc.Period == 0 {
+		dc.Period = 100
+	}
+	if dc.Duration == 0 {
+		dc.Duration = 10 * time.Millisecond
+	}
+}
+
+// RandomLog returns a stream of 'n' commands over 'keys' distinct keys, where
+// 'wrt' percent are SETs and the remaining are GETs, deterministically generated
+// from 'seed'.
+func RandomLog(seed int64, n uint64, keys, wrt int) []pb.Command {
+	r := rand.New(rand.NewSource(seed))
+	log := make([]pb.Command, 0, n)
+	for i := uint64(0); i < n; i++ {
+		cmd := pb.Command{Id: i, Key: strconv.Itoa(r.Intn(keys)), Op: pb.Command_GET}
+		if r.Intn(100) < wrt {
+			cmd.Op = pb.Command_SET
+			cmd.Value = strconv.Itoa(r.Int())
+		}
+		log = append(log, cmd)
+	}
+	return log
+}
+
+// namedStructure is a structure under test, and a function awaiting its pending
+// reduces.
+type namedStructure struct {
+	name  string
+	st    bl.Structure
+	sync  func()
+	close func()
+}
+
+// Differential feeds an identical random command stream to ListHT, ArrayHT, AVLTreeHT,
+// CircBuffHT and ConcTable structures, under every configured reduce interval, and
+// asserts that the recovered log of each structure is equivalent to the reference
+// execution of the stream over the interval it covers, as recorded on its header,
+// reporting every divergent key. Structures covering the same interval are thus
+// pairwise equivalent. Since Interval, Adaptive and TimeInterval ticks recover the
+// latest reduced state, which may not cover the entire stream, only Immediately and
+// Delayed ticks must recover every write. Structures persist their states under
+// 'dir', and are recovered once every pending reduce is completed.
+func Differential(t testing.TB, dir string, cfg DiffConfig) {
+	t.Helper()
+	cfg.setDefaults()
+	log := RandomLog(cfg.Seed, cfg.Cmds, cfg.Keys, cfg.WritePercent)
+
+	for _, tick := range cfg.Ticks {
+		sts, err := newStructures(filepath.Join(dir, strconv.Itoa(int(tick))), tick, &cfg)
+		if err != nil {
+			t.Fatalf("tick %d: failed creating structures, err: '%s'", tick, err.Error())
+		}
+
+		for _, s := range sts {
+			for _, cmd := range log {
+				if err := s.st.Log(cmd); err != nil {
+					t.Fatalf("tick %d: %s failed logging command %d, err: '%s'", tick, s.name, cmd.Id, err.Error())
+				}
+			}
+		}
+
+		for _, s := range sts {
+			s.sync()
+			if err := verifyRecovered(s.st, log, tick); err != nil {
+				t.Errorf("tick %d, seed %d: %s %s", tick, cfg.Seed, s.name, err.Error())
+			}
+			s.close()
+		}
+	}
+}
+
+// verifyRecovered checks the recovered log of 'st' against the reference execution of
+// 'log', over the interval recorded on its header.
+func verifyRecovered(st bl.Structure, log []pb.Command, tick bl.ReduceInterval) error {
+	raw, err := st.RecovBytes(0, uint64(len(log)-1))
+	if err != nil {
+		return fmt.Errorf("failed recovering, err: '%s'", err.Error())
+	}
+	_, hdr, err := bl.ReadLogHeader(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("failed reading header, err: '%s'", err.Error())
+	}
+	rec, err := bl.UnmarshalLogFromBytes(raw)
+	if err != nil {
+		return fmt.Errorf("failed decoding, err: '%s'", err.Error())
+	}
+
+	if hdr.First > hdr.Last || hdr.Last >= uint64(len(log)) {
+		return fmt.Errorf("recovered an invalid interval [%d, %d]", hdr.First, hdr.Last)
+	}
+	if tick == bl.Immediately || tick == bl.Delayed {
+		first, last, ok := writesInterval(log)
+		if ok && (hdr.First > first || hdr.Last < last) {
+			return fmt.Errorf("recovered interval [%d, %d], expected every write within [%d, %d]", hdr.First, hdr.Last, first, last)
+		}
+	}
+
+	res := bl.VerifyReducedLog(log[hdr.First:hdr.Last+1], rec)
+	if !res.Equivalent {
+		return fmt.Errorf("diverged on %d keys within [%d, %d], first: %s", len(res.Divergences), hdr.First, hdr.Last, divergenceString(res.Divergences[0]))
+	}
+	return nil
+}
+
+// writesInterval returns the indexes of the first and last writes on 'log', if any.
+func writesInterval(log []pb.Command) (uint64, uint64, bool) {
+	var first, last uint64
+	var ok bool
+	for _, c := range log {
+		if c.Op == pb.Command_GET {
+			continue
+		}
+		if !ok {
+			first, ok = c.Id, true
+		}
+		last = c.Id
+	}
+	return first, last, ok
+}
+
+func divergenceString(d bl.Divergence) string {
+	state := func(c *pb.Command) string {
+		if c == nil {
+			return "absent"
+		}
+		return fmt.Sprintf("'%s' (index %d)", c.ValueString(), c.Id)
+	}
+	return fmt.Sprintf("key '%s', expected %s, got %s", d.Key, state(d.Original), state(d.Reduced))
+}
+
+// newStructures returns every structure under test configured with 'tick'.
+func newStructures(dir string, tick bl.ReduceInterval, cfg *DiffConfig) ([]namedStructure, error) {
+	config := func(alg bl.Reducer) *bl.LogConfig {
+		return &bl.LogConfig{
+			Fname:     filepath.Join(dir, strconv.Itoa(int(alg)), "logstate.log"),
+			Tick:      tick,
+			Alg:       alg,
+			Period:    cfg.Period,
+			MinPeriod: cfg.Period,
+			MaxPeriod: 4 * cfg.Period,
+			Duration:  cfg.Duration,
+		}
+	}
+	for _, alg := range []bl.Reducer{bl.GreedyLt, bl.GreedyArray, bl.IterDFSAvl, bl.IterCircBuff, bl.IterConcTable} {
+		if err := os.MkdirAll(filepath.Join(dir, strconv.Itoa(int(alg))), 0755); err != nil {
+			return nil, err
+		}
+	}
+	none := func() {}
+	elapse := none
+	if tick == bl.TimeInterval {
+		elapse = func() { time.Sleep(4 * cfg.Duration) }
+	}
+
+	list, err := bl.NewListHTWithConfig(config(bl.GreedyLt))
+	if err != nil {
+		return nil, err
+	}
+	array, err := bl.NewArrayHTWithConfig(config(bl.GreedyArray))
+	if err != nil {
+		return nil, err
+	}
+	avl, err := bl.NewAVLTreeHTWithConfig(config(bl.IterDFSAvl))
+	if err != nil {
+		return nil, err
+	}
+	cb, err := bl.NewCircBuffHTWithConfig(context.Background(), config(bl.IterCircBuff), int(cfg.Cmds))
+	if err != nil {
+		return nil, err
+	}
+	ct, err := bl.NewConcTableWithConfig(context.Background(), 2, config(bl.IterConcTable))
+	if err != nil {
+		return nil, err
+	}
+
+	return []namedStructure{
+		{"ListHT", list, elapse, list.Shutdown},
+		{"ArrayHT", array, elapse, array.Shutdown},
+		{"AVLTreeHT", avl, elapse, avl.Shutdown},
+		{"CircBuffHT", cb, func() {
+			elapse()
+			for cb.Pending() > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}, cb.Shutdown},
+		{"ConcTable", ct, func() {
+			elapse()
+			for ct.Pending() > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}, ct.Shutdown},
+	}, nil
+}
@@ -0,0 +1,11 @@
+package beelogtest
+
+import (
+	"testing"
+)
+
+func TestDifferential(t *testing.T) {
+	for seed := int64(0); seed < 3; seed++ {
+		Differential(t, t.TempDir(), DiffConfig{Seed: seed})
+	}
+}
@@ -0,0 +1,339 @@
+// Package bench runs configurable throughput workloads over beelog structures,
+// reporting machine-readable results. It backs the beelog-bench command, and can be
+// embedded by users comparing structures and reduce intervals on their own hardware.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+	"github.com/Lz-Gustavo/beelog/workload"
+)
+
+// Workload configures a single benchmark run. Zero values are replaced by defaults.
+type Workload struct {
+	// size of the command stream, its number of distinct keys, and the percentage
+	// of writes (i.e. SETs) among its commands
+	Cmds         uint64
+	Keys         int
+	WritePercent int
+
+	// distribution of selected keys, uniform if none is provided
+	Dist workload.Distribution
+
+	// YCSB core workload (i.e. "a" to "f") generating the command stream, overriding
+	// both 'WritePercent' and 'Dist' if provided
+	Mix string
+
+	// seed of the command stream, reproducing the stream of prior runs
+	Seed int64
+
+	// benchmarked structure, and its reduce interval and period
+	Structure bl.StructureKind
+	Tick      bl.ReduceInterval
+	Period    uint32
+
+	// reduce period on TimeInterval ticks
+	Duration time.Duration
+
+	// persists reduced states under 'Dir', or a temporary directory removed after the
+	// run if none is provided. States are retained in memory if 'Inmem' is set
+	Inmem bool
+	Dir   string
+
+	// number of views on ConcTable structures
+	ConcLevel int
+}
+
+func (w *Workload) setDefaults() {
+	if w.Cmds == 0 {
+		w.Cmds = 100000
+	}
+	if w.Keys == 0 {
+		w.Keys = 1000
+	}
+	if w.WritePercent == 0 {
+		w.WritePercent = 50
+	}
+	if w.Dist == "" {
+		w.Dist = workload.Uniform
+	}
+	if w.Structure == "" {
+		w.Structure = bl.ListKind
+	}
+	if w.Period == 0 {
+		w.Period = 1000
+	}
+	if w.Duration == 0 {
+		w.Duration = 10 * time.Millisecond
+	}
+	if w.ConcLevel == 0 {
+		w.ConcLevel = 2
+	}
+}
+
+func (w *Workload) validate() error {
+	if w.WritePercent < 0 || w.WritePercent > 100 {
+		return errors.New("write percentage must be in the [0, 100] range")
+	}
+	if _, err := w.generator(); err != nil {
+		return err
+	}
+	if _, ok := reducers[w.Structure]; !ok {
+		return fmt.Errorf("unsupported structure '%s'", w.Structure)
+	}
+	return nil
+}
+
+// reducers maps each benchmarked structure into its reduce algorithm.
+var reducers = map[bl.StructureKind]bl.Reducer{
+	bl.ListKind:      bl.GreedyLt,
+	bl.ArrayKind:     bl.GreedyArray,
+	bl.AVLKind:       bl.IterDFSAvl,
+	bl.CircBuffKind:  bl.IterCircBuff,
+	bl.ConcTableKind: bl.IterConcTable,
+	bl.SkipListKind:  bl.GreedySkip,
+	bl.RadixKind:     bl.IterRadix,
+}
+
+// Structures returns every structure supported by Run.
+func Structures() []bl.StructureKind {
+	return []bl.StructureKind{bl.ListKind, bl.ArrayKind, bl.AVLKind, bl.CircBuffKind,
+		bl.ConcTableKind, bl.SkipListKind, bl.RadixKind}
+}
+
+// ParseTick returns the reduce interval named by 's' (e.g. "interval"), ignoring case.
+func ParseTick(s string) (bl.ReduceInterval, error) {
+	switch strings.ToLower(s) {
+	case "immediately":
+		return bl.Immediately, nil
+	case "delayed":
+		return bl.Delayed, nil
+	case "interval":
+		return bl.Interval, nil
+	case "adaptive":
+		return bl.Adaptive, nil
+	case "timeinterval":
+		return bl.TimeInterval, nil
+	}
+	return 0, fmt.Errorf("unknown reduce interval '%s'", s)
+}
+
+// tickName returns the name of 't' recognized by ParseTick.
+func tickName(t bl.ReduceInterval) string {
+	switch t {
+	case bl.Immediately:
+		return "immediately"
+	case bl.Delayed:
+		return "delayed"
+	case bl.Interval:
+		return "interval"
+	case bl.Adaptive:
+		return "adaptive"
+	case bl.TimeInterval:
+		return "timeinterval"
+	}
+	return strconv.Itoa(int(t))
+}
+
+// Commands returns the command stream of 'w', deterministically generated from its
+// seed.
+func Commands(w Workload) ([]pb.Command, error) {
+	w.setDefaults()
+	g, err := w.generator()
+	if err != nil {
+		return nil, err
+	}
+	return g.Commands(w.Cmds), nil
+}
+
+// generator returns the generator of the command stream of 'w'.
+func (w *Workload) generator() (*workload.Generator, error) {
+	cfg := workload.Config{
+		Keys:   w.Keys,
+		Dist:   w.Dist,
+		Read:   float64(100-w.WritePercent) / 100,
+		Update: float64(w.WritePercent) / 100,
+	}
+	if w.Mix != "" {
+		var err error
+		if cfg, err = workload.YCSB(w.Mix, w.Keys); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Seed = w.Seed
+	return workload.New(cfg)
+}
+
+// Result is the outcome of a single benchmark run.
+type Result struct {
+	Structure    string `json:"structure"`
+	Tick         string `json:"tick"`
+	Cmds         uint64 `json:"cmds"`
+	Keys         int    `json:"keys"`
+	WritePercent int    `json:"write_percent"`
+	Dist         string `json:"dist"`
+	Mix          string `json:"mix,omitempty"`
+
+	// elapsed time logging every command, including pending reduces, and the
+	// resulting throughput in commands per second
+	LogNanos   int64   `json:"log_ns"`
+	Throughput float64 `json:"cmds_per_sec"`
+
+	// elapsed time recovering the entire stream, the number of recovered commands,
+	// and the size of their serialized log
+	RecovNanos int64 `json:"recov_ns"`
+	Recovered  int   `json:"recovered"`
+	RecovBytes int   `json:"recov_bytes"`
+}
+
+// Run executes workload 'w', logging its entire command stream on a new structure
+// then recovering it.
+func Run(ctx context.Context, w Workload) (Result, error) {
+	w.setDefaults()
+	if err := w.validate(); err != nil {
+		return Result{}, err
+	}
+	if w.Mix != "" {
+		// reported as the distribution of the YCSB workload, whose writes are mixed
+		// with inserts and read-modify-writes
+		cfg, _ := workload.YCSB(w.Mix, w.Keys)
+		w.Dist, w.WritePercent = cfg.Dist, 0
+	}
+	log, err := Commands(w)
+	if err != nil {
+		return Result{}, err
+	}
+
+	dir := w.Dir
+	if !w.Inmem && dir == "" {
+		tmp, err := ioutil.TempDir("", "beelog-bench")
+		if err != nil {
+			return Result{}, err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	st, pending, shutdown, err := newStructure(ctx, &w, dir)
+	if err != nil {
+		return Result{}, err
+	}
+	defer shutdown()
+
+	start := time.Now()
+	for _, cmd := range log {
+		if err := st.Log(cmd); err != nil {
+			return Result{}, fmt.Errorf("failed logging command %d, err: '%s'", cmd.Id, err.Error())
+		}
+	}
+	for pending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	logged := time.Since(start)
+
+	start = time.Now()
+	raw, err := st.RecovBytes(0, w.Cmds-1)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed recovering, err: '%s'", err.Error())
+	}
+	cmds, err := bl.UnmarshalLogFromBytes(raw)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed decoding recovered log, err: '%s'", err.Error())
+	}
+	recov := time.Since(start)
+
+	return Result{
+		Structure:    string(w.Structure),
+		Tick:         tickName(w.Tick),
+		Cmds:         w.Cmds,
+		Keys:         w.Keys,
+		WritePercent: w.WritePercent,
+		Dist:         string(w.Dist),
+		Mix:          w.Mix,
+		LogNanos:     int64(logged),
+		Throughput:   float64(w.Cmds) / logged.Seconds(),
+		RecovNanos:   int64(recov),
+		Recovered:    len(cmds),
+		RecovBytes:   len(raw),
+	}, nil
+}
+
+// newStructure returns the structure configured by 'w', persisting states under 'dir',
+// a function informing its pending reduces, and another releasing it.
+func newStructure(ctx context.Context, w *Workload, dir string) (bl.Structure, func() int, func(), error) {
+	cfg := &bl.LogConfig{
+		Alg:       reducers[w.Structure],
+		Tick:      w.Tick,
+		Period:    w.Period,
+		MinPeriod: w.Period,
+		MaxPeriod: 4 * w.Period,
+		Duration:  w.Duration,
+		Inmem:     w.Inmem,
+	}
+	if !w.Inmem {
+		cfg.Fname = filepath.Join(dir, "logstate.log")
+	}
+	none := func() int { return 0 }
+
+	switch w.Structure {
+	case bl.ListKind:
+		st, err := bl.NewListHTWithConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, none, st.Shutdown, nil
+
+	case bl.ArrayKind:
+		st, err := bl.NewArrayHTWithConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, none, st.Shutdown, nil
+
+	case bl.AVLKind:
+		st, err := bl.NewAVLTreeHTWithConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, none, st.Shutdown, nil
+
+	case bl.CircBuffKind:
+		st, err := bl.NewCircBuffHTWithConfig(ctx, cfg, int(w.Cmds))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, st.Pending, st.Shutdown, nil
+
+	case bl.ConcTableKind:
+		st, err := bl.NewConcTableWithConfig(ctx, w.ConcLevel, cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, st.Pending, st.Shutdown, nil
+
+	case bl.SkipListKind:
+		st, err := bl.NewSkipListHTWithConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, none, st.Shutdown, nil
+
+	case bl.RadixKind:
+		st, err := bl.NewRadixHTWithConfig(cfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return st, none, st.Shutdown, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported structure '%s'", w.Structure)
+}
@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/workload"
+)
+
+func TestRun(t *testing.T) {
+	rs := make([]Result, 0)
+	for _, kind := range Structures() {
+		w := Workload{
+			Cmds:      2000,
+			Keys:      100,
+			Dist:      workload.Zipfian,
+			Structure: kind,
+			Tick:      bl.Delayed,
+			Dir:       t.TempDir(),
+		}
+		r, err := Run(context.Background(), w)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err.Error())
+		}
+		if r.Recovered == 0 || r.Recovered > w.Keys || r.Throughput <= 0 {
+			t.Fatalf("%s: unexpected result %+v", kind, r)
+		}
+		rs = append(rs, r)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteCSV(buf, rs); err != nil {
+		t.Fatal(err.Error())
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(rs)+1 {
+		t.Fatalf("expected %d csv records, got %d", len(rs)+1, lines)
+	}
+
+	if _, err := Run(context.Background(), Workload{Structure: bl.LSMKind}); err == nil {
+		t.Fatal("expected an error on unsupported structures")
+	}
+}
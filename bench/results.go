@@ -0,0 +1,53 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// csvHeader names the columns written by WriteCSV, following the JSON field names
+// of Result.
+var csvHeader = []string{"structure", "tick", "cmds", "keys", "write_percent", "dist", "mix",
+	"log_ns", "cmds_per_sec", "recov_ns", "recovered", "recov_bytes"}
+
+// WriteJSONL writes each result of 'rs' as a JSON object per line into 'w'.
+func WriteJSONL(w io.Writer, rs []Result) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rs {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes every result of 'rs' into 'w' as CSV records, preceded by a header.
+func WriteCSV(w io.Writer, rs []Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		rec := []string{
+			r.Structure,
+			r.Tick,
+			strconv.FormatUint(r.Cmds, 10),
+			strconv.Itoa(r.Keys),
+			strconv.Itoa(r.WritePercent),
+			r.Dist,
+			r.Mix,
+			strconv.FormatInt(r.LogNanos, 10),
+			strconv.FormatFloat(r.Throughput, 'f', 2, 64),
+			strconv.FormatInt(r.RecovNanos, 10),
+			strconv.Itoa(r.Recovered),
+			strconv.Itoa(r.RecovBytes),
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,150 @@
+package beelog
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// BoundsPolicy defines how recovery requests are validated against the interval of
+// indexes logged by a structure (see LoggedInterval).
+type BoundsPolicy int8
+
+const (
+	// BestEffortBounds accepts any requested interval, returning the states logged
+	// within it, if any.
+	BestEffortBounds BoundsPolicy = iota
+
+	// StrictBounds rejects requests not entirely within the logged interval with an
+	// *IntervalError.
+	StrictBounds
+
+	// ClampBounds trims requests to the logged interval, only rejecting those disjoint
+	// from it with an *IntervalError.
+	ClampBounds
+)
+
+// IndexInterval is an inclusive interval of consensus indexes.
+type IndexInterval struct {
+	First, Last uint64
+}
+
+// IntervalError is returned by recovery procedures on StrictBounds and ClampBounds
+// configs, once the requested interval is not covered by the logged one.
+type IntervalError struct {
+	Requested IndexInterval
+
+	// interval of indexes logged by the structure, undefined if Empty
+	Logged IndexInterval
+	Empty  bool
+}
+
+func (e *IntervalError) Error() string {
+	if e.Empty {
+		return fmt.Sprintf("invalid interval request [%d, %d], no index was logged", e.Requested.First, e.Requested.Last)
+	}
+	return fmt.Sprintf("invalid interval request [%d, %d], logged interval is [%d, %d]",
+		e.Requested.First, e.Requested.Last, e.Logged.First, e.Logged.Last)
+}
+
+// LoggedInterval returns the lowest and highest indexes logged by the structure since
+// its creation, including those restored on RestoreOnInit configs, and false if none
+// was logged. Every index within it is covered by recovery procedures, either from
+// memory or persisted states.
+func (ld *logData) LoggedInterval() (IndexInterval, bool) {
+	return ld.stats.loggedInterval()
+}
+
+// observeIndex widens the interval of logged indexes to include 'ind', recording any
+// hole opened by it, or filling the one it belonged to. Both bounds are stored
+// incremented, where zero informs that no index was logged.
+func (s *logStats) observeIndex(ind uint64) {
+	// the successor of the highest index extends the interval without opening holes
+	if high := atomic.LoadUint64(&s.highest); high != 0 && high == ind && atomic.CompareAndSwapUint64(&s.highest, high, ind+1) {
+		return
+	}
+	s.gaps.mu.Lock()
+	defer s.gaps.mu.Unlock()
+
+	widened := false
+	for {
+		low := atomic.LoadUint64(&s.lowest)
+		if low != 0 && low-1 <= ind {
+			break
+		}
+		if atomic.CompareAndSwapUint64(&s.lowest, low, ind+1) {
+			if low != 0 && ind+1 < low-1 {
+				s.gaps.add(ind+1, low-2)
+			}
+			widened = true
+			break
+		}
+	}
+	for {
+		high := atomic.LoadUint64(&s.highest)
+		if high > ind {
+			break
+		}
+		if atomic.CompareAndSwapUint64(&s.highest, high, ind+1) {
+			if high != 0 && high < ind {
+				s.gaps.add(high, ind-1)
+			}
+			widened = true
+			break
+		}
+	}
+	if !widened {
+		s.gaps.fill(ind, ind)
+	}
+}
+
+// observeInterval widens the interval of logged indexes to include [p, n], entirely
+// covered by a restored state.
+func (s *logStats) observeInterval(p, n uint64) {
+	s.observeIndex(p)
+	s.observeIndex(n)
+	s.gaps.mu.Lock()
+	defer s.gaps.mu.Unlock()
+	s.gaps.fill(p, n)
+}
+
+func (s *logStats) loggedInterval() (IndexInterval, bool) {
+	low, high := atomic.LoadUint64(&s.lowest), atomic.LoadUint64(&s.highest)
+	if low == 0 {
+		return IndexInterval{}, false
+	}
+	return IndexInterval{First: low - 1, Last: high - 1}, true
+}
+
+// checkInterval validates the requested [p, n] interval following config.RecovBounds,
+// returning the interval to be recovered.
+func (ld *logData) checkInterval(p, n uint64) (uint64, uint64, error) {
+	if n < p {
+		return p, n, errors.New("invalid interval request, 'n' must be >= 'p'")
+	}
+	if ld.config.RecovBounds == BestEffortBounds {
+		return p, n, nil
+	}
+	logged, ok := ld.LoggedInterval()
+	return boundInterval(ld.config.RecovBounds, IndexInterval{First: p, Last: n}, logged, ok)
+}
+
+// boundInterval applies the policy 'bp' on the requested interval 'req', given the
+// 'logged' one, if any.
+func boundInterval(bp BoundsPolicy, req, logged IndexInterval, ok bool) (uint64, uint64, error) {
+	if !ok {
+		return req.First, req.Last, &IntervalError{Requested: req, Empty: true}
+	}
+	outside := req.Last < logged.First || req.First > logged.Last
+	if bp == StrictBounds && (req.First < logged.First || req.Last > logged.Last) || outside {
+		return req.First, req.Last, &IntervalError{Requested: req, Logged: logged}
+	}
+
+	if req.First < logged.First {
+		req.First = logged.First
+	}
+	if req.Last > logged.Last {
+		req.Last = logged.Last
+	}
+	return req.First, req.Last, nil
+}
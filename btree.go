@@ -0,0 +1,1307 @@
+package beelog
+
+import (
+	"bufio"
+	"bytes"
+	clist "container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// btreePageSize is the size of each node page on BTreeHT structures.
+	btreePageSize = 4096
+
+	// btreeMaxKeyLen bounds the length of keys, ensuring that both halves of a split
+	// node always fit on a single page.
+	btreeMaxKeyLen = btreePageSize / 8
+
+	// defaultBTreeCachePages is the number of node pages retained in memory by the
+	// page cache of BTreeHT structures.
+	defaultBTreeCachePages = 1024
+
+	// btreeCheckpointCmds is the number of commands recorded on the WAL before the
+	// page cache is checkpointed.
+	btreeCheckpointCmds = 4096
+
+	btreeLeafNode     byte = 1
+	btreeInternalNode byte = 2
+)
+
+var btreeMagic = []byte("BEEBTREE")
+
+// btreeValue references the latest state of a key on the value file, serialized
+// following the same slicing protocol of logs.
+type btreeValue struct {
+	off  uint64
+	size uint32
+	cmds uint32 // number of commands on the state chain
+	ind  uint64 // index of the latest state
+}
+
+// btreeNode is the decoded content of a node page. Internal nodes have one more child
+// than keys, where 'childs[i]' stores keys lower than 'keys[i]'. Leaves are linked on
+// key order.
+type btreeNode struct {
+	id     uint64
+	leaf   bool
+	keys   []string
+	vals   []btreeValue // only on leaves
+	childs []uint64     // only on internal nodes
+	next   uint64       // only on leaves, zero on the last one
+	dirty  bool
+}
+
+func (nd *btreeNode) entrySize(i int) int {
+	if nd.leaf {
+		return 2 + len(nd.keys[i]) + 24
+	}
+	return 2 + len(nd.keys[i]) + 8
+}
+
+// size returns the length of the encoded node.
+func (nd *btreeNode) size() int {
+	sz := 11
+	for i := range nd.keys {
+		sz += nd.entrySize(i)
+	}
+	return sz
+}
+
+func (nd *btreeNode) encode() []byte {
+	page := make([]byte, btreePageSize)
+	page[0] = btreeInternalNode
+	if nd.leaf {
+		page[0] = btreeLeafNode
+	}
+	binary.BigEndian.PutUint16(page[1:], uint16(len(nd.keys)))
+
+	if nd.leaf {
+		binary.BigEndian.PutUint64(page[3:], nd.next)
+	} else {
+		binary.BigEndian.PutUint64(page[3:], nd.childs[0])
+	}
+
+	pos := 11
+	for i, k := range nd.keys {
+		binary.BigEndian.PutUint16(page[pos:], uint16(len(k)))
+		pos += 2 + copy(page[pos+2:], k)
+
+		if nd.leaf {
+			v := nd.vals[i]
+			binary.BigEndian.PutUint64(page[pos:], v.off)
+			binary.BigEndian.PutUint32(page[pos+8:], v.size)
+			binary.BigEndian.PutUint32(page[pos+12:], v.cmds)
+			binary.BigEndian.PutUint64(page[pos+16:], v.ind)
+			pos += 24
+
+		} else {
+			binary.BigEndian.PutUint64(page[pos:], nd.childs[i+1])
+			pos += 8
+		}
+	}
+	return page
+}
+
+func decodeBTreeNode(id uint64, page []byte) (*btreeNode, error) {
+	if page[0] != btreeLeafNode && page[0] != btreeInternalNode {
+		return nil, fmt.Errorf("corrupted b+tree page %d", id)
+	}
+
+	ln := int(binary.BigEndian.Uint16(page[1:]))
+	nd := &btreeNode{
+		id:   id,
+		leaf: page[0] == btreeLeafNode,
+		keys: make([]string, 0, ln),
+	}
+
+	if nd.leaf {
+		nd.next = binary.BigEndian.Uint64(page[3:])
+		nd.vals = make([]btreeValue, 0, ln)
+	} else {
+		nd.childs = make([]uint64, 1, ln+1)
+		nd.childs[0] = binary.BigEndian.Uint64(page[3:])
+	}
+
+	pos := 11
+	for i := 0; i < ln; i++ {
+		kl := int(binary.BigEndian.Uint16(page[pos:]))
+		nd.keys = append(nd.keys, string(page[pos+2:pos+2+kl]))
+		pos += 2 + kl
+
+		if nd.leaf {
+			nd.vals = append(nd.vals, btreeValue{
+				off:  binary.BigEndian.Uint64(page[pos:]),
+				size: binary.BigEndian.Uint32(page[pos+8:]),
+				cmds: binary.BigEndian.Uint32(page[pos+12:]),
+				ind:  binary.BigEndian.Uint64(page[pos+16:]),
+			})
+			pos += 24
+
+		} else {
+			nd.childs = append(nd.childs, binary.BigEndian.Uint64(page[pos:]))
+			pos += 8
+		}
+	}
+	return nd, nil
+}
+
+// btreeMeta is the content of the first page of a BTreeHT pages file, updated on
+// every checkpoint.
+type btreeMeta struct {
+	root, pages uint64
+	keys, cmds  uint64
+	valsEnd     uint64
+	first, last uint64
+	len         uint64
+}
+
+func (m *btreeMeta) encode() []byte {
+	page := make([]byte, btreePageSize)
+	copy(page, btreeMagic)
+
+	pos := len(btreeMagic)
+	for _, v := range []uint64{m.root, m.pages, m.keys, m.cmds, m.valsEnd, m.first, m.last, m.len} {
+		binary.BigEndian.PutUint64(page[pos:], v)
+		pos += 8
+	}
+	return page
+}
+
+func decodeBTreeMeta(page []byte) (btreeMeta, error) {
+	if !bytes.Equal(page[:len(btreeMagic)], btreeMagic) {
+		return btreeMeta{}, errors.New("invalid b+tree pages file, missing magic")
+	}
+
+	var vs [8]uint64
+	pos := len(btreeMagic)
+	for i := range vs {
+		vs[i] = binary.BigEndian.Uint64(page[pos:])
+		pos += 8
+	}
+	return btreeMeta{
+		root: vs[0], pages: vs[1], keys: vs[2], cmds: vs[3],
+		valsEnd: vs[4], first: vs[5], last: vs[6], len: vs[7],
+	}, nil
+}
+
+// BTreeHT stores the latest state of each key on a disk-backed B+tree, allowing logs
+// whose unique-key state exceeds the available memory to be compacted. Only a bounded
+// number of node pages is retained in memory by a LRU page cache, while states are
+// appended to a separate value file. Every command is first recorded on a write-ahead
+// log, and modified pages are only written during checkpoints, which are journaled
+// before updating pages in place. After a failure, 'OpenBTreeHT' restores the state of
+// the last checkpoint and replays the commands recorded on the WAL since then.
+//
+// Tree files are always kept on the local filesystem, derived from the path informed
+// on construction (e.g. './tree' is mapped into './tree.pages', './tree.vals',
+// './tree.wal' and './tree.journal'). The reduced log still follows the informed
+// config, and is streamed directly from leaves on persistent configs.
+type BTreeHT struct {
+	pages, vals *os.File
+	wal, jrnl   *os.File
+	meta        btreeMeta
+	walEnd      int64
+	walCmds     int
+	tmpDir      string // only set by NewBTreeHT
+
+	cap   int
+	lru   *clist.List // of *btreeNode, most recently used first
+	cache map[uint64]*clist.Element
+
+	mu   sync.Mutex
+	canc context.CancelFunc
+	logData
+}
+
+// NewBTreeHT returns a BTreeHT storing its files on a new temporary directory, which
+// is removed on 'Shutdown'.
+func NewBTreeHT() (*BTreeHT, error) {
+	dir, err := ioutil.TempDir("", "beelog-btree")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultLogConfig()
+	cfg.Alg = IterBTree
+
+	bt, err := createBTreeHT(cfg, filepath.Join(dir, "tree"), defaultBTreeCachePages)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	bt.tmpDir = dir
+	return bt, nil
+}
+
+// NewBTreeHTWithConfig returns a BTreeHT storing its files on 'path', truncating any
+// prior content, and retaining up to 'cachePages' node pages in memory. On RestoreOnInit
+// configs, a structure previously stored on 'path' is restored instead, as in
+// 'OpenBTreeHT'.
+func NewBTreeHTWithConfig(cfg *LogConfig, path string, cachePages int) (*BTreeHT, error) {
+	if err := validateBTreeConfig(cfg, path, cachePages); err != nil {
+		return nil, err
+	}
+	if cfg.RestoreOnInit {
+		if info, err := os.Stat(path + ".pages"); err == nil && info.Size() > 0 {
+			return OpenBTreeHT(cfg, path, cachePages)
+		}
+	}
+
+	bt, err := createBTreeHT(cfg, path, cachePages)
+	if err != nil {
+		return nil, err
+	}
+	bt.mayLaunchReduceTicker()
+	return bt, nil
+}
+
+// OpenBTreeHT is analogous to 'NewBTreeHTWithConfig', but restores the structure
+// previously stored on 'path', replaying any command recorded on its WAL after the
+// last checkpoint.
+func OpenBTreeHT(cfg *LogConfig, path string, cachePages int) (*BTreeHT, error) {
+	if err := validateBTreeConfig(cfg, path, cachePages); err != nil {
+		return nil, err
+	}
+
+	bt, err := openBTreeFiles(cfg, path, cachePages, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = bt.restore(); err != nil {
+		bt.closeFiles()
+		return nil, err
+	}
+	bt.mayLaunchReduceTicker()
+	return bt, nil
+}
+
+func validateBTreeConfig(cfg *LogConfig, path string, cachePages int) error {
+	if err := cfg.ValidateConfig(); err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("invalid config: a path for the b+tree files must be provided")
+	}
+	if cachePages <= 0 {
+		return errors.New("invalid config: a positive number of cached pages must be provided")
+	}
+	if cfg.Journal {
+		return errors.New("invalid config: config.Journal is unsupported on BTreeHT structures, which already journal writes")
+	}
+	if cfg.Codec != ProtoCodec {
+		return errors.New("invalid config: config.Codec is unsupported on BTreeHT structures")
+	}
+	return checkHistorySupported(cfg, BTreeKind)
+}
+
+func createBTreeHT(cfg *LogConfig, path string, cachePages int) (*BTreeHT, error) {
+	bt, err := openBTreeFiles(cfg, path, cachePages, os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	// page 0 stores metadata, and the root starts as an empty leaf
+	bt.meta = btreeMeta{pages: 1}
+	bt.meta.root = bt.newNode(true).id
+
+	if err = bt.checkpoint(); err != nil {
+		bt.closeFiles()
+		return nil, err
+	}
+	return bt, nil
+}
+
+func openBTreeFiles(cfg *LogConfig, path string, cachePages int, flag int) (*BTreeHT, error) {
+	bt := &BTreeHT{
+		cap:     cachePages,
+		lru:     clist.New(),
+		cache:   make(map[uint64]*clist.Element),
+		logData: newLogData(cfg),
+	}
+
+	var err error
+	for _, f := range []struct {
+		fd  **os.File
+		ext string
+	}{
+		{&bt.pages, ".pages"}, {&bt.vals, ".vals"}, {&bt.wal, ".wal"}, {&bt.jrnl, ".journal"},
+	} {
+		*f.fd, err = os.OpenFile(path+f.ext, os.O_CREATE|os.O_RDWR|flag, 0644)
+		if err != nil {
+			bt.closeFiles()
+			return nil, err
+		}
+	}
+	return bt, nil
+}
+
+func (bt *BTreeHT) mayLaunchReduceTicker() {
+	if bt.config.Tick == TimeInterval {
+		ctx, cancel := context.WithCancel(context.Background())
+		bt.canc = cancel
+		launchReduceTicker(ctx, bt.config.Duration, bt.reduceOnTick)
+	}
+}
+
+// Str returns a string representation of every key on the leaves, on key order, and
+// the index of its latest state, used for debug purposes.
+func (bt *BTreeHT) Str() string {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	var strs []string
+	err := bt.walkLeaves(func(key string, v btreeValue) error {
+		strs = append(strs, fmt.Sprintf("(%v|%v)->", v.ind, key))
+		return nil
+	})
+	if err != nil {
+		strs = append(strs, err.Error())
+	}
+	return strings.Join(strs, " ")
+}
+
+// Len returns the number of write commands logged on the structure.
+func (bt *BTreeHT) Len() uint64 {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.length()
+}
+
+func (bt *BTreeHT) length() uint64 {
+	return bt.meta.len
+}
+
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (bt *BTreeHT) FirstIndex() uint64 {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (bt *BTreeHT) LastIndex() uint64 {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (bt *BTreeHT) Debug() DebugInfo {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.debugInfo("btree", bt.meta.len)
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (bt *BTreeHT) Stats() (Stats, error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	st, err := bt.baseStats()
+	st.Keys = int(bt.meta.keys)
+	st.MemBytes = int64(len(bt.cache)) * btreePageSize
+
+	// page, value, WAL and journal files of the tree
+	st.Segments += 4
+	return st, err
+}
+
+// Log records the occurence of command 'cmd' on the provided index. Writes are first
+// recorded on the WAL, then replace the latest state of their key on the tree.
+func (bt *BTreeHT) Log(cmd pb.Command) error {
+	return bt.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (bt *BTreeHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return bt.seq.sequence(ctx, cmd, bt.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (bt *BTreeHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isRangeDelete(cmd.Op) {
+		return ErrRangeDeleteUnsupported
+	}
+	if len(cmd.Key) > btreeMaxKeyLen {
+		return fmt.Errorf("key length exceeds the maximum of %d bytes", btreeMaxKeyLen)
+	}
+	if bt.ignoresRead(&cmd) {
+		return nil
+	}
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.countLogged(&cmd)
+	bt.recordPinned(&cmd)
+
+	if !isWriteOp(cmd.Op) {
+		if !bt.logged {
+			bt.meta.first = cmd.Id
+		}
+		bt.attributeFirst(cmd.Id)
+		bt.last = cmd.Id
+		bt.meta.last = cmd.Id
+		return bt.mayTriggerReduce(ctx)
+	}
+
+	if err := bt.appendToWAL(cmd); err != nil {
+		return err
+	}
+	if err := bt.apply(cmd); err != nil {
+		return err
+	}
+	if err := bt.mayCheckpoint(); err != nil {
+		return err
+	}
+
+	// Immediately recovery entirely reduces the log to its minimal format
+	if bt.config.Tick == Immediately {
+		return bt.reduceLogCtx(ctx, bt.first, bt.last)
+	}
+	return bt.mayTriggerReduce(ctx)
+}
+
+// Recov returns a compacted log of commands. Since only the latest state of each key
+// is retained, the entire reduced log is always returned, independently of the
+// requested [p, n] interval. On persistent configuration (i.e. 'inmem' false) the
+// entire log is loaded and then unmarshaled, consider using 'RecovBytesStream' calls
+// instead.
+func (bt *BTreeHT) Recov(p, n uint64) ([]pb.Command, error) {
+	return bt.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (bt *BTreeHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	p, n, err := bt.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bt.hookRecovery(p, n)
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if err := bt.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return nil, err
+	}
+	return bt.retrieveLogCtx(ctx)
+}
+
+// RecovBytes returns an already serialized log, parsed from persistent storage
+// or marshaled from the in-memory state. The command interpretation from the byte
+// stream follows a simple slicing protocol, where the size of each command is binary
+// encoded before the raw pbuff.
+func (bt *BTreeHT) RecovBytes(p, n uint64) ([]byte, error) {
+	p, n, err := bt.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	bt.hookRecovery(p, n)
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if err := bt.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return nil, err
+	}
+	return bt.retrieveRawLog(p, n)
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn). On persistent configuration, neither reduce
+// nor recovery load the entire state in memory.
+func (bt *BTreeHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := bt.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	bt.hookRecovery(p, n)
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if err := bt.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return bt.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (bt *BTreeHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := bt.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (bt *BTreeHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := bt.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (bt *BTreeHT) RecovSince(id uint64) ([]pb.Command, error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.length() == 0 || id >= bt.last {
+		return []pb.Command{}, nil
+	}
+
+	log := []pb.Command{}
+	err := bt.walkLeaves(func(key string, v btreeValue) error {
+		if v.ind <= id {
+			return nil
+		}
+		cmds, err := bt.readValue(v)
+		if err != nil {
+			return err
+		}
+		log = append(log, filterSince(cmds, id)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (bt *BTreeHT) Snapshot() (*Snapshot, error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.length() == 0 {
+		return newSnapshot(bt.last, nil), nil
+	}
+	cmds, err := IterBTreeHT(bt)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshot(bt.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (bt *BTreeHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := bt.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', reading it from the value file.
+// Returns false if the key was never written, or if its state could not be read, in
+// which case the failure is reported on the structure error channel.
+func (bt *BTreeHT) Get(key string) (pb.Command, bool) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	cmd, ok, err := bt.getState(key)
+	if err != nil {
+		bt.errs.report(fmt.Errorf("failed reading state of key '%s', err: %w", key, err))
+		return pb.Command{}, false
+	}
+	return cmd, ok
+}
+
+// Keys returns every key with a state retained on the tree, in ascending order.
+// Failures while walking the tree are reported on the structure error channel,
+// returning only the keys visited so far.
+func (bt *BTreeHT) Keys() []string {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	keys := make([]string, 0, bt.meta.keys)
+	err := bt.walkLeaves(func(key string, v btreeValue) error {
+		// states are only read if they possibly expired
+		if bt.config.DropExpired {
+			chain, err := bt.readValue(v)
+			if err != nil {
+				return err
+			}
+			if !bt.liveState(&chain[len(chain)-1], nil) {
+				return nil
+			}
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		bt.errs.report(fmt.Errorf("failed walking tree keys, err: %w", err))
+	}
+	return keys
+}
+
+// getState returns the latest visible state of 'key'.
+func (bt *BTreeHT) getState(key string) (pb.Command, bool, error) {
+	v, ok, err := bt.lookup(key)
+	if err != nil || !ok {
+		return pb.Command{}, false, err
+	}
+	chain, err := bt.readValue(v)
+	if err != nil {
+		return pb.Command{}, false, err
+	}
+	cmd := chain[len(chain)-1]
+	if !bt.liveState(&cmd, nil) {
+		return pb.Command{}, false, nil
+	}
+	return cmd, true, nil
+}
+
+// ReduceLog applies the configured reduce algorithm and updates the current log state.
+// Must only be called within mutual exclusion scope.
+func (bt *BTreeHT) ReduceLog(p, n uint64) error {
+	return bt.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (bt *BTreeHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !bt.canStreamReduce() {
+		start := bt.hookReduceStart(p, n)
+		cmds, err := bt.applyReduce(bt, p, n)
+		bt.hookReduceDone(p, n, start, len(cmds), err)
+		if err != nil {
+			return err
+		}
+		return bt.updateLogStateCtx(ctx, cmds, p, n, 0)
+	}
+
+	// the reduced log is written directly from leaves, without loading the entire
+	// state in memory
+	seg, err := bt.storage().Create(bt.config.Fname)
+	if err != nil {
+		return err
+	}
+	defer seg.Close()
+	cw := &countingWriter{w: seg}
+
+	start := bt.hookReduceStart(p, n)
+	err = bt.streamReducedLog(cw, p, n)
+	bt.hookReduceDone(p, n, start, int(bt.meta.cmds), err)
+	if err != nil {
+		return err
+	}
+
+	if bt.config.Sync {
+		if err = seg.Sync(); err != nil {
+			return err
+		}
+	}
+	bt.hookPersist(bt.config.Fname, cw.n)
+	return nil
+}
+
+// retainedStates returns the latest state of every key, reduced by registered reducers.
+// Must only be called within mutual exclusion scope.
+func (bt *BTreeHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return IterBTreeHT(bt)
+}
+
+// canStreamReduce informs if the reduced log can be written directly into persistent
+// storage, which is not possible on configs that post-process the reduced log before
+// persisting it.
+func (bt *BTreeHT) canStreamReduce() bool {
+	cfg := bt.config
+	return !cfg.Inmem && cfg.Encryption == nil && !cfg.DropExpired && !cfg.KeepAll && cfg.Pinned == nil
+}
+
+// mayTriggerReduce possibly triggers the reduce algorithm based on config params
+// (e.g. interval period reached). Must only be called within mutual exclusion scope.
+func (bt *BTreeHT) mayTriggerReduce(ctx context.Context) error {
+	if bt.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		bt.count++
+		return nil
+	}
+	if !bt.config.Tick.isPeriodic() {
+		return nil
+	}
+	if bt.reachedReducePeriod(int(bt.meta.keys)) {
+		return bt.reduceLogCtx(ctx, bt.first, bt.last)
+	}
+	return nil
+}
+
+// mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
+// 'config.Period' wasnt reached yet.
+func (bt *BTreeHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
+	if bt.config.Tick == Delayed {
+		err := bt.reduceLogCtx(ctx, p, n)
+		if err != nil {
+			return err
+		}
+
+	} else if bt.config.Tick.isScheduled() && !bt.firstReduceExists() {
+		err := bt.reduceLogCtx(ctx, bt.first, bt.last)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (bt *BTreeHT) reduceOnTick() {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.count == 0 {
+		return
+	}
+
+	// on failure, the counter is retained to retry on the next tick
+	err := bt.config.Retry.do(context.Background(), func() error {
+		return bt.ReduceLog(bt.first, bt.last)
+	})
+	if err != nil {
+		bt.reportReduceErr(err)
+		return
+	}
+	bt.count = 0
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, flushes
+// any pending group commit, and checkpoints and closes the tree files.
+func (bt *BTreeHT) Shutdown() {
+	if bt.canc != nil {
+		bt.canc()
+	}
+	if bt.gc != nil {
+		bt.gc.close()
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if err := bt.checkpoint(); err != nil {
+		bt.errs.report(fmt.Errorf("failed checkpointing b+tree on shutdown, err: %w", err))
+	}
+	bt.closeFiles()
+
+	if bt.tmpDir != "" {
+		os.RemoveAll(bt.tmpDir)
+	}
+}
+
+// apply records the write command 'cmd' on the tree, appending its state to the value
+// file. Conditional commands retain the prior state chain of their key.
+func (bt *BTreeHT) apply(cmd pb.Command) error {
+	old, exists, err := bt.lookup(cmd.Key)
+	if err != nil {
+		return err
+	}
+
+	chain := []pb.Command{cmd}
+	st := State{ind: cmd.Id, cmd: cmd}
+	if exists && st.dependsOnPrior() {
+		prior, err := bt.readValue(old)
+		if err != nil {
+			return err
+		}
+		chain = append(prior, cmd)
+	}
+
+	v, err := bt.appendValue(chain)
+	if err != nil {
+		return err
+	}
+	v.ind = cmd.Id
+
+	if err = bt.put(cmd.Key, v); err != nil {
+		return err
+	}
+
+	if exists {
+		bt.meta.cmds -= uint64(old.cmds)
+	} else {
+		bt.meta.keys++
+	}
+	bt.meta.cmds += uint64(v.cmds)
+
+	// adjust first structure index
+	if !bt.logged {
+		bt.meta.first = cmd.Id
+	}
+	bt.attributeFirst(cmd.Id)
+	bt.meta.last = cmd.Id
+	bt.last = cmd.Id
+	bt.meta.len++
+
+	bt.shrinkCache()
+	return nil
+}
+
+// lookup returns the latest state reference of 'key', if any.
+func (bt *BTreeHT) lookup(key string) (btreeValue, bool, error) {
+	nd, err := bt.node(bt.meta.root)
+	if err != nil {
+		return btreeValue{}, false, err
+	}
+
+	for !nd.leaf {
+		i := sort.Search(len(nd.keys), func(i int) bool { return nd.keys[i] > key })
+		if nd, err = bt.node(nd.childs[i]); err != nil {
+			return btreeValue{}, false, err
+		}
+	}
+
+	i := sort.SearchStrings(nd.keys, key)
+	if i < len(nd.keys) && nd.keys[i] == key {
+		return nd.vals[i], true, nil
+	}
+	return btreeValue{}, false, nil
+}
+
+// put stores 'v' as the latest state of 'key', splitting the root if necessary.
+func (bt *BTreeHT) put(key string, v btreeValue) error {
+	sep, right, err := bt.putOnNode(bt.meta.root, key, v)
+	if err != nil || right == 0 {
+		return err
+	}
+
+	root := bt.newNode(false)
+	root.keys = []string{sep}
+	root.childs = []uint64{bt.meta.root, right}
+	bt.meta.root = root.id
+	return nil
+}
+
+// putOnNode stores 'v' on the subtree of node 'id'. If the node was split, the separator
+// key and the page of its new right sibling are returned, otherwise 'right' is zero.
+// Nodes are never evicted from the cache during a put, so references remain valid.
+func (bt *BTreeHT) putOnNode(id uint64, key string, v btreeValue) (string, uint64, error) {
+	nd, err := bt.node(id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if nd.leaf {
+		i := sort.SearchStrings(nd.keys, key)
+		if i < len(nd.keys) && nd.keys[i] == key {
+			nd.vals[i] = v
+
+		} else {
+			nd.keys = append(nd.keys, "")
+			copy(nd.keys[i+1:], nd.keys[i:])
+			nd.keys[i] = key
+
+			nd.vals = append(nd.vals, btreeValue{})
+			copy(nd.vals[i+1:], nd.vals[i:])
+			nd.vals[i] = v
+		}
+
+	} else {
+		i := sort.Search(len(nd.keys), func(i int) bool { return nd.keys[i] > key })
+		sep, right, err := bt.putOnNode(nd.childs[i], key, v)
+		if err != nil || right == 0 {
+			return "", 0, err
+		}
+
+		nd.keys = append(nd.keys, "")
+		copy(nd.keys[i+1:], nd.keys[i:])
+		nd.keys[i] = sep
+
+		nd.childs = append(nd.childs, 0)
+		copy(nd.childs[i+2:], nd.childs[i+1:])
+		nd.childs[i+1] = right
+	}
+	nd.dirty = true
+
+	if nd.size() <= btreePageSize {
+		return "", 0, nil
+	}
+	sep, right := bt.split(nd)
+	return sep, right.id, nil
+}
+
+// split moves the upper half of 'nd' entries, considering their encoded size, into a
+// new right sibling, returning the separator key between both.
+func (bt *BTreeHT) split(nd *btreeNode) (string, *btreeNode) {
+	half, acc, m := nd.size()/2, 11, 0
+	for m < len(nd.keys)-1 && acc < half {
+		acc += nd.entrySize(m)
+		m++
+	}
+
+	right := bt.newNode(nd.leaf)
+	var sep string
+	if nd.leaf {
+		right.keys = append([]string{}, nd.keys[m:]...)
+		right.vals = append([]btreeValue{}, nd.vals[m:]...)
+		right.next = nd.next
+
+		nd.keys, nd.vals = nd.keys[:m], nd.vals[:m]
+		nd.next = right.id
+		sep = right.keys[0]
+
+	} else {
+		// the separator is moved up, instead of copied
+		sep = nd.keys[m]
+		right.keys = append([]string{}, nd.keys[m+1:]...)
+		right.childs = append([]uint64{}, nd.childs[m+1:]...)
+
+		nd.keys, nd.childs = nd.keys[:m], nd.childs[:m+1]
+	}
+	nd.dirty = true
+	return sep, right
+}
+
+// walkLeaves visits every key and its latest state reference on key order. Visited
+// pages are evicted as needed, so 'visit' must not modify the tree.
+func (bt *BTreeHT) walkLeaves(visit func(key string, v btreeValue) error) error {
+	nd, err := bt.node(bt.meta.root)
+	if err != nil {
+		return err
+	}
+	for !nd.leaf {
+		if nd, err = bt.node(nd.childs[0]); err != nil {
+			return err
+		}
+	}
+
+	for {
+		for i, k := range nd.keys {
+			if err = visit(k, nd.vals[i]); err != nil {
+				return err
+			}
+		}
+		if nd.next == 0 {
+			bt.shrinkCache()
+			return nil
+		}
+
+		// safe, since 'nd' is already read and only the next leaf is referenced
+		bt.shrinkCache()
+		if nd, err = bt.node(nd.next); err != nil {
+			return err
+		}
+	}
+}
+
+// streamReducedLog writes the latest state of every key into 'w', following the same
+// format of 'MarshalLogIntoWriter', copying serialized states from the value file.
+func (bt *BTreeHT) streamReducedLog(w io.Writer, p, n uint64) error {
+	bw := bufio.NewWriter(w)
+	if err := writeLogHeader(bw, p, n, int(bt.meta.cmds)); err != nil {
+		return err
+	}
+
+	err := bt.walkLeaves(func(key string, v btreeValue) error {
+		_, err := io.Copy(bw, io.NewSectionReader(bt.vals, int64(v.off), int64(v.size)))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// manually write an add-hoc EOL (end-of-log) mark
+	if _, err = fmt.Fprintln(bw, "\nEOL"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// appendValue serializes 'chain' at the end of the value file.
+func (bt *BTreeHT) appendValue(chain []pb.Command) (btreeValue, error) {
+	buff := bytes.NewBuffer(nil)
+	if err := marshalCommandsIntoWriter(buff, &chain); err != nil {
+		return btreeValue{}, err
+	}
+
+	v := btreeValue{
+		off:  bt.meta.valsEnd,
+		size: uint32(buff.Len()),
+		cmds: uint32(len(chain)),
+	}
+	if _, err := bt.vals.WriteAt(buff.Bytes(), int64(v.off)); err != nil {
+		return btreeValue{}, err
+	}
+	bt.meta.valsEnd += uint64(v.size)
+	return v, nil
+}
+
+// readValue returns the state chain referenced by 'v'.
+func (bt *BTreeHT) readValue(v btreeValue) ([]pb.Command, error) {
+	rd := bufio.NewReader(io.NewSectionReader(bt.vals, int64(v.off), int64(v.size)))
+	cmds := make([]pb.Command, 0, v.cmds)
+	for i := uint32(0); i < v.cmds; i++ {
+		c, err := readCommand(rd, LogFormatVersion)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds, nil
+}
+
+// node returns the node stored on page 'id', reading it from the pages file if not
+// cached.
+func (bt *BTreeHT) node(id uint64) (*btreeNode, error) {
+	if el, ok := bt.cache[id]; ok {
+		bt.lru.MoveToFront(el)
+		return el.Value.(*btreeNode), nil
+	}
+
+	page := make([]byte, btreePageSize)
+	if _, err := bt.pages.ReadAt(page, int64(id)*btreePageSize); err != nil {
+		return nil, err
+	}
+	nd, err := decodeBTreeNode(id, page)
+	if err != nil {
+		return nil, err
+	}
+	bt.cache[id] = bt.lru.PushFront(nd)
+	return nd, nil
+}
+
+// newNode allocates a new dirty node on the next available page.
+func (bt *BTreeHT) newNode(leaf bool) *btreeNode {
+	nd := &btreeNode{id: bt.meta.pages, leaf: leaf, dirty: true}
+	if !leaf {
+		nd.childs = []uint64{}
+	}
+	bt.meta.pages++
+	bt.cache[nd.id] = bt.lru.PushFront(nd)
+	return nd
+}
+
+// shrinkCache evicts the least recently used clean pages until the cache capacity is
+// respected. Dirty pages are never written before a checkpoint, so if not enough clean
+// pages are found, a checkpoint is executed.
+func (bt *BTreeHT) shrinkCache() {
+	bt.evictClean()
+	if len(bt.cache) <= bt.cap {
+		return
+	}
+
+	if err := bt.checkpoint(); err != nil {
+		// pages are retained in memory, and a later checkpoint is retried
+		bt.errs.report(fmt.Errorf("failed checkpointing b+tree, err: %w", err))
+		return
+	}
+	bt.evictClean()
+}
+
+func (bt *BTreeHT) evictClean() {
+	for el := bt.lru.Back(); el != nil && len(bt.cache) > bt.cap; {
+		prev := el.Prev()
+		if nd := el.Value.(*btreeNode); !nd.dirty {
+			bt.lru.Remove(el)
+			delete(bt.cache, nd.id)
+		}
+		el = prev
+	}
+}
+
+// appendToWAL records 'cmd' on the write-ahead log, before it's applied on the tree.
+func (bt *BTreeHT) appendToWAL(cmd pb.Command) error {
+	buff := bytes.NewBuffer(nil)
+	if err := marshalCommandsIntoWriter(buff, &[]pb.Command{cmd}); err != nil {
+		return err
+	}
+
+	if _, err := bt.wal.WriteAt(buff.Bytes(), bt.walEnd); err != nil {
+		return err
+	}
+	if bt.config.Sync {
+		if err := bt.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	bt.walEnd += int64(buff.Len())
+	bt.walCmds++
+	return nil
+}
+
+func (bt *BTreeHT) mayCheckpoint() error {
+	if bt.walCmds < btreeCheckpointCmds {
+		return nil
+	}
+	return bt.checkpoint()
+}
+
+// checkpoint writes every dirty page and the metadata into the pages file, then
+// truncates the WAL. Page images are first recorded on the journal, so a checkpoint
+// interrupted by a failure can be completed during restore.
+func (bt *BTreeHT) checkpoint() error {
+	if err := bt.vals.Sync(); err != nil {
+		return err
+	}
+
+	dirty := []*btreeNode{}
+	for _, el := range bt.cache {
+		if nd := el.Value.(*btreeNode); nd.dirty {
+			dirty = append(dirty, nd)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i].id < dirty[j].id })
+
+	jrnl := bytes.NewBuffer(nil)
+	imgs := make([][]byte, 0, len(dirty)+1)
+	ids := make([]uint64, 0, len(dirty)+1)
+
+	ids = append(ids, 0)
+	imgs = append(imgs, bt.meta.encode())
+	for _, nd := range dirty {
+		ids = append(ids, nd.id)
+		imgs = append(imgs, nd.encode())
+	}
+
+	for i, img := range imgs {
+		binary.Write(jrnl, binary.BigEndian, ids[i])
+		jrnl.Write(img)
+	}
+
+	// a trailing magic identifies a complete journal
+	jrnl.Write(btreeMagic)
+	if err := writeAndSync(bt.jrnl, jrnl.Bytes()); err != nil {
+		return err
+	}
+
+	for i, img := range imgs {
+		if _, err := bt.pages.WriteAt(img, int64(ids[i])*btreePageSize); err != nil {
+			return err
+		}
+	}
+	if err := bt.pages.Sync(); err != nil {
+		return err
+	}
+
+	for _, nd := range dirty {
+		nd.dirty = false
+	}
+	if err := writeAndSync(bt.jrnl, nil); err != nil {
+		return err
+	}
+	if err := writeAndSync(bt.wal, nil); err != nil {
+		return err
+	}
+	bt.walEnd, bt.walCmds = 0, 0
+	return nil
+}
+
+// restore completes any interrupted checkpoint, loads the metadata, and replays the
+// commands recorded on the WAL after the last checkpoint.
+func (bt *BTreeHT) restore() error {
+	jrnl, err := ioutil.ReadAll(io.NewSectionReader(bt.jrnl, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasSuffix(jrnl, btreeMagic) {
+		imgs := jrnl[:len(jrnl)-len(btreeMagic)]
+		for len(imgs) >= 8+btreePageSize {
+			id := binary.BigEndian.Uint64(imgs)
+			if _, err := bt.pages.WriteAt(imgs[8:8+btreePageSize], int64(id)*btreePageSize); err != nil {
+				return err
+			}
+			imgs = imgs[8+btreePageSize:]
+		}
+		if err = bt.pages.Sync(); err != nil {
+			return err
+		}
+
+		// every command on the WAL is already reflected on the journaled pages
+		if err = writeAndSync(bt.wal, nil); err != nil {
+			return err
+		}
+	}
+	if err = writeAndSync(bt.jrnl, nil); err != nil {
+		return err
+	}
+
+	page := make([]byte, btreePageSize)
+	if _, err = bt.pages.ReadAt(page, 0); err != nil {
+		return err
+	}
+	if bt.meta, err = decodeBTreeMeta(page); err != nil {
+		return err
+	}
+	bt.resetFirst(bt.meta.first, bt.meta.len > 0)
+	bt.last = bt.meta.last
+
+	// states appended after the last checkpoint are discarded, and reapplied from the WAL
+	if err = bt.vals.Truncate(int64(bt.meta.valsEnd)); err != nil {
+		return err
+	}
+
+	// the WAL is entirely read beforehand, since it may be truncated by checkpoints
+	// during replay
+	wal, err := ioutil.ReadAll(io.NewSectionReader(bt.wal, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+
+	rd := bytes.NewReader(wal)
+	for {
+		cmd, err := readCommand(rd, LogFormatVersion)
+		if err != nil {
+			// a partially written command is discarded
+			break
+		}
+		if err = bt.appendToWAL(cmd); err != nil {
+			return err
+		}
+		if err = bt.apply(cmd); err != nil {
+			return err
+		}
+	}
+	return bt.wal.Truncate(bt.walEnd)
+}
+
+func (bt *BTreeHT) closeFiles() {
+	for _, fd := range []*os.File{bt.pages, bt.vals, bt.wal, bt.jrnl} {
+		if fd != nil {
+			fd.Close()
+		}
+	}
+}
+
+// writeAndSync replaces the entire content of 'fd' by 'b', syncing it to stable storage.
+func writeAndSync(fd *os.File, b []byte) error {
+	if err := fd.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := fd.WriteAt(b, 0); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
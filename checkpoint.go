@@ -0,0 +1,326 @@
+package beelog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// ringCheckpointMagic identifies checkpoints of CircBuffHT un-reduced contents,
+// followed by their format version.
+var ringCheckpointMagic = []byte("BLRING\x01")
+
+// errCorruptCheckpoint is returned when decoding a truncated or corrupted checkpoint.
+var errCorruptCheckpoint = errors.New("corrupted ring checkpoint")
+
+// ringCheckpoint is a copy of the un-reduced contents of a CircBuffHT: its buffer and
+// spilled entries on insertion order, and the latest state of each key. Captured from
+// mutual exclusion scope, and written after the lock is released.
+type ringCheckpoint struct {
+	first, last uint64
+	spill, buff []*State
+	states      []State
+}
+
+// ringCheckpointer tracks the checkpoints written by a CircBuffHT, alternating
+// between two files so a checkpoint interrupted by a crash never corrupts the last
+// complete one.
+type ringCheckpointer struct {
+	mu      sync.Mutex
+	slot    int
+	last    uint64
+	written bool
+
+	// commands logged since the last capture, guarded by the structure mutex
+	count uint32
+}
+
+// checkpointFnames returns the two files alternately written with checkpoints of
+// config.CheckpointFname.
+func checkpointFnames(fn string) [2]string {
+	return [2]string{fn, fn + ".alt"}
+}
+
+// mayCaptureCheckpoint accounts a new logged command, capturing a checkpoint once
+// config.CheckpointEvery commands were logged since the last one. Must be called from
+// mutual exclusion scope.
+func (cb *CircBuffHT) mayCaptureCheckpoint() *ringCheckpoint {
+	if cb.config.CheckpointEvery == 0 {
+		return nil
+	}
+	cb.ckpt.count++
+	if cb.ckpt.count < cb.config.CheckpointEvery {
+		return nil
+	}
+	cb.ckpt.count = 0
+	return cb.captureCheckpoint()
+}
+
+// captureCheckpoint copies the un-reduced contents of the structure, on O(len + keys)
+// operations. Entries and states are never mutated once inserted, so only references
+// are copied. Must be called from mutual exclusion scope.
+func (cb *CircBuffHT) captureCheckpoint() *ringCheckpoint {
+	ck := &ringCheckpoint{
+		first:  cb.first,
+		last:   cb.last,
+		spill:  make([]*State, 0, len(cb.spill)),
+		buff:   make([]*State, 0, cb.len),
+		states: make([]State, 0, len(*cb.aux)),
+	}
+	for _, ent := range cb.spill {
+		ck.spill = append(ck.spill, ent.st)
+	}
+
+	ents := make([]buffEntry, cb.len)
+	cb.linearize(ents)
+	for _, ent := range ents {
+		ck.buff = append(ck.buff, ent.st)
+	}
+
+	for _, st := range *cb.aux {
+		ck.states = append(ck.states, st)
+	}
+	return ck
+}
+
+// Checkpoint writes the un-reduced contents of the structure into config.CheckpointFname,
+// regardless of the configured CheckpointEvery.
+func (cb *CircBuffHT) Checkpoint() error {
+	if cb.config.CheckpointFname == "" {
+		return errors.New("no config.CheckpointFname is configured")
+	}
+	cb.mu.Lock()
+	ck := cb.captureCheckpoint()
+	cb.mu.Unlock()
+	return cb.writeCheckpoint(ck)
+}
+
+// writeCheckpoint persists 'ck' into the least recently written checkpoint file,
+// unless a more recent checkpoint was already written by a concurrent call.
+func (cb *CircBuffHT) writeCheckpoint(ck *ringCheckpoint) error {
+	cb.ckpt.mu.Lock()
+	defer cb.ckpt.mu.Unlock()
+	if cb.ckpt.written && ck.last < cb.ckpt.last {
+		return nil
+	}
+
+	fn := checkpointFnames(cb.config.CheckpointFname)[cb.ckpt.slot]
+	seg, err := cb.storage().Create(fn)
+	if err != nil {
+		return err
+	}
+	defer seg.Close()
+
+	if _, err = seg.Write(ck.encode()); err != nil {
+		return err
+	}
+	if cb.config.Sync {
+		if err = seg.Sync(); err != nil {
+			return err
+		}
+	}
+
+	cb.ckpt.slot ^= 1
+	cb.ckpt.last, cb.ckpt.written = ck.last, true
+	return nil
+}
+
+// restoreCheckpoint loads the most recent valid checkpoint of config.CheckpointFname
+// into the structure, if any. Checkpoints interrupted by a crash are ignored, while
+// any other failure is returned. Must be called during construction.
+func (cb *CircBuffHT) restoreCheckpoint() error {
+	var latest *ringCheckpoint
+	for i, fn := range checkpointFnames(cb.config.CheckpointFname) {
+		if _, err := cb.storage().Size(fn); err != nil {
+			continue
+		}
+		rd, err := cb.storage().ReadAt(fn)
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return err
+		}
+
+		ck, err := decodeRingCheckpoint(raw)
+		if err == errCorruptCheckpoint {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not restore checkpoint '%s': %s", fn, err.Error())
+		}
+
+		if latest == nil || ck.last > latest.last {
+			// the next checkpoint replaces the older file
+			latest, cb.ckpt.slot = ck, i^1
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	// restored buffers are grown to fit every checkpointed entry, retaining a
+	// free position for the next insertion
+	if len(latest.buff) >= cb.cap {
+		cb.cap = len(latest.buff) + 1
+		cb.newBuffEpoch()
+	}
+	for i, st := range latest.buff {
+		(*cb.buff)[i] = buffEntry{ind: st.ind, key: st.cmd.Key, st: st}
+	}
+	cb.cur, cb.len = len(latest.buff), len(latest.buff)
+
+	cb.spill = make([]buffEntry, 0, len(latest.spill))
+	for _, st := range latest.spill {
+		cb.spill = append(cb.spill, buffEntry{ind: st.ind, key: st.cmd.Key, st: st})
+	}
+	for _, st := range latest.states {
+		(*cb.aux)[st.cmd.Key] = st
+	}
+
+	cb.resetFirst(latest.first, len(latest.buff) > 0 || len(latest.spill) > 0)
+	cb.last = latest.last
+	cb.ckpt.last, cb.ckpt.written = latest.last, true
+	return nil
+}
+
+// encode serializes the checkpoint: its magic, the uvarint encoded first and last
+// indexes, followed by the number of spilled entries, buffer entries and states, each
+// list followed by its encoded states. A trailing crc32 (Castagnoli) checksum of the
+// entire content detects checkpoints partially written.
+func (ck *ringCheckpoint) encode() []byte {
+	b := append([]byte(nil), ringCheckpointMagic...)
+	b = appendUvarint(b, ck.first)
+	b = appendUvarint(b, ck.last)
+
+	b = appendUvarint(b, uint64(len(ck.spill)))
+	for _, st := range ck.spill {
+		b = appendCheckpointState(b, st)
+	}
+	b = appendUvarint(b, uint64(len(ck.buff)))
+	for _, st := range ck.buff {
+		b = appendCheckpointState(b, st)
+	}
+	b = appendUvarint(b, uint64(len(ck.states)))
+	for i := range ck.states {
+		b = appendCheckpointState(b, &ck.states[i])
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(b, castagnoli))
+	return append(b, sum[:]...)
+}
+
+// appendCheckpointState appends 'st' and every prior state it depends on into 'b':
+// its uvarint index and command length, the serialized command, and a byte flagging
+// if a prior state follows.
+func appendCheckpointState(b []byte, st *State) []byte {
+	for ; st != nil; st = st.prev {
+		b = appendUvarint(b, st.ind)
+		b = appendUvarint(b, uint64(st.cmd.SizeVT()))
+		b = st.cmd.MarshalAppendVT(b)
+		if st.prev != nil {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// decodeRingCheckpoint interprets a serialized checkpoint. An errCorruptCheckpoint is
+// returned if its checksum does not match, including partially written ones.
+func decodeRingCheckpoint(raw []byte) (*ringCheckpoint, error) {
+	if len(raw) < len(ringCheckpointMagic)+4 || !bytes.Equal(raw[:len(ringCheckpointMagic)], ringCheckpointMagic) {
+		return nil, errCorruptCheckpoint
+	}
+	body, sum := raw[:len(raw)-4], binary.BigEndian.Uint32(raw[len(raw)-4:])
+	if crc32.Checksum(body, castagnoli) != sum {
+		return nil, errCorruptCheckpoint
+	}
+
+	d := &checkpointDecoder{b: body[len(ringCheckpointMagic):]}
+	ck := &ringCheckpoint{first: d.uvarint(), last: d.uvarint()}
+	ck.spill = d.stateList()
+	ck.buff = d.stateList()
+	for _, st := range d.stateList() {
+		ck.states = append(ck.states, *st)
+	}
+	if d.err == nil && len(d.b) > 0 {
+		d.err = fmt.Errorf("unexpected %d trailing bytes", len(d.b))
+	}
+	return ck, d.err
+}
+
+// checkpointDecoder reads the fields of a checksum validated checkpoint, retaining
+// the first failure.
+type checkpointDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *checkpointDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errors.New("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// stateList reads a uvarint number of states, never preallocating from it.
+func (d *checkpointDecoder) stateList() []*State {
+	n := d.uvarint()
+	var sts []*State
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		sts = append(sts, d.state())
+	}
+	return sts
+}
+
+// state reads a state and every prior state it depends on.
+func (d *checkpointDecoder) state() *State {
+	head := &State{}
+	for st := head; d.err == nil; {
+		st.ind = d.uvarint()
+		l := d.uvarint()
+		if d.err != nil {
+			break
+		}
+		if uint64(len(d.b)) < l+1 {
+			d.err = fmt.Errorf("truncated state of index %d", st.ind)
+			break
+		}
+
+		cmd := pb.Command{}
+		if d.err = cmd.UnmarshalVT(d.b[:l]); d.err != nil {
+			break
+		}
+		st.cmd = cmd
+		hasPrev := d.b[l] == 1
+		d.b = d.b[l+1:]
+
+		if !hasPrev {
+			break
+		}
+		st.prev = &State{}
+		st = st.prev
+	}
+	return head
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Lz-Gustavo/beelog/pb"
 )
@@ -17,22 +18,29 @@ const (
 )
 
 // buffEntry (ies) are equivalent to list entries without any "shortcut" ptr
-// to the stateTable.
+// to the stateTable. On CircBuffHT structures, entries also reference the state
+// they logged, which is never mutated once inserted.
 type buffEntry struct {
 	ind uint64
 	key string
+	st  *State
 }
 
 // minStateTable is a minimal format of the ordinary stateTable, storing only
 // the lates state for each key.
 type minStateTable map[string]State
 
-// buffCopy stores only the useful data for a structure snapshot.
+// buffCopy stores only the useful data for a structure snapshot. Copies reference
+// the buffer itself instead of duplicating it, since entries within [cur-len, cur)
+// are frozen: new insertions only write after 'cur', and a buffer is never rewritten
+// after a restart (i.e. a new buffer epoch is allocated instead).
 type buffCopy struct {
 	buf           []buffEntry
-	tbl           minStateTable
+	spill         []buffEntry
 	cur, cap, len int
+	keys          int
 	first, last   uint64
+	measure       int // latency measurement awaiting persistence, or -1
 }
 
 // CircBuffHT ...
@@ -42,9 +50,26 @@ type CircBuffHT struct {
 	mu   sync.Mutex
 	canc context.CancelFunc
 
+	// held by reduces and recoveries of the reduced state, exclusively by reduces
+	// executed by Log calls on Immediately config, which replace it concurrently, and
+	// by config updates
+	redMu sync.RWMutex
+
 	cur, cap, len int
 	reduceReq     chan buffCopy
+	pending       int32 // atomic, reduce requests not yet finished by the logger routine
 	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
+
+	// entries moved out of the buffer on SpillWhenFull config, retaining only the
+	// latest entry of each key on their insertion order
+	spill []buffEntry
+
+	// checkpoints of the un-reduced contents, on configs with a CheckpointFname
+	ckpt ringCheckpointer
 }
 
 // NewCircBuffHT ...
@@ -54,7 +79,7 @@ func NewCircBuffHT(ctx context.Context) *CircBuffHT {
 	ct, cancel := context.WithCancel(ctx)
 
 	cb := &CircBuffHT{
-		logData:   logData{config: DefaultLogConfig()},
+		logData:   newLogData(DefaultLogConfig()),
 		buff:      &sl,
 		aux:       &ht,
 		cap:       defaultCap,
@@ -71,20 +96,49 @@ func NewCircBuffHTWithConfig(ctx context.Context, cfg *LogConfig, cap int) (*Cir
 	if err != nil {
 		return nil, err
 	}
+	if cap <= 0 {
+		return nil, errors.New("invalid buffer capacity, must be a positive value")
+	}
+	if cfg.Growth == GrowWhenFull && cfg.MaxCap < cap {
+		return nil, errors.New("invalid config: config.MaxCap must be >= the initial buffer capacity")
+	}
+	if cfg.RestoreOnInit {
+		return nil, errors.New("invalid config: config.RestoreOnInit is unsupported on CircBuffHT structures")
+	}
 
 	ht := make(minStateTable, 0)
-	sl := make([]buffEntry, cap, cap) // fixed size
+	sl := make([]buffEntry, cap, cap) // fixed size, unless GrowWhenFull is set
 	ct, cancel := context.WithCancel(ctx)
 
 	cb := &CircBuffHT{
-		logData:   logData{config: cfg},
+		logData:   newLogData(cfg),
 		buff:      &sl,
 		aux:       &ht,
 		cap:       cap,
 		canc:      cancel,
 		reduceReq: make(chan buffCopy, chanBuffSize),
+		ctx:       ct,
+	}
+	if cfg.CheckpointFname != "" {
+		if err := cb.restoreCheckpoint(); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	if err := cb.initMeasure("circbuff"); err != nil {
+		cancel()
+		return nil, err
 	}
 	go cb.handleReduce(ct)
+
+	if err := cb.restoreJournal(cb.LogCtx); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(ct, cfg, &cb.tick, cb.reduceOnTick)
+	}
 	return cb, nil
 }
 
@@ -94,6 +148,10 @@ func (cb *CircBuffHT) Str() string {
 	defer cb.mu.Unlock()
 
 	var strs []string
+	for _, v := range cb.spill {
+		strs = append(strs, fmt.Sprintf("%v->", v))
+	}
+
 	i := 0
 	for i < cb.len {
 		// negative values already account circular reference
@@ -105,41 +163,119 @@ func (cb *CircBuffHT) Str() string {
 	return strings.Join(strs, " ")
 }
 
-// Len returns the list length.
+// Len returns the list length, accounting spilled entries.
 func (cb *CircBuffHT) Len() uint64 {
-	return uint64(cb.len)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.length()
+}
+
+func (cb *CircBuffHT) length() uint64 {
+	return uint64(cb.len + len(cb.spill))
+}
+
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (cb *CircBuffHT) FirstIndex() uint64 {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (cb *CircBuffHT) LastIndex() uint64 {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (cb *CircBuffHT) Debug() DebugInfo {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	info := cb.debugInfo("circbuff", uint64(cb.len+len(cb.spill)))
+	info.PendingReduces = cb.Pending()
+	return info
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (cb *CircBuffHT) Stats() (Stats, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	st, err := cb.baseStats()
+	st.Keys = len(*cb.aux)
+	st.MemBytes = cb.aux.memBytes() + int64(cb.cap+len(cb.spill))*buffEntBytes
+	return st, err
 }
 
 // Log records the occurence of command 'cmd' on the provided index. Writes are
 // mapped as a new node on the buffer array, with a pointer to the newly inserted
 // state update on the update list for its particular key.
 func (cb *CircBuffHT) Log(cmd pb.Command) error {
+	return cb.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Immediately reduces observe 'ctx' before persisting, in which
+// case the command remains recorded and is persisted by a later reduce. Reduces
+// delegated to the logger routine are not interrupted.
+func (cb *CircBuffHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return cb.seq.sequence(ctx, cmd, cb.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (cb *CircBuffHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isRangeDelete(cmd.Op) {
+		return ErrRangeDeleteUnsupported
+	}
 	cb.mu.Lock()
+	if cb.ignoresRead(&cmd) {
+		cb.mu.Unlock()
+		return nil
+	}
+	cb.measureBegin()
+	cb.countLogged(&cmd)
+	cb.recordPinned(&cmd)
 	var wrt bool
 
-	if cmd.Op != pb.Command_SET {
-		// TODO: treat 'ar.first' attribution on GETs
+	if err := cb.journalCommand(cmd); err != nil {
+		cb.mu.Unlock()
+		return err
+	}
+
+	if !isWriteOp(cmd.Op) {
+		cb.attributeFirst(cmd.Id)
 		cb.last = cmd.Id
 
 	} else {
 		wrt = true
-		entry := buffEntry{
-			ind: cmd.Id,
-			key: cmd.Key,
-		}
 
 		// update current state for that particular key
-		st := State{
+		st := &State{
 			ind: cmd.Id,
 			cmd: cmd,
 		}
-		(*cb.aux)[cmd.Key] = st
 
-		// adjust first structure index
-		if cb.Len() == 0 {
-			cb.first = entry.ind
+		// conditional updates must retain the state they were applied over
+		if prior, ok := (*cb.aux)[cmd.Key]; ok && st.dependsOnPrior() {
+			st.prev = &prior
+		}
+		(*cb.aux)[cmd.Key] = *st
+
+		entry := buffEntry{
+			ind: cmd.Id,
+			key: cmd.Key,
+			st:  st,
 		}
 
+		// adjust first structure index
+		cb.attributeFirst(entry.ind)
+
 		// insert new entry
 		(*cb.buff)[cb.cur] = entry
 
@@ -149,6 +285,20 @@ func (cb *CircBuffHT) Log(cmd pb.Command) error {
 		// adjust last index and len once inserted
 		cb.last = cmd.Id
 		cb.len++
+
+		if cb.len == cb.cap {
+			cb.mayGrowBuff()
+		}
+	}
+	cb.measureLogged()
+
+	// checkpoints are written once the lock is released, on every return
+	if ckpt := cb.mayCaptureCheckpoint(); ckpt != nil {
+		defer func() {
+			if err := cb.writeCheckpoint(ckpt); err != nil {
+				cb.errs.report(fmt.Errorf("failed during checkpoint, err: %w", err))
+			}
+		}()
 	}
 
 	// avoid an unecessary copy, reduce algorithm will be later executed
@@ -157,15 +307,42 @@ func (cb *CircBuffHT) Log(cmd pb.Command) error {
 		return nil
 	}
 
+	if cb.config.Tick == TimeInterval && cb.len != cb.cap {
+		// reduce is later executed by the ticker routine
+		cb.count++
+		cb.mu.Unlock()
+		return nil
+	}
+
 	cp := cb.createStateCopy()
-	cb.mu.Unlock()
+	cp.measure = cb.takeMeasure()
 
 	// Immediately recovery entirely reduces the log to its minimal format, and
 	// delays logging until reduce is finished.
 	if wrt && cb.config.Tick == Immediately {
-		return cb.ReduceLog(cp)
+		// a full buffer would be overwritten by the next insertion while still read
+		// by the reduce of 'cp', so a new epoch is started instead
+		if cb.len == cb.cap {
+			cb.resetBuffState()
+		}
+		// acquired before releasing the structure, so copies are persisted in order
+		cb.redMu.Lock()
+		defer cb.redMu.Unlock()
+		cb.mu.Unlock()
+		return cb.reduceLogCtx(ctx, cp)
+	}
+
+	// requests are accounted within mutual exclusion scope, so config updates observe
+	// every one of them
+	reduce := cb.mayTriggerReduce(cp)
+	if reduce {
+		atomic.AddInt32(&cb.pending, 1)
+	}
+	cb.mu.Unlock()
+
+	if reduce {
+		cb.reduceReq <- cp
 	}
-	cb.mayTriggerReduce(cp)
 	return nil
 }
 
@@ -175,18 +352,50 @@ func (cb *CircBuffHT) Log(cmd pb.Command) error {
 // 'inmem' false) the entire log is loaded and then unmarshaled, consider using
 // 'RecovBytes' calls instead. On CircBuff structures, indexes [p, n] are ignored.
 func (cb *CircBuffHT) Recov(p, n uint64) ([]pb.Command, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
-	}
+	return cb.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (cb *CircBuffHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	res, err := cb.recovCtx(ctx, p, n)
+	return res.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log, always the entire reduced interval.
+func (cb *CircBuffHT) RecovWithResult(p, n uint64) (RecovResult, error) {
+	return cb.recovCtx(context.Background(), p, n)
+}
+
+func (cb *CircBuffHT) recovCtx(ctx context.Context, p, n uint64) (RecovResult, error) {
+	// the config is only replaced once every recovery is finished
 	cb.mu.Lock()
 	cp := cb.createStateCopy()
+	cb.redMu.RLock()
+	defer cb.redMu.RUnlock()
 	cb.mu.Unlock()
 
+	p, n, err := cb.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return RecovResult{}, err
+	}
+	cb.hookRecovery(p, n)
+
 	// sequentially reduce since 'Recov' will already be called concurrently
-	if err := cb.mayExecuteLazyReduce(cp); err != nil {
-		return nil, err
+	if err := cb.mayExecuteLazyReduce(ctx, cp); err != nil {
+		return RecovResult{}, err
 	}
-	return cb.retrieveLog()
+	cmds, err := cb.retrieveLogCtx(ctx)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := cb.coverage(p, n, false)
+	res.Cmds = cmds
+	return res, nil
 }
 
 // RecovBytes returns an already serialized log, parsed from persistent storage
@@ -196,18 +405,154 @@ func (cb *CircBuffHT) Recov(p, n uint64) ([]pb.Command, error) {
 // the size of each command is binary encoded before the raw pbuff. On CircBuff
 // structures, indexes [p, n] are ignored.
 func (cb *CircBuffHT) RecovBytes(p, n uint64) ([]byte, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
-	}
+	res, err := cb.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log, always the entire reduced interval.
+func (cb *CircBuffHT) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
 	cb.mu.Lock()
 	cp := cb.createStateCopy()
+	cb.redMu.RLock()
+	defer cb.redMu.RUnlock()
 	cb.mu.Unlock()
 
+	p, n, err := cb.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	cb.hookRecovery(p, n)
+
 	// sequentially reduce since 'RecovBytes' will already be called concurrently
-	if err := cb.mayExecuteLazyReduce(cp); err != nil {
+	if err := cb.mayExecuteLazyReduce(context.Background(), cp); err != nil {
+		return RecovResult{}, err
+	}
+	raw, err := cb.retrieveRawLog(cp.first, cp.last)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := cb.coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+// On CircBuff structures, indexes [p, n] are ignored.
+func (cb *CircBuffHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	cb.mu.Lock()
+	cp := cb.createStateCopy()
+	cb.redMu.RLock()
+	defer cb.redMu.RUnlock()
+	cb.mu.Unlock()
+
+	p, n, err := cb.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	cb.hookRecovery(p, n)
+
+	// sequentially reduce since 'RecovBytesStream' will already be called concurrently
+	if err := cb.mayExecuteLazyReduce(context.Background(), cp); err != nil {
+		return err
+	}
+	return cb.streamRawLog(w, cp.first, cp.last)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (cb *CircBuffHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := cb.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (cb *CircBuffHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := cb.RecovFiltered(p, n, f)
+	if err != nil {
 		return nil, err
 	}
-	return cb.retrieveRawLog(cp.first, cp.last)
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (cb *CircBuffHT) RecovSince(id uint64) ([]pb.Command, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cp := cb.createStateCopy()
+	cmds := filterSince(IterCircBuffHT(&cp), id)
+
+	// states of prior buffers are only retained on the last reduced log
+	if cb.firstReduceExists() {
+		log, err := cb.retrieveLog()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, filterSince(log, id)...)
+	}
+	return mergeLatestStates(cmds), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands. Only a copy of the buffer state is taken
+// during creation, reduced once the snapshot is first read.
+func (cb *CircBuffHT) Snapshot() (*Snapshot, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cp := cb.createStateCopy()
+
+	// states of prior buffers are only retained on the last reduced log
+	var prior []pb.Command
+	if cb.firstReduceExists() {
+		log, err := cb.retrieveLog()
+		if err != nil {
+			return nil, err
+		}
+		prior = log
+	}
+
+	return newLazySnapshot(cb.last, func() ([]pb.Command, error) {
+		return mergeLatestStates(append(prior, IterCircBuffHT(&cp)...)), nil
+	}), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (cb *CircBuffHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := cb.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written.
+func (cb *CircBuffHT) Get(key string) (pb.Command, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	st, ok := (*cb.aux)[key]
+	if !ok || !cb.liveState(&st.cmd, nil) {
+		return pb.Command{}, false
+	}
+	return st.cmd, true
+}
+
+// Keys returns every key with a state retained on the buffer, in ascending order.
+func (cb *CircBuffHT) Keys() []string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.minStateKeys(*cb.aux)
 }
 
 // ReduceLog applies the configured algorithm on a concurrent-safe copy and
@@ -216,49 +561,60 @@ func (cb *CircBuffHT) RecovBytes(p, n uint64) ([]byte, error) {
 // TODO: maybe implement mutual exclusion during state update using a different
 // lock.
 func (cb *CircBuffHT) ReduceLog(cp buffCopy) error {
+	return cb.reduceLogCtx(context.Background(), cp)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (cb *CircBuffHT) reduceLogCtx(ctx context.Context, cp buffCopy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := cb.hookReduceStart(cp.first, cp.last)
 	cmds, err := cb.executeReduceAlgOnCopy(&cp)
+	cb.hookReduceDone(cp.first, cp.last, start, len(cmds), err)
 	if err != nil {
 		return err
 	}
-	return cb.updateLogState(cmds, cp.first, cp.last, false)
+	if err := cb.updateLogStateCtx(ctx, cmds, cp.first, cp.last, 0); err != nil {
+		return err
+	}
+	cb.measurePersisted(cp.measure)
+	return nil
 }
 
-// mayTriggerReduce possibly triggers the reduce algorithm based on config params
-// (e.g. interval period reached) or when the buffer capacity is surprassed on next
-// insertion. The circular buffer variant operates over a copy, so it's safe to be
-// called concurrently.
-func (cb *CircBuffHT) mayTriggerReduce(cp buffCopy) {
+// mayTriggerReduce informs if the reduce algorithm must be triggered over 'cp' based
+// on config params (e.g. interval period reached) or when the buffer capacity is
+// surprassed on next insertion. Must be called from mutual exclusion scope, while the
+// reduce request is sent by the caller once released, since the circular buffer
+// variant operates over a copy.
+func (cb *CircBuffHT) mayTriggerReduce(cp buffCopy) bool {
 	// cap surprassing on next insertion
 	if cb.len == cb.cap {
 		cb.resetBuffState()
-		cb.reduceReq <- cp
-		return
+		return true
 	}
 
-	if cb.config.Tick != Interval {
-		return
-	}
-	cb.count++
-	if cb.count >= cb.config.Period {
-		cb.count = 0
-		cb.reduceReq <- cp
+	if !cb.config.Tick.isPeriodic() {
+		return false
 	}
+	return cb.reachedReducePeriod(cp.keys)
 }
 
 // mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
 // 'config.Period' wasnt reached yet. On CircBuff structures, informed [first, last]
 // MUST ALWAYS match the first and last indexes contained on the local copy parameter.
-// Informing a different interval would incoherent with the 'Interval' config and compromise
+// Informing a different interval would incoherent with scheduled configs and compromise
 // safety.
-func (cb *CircBuffHT) mayExecuteLazyReduce(cp buffCopy) error {
+func (cb *CircBuffHT) mayExecuteLazyReduce(ctx context.Context, cp buffCopy) error {
 	if cb.config.Tick == Delayed {
-		err := cb.ReduceLog(cp)
+		err := cb.reduceLogCtx(ctx, cp)
 		if err != nil {
 			return err
 		}
 
-	} else if cb.config.Tick == Interval && !cb.firstReduceExists() {
-		err := cb.ReduceLog(cp)
+	} else if cb.config.Tick.isScheduled() && !cb.firstReduceExists() {
+		err := cb.reduceLogCtx(ctx, cp)
 		if err != nil {
 			return err
 		}
@@ -267,44 +623,130 @@ func (cb *CircBuffHT) mayExecuteLazyReduce(cp buffCopy) error {
 }
 
 func (cb *CircBuffHT) resetBuffState() {
-	cb.len = 0   // old values will later be overwritten by insertions
+	cb.len = 0   // old values are retained by copies of the prior epoch
 	cb.count = 0 // interval counting
-	cb.first = 0
-	cb.last = 0
+
+	// last index is retained, still the most recently logged
+	cb.resetFirst(0, false)
+	cb.spill = nil
+	cb.newBuffEpoch()
 }
 
-// createStateCopy returns a local view of the buffer structure and indexes metadata. Must
-// be called from mutual exclusion scope.
-//
-// TODO: investigate trade-offs between copying an array of states or the buffer array and
-// the auxiliar hash table.
-func (cb *CircBuffHT) createStateCopy() buffCopy {
-	cp := buffCopy{
-		cur:   cb.cur,
-		len:   cb.len,
-		cap:   cb.cap,
-		first: cb.first,
-		last:  cb.last,
-		buf:   make([]buffEntry, cb.cap, cb.cap),
-		tbl:   make(minStateTable, len(*cb.aux)),
+// newBuffEpoch replaces the buffer by a new one, retaining the prior buffer intact for
+// any copy still referencing it. Allocation is amortized by the 'cap' insertions made
+// on each epoch. Must be called from mutual exclusion scope.
+func (cb *CircBuffHT) newBuffEpoch() {
+	sl := make([]buffEntry, cb.cap, cb.cap)
+	cb.buff = &sl
+}
+
+// mayGrowBuff applies the configured growth policy once the buffer capacity is reached,
+// avoiding a forced reduce. On ReduceWhenFull config, or if 'MaxCap' was already reached,
+// the buffer remains full. Must be called from mutual exclusion scope.
+func (cb *CircBuffHT) mayGrowBuff() {
+	switch cb.config.Growth {
+	case GrowWhenFull:
+		if cb.cap >= cb.config.MaxCap {
+			return
+		}
+		ncap := 2 * cb.cap
+		if ncap > cb.config.MaxCap {
+			ncap = cb.config.MaxCap
+		}
+
+		sl := make([]buffEntry, ncap, ncap)
+		cb.linearize(sl)
+		cb.buff = &sl
+		cb.cur = cb.len
+		cb.cap = ncap
+
+	case SpillWhenFull:
+		sp := make([]buffEntry, len(cb.spill)+cb.len)
+		copy(sp, cb.spill)
+		cb.linearize(sp[len(cb.spill):])
+		cb.spill = latestBuffEntries(sp)
+
+		// first and last indexes are retained, only the buffer is restarted
+		cb.len, cb.cur = 0, 0
+		cb.newBuffEpoch()
+	}
+}
+
+// linearize copies buffer entries into 'dst' on their insertion order, oldest first.
+// Must be called from mutual exclusion scope.
+func (cb *CircBuffHT) linearize(dst []buffEntry) {
+	for i := 0; i < cb.len; i++ {
+		// negative values already account circular reference
+		pos := modInt((cb.cur - cb.len + i), cb.cap)
+		dst[i] = (*cb.buff)[pos]
+	}
+}
+
+// latestBuffEntries returns only the last entry of each key on 'ents', retaining their
+// relative order.
+func latestBuffEntries(ents []buffEntry) []buffEntry {
+	seen := make(map[string]bool, len(ents))
+	j := len(ents)
+	for i := len(ents) - 1; i >= 0; i-- {
+		if seen[ents[i].key] {
+			continue
+		}
+		seen[ents[i].key] = true
+		j--
+		ents[j] = ents[i]
 	}
+	return ents[j:]
+}
 
-	copy(cp.buf, *cb.buff)
-	for k, v := range *cb.aux {
-		cp.tbl[k] = v
+// createStateCopy returns a local view of the buffer structure and indexes metadata, on
+// O(1) operations. The copy references the current buffer epoch, whose logged entries are
+// never rewritten, and the spilled entries, which are only replaced. Must be called from
+// mutual exclusion scope.
+func (cb *CircBuffHT) createStateCopy() buffCopy {
+	return buffCopy{
+		buf:     *cb.buff,
+		spill:   cb.spill,
+		cur:     cb.cur,
+		len:     cb.len,
+		cap:     cb.cap,
+		keys:    len(*cb.aux),
+		first:   cb.first,
+		last:    cb.last,
+		measure: -1,
 	}
-	return cp
 }
 
 // executeReduceAlgOnCopy applies the configured reduce algorithm on a conflict-free copy.
 func (cb *CircBuffHT) executeReduceAlgOnCopy(cp *buffCopy) ([]pb.Command, error) {
+	cb.cpu.acquire()
+	defer cb.cpu.release()
+
+	if cb.config.AlgName != "" || cb.config.retainsHistory() {
+		return cb.reduceStates(CircBuffKind, cp.bufferedStates(), cp.first, cp.last)
+	}
 	switch cb.config.Alg {
 	case IterCircBuff:
-		return IterCircBuffHT(cp), nil
+		return iterCircBuffHT(cp, cb.cpu.yielder()), nil
 	}
 	return nil, errors.New("unsupported reduce algorithm for a CircBuffHT structure")
 }
 
+// reduceOnTick requests a reduce over a copy of the current buffer state if any command
+// was logged since the last reduce. Invoked by the ticker routine on TimeInterval config.
+func (cb *CircBuffHT) reduceOnTick() {
+	cb.mu.Lock()
+	if cb.count == 0 {
+		cb.mu.Unlock()
+		return
+	}
+	cb.count = 0
+	cp := cb.createStateCopy()
+	atomic.AddInt32(&cb.pending, 1)
+	cb.mu.Unlock()
+
+	cb.reduceReq <- cp
+}
+
 func (cb *CircBuffHT) handleReduce(ctx context.Context) {
 	for {
 		select {
@@ -312,15 +754,62 @@ func (cb *CircBuffHT) handleReduce(ctx context.Context) {
 			return
 
 		case cp := <-cb.reduceReq:
-			err := cb.ReduceLog(cp)
+			cb.redMu.RLock()
+			err := cb.config.Retry.do(ctx, func() error {
+				return cb.ReduceLog(cp)
+			})
+			cb.redMu.RUnlock()
 			if err != nil {
-				log.Fatalln("failed during reduce procedure, err:", err.Error())
+				cb.reportReduceErr(err)
 			}
+			atomic.AddInt32(&cb.pending, -1)
 		}
 	}
 }
 
+// Pending returns the number of reduce requests still waiting for, or being processed
+// by, the logger routine.
+func (cb *CircBuffHT) Pending() int {
+	return int(atomic.LoadInt32(&cb.pending))
+}
+
+// Subscribe returns a channel of 'buf' capacity emitting every reduced log state once
+// persisted, following the same semantics of ConcTable's 'Subscribe'.
+func (cb *CircBuffHT) Subscribe(buf int) (<-chan SegmentEvent, func()) {
+	return cb.feed.subscribe(buf)
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once every in-flight reduce and recovery is finished. New ones are blocked until
+// the config is replaced, while requests still queued to the logger routine are
+// reduced under the updated config.
+func (cb *CircBuffHT) SetConfig(cfg *LogConfig) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.redMu.Lock()
+	defer cb.redMu.Unlock()
+	return cb.reconfigure(cb.ctx, cfg, &cb.tick, cb.reduceOnTick)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (cb *CircBuffHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.redMu.Lock()
+	defer cb.redMu.Unlock()
+	cfg := cb.config.clone()
+	fn(cfg)
+	return cb.reconfigure(cb.ctx, cfg, &cb.tick, cb.reduceOnTick)
+}
+
 // Shutdown ...
 func (cb *CircBuffHT) Shutdown() {
 	cb.canc()
+	if cb.gc != nil {
+		cb.gc.close()
+	}
+	cb.closeJournal()
+	cb.feed.close()
+	cb.closeMeasure()
 }
@@ -0,0 +1,135 @@
+// Command beelog-bench runs throughput workloads over beelog structures, writing
+// one machine-readable result per run.
+//
+// Usage:
+//
+//	beelog-bench [-cmds N] [-keys N] [-writes P] [-dist D] [-ycsb a-f] [-seed N]
+//	             [-struct list,avl,...|all] [-tick interval,delayed,...] [-period N]
+//	             [-inmem] [-dir path] [-conc N] [-runs N] [-format jsonl|csv] [-out file]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/bench"
+	"github.com/Lz-Gustavo/beelog/workload"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("beelog-bench: ")
+
+	var (
+		w       bench.Workload
+		structs = flag.String("struct", "all", "comma-separated structures, or 'all'")
+		ticks   = flag.String("tick", "interval", "comma-separated reduce intervals (immediately, delayed, interval, adaptive, timeinterval)")
+		runs    = flag.Int("runs", 1, "number of runs of each structure and tick")
+		format  = flag.String("format", "jsonl", "output format, either jsonl or csv")
+		out     = flag.String("out", "", "output file, stdout if none is provided")
+		period  = flag.Uint("period", 1000, "reduce period on interval and adaptive ticks")
+		dist    = flag.String("dist", "uniform", "key distribution (uniform, zipfian, latest, hotspot)")
+	)
+	flag.Uint64Var(&w.Cmds, "cmds", 100000, "number of logged commands")
+	flag.IntVar(&w.Keys, "keys", 1000, "number of distinct keys")
+	flag.IntVar(&w.WritePercent, "writes", 50, "percentage of writes")
+	flag.StringVar(&w.Mix, "ycsb", "", "ycsb core workload (a to f), overriding -writes and -dist")
+	flag.Int64Var(&w.Seed, "seed", 0, "seed of the command stream")
+	flag.DurationVar(&w.Duration, "duration", 0, "reduce period on timeinterval ticks")
+	flag.BoolVar(&w.Inmem, "inmem", false, "retain reduced states in memory")
+	flag.StringVar(&w.Dir, "dir", "", "directory of persisted states, a temporary one if none is provided")
+	flag.IntVar(&w.ConcLevel, "conc", 2, "number of views on conctable structures")
+	flag.Parse()
+	w.Period = uint32(*period)
+
+	kinds, err := parseStructures(*structs)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if w.Dist, err = workload.ParseDistribution(*dist); err != nil {
+		log.Fatalln(err.Error())
+	}
+	tks, err := parseTicks(*ticks)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	write, err := resultWriter(*format)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	var dst io.Writer = os.Stdout
+	if *out != "" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		defer fd.Close()
+		dst = fd
+	}
+
+	rs := make([]bench.Result, 0, len(kinds)*len(tks)*(*runs))
+	for _, k := range kinds {
+		for _, t := range tks {
+			for i := 0; i < *runs; i++ {
+				w.Structure, w.Tick = k, t
+				r, err := bench.Run(context.Background(), w)
+				if err != nil {
+					log.Fatalf("%s: %s\n", k, err.Error())
+				}
+				rs = append(rs, r)
+			}
+		}
+	}
+	if err := write(dst, rs); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+func parseStructures(s string) ([]bl.StructureKind, error) {
+	if s == "all" {
+		return bench.Structures(), nil
+	}
+	supported := make(map[bl.StructureKind]bool)
+	for _, k := range bench.Structures() {
+		supported[k] = true
+	}
+
+	kinds := make([]bl.StructureKind, 0)
+	for _, name := range strings.Split(s, ",") {
+		k := bl.StructureKind(strings.TrimSpace(name))
+		if !supported[k] {
+			return nil, fmt.Errorf("unsupported structure '%s'", name)
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, nil
+}
+
+func parseTicks(s string) ([]bl.ReduceInterval, error) {
+	tks := make([]bl.ReduceInterval, 0)
+	for _, name := range strings.Split(s, ",") {
+		t, err := bench.ParseTick(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		tks = append(tks, t)
+	}
+	return tks, nil
+}
+
+func resultWriter(format string) (func(io.Writer, []bench.Result) error, error) {
+	switch format {
+	case "jsonl":
+		return bench.WriteJSONL, nil
+	case "csv":
+		return bench.WriteCSV, nil
+	}
+	return nil, fmt.Errorf("unknown output format '%s'", format)
+}
@@ -9,13 +9,16 @@ import (
 
 	bl "github.com/Lz-Gustavo/beelog"
 	"github.com/Lz-Gustavo/beelog/pb"
+	"github.com/Lz-Gustavo/beelog/workload"
 
 	"github.com/BurntSushi/toml"
 )
 
 // TestCase reflects the .TOML input files, configuring experimental evaluation
-// scenarios. If 'LogFile' is provided, the random parameters (NumCmds, PWrites,
-// NDiffKeys) are ignored and the static log is parsed from the provided path.
+// scenarios. If 'LogFilename' is provided, the random parameters (NumCmds, PWrites,
+// NDiffKeys, Payload, Distribution) are ignored and the static log is parsed from the
+// provided path, encoded on 'LogFormat'. Structures are configured by the optional
+// [Config] table, mapped into a beelog.LogConfig, and ConcTables by 'ConcLevel' views.
 type TestCase struct {
 	Name          string
 	Struct        StructID
@@ -25,6 +28,12 @@ type TestCase struct {
 	Iterations    int
 	Algo          []bl.Reducer
 	LogFilename   string
+	LogFormat     string
+	OutputFormat  string
+	Payload       string
+	Distribution  string
+	ConcLevel     int
+	Config        *bl.LogConfig
 }
 
 func newTestCase(cfg []byte) (*TestCase, error) {
@@ -33,25 +42,44 @@ func newTestCase(cfg []byte) (*TestCase, error) {
 	if err != nil {
 		return nil, err
 	}
-	if validateTestCase(tc); err != nil {
+	if err := validateTestCase(tc); err != nil {
 		return nil, err
 	}
 	return tc, nil
 }
 
 func validateTestCase(tc *TestCase) error {
-	if tc.NumCmds < 0 || tc.NumDiffKeys < 0 || tc.Iterations < 0 {
+	if tc.NumCmds < 0 || tc.NumDiffKeys < 0 || tc.Iterations < 0 || tc.ConcLevel < 0 {
 		return errors.New("negative config number")
 	}
 	if tc.PercentWrites < 0 || tc.PercentWrites > 100 {
 		return errors.New("invalid write percentage value")
 	}
-	if tc.Struct != LogDAG && tc.Struct != LogList && tc.Struct != LogAVL {
+	switch tc.Struct {
+	case LogList, LogAVL, LogArray, LogCircBuff, LogConcTable:
+	case LogDAG:
+		return errors.New("LogDAG structures are not implemented")
+	default:
 		return errors.New("unknow log structure")
 	}
 	if len(tc.Algo) < 1 {
 		return errors.New("no reduce algorithm provided")
 	}
+	if _, err := bl.ParseFormat(tc.OutputFormat); err != nil {
+		return err
+	}
+	if _, err := ParsePayload(tc.Payload); err != nil {
+		return err
+	}
+	if _, err := workload.ParseDistribution(tc.Distribution); err != nil {
+		return err
+	}
+	if _, err := ParseLogFormat(tc.LogFormat); err != nil {
+		return err
+	}
+	if tc.Config != nil {
+		return tc.Config.ValidateConfig()
+	}
 	return nil
 }
 
@@ -65,16 +93,17 @@ func (tc *TestCase) run() error {
 
 	for i := 0; i < tc.Iterations; i++ {
 		if hasInputLog {
-			cnt := TranslateConst(tc.Struct)
-			st, ln, err = cnt(tc.LogFilename)
+			lf, _ := ParseLogFormat(tc.LogFormat)
+			st, ln, err = Replay(tc.Struct, tc.LogFilename, lf, tc.ConcLevel, tc.Config)
 			if err != nil {
 				return err
 			}
 			tc.NumCmds = ln
 
 		} else {
-			gen := TranslateGen(tc.Struct)
-			st, err = gen(tc.NumCmds, tc.PercentWrites, tc.NumDiffKeys)
+			pl, _ := ParsePayload(tc.Payload)
+			dist, _ := workload.ParseDistribution(tc.Distribution)
+			st, err = Generate(tc.Struct, tc.NumCmds, tc.PercentWrites, tc.NumDiffKeys, dist, pl, tc.ConcLevel, tc.Config)
 			if err != nil {
 				return err
 			}
@@ -84,6 +113,7 @@ func (tc *TestCase) run() error {
 			start := time.Now()
 			log, err := bl.ApplyReduceAlgo(st, a, 0, uint64(tc.NumCmds-1))
 			if err != nil {
+				shutdown(st)
 				return err
 			}
 
@@ -92,6 +122,7 @@ func (tc *TestCase) run() error {
 				continue
 			}
 		}
+		shutdown(st)
 	}
 	return nil
 }
@@ -110,14 +141,15 @@ func (tc *TestCase) output(ind int, alg bl.Reducer, dur time.Duration, log []pb.
 	outF := "./output/"
 	fn := outF + tc.Name + "-iteration-" + strconv.Itoa(ind) + "-alg-" + strconv.Itoa(int(alg)) + ".out"
 
-	err := dumpLogIntoFile(outF, fn, log)
+	format, _ := bl.ParseFormat(tc.OutputFormat)
+	err := dumpLogIntoFile(outF, fn, format, log)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func dumpLogIntoFile(folder, name string, log []pb.Command) error {
+func dumpLogIntoFile(folder, name string, format bl.Format, log []pb.Command) error {
 	if _, exists := os.Stat(folder); os.IsNotExist(exists) {
 		os.Mkdir(folder, 0744)
 	}
@@ -127,12 +159,5 @@ func dumpLogIntoFile(folder, name string, log []pb.Command) error {
 		return err
 	}
 	defer out.Close()
-
-	for _, cmd := range log {
-		_, err = fmt.Fprintf(out, "%d %s %v\n", cmd.Op, cmd.Key, cmd.Value)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return bl.ExportLog(out, format, log)
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+	"github.com/Lz-Gustavo/beelog/workload"
+)
+
+// StructID indexes different structs available for log representation.
+type StructID int8
+
+const (
+	// LogList maps the command log as a ListHT, a linked list of state
+	// updates for each key.
+	LogList StructID = iota
+
+	// LogAVL represents the log as an underlying AVL tree, indexed by
+	// command indexes. Only state updates (i.e. Writes) are mapped into
+	// nodes. An auxilary hash table is used to track state updates over
+	// a particular key.
+	LogAVL
+
+	// LogDAG is a work-in-progress log representation as an underlying
+	// BST with a state updates being mapped as a DAG. The tree nodes
+	// represent particular keys, each pointing to the first node of the
+	// graph. The main purpose of this approach is to track dependencies
+	// between multiple-key operations (i.e. SWAPS).
+	LogDAG
+
+	// LogArray maps the command log as an ArrayHT, an array of state updates
+	// for each key.
+	LogArray
+
+	// LogCircBuff maps the command log as a CircBuffHT, a circular buffer
+	// reduced on a background routine, sized to the entire command log.
+	LogCircBuff
+
+	// LogConcTable maps the command log as a ConcTable, a concurrent table
+	// of views reduced on background routines.
+	LogConcTable
+)
+
+// defaultConcLevel is the number of views of ConcTable structures, if none is
+// configured.
+const defaultConcLevel = 2
+
+// NewStructure returns a new structure of representation 'id' configured by 'cfg',
+// or by the default config if none is provided. CircBuffHT structures are sized to
+// 'n' commands, and ConcTables have 'concLvl' views.
+func NewStructure(id StructID, n, concLvl int, cfg *bl.LogConfig) (bl.Structure, error) {
+	if cfg == nil {
+		cfg = bl.DefaultLogConfig()
+	}
+	if concLvl == 0 {
+		concLvl = defaultConcLevel
+	}
+
+	switch id {
+	case LogList:
+		return bl.NewListHTWithConfig(cfg)
+
+	case LogAVL:
+		return bl.NewAVLTreeHTWithConfig(cfg)
+
+	case LogArray:
+		return bl.NewArrayHTWithConfig(cfg)
+
+	case LogCircBuff:
+		return bl.NewCircBuffHTWithConfig(context.Background(), cfg, n)
+
+	case LogConcTable:
+		return bl.NewConcTableWithConfig(context.Background(), concLvl, cfg)
+
+	default:
+		return nil, fmt.Errorf("unsupported log structure %d", id)
+	}
+}
+
+// waitPending blocks until every reduce pending on background routines of 'st', if
+// any, is completed.
+func waitPending(st bl.Structure) {
+	pd, ok := st.(interface{ Pending() int })
+	if !ok {
+		return
+	}
+	for pd.Pending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// shutdown releases every resource held by 'st', if any.
+func shutdown(st bl.Structure) {
+	if sd, ok := st.(interface{ Shutdown() }); ok {
+		sd.Shutdown()
+	}
+}
+
+// Payload identifies the type of value carried by generated commands.
+type Payload string
+
+const (
+	// StringPayload carries a random integer formatted as the string Value.
+	StringPayload Payload = "string"
+
+	// BytesPayload carries 'payloadSize' random bytes as a typed payload.
+	BytesPayload Payload = "bytes"
+
+	// IntPayload carries a random integer as a typed payload.
+	IntPayload Payload = "int"
+
+	// FloatPayload carries a random float as a typed payload.
+	FloatPayload Payload = "float"
+)
+
+// payloadSize is the number of random bytes carried on BytesPayload commands.
+const payloadSize = 32
+
+// ParsePayload returns the Payload identified by 's', defaulting to StringPayload
+// if none is informed.
+func ParsePayload(s string) (Payload, error) {
+	switch pl := Payload(s); pl {
+	case "":
+		return StringPayload, nil
+
+	case StringPayload, BytesPayload, IntPayload, FloatPayload:
+		return pl, nil
+
+	default:
+		return "", fmt.Errorf("unknown payload type '%s'", s)
+	}
+}
+
+// fill sets a random value of type 'pl' on 'cmd'.
+func (pl Payload) fill(cmd *pb.Command, r *rand.Rand) {
+	cmd.Value = ""
+	switch pl {
+	case BytesPayload:
+		b := make([]byte, payloadSize)
+		r.Read(b)
+		cmd.Typed = &pb.Command_BytesValue{BytesValue: b}
+
+	case IntPayload:
+		cmd.Typed = &pb.Command_IntValue{IntValue: r.Int63()}
+
+	case FloatPayload:
+		cmd.Typed = &pb.Command_FloatValue{FloatValue: r.Float64()}
+
+	default:
+		cmd.Value = strconv.Itoa(r.Int())
+	}
+}
+
+// Generate logs a random command stream on a new structure of representation 'id',
+// configured as in NewStructure. 'n' is the total number of commands; 'wrt' the
+// write percentage of that randomized load profile; 'dif' the number of different
+// keys to be considered; 'dist' the distribution of selected keys; and 'pl' the
+// type of value carried by each command. Only writes are logged.
+func Generate(id StructID, n, wrt, dif int, dist workload.Distribution, pl Payload, concLvl int, cfg *bl.LogConfig) (bl.Structure, error) {
+	srand := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(srand)
+
+	st, err := NewStructure(id, n, concLvl, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := newCommandGen(wrt, dif, dist)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < n; i++ {
+		if cmd := g.Next(); cmd.Op == pb.Command_SET {
+			pl.fill(&cmd, r)
+			if err := st.Log(cmd); err != nil {
+				return nil, err
+			}
+		}
+	}
+	waitPending(st)
+	return st, nil
+}
+
+// newCommandGen returns a random command generator with 'wrt' percent of writes over
+// 'dif' keys, selected following 'dist'.
+func newCommandGen(wrt, dif int, dist workload.Distribution) (*workload.Generator, error) {
+	return workload.New(workload.Config{
+		Keys:   dif,
+		Dist:   dist,
+		Read:   float64(100-wrt) / 100,
+		Update: float64(wrt) / 100,
+		Seed:   time.Now().UnixNano(),
+	})
+}
+
+// LogFormat identifies the encoding of static input logs.
+type LogFormat string
+
+const (
+	// AutoFormat detects the encoding of each input log, interpreting logs starting
+	// with the versioned beelog header as BinaryFormat, and any other as TextFormat.
+	AutoFormat LogFormat = ""
+
+	// TextFormat is the custom defined log format, where each line is an operation,
+	// key and value separated by spaces.
+	TextFormat LogFormat = "text"
+
+	// BinaryFormat is any log serialized by beelog, either reduced or traditional
+	// (i.e. captured from production replicas, or written by beelogctl convert),
+	// including legacy headerless logs.
+	BinaryFormat LogFormat = "binary"
+)
+
+// ParseLogFormat returns the LogFormat identified by 's', defaulting to AutoFormat
+// if none is informed.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch lf := LogFormat(s); lf {
+	case AutoFormat, TextFormat, BinaryFormat:
+		return lf, nil
+
+	default:
+		return "", fmt.Errorf("unknown log format '%s'", s)
+	}
+}
+
+// Replay logs every command of the input log 'fn', encoded on 'lf', on a new structure
+// of representation 'id', configured as in NewStructure, returning the number of
+// replayed commands. Commands are logged on their original order, but renumbered by
+// their position on the input log, so replayed logs are always indexed from zero, as
+// random ones.
+func Replay(id StructID, fn string, lf LogFormat, concLvl int, cfg *bl.LogConfig) (bl.Structure, int, error) {
+	log, err := readLog(fn, lf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ln := len(log)
+	if ln == 0 {
+		return nil, 0, errors.New("empty logfile informed")
+	}
+
+	st, err := NewStructure(id, ln, concLvl, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, cmd := range log {
+		cmd.Id = uint64(i)
+		err := st.Log(cmd)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	waitPending(st)
+	return st, ln, nil
+}
+
+// readLog interprets the input log 'fn' encoded on 'lf'.
+func readLog(fn string, lf LogFormat) ([]pb.Command, error) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if lf == AutoFormat {
+		lf = TextFormat
+		if bytes.HasPrefix(raw, []byte("BEELOG")) {
+			lf = BinaryFormat
+		}
+	}
+
+	if lf == BinaryFormat {
+		log, err := bl.UnmarshalLogFromBytes(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding log '%s', err: '%s'", fn, err.Error())
+		}
+		return log, nil
+	}
+	return parseLog(bytes.NewReader(raw))
+}
+
+// parseLog interprets the custom defined log format, equivalent to the string
+// representation of the pb.Command struct.
+func parseLog(rd io.Reader) ([]pb.Command, error) {
+	log := make([]pb.Command, 0)
+	sc := bufio.NewScanner(rd)
+
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Split(line, " ")
+
+		op, _ := strconv.Atoi(fields[0])
+		key := fields[1]
+		value := fields[2]
+
+		cmd := pb.Command{
+			Op:    pb.Command_Operation(op),
+			Key:   key,
+			Value: value,
+		}
+		log = append(log, cmd)
+	}
+	return log, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+	"github.com/Lz-Gustavo/beelog/workload"
+)
+
+func TestInit(t *testing.T) {
+	fs, err := parseDir("./input/")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	err = initTestCases(fs)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+// structs are every structure supported by NewStructure, and their reduce algorithm.
+var structs = map[StructID]bl.Reducer{
+	LogList:      bl.GreedyLt,
+	LogAVL:       bl.IterDFSAvl,
+	LogArray:     bl.GreedyArray,
+	LogCircBuff:  bl.IterCircBuff,
+	LogConcTable: bl.IterConcTable,
+}
+
+func TestGenerate(t *testing.T) {
+	for id, alg := range structs {
+		st, err := Generate(id, 100, 50, 10, workload.Zipfian, BytesPayload, 0, nil)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		log, err := bl.ApplyReduceAlgo(st, alg, 0, 99)
+		if err != nil {
+			t.Log(id, err.Error())
+			t.FailNow()
+		}
+		if len(log) == 0 || len(log) > 10 {
+			t.Log(id, ": expected at most one command per key, got", len(log))
+			t.FailNow()
+		}
+		shutdown(st)
+	}
+
+	if _, err := Generate(LogDAG, 100, 50, 10, workload.Uniform, StringPayload, 0, nil); err == nil {
+		t.Log("expected an error on unsupported structures")
+		t.FailNow()
+	}
+}
+
+func TestReplay(t *testing.T) {
+	for id, alg := range structs {
+		st, ln, err := Replay(id, "input/logavl-a.log", AutoFormat, 0, nil)
+		if err != nil {
+			t.Log(id, err.Error())
+			t.FailNow()
+		}
+		if _, err := bl.ApplyReduceAlgo(st, alg, 0, uint64(ln-1)); err != nil {
+			t.Log(id, err.Error())
+			t.FailNow()
+		}
+		shutdown(st)
+	}
+}
+
+func TestTestCaseConfig(t *testing.T) {
+	tc, err := newTestCase([]byte(`
+Name="conctable"
+Struct=5
+NumCmds=100
+PercentWrites=50
+NumDiffKeys=10
+Iterations=1
+Algo=[6]
+ConcLevel=4
+
+[Config]
+Inmem=true
+Tick=2
+Period=20
+MaxReduces=1
+`))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if tc.Config == nil || tc.Config.Tick != bl.Interval || tc.Config.Period != 20 || tc.Config.MaxReduces != 1 {
+		t.Log("unexpected decoded config:", tc.Config)
+		t.FailNow()
+	}
+
+	// invalid structure configs are rejected
+	if _, err := newTestCase([]byte(`
+Name="invalid"
+Struct=0
+Iterations=1
+Algo=[0]
+
+[Config]
+Inmem=false
+`)); err == nil {
+		t.Log("expected an error on an invalid config")
+		t.FailNow()
+	}
+}
+
+func TestBinaryLogReplay(t *testing.T) {
+	log := make([]pb.Command, 0)
+	for i := 0; i < 100; i++ {
+		log = append(log, pb.Command{Id: uint64(1000 + i), Op: pb.Command_SET, Key: strconv.Itoa(i % 10), Value: strconv.Itoa(i)})
+	}
+
+	marshalers := map[string]func(io.Writer, *[]pb.Command, uint64, uint64) error{
+		"beelog": bl.MarshalLogIntoWriter,
+		"trad":   bl.MarshalTradLogIntoWriter,
+	}
+	for name, marshal := range marshalers {
+		fn := filepath.Join(t.TempDir(), name+".log")
+		buf := bytes.NewBuffer(nil)
+		if err := marshal(buf, &log, 1000, 1099); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		for id := range structs {
+			st, ln, err := Replay(id, fn, AutoFormat, 0, nil)
+			if err != nil {
+				t.Log(name, err.Error())
+				t.FailNow()
+			}
+			if ln != len(log) || st.LastIndex() != uint64(len(log)-1) {
+				t.Log(name, ": expected", len(log), "replayed commands, got", ln, "up to index", st.LastIndex())
+				t.FailNow()
+			}
+			shutdown(st)
+		}
+	}
+
+	// binary logs are not interpreted as text
+	if _, _, err := Replay(LogAVL, "input/logavl-a.log", BinaryFormat, 0, nil); err == nil {
+		t.Log("expected an error decoding a text log as binary")
+		t.FailNow()
+	}
+}
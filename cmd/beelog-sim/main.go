@@ -1,6 +1,14 @@
+// Command beelog-sim evaluates reduce algorithms over beelog structures, logging
+// random or static command logs configured by the .toml test cases of an input
+// directory, and writing each reduced log under ./output/.
+//
+// Usage:
+//
+//	beelog-sim [-input dir]
 package main
 
 import (
+	"flag"
 	"io/ioutil"
 	"log"
 	"os"
@@ -12,18 +20,19 @@ var (
 	testCases []*TestCase
 )
 
-func init() {
-	fs, err := parseDir("./input/")
+func main() {
+	input := flag.String("input", "./input/", "directory of .toml test cases")
+	flag.Parse()
+
+	fs, err := parseDir(*input)
 	if err != nil {
-		log.Fatalln("could not load current dir:", err.Error())
+		log.Fatalln("could not load input dir:", err.Error())
 	}
 	err = initTestCases(fs)
 	if err != nil {
 		log.Fatalln("could not init test case:", err.Error())
 	}
-}
 
-func main() {
 	for _, t := range testCases {
 		err := t.run()
 		if err != nil {
@@ -41,7 +50,7 @@ func parseDir(path string) ([]string, error) {
 	var fns []string
 	for _, f := range ent {
 		if !f.IsDir() && strings.Compare(filepath.Ext(f.Name()), ".toml") == 0 {
-			fns = append(fns, path+f.Name())
+			fns = append(fns, filepath.Join(path, f.Name()))
 		}
 	}
 	return fns, nil
@@ -0,0 +1,304 @@
+// Command beelogctl inspects and converts serialized beelog files.
+//
+// Usage:
+//
+//	beelogctl inspect [-top N] <file>
+//	beelogctl cat [-format json|csv|text] <file>
+//	beelogctl convert -to beelog|trad <in> <out>
+//	beelogctl verify [-against <original>] <file>
+//	beelogctl compact [-alg N] <in> <out>
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+type subcommand struct {
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"inspect": {"inspect [-top N] <file>: print header, op counts and key histogram", runInspect},
+		"cat":     {"cat [-format json|csv|text] <file>: decode commands", runCat},
+		"convert": {"convert -to beelog|trad <in> <out>: convert between log formats", runConvert},
+		"verify":  {"verify [-against <original>] <file>: validate log structure and EOL mark, printing its checksum, and its equivalence to an original log", runVerify},
+		"compact": {"compact [-alg N] <in> <out>: offline reduce of a traditional log into beelog format", runCompact},
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("beelogctl: ")
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	sc, ok := subcommands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := sc.run(os.Args[2:]); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(subcommands))
+	for n := range subcommands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: beelogctl <subcommand> [flags] [args]")
+	for _, n := range names {
+		fmt.Fprintln(os.Stderr, "  "+subcommands[n].usage)
+	}
+}
+
+// readLog parses the header and every command of the log file 'fn'. Logs on
+// ProtoLogFormatVersion carry no text header, which is then built from the interval
+// covered by their segments.
+func readLog(fn string) (bl.LogHeader, []pb.Command, error) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return bl.LogHeader{}, nil, err
+	}
+
+	_, hdr, err := bl.ReadLogHeader(bytes.NewReader(raw))
+	if err != nil && hdr.Version == bl.ProtoLogFormatVersion {
+		return readProtoLog(fn, raw)
+	} else if err != nil {
+		return hdr, nil, fmt.Errorf("failed reading header of '%s', err: '%s'", fn, err.Error())
+	}
+
+	cmds, err := bl.UnmarshalLogFromBytes(raw)
+	if err != nil {
+		return hdr, nil, fmt.Errorf("failed decoding '%s', err: '%s'", fn, err.Error())
+	}
+	return hdr, cmds, nil
+}
+
+// readProtoLog parses every segment of the ProtoLogFormatVersion log 'raw', read
+// from 'fn'.
+func readProtoLog(fn string, raw []byte) (bl.LogHeader, []pb.Command, error) {
+	hdr := bl.LogHeader{Version: bl.ProtoLogFormatVersion}
+	segs, err := bl.UnmarshalProtoLog(bytes.NewReader(raw))
+	if err != nil {
+		return hdr, nil, fmt.Errorf("failed decoding '%s', err: '%s'", fn, err.Error())
+	}
+
+	var cmds []pb.Command
+	for i, s := range segs {
+		if i == 0 || s.First < hdr.First {
+			hdr.First = s.First
+		}
+		if s.Last > hdr.Last {
+			hdr.Last = s.Last
+		}
+		cmds = append(cmds, s.Commands...)
+	}
+	hdr.Len = len(cmds)
+	return hdr, cmds, nil
+}
+
+func formatName(hdr bl.LogHeader) string {
+	if hdr.Version == bl.ProtoLogFormatVersion {
+		return "proto"
+	}
+	if hdr.Len < 0 {
+		return "trad"
+	}
+	return "beelog"
+}
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	top := fs.Int("top", 10, "number of most frequent keys to print")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s", subcommands["inspect"].usage)
+	}
+
+	hdr, cmds, err := readLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d\nformat: %s\nfirst: %d\nlast: %d\ncommands: %d\n",
+		hdr.Version, formatName(hdr), hdr.First, hdr.Last, len(cmds))
+
+	ops := make(map[pb.Command_Operation]int)
+	keys := make(map[string]int)
+	for _, c := range cmds {
+		ops[c.Op]++
+		keys[c.Key]++
+	}
+
+	fmt.Println("operations:")
+	for op := 0; op < len(pb.Command_Operation_name); op++ {
+		if n := ops[pb.Command_Operation(op)]; n > 0 {
+			fmt.Printf("  %s: %d\n", pb.Command_Operation(op), n)
+		}
+	}
+
+	type keyCount struct {
+		key string
+		n   int
+	}
+	hist := make([]keyCount, 0, len(keys))
+	for k, n := range keys {
+		hist = append(hist, keyCount{k, n})
+	}
+	sort.Slice(hist, func(i, j int) bool {
+		if hist[i].n == hist[j].n {
+			return hist[i].key < hist[j].key
+		}
+		return hist[i].n > hist[j].n
+	})
+
+	fmt.Printf("keys: %d distinct\n", len(hist))
+	for i := 0; i < len(hist) && i < *top; i++ {
+		fmt.Printf("  %s: %d\n", hist[i].key, hist[i].n)
+	}
+	return nil
+}
+
+func runCat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	format := fs.String("format", "json", "output format: json, csv or text")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s", subcommands["cat"].usage)
+	}
+
+	ft, err := bl.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	_, cmds, err := readLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return bl.ExportLog(os.Stdout, ft, cmds)
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", "beelog", "output log format: beelog or trad")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: %s", subcommands["convert"].usage)
+	}
+
+	hdr, cmds, err := readLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return writeLog(fs.Arg(1), func(w io.Writer) error {
+		switch *to {
+		case "beelog":
+			return bl.MarshalLogIntoWriter(w, &cmds, hdr.First, hdr.Last)
+		case "trad":
+			return bl.MarshalTradLogIntoWriter(w, &cmds, hdr.First, hdr.Last)
+		default:
+			return fmt.Errorf("unknown log format '%s'", *to)
+		}
+	})
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	against := fs.String("against", "", "original log the verified one must be equivalent to, once executed")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: %s", subcommands["verify"].usage)
+	}
+
+	fn := fs.Arg(0)
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	// beelog logs are only valid if their EOL mark is present, which is checked
+	// during unmarshal
+	hdr, cmds, err := readLog(fn)
+	if err != nil {
+		return err
+	}
+
+	if hdr.Len >= 0 && len(cmds) != hdr.Len {
+		return fmt.Errorf("header records %d commands, but found %d", hdr.Len, len(cmds))
+	}
+	for _, c := range cmds {
+		if c.Id < hdr.First || c.Id > hdr.Last {
+			return fmt.Errorf("command %d outside of the recorded interval [%d, %d]", c.Id, hdr.First, hdr.Last)
+		}
+	}
+
+	if *against != "" {
+		_, orig, err := readLog(*against)
+		if err != nil {
+			return err
+		}
+		res := bl.VerifyReducedLog(orig, cmds)
+		for _, d := range res.Divergences {
+			fmt.Printf("divergent key '%s': original %s, reduced %s\n", d.Key, stateString(d.Original), stateString(d.Reduced))
+		}
+		if !res.Equivalent {
+			return fmt.Errorf("%d keys diverge from '%s'", len(res.Divergences), *against)
+		}
+	}
+
+	fmt.Printf("ok: %s log, %d commands, crc32 %08x\n", formatName(hdr), len(cmds), crc32.ChecksumIEEE(raw))
+	return nil
+}
+
+// stateString returns a printable representation of the key state defined by 'c',
+// which is absent if nil.
+func stateString(c *pb.Command) string {
+	if c == nil {
+		return "absent"
+	}
+	return fmt.Sprintf("'%s' (index %d)", c.ValueString(), c.Id)
+}
+
+func runCompact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	alg := fs.Int("alg", int(bl.GreedyLt), "reduce algorithm identifier")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: %s", subcommands["compact"].usage)
+	}
+	return bl.CompactLogFile(fs.Arg(0), fs.Arg(1), bl.Reducer(*alg))
+}
+
+func writeLog(fn string, marshal func(w io.Writer) error) error {
+	fd, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return marshal(fd)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// fixtures writes a log of 'cmds' on every supported format into 'dir', along with
+// a truncated one, returning their paths by name.
+func fixtures(t *testing.T, dir string, cmds []pb.Command) map[string]string {
+	t.Helper()
+	last := cmds[len(cmds)-1].Id
+	half := cmds[:len(cmds)/2]
+	rest := cmds[len(cmds)/2:]
+
+	writers := map[string]func(w io.Writer) error{
+		"beelog": func(w io.Writer) error { return bl.MarshalLogIntoWriter(w, &cmds, 1, last) },
+		"trad":   func(w io.Writer) error { return bl.MarshalTradLogIntoWriter(w, &cmds, 1, last) },
+		"proto": func(w io.Writer) error {
+			// segments are appended over consecutive intervals
+			if err := bl.MarshalProtoLogIntoWriter(w, &half, 1, half[len(half)-1].Id); err != nil {
+				return err
+			}
+			return bl.AppendProtoSegment(w, &rest, rest[0].Id, last)
+		},
+	}
+
+	fns := make(map[string]string)
+	for name, wr := range writers {
+		fns[name] = filepath.Join(dir, name+".log")
+		if err := writeLog(fns[name], wr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := ioutil.ReadFile(fns["beelog"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns["truncated"] = filepath.Join(dir, "truncated.log")
+	if err := ioutil.WriteFile(fns["truncated"], raw[:len(raw)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	fns["missing"] = filepath.Join(dir, "missing.log")
+	return fns
+}
+
+// testLog returns 'n' writes over 3 keys, indexed from 1.
+func testLog(n int) []pb.Command {
+	cmds := make([]pb.Command, 0, n)
+	for i := 1; i <= n; i++ {
+		cmds = append(cmds, pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 3), Value: strconv.Itoa(i)})
+	}
+	return cmds
+}
+
+// runCapture runs the subcommand 'name' with 'args', returning everything written
+// into the standard output.
+func runCapture(t *testing.T, name string, args ...string) (string, error) {
+	t.Helper()
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	err = subcommands[name].run(args)
+	os.Stdout = stdout
+
+	raw, rerr := ioutil.ReadFile(out.Name())
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(raw), err
+}
+
+func TestReadLog(t *testing.T) {
+	cmds := testLog(10)
+	fns := fixtures(t, t.TempDir(), cmds)
+
+	testCases := []struct {
+		file    string
+		version uint8
+		len     int
+		wantErr bool
+	}{
+		{"beelog", bl.LogFormatVersion, 10, false},
+		{"trad", bl.LogFormatVersion, -1, false},
+		{"proto", bl.ProtoLogFormatVersion, 10, false},
+		{"truncated", 0, 0, true},
+		{"missing", 0, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			hdr, got, err := readLog(fns[tc.file])
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Version != tc.version || hdr.First != 1 || hdr.Last != 10 || hdr.Len != tc.len {
+				t.Fatalf("unexpected header %+v", hdr)
+			}
+			if len(got) != len(cmds) {
+				t.Fatalf("read %d commands, expected %d", len(got), len(cmds))
+			}
+			for i := range cmds {
+				if got[i].String() != cmds[i].String() {
+					t.Fatalf("read command %v, expected %v", got[i].String(), cmds[i].String())
+				}
+			}
+		})
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	dir := t.TempDir()
+	cmds := testLog(10)
+	fns := fixtures(t, dir, cmds)
+
+	// reduced log diverging from the original on key "1"
+	divergent := filepath.Join(dir, "divergent.log")
+	reduced := []pb.Command{
+		{Id: 8, Op: pb.Command_SET, Key: "2", Value: "8"},
+		{Id: 9, Op: pb.Command_SET, Key: "0", Value: "9"},
+		{Id: 10, Op: pb.Command_SET, Key: "1", Value: "7"},
+	}
+	if err := writeLog(divergent, func(w io.Writer) error {
+		return bl.MarshalLogIntoWriter(w, &reduced, 1, 10)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		cmd     string
+		args    []string
+		want    []string // substrings of the output
+		wantErr bool
+		check   func(t *testing.T) // additional checks over written files
+	}{
+		{"InspectBeelog", "inspect", []string{fns["beelog"]}, []string{"version: 1\n", "format: beelog\n", "commands: 10\n", "SET: 10\n", "keys: 3 distinct\n"}, false, nil},
+		{"InspectTrad", "inspect", []string{fns["trad"]}, []string{"format: trad\n", "commands: 10\n"}, false, nil},
+		{"InspectProto", "inspect", []string{"-top", "1", fns["proto"]}, []string{"version: 2\n", "format: proto\n", "first: 1\nlast: 10\n", "commands: 10\n", "  1: 4\n"}, false, nil},
+		{"InspectTruncated", "inspect", []string{fns["truncated"]}, nil, true, nil},
+		{"InspectMissing", "inspect", []string{fns["missing"]}, nil, true, nil},
+		{"InspectNoArgs", "inspect", nil, nil, true, nil},
+
+		{"CatJSON", "cat", []string{fns["proto"]}, []string{`"key":"1"`}, false, nil},
+		{"CatCSV", "cat", []string{"-format", "csv", fns["trad"]}, []string{"10,SET,1,,10"}, false, nil},
+		{"CatUnknownFormat", "cat", []string{"-format", "xml", fns["beelog"]}, nil, true, nil},
+		{"CatTruncated", "cat", []string{fns["truncated"]}, nil, true, nil},
+
+		{"ConvertProtoToBeelog", "convert", []string{"-to", "beelog", fns["proto"], filepath.Join(dir, "conv.log")}, nil, false, func(t *testing.T) {
+			hdr, got, err := readLog(filepath.Join(dir, "conv.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Version != bl.LogFormatVersion || hdr.First != 1 || hdr.Last != 10 || len(got) != len(cmds) {
+				t.Fatalf("unexpected converted log %+v with %d commands", hdr, len(got))
+			}
+		}},
+		{"ConvertBeelogToTrad", "convert", []string{"-to", "trad", fns["beelog"], filepath.Join(dir, "conv.trad.log")}, nil, false, func(t *testing.T) {
+			hdr, got, err := readLog(filepath.Join(dir, "conv.trad.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Len != -1 || len(got) != len(cmds) {
+				t.Fatalf("unexpected converted log %+v with %d commands", hdr, len(got))
+			}
+		}},
+		{"ConvertUnknownFormat", "convert", []string{"-to", "xml", fns["beelog"], filepath.Join(dir, "conv.xml")}, nil, true, nil},
+		{"ConvertMissing", "convert", []string{fns["missing"], filepath.Join(dir, "conv.missing.log")}, nil, true, nil},
+
+		{"VerifyBeelog", "verify", []string{fns["beelog"]}, []string{"ok: beelog log, 10 commands, crc32 "}, false, nil},
+		{"VerifyProto", "verify", []string{fns["proto"]}, []string{"ok: proto log, 10 commands, crc32 "}, false, nil},
+		{"VerifyAgainstOriginal", "verify", []string{"-against", fns["trad"], fns["proto"]}, []string{"ok: proto log"}, false, nil},
+		{"VerifyDivergent", "verify", []string{"-against", fns["trad"], divergent}, []string{"divergent key '1': original '10' (index 10), reduced '7' (index 10)"}, true, nil},
+		{"VerifyTruncated", "verify", []string{fns["truncated"]}, nil, true, nil},
+
+		{"CompactTrad", "compact", []string{fns["trad"], filepath.Join(dir, "compact.log")}, nil, false, func(t *testing.T) {
+			hdr, got, err := readLog(filepath.Join(dir, "compact.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Len != 3 || len(got) != 3 {
+				t.Fatalf("expected the 3 latest states, got %+v with %d commands", hdr, len(got))
+			}
+		}},
+		{"CompactMissing", "compact", []string{fns["missing"], filepath.Join(dir, "compact.missing.log")}, nil, true, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runCapture(t, tc.cmd, tc.args...)
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected an error, got output %q", out)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(out, w) {
+					t.Fatalf("expected %q on output %q", w, out)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t)
+			}
+		})
+	}
+}
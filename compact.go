@@ -0,0 +1,152 @@
+package beelog
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// CompactLogFile reduces the log persisted at 'src', typically on the traditional
+// format, writing the compacted log into 'dst' on beelog format. Commands are streamed
+// from 'src' into a structure supporting 'alg', avoiding loading the entire raw log
+// in memory, which allows the migration of pre-existing replica logs without a running
+// structure. 'dst' is only replaced once the compacted log is completely written.
+func CompactLogFile(src, dst string, alg Reducer) error {
+	fd, err := os.OpenFile(src, os.O_RDONLY, 0400)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	rd, hdr, err := ReadLogHeader(bufio.NewReader(fd))
+	if err != nil {
+		return fmt.Errorf("failed reading header of '%s', err: '%s'", src, err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st, err := newStructureForReducer(ctx, alg, hdr)
+	if err != nil {
+		return err
+	}
+
+	// structures may hold background routines or temporary files
+	if sd, ok := st.(interface{ Shutdown() }); ok {
+		defer sd.Shutdown()
+	}
+
+	for i := 0; hdr.Len < 0 || i < hdr.Len; i++ {
+		cmd, err := readCommand(rd, hdr.Version)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed decoding '%s', err: '%s'", src, err.Error())
+		}
+
+		if err = st.Log(cmd); err != nil {
+			return err
+		}
+	}
+
+	if st.Len() == 0 {
+		return fmt.Errorf("empty log '%s'", src)
+	}
+
+	log, err := ApplyReduceAlgo(st, alg, hdr.First, hdr.Last)
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	// compacted logs retain the codec of their source
+	bw := bufio.NewWriter(out)
+	err = MarshalLogWithCodec(bw, codecOf(hdr.Version), &log, hdr.First, hdr.Last)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// newStructureForReducer returns an empty structure supporting 'alg', sized to
+// the log interval of 'hdr' when necessary.
+func newStructureForReducer(ctx context.Context, alg Reducer, hdr LogHeader) (Structure, error) {
+	switch alg {
+	case GreedyLt:
+		return NewListHT(), nil
+
+	case GreedyArray:
+		return NewArrayHT(), nil
+
+	case GreedyAvl, IterBFSAvl, IterDFSAvl:
+		return NewAVLTreeHT(), nil
+
+	case GreedySkip:
+		return NewSkipListHT(), nil
+
+	case IterRadix:
+		return NewRadixHT(), nil
+
+	case IterBTree:
+		return NewBTreeHT()
+
+	case IterCircBuff:
+		cfg := DefaultLogConfig()
+		cfg.Alg = IterCircBuff
+		return NewCircBuffHTWithConfig(ctx, cfg, int(hdr.Last-hdr.First)+1)
+
+	case IterConcTable:
+		return NewConcTable(ctx), nil
+
+	case MergeLSM:
+		return NewLSMLog(ctx), nil
+
+	default:
+		return nil, errors.New("unsupported reduce algorithm")
+	}
+}
+
+// readCommand decodes a single command from 'rd', prefixed by its binary encoded
+// size, 32b, BigEndian format.
+func readCommand(rd io.Reader, version uint8) (pb.Command, error) {
+	var cmdLen int32
+	if err := binary.Read(rd, binary.BigEndian, &cmdLen); err != nil {
+		return pb.Command{}, err
+	}
+	if err := checkCommandLen(cmdLen); err != nil {
+		return pb.Command{}, err
+	}
+
+	raw := make([]byte, cmdLen)
+	if _, err := io.ReadFull(rd, raw); err != nil {
+		return pb.Command{}, err
+	}
+
+	c := &pb.Command{}
+	if err := decodeCommand(version, raw, c); err != nil {
+		return pb.Command{}, err
+	}
+	return *c, nil
+}
@@ -1,22 +1,18 @@
 package beelog
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"log"
-	"math/rand"
-	"os"
-	"path/filepath"
+	"io/ioutil"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/Lz-Gustavo/beelog/pb"
 )
@@ -28,8 +24,17 @@ const (
 	// number of commands to wait until a complete state reset for Immediately
 	// reduce period.
 	resetOnImmediately int = 4000
+
+	// minimum number of keys on a view to reduce it through multiple goroutines,
+	// smaller views are iterated by a single one.
+	parIterThreshold int = 100000
 )
 
+// ErrBusy is returned by 'Log()' calls on ConcTable structures configured with the
+// RejectWhenBusy policy, when the command would trigger a new reduce request while
+// the logger routine is still behind on prior ones.
+var ErrBusy = errors.New("logger routine is busy, too many pending reduce requests")
+
 // logEvent represents a event metadata passed to logger routines signalling a persistence
 // to a certain table, and the array position to store the measurement data.
 type logEvent struct {
@@ -38,20 +43,45 @@ type logEvent struct {
 
 // ConcTable ...
 type ConcTable struct {
-	views []minStateTable
-	mu    []sync.Mutex
-	logs  []logData
-	canc  context.CancelFunc
+	views  []minStateTable
+	shared []bool        // views detached by snapshots, cloned before their next write
+	order  [][]buffEntry // index-ordered writes of each view, utilized by RecovSince
+	busy   []int32       // atomic, flags views with a pending reduce request
+	mu     []*sync.Mutex
+	logs   []logData
+	canc   context.CancelFunc
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
 
 	concLevel int
 	loggerReq chan logEvent
 	curMu     sync.Mutex
+	lvlMu     sync.RWMutex // guards concLevel resizes against logging and recoveries
+	seq       *sequencer   // nil on UncheckedOrder config, shared by every view
 	current   int
-	prevLog   int32 // atomic
+	lastInd   uint64 // guarded by curMu
+	prevLog   int32  // atomic
 	logFolder string
+	logGlobs  []string // match every log file persisted by the table, one for each disk
 
-	msr bool
+	// latency measurement is toggled at runtime through 'msr', while 'lm' is only
+	// initialized under 'curMu', retaining captured tuples once disabled
+	msr int32 // atomic
 	lm  *latencyMeasure
+
+	// views sharing the same persisted state are serialized when reduced by multiple
+	// logger routines, retaining the last persisted index of each disk
+	workers   int
+	persistMu sync.Mutex
+	persisted []uint64
+
+	// atomic, counts reduces to stripe disks on RoundRobin configs
+	reduces uint32
+
+	// guards entire log recoveries against the removal of obsolete segments
+	segMu sync.RWMutex
 }
 
 // NewConcTable ...
@@ -59,24 +89,32 @@ func NewConcTable(ctx context.Context) *ConcTable {
 	c, cancel := context.WithCancel(ctx)
 	ct := &ConcTable{
 		canc:      cancel,
+		ctx:       c,
 		loggerReq: make(chan logEvent, chanBuffSize),
 		concLevel: defaultConcLvl,
 
-		views: make([]minStateTable, defaultConcLvl, defaultConcLvl),
-		mu:    make([]sync.Mutex, defaultConcLvl, defaultConcLvl),
-		logs:  make([]logData, defaultConcLvl, defaultConcLvl),
+		views:  make([]minStateTable, defaultConcLvl, defaultConcLvl),
+		shared: make([]bool, defaultConcLvl, defaultConcLvl),
+		order:  make([][]buffEntry, defaultConcLvl, defaultConcLvl),
+		busy:   make([]int32, defaultConcLvl, defaultConcLvl),
+		mu:     make([]*sync.Mutex, defaultConcLvl, defaultConcLvl),
+		logs:   make([]logData, defaultConcLvl, defaultConcLvl),
 	}
 
 	def := *DefaultLogConfig()
 	def.Alg = IterConcTable
+	ld := newLogData(&def)
+	ct.seq = ld.seq
 	for i := 0; i < defaultConcLvl; i++ {
-		ct.logs[i] = logData{config: &def}
+		ct.mu[i] = &sync.Mutex{}
+		ct.logs[i] = ld
 		ct.views[i] = make(minStateTable, 0)
 	}
 	ct.logFolder = extractLocation(def.Fname)
+	ct.logGlobs = []string{ct.logFolder + "*.log"}
 
 	// Measure disabled in default config
-	go ct.handleReduce(c, false)
+	go ct.handleReduce(c)
 	return ct
 }
 
@@ -89,95 +127,345 @@ func NewConcTableWithConfig(ctx context.Context, concLvl int, cfg *LogConfig) (*
 	if concLvl < 0 {
 		return nil, errors.New("must inform a positive value for 'concLevel' argument")
 	}
+	if err := checkHistorySupported(cfg, ConcTableKind); err != nil {
+		return nil, err
+	}
 
 	c, cancel := context.WithCancel(ctx)
 	ct := &ConcTable{
 		canc:      cancel,
+		ctx:       c,
 		loggerReq: make(chan logEvent, chanBuffSize),
 		concLevel: concLvl,
 
-		views: make([]minStateTable, concLvl, concLvl),
-		mu:    make([]sync.Mutex, concLvl, concLvl),
-		logs:  make([]logData, concLvl, concLvl),
+		views:  make([]minStateTable, concLvl, concLvl),
+		shared: make([]bool, concLvl, concLvl),
+		order:  make([][]buffEntry, concLvl, concLvl),
+		busy:   make([]int32, concLvl, concLvl),
+		mu:     make([]*sync.Mutex, concLvl, concLvl),
+		logs:   make([]logData, concLvl, concLvl),
+	}
+
+	// every view shares the same group committer and journal, if any
+	ld := newLogData(cfg)
+	if ld.quota != nil {
+		ld.quota.seg = &ct.segMu
 	}
 
+	// releases the context and journal on any failure until launch
+	launched := false
+	defer func() {
+		if !launched {
+			cancel()
+			ld.closeJournal()
+		}
+	}()
+
+	journaled, err := ld.openJournal()
+	if err != nil {
+		return nil, err
+	}
+	ct.seq = ld.seq
 	for i := 0; i < concLvl; i++ {
-		ct.logs[i] = logData{config: cfg}
+		ct.mu[i] = &sync.Mutex{}
+		ct.logs[i] = ld
 		ct.views[i] = make(minStateTable, 0)
 	}
 	ct.logFolder = extractLocation(cfg.Fname)
+	ct.logGlobs = diskLogGlobs(cfg, concTableLogGlob)
+
+	if err := ct.restoreOnInit(); err != nil {
+		return nil, err
+	}
 
 	if cfg.Measure {
-		ct.msr = true
-		fn := ct.logFolder + "bl-" + strconv.Itoa(int(cfg.Period)) + "-latency.out"
-		ct.lm, err = newLatencyMeasure(concLvl, int(cfg.Period), fn)
-		if err != nil {
+		if err := ct.SetMeasure(true); err != nil {
 			return nil, err
 		}
 	}
-	go ct.handleReduce(c, false)
+	launched = true
 
-	// launch another reduce for secondary disk
-	if cfg.ParallelIO {
-		go ct.handleReduce(c, true)
+	ct.workers = cfg.ReduceWorkers
+	if ct.workers < 1 {
+		ct.workers = 1
+	}
+	for i := 0; i < ct.workers; i++ {
+		go ct.handleReduce(c)
+	}
+
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(c, cfg, &ct.tick, ct.reduceOnTick)
+	}
+
+	// launch another reduce routine for each additional disk, concurrently persisting
+	// on striped configs
+	ct.persisted = make([]uint64, len(cfg.diskFnames()))
+	for i := 1; i < len(ct.persisted); i++ {
+		go ct.handleReduce(c)
+	}
+
+	if cfg.GCInterval > 0 {
+		ct.launchSegmentCollector(c, cfg.GCInterval)
+	}
+
+	if err := ld.replayJournal(journaled, ct.LogCtx); err != nil {
+		ct.Shutdown()
+		return nil, err
 	}
 	return ct, nil
 }
 
-// Str ...
+// defaultStrKeys is the number of keys sampled from each view on ConcTable's Str.
+const defaultStrKeys = 8
+
+// Str returns a string representation of each view occupancy and interval, including
+// a sample of up to 8 of its keys, used for debug purposes (see 'StrSample').
 func (ct *ConcTable) Str() string {
-	// TODO:
-	return ""
+	return ct.StrSample(defaultStrKeys)
+}
+
+// StrSample is analogous to 'Str', but samples up to 'maxKeys' keys of each view, in
+// ascending order. A non-positive 'maxKeys' omits keys. Views being reduced are only
+// reported as so, without waiting for their reduce, and output is bounded by 'maxKeys'
+// regardless of view sizes. Safe to call concurrently with any other procedure.
+func (ct *ConcTable) StrSample(maxKeys int) string {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	ct.curMu.Lock()
+	cur := ct.current
+	ct.curMu.Unlock()
+
+	strs := make([]string, 0, len(ct.views))
+	for i := range ct.views {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "view %d", i)
+		if i == cur {
+			sb.WriteString(" (current)")
+		}
+		if atomic.LoadInt32(&ct.busy[i]) == 1 {
+			strs = append(strs, sb.String()+": reducing")
+			continue
+		}
+
+		ct.mu[i].Lock()
+		ld := &ct.logs[i]
+		fmt.Fprintf(&sb, ": %d keys", len(ct.views[i]))
+		if ld.logged {
+			fmt.Fprintf(&sb, " on [%d, %d]", ld.first, ld.last)
+		}
+
+		if maxKeys > 0 && len(ct.views[i]) > 0 {
+			keys := make([]string, 0, maxKeys)
+			for k := range ct.views[i] {
+				if len(keys) == maxKeys {
+					break
+				}
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(&sb, " %v", keys)
+			if len(ct.views[i]) > maxKeys {
+				sb.WriteString("...")
+			}
+		}
+		ct.mu[i].Unlock()
+		strs = append(strs, sb.String())
+	}
+	return strings.Join(strs, "; ")
 }
 
 // Len returns the length of the current active view. A structure lenght
 // is defined as the number of inserted elements on its underlying container,
 // which disregards read operations. To interpret the absolute number of cmds
 // safely discarded on ConcTable structures, just compute:
-//   ct.logs[ct.current].last - ct.logs[ct.current].first + 1
+//   ct.LastIndex() - ct.FirstIndex() + 1
 func (ct *ConcTable) Len() uint64 {
-	return uint64(len(ct.views[ct.current]))
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	cur := ct.lockCurrentView()
+	defer ct.mu[cur].Unlock()
+	return uint64(len(ct.views[cur]))
+}
+
+// FirstIndex returns the index of the first command recorded on the current active
+// view, or zero if none.
+func (ct *ConcTable) FirstIndex() uint64 {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	cur := ct.lockCurrentView()
+	defer ct.mu[cur].Unlock()
+	if !ct.logs[cur].logged {
+		return 0
+	}
+	return ct.logs[cur].first
+}
+
+// LastIndex returns the index of the most recently recorded command, regardless of
+// the view it was recorded on.
+func (ct *ConcTable) LastIndex() uint64 {
+	ct.curMu.Lock()
+	defer ct.curMu.Unlock()
+	return ct.lastInd
+}
+
+// LoggedInterval returns the lowest and highest indexes logged among every view since
+// the table creation, and false if none was logged.
+func (ct *ConcTable) LoggedInterval() (IndexInterval, bool) {
+	return ct.logs[0].LoggedInterval()
+}
+
+// Holes returns every interval of indexes never logged among every view, within the
+// logged interval of the table.
+func (ct *ConcTable) Holes() []IndexInterval {
+	return ct.logs[0].Holes()
+}
+
+// lockCurrentView acquires the mutex of the current active view, returning its id.
+// Follows the same lock order of Log calls, acquiring the view mutex before releasing
+// the cursor.
+func (ct *ConcTable) lockCurrentView() int {
+	ct.curMu.Lock()
+	cur := ct.current
+	ct.mu[cur].Lock()
+	ct.curMu.Unlock()
+	return cur
+}
+
+// Debug returns a report of the table internals, used for troubleshooting. Views
+// awaiting a reduce are not inspected, avoiding blocking on the logger routine.
+func (ct *ConcTable) Debug() DebugInfo {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	ct.curMu.Lock()
+	cur := ct.current
+	ct.curMu.Unlock()
+
+	info := ct.logs[0].debugInfo("conctable", 0)
+	info.First, info.Last = 0, 0
+	info.CurrentView = cur
+
+	var logged bool
+	for i := range ct.views {
+		if atomic.LoadInt32(&ct.busy[i]) == 1 {
+			info.PendingReduces++
+			continue
+		}
+
+		ct.mu[i].Lock()
+		if i == cur {
+			info.Len = uint64(len(ct.views[i]))
+		}
+		if ld := &ct.logs[i]; ld.logged {
+			if !logged || ld.first < info.First {
+				info.First = ld.first
+			}
+			if ld.last > info.Last {
+				info.Last = ld.last
+			}
+			logged = true
+		}
+		ct.mu[i].Unlock()
+	}
+	return info
+}
+
+// Stats returns a summary of the table size and reduce costs, where keys are counted
+// once among every view, and segments are listed from the table log folder. Views
+// awaiting a reduce are still inspected, possibly blocking until it's finished.
+func (ct *ConcTable) Stats() (Stats, error) {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	st, err := ct.logs[0].baseStats()
+	keys := make(map[string]struct{})
+	for i := range ct.views {
+		ct.mu[i].Lock()
+		for k := range ct.views[i] {
+			keys[k] = struct{}{}
+		}
+		st.MemBytes += ct.logs[i].viewBytes
+		ct.mu[i].Unlock()
+	}
+	st.Keys = len(keys)
+
+	// striped and mirrored segments are not indexed by the manifest
+	if !ct.logs[0].config.Inmem {
+		fs, _, lerr := ct.listLogFiles()
+		if lerr != nil {
+			return st, lerr
+		}
+		st.Segments, err = len(fs), nil
+	}
+	return st, err
 }
 
 // Log records the occurence of command 'cmd' on the provided index.
 func (ct *ConcTable) Log(cmd pb.Command) error {
-	wrt := cmd.Op == pb.Command_SET
+	return ct.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded, bounding the time blocked behind a pending reduce over the
+// current view. Once recorded, reduces are delegated to the logger routine as usual.
+func (ct *ConcTable) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return ct.seq.sequence(ctx, cmd, ct.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering. Views are indexed during
+// the entire procedure, so concurrent resizes are awaited.
+func (ct *ConcTable) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+	if ct.logs[0].ignoresRead(&cmd) {
+		return nil
+	}
+	wrt := isWriteOp(cmd.Op) || isRangeDelete(cmd.Op)
 	ct.curMu.Lock()
 	cur := ct.current
 
+	// view still awaiting a reduce, or a new request wouldnt fit the logger queue
+	if ct.logs[cur].config.Backpressure == RejectWhenBusy && (atomic.LoadInt32(&ct.busy[cur]) == 1 ||
+		(ct.loggerBusy() && ct.willTriggerReduceOnView(wrt, cur))) {
+		ct.curMu.Unlock()
+		return ErrBusy
+	}
+
+	// must acquire view mutex before releasing cursor to ensure safety
+	if err := lockCtx(ctx, ct.mu[cur]); err != nil {
+		ct.curMu.Unlock()
+		return err
+	}
+
+	if err := ct.logs[cur].journalCommand(cmd); err != nil {
+		ct.mu[cur].Unlock()
+		ct.curMu.Unlock()
+		return err
+	}
+
 	// first command
-	if ct.msr {
-		ct.lm.absIndex++
-		if (ct.lm.absIndex%ct.lm.interval == 1 || ct.lm.interval == 1) && rand.Intn(measureChance) == 0 {
-			ct.lm.initLat[ct.lm.msrIndex] = time.Now().UnixNano()
-			ct.lm.drawn = true
-		}
+	lm := ct.activeMeasure()
+	if lm != nil {
+		lm.beginCmd()
 	}
 
-	willReduce, advance := ct.willRequireReduceOnView(wrt, cur)
+	willReduce, advance := ct.willRequireReduceOnView(&cmd, cur)
 	if advance {
 		ct.advanceCurrentView()
 	}
-
-	// must acquire view mutex before releasing cursor to ensure safety
-	ct.mu[cur].Lock()
+	ct.lastInd = cmd.Id
+	ct.logs[cur].countLogged(&cmd)
+	ct.logs[cur].recordPinned(&cmd)
 	ct.curMu.Unlock()
 
-	if ct.msr && ct.lm.drawn {
-		if ct.lm.absIndex%ct.lm.interval == 1 {
-			// first command was written into table
-			ct.lm.writeLat[ct.lm.msrIndex] = time.Now().UnixNano()
-
-		} else if ct.lm.interval == 1 {
-			// special case of first and last command, which does not fall
-			// on first condition
-			ct.lm.writeLat[ct.lm.msrIndex] = time.Now().UnixNano()
-			ct.lm.fillLat[ct.lm.msrIndex] = time.Now().UnixNano()
-
-		} else if ct.lm.absIndex%ct.lm.interval == 0 {
-			// last command, table filled
-			ct.lm.fillLat[ct.lm.msrIndex] = time.Now().UnixNano()
-		}
+	if lm != nil {
+		lm.cmdLogged()
 	}
 
 	// adjust first structure index
@@ -186,70 +474,160 @@ func (ct *ConcTable) Log(cmd pb.Command) error {
 		ct.logs[cur].logged = true
 	}
 
-	if wrt {
+	if isRangeDelete(cmd.Op) {
+		// matching states are only discarded during reduce
+		ct.logs[cur].tombs.add(cmd)
+
+	} else if wrt {
 		// update current state for that particular key
 		st := State{
 			ind: cmd.Id,
 			cmd: cmd,
 		}
+
+		// conditional updates must retain the state they were applied over
+		ct.detachSharedView(cur)
+		ct.logs[cur].viewBytes += ct.views[cur].insertBytes(&cmd)
+		if prior, ok := ct.views[cur][cmd.Key]; ok && st.dependsOnPrior() {
+			st.prev = &prior
+		}
 		ct.views[cur][cmd.Key] = st
+		ct.order[cur] = append(ct.order[cur], buffEntry{ind: cmd.Id, key: cmd.Key})
 	}
 	// adjust last index
 	ct.logs[cur].last = cmd.Id
 
 	if willReduce {
 		// mutext will be later unlocked by the logger routine
-		if ct.msr && ct.lm.drawn {
-			ct.loggerReq <- logEvent{cur, ct.lm.msrIndex}
-			ct.lm.msrIndex++
-			ct.lm.drawn = false
-
-		} else {
-			ct.loggerReq <- logEvent{cur, -1}
+		ev := logEvent{cur, -1}
+		if lm != nil {
+			ev.measure = lm.takeMeasure()
 		}
+		return ct.requestReduce(ev)
+	}
+	ct.mu[cur].Unlock()
+	return nil
+}
 
-	} else {
-		ct.mu[cur].Unlock()
+// Err returns a channel reporting failures of reduce procedures executed by the logger
+// routine, after every attempt configured by 'config.Retry' is exhausted. The state
+// of a failed view is retained, and persisted on its next reduce.
+func (ct *ConcTable) Err() <-chan error {
+	return ct.logs[0].Err()
+}
+
+// Pending returns the number of views with a reduce request still waiting for, or
+// being processed by, the logger routine. Views are locked until their reduce
+// finishes, so a value close to the concurrency level signals that persistence
+// is slower than ingestion.
+func (ct *ConcTable) Pending() int {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	var n int
+	for i := range ct.busy {
+		n += int(atomic.LoadInt32(&ct.busy[i]))
+	}
+	return n
+}
+
+// loggerBusy informs if the queue of pending reduce requests is full.
+func (ct *ConcTable) loggerBusy() bool {
+	return len(ct.loggerReq) >= cap(ct.loggerReq)
+}
+
+// requestReduce enqueues a reduce request to the logger routine. If its queue is
+// full and the SyncWhenBusy policy is configured, the reduce is executed by the
+// caller instead, otherwise it blocks until the request is dequeued. The view mutex
+// of 'ev.table' must be held, and is released once the reduce finishes.
+func (ct *ConcTable) requestReduce(ev logEvent) error {
+	atomic.StoreInt32(&ct.busy[ev.table], 1)
+	select {
+	case ct.loggerReq <- ev:
+		return nil
+	default:
+	}
+
+	if ct.logs[ev.table].config.Backpressure != SyncWhenBusy {
+		ct.loggerReq <- ev
+		return nil
+	}
+
+	var count int
+	err := ct.logs[ev.table].config.Retry.do(context.Background(), func() error {
+		return ct.reduceLog(ev.table, &count)
+	})
+	if err != nil {
+		ct.releaseView(ev.table)
+		return err
+	}
+	if ev.measure != -1 {
+		ct.lm.measurePersLatOf(ev.measure)
 	}
 	return nil
 }
 
 // Recov returns a compacted log of commands, following the requested [p, n]
-// interval if 'Delayed' reduce is configured. On different period configurations,
-// the entire reduced log is always returned. On persistent configuration (i.e.
+// interval. 'Delayed' configs reduce the requested interval, while on different
+// period configurations the most recently reduced states are trimmed to [p, n],
+// merging every segment overlapping it on KeepAll configs. On persistent configuration (i.e.
 // 'inmem' false) the entire log is loaded and then unmarshaled, consider using
 // 'RecovBytes' calls instead. On CircBuff structures, indexes [p, n] are ignored.
 func (ct *ConcTable) Recov(p, n uint64) ([]pb.Command, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	return ct.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the wait for the current view, its
+// lazy reduce, and the reading of persisted states once 'ctx' is done, returning ctx.Err().
+func (ct *ConcTable) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	res, err := ct.recovCtx(ctx, p, n)
+	return res.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log, the one of the view it was read from.
+func (ct *ConcTable) RecovWithResult(p, n uint64) (RecovResult, error) {
+	return ct.recovCtx(context.Background(), p, n)
+}
+
+func (ct *ConcTable) recovCtx(ctx context.Context, p, n uint64) (RecovResult, error) {
+	p, n, err := ct.logs[0].checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
 	}
+	if err := ctx.Err(); err != nil {
+		return RecovResult{}, err
+	}
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+	ct.logs[0].hookRecovery(p, n)
 	cur := ct.readAndAdvanceCurrentView()
 
 	// sequentially reduce since 'Recov' will already be called concurrently
-	exec, err := ct.mayExecuteLazyReduce(cur)
+	exec, err := ct.mayExecuteLazyReduce(ctx, cur)
 	if err != nil {
-		return nil, err
+		return RecovResult{}, err
 	}
 
-	var cmds []pb.Command
+	var view int
 	if exec {
 		defer ct.mu[cur].Unlock()
 
 		// executed a lazy reduce, must read from the 'cur' log
-		cmds, err = ct.logs[cur].retrieveLog()
-		if err != nil {
-			return nil, err
-		}
+		view = cur
 
 	} else {
 		// didnt execute, must read from the previous log cursor
-		prev := atomic.LoadInt32(&ct.prevLog)
-		cmds, err = ct.logs[prev].retrieveLog()
-		if err != nil {
-			return nil, err
-		}
+		view = int(atomic.LoadInt32(&ct.prevLog))
+	}
+
+	cmds, err := ct.logs[view].retrieveIntervalLogCtx(ctx, p, n)
+	if err != nil {
+		return RecovResult{}, err
 	}
-	return cmds, nil
+	res := ct.logs[view].coverage(p, n, true)
+	res.Cmds = cmds
+	return res, nil
 }
 
 // RecovBytes returns an already serialized log, parsed from persistent storage
@@ -258,166 +636,827 @@ func (ct *ConcTable) Recov(p, n uint64) ([]pb.Command, error) {
 // interpretation from the byte stream follows a simple slicing protocol, where
 // the size of each command is binary encoded before the raw pbuff.
 func (ct *ConcTable) RecovBytes(p, n uint64) ([]byte, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	res, err := ct.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log, the one of the view it was read from.
+func (ct *ConcTable) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
+	p, n, err := ct.logs[0].checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
 	}
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+	ct.logs[0].hookRecovery(p, n)
 	cur := ct.readAndAdvanceCurrentView()
 
 	// sequentially reduce since 'Recov' will already be called concurrently
-	exec, err := ct.mayExecuteLazyReduce(cur)
+	exec, err := ct.mayExecuteLazyReduce(context.Background(), cur)
 	if err != nil {
-		return nil, err
+		return RecovResult{}, err
 	}
 
-	var raw []byte
+	var view int
 	if exec {
 		defer ct.mu[cur].Unlock()
 
 		// executed a lazy reduce, must read from the 'cur' log
-		raw, err = ct.logs[cur].retrieveRawLog(ct.logs[cur].first, ct.logs[cur].last)
-		if err != nil {
-			return nil, err
-		}
+		view = cur
 
 	} else {
 		// didnt execute, must read from the previous log cursor
-		prev := atomic.LoadInt32(&ct.prevLog)
-		raw, err = ct.logs[prev].retrieveRawLog(ct.logs[prev].first, ct.logs[prev].last)
-		if err != nil {
-			return nil, err
+		view = int(atomic.LoadInt32(&ct.prevLog))
+	}
+
+	raw, err := ct.logs[view].retrieveRawLog(ct.logs[view].first, ct.logs[view].last)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := ct.logs[view].coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (ct *ConcTable) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := ct.logs[0].checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+	ct.logs[0].hookRecovery(p, n)
+	cur := ct.readAndAdvanceCurrentView()
+
+	// sequentially reduce since 'Recov' will already be called concurrently
+	exec, err := ct.mayExecuteLazyReduce(context.Background(), cur)
+	if err != nil {
+		return err
+	}
+
+	if exec {
+		defer ct.mu[cur].Unlock()
+
+		// executed a lazy reduce, must read from the 'cur' log
+		return ct.logs[cur].streamRawLog(w, ct.logs[cur].first, ct.logs[cur].last)
+	}
+
+	// didnt execute, must read from the previous log cursor
+	prev := atomic.LoadInt32(&ct.prevLog)
+	return ct.logs[prev].streamRawLog(w, ct.logs[prev].first, ct.logs[prev].last)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix). The filter is applied while iterating each view, so states
+// of other keys are never copied, then merged with the filtered last reduced states.
+func (ct *ConcTable) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	p, n, err := ct.logs[0].checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+	ct.logs[0].hookRecovery(p, n)
+
+	cmds := []pb.Command{}
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+		cmds = append(cmds, iterConcTableOnView(&ct.views[i], f, nil)...)
+
+		// states of already reduced views are only retained on the reduced log
+		if ct.logs[i].firstReduceExists() {
+			log, err := ct.logs[i].retrieveLog()
+			if err != nil {
+				ct.mu[i].Unlock()
+				return nil, err
+			}
+			cmds = append(cmds, filterByKey(log, f)...)
+		}
+		ct.mu[i].Unlock()
+	}
+	return mergeLatestStates(cmds), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (ct *ConcTable) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := ct.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log. Un-reduced states are located on each view by a binary
+// search over its index-ordered writes, and merged with the last reduced states.
+func (ct *ConcTable) RecovSince(id uint64) ([]pb.Command, error) {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	cmds := []pb.Command{}
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+		cmds = ct.appendViewSince(cmds, i, id)
+
+		// states of already reduced views are only retained on the reduced log
+		if ct.logs[i].firstReduceExists() {
+			log, err := ct.logs[i].retrieveLog()
+			if err != nil {
+				ct.mu[i].Unlock()
+				return nil, err
+			}
+			cmds = append(cmds, filterSince(log, id)...)
+		}
+		ct.mu[i].Unlock()
+	}
+	return mergeLatestStates(cmds), nil
+}
+
+// appendViewSince appends into 'log' the latest states on view 'v' updated after 'id'.
+// Must be called from mutual exclusion scope over the view.
+func (ct *ConcTable) appendViewSince(log []pb.Command, v int, id uint64) []pb.Command {
+	ord := ct.order[v]
+	i := sort.Search(len(ord), func(j int) bool { return ord[j].ind > id })
+
+	for ; i < len(ord); i++ {
+		st, ok := ct.views[v][ord[i].key]
+
+		// only the latest state of each key is retained, prior ones are
+		// either superseded or part of its chain
+		if !ok || st.ind != ord[i].ind {
+			continue
+		}
+		log = append(log, filterSince(appendStateChain(nil, &st), id)...)
+	}
+	return log
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands. Each view table is swapped out of the table
+// during creation, which copies it only once written again (see 'detachSharedView'),
+// and only reduced once the snapshot is first read. Every view is locked until the
+// reduced logs of persistent configs are read, since any reduce could otherwise
+// replace them by a state covering commands logged after the snapshot.
+func (ct *ConcTable) Snapshot() (*Snapshot, error) {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	// wait every pending reduce, the logger routine releases each view mutex
+	// once its state is persisted
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+	}
+	defer func() {
+		for i := 0; i < ct.concLevel; i++ {
+			ct.mu[i].Unlock()
+		}
+	}()
+
+	var last uint64
+	views := make([]minStateTable, ct.concLevel)
+	tombs := make([]rangeTombs, ct.concLevel)
+	prior := []pb.Command{}
+
+	for i := 0; i < ct.concLevel; i++ {
+		views[i] = ct.views[i]
+		ct.shared[i] = true
+		tombs[i] = append(rangeTombs(nil), ct.logs[i].tombs...)
+
+		// states of already reduced views are only retained on the reduced log,
+		// in-memory ones are only appended after creation
+		if ct.logs[i].firstReduceExists() {
+			if ct.logs[i].config.Inmem {
+				prior = append(prior, *ct.logs[i].recentLog...)
+			} else {
+				log, err := ct.logs[i].retrieveLog()
+				if err != nil {
+					return nil, err
+				}
+				prior = append(prior, log...)
+			}
+		}
+
+		if ct.logs[i].last > last {
+			last = ct.logs[i].last
+		}
+	}
+
+	return newLazySnapshot(last, func() ([]pb.Command, error) {
+		cmds := prior
+		for i := range views {
+			cmds = append(cmds, tombs[i].applyOn(IterConcTableOnView(&views[i]), 0, ^uint64(0))...)
+		}
+		return mergeLatestStates(cmds), nil
+	}), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (ct *ConcTable) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := ct.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key' on any view not yet reduced, without
+// triggering a reduce. States of already reduced views are only retained on the
+// reduced log, so keys not written since their last reduce are not found; consider
+// Snapshot calls to query the entire state.
+func (ct *ConcTable) Get(key string) (pb.Command, bool) {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	var latest State
+	var found bool
+	var tombs []pb.Command
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+		if st, ok := ct.views[i][key]; ok && (!found || st.ind > latest.ind) {
+			latest, found = st, true
+		}
+		tombs = append(tombs, ct.logs[i].tombs...)
+		ct.mu[i].Unlock()
+	}
+
+	if !found {
+		return pb.Command{}, false
+	}
+	return visibleState(&latest.cmd, ct.logs[0].liveState(&latest.cmd, tombs))
+}
+
+// Keys returns every key with a state retained on any view not yet reduced, in
+// ascending order.
+func (ct *ConcTable) Keys() []string {
+	ct.lvlMu.RLock()
+	defer ct.lvlMu.RUnlock()
+
+	latest := make(map[string]State)
+	var tombs []pb.Command
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+		for k, st := range ct.views[i] {
+			if prior, ok := latest[k]; !ok || st.ind > prior.ind {
+				latest[k] = st
+			}
+		}
+		tombs = append(tombs, ct.logs[i].tombs...)
+		ct.mu[i].Unlock()
+	}
+
+	keys := make([]string, 0, len(latest))
+	for k, st := range latest {
+		if ct.logs[0].liveState(&st.cmd, tombs) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetConcLevel resizes the number of views of the table to 'n' at runtime. The cursor
+// is quiesced and every in-flight reduce is awaited before resizing. When shrinking,
+// the un-reduced state of removed views is merged into the remaining ones, so no
+// logged command is lost.
+func (ct *ConcTable) SetConcLevel(n int) error {
+	if n < 1 {
+		return errors.New("must inform a positive value for 'concLevel' argument")
+	}
+	ct.lvlMu.Lock()
+	defer ct.lvlMu.Unlock()
+
+	ct.curMu.Lock()
+	defer ct.curMu.Unlock()
+
+	// wait every pending reduce, the logger routine releases each view mutex
+	// once its state is persisted
+	old := ct.concLevel
+	for i := 0; i < old; i++ {
+		ct.mu[i].Lock()
+	}
+	defer func() {
+		for i := 0; i < ct.concLevel; i++ {
+			ct.mu[i].Unlock()
+		}
+	}()
+
+	if n == old {
+		return nil
+	}
+
+	if n > old {
+		for i := old; i < n; i++ {
+			mu := &sync.Mutex{}
+			mu.Lock()
+			ct.mu = append(ct.mu, mu)
+			ct.views = append(ct.views, make(minStateTable, 0))
+			ct.shared = append(ct.shared, false)
+			ct.order = append(ct.order, nil)
+			ct.busy = append(ct.busy, 0)
+			ct.logs = append(ct.logs, logData{config: ct.logs[0].config, gc: ct.logs[0].gc, idx: ct.logs[0].idx, errs: ct.logs[0].errs, stats: ct.logs[0].stats, pins: ct.logs[0].pins, feed: ct.logs[0].feed, seq: ct.logs[0].seq, quota: ct.logs[0].quota, tier: ct.logs[0].tier, wlim: ct.logs[0].wlim, cpu: ct.logs[0].cpu})
+		}
+
+	} else {
+		for i := n; i < old; i++ {
+			ct.mergeViewInto(modInt(i, n), i)
+			ct.mu[i].Unlock()
+		}
+		ct.mu = ct.mu[:n:n]
+		ct.views = ct.views[:n:n]
+		ct.shared = ct.shared[:n:n]
+		ct.order = ct.order[:n:n]
+		ct.busy = ct.busy[:n:n]
+		ct.logs = ct.logs[:n:n]
+
+		if ct.current >= n {
+			ct.current = modInt(ct.current, n)
+		}
+		if prev := atomic.LoadInt32(&ct.prevLog); int(prev) >= n {
+			atomic.StoreInt32(&ct.prevLog, int32(ct.current))
+		}
+	}
+
+	ct.concLevel = n
+	return nil
+}
+
+// SetConfig atomically replaces the table config by 'cfg' (see Reconfigurable). As
+// on 'SetConcLevel', the cursor and recoveries are quiesced and every in-flight reduce
+// is awaited before the update.
+func (ct *ConcTable) SetConfig(cfg *LogConfig) error {
+	return ct.updateConfig(func(*LogConfig) *LogConfig { return cfg }, concTableLogGlob)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// table config as in 'SetConfig'.
+func (ct *ConcTable) UpdateConfig(fn func(cfg *LogConfig)) error {
+	return ct.updateConfig(func(cur *LogConfig) *LogConfig {
+		cfg := cur.clone()
+		fn(cfg)
+		return cfg
+	}, concTableLogGlob)
+}
+
+// updateConfig replaces the table config by the one returned by 'next' over the
+// current config, where 'glob' maps each updated output path into the pattern of its
+// log files.
+func (ct *ConcTable) updateConfig(next func(cur *LogConfig) *LogConfig, glob func(fn string) string) error {
+	ct.lvlMu.Lock()
+	defer ct.lvlMu.Unlock()
+
+	ct.curMu.Lock()
+	defer ct.curMu.Unlock()
+
+	// wait every pending reduce, the logger routine releases each view mutex
+	// once its state is persisted
+	for i := 0; i < ct.concLevel; i++ {
+		ct.mu[i].Lock()
+	}
+	defer func() {
+		for i := 0; i < ct.concLevel; i++ {
+			ct.mu[i].Unlock()
 		}
+	}()
+
+	// every view shares the same config
+	if err := ct.logs[0].reconfigure(ct.ctx, next(ct.logs[0].config), &ct.tick, ct.reduceOnTick); err != nil {
+		return err
 	}
-	return raw, nil
+	for i := 1; i < ct.concLevel; i++ {
+		ct.logs[i].applyConfig(ct.logs[0].config)
+	}
+	ct.logFolder = extractLocation(ct.logs[0].config.Fname)
+	ct.logGlobs = diskLogGlobs(ct.logs[0].config, glob)
+	return nil
 }
 
-// RecovEntireLog ...
+// mergeViewInto merges the un-reduced state of view 'src' into 'dest', retaining the
+// most recent state for each key. Must be called from mutual exclusion scope over both
+// views.
+func (ct *ConcTable) mergeViewInto(dest, src int) {
+	if !ct.logs[src].logged {
+		return
+	}
+
+	ct.detachSharedView(dest)
+	for k, st := range ct.views[src] {
+		cur, ok := ct.views[dest][k]
+		if !ok {
+			ct.views[dest][k] = st
+			continue
+		}
+
+		if st.ind > cur.ind {
+			cur, st = st, cur
+		}
+		// conditional updates must retain the state they were applied over
+		if cur.dependsOnPrior() && cur.prev == nil {
+			prior := st
+			cur.prev = &prior
+		}
+		ct.views[dest][k] = cur
+	}
+
+	ord := append(ct.order[dest], ct.order[src]...)
+	sort.Slice(ord, func(i, j int) bool { return ord[i].ind < ord[j].ind })
+	ct.order[dest] = ord
+	ct.logs[dest].viewBytes = ct.views[dest].memBytes() + int64(len(ord))*buffEntBytes
+
+	if !ct.logs[dest].logged {
+		ct.logs[dest].first = ct.logs[src].first
+		ct.logs[dest].last = ct.logs[src].last
+		ct.logs[dest].logged = true
+
+	} else {
+		if ct.logs[src].first < ct.logs[dest].first {
+			ct.logs[dest].first = ct.logs[src].first
+		}
+		if ct.logs[src].last > ct.logs[dest].last {
+			ct.logs[dest].last = ct.logs[src].last
+		}
+	}
+}
+
+// RecovEntireLog returns every segment persisted by the table, framed into a single
+// envelope with a length prefix and checksum for each segment (see 'encodeEntireLog'),
+// and the number of segments read. Receivers interpret the envelope through
+// 'DecodeEntireLogStream'. On striped configs, segments of every disk are recovered
+// in interval order, as recorded on the manifest, or on their headers if none is
+// maintained.
 func (ct *ConcTable) RecovEntireLog() ([]byte, int, error) {
-	fp := ct.logFolder + "*.log"
-	fs, err := filepath.Glob(fp)
+	segs, err := ct.entireLogSegments()
 	if err != nil {
 		return nil, 0, err
 	}
+	return encodeEntireLog(segs), len(segs), nil
+}
 
-	// sorts by lenght and lexicographically for equal len
-	sort.Sort(byLenAlpha(fs))
-	buf := bytes.NewBuffer(nil)
+// entireLogSegments reads every segment persisted by the table.
+func (ct *ConcTable) entireLogSegments() ([][]byte, error) {
+	ct.segMu.RLock()
+	defer ct.segMu.RUnlock()
 
-	for _, fn := range fs {
-		fd, err := os.OpenFile(fn, os.O_RDONLY, 0400)
-		if err != nil && err != io.EOF {
-			return nil, 0, fmt.Errorf("failed while opening log '%s', err: '%s'", fn, err.Error())
-		}
-		defer fd.Close()
+	segs, err := ct.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	return ct.readSegments(segs)
+}
 
-		// read the retrieved log interval
-		var f, l uint64
-		_, err = fmt.Fscanf(fd, "%d\n%d\n", &f, &l)
+// listSegments returns every segment persisted by the table, ordered by their intervals
+// as recorded on the manifest of KeepAll configs. Segments are located by listing the
+// log folder of each disk if no manifest is maintained, or none was recorded (e.g. logs
+// persisted by prior versions), in which case segments striped across disks are merged
+// by the intervals recorded on their headers.
+func (ct *ConcTable) listSegments() ([]segmentInterval, error) {
+	if ix := ct.logs[0].idx; ix != nil {
+		segs, err := ix.overlapping(ct.logs[0].storage(), 0, ^uint64(0))
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed while reading log '%s', err: '%s'", fn, err.Error())
+			return nil, err
+		}
+		if len(segs) > 0 {
+			return segs, nil
 		}
+	}
+
+	fs, disks, err := ct.listLogFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ct.logGlobs) == 1 {
+		// sorts by lenght and lexicographically for equal len
+		sort.Sort(byLenAlpha(fs))
+		segs := make([]segmentInterval, 0, len(fs))
+		for _, fn := range fs {
+			segs = append(segs, segmentInterval{Name: fn})
+		}
+		return segs, nil
+	}
 
-		// reset cursor
-		_, err = fd.Seek(0, io.SeekStart)
+	segs := make([]segmentInterval, 0, len(fs))
+	for i, fn := range fs {
+		rd, err := ct.logs[0].readSegment(fn)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed while reading log '%s', err: '%s'", fn, err.Error())
+			return nil, err
+		}
+		_, hdr, err := ReadLogHeader(newSegmentCursor(rd))
+		rd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed while reading log '%s', err: '%s'", fn, err.Error())
+		}
+		segs = append(segs, segmentInterval{Name: fn, First: hdr.First, Last: hdr.Last, Disk: disks[i]})
+	}
+	sort.SliceStable(segs, func(i, j int) bool {
+		if segs[i].First != segs[j].First {
+			return segs[i].First < segs[j].First
 		}
+		return segs[i].Last < segs[j].Last
+	})
+	return segs, nil
+}
 
-		// each copy stages through a temporary buffer, copying to dest once completed
-		_, err = io.Copy(buf, fd)
+// listLogFiles lists every log file persisted by the table on each disk, along with
+// the disk each one was found on. Disks sharing a log folder are listed once.
+func (ct *ConcTable) listLogFiles() ([]string, []int, error) {
+	var (
+		fs    []string
+		disks []int
+	)
+	seen := make(map[string]struct{})
+	for d, glob := range ct.logGlobs {
+		ls, err := ct.logs[0].storage().List(glob)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed while copying log '%s', err: '%s'", fn, err.Error())
+			return nil, nil, err
+		}
+		for _, fn := range ls {
+			if _, ok := seen[fn]; ok {
+				continue
+			}
+			seen[fn] = struct{}{}
+			fs = append(fs, fn)
+			disks = append(disks, d)
 		}
 	}
-	return buf.Bytes(), len(fs), nil
+	return fs, disks, nil
 }
 
-// RecovEntireLogConc ...
-// TODO: comeback later once sequential solution is done.
-func (ct *ConcTable) RecovEntireLogConc() (<-chan []byte, int, error) {
-	fp := ct.logFolder + "*.log"
-	fs, err := filepath.Glob(fp)
+// concTableLogGlob returns a pattern matching every log file on the folder of 'fn'.
+func concTableLogGlob(fn string) string {
+	return extractLocation(fn) + "*.log"
+}
+
+// diskLogGlobs maps the path of every disk of 'cfg' retaining distinct segments into the
+// pattern matching its log files through 'glob'. Mirrored disks only retain copies of
+// the primary segments, so only the primary pattern is returned on Mirror configs.
+func diskLogGlobs(cfg *LogConfig, glob func(fn string) string) []string {
+	fns := cfg.diskFnames()
+	if cfg.ParallelMode == Mirror {
+		fns = fns[:1]
+	}
+	globs := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		globs = append(globs, glob(fn))
+	}
+	return globs
+}
+
+// RecovEntireLogInterval is analogous to 'RecovEntireLog', but only reads the segments
+// whose interval overlaps [p, n], located through the interval index maintained on
+// persistent KeepAll configs, instead of listing every segment on the log folder.
+func (ct *ConcTable) RecovEntireLogInterval(p, n uint64) ([]byte, int, error) {
+	segs, err := ct.entireLogSegmentsInterval(p, n)
 	if err != nil {
 		return nil, 0, err
 	}
+	return encodeEntireLog(segs), len(segs), nil
+}
 
-	// sorts by lenght and lexicographically for equal len
-	sort.Sort(byLenAlpha(fs))
-	buf := bytes.NewBuffer(nil)
-	mu := &sync.Mutex{}
+// entireLogSegmentsInterval reads every segment whose interval overlaps [p, n].
+func (ct *ConcTable) entireLogSegmentsInterval(p, n uint64) ([][]byte, error) {
+	p, n, err := ct.logs[0].checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	ct.segMu.RLock()
+	defer ct.segMu.RUnlock()
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(fs))
-	fmt.Println("will be waiting on", len(fs), "files")
+	ix := ct.logs[0].idx
+	if ix == nil {
+		return nil, errors.New("interval index is only maintained on persistent configs with KeepAll set")
+	}
 
-	for _, f := range fs {
-		// read each file concurrently and write to buffer once done
-		go func(fn string) {
-			fd, err := os.OpenFile(fn, os.O_RDONLY, 0400)
-			if err != nil && err != io.EOF {
-				log.Fatalf("failed while opening log '%s', err: '%s'\n", fn, err.Error())
-			}
-			defer fd.Close()
+	segs, err := ix.overlapping(ct.logs[0].storage(), p, n)
+	if err != nil {
+		return nil, err
+	}
+	return ct.readSegments(segs)
+}
 
-			// read the retrieved log interval
-			var f, l uint64
-			_, err = fmt.Fscanf(fd, "%d\n%d\n", &f, &l)
-			if err != nil {
-				log.Fatalf("failed while reading log '%s', err: '%s'\n", fn, err.Error())
-			}
+// readSegments returns the entire content of every segment of 'segs', headers included.
+func (ct *ConcTable) readSegments(segs []segmentInterval) ([][]byte, error) {
+	raws := make([][]byte, 0, len(segs))
+	for _, sg := range segs {
+		seg := ct.readEntireSegment(sg)
+		if seg.Err != nil {
+			return nil, seg.Err
+		}
+		raws = append(raws, seg.Raw)
+	}
+	return raws, nil
+}
 
-			mu.Lock()
-			defer mu.Unlock()
+// RecovSegment is a persisted segment retrieved by 'RecovEntireLogConc'.
+type RecovSegment struct {
+	Name string
 
-			// increase buffer's capacity, if necessary
-			if size := int(l - f); size >= (buf.Cap() - buf.Len()) {
-				buf.Grow(size)
-			}
+	// First and Last are the indexes of the segment interval
+	First, Last uint64
 
-			// reset cursor
-			_, err = fd.Seek(0, io.SeekStart)
-			if err != nil {
-				log.Fatalf("failed while reading log '%s', err: '%s'\n", fn, err.Error())
-			}
+	// the entire serialized segment, header included
+	Raw []byte
 
-			// each copy stages through a temporary buffer, copying to dest once completed
-			_, err = io.Copy(buf, fd)
-			if err != nil {
-				log.Fatalf("failed while copying log '%s', err: '%s'\n", fn, err.Error())
-			}
+	// error reading the segment, if any. No segment follows a failed one
+	Err error
+}
 
-			wg.Done()
-			fmt.Println("finished one...")
-			return
-		}(f)
+// RecovEntireLogConc is analogous to 'RecovEntireLog', but reads segments through a
+// pool of 'workers' routines, or GOMAXPROCS if non-positive. Segments are streamed
+// in the same order of 'RecovEntireLog' as soon as each one is read, and at most
+// 'workers' segments are buffered at any time, bounding memory usage regardless of
+// the log size. The returned channel is closed once every segment is sent, after a
+// failed segment, or once 'ctx' is done. The number of segments is also returned.
+// Obsolete segments are not collected until the channel is closed, so callers must
+// either drain the channel or cancel 'ctx'.
+func (ct *ConcTable) RecovEntireLogConc(ctx context.Context, workers int) (<-chan RecovSegment, int, error) {
+	ct.segMu.RLock()
+	fs, err := ct.listSegments()
+	if err != nil {
+		ct.segMu.RUnlock()
+		return nil, 0, err
 	}
 
-	wg.Wait()
-	fmt.Println("finished reading logs!")
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	out := make(chan RecovSegment)
+
+	// each segment is delivered on its own slot, then forwarded in order. A new
+	// read is only dispatched once a previous segment is forwarded
+	slots := make([]chan RecovSegment, len(fs))
+	for i := range slots {
+		slots[i] = make(chan RecovSegment, 1)
+	}
+	sem := make(chan struct{}, workers)
+	stop := make(chan struct{})
 
-	out := make(chan []byte, 0)
-	sc := bufio.NewScanner(buf)
 	go func() {
-		for sc.Scan() {
-			out <- sc.Bytes()
+		for i, sg := range fs {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(i int, sg segmentInterval) {
+				slots[i] <- ct.readEntireSegment(sg)
+			}(i, sg)
+		}
+	}()
+
+	go func() {
+		defer ct.segMu.RUnlock()
+		defer close(out)
+		defer close(stop)
+		for i := range slots {
+			var seg RecovSegment
+			select {
+			case seg = <-slots[i]:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case out <- seg:
+			case <-ctx.Done():
+				return
+			}
+			if seg.Err != nil {
+				return
+			}
+			<-sem
 		}
-		close(out)
-		return
 	}()
 	return out, len(fs), nil
 }
 
+// readEntireSegment reads the entire segment 'sg', validating its header and checksum,
+// if known. On Mirror configs, its copies on other disks are read if the segment is
+// unavailable or corrupted.
+func (ct *ConcTable) readEntireSegment(sg segmentInterval) RecovSegment {
+	seg := ct.readSegmentFile(sg.Name, sg)
+	cfg := ct.logs[0].config
+	if seg.Err == nil || cfg.ParallelMode != Mirror {
+		return seg
+	}
+	for _, fn := range cfg.diskFnames()[1:] {
+		mirror := ct.readSegmentFile(segmentFname(fn, sg.First, sg.Last, cfg.Naming), sg)
+		if mirror.Err == nil {
+			return mirror
+		}
+	}
+	return seg
+}
+
+// readSegmentFile reads the entire segment file 'fn', validating its header and the
+// checksum recorded for 'sg', if known.
+func (ct *ConcTable) readSegmentFile(fn string, sg segmentInterval) RecovSegment {
+	seg := RecovSegment{Name: fn}
+	rd, err := ct.logs[0].readSegment(fn)
+	if err != nil && err != io.EOF {
+		seg.Err = fmt.Errorf("failed while opening log '%s', err: '%s'", fn, err.Error())
+		return seg
+	}
+	defer rd.Close()
+
+	_, hdr, err := ReadLogHeader(newSegmentCursor(rd))
+	if err != nil {
+		seg.Err = fmt.Errorf("failed while reading log '%s', err: '%s'", fn, err.Error())
+		return seg
+	}
+	seg.First, seg.Last = hdr.First, hdr.Last
+
+	seg.Raw, err = ioutil.ReadAll(newSegmentCursor(rd))
+	if err != nil {
+		seg.Err = fmt.Errorf("failed while copying log '%s', err: '%s'", fn, err.Error())
+
+	} else if sg.HasChecksum && crc32.Checksum(seg.Raw, castagnoli) != sg.Checksum {
+		seg.Err = fmt.Errorf("log '%s': %w", fn, ErrSegmentChecksum)
+	}
+	return seg
+}
+
 // persistTable applies the configured algorithm on a specific view and updates
-// the latest log state into a new file.
-func (ct *ConcTable) persistTable(id int, secDisk bool) error {
+// the latest log state into a new file, on the path of 'disk'.
+func (ct *ConcTable) persistTable(id int, disk int) error {
+	return ct.persistTableCtx(context.Background(), id, disk)
+}
+
+// persistTableCtx is analogous to 'persistTable', but returns ctx.Err() without
+// reducing or persisting the view if 'ctx' is done, either before or during the reduce.
+func (ct *ConcTable) persistTableCtx(ctx context.Context, id int, disk int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p, n := ct.logs[id].first, ct.logs[id].last
+	start := ct.logs[id].hookReduceStart(p, n)
 	cmds, err := ct.executeReduceAlgOnView(id)
+	ct.logs[id].hookReduceDone(p, n, start, len(cmds), err)
 	if err != nil {
 		return err
 	}
-	return ct.logs[id].updateLogState(cmds, ct.logs[id].first, ct.logs[id].last, secDisk)
+
+	// mirrored configs persist every reduced view on all disks
+	disks := []int{disk}
+	if cfg := ct.logs[id].config; cfg.ParallelMode == Mirror {
+		disks = disks[:0]
+		for d := range cfg.diskFnames() {
+			disks = append(disks, d)
+		}
+	}
+
+	if !ct.sharesPersistedState() {
+		for _, d := range disks {
+			if err = ct.logs[id].updateLogStateCtx(ctx, cmds, p, n, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// views are concurrently reduced, but a newer persisted state must never be
+	// replaced by an older view
+	ct.persistMu.Lock()
+	defer ct.persistMu.Unlock()
+	for _, d := range disks {
+		if n < ct.persisted[d] {
+			continue
+		}
+		if err = ct.logs[id].updateLogStateCtx(ctx, cmds, p, n, d); err != nil {
+			return err
+		}
+		ct.persisted[d] = n
+	}
+	return nil
+}
+
+// nextReduceDisk returns the disk the next reduce must be persisted on, striping
+// consecutive calls across every configured path on RoundRobin configs. Mirror configs
+// persist on all disks regardless.
+func (ct *ConcTable) nextReduceDisk() int {
+	fns := ct.logs[0].config.diskFnames()
+	if len(fns) == 1 || ct.logs[0].config.ParallelMode != RoundRobin {
+		return 0
+	}
+	return int((atomic.AddUint32(&ct.reduces, 1) - 1) % uint32(len(fns)))
+}
+
+// sharesPersistedState informs if views are persisted into the same segment while
+// reduced by multiple logger routines, including the additional routines launched on
+// striped configs. On KeepAll configs, each view is persisted on a new segment
+// named after its last index, so no ordering is required.
+func (ct *ConcTable) sharesPersistedState() bool {
+	cfg := ct.logs[0].config
+	return (ct.workers > 1 || len(cfg.diskFnames()) > 1) && !cfg.Inmem && !cfg.KeepAll
 }
 
-func (ct *ConcTable) reduceLog(cur int, count *int, secDisk bool) error {
-	err := ct.persistTable(cur, secDisk)
+func (ct *ConcTable) reduceLog(cur int, count *int) error {
+	err := ct.persistTable(cur, ct.nextReduceDisk())
 	if err != nil {
 		return err
 	}
@@ -426,7 +1465,7 @@ func (ct *ConcTable) reduceLog(cur int, count *int, secDisk bool) error {
 	if ct.logs[cur].config.Tick == Immediately {
 		*count++
 		if *count < resetOnImmediately {
-			ct.mu[cur].Unlock()
+			ct.releaseView(cur)
 			return nil
 		}
 		*count = 0
@@ -438,11 +1477,17 @@ func (ct *ConcTable) reduceLog(cur int, count *int, secDisk bool) error {
 
 	// clean 'cur' view state
 	ct.resetViewState(cur)
-	ct.mu[cur].Unlock()
+	ct.releaseView(cur)
 	return nil
 }
 
-func (ct *ConcTable) handleReduce(ctx context.Context, secDisk bool) {
+// releaseView clears the pending reduce flag of view 'id' and unlocks its mutex.
+func (ct *ConcTable) releaseView(id int) {
+	atomic.StoreInt32(&ct.busy[id], 0)
+	ct.mu[id].Unlock()
+}
+
+func (ct *ConcTable) handleReduce(ctx context.Context) {
 	var count int
 	for {
 		select {
@@ -450,19 +1495,43 @@ func (ct *ConcTable) handleReduce(ctx context.Context, secDisk bool) {
 			return
 
 		case event := <-ct.loggerReq:
-			err := ct.reduceLog(event.table, &count, secDisk)
+			err := ct.logs[event.table].config.Retry.do(ctx, func() error {
+				return ct.reduceLog(event.table, &count)
+			})
 			if err != nil {
-				log.Fatalln("failed during reduce procedure, err:", err.Error())
+				// view state is retained, and persisted on its next reduce
+				ct.logs[event.table].reportReduceErr(err)
+				ct.releaseView(event.table)
 			}
 
 			// requested latency measurement for persist
 			if event.measure != -1 {
-				ct.lm.perstLat[event.measure] = time.Now().UnixNano()
+				ct.lm.measurePersLatOf(event.measure)
 			}
 		}
 	}
 }
 
+// reduceOnTick advances the current view and triggers a reduce over it, if any command
+// was logged since its last reduce. Invoked by the ticker routine on TimeInterval config.
+func (ct *ConcTable) reduceOnTick() {
+	ct.curMu.Lock()
+	cur := ct.current
+	ct.mu[cur].Lock()
+
+	if !ct.logs[cur].logged {
+		ct.mu[cur].Unlock()
+		ct.curMu.Unlock()
+		return
+	}
+	ct.advanceCurrentView()
+	ct.curMu.Unlock()
+
+	// mutext will be later unlocked by the logger routine
+	atomic.StoreInt32(&ct.busy[cur], 1)
+	ct.loggerReq <- logEvent{cur, -1}
+}
+
 // readAndAdvanceCurrentView reads the current view id then advances it to the next
 // available identifier, returning the old observed value.
 func (ct *ConcTable) readAndAdvanceCurrentView() int {
@@ -482,15 +1551,14 @@ func (ct *ConcTable) advanceCurrentView() {
 // mayTriggerReduceOnView possibly triggers the reduce algorithm over the informed view
 // based on config params (e.g. interval period reached).
 func (ct *ConcTable) mayTriggerReduceOnView(id int) {
-	if ct.logs[id].config.Tick != Interval {
+	if !ct.logs[id].config.Tick.isPeriodic() {
 		return
 	}
-	ct.logs[id].count++
 
-	// reached reduce period or immediately config
-	if ct.logs[id].count >= ct.logs[id].config.Period {
-		ct.logs[id].count = 0
+	// reached reduce period
+	if ct.logs[id].reachedReducePeriod(len(ct.views[id])) {
 		// trigger reduce on view
+		atomic.StoreInt32(&ct.busy[id], 1)
 		ct.loggerReq <- logEvent{id, -1}
 	}
 }
@@ -499,50 +1567,89 @@ func (ct *ConcTable) mayTriggerReduceOnView(id int) {
 // and if the current view cursor must be advanced, following some specific rules:
 //
 // TODO: describe later...
-func (ct *ConcTable) willRequireReduceOnView(wrt bool, id int) (bool, bool) {
+func (ct *ConcTable) willRequireReduceOnView(cmd *pb.Command, id int) (bool, bool) {
 	// write operation and immediately config
+	wrt := isWriteOp(cmd.Op) || isRangeDelete(cmd.Op)
 	if wrt && ct.logs[id].config.Tick == Immediately {
 		return true, false
 	}
 
+	// reached an occupancy watermark, starting a new period
+	if ct.reachedViewWatermark(cmd, id) {
+		ct.logs[id].count = 0
+		return true, true
+	}
+
 	// read on immediately or delayed config, wont need reduce
-	if ct.logs[id].config.Tick != Interval {
+	if !ct.logs[id].config.Tick.isPeriodic() {
 		return false, false
 	}
-	ct.logs[id].count++
 
 	// reached reduce period
-	if ct.logs[id].count >= ct.logs[id].config.Period {
-		ct.logs[id].count = 0
+	if ct.logs[id].reachedReducePeriod(len(ct.views[id])) {
 		return true, true
 	}
 	return false, false
 }
 
-// mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
-// 'config.Period' wasnt reached yet. Returns true if reduce was executed, false otherwise.
-//
-// TODO: currently 'false' is always passed to persist procedure, which basically flushes
-// and stores to the primary disk even if config.ParallelIO is set. Adjust recovery procedure
-// implications later.
-func (ct *ConcTable) mayExecuteLazyReduce(id int) (bool, error) {
-	if ct.logs[id].config.Tick == Delayed {
-		ct.mu[id].Lock()
-		err := ct.persistTable(id, false)
-		if err != nil {
-			return true, err
-		}
+// reachedViewWatermark informs if recording 'cmd' on view 'id' reaches any of its
+// occupancy watermarks (i.e. config.ViewMaxKeys or config.ViewMaxBytes). Must be
+// called from mutual exclusion scope over the view.
+func (ct *ConcTable) reachedViewWatermark(cmd *pb.Command, id int) bool {
+	cfg := ct.logs[id].config
+	if cfg.ViewMaxKeys == 0 && cfg.ViewMaxBytes == 0 || !isWriteOp(cmd.Op) {
+		return false
+	}
 
-	} else if ct.logs[id].config.Tick == Interval && !ct.logs[id].firstReduceExists() {
-		ct.mu[id].Lock()
-		err := ct.persistTable(id, false)
-		if err != nil {
-			return true, err
+	if cfg.ViewMaxKeys > 0 {
+		keys := len(ct.views[id])
+		if _, ok := ct.views[id][cmd.Key]; !ok {
+			keys++
 		}
+		if keys >= cfg.ViewMaxKeys {
+			return true
+		}
+	}
+	return cfg.ViewMaxBytes > 0 && ct.logs[id].viewBytes+ct.views[id].insertBytes(cmd) >= cfg.ViewMaxBytes
+}
 
-	} else {
+// willTriggerReduceOnView informs if logging a command on view 'id' would trigger a
+// new reduce request, without modifying any counters.
+func (ct *ConcTable) willTriggerReduceOnView(wrt bool, id int) bool {
+	if wrt && ct.logs[id].config.Tick == Immediately {
+		return true
+	}
+	if !ct.logs[id].config.Tick.isPeriodic() {
+		return false
+	}
+	return ct.logs[id].nextReachesReducePeriod()
+}
+
+// mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
+// 'config.Period' wasnt reached yet. Returns true if reduce was executed, false otherwise.
+// On success, the view mutex remains locked if the reduce was executed. Lazy reduces
+// are distributed between disks on striped configs as any other reduce, and
+// recoveries later read the freshest of both states. A view with no command logged
+// since its last reduce is not reduced once a state was already persisted, which
+// would replace it by an empty one, so recoveries read the last reduced view instead.
+func (ct *ConcTable) mayExecuteLazyReduce(ctx context.Context, id int) (bool, error) {
+	cfg := ct.logs[id].config
+	if cfg.Tick != Delayed && !(cfg.Tick.isScheduled() && !ct.logs[id].firstReduceExists()) {
 		return false, nil
 	}
+
+	if err := lockCtx(ctx, ct.mu[id]); err != nil {
+		return false, err
+	}
+	if prev := atomic.LoadInt32(&ct.prevLog); !ct.logs[id].logged && ct.logs[prev].firstReduceExists() {
+		ct.mu[id].Unlock()
+		return false, nil
+	}
+	if err := ct.persistTableCtx(ctx, id, ct.nextReduceDisk()); err != nil {
+		ct.mu[id].Unlock()
+		return false, err
+	}
+	atomic.StoreInt32(&ct.prevLog, int32(id))
 	return true, nil
 }
 
@@ -558,28 +1665,138 @@ func (ct *ConcTable) retrieveCurrentViewCopy() minStateTable {
 // exclusion scope.
 func (ct *ConcTable) resetViewState(id int) {
 	ct.views[id] = make(minStateTable, 0)
+	ct.shared[id] = false
+	ct.order[id] = ct.order[id][:0]
 
 	// reset log data
+	ct.logs[id].viewBytes = 0
 	ct.logs[id].first, ct.logs[id].last = 0, 0
 	ct.logs[id].logged = false
+	ct.logs[id].tombs = nil
+}
+
+// detachSharedView replaces view 'id' by a copy if its table is shared with a snapshot,
+// so later writes never affect it. Must be called from mutual exclusion scope.
+func (ct *ConcTable) detachSharedView(id int) {
+	if !ct.shared[id] {
+		return
+	}
+	cp := make(minStateTable, len(ct.views[id]))
+	for k, st := range ct.views[id] {
+		cp[k] = st
+	}
+	ct.views[id] = cp
+	ct.shared[id] = false
+}
+
+// currentRangeDeletes returns the range deletes logged on the current view.
+func (ct *ConcTable) currentRangeDeletes() rangeTombs {
+	ct.curMu.Lock()
+	defer ct.curMu.Unlock()
+	return ct.logs[ct.current].tombs
 }
 
 // executeReduceAlgOnView applies the configured reduce algorithm on a conflict-free view,
 // mutual exclusion is done by outer scope.
 func (ct *ConcTable) executeReduceAlgOnView(id int) ([]pb.Command, error) {
+	ct.logs[id].cpu.acquire()
+	defer ct.logs[id].cpu.release()
+
+	if ld := &ct.logs[id]; ld.config.AlgName != "" {
+		cmds, err := ld.applyRegisteredReducer(ConcTableKind, ct.views[id].chainStates(), ld.first, ld.last)
+		if err != nil {
+			return nil, err
+		}
+		return ld.applyRangeDeletes(cmds, 0, ^uint64(0)), nil
+	}
 	switch ct.logs[id].config.Alg {
 	case IterConcTable:
-		return IterConcTableOnView(&ct.views[id]), nil
+		cmds := iterConcTableOnView(&ct.views[id], nil, ct.logs[id].cpu)
+		return ct.logs[id].applyRangeDeletes(cmds, 0, ^uint64(0)), nil
 	}
 	return nil, errors.New("unsupported reduce algorithm for a ConcTable structure")
 }
 
+// SetMeasure enables or disables latency measurement on a live table, without
+// interrupting logging. Measurements drawn before disabling are still completed
+// by their reduce, and tuples captured so far are retained, being recorded into
+// the sink on Shutdown. Enabling requires a config.Period, the measured interval.
+func (ct *ConcTable) SetMeasure(on bool) error {
+	ct.curMu.Lock()
+	defer ct.curMu.Unlock()
+
+	if !on {
+		atomic.StoreInt32(&ct.msr, 0)
+		return nil
+	}
+	if ct.lm == nil {
+		cfg := ct.logs[0].config
+		if cfg.Period == 0 {
+			return errors.New("invalid config: if latency measurement is set (i.e. Measure == true), a config.Period must be provided as the measured interval")
+		}
+		fn := ct.logFolder + "bl-" + strconv.Itoa(int(cfg.Period)) + "-latency.out"
+		sink, err := openMeasureSink(cfg, fn)
+		if err != nil {
+			return err
+		}
+		ct.lm = newLatencyMeasure(int(cfg.Period), cfg, sink)
+	}
+	atomic.StoreInt32(&ct.msr, 1)
+	return nil
+}
+
+// Measuring informs if latency measurement is currently enabled.
+func (ct *ConcTable) Measuring() bool {
+	return atomic.LoadInt32(&ct.msr) == 1
+}
+
+// activeMeasure returns the latency measure if enabled, nil otherwise. Must be called
+// from 'curMu' mutual exclusion scope.
+func (ct *ConcTable) activeMeasure() *latencyMeasure {
+	if atomic.LoadInt32(&ct.msr) == 0 {
+		return nil
+	}
+	return ct.lm
+}
+
+// Latencies returns the aggregated latency histograms, or nil if latency measurement
+// was never enabled.
+func (ct *ConcTable) Latencies() *LatencyHistograms {
+	ct.curMu.Lock()
+	lm := ct.lm
+	ct.curMu.Unlock()
+
+	if lm == nil {
+		return nil
+	}
+	return lm.histograms()
+}
+
+// Subscribe returns a channel of 'buf' capacity emitting every reduced view once
+// persisted, allowing followers and backup services to tail the compacted state
+// instead of polling 'RecovEntireLog'. Events are never blocked by a full channel,
+// being discarded and accounted on the 'Missed' field of the next delivered event.
+// The returned function cancels the subscription, closing the channel, which is also
+// closed on Shutdown.
+func (ct *ConcTable) Subscribe(buf int) (<-chan SegmentEvent, func()) {
+	return ct.logs[0].feed.subscribe(buf)
+}
+
 // Shutdown ...
 func (ct *ConcTable) Shutdown() {
 	ct.canc()
-	if ct.msr {
-		ct.lm.flush()
-		ct.lm.close()
+	if gc := ct.logs[0].gc; gc != nil {
+		gc.close()
+	}
+	ct.logs[0].closeJournal()
+	ct.logs[0].feed.close()
+	ct.curMu.Lock()
+	lm := ct.lm
+	ct.curMu.Unlock()
+
+	if lm != nil {
+		lm.flush()
+		lm.close()
 	}
 }
 
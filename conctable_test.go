@@ -2,10 +2,17 @@ package beelog
 
 import (
 	"bytes"
-	"encoding/binary"
-	"fmt"
-	"io"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,8 +22,6 @@ import (
 
 func TestConcTableRecovEntireLog(t *testing.T) {
 	nCmds, wrt, dif := uint64(2000), 50, 100
-	concRecov := false
-
 	cfgs := []LogConfig{
 		{
 			Inmem:   false,
@@ -29,67 +34,89 @@ func TestConcTableRecovEntireLog(t *testing.T) {
 	}
 
 	for _, cf := range cfgs {
-		var st Structure
-		var err error
-
 		// clean state before creating
 		if err := cleanAllLogStates(); err != nil {
 			t.Log(err.Error())
 			t.FailNow()
 		}
 
-		st, err = generateRandStructure(4, nCmds, wrt, dif, &cf)
+		st, err := generateRandStructure(4, nCmds, wrt, dif, &cf)
 		if err != nil {
 			t.Log(err.Error())
 			t.FailNow()
 		}
+		ct := st.(*ConcTable)
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
 
-		if concRecov {
-			ch, num, err := st.(*ConcTable).RecovEntireLogConc()
-			if err != nil {
-				t.Log(err.Error())
-				t.FailNow()
-			}
-			fmt.Println("now reading ch...")
-
-			for {
-				raw, ok := <-ch
-				if !ok { // closed ch
-					fmt.Println("closed!")
-					break
-				}
-				fmt.Println("got one, deserializing")
-
-				log, err := deserializeRawLogStream(raw, num)
-				if err == io.EOF {
-					t.Log("empty log")
-					t.Fail()
+		raw, num, err := ct.RecovEntireLog()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		log, err := DecodeEntireLogStream(bytes.NewReader(raw))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
 
-				} else if err != nil {
-					t.Log("error while deserializing log, err:", err.Error())
-					t.FailNow()
-				}
+		ch, cnum, err := ct.RecovEntireLogConc(context.Background(), 3)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if cnum != num {
+			t.Log("expected", num, "segments on concurrent recovery, got", cnum)
+			t.FailNow()
+		}
 
-				t.Log("got one log:", log)
-				// TODO: test log...
+		concLog := make([]pb.Command, 0, len(log))
+		var segs int
+		var last uint64
+		for seg := range ch {
+			if seg.Err != nil {
+				t.Log(seg.Err.Error())
+				t.FailNow()
 			}
+			if segs > 0 && seg.First <= last {
+				t.Log("segment", seg.Name, "streamed out of order")
+				t.FailNow()
+			}
+			last = seg.Last
+			segs++
 
-		} else {
-			raw, num, err := st.(*ConcTable).RecovEntireLog()
+			cmds, err := UnmarshalLogFromBytes(seg.Raw)
 			if err != nil {
 				t.Log(err.Error())
 				t.FailNow()
 			}
+			concLog = append(concLog, cmds...)
+		}
 
-			log, err := deserializeRawLogStream(raw, num)
-			if err != nil {
-				t.Log(err.Error())
+		if segs != num || len(concLog) != len(log) {
+			t.Log("expected", num, "segments with", len(log), "commands, got", segs, "with", len(concLog))
+			t.FailNow()
+		}
+		for i := range log {
+			if !proto.Equal(&log[i], &concLog[i]) {
+				t.Log("expected command", log[i].String(), "got", concLog[i].String())
 				t.FailNow()
 			}
+		}
 
-			t.Log("got log:", log)
-			// TODO: test log...
+		// channel must be closed once recovery is cancelled
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, _, err = ct.RecovEntireLogConc(ctx, 1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
 		}
+		<-ch
+		cancel()
+		for range ch {
+		}
+		ct.Shutdown()
 	}
 
 	if err := cleanAllLogStates(); err != nil {
@@ -98,6 +125,55 @@ func TestConcTableRecovEntireLog(t *testing.T) {
 	}
 }
 
+func TestDecodeEntireLogStream(t *testing.T) {
+	segs := make([][]byte, 0, 3)
+	exp := make([]pb.Command, 0)
+	for i := uint64(0); i < 3; i++ {
+		log := []pb.Command{
+			{Id: i * 10, Op: pb.Command_SET, Key: "a", Value: strconv.Itoa(int(i))},
+			{Id: i*10 + 5, Op: pb.Command_SET, Key: "b", Value: strconv.Itoa(int(i))},
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := MarshalLogIntoWriter(buf, &log, i*10, i*10+9); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		segs = append(segs, buf.Bytes())
+		exp = append(exp, log...)
+	}
+
+	raw := encodeEntireLog(segs)
+	log, err := DecodeEntireLogStream(bytes.NewReader(raw))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) != len(exp) {
+		t.Log("expected", len(exp), "commands, got", len(log))
+		t.FailNow()
+	}
+	for i := range exp {
+		if !proto.Equal(&exp[i], &log[i]) {
+			t.Log("expected command", exp[i].String(), "got", log[i].String())
+			t.FailNow()
+		}
+	}
+
+	// corrupt a single byte of the last segment
+	bad := append([]byte(nil), raw...)
+	bad[len(bad)-10] ^= 0xff
+	if _, err := DecodeEntireLogStream(bytes.NewReader(bad)); !errors.Is(err, ErrSegmentChecksum) {
+		t.Log("expected a checksum mismatch, got", err)
+		t.FailNow()
+	}
+
+	// truncated envelopes must fail instead of returning fewer segments
+	if _, err := DecodeEntireLogStream(bytes.NewReader(raw[:len(raw)-20])); err == nil {
+		t.Log("expected an error decoding a truncated envelope")
+		t.FailNow()
+	}
+}
+
 func TestConcTableLatencyMeasurementAndSync(t *testing.T) {
 	nCmds, wrt, dif := uint64(2000), 50, 100
 	cfgs := []LogConfig{
@@ -109,17 +185,12 @@ func TestConcTableLatencyMeasurementAndSync(t *testing.T) {
 			Alg:     IterConcTable,
 			Tick:    Interval,
 			Period:  200,
-			Fname:   "./logstate.log",
 		},
 	}
 
 	for _, cf := range cfgs {
-		// clean state before creating
-		err := cleanAllLogStates()
-		if err != nil {
-			t.Log(err.Error())
-			t.FailNow()
-		}
+		// log states and latency metrics are written under the test dir
+		cf.Fname = filepath.Join(t.TempDir(), "logstate.log")
 
 		// latency is already recorded while being generated
 		st, err := generateRandStructure(4, nCmds, wrt, dif, &cf)
@@ -128,40 +199,45 @@ func TestConcTableLatencyMeasurementAndSync(t *testing.T) {
 			t.FailNow()
 		}
 
-		// persist latency metrics by during shutdown
-		st.(*ConcTable).Shutdown()
-	}
+		// wait for pending reduces, then persist latency metrics during shutdown
+		ct := st.(*ConcTable)
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		ct.Shutdown()
 
-	if err := cleanAllLogStates(); err != nil {
-		t.Log(err.Error())
-		t.FailNow()
+		fn := filepath.Join(filepath.Dir(cf.Fname), "bl-"+strconv.Itoa(int(cf.Period))+"-latency.out")
+		if _, err := os.Stat(fn); err != nil {
+			t.Log("expected latency metrics under the log folder, err:", err.Error())
+			t.FailNow()
+		}
 	}
 }
 
 func TestConcTableParallelIO(t *testing.T) {
 	nCmds, wrt, dif := uint64(800), 50, 200
 
-	primDir := t.TempDir()
-	secdDir := t.TempDir()
-	cfgs := []LogConfig{
-		{
-			KeepAll:     true,
-			Alg:         IterConcTable,
-			Tick:        Interval,
-			Period:      200,
-			Fname:       primDir + "/logstate.log",
-			ParallelIO:  true,
-			SecondFname: secdDir + "/logstate2.log",
-		},
-	}
+	for _, mode := range []ParallelIOMode{RoundRobin, Mirror} {
+		primDir, secdDir := t.TempDir(), t.TempDir()
+		cf := LogConfig{
+			KeepAll:      true,
+			Alg:          IterConcTable,
+			Tick:         Interval,
+			Period:       200,
+			Fname:        primDir + "/logstate.log",
+			ParallelIO:   true,
+			SecondFname:  secdDir + "/logstate2.log",
+			ParallelMode: mode,
+		}
 
-	for _, cf := range cfgs {
-		// log files should be interchanged between primary and second fns
-		_, err := generateRandStructure(4, nCmds, wrt, dif, &cf)
+		// log files should be interchanged between primary and second fns, or
+		// mirrored on both
+		st, err := generateRandStructure(4, nCmds, wrt, dif, &cf)
 		if err != nil {
 			t.Log(err.Error())
 			t.FailNow()
 		}
+		ct := st.(*ConcTable)
 
 		// must wait concurrent persistence...
 		time.Sleep(time.Second)
@@ -178,66 +254,1883 @@ func TestConcTableParallelIO(t *testing.T) {
 			t.FailNow()
 		}
 
-		if p, s := len(logsPrim), len(logsSecd); p != s {
+		if p, s := len(logsPrim), len(logsSecd); p != s || p == 0 {
 			t.Log("With an even interval config, expected primary and secondary locations to have the same number of log files")
 			t.Log("PRIMARY HAS:", p)
 			t.Log("SECONDARY HAS:", s)
 			t.FailNow()
 		}
+
+		// mirrored segments are recovered from the secondary disk once lost
+		if mode == Mirror {
+			if err := os.Remove(logsPrim[0]); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		_, num, err := ct.RecovEntireLog()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if exp := int(nCmds) / int(cf.Period); num != exp {
+			t.Log("expected", exp, "recovered segments, got", num)
+			t.FailNow()
+		}
+		ct.Shutdown()
 	}
 }
 
-// deserializeRawLogStream emulates the same procedure implemented by a recov
-// replica, interpreting the serialized log stream received from RecovEntireLog
-// different calls.
-func deserializeRawLogStream(stream []byte, size int) ([]pb.Command, error) {
-	rd := bytes.NewReader(stream)
-	cmds := make([]pb.Command, 0, 256*size)
+func TestConcTableParallelIOMmap(t *testing.T) {
+	cfg := &LogConfig{
+		Alg:         IterConcTable,
+		Tick:        Interval,
+		Period:      10,
+		Fname:       t.TempDir() + "/logstate.log",
+		ParallelIO:  true,
+		SecondFname: t.TempDir() + "/logstate2.log",
+		Mmap:        true,
+	}
+	ct, err := NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
 
-	for i := 0; i < size; i++ {
-		// read the retrieved log interval
-		var f, l uint64
-		var ln int
-		_, err := fmt.Fscanf(rd, "%d\n%d\n%d\n", &f, &l, &ln)
-		if err != nil {
-			return nil, err
+	for i := uint64(1); i <= 40; i++ {
+		if err := ct.Log(pb.Command{Id: i, Op: pb.Command_SET, Key: "k", Value: strconv.FormatUint(i, 10)}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
 		}
+	}
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
 
-		for j := 0; j < ln; j++ {
-			var commandLength int32
-			err = binary.Read(rd, binary.BigEndian, &commandLength)
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return nil, err
+	// mapped states must be read from the disk holding the latest reduce, and not
+	// always from the primary one
+	cmds, err := ct.Recov(1, 40)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(cmds) != 1 || cmds[0].Id != 40 {
+		t.Log("expected the state logged at index 40, got:", cmds)
+		t.FailNow()
+	}
+
+	raw, err := ct.RecovBytes(1, 40)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	cmds, err = UnmarshalLogFromBytes(raw)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(cmds) != 1 || cmds[0].Id != 40 {
+		t.Log("expected the state logged at index 40 on raw recovery, got:", cmds)
+		t.FailNow()
+	}
+}
+
+func TestConcTableSetConcLevel(t *testing.T) {
+	nCmds, dif := uint64(300), 50
+	cfg := &LogConfig{
+		Inmem: true,
+		Alg:   IterConcTable,
+		Tick:  Delayed,
+	}
+
+	ct, err := NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	// reference structure, logging the same sequence of commands
+	ref := NewListHT()
+	levels := []int{4, 3, 1}
+
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := pb.Command{
+			Id:    i,
+			Key:   strconv.Itoa(int(i) % dif),
+			Value: strconv.Itoa(int(i)),
+			Op:    pb.Command_SET,
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		ref.Log(cmd)
+
+		// resize every 'nCmds / levels' commands, advancing the current view
+		// to spread state between different views
+		if (i+1)%(nCmds/uint64(len(levels))) == 0 {
+			lvl := levels[int(i/(nCmds/uint64(len(levels))))]
+			ct.curMu.Lock()
+			ct.advanceCurrentView()
+			ct.curMu.Unlock()
+
+			if err := ct.SetConcLevel(lvl); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if ct.concLevel != lvl || len(ct.views) != lvl || len(ct.mu) != lvl || len(ct.logs) != lvl {
+				t.Log("expected", lvl, "views after resize, got", len(ct.views))
+				t.FailNow()
 			}
+		}
+	}
 
-			serializedCmd := make([]byte, commandLength)
-			_, err = rd.Read(serializedCmd)
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return nil, err
+	exp, err := ref.Recov(0, nCmds-1)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	log, err := ct.Recov(0, nCmds-1)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if !logsAreEquivalent(exp, log) {
+		t.Log("Logs are not equivalent after resizes")
+		t.Log("EXPC:", exp)
+		t.Log("RECV:", log)
+		t.FailNow()
+	}
+
+	if err := ct.SetConcLevel(0); err == nil {
+		t.Log("expected an error on non-positive concLevel")
+		t.FailNow()
+	}
+}
+
+func TestConcTableSetConcLevelConcurrent(t *testing.T) {
+	nCmds, dif, writers := 500, 20, 4
+	cfg := &LogConfig{
+		Inmem:    true,
+		Alg:      IterConcTable,
+		Tick:     Delayed,
+		Ordering: RejectOutOfOrder,
+	}
+
+	ct, err := NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	// views are resized while concurrently logged, each writer updating its own keys
+	var id uint64
+	var mu sync.Mutex
+	exp := make(map[string]string)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < nCmds; i++ {
+				key := strconv.Itoa(w) + "-" + strconv.Itoa(i%dif)
+				val := strconv.Itoa(i)
+
+				// ids are admitted in order, so commands are logged within the same scope
+				mu.Lock()
+				cmd := pb.Command{Id: id, Op: pb.Command_SET, Key: key, Value: val}
+				id++
+				err := ct.Log(cmd)
+				exp[key] = val
+				mu.Unlock()
+
+				if err != nil {
+					errs <- err
+					return
+				}
 			}
+		}(w)
+	}
 
-			c := &pb.Command{}
-			err = proto.Unmarshal(serializedCmd, c)
-			if err != nil {
-				fmt.Println("could not parse")
-				return nil, err
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	levels := []int{2, 6, 1, 4}
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+		default:
+			if err := ct.SetConcLevel(levels[i%len(levels)]); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
 			}
-			cmds = append(cmds, *c)
+			continue
+		}
+		break
+	}
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// every view is merged into a single one before recovering
+	if err := ct.SetConcLevel(1); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	log, err := ct.Recov(0, id-1)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if len(log) != len(exp) {
+		t.Log("expected", len(exp), "keys after concurrent resizes, got", len(log))
+		t.FailNow()
+	}
+	for _, c := range log {
+		if exp[c.Key] != c.Value {
+			t.Log("key", c.Key, "expected value", exp[c.Key], "got", c.Value)
+			t.FailNow()
 		}
+	}
+}
+
+func TestShardedConcTableRecov(t *testing.T) {
+	nCmds, dif := uint64(2000), 100
+	dir := t.TempDir()
+
+	cfgs := []LogConfig{
+		{
+			Inmem:  true,
+			Alg:    IterConcTable,
+			Tick:   Delayed,
+			Shards: 4,
+		},
+		{
+			KeepAll: true,
+			Alg:     IterConcTable,
+			Tick:    Interval,
+			Period:  100,
+			Fname:   dir + "/logstate.log",
+			Shards:  3,
+		},
+	}
 
-		var eol string
-		_, err = fmt.Fscanf(rd, "\n%s\n", &eol)
+	for _, cf := range cfgs {
+		sh, err := NewShardedConcTableWithConfig(context.TODO(), defaultConcLvl, &cf)
 		if err != nil {
-			return nil, err
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		ref := NewListHT()
+
+		for i := uint64(0); i < nCmds; i++ {
+			cmd := pb.Command{
+				Id:    i,
+				Key:   strconv.Itoa(int(i) % dif),
+				Value: strconv.Itoa(int(i)),
+				Op:    pb.Command_SET,
+			}
+			if err := sh.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			ref.Log(cmd)
+		}
+
+		if cf.Inmem {
+			exp, err := ref.Recov(0, nCmds-1)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			log, err := sh.Recov(0, nCmds-1)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			if !logsAreEquivalent(exp, log) {
+				t.Log("Merged shard logs are not equivalent")
+				t.Log("EXPC:", exp)
+				t.Log("RECV:", log)
+				t.FailNow()
+			}
+
+		} else {
+			// must wait concurrent persistence...
+			time.Sleep(time.Second)
+
+			raw, num, err := sh.RecovEntireLog()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			fs, err := filepath.Glob(dir + "/*.log")
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if num != len(fs) {
+				t.Log("expected", len(fs), "log files, got", num)
+				t.FailNow()
+			}
+
+			if _, err := DecodeEntireLogStream(bytes.NewReader(raw)); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
 		}
+		sh.Shutdown()
+	}
+}
+
+func TestConcTableGroupCommit(t *testing.T) {
+	nCmds, wrt, dif := uint64(1000), 100, 50
+	dir := t.TempDir()
+
+	cfg := LogConfig{
+		Sync:        true,
+		GroupCommit: time.Millisecond,
+		Alg:         IterConcTable,
+		Tick:        Immediately,
+		Fname:       dir + "/logstate.log",
+	}
+
+	st, err := generateRandStructure(4, nCmds, wrt, dif, &cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	ct := st.(*ConcTable)
+
+	// must wait concurrent persistence...
+	time.Sleep(100 * time.Millisecond)
+	gc := ct.logs[0].gc
+	if gc == nil {
+		t.Log("expected a group committer on Sync config")
+		t.FailNow()
+	}
 
-		if eol != "EOL" {
-			return nil, fmt.Errorf("expected EOL flag, got '%s'", eol)
+	// every view must share the same committer
+	for i := range ct.logs {
+		if ct.logs[i].gc != gc {
+			t.Log("views dont share the same group committer")
+			t.FailNow()
 		}
 	}
-	return cmds, nil
+	ct.Shutdown()
+
+	gc.mu.Lock()
+	pending := gc.batch != nil
+	gc.mu.Unlock()
+	if pending {
+		t.Log("expected no pending commits after shutdown")
+		t.FailNow()
+	}
+
+	fd, err := os.Open(cfg.Fname)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer fd.Close()
+
+	if _, err := UnmarshalLogFromReader(fd); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	invalid := LogConfig{Inmem: true, GroupCommit: time.Millisecond}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on GroupCommit without Sync")
+		t.FailNow()
+	}
+}
+
+// fakeSegment is a Segment whose Sync fails with 'err' and Close with 'closeErr',
+// recording every sync.
+type fakeSegment struct {
+	err      error
+	closeErr error
+	synced   int32
+}
+
+func (fs *fakeSegment) Write(p []byte) (int, error)              { return len(p), nil }
+func (fs *fakeSegment) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
+func (fs *fakeSegment) Close() error                             { return fs.closeErr }
+
+func (fs *fakeSegment) Sync() error {
+	atomic.StoreInt32(&fs.synced, 1)
+	return fs.err
+}
+
+func TestGroupCommitBatchErrors(t *testing.T) {
+	// never flushed by its timer during the test
+	gc := newGroupCommitter(time.Hour)
+	failed := &fakeSegment{err: errors.New("fsync failed")}
+	ok := &fakeSegment{}
+
+	errs := make(chan error, 2)
+	go func() { errs <- gc.commit("a.log", failed) }()
+	go func() { errs <- gc.commit("b.log", ok) }()
+
+	for {
+		gc.mu.Lock()
+		n := 0
+		if gc.batch != nil {
+			n = len(gc.batch.pending)
+		}
+		gc.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-errs:
+		t.Log("commit returned before its batch was flushed")
+		t.FailNow()
+	default:
+	}
+
+	if err := gc.flush(); err == nil {
+		t.Log("expected the fsync error on flush")
+		t.FailNow()
+	}
+	// every committer of the batch must observe its error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Log("expected the batch fsync error on every commit")
+			t.FailNow()
+		}
+	}
+
+	// a later batch must not inherit errors of prior ones
+	next := &fakeSegment{}
+	go func() { errs <- gc.commit("a.log", next) }()
+	for {
+		gc.mu.Lock()
+		armed := gc.batch != nil
+		gc.mu.Unlock()
+		if armed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := gc.close(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := <-errs; err != nil {
+		t.Log("unexpected error on a succeeded batch:", err.Error())
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&next.synced) != 1 {
+		t.Log("expected the segment to be synced once commit returns")
+		t.FailNow()
+	}
+
+	// failed closes of superseded descriptors are reported to their batch
+	superseded, latest := &fakeSegment{closeErr: errors.New("close failed")}, &fakeSegment{}
+	for _, seg := range []*fakeSegment{superseded, latest} {
+		seg := seg
+		go func() { errs <- gc.commit("a.log", seg) }()
+		for {
+			gc.mu.Lock()
+			pending := gc.batch != nil && gc.batch.pending["a.log"] == Segment(seg)
+			gc.mu.Unlock()
+			if pending {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := gc.flush(); err == nil {
+		t.Log("expected the close error of a superseded descriptor on flush")
+		t.FailNow()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Log("expected the close error on every commit of the batch")
+			t.FailNow()
+		}
+	}
+}
+
+// syncStorage is a memStorage recording which segments were synced since created.
+type syncStorage struct {
+	*memStorage
+	mu     sync.Mutex
+	synced map[string]bool
+}
+
+type syncSegment struct {
+	Segment
+	st   *syncStorage
+	name string
+}
+
+func (sg *syncSegment) Sync() error {
+	sg.st.mu.Lock()
+	defer sg.st.mu.Unlock()
+	sg.st.synced[sg.name] = true
+	return sg.Segment.Sync()
+}
+
+func (ss *syncStorage) Create(name string) (Segment, error) {
+	seg, err := ss.memStorage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.synced[name] = false
+	return &syncSegment{Segment: seg, st: ss, name: name}, nil
+}
+
+func TestGroupCommitHookAfterSync(t *testing.T) {
+	st := &syncStorage{memStorage: newMemStorage(), synced: make(map[string]bool)}
+	var (
+		mu       sync.Mutex
+		persists int
+		unsynced []string
+	)
+
+	cfg := &LogConfig{
+		Sync:        true,
+		GroupCommit: 5 * time.Millisecond,
+		Tick:        Immediately,
+		Fname:       "logstate.log",
+		Storage:     st,
+		Hooks: &Hooks{
+			OnPersist: func(file string, bytes int64) {
+				st.mu.Lock()
+				synced := st.synced[file]
+				st.mu.Unlock()
+
+				mu.Lock()
+				defer mu.Unlock()
+				persists++
+				if !synced {
+					unsynced = append(unsynced, file)
+				}
+			},
+		},
+	}
+
+	l, err := NewListHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer l.Shutdown()
+
+	for i := uint64(0); i < 10; i++ {
+		cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i % 3)), Value: "v"}
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if persists == 0 {
+		t.Log("expected OnPersist to be invoked")
+		t.FailNow()
+	}
+	if len(unsynced) > 0 {
+		t.Log("OnPersist invoked before the group commit synced:", unsynced)
+		t.FailNow()
+	}
+}
+
+// openStorage is a memStorage counting segments not yet closed.
+type openStorage struct {
+	*memStorage
+	open int32 // atomic
+}
+
+type openSegment struct {
+	Segment
+	st *openStorage
+}
+
+func (sg *openSegment) Close() error {
+	atomic.AddInt32(&sg.st.open, -1)
+	return sg.Segment.Close()
+}
+
+func (ost *openStorage) Create(name string) (Segment, error) {
+	seg, err := ost.memStorage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&ost.open, 1)
+	return &openSegment{Segment: seg, st: ost}, nil
+}
+
+func TestConcTableInitFailureClosesJournal(t *testing.T) {
+	dir := t.TempDir()
+	st := &openStorage{memStorage: newMemStorage()}
+	cfg := &LogConfig{
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  10,
+		Fname:   filepath.Join(dir, "logstate.log"),
+		Storage: st,
+		Journal: true,
+		Measure: true,
+	}
+
+	// latency output cant be created over a directory
+	if err := os.Mkdir(filepath.Join(dir, "bl-10-latency.out"), 0755); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg); err == nil {
+		t.Log("expected an error opening the latency output")
+		t.FailNow()
+	}
+	if n := atomic.LoadInt32(&st.open); n != 0 {
+		t.Log("expected the journal to be closed on failure,", n, "segments still open")
+		t.FailNow()
+	}
+}
+
+func TestConcTableMergeSegments(t *testing.T) {
+	nCmds, dif, wrt := uint64(1000), 50, 50
+	dir := t.TempDir()
+	cfg := &LogConfig{
+		Inmem:   false,
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  100,
+		Fname:   dir + "/logstate.log",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ct, err := NewConcTableWithConfig(ctx, defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	// reference reduced log over the entire interval
+	lt := NewListHT()
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		lt.Log(cmd)
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	exp := GreedyListHT(lt, 0, nCmds-1)
+
+	// must wait concurrent persistence...
+	time.Sleep(time.Second)
+
+	fs, err := filepath.Glob(dir + "/*.log")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := MergeSegments(fs, buff); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	rd, hdr, err := ReadLogHeader(buff)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if hdr.First != 0 || hdr.Last != nCmds-1 {
+		t.Logf("expected merged interval [0, %d], got [%d, %d]", nCmds-1, hdr.First, hdr.Last)
+		t.FailNow()
+	}
+
+	log, err := unmarshalBeelog(rd, hdr.Version, hdr.Len)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if !logsAreEquivalent(exp, log) {
+		t.Log("merged segments differ from the reduced log")
+		t.Log("EXPC:", exp)
+		t.Log("MERG:", log)
+		t.FailNow()
+	}
+}
+
+func TestConcTableBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := LogConfig{
+		Inmem:        true,
+		Alg:          IterConcTable,
+		Tick:         Interval,
+		Period:       1,
+		Backpressure: RejectWhenBusy,
+	}
+
+	// stopped logger routine, every reduce request remains pending
+	ct, err := NewConcTableWithConfig(ctx, 2, &cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	cmd := pb.Command{Op: pb.Command_SET, Key: "0", Value: "0"}
+	for i := 0; i < 2; i++ {
+		cmd.Id = uint64(i)
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if p := ct.Pending(); p != 2 {
+		t.Log("expected 2 pending reduces, got", p)
+		t.FailNow()
+	}
+
+	// every view is now awaiting the logger routine
+	cmd.Id = 2
+	if err := ct.Log(cmd); err != ErrBusy {
+		t.Log("expected ErrBusy on a saturated table, got", err)
+		t.FailNow()
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	cfg.Backpressure = SyncWhenBusy
+	lvl := chanBuffSize + 10
+
+	ct, err = NewConcTableWithConfig(ctx, lvl, &cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	ct.canc()
+	time.Sleep(10 * time.Millisecond)
+
+	// requests beyond the logger queue capacity are reduced by the caller
+	for i := 0; i < lvl-1; i++ {
+		cmd.Id = uint64(i)
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if p := ct.Pending(); p != chanBuffSize {
+		t.Log("expected", chanBuffSize, "pending reduces, got", p)
+		t.FailNow()
+	}
+
+	invalid := LogConfig{Inmem: true, Backpressure: RejectWhenBusy + 1}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on unknown Backpressure policy")
+		t.FailNow()
+	}
+}
+
+func TestConcTableIntervalIndex(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	cfg := &LogConfig{
+		Inmem:   false,
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  100,
+		Fname:   "./logstate.log",
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	st, err := generateRandStructure(4, nCmds, wrt, dif, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	ct := st.(*ConcTable)
+
+	// wait for pending reduces on the logger routine
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, total, err := ct.RecovEntireLog()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	_, num, err := ct.RecovEntireLogInterval(0, nCmds)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if num != total {
+		t.Log("expected", total, "indexed segments on the entire interval, got", num)
+		t.FailNow()
+	}
+
+	p, n := nCmds/4, nCmds/2
+	_, num, err = ct.RecovEntireLogInterval(p, n)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if num == 0 || num >= total {
+		t.Log("expected only a subset of the", total, "segments to overlap the interval, got", num)
+		t.FailNow()
+	}
+
+	// a reloaded index must retain the same segments
+	ix := newIntervalIndex(indexFname(cfg.Fname))
+	segs, err := ix.overlapping(defaultStorage, p, n)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(segs) != num {
+		t.Log("reloaded index returned", len(segs), "segments, expected", num)
+		t.FailNow()
+	}
+	for _, sg := range segs {
+		if sg.Last < p || sg.First > n {
+			t.Log("segment", sg.Name, "covering", sg.First, sg.Last, "doesnt overlap the interval")
+			t.FailNow()
+		}
+	}
+
+	ct.Shutdown()
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestConcTableReduceWorkers(t *testing.T) {
+	nCmds, period := 4000, uint32(100)
+	cfgs := []*LogConfig{
+		{
+			Inmem:         false,
+			KeepAll:       true,
+			Alg:           IterConcTable,
+			Tick:          Interval,
+			Period:        period,
+			Fname:         "./logstate.log",
+			ReduceWorkers: 4,
+		},
+		{
+			Inmem:         false,
+			Alg:           IterConcTable,
+			Tick:          Interval,
+			Period:        period,
+			Fname:         "./logstate.log",
+			ReduceWorkers: 4,
+		},
+		{
+			// background reduces throttled below the number of logger routines
+			Inmem:         false,
+			KeepAll:       true,
+			Alg:           IterConcTable,
+			Tick:          Interval,
+			Period:        period,
+			Fname:         "./logstate.log",
+			ReduceWorkers: 4,
+			MaxReduces:    2,
+			ReduceYield:   16,
+		},
+	}
+
+	for i, cfg := range cfgs {
+		if err := cleanAllLogStates(); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		ct, err := NewConcTableWithConfig(context.Background(), 8, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		for j := 0; j < nCmds; j++ {
+			cmd := pb.Command{
+				Id:    uint64(j),
+				Op:    pb.Command_SET,
+				Key:   strconv.Itoa(j % 50),
+				Value: strconv.Itoa(j),
+			}
+			if err := ct.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// wait for pending reduces on every logger routine
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if cfg.KeepAll {
+			_, num, err := ct.RecovEntireLog()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if exp := nCmds / int(period); num != exp {
+				t.Log("config", i, ": expected", exp, "persisted segments, got", num)
+				t.FailNow()
+			}
+
+		} else {
+			// the shared persisted state must be the most recent view
+			fd, err := os.Open(cfg.Fname)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			_, hdr, err := ReadLogHeader(fd)
+			fd.Close()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if hdr.Last != uint64(nCmds-1) {
+				t.Log("config", i, ": expected the latest view persisted, got last index", hdr.Last)
+				t.FailNow()
+			}
+		}
+		ct.Shutdown()
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+// in-flight reduces of the "inflight" reducer, and the highest count observed
+var inflightReduces, maxInflightReduces int32
+
+func TestConcTableMaxReduces(t *testing.T) {
+	nCmds, period := 2000, uint32(50)
+
+	// retains every state, sleeping while counted as in-flight
+	inflight := func(states []pb.Command, p, n uint64) ([]pb.Command, error) {
+		cur := atomic.AddInt32(&inflightReduces, 1)
+		defer atomic.AddInt32(&inflightReduces, -1)
+		for {
+			max := atomic.LoadInt32(&maxInflightReduces)
+			if cur <= max || atomic.CompareAndSwapInt32(&maxInflightReduces, max, cur) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return states, nil
+	}
+	if _, ok := lookupReducer("inflight", ConcTableKind); !ok {
+		if err := RegisterReducer("inflight", ConcTableKind, inflight); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// unbounded configs reduce on every logger routine at once
+	for _, max := range []int{0, 1, 2} {
+		atomic.StoreInt32(&maxInflightReduces, 0)
+		cfg := &LogConfig{
+			Inmem:         true,
+			Tick:          Interval,
+			Period:        period,
+			AlgName:       "inflight",
+			ReduceWorkers: 4,
+			MaxReduces:    max,
+		}
+		ct, err := NewConcTableWithConfig(context.Background(), 8, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		for i := 0; i < nCmds; i++ {
+			cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 50), Value: strconv.Itoa(i)}
+			if err := ct.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		ct.Shutdown()
+
+		got := int(atomic.LoadInt32(&maxInflightReduces))
+		if max == 0 && got <= 2 {
+			t.Log("expected more than 2 concurrent reduces without MaxReduces, got", got)
+			t.FailNow()
+		}
+		if max > 0 && got != max {
+			t.Log("expected", max, "concurrent reduces at most, got", got)
+			t.FailNow()
+		}
+	}
+}
+
+func TestReduceYield(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// on a single processor, a competing goroutine only runs once the scan yields
+	var ran int32
+	go atomic.StoreInt32(&ran, 1)
+
+	y := newReduceThrottle(&LogConfig{ReduceYield: 16}).yielder()
+	for i := 0; i < 15; i++ {
+		y.tick()
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Log("yielded before visiting ReduceYield entries")
+		t.FailNow()
+	}
+	for i := 0; i < 64 && atomic.LoadInt32(&ran) == 0; i++ {
+		y.tick()
+	}
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Log("expected a yield after visiting ReduceYield entries")
+		t.FailNow()
+	}
+
+	// view scans tick the yielder of their throttle on every visited key
+	tbl := make(minStateTable, 64)
+	for i := 0; i < 64; i++ {
+		k := strconv.Itoa(i)
+		tbl[k] = State{ind: uint64(i), cmd: pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: k}}
+	}
+	atomic.StoreInt32(&ran, 0)
+	go atomic.StoreInt32(&ran, 1)
+
+	rt := newReduceThrottle(&LogConfig{MaxReduces: 1, ReduceYield: 16})
+	if cmds := iterConcTableOnView(&tbl, nil, rt); len(cmds) != 64 {
+		t.Log("expected 64 reduced commands, got", len(cmds))
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Log("expected the view scan to yield")
+		t.FailNow()
+	}
+}
+
+func TestConcTableSetMeasure(t *testing.T) {
+	nCmds, period := 1000, uint32(100)
+	buf := bytes.NewBuffer(nil)
+	cfg := &LogConfig{
+		Inmem:       true,
+		Alg:         IterConcTable,
+		Tick:        Interval,
+		Period:      period,
+		Fname:       "./logstate.log",
+		MeasureSink: NewCSVSink(buf),
+	}
+
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	var id uint64
+	logCmds := func() {
+		for j := 0; j < nCmds; j++ {
+			cmd := pb.Command{
+				Id:    id,
+				Op:    pb.Command_SET,
+				Key:   strconv.Itoa(j % 50),
+				Value: strconv.Itoa(j),
+			}
+			if err := ct.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			id++
+		}
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	logCmds()
+	if ct.Measuring() || ct.Latencies() != nil {
+		t.Log("expected no latency measurement before SetMeasure")
+		t.FailNow()
+	}
+
+	if err := ct.SetMeasure(true); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	logCmds()
+	measured := ct.Latencies().Persist.Count()
+	if measured == 0 {
+		t.Log("expected latency tuples after enabling measurement")
+		t.FailNow()
+	}
+
+	if err := ct.SetMeasure(false); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	logCmds()
+	if cnt := ct.Latencies().Persist.Count(); cnt != measured {
+		t.Log("expected", measured, "latency tuples after disabling measurement, got", cnt)
+		t.FailNow()
+	}
+
+	// every command is still logged and reduced
+	log, err := ct.Recov(0, id-1)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) != 50 {
+		t.Log("expected 50 reduced states, got", len(log))
+		t.FailNow()
+	}
+	ct.Shutdown()
+
+	if lines := strings.Count(buf.String(), "\n"); uint64(lines) != measured {
+		t.Log("expected", measured, "tuples recorded on sink, got", lines)
+		t.FailNow()
+	}
+
+	cfg.Period, cfg.Tick, cfg.Duration = 0, TimeInterval, time.Second
+	ct, err = NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+	if err := ct.SetMeasure(true); err == nil {
+		t.Log("expected an error enabling measurement without a config.Period")
+		t.FailNow()
+	}
+}
+
+func TestConcTableCollectSegments(t *testing.T) {
+	nCmds, period := 2000, uint32(100)
+	cfg := &LogConfig{
+		KeepAll:        true,
+		Alg:            IterConcTable,
+		Tick:           Interval,
+		Period:         period,
+		Fname:          "./logstate.log",
+		RetainSegments: 3,
+	}
+
+	for _, retainDur := range []time.Duration{0, time.Hour} {
+		if err := cleanAllLogStates(); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		cfg.RetainDuration = retainDur
+
+		ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		ref := NewListHT()
+
+		for j := 0; j < nCmds; j++ {
+			cmd := pb.Command{
+				Id:    uint64(j),
+				Op:    pb.Command_SET,
+				Key:   strconv.Itoa(j % 50),
+				Value: strconv.Itoa(j),
+			}
+			switch j {
+			case 250:
+				// a key never written again retains its segment
+				cmd.Key = "unique"
+			case 420:
+				// as well as range deletes
+				cmd.Op, cmd.Key, cmd.EndKey = pb.Command_DELETE_RANGE, "a", "b"
+			}
+			if err := ct.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			ref.Log(cmd)
+		}
+		for ct.Pending() > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		removed, err := ct.CollectSegments()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		total := nCmds / int(period)
+		exp := total - int(cfg.RetainSegments) - 2
+		if retainDur > 0 {
+			exp = 0
+		}
+		if removed != exp {
+			t.Log("expected", exp, "removed segments, got", removed)
+			t.FailNow()
+		}
+
+		raw, num, err := ct.RecovEntireLog()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		_, inum, err := ct.RecovEntireLogInterval(0, uint64(nCmds))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if num != total-removed || inum != num {
+			t.Log("expected", total-removed, "segments, got", num, "listed and", inum, "indexed")
+			t.FailNow()
+		}
+
+		// the latest state of every key must survive collection
+		log, err := DecodeEntireLogStream(bytes.NewReader(raw))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		expLog, err := ref.Recov(0, uint64(nCmds-1))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		latest := func(log []pb.Command) map[string]string {
+			ht := make(map[string]string)
+			for _, c := range log {
+				if !isRangeDelete(c.Op) {
+					ht[c.Key] = c.Value
+				}
+			}
+			return ht
+		}
+		if got, exp := latest(log), latest(expLog); !reflect.DeepEqual(got, exp) {
+			t.Log("collected log is not equivalent to the reduced log")
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", got)
+			t.FailNow()
+		}
+		ct.Shutdown()
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestConcTableManifest(t *testing.T) {
+	nCmds, period := 1000, uint32(100)
+	cfg := &LogConfig{
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  period,
+		Fname:   "./logstate.log",
+		Naming:  IntervalNaming,
+	}
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	for j := 0; j < nCmds; j++ {
+		cmd := pb.Command{
+			Id:    uint64(j),
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(j % 50),
+			Value: strconv.Itoa(j),
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// segments are recovered following the manifest order, matching their zero
+	// padded names
+	segs, err := ct.listSegments()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(segs) != nCmds/int(period) {
+		t.Log("expected", nCmds/int(period), "segments on manifest, got", len(segs))
+		t.FailNow()
+	}
+	for i, sg := range segs {
+		exp := segmentFname(cfg.Fname, uint64(i)*uint64(period), uint64(i+1)*uint64(period)-1, IntervalNaming)
+		if sg.Name != exp || !sg.HasChecksum {
+			t.Log("expected segment", exp, "with checksum, got", sg)
+			t.FailNow()
+		}
+	}
+
+	raw, num, err := ct.RecovEntireLog()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	log, err := DecodeEntireLogStream(bytes.NewReader(raw))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if num != len(segs) || log[0].Id >= log[len(log)-1].Id {
+		t.Log("unexpected recovered log with", num, "segments")
+		t.FailNow()
+	}
+
+	// corrupted segments are detected through their recorded checksum
+	data, err := ioutil.ReadFile(segs[3].Name)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	data[len(data)-10] ^= 0xff
+	if err := ioutil.WriteFile(segs[3].Name, data, 0644); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, _, err := ct.RecovEntireLog(); !errors.Is(err, ErrSegmentChecksum) {
+		t.Log("expected a checksum mismatch, got", err)
+		t.FailNow()
+	}
+
+	// legacy records are still interpreted
+	recs := map[string]segmentInterval{
+		"10 19 ./logstate.19.log":                            {Name: "./logstate.19.log", First: 10, Last: 19},
+		`{"name":"./a b.log","first":1,"last":2,"crc32c":0}`: {Name: "./a b.log", First: 1, Last: 2, HasChecksum: true},
+		`{"name":"./c.log","first":3,"last":4}`:              {Name: "./c.log", First: 3, Last: 4},
+		`{"name":"./d.log","first":5,"last":6,"disk":1}`:     {Name: "./d.log", First: 5, Last: 6, Disk: 1},
+	}
+	recs[strings.TrimSuffix(string(encodeManifestRecord(segs[0])), "\n")] = segs[0]
+
+	for rec, exp := range recs {
+		seg, err := parseSegmentInterval(rec)
+		if err != nil || seg != exp {
+			t.Log("expected", exp, "parsing", rec, "got", seg, err)
+			t.FailNow()
+		}
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestConcTableRestoreOnInit(t *testing.T) {
+	nCmds, period := 1000, uint32(100)
+	cfg := &LogConfig{
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  period,
+		Fname:   t.TempDir() + "/logstate.log",
+	}
+
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	latest := make(map[string]string)
+	for j := 0; j < nCmds; j++ {
+		cmd := pb.Command{
+			Id:    uint64(j),
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(j % 150),
+			Value: strconv.Itoa(j),
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		latest[cmd.Key] = cmd.Value
+	}
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ct.Shutdown()
+
+	// every persisted segment is merged into the first view
+	cfg.RestoreOnInit = true
+	ct, err = NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	if ct.Len() != uint64(len(latest)) {
+		t.Log("restored", ct.Len(), "states, expected", len(latest))
+		t.FailNow()
+	}
+	if f, l := ct.logs[0].first, ct.logs[0].last; f != 0 || l != uint64(nCmds-1) {
+		t.Log("restored interval", f, l, "expected", 0, nCmds-1)
+		t.FailNow()
+	}
+
+	// KeepAll configs only persist segments, the restored state is retrieved from
+	// a snapshot of the table
+	snap, err := ct.Snapshot()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	log, err := snap.Recov()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) != len(latest) {
+		t.Log("recovered", len(log), "commands, expected", len(latest))
+		t.FailNow()
+	}
+	for _, c := range log {
+		if latest[c.Key] != c.Value {
+			t.Log("restored key", c.Key, "with value", c.Value, "expected", latest[c.Key])
+			t.FailNow()
+		}
+	}
+}
+
+func TestConcTableSnapshotConcurrent(t *testing.T) {
+	nCmds, keys := uint64(20000), uint64(10)
+	cfgs := []*LogConfig{
+		{Alg: IterConcTable, Tick: Delayed, Inmem: true},
+
+		// persisted states must be read before any later reduce replaces them
+		{Alg: IterConcTable, Tick: Interval, Period: 100, Fname: t.TempDir() + "/logstate.log"},
+	}
+
+	for _, cfg := range cfgs {
+		ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			for i := uint64(0); i < nCmds; i++ {
+				cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.FormatUint(i%keys, 10), Value: strconv.FormatUint(i, 10)}
+				if err := ct.Log(cmd); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+
+		// snapshots are taken and read while logging continues, each one must retain
+		// the latest state of every key up to its creation
+		for running := true; running; {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+				running = false
+			default:
+			}
+
+			snap, err := ct.Snapshot()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			log, err := snap.Recov()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			last := snap.Last()
+			if last < keys {
+				continue
+			}
+			if uint64(len(log)) != keys {
+				t.Log("snapshot at", last, "recovered", len(log), "states, expected", keys)
+				t.FailNow()
+			}
+			for _, c := range log {
+				k, _ := strconv.ParseUint(c.Key, 10, 64)
+				exp := last - (last+keys-k)%keys
+				if c.Id != exp {
+					t.Log("snapshot at", last, "retained index", c.Id, "for key", c.Key, "expected", exp)
+					t.FailNow()
+				}
+			}
+		}
+		ct.Shutdown()
+	}
+}
+
+func TestConcTableParallelIOLazyReduce(t *testing.T) {
+	primDir, secdDir := t.TempDir(), t.TempDir()
+	cfg := &LogConfig{
+		Alg:         IterConcTable,
+		Tick:        Delayed,
+		Fname:       primDir + "/logstate.log",
+		ParallelIO:  true,
+		SecondFname: secdDir + "/logstate2.log",
+	}
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		last := uint64(i)
+		cmd := pb.Command{Id: last, Op: pb.Command_SET, Key: "k", Value: strconv.Itoa(i)}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// lazy reduces alternate between disks, recovering the freshest state. Views
+		// with no command logged since the last reduce are not reduced again
+		log, err := ct.Recov(0, last)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(log) != 1 || log[0].Id != last {
+			t.Log("expected the latest state of index", last, "got", log)
+			t.FailNow()
+		}
+		if _, err := os.Stat(cfg.SecondFname); i == 0 && err == nil {
+			t.Log("expected the first lazy reduce persisted only on the primary disk")
+			t.FailNow()
+
+		} else if i > 0 && err != nil {
+			t.Log("expected the second lazy reduce persisted on the secondary disk, err:", err.Error())
+			t.FailNow()
+		}
+
+		raw, err := ct.RecovBytes(0, last)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		cmds, err := UnmarshalLogFromReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(cmds) != 1 || cmds[0].Id != last {
+			t.Log("expected serialized state of index", last, "got", cmds)
+			t.FailNow()
+		}
+	}
+}
+
+func TestConcTableStripedDisks(t *testing.T) {
+	nCmds, period, stripes := 800, uint32(100), 4
+	dirs := make([]string, stripes)
+	fns := make([]string, stripes)
+	for i := range fns {
+		dirs[i] = t.TempDir()
+		fns[i] = dirs[i] + "/logstate.log"
+	}
+
+	cfg := &LogConfig{
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  period,
+		Fname:   fns[0],
+		Fnames:  fns,
+	}
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	for j := 0; j < nCmds; j++ {
+		cmd := pb.Command{
+			Id:    uint64(j),
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(j % 50),
+			Value: strconv.Itoa(j),
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// consecutive reduces are striped across every disk
+	for _, dir := range dirs {
+		logs, err := filepath.Glob(dir + "/*.log")
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if exp := nCmds / int(period) / stripes; len(logs) != exp {
+			t.Log("expected", exp, "segments on", dir, "got", len(logs))
+			t.FailNow()
+		}
+	}
+
+	// and recovered in interval order from all of them
+	recv, num, err := ct.RecovEntireLogConc(context.Background(), 2)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if num != nCmds/int(period) {
+		t.Log("expected", nCmds/int(period), "segments, got", num)
+		t.FailNow()
+	}
+	var next uint64
+	for seg := range recv {
+		if seg.Err != nil {
+			t.Log(seg.Err.Error())
+			t.FailNow()
+		}
+		if seg.First != next {
+			t.Log("expected segment starting at", next, "got", seg.First, seg.Name)
+			t.FailNow()
+		}
+		next = seg.Last + 1
+	}
+
+	invalid := []LogConfig{
+		{Alg: IterConcTable, Tick: Delayed, Fname: fns[0], Fnames: fns[:1]},
+		{Alg: IterConcTable, Tick: Delayed, Fname: fns[1], Fnames: fns},
+		{Alg: IterConcTable, Tick: Delayed, Fname: fns[0], Fnames: fns, ParallelIO: true, SecondFname: fns[1]},
+	}
+	for _, cf := range invalid {
+		if err := cf.ValidateConfig(); err == nil {
+			t.Log("expected an error on invalid striped config", cf.Fnames)
+			t.FailNow()
+		}
+	}
+}
+
+func TestConcTableViewWatermarks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, cfg := range []LogConfig{
+		{Inmem: true, Alg: IterConcTable, Tick: Interval, Period: 1000000, ViewMaxKeys: 10},
+		{Inmem: true, Alg: IterConcTable, Tick: Interval, Period: 1000000, ViewMaxBytes: 4096},
+	} {
+		cfg := cfg
+		ct, err := NewConcTableWithConfig(ctx, 2, &cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// every command writes a distinct key, never reaching the count-based period
+		for i := 0; i < 200; i++ {
+			cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i), Value: "value"}
+			if err := ct.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			cur := ct.lockCurrentView()
+			keys, bytes := len(ct.views[cur]), ct.logs[cur].viewBytes
+			ct.mu[cur].Unlock()
+
+			if cfg.ViewMaxKeys > 0 && keys >= cfg.ViewMaxKeys {
+				t.Log("expected views below", cfg.ViewMaxKeys, "keys, got", keys)
+				t.FailNow()
+			}
+			if cfg.ViewMaxBytes > 0 && bytes >= cfg.ViewMaxBytes {
+				t.Log("expected views below", cfg.ViewMaxBytes, "bytes, got", bytes)
+				t.FailNow()
+			}
+		}
+		ct.Shutdown()
+	}
+
+	invalid := LogConfig{Inmem: true, Tick: Delayed, ViewMaxKeys: 10}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on watermarks over unscheduled ticks")
+		t.FailNow()
+	}
+}
+
+func TestConcTableStr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &LogConfig{Inmem: true, Alg: IterConcTable, Tick: Delayed}
+	ct, err := NewConcTableWithConfig(ctx, 2, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for i := 0; i < 50; i++ {
+		cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i), Value: "value"}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	str := ct.StrSample(3)
+	if !strings.HasPrefix(str, "view 0 (current): 50 keys on [0, 49] [") || !strings.Contains(str, "]...; view 1: 0 keys") {
+		t.Log("unexpected table representation:", str)
+		t.FailNow()
+	}
+	sample := strings.TrimPrefix(str[:strings.Index(str, "]...")], "view 0 (current): 50 keys on [0, 49] [")
+	if keys := strings.Fields(sample); len(keys) != 3 {
+		t.Log("expected 3 sampled keys, got", keys)
+		t.FailNow()
+	}
+	if str := ct.StrSample(0); str != "view 0 (current): 50 keys on [0, 49]; view 1: 0 keys" {
+		t.Log("unexpected table representation without keys:", str)
+		t.FailNow()
+	}
+}
+
+func TestConcTableStripedManifest(t *testing.T) {
+	nCmds, period := uint64(800), uint32(100)
+	primDir, secdDir := t.TempDir(), t.TempDir()
+	cf := LogConfig{
+		KeepAll:     true,
+		Alg:         IterConcTable,
+		Tick:        Interval,
+		Period:      period,
+		Fname:       primDir + "/logstate.log",
+		ParallelIO:  true,
+		SecondFname: secdDir + "/logstate2.log",
+	}
+
+	st, err := generateRandStructure(4, nCmds, 50, 200, &cf)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	ct := st.(*ConcTable)
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ct.Shutdown()
+
+	// every segment is recorded with the disk it was persisted on
+	segs, err := ct.listSegments()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(segs) != int(nCmds)/int(period) {
+		t.Log("expected", int(nCmds)/int(period), "segments on manifest, got", len(segs))
+		t.FailNow()
+	}
+	dirs := []string{primDir, secdDir}
+	var onDisk [2]int
+	for i, sg := range segs {
+		if filepath.Dir(sg.Name) != dirs[sg.Disk] || (i > 0 && sg.First <= segs[i-1].First) {
+			t.Log("unexpected segment", sg, "on manifest")
+			t.FailNow()
+		}
+		onDisk[sg.Disk]++
+	}
+	if onDisk[0] == 0 || onDisk[1] == 0 {
+		t.Log("expected segments on both disks, got", onDisk)
+		t.FailNow()
+	}
+
+	// without a manifest, segments of both disks are merged by their intervals
+	if err := os.Remove(indexFname(cf.Fname)); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	restored, err := NewConcTableWithConfig(context.TODO(), defaultConcLvl, &cf)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer restored.Shutdown()
+
+	listed, err := restored.listSegments()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(listed) != len(segs) {
+		t.Log("expected", len(segs), "listed segments, got", len(listed))
+		t.FailNow()
+	}
+	for i := range segs {
+		if listed[i].Name != segs[i].Name || listed[i].Disk != segs[i].Disk {
+			t.Log("expected segment", segs[i], "at position", i, "got", listed[i])
+			t.FailNow()
+		}
+	}
+
+	_, num, err := restored.RecovEntireLog()
+	if err != nil || num != len(segs) {
+		t.Log("expected", len(segs), "recovered segments, got", num, err)
+		t.FailNow()
+	}
 }
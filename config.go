@@ -1,6 +1,10 @@
 package beelog
 
-import "errors"
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
 
 // ReduceInterval ...
 type ReduceInterval int8
@@ -23,6 +27,111 @@ const (
 	// no prior state is found (i.e. didnt reach 'Period' commands yet), a new
 	// one is immediately executed.
 	Interval
+
+	// Adaptive log reduce acts similar to Interval, but dynamically adjusts
+	// the effective reduce period between 'MinPeriod' and 'MaxPeriod' commands,
+	// considering the incoming command rate and table occupancy observed since
+	// the last reduce. Bursty workloads reduce less frequently under load, while
+	// idle replicas still persist recent writes promptly.
+	Adaptive
+
+	// TimeInterval log reduce is triggered every 'Duration' by a ticker routine,
+	// if any command was logged since the last reduce. Ensures that a mostly-idle
+	// replica still persists recent writes within a bounded time window.
+	TimeInterval
+)
+
+// isPeriodic informs if the reduce interval is triggered after a number of commands
+// (i.e. Interval and Adaptive configs).
+func (ri ReduceInterval) isPeriodic() bool {
+	return ri == Interval || ri == Adaptive
+}
+
+// isScheduled informs if the reduce interval is triggered by a schedule, either
+// a number of commands or a time duration, instead of every write or recovery.
+func (ri ReduceInterval) isScheduled() bool {
+	return ri.isPeriodic() || ri == TimeInterval
+}
+
+// BackpressurePolicy defines how ConcTable structures react when their logger
+// routine falls behind, and the queue of pending reduce requests is full.
+type BackpressurePolicy int8
+
+const (
+	// BlockWhenBusy blocks the 'Log()' caller until the logger routine dequeues
+	// a pending reduce request.
+	BlockWhenBusy BackpressurePolicy = iota
+
+	// SyncWhenBusy executes the reduce procedure synchronously on the 'Log()'
+	// caller, persisting the view state without waiting for the logger routine.
+	SyncWhenBusy
+
+	// RejectWhenBusy refuses commands that would trigger a new reduce request,
+	// returning ErrBusy without logging them.
+	RejectWhenBusy
+)
+
+// BufferGrowth defines how CircBuffHT structures react when their buffer capacity
+// is reached.
+type BufferGrowth int8
+
+const (
+	// ReduceWhenFull forces a reduce once the buffer capacity is reached, regardless
+	// of the configured Tick, and restarts the buffer afterwards.
+	ReduceWhenFull BufferGrowth = iota
+
+	// GrowWhenFull doubles the buffer capacity, up to 'MaxCap' entries. Once
+	// 'MaxCap' is reached, falls back to ReduceWhenFull.
+	GrowWhenFull
+
+	// SpillWhenFull moves buffer entries into a secondary buffer, which retains
+	// only the latest entry of each key, and restarts the buffer. Capacity is
+	// never surpassed, preserving the configured Tick semantics.
+	SpillWhenFull
+)
+
+// SegmentNaming defines how segments persisted on KeepAll configs are named, derived
+// from config.Fname.
+type SegmentNaming int8
+
+const (
+	// LastIndexNaming replaces the Fname extension by the last index of the segment
+	// interval (e.g. "logstate.1999.log").
+	LastIndexNaming SegmentNaming = iota
+
+	// IntervalNaming replaces the Fname extension by both indexes of the segment
+	// interval, zero padded to be lexicographically ordered (e.g.
+	// "logstate.00000000000000001900-00000000000000001999.log").
+	IntervalNaming
+)
+
+// ParallelIOMode defines how reduced states are distributed between the primary and
+// secondary disks on ParallelIO configs, or between every path on striped configs
+// (i.e. config.Fnames).
+type ParallelIOMode int8
+
+const (
+	// RoundRobin stripes consecutive reduces across disks, concurrently persisting
+	// views for throughput. Recoveries read the freshest state among every disk.
+	RoundRobin ParallelIOMode = iota
+
+	// Mirror persists every reduce on all disks for redundancy. Recoveries read from
+	// the primary disk, falling back to the others if its state is unavailable.
+	Mirror
+)
+
+// ReadLogging defines how read commands (i.e. GETs) are recorded by structures.
+type ReadLogging int8
+
+const (
+	// IndexReads records the index of reads on the structure interval, attributing
+	// them as its first and last indexes, and accounting them on reduce periods.
+	IndexReads ReadLogging = iota
+
+	// IgnoreReads discards reads entirely, never altering the structure interval,
+	// reduce periods, nor statistics, except for the logged interval (see
+	// LoggedInterval and Holes).
+	IgnoreReads
 )
 
 // LogConfig ...
@@ -36,8 +145,212 @@ type LogConfig struct {
 	Period  uint32
 	Fname   string
 
-	ParallelIO  bool
-	SecondFname string
+	ParallelIO   bool
+	SecondFname  string
+	ParallelMode ParallelIOMode
+
+	// every target path of reduced states on N-way striped configs, generalizing
+	// ParallelIO beyond two disks. Must start with Fname, and can't be combined with
+	// ParallelIO. Reduces are distributed following ParallelMode
+	Fnames []string
+
+	// bounds of the effective reduce period on Adaptive config
+	MinPeriod uint32
+	MaxPeriod uint32
+
+	// destination of latency tuples captured on Measure config. If none is provided,
+	// tuples are written as CSV into a latency output file on the log location.
+	// The sink is closed on structure Shutdown
+	MeasureSink MeasureSink
+
+	// chance of each interval being measured on Measure config, in (0, 1]. Zero
+	// measures every interval
+	MeasureRate float64
+
+	// if positive, only a uniform sample of 'MeasureReservoir' raw tuples is kept
+	// and recorded into the sink on Shutdown, instead of streaming every tuple.
+	// Aggregated histograms always account for every tuple
+	MeasureReservoir int
+
+	// if positive, deterministically measures every 'MeasureEvery'-th interval on
+	// Measure config, instead of drawing intervals with MeasureRate chance
+	MeasureEvery int
+
+	// seed of the pseudo-random generators utilized on MeasureRate draws, reservoir
+	// sampling and SkipListHT level promotions, reproducing those choices across
+	// runs. Zero seeds them from the current time
+	Seed int64
+
+	// name of a reduce algorithm registered through RegisterReducer, replacing Alg
+	// if set
+	AlgName string
+
+	// retains the latest 'VersionsToKeep' updates of each key within the reduced
+	// interval, instead of only its latest state, allowing bounded time-travel reads
+	// after recovery. Only supported on structures indexing every update of a key
+	// (i.e. ListHT, ArrayHT, AVLTreeHT, CircBuffHT and SkipListHT). Zero or one
+	// retains only the latest state
+	VersionsToKeep int
+
+	// number of latest indexes of each reduced interval whose commands are retained
+	// verbatim, reducing only older ones, so recovering replicas get the exact recent
+	// history (e.g. for conflict resolution) while the log size remains bounded. Has
+	// the same structure restrictions of VersionsToKeep. Zero compacts every command
+	CompactWindow uint64
+
+	// reduce period on TimeInterval config
+	Duration time.Duration
+
+	// maximum latency to batch the fsync of persisted logs on Sync config,
+	// issuing a single Fsync per file for the entire batch. Reduces wait for
+	// their batch to be synced. Zero disables group commit, syncing every write.
+	GroupCommit time.Duration
+
+	// discard key states whose ExpiresAt timestamp elapsed during reduce
+	DropExpired bool
+
+	// keys never compacted away: reduced logs retain every update of a pinned key
+	// within the reduced interval, instead of only its latest state, regardless of
+	// DropExpired. Updates of pinned keys are retained in memory during the entire
+	// structure lifetime, so only a small set of rarely updated keys (e.g.
+	// configuration or membership) should be pinned
+	Pinned KeyFilter
+
+	// persistence backend of log segments, the local filesystem is used if
+	// none is provided
+	Storage LogStorage
+
+	// read persisted states through read-only memory mappings during recovery,
+	// instead of buffering entire files. Only supported on the filesystem storage
+	Mmap bool
+
+	// encoding of commands on persisted segments and RecovBytes calls. FlatCodec
+	// allows recovered logs to be traversed in place by IterFlatLog. Not supported
+	// on LSMLog and BTreeHT structures
+	Codec Codec
+
+	// encrypts each persisted segment with AES-GCM, using the current key of the
+	// provider. Recovery procedures transparently decrypt segments
+	Encryption KeyProvider
+
+	// retries of reduce procedures failed on background routines, whose final
+	// errors are reported through 'Err()' calls
+	Retry RetryPolicy
+
+	// user callbacks invoked on log lifecycle events
+	Hooks *Hooks
+
+	// reaction of ConcTable structures when reduce requests are issued faster
+	// than persisted
+	Backpressure BackpressurePolicy
+
+	// number of independent ConcTables on a ShardedConcTable, each logging
+	// a partition of the key space
+	Shards int
+
+	// number of logger routines concurrently reducing and persisting full views
+	// on ConcTable structures. Zero or one retains a single logger routine
+	ReduceWorkers int
+
+	// maximum number of reduces concurrently computed on background routines of
+	// ConcTable and CircBuffHT structures, also bounding the workers of a parallel
+	// view scan. On ShardedConcTables, each shard is bounded independently. Zero
+	// doesnt bound concurrent reduces
+	MaxReduces int
+
+	// number of entries scanned by a background reduce before yielding the processor
+	// (i.e. runtime.Gosched), protecting the latency of Log calls during huge view
+	// scans on small machines. Zero never yields
+	ReduceYield int
+
+	// occupancy watermarks of ConcTable views, triggering the reduce of a view once
+	// its state table reaches 'ViewMaxKeys' unique keys or 'ViewMaxBytes' estimated
+	// bytes (see Stats.MemBytes), besides any count-based period. Only valid on
+	// scheduled ticks (i.e. Interval, Adaptive or TimeInterval). Zero disables
+	ViewMaxKeys  int
+	ViewMaxBytes int64
+
+	// reaction of CircBuffHT structures when their buffer capacity is reached,
+	// and the maximum capacity a buffer can grow to on GrowWhenFull
+	Growth BufferGrowth
+	MaxCap int
+
+	// periodic checkpoints of the un-reduced contents of CircBuffHT structures (i.e.
+	// their buffer and the latest state of each key), written into 'CheckpointFname'
+	// every 'CheckpointEvery' logged commands and restored during construction,
+	// bounding the loss of Delayed configs to the commands logged since the last
+	// checkpoint. Zero disables periodic checkpoints, still allowing explicit ones
+	CheckpointFname string
+	CheckpointEvery uint32
+
+	// loads the latest persisted state (i.e. at Fname, or every segment on KeepAll
+	// configs) into the structure during construction, resuming from a prior
+	// execution. Not supported on CircBuffHT and LSMLog structures
+	RestoreOnInit bool
+
+	// naming scheme of segments persisted on KeepAll configs
+	Naming SegmentNaming
+
+	// interval between background collections of obsolete segments on persistent
+	// KeepAll ConcTables, removing segments entirely shadowed by newer ones. Zero
+	// disables collection
+	GCInterval time.Duration
+
+	// segments retained by collection regardless of being shadowed: the latest
+	// 'RetainSegments' ones, and those written within 'RetainDuration'
+	RetainSegments int
+	RetainDuration time.Duration
+
+	// maximum bytes occupied by segments persisted on KeepAll configs. Once exceeded,
+	// the oldest segments entirely shadowed by newer ones are evicted after each
+	// persist, never those holding the latest state of any key, informing each one to
+	// 'Hooks.OnEvict'. Zero disables the quota
+	MaxDiskBytes int64
+
+	// cold persistence backend of segments on KeepAll configs. Only the latest
+	// 'HotSegments' segments are retained on the hot storage (i.e. config.Storage, or
+	// the local filesystem), while older ones are asynchronously migrated into
+	// 'ColdStorage' after each persist. Recovery procedures transparently read migrated
+	// segments from the cold backend. Segments must have distinct names on both
+	// backends, so the cold one should not alias the hot storage (e.g. an object
+	// store prefix, instead of the same local filesystem)
+	ColdStorage LogStorage
+	HotSegments int
+
+	// maximum bytes per second written while persisting reduced states, paced by a
+	// token bucket shared by every view of a ConcTable, so background persistence
+	// doesnt saturate the disk utilized by the state machine. Zero disables the limit
+	WriteRate int64
+
+	// drops every entry covered by a reduced interval once it's persisted, retaining
+	// memory proportional to the un-reduced suffix of long-running structures. Later
+	// reduces only cover the un-reduced suffix, so it can only be set on persistent
+	// KeepAll configs, where recoveries merge every segment. Get calls disregard keys
+	// only updated within truncated intervals. Only supported on ListHT, ArrayHT and
+	// AVLTreeHT structures
+	TruncateAfterReduce bool
+
+	// validation of intervals requested by recovery procedures against the interval
+	// of indexes logged by the structure. Any interval is accepted by default
+	RecovBounds BoundsPolicy
+
+	// how read commands are recorded. Reads are indexed by default
+	Reads ReadLogging
+
+	// handling of commands logged with non-monotonic Ids, and the number of commands
+	// retained on ReorderBuffer configs. Ids are unchecked by default
+	Ordering      OrderPolicy
+	ReorderWindow int
+
+	// inserts nodes on AVLTreeHT structures through an iterative procedure with
+	// an explicit parent stack, instead of recursing on each tree level
+	IterativeInsert bool
+
+	// records every logged command on an append-only journal (i.e. Fname with a
+	// ".wal" extension) until persisted by a reduce, replaying journaled commands
+	// during construction. Appends are synced on Sync config. Not supported on
+	// BTreeHT and LSMLog structures
+	Journal bool
 }
 
 // DefaultLogConfig ...
@@ -56,8 +369,147 @@ func (lc *LogConfig) ValidateConfig() error {
 	if lc.Tick == Interval && lc.Period == 0 {
 		return errors.New("invalid config: if periodic reduce is set (i.e. Tick == Interval), a config.Period must be provided")
 	}
+	if lc.Tick == Adaptive && (lc.MinPeriod == 0 || lc.MaxPeriod < lc.MinPeriod) {
+		return errors.New("invalid config: if adaptive reduce is set (i.e. Tick == Adaptive), a config.MinPeriod and a config.MaxPeriod >= MinPeriod must be provided")
+	}
+	if lc.MeasureRate < 0 || lc.MeasureRate > 1 {
+		return errors.New("invalid config: config.MeasureRate must be in the [0, 1] range")
+	}
+	if lc.MeasureReservoir < 0 {
+		return errors.New("invalid config: config.MeasureReservoir cannot be negative")
+	}
+	if lc.MeasureEvery < 0 || (lc.MeasureEvery > 0 && lc.MeasureRate != 0) {
+		return errors.New("invalid config: config.MeasureEvery must be non-negative, and cant be combined with config.MeasureRate")
+	}
+	if lc.Measure && lc.Period == 0 {
+		return errors.New("invalid config: if latency measurement is set (i.e. Measure == true), a config.Period must be provided as the measured interval")
+	}
+	if lc.Tick == TimeInterval && lc.Duration <= 0 {
+		return errors.New("invalid config: if time interval reduce is set (i.e. Tick == TimeInterval), a positive config.Duration must be provided")
+	}
+	if lc.GroupCommit < 0 || (lc.GroupCommit > 0 && !lc.Sync) {
+		return errors.New("invalid config: config.GroupCommit must be non-negative, and can only be set along with config.Sync")
+	}
+	if lc.Codec > FlatCodec {
+		return errors.New("invalid config: unknown config.Codec")
+	}
+	if lc.Mmap && (lc.Inmem || !isFileStorage(lc.Storage)) {
+		return errors.New("invalid config: config.Mmap can only be set on persistent storage (i.e. Inmem == false) over the filesystem")
+	}
+	if lc.Encryption != nil && (lc.Inmem || lc.Mmap) {
+		return errors.New("invalid config: config.Encryption can only be set on persistent storage (i.e. Inmem == false), and cant be combined with config.Mmap")
+	}
+	if lc.Retry.MaxAttempts < 0 || lc.Retry.Backoff < 0 || lc.Retry.MaxBackoff < 0 {
+		return errors.New("invalid config: config.Retry must have non-negative values")
+	}
+	if lc.Backpressure < BlockWhenBusy || lc.Backpressure > RejectWhenBusy {
+		return errors.New("invalid config: unknown config.Backpressure policy")
+	}
+	if lc.Shards < 0 {
+		return errors.New("invalid config: config.Shards must be a non-negative value")
+	}
+	if lc.ReduceWorkers < 0 {
+		return errors.New("invalid config: config.ReduceWorkers must be a non-negative value")
+	}
+	if lc.MaxReduces < 0 || lc.ReduceYield < 0 {
+		return errors.New("invalid config: config.MaxReduces and config.ReduceYield must be non-negative")
+	}
+	if lc.ViewMaxKeys < 0 || lc.ViewMaxBytes < 0 {
+		return errors.New("invalid config: config.ViewMaxKeys and config.ViewMaxBytes must be non-negative")
+	}
+	if (lc.ViewMaxKeys > 0 || lc.ViewMaxBytes > 0) && !lc.Tick.isScheduled() {
+		return errors.New("invalid config: view watermarks can only be set on scheduled reduce (i.e. Tick == Interval, Adaptive or TimeInterval)")
+	}
+	if lc.Growth < ReduceWhenFull || lc.Growth > SpillWhenFull {
+		return errors.New("invalid config: unknown config.Growth policy")
+	}
+	if lc.MaxCap < 0 || (lc.Growth == GrowWhenFull && lc.MaxCap == 0) {
+		return errors.New("invalid config: config.MaxCap must be non-negative, and provided if buffer growth is set (i.e. Growth == GrowWhenFull)")
+	}
+	if lc.CheckpointEvery > 0 && lc.CheckpointFname == "" {
+		return errors.New("invalid config: if periodic checkpoints are set (i.e. CheckpointEvery > 0), a config.CheckpointFname must be provided")
+	}
+	if lc.CheckpointFname != "" && lc.Encryption != nil {
+		return errors.New("invalid config: config.CheckpointFname cant be combined with config.Encryption")
+	}
+	if lc.TruncateAfterReduce && (lc.Inmem || !lc.KeepAll) {
+		return errors.New("invalid config: config.TruncateAfterReduce can only be set on persistent storage (i.e. Inmem == false) along with config.KeepAll")
+	}
+	if lc.RestoreOnInit && lc.Inmem {
+		return errors.New("invalid config: config.RestoreOnInit can only be set on persistent storage (i.e. Inmem == false)")
+	}
+	if lc.RecovBounds < BestEffortBounds || lc.RecovBounds > ClampBounds {
+		return errors.New("invalid config: unknown config.RecovBounds policy")
+	}
+	if lc.Reads < IndexReads || lc.Reads > IgnoreReads {
+		return errors.New("invalid config: unknown config.Reads mode")
+	}
+	if lc.Ordering < UncheckedOrder || lc.Ordering > ReorderBuffer {
+		return errors.New("invalid config: unknown config.Ordering policy")
+	}
+	if (lc.Ordering == ReorderBuffer) != (lc.ReorderWindow > 0) {
+		return errors.New("invalid config: config.ReorderWindow must be positive, and can only be set along with ReorderBuffer ordering")
+	}
+	if lc.Naming < LastIndexNaming || lc.Naming > IntervalNaming {
+		return errors.New("invalid config: unknown config.Naming scheme")
+	}
+	if lc.GCInterval < 0 || (lc.GCInterval > 0 && (lc.Inmem || !lc.KeepAll)) {
+		return errors.New("invalid config: config.GCInterval must be non-negative, and can only be set on persistent storage (i.e. Inmem == false) along with config.KeepAll")
+	}
+	if lc.RetainSegments < 0 || lc.RetainDuration < 0 {
+		return errors.New("invalid config: config.RetainSegments and config.RetainDuration must be non-negative")
+	}
+	if lc.Journal && lc.Inmem {
+		return errors.New("invalid config: config.Journal can only be set on persistent storage (i.e. Inmem == false)")
+	}
+	if lc.MaxDiskBytes < 0 || (lc.MaxDiskBytes > 0 && (lc.Inmem || !lc.KeepAll)) {
+		return errors.New("invalid config: config.MaxDiskBytes must be non-negative, and can only be set on persistent storage (i.e. Inmem == false) along with config.KeepAll")
+	}
+	if lc.ColdStorage != nil && (lc.Inmem || !lc.KeepAll || lc.Mmap || lc.HotSegments <= 0) {
+		return errors.New("invalid config: config.ColdStorage can only be set on persistent storage (i.e. Inmem == false) along with config.KeepAll and a positive config.HotSegments, and cant be combined with config.Mmap")
+	}
+	if lc.HotSegments < 0 || (lc.HotSegments > 0 && lc.ColdStorage == nil) {
+		return errors.New("invalid config: config.HotSegments must be non-negative, and can only be set along with config.ColdStorage")
+	}
+	if lc.WriteRate < 0 || (lc.WriteRate > 0 && lc.Inmem) {
+		return errors.New("invalid config: config.WriteRate must be non-negative, and can only be set on persistent storage (i.e. Inmem == false)")
+	}
+	if lc.AlgName != "" && !isRegisteredReducer(lc.AlgName) {
+		return errors.New("invalid config: config.AlgName must name a reducer registered through RegisterReducer")
+	}
+	if lc.VersionsToKeep < 0 || (lc.retainsHistory() && lc.AlgName != "") {
+		return errors.New("invalid config: config.VersionsToKeep must be non-negative, and neither it nor config.CompactWindow can be combined with config.AlgName")
+	}
 	if lc.ParallelIO && lc.SecondFname == "" {
 		return errors.New("invalid config: if parallel io is set (i.e. ParallelIO == true), config.secondFname must be provided")
 	}
+	if lc.ParallelMode < RoundRobin || lc.ParallelMode > Mirror {
+		return errors.New("invalid config: unknown config.ParallelMode")
+	}
+	if len(lc.Fnames) > 0 && (lc.Inmem || lc.ParallelIO || len(lc.Fnames) < 2 || lc.Fnames[0] != lc.Fname) {
+		return errors.New("invalid config: config.Fnames must list at least two paths starting at config.Fname, and can only be set on persistent storage (i.e. Inmem == false) without config.ParallelIO")
+	}
 	return nil
 }
+
+// diskFnames returns every path reduced states are persisted on, where the first one
+// is always the primary config.Fname.
+func (lc *LogConfig) diskFnames() []string {
+	if len(lc.Fnames) > 0 {
+		return lc.Fnames
+	}
+	if lc.ParallelIO {
+		return []string{lc.Fname, lc.SecondFname}
+	}
+	return []string{lc.Fname}
+}
+
+// newRand returns a pseudo-random generator seeded by 'Seed', or by the current time
+// if unset.
+func (lc *LogConfig) newRand() *rand.Rand {
+	seed := lc.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
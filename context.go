@@ -0,0 +1,47 @@
+package beelog
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ctxReader interrupts reads from 'r' once 'ctx' is done, returning ctx.Err().
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// lockCtx acquires 'mu', or returns ctx.Err() if 'ctx' is done first. On cancellation,
+// the mutex is released by a background routine as soon as it's acquired.
+func lockCtx(ctx context.Context, mu *sync.Mutex) error {
+	if ctx.Done() == nil {
+		mu.Lock()
+		return nil
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		mu.Lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return nil
+
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
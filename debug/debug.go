@@ -0,0 +1,105 @@
+// Package debug exposes the internals of registered beelog structures for quick
+// troubleshooting, without requiring a metrics stack. Importing the package, in
+// the likes of net/http/pprof, registers an HTTP handler at "/debug/beelog" on
+// http.DefaultServeMux and publishes every report on the "beelog" expvar.
+//
+//	tbl, _ := beelog.NewConcTableWithConfig(ctx, lvl, cfg)
+//	debug.Register("kv-log", tbl)
+//	go http.ListenAndServe("localhost:6060", nil)
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog"
+)
+
+// Inspectable is implemented by every beelog structure.
+type Inspectable interface {
+	Debug() beelog.DebugInfo
+}
+
+var (
+	mu      sync.RWMutex
+	structs = make(map[string]Inspectable)
+)
+
+func init() {
+	expvar.Publish("beelog", expvar.Func(func() interface{} { return Reports() }))
+	http.Handle("/debug/beelog", Handler())
+}
+
+// Register exposes structure 's' under 'name', replacing any structure previously
+// registered with the same name.
+func Register(name string, s Inspectable) {
+	mu.Lock()
+	defer mu.Unlock()
+	structs[name] = s
+}
+
+// Unregister removes the structure registered under 'name', if any.
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(structs, name)
+}
+
+// Reports returns the current report of every registered structure, indexed by
+// their names.
+func Reports() map[string]beelog.DebugInfo {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	reps := make(map[string]beelog.DebugInfo, len(structs))
+	for name, s := range structs {
+		reps[name] = s.Debug()
+	}
+	return reps
+}
+
+// Handler returns an http.Handler serving the reports of every registered structure
+// as JSON. The "name" query param restricts the response to a single structure,
+// replying 404 if not registered.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		if name := r.URL.Query().Get("name"); name != "" {
+			mu.RLock()
+			s, ok := structs[name]
+			mu.RUnlock()
+
+			if !ok {
+				http.Error(w, "structure '"+name+"' not registered", http.StatusNotFound)
+				return
+			}
+			resp = s.Debug()
+
+		} else {
+			resp = Reports()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Names returns the sorted names of every registered structure.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(structs))
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+var (
+	_ Inspectable = &beelog.ListHT{}
+	_ Inspectable = &beelog.ArrayHT{}
+	_ Inspectable = &beelog.AVLTreeHT{}
+	_ Inspectable = &beelog.CircBuffHT{}
+	_ Inspectable = &beelog.ConcTable{}
+	_ Inspectable = &beelog.ShardedConcTable{}
+	_ Inspectable = &beelog.SkipListHT{}
+	_ Inspectable = &beelog.RadixHT{}
+	_ Inspectable = &beelog.LSMLog{}
+	_ Inspectable = &beelog.BTreeHT{}
+)
+
+func TestHandler(t *testing.T) {
+	cfg := &beelog.LogConfig{
+		Inmem:  true,
+		Alg:    beelog.IterConcTable,
+		Tick:   beelog.Interval,
+		Period: 1000,
+	}
+	ct, err := beelog.NewConcTableWithConfig(context.Background(), 2, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	for i := 10; i < 30; i++ {
+		cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 5), Value: "v"}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	Register("table", ct)
+	defer Unregister("table")
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?name=table")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	var info beelog.DebugInfo
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if info.Structure != "conctable" || info.Len != 5 || info.First != 10 || info.Last != 29 {
+		t.Log("unexpected report:", info)
+		t.FailNow()
+	}
+	if info.Config["Period"] != float64(1000) || info.Config["Inmem"] != true {
+		t.Log("unexpected config report:", info.Config)
+		t.FailNow()
+	}
+
+	resp, err = http.Get(srv.URL + "?name=unknown")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Log("expected 404 for an unregistered structure, got", resp.StatusCode)
+		t.FailNow()
+	}
+
+	if reps := Reports(); len(reps) != 1 || reps["table"].Len != 5 {
+		t.Log("unexpected reports:", reps)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,84 @@
+package beelog
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// logStats holds runtime statistics of a structure, updated concurrently by its
+// reduce procedures.
+type logStats struct {
+	lastPersist int64  // atomic, nanoseconds spent on the latest persist
+	lastReduce  int64  // atomic, nanoseconds spent on the latest reduce
+	logged      uint64 // atomic, commands logged since creation
+	ingested    uint64 // atomic, wire bytes of logged commands
+	persisted   uint64 // atomic, bytes written into persisted segments
+	reduceIn    uint64 // atomic, indexes covered by the latest reduce
+	reduceOut   uint64 // atomic, commands emitted by the latest reduce
+	lowest      uint64 // atomic, lowest logged index incremented, zero if none
+	highest     uint64 // atomic, highest logged index incremented, zero if none
+	gaps        holeSet
+}
+
+// DebugInfo is a point-in-time report of structure internals, used for troubleshooting.
+type DebugInfo struct {
+	Structure string `json:"structure"`
+	Len       uint64 `json:"len"`
+
+	// First and Last are the indexes of the un-reduced interval. On ConcTables, the
+	// interval spans every view not awaiting a reduce
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+
+	// current active view on ConcTables, -1 on other structures
+	CurrentView int `json:"currentView"`
+
+	// number of reduce requests awaiting or being processed by background routines
+	PendingReduces int `json:"pendingReduces"`
+
+	// elapsed time persisting the latest reduced state
+	LastPersist time.Duration `json:"lastPersist"`
+
+	// every scalar param of the structure config
+	Config map[string]interface{} `json:"config"`
+
+	// reports of each shard of a ShardedConcTable
+	Shards []DebugInfo `json:"shards,omitempty"`
+}
+
+// debugInfo returns a report of structure 'name', with 'length' elements. Must be
+// called from mutual exclusion scope.
+func (ld *logData) debugInfo(name string, length uint64) DebugInfo {
+	return DebugInfo{
+		Structure:   name,
+		Len:         length,
+		First:       ld.first,
+		Last:        ld.last,
+		CurrentView: -1,
+		LastPersist: time.Duration(atomic.LoadInt64(&ld.stats.lastPersist)),
+		Config:      configSummary(ld.config),
+	}
+}
+
+// configSummary returns every scalar field of 'cfg', discarding callbacks and
+// pluggable backends which are not meaningful (nor serializable) on a report.
+func configSummary(cfg *LogConfig) map[string]interface{} {
+	sum := make(map[string]interface{})
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+
+			if d, ok := f.Interface().(time.Duration); ok {
+				sum[v.Type().Field(i).Name] = d.String()
+				continue
+			}
+			sum[v.Type().Field(i).Name] = f.Interface()
+		}
+	}
+	return sum
+}
@@ -0,0 +1,202 @@
+package beelog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// defaultDecodeSlab is the size of each arena slab retaining raw commands decoded by
+// LogDecoder.DecodeInto.
+const defaultDecodeSlab = 64 * 1024
+
+// errEndOfLog signals the end of a traditional log stream, without any EOL mark.
+var errEndOfLog = errors.New("end of log")
+
+// LogDecoder iterates over the commands of a serialized log, on both beelog and
+// traditional formats, without allocating per decoded entry. Raw commands are read
+// into a reusable scratch buffer, and decoded into a single reused Command, suited
+// for replicas replaying tens of millions of entries. A LogDecoder is not safe for
+// concurrent use.
+type LogDecoder struct {
+	rd  io.Reader
+	hdr LogHeader
+	err error
+
+	// number of commands already read, compared against hdr.Len on beelog format
+	read    int
+	lenBuf  [4]byte
+	scratch []byte
+	cmd     pb.Command
+
+	// arena slab referenced by commands returned on DecodeInto, never overwritten
+	slab []byte
+}
+
+// NewLogDecoder reads the log header from 'rd', returning a decoder positioned at the
+// first command.
+func NewLogDecoder(rd io.Reader) (*LogDecoder, error) {
+	rd, hdr, err := ReadLogHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	return &LogDecoder{rd: rd, hdr: hdr}, nil
+}
+
+// Header returns the header of the decoded log.
+func (d *LogDecoder) Header() LogHeader {
+	return d.hdr
+}
+
+// Next decodes the next command of the log, returning false after the last one or
+// on any failure, differentiated by Err. The EOL mark of beelog format is validated
+// after its last command.
+func (d *LogDecoder) Next() bool {
+	raw, err := d.readRaw(d.scratchBuffer)
+	if err != nil {
+		d.fail(err)
+		return false
+	}
+	if err := decodeCommandUnsafe(d.hdr.Version, raw, &d.cmd); err != nil {
+		d.fail(err)
+		return false
+	}
+	return true
+}
+
+// Command returns the command decoded by the last Next call. The command, including
+// its string fields that alias the scratch buffer, is only valid until the next Next
+// call, and must be cloned (e.g. via proto.Clone) if retained.
+func (d *LogDecoder) Command() *pb.Command {
+	return &d.cmd
+}
+
+// Err returns the first failure observed by the decoder, or nil if the log was
+// entirely and safely decoded.
+func (d *LogDecoder) Err() error {
+	if d.err == errEndOfLog {
+		return nil
+	}
+	return d.err
+}
+
+// DecodeInto decodes every remaining command of the log, appending them into 'dst'
+// and returning the extended slice. Unlike Next, returned commands are safe to
+// retain: raw commands are copied into large arena slabs that are never reused,
+// where string fields alias the slab instead of allocating per field. Callers may
+// pass a truncated 'dst' (e.g. 'dst[:0]') to reuse its backing array between logs.
+func (d *LogDecoder) DecodeInto(dst []pb.Command) ([]pb.Command, error) {
+	if rem := preallocLen(d.hdr.Len - d.read); cap(dst)-len(dst) < rem {
+		grown := make([]pb.Command, len(dst), len(dst)+rem)
+		copy(grown, dst)
+		dst = grown
+	}
+
+	for {
+		raw, err := d.readRaw(d.slabBuffer)
+		if err != nil {
+			d.fail(err)
+			return dst, d.Err()
+		}
+
+		dst = append(dst, pb.Command{})
+		if err := decodeCommandUnsafe(d.hdr.Version, raw, &dst[len(dst)-1]); err != nil {
+			d.fail(err)
+			return dst[:len(dst)-1], err
+		}
+	}
+}
+
+// UnmarshalLogInto is analogous to UnmarshalLogFromReader, but appends decoded
+// commands into the caller provided 'dst', decoding through a LogDecoder arena.
+func UnmarshalLogInto(logRd io.Reader, dst []pb.Command) ([]pb.Command, error) {
+	d, err := NewLogDecoder(logRd)
+	if err != nil {
+		return dst, err
+	}
+	return d.DecodeInto(dst)
+}
+
+// readRaw reads the next length prefixed raw command into a buffer of 'cmdLen' bytes
+// returned by 'alloc'. An errEndOfLog is returned after the last command.
+func (d *LogDecoder) readRaw(alloc func(n int) []byte) ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.hdr.Len >= 0 && d.read == d.hdr.Len {
+		if err := d.readEOL(); err != nil {
+			return nil, err
+		}
+		return nil, errEndOfLog
+	}
+
+	if _, err := io.ReadFull(d.rd, d.lenBuf[:]); err != nil {
+		return nil, d.streamErr(err)
+	}
+	cmdLen := int32(binary.BigEndian.Uint32(d.lenBuf[:]))
+	if err := checkCommandLen(cmdLen); err != nil {
+		return nil, fmt.Errorf("%s at entry %d", err.Error(), d.read)
+	}
+
+	raw := alloc(int(cmdLen))
+	if _, err := io.ReadFull(d.rd, raw); err != nil {
+		return nil, d.streamErr(err)
+	}
+	d.read++
+	return raw, nil
+}
+
+// streamErr interprets a failed read of the log stream. Traditional logs are parsed
+// until EOF, while beelog logs must contain every command and the EOL mark.
+func (d *LogDecoder) streamErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if d.hdr.Len < 0 {
+			return errEndOfLog
+		}
+		return fmt.Errorf("expected a log with %d commands, but got %d", d.hdr.Len, d.read)
+	}
+	return err
+}
+
+// readEOL validates the EOL mark written after the last command of beelog logs.
+func (d *LogDecoder) readEOL() error {
+	var eol [5]byte
+	if _, err := io.ReadFull(d.rd, eol[:]); err != nil {
+		return err
+	}
+	if string(eol[:]) != "\nEOL\n" {
+		return fmt.Errorf("expected EOL flag, got '%s'", eol[1:4])
+	}
+	return nil
+}
+
+func (d *LogDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// scratchBuffer returns the reusable scratch buffer resized to 'n' bytes.
+func (d *LogDecoder) scratchBuffer(n int) []byte {
+	if cap(d.scratch) < n {
+		d.scratch = make([]byte, n)
+	}
+	return d.scratch[:n]
+}
+
+// slabBuffer returns 'n' unused bytes from the current arena slab, allocating a new
+// slab if exhausted. Commands larger than a slab are given a dedicated buffer.
+func (d *LogDecoder) slabBuffer(n int) []byte {
+	if n > defaultDecodeSlab/4 {
+		return make([]byte, n)
+	}
+	if cap(d.slab)-len(d.slab) < n {
+		d.slab = make([]byte, 0, defaultDecodeSlab)
+	}
+	b := d.slab[len(d.slab) : len(d.slab)+n : len(d.slab)+n]
+	d.slab = d.slab[:len(d.slab)+n]
+	return b
+}
@@ -0,0 +1,126 @@
+package beelog
+
+import (
+	"sort"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// SnapshotDelta is a two-phase recovered state: the latest reduced state of a structure
+// followed by the exact history of commands logged after it. Scheduled reduce configs
+// (e.g. Interval) never cover commands logged after their latest reduce, which are
+// instead returned on Delta, so a recovering replica obtains a minimal state plus every
+// recent update on a single call.
+type SnapshotDelta struct {
+	// reduced state trimmed to the requested interval, and the interval it covers
+	Snapshot RecovResult
+
+	// every write and range delete logged within the requested interval after the
+	// snapshot, not reduced, on index order. Reads are never retained by structures
+	Delta []pb.Command
+
+	// latest index covered by both the snapshot and delta, from which consensus may
+	// resume on 'Last' + 1
+	Last uint64
+}
+
+// DeltaRecoverer is implemented by structures retaining every logged write (i.e.
+// ListHT, ArrayHT, AVLTreeHT and SkipListHT), able to recover the commands logged
+// after their latest reduce.
+type DeltaRecoverer interface {
+	RecovSnapshotAndDelta(p, n uint64) (SnapshotDelta, error)
+}
+
+// snapshotAndDelta combines the recovered 'res' of [p, n] with the commands returned by
+// 'collect' for the remaining interval. Must be called within the same mutual exclusion
+// scope 'res' was recovered on, so no command is reduced in between.
+func (ld *logData) snapshotAndDelta(res RecovResult, p, n uint64, collect func(from, to uint64) []pb.Command) SnapshotDelta {
+	sd := SnapshotDelta{Snapshot: res, Last: res.Last}
+	from := p
+	if res.Reduced {
+		if res.Last >= n {
+			return sd
+		}
+		from = res.Last + 1
+	}
+
+	logged, ok := ld.LoggedInterval()
+	if !ok || logged.Last < from {
+		if !res.Reduced {
+			sd.Last = 0
+		}
+		return sd
+	}
+	to := n
+	if logged.Last < to {
+		to = logged.Last
+	}
+
+	sd.Delta = ld.tombs.mergeInto(collect(from, to), from, to)
+	sd.Last = to
+	return sd
+}
+
+// mergeInto appends every range delete logged within [p, n] into 'cmds', sorting the
+// result by index.
+func (rt rangeTombs) mergeInto(cmds []pb.Command, p, n uint64) []pb.Command {
+	merged := false
+	for _, c := range rt {
+		if c.Id >= p && c.Id <= n {
+			cmds = append(cmds, c)
+			merged = true
+		}
+	}
+	if merged {
+		sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].Id < cmds[j].Id })
+	}
+	return cmds
+}
+
+// deltaLog returns every write retained within [p, n], on index order. Must be called
+// within mutual exclusion scope.
+func (l *ListHT) deltaLog(p, n uint64) []pb.Command {
+	var cmds []pb.Command
+	nd, _ := l.searchEntryNodeByIndex(p)
+	for ; nd != nil; nd = nd.next {
+		ent := nd.val.(*listEntry)
+		if ent.ind > n {
+			break
+		}
+		cmds = append(cmds, ent.ptr.val.(*State).cmd)
+	}
+	return cmds
+}
+
+// deltaLog returns every write retained within [p, n], on index order. Must be called
+// within mutual exclusion scope.
+func (ar *ArrayHT) deltaLog(p, n uint64) []pb.Command {
+	var cmds []pb.Command
+	i, _ := ar.searchEntryPosByIndex(p)
+	for ; i < ar.length() && (*ar.arr)[i].ind <= n; i++ {
+		cmds = append(cmds, (*ar.arr)[i].ptr.val.(*State).cmd)
+	}
+	return cmds
+}
+
+// deltaLog returns every write retained within [p, n], on index order. Must be called
+// within mutual exclusion scope.
+func (av *AVLTreeHT) deltaLog(p, n uint64) []pb.Command {
+	var cmds []pb.Command
+	inorderAVL(av.root, func(nd *avlTreeEntry) {
+		if nd.ind >= p && nd.ind <= n {
+			cmds = append(cmds, nd.ptr.val.(*State).cmd)
+		}
+	})
+	return cmds
+}
+
+// deltaLog returns every write retained within [p, n], on index order. Must be called
+// within mutual exclusion scope.
+func (sl *SkipListHT) deltaLog(p, n uint64) []pb.Command {
+	var cmds []pb.Command
+	for ent := sl.searchEntryByIndex(p); ent != nil && ent.ind <= n; ent = ent.next[0] {
+		cmds = append(cmds, ent.ptr.val.(*State).cmd)
+	}
+	return cmds
+}
@@ -0,0 +1,8 @@
+// +build linux
+
+package beelog
+
+import "syscall"
+
+// directFlag opens files for direct IO.
+const directFlag = syscall.O_DIRECT
@@ -0,0 +1,6 @@
+// +build !linux
+
+package beelog
+
+// directFlag is unset on platforms without O_DIRECT, writing through the page cache.
+const directFlag = 0
@@ -0,0 +1,226 @@
+package beelog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// encSegmentMagic prefixes every encrypted segment, followed by the 32b BigEndian
+// identifier of the encryption key, the AES-GCM nonce and the sealed log.
+var encSegmentMagic = []byte("BLENC1\n")
+
+// KeyProvider supplies AES keys (16, 24 or 32 bytes long) to encrypt and decrypt log
+// segments. Each segment records the identifier of the key utilized on its creation,
+// allowing keys to be rotated without re-encrypting prior segments.
+type KeyProvider interface {
+	// CurrentKey returns the key, and its identifier, utilized to encrypt new segments.
+	CurrentKey() (uint32, []byte, error)
+
+	// Key returns the key identified by 'id', utilized to decrypt existing segments.
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing is a thread-safe, in-memory KeyProvider retaining every rotated key.
+type KeyRing struct {
+	mu   sync.RWMutex
+	cur  uint32
+	keys map[uint32][]byte
+}
+
+// NewKeyRing returns a new key ring with 'key', identified by 'id', as current key.
+func NewKeyRing(id uint32, key []byte) (*KeyRing, error) {
+	kr := &KeyRing{keys: make(map[uint32][]byte)}
+	if err := kr.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Rotate sets 'key', identified by 'id', as the current key. Prior keys are retained
+// to decrypt segments created before the rotation.
+func (kr *KeyRing) Rotate(id uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if old, ok := kr.keys[id]; ok && !bytes.Equal(old, key) {
+		return fmt.Errorf("key id %d already registered with a different key", id)
+	}
+	kr.keys[id] = append([]byte(nil), key...)
+	kr.cur = id
+	return nil
+}
+
+// CurrentKey ...
+func (kr *KeyRing) CurrentKey() (uint32, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.cur, kr.keys[kr.cur], nil
+}
+
+// Key ...
+func (kr *KeyRing) Key(id uint32) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %d", id)
+	}
+	return key, nil
+}
+
+// EncryptSegment seals the serialized log 'plain' with the current key of 'kp', using
+// AES-GCM with a random per-segment nonce.
+func EncryptSegment(kp KeyProvider, plain []byte) ([]byte, error) {
+	id, key, err := kp.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, len(encSegmentMagic)+4)
+	copy(hdr, encSegmentMagic)
+	binary.BigEndian.PutUint32(hdr[len(encSegmentMagic):], id)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(hdr)+len(nonce)+len(plain)+gcm.Overhead())
+	out = append(out, hdr...)
+	out = append(out, nonce...)
+
+	// the header is authenticated, preventing key id tampering
+	return gcm.Seal(out, nonce, plain, hdr), nil
+}
+
+// DecryptSegment opens a segment sealed by 'EncryptSegment', retrieving its key from
+// 'kp' by the recorded identifier.
+func DecryptSegment(kp KeyProvider, seg []byte) ([]byte, error) {
+	hl := len(encSegmentMagic) + 4
+	if len(seg) < hl || !bytes.Equal(seg[:len(encSegmentMagic)], encSegmentMagic) {
+		return nil, errors.New("not an encrypted log segment")
+	}
+	id := binary.BigEndian.Uint32(seg[len(encSegmentMagic):hl])
+
+	key, err := kp.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seg) < hl+gcm.NonceSize() {
+		return nil, errors.New("truncated encrypted log segment")
+	}
+	nonce := seg[hl : hl+gcm.NonceSize()]
+	return gcm.Open(nil, nonce, seg[hl+gcm.NonceSize():], seg[:hl])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readSegment opens 'fn' from the configured storage, transparently decrypting its
+// content if config.Encryption is set.
+func (ld *logData) readSegment(fn string) (SegmentReader, error) {
+	rd, err := ld.storage().ReadAt(fn)
+	if err != nil || ld.config.Encryption == nil {
+		return rd, err
+	}
+	defer rd.Close()
+
+	seg, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := DecryptSegment(ld.config.Encryption, seg)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting segment '%s', err: '%s'", fn, err.Error())
+	}
+	return &plainSegment{bytes.NewReader(plain)}, nil
+}
+
+// plainSegment is a decrypted segment content.
+type plainSegment struct {
+	*bytes.Reader
+}
+
+func (ps *plainSegment) Close() error {
+	return nil
+}
+
+// persistEncryptedState is analogous to 'updateLogState', sealing the serialized
+// log before writing it to 'fn'.
+func (ld *logData) persistEncryptedState(fn string, lg []pb.Command, p, n uint64) (uint32, error) {
+	raw, err := ld.marshalBuffer().serialize(&lg, p, n)
+	if err != nil {
+		return 0, err
+	}
+	sum := crc32.Checksum(raw, castagnoli)
+
+	sealed, err := EncryptSegment(ld.config.Encryption, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	seg, err := ld.storage().Create(fn)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = ld.throttle(seg).Write(sealed); err != nil {
+		seg.Close()
+		return 0, err
+	}
+
+	if !ld.config.Sync {
+		if err = seg.Close(); err != nil {
+			return 0, err
+		}
+		ld.hookPersist(fn, int64(len(sealed)))
+		return sum, nil
+	}
+
+	if ld.gc != nil {
+		if err = ld.gc.commit(fn, seg); err != nil {
+			return 0, err
+		}
+		ld.hookPersist(fn, int64(len(sealed)))
+		return sum, nil
+	}
+
+	defer seg.Close()
+	if err = seg.Sync(); err != nil {
+		return 0, err
+	}
+	ld.hookPersist(fn, int64(len(sealed)))
+	return sum, nil
+}
@@ -0,0 +1,122 @@
+package beelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// entireLogVersion is the current version of the envelope written by 'RecovEntireLog'.
+const entireLogVersion uint8 = 1
+
+// entireLogMagic prefixes every envelope produced by 'RecovEntireLog'.
+var entireLogMagic = []byte("BEELOGS")
+
+// ErrSegmentChecksum is returned by 'DecodeEntireLogStream' and entire log recoveries
+// when a segment content does not match its checksum.
+var ErrSegmentChecksum = errors.New("segment checksum mismatch")
+
+// encodeEntireLog frames every serialized segment in 'segs' into a single envelope,
+// following the format:
+//   'BEELOGS''version byte'
+//   'segment count' (uint32)
+//   for each segment:
+//     'segment length' (uint64)
+//     'segment' (length bytes)
+//     'crc32 checksum of segment' (uint32, Castagnoli)
+// Every integer is encoded in big endian.
+func encodeEntireLog(segs [][]byte) []byte {
+	size := len(entireLogMagic) + 1 + 4
+	for _, sg := range segs {
+		size += 8 + len(sg) + 4
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	buf.Write(entireLogMagic)
+	buf.WriteByte(entireLogVersion)
+
+	var num [8]byte
+	binary.BigEndian.PutUint32(num[:4], uint32(len(segs)))
+	buf.Write(num[:4])
+
+	for _, sg := range segs {
+		binary.BigEndian.PutUint64(num[:], uint64(len(sg)))
+		buf.Write(num[:])
+		buf.Write(sg)
+		binary.BigEndian.PutUint32(num[:4], crc32.Checksum(sg, castagnoli))
+		buf.Write(num[:4])
+	}
+	return buf.Bytes()
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ReadEntireLogSegments interprets an envelope returned by 'RecovEntireLog' from 'rd',
+// returning each serialized segment, header included, after validating its checksum.
+func ReadEntireLogSegments(rd io.Reader) ([][]byte, error) {
+	br := bufio.NewReader(rd)
+	prefix := make([]byte, len(entireLogMagic)+1)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(entireLogMagic)], entireLogMagic) {
+		return nil, fmt.Errorf("invalid entire log magic '%s'", prefix[:len(entireLogMagic)])
+	}
+	if v := prefix[len(entireLogMagic)]; v != entireLogVersion {
+		return nil, fmt.Errorf("unsupported entire log version %d", v)
+	}
+
+	var num [8]byte
+	if _, err := io.ReadFull(br, num[:4]); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint32(num[:4])
+
+	segs := make([][]byte, 0, preallocLen(int(count)))
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(br, num[:]); err != nil {
+			return nil, fmt.Errorf("failed reading segment %d length, err: '%s'", i, err.Error())
+		}
+		ln := binary.BigEndian.Uint64(num[:])
+
+		// never trust the informed length to allocate the entire segment upfront
+		sg := bytes.NewBuffer(nil)
+		if n, err := io.CopyN(sg, br, int64(ln)); err != nil {
+			return nil, fmt.Errorf("failed reading segment %d, got %d of %d bytes, err: '%s'", i, n, ln, err.Error())
+		}
+
+		if _, err := io.ReadFull(br, num[:4]); err != nil {
+			return nil, fmt.Errorf("failed reading segment %d checksum, err: '%s'", i, err.Error())
+		}
+		if binary.BigEndian.Uint32(num[:4]) != crc32.Checksum(sg.Bytes(), castagnoli) {
+			return nil, fmt.Errorf("segment %d: %w", i, ErrSegmentChecksum)
+		}
+		segs = append(segs, sg.Bytes())
+	}
+	return segs, nil
+}
+
+// DecodeEntireLogStream interprets an envelope returned by 'RecovEntireLog' from 'rd',
+// returning the commands of every segment in the same order they were framed.
+func DecodeEntireLogStream(rd io.Reader) ([]pb.Command, error) {
+	segs, err := ReadEntireLogSegments(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]pb.Command, 0)
+	for i, sg := range segs {
+		log, err := UnmarshalLogFromBytes(sg)
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding segment %d, err: '%s'", i, err.Error())
+		}
+		cmds = append(cmds, log...)
+	}
+	return cmds, nil
+}
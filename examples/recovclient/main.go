@@ -0,0 +1,107 @@
+// Command recovclient is the reference decoder of the Recovery framing described on
+// pb/recovery.proto. It intentionally depends only on the generated messages and on
+// varint framing, illustrating the steps replicas on other languages must follow.
+//
+// Usage:
+//
+//	recovclient [-addr localhost:9000] <first> <last>
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// protoLogFormatVersion is the format version announced by Recovery servers.
+const protoLogFormatVersion = 2
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("recovclient: ")
+
+	addr := flag.String("addr", "localhost:9000", "server address")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		log.Fatalln("usage: recovclient [-addr host:port] <first> <last>")
+	}
+	first, err := strconv.ParseUint(flag.Arg(0), 10, 64)
+	if err != nil {
+		log.Fatalln("invalid first index:", err.Error())
+	}
+	last, err := strconv.ParseUint(flag.Arg(1), 10, 64)
+	if err != nil {
+		log.Fatalln("invalid last index:", err.Error())
+	}
+
+	seg, err := recov(*addr, first, last)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	fmt.Printf("segment [%d, %d] with %d commands\n", seg.First, seg.Last, seg.N)
+	for i := range seg.Commands {
+		fmt.Fprintln(os.Stdout, seg.Commands[i].String())
+	}
+}
+
+// recov requests the reduced log of [first, last] from the server at 'addr'.
+func recov(addr string, first, last uint64) (*pb.LogSegment, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	// 1. the server announces the format: 'BEELOG', the version byte and a '\n'
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return nil, fmt.Errorf("could not read header: %s", err.Error())
+	}
+	if !bytes.Equal(hdr[:6], []byte("BEELOG")) || hdr[6] != protoLogFormatVersion || hdr[7] != '\n' {
+		return nil, fmt.Errorf("unexpected header %q", hdr)
+	}
+
+	// 2. requests are written prefixed by their length as an unsigned varint
+	req := pb.RecovRequest{First: first, Last: last}
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+req.SizeVT())
+	b = req.MarshalAppendVT(b[:binary.PutUvarint(b, uint64(req.SizeVT()))])
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	// 3. the response segment is framed in the same way, and a closed connection
+	// signals a failed request
+	size, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, fmt.Errorf("could not read segment length: %s", err.Error())
+	}
+	if size > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid segment length %d", size)
+	}
+	raw := bytes.NewBuffer(nil)
+	if _, err := io.CopyN(raw, rd, int64(size)); err != nil {
+		return nil, fmt.Errorf("truncated segment, expected %d bytes but got %d", size, raw.Len())
+	}
+
+	// 4. the segment carries its own number of commands, validating its integrity
+	seg := &pb.LogSegment{}
+	if err := seg.UnmarshalVT(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if seg.N != uint64(len(seg.Commands)) {
+		return nil, fmt.Errorf("expected %d commands, but got %d", seg.N, len(seg.Commands))
+	}
+	return seg, nil
+}
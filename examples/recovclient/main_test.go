@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+var header = []byte{'B', 'E', 'E', 'L', 'O', 'G', bl.ProtoLogFormatVersion, '\n'}
+
+// listen serves a single connection through 'handle', returning the server address.
+func listen(t *testing.T, handle func(conn net.Conn, rd *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn, bufio.NewReader(conn))
+	}()
+	return ln.Addr().String()
+}
+
+// readRequest reads a single framed request from 'rd'.
+func readRequest(rd *bufio.Reader) (*pb.RecovRequest, error) {
+	size, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(rd, raw); err != nil {
+		return nil, err
+	}
+	req := &pb.RecovRequest{}
+	return req, req.UnmarshalVT(raw)
+}
+
+func TestRecov(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 2, Op: pb.Command_SET, Key: "a", Value: "2"},
+		{Id: 4, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_IntValue{IntValue: -4}},
+	}
+
+	// answers the request with the framed segment of 'cmds', then applies 'corrupt'
+	// over the response before writing it
+	respond := func(corrupt func(b []byte) []byte) func(conn net.Conn, rd *bufio.Reader) {
+		return func(conn net.Conn, rd *bufio.Reader) {
+			conn.Write(header)
+			req, err := readRequest(rd)
+			if err != nil {
+				return
+			}
+			buf := bytes.NewBuffer(nil)
+			if err := bl.AppendProtoSegment(buf, &cmds, req.First, req.Last); err != nil {
+				return
+			}
+			conn.Write(corrupt(buf.Bytes()))
+		}
+	}
+	keep := func(b []byte) []byte { return b }
+
+	testCases := []struct {
+		name    string
+		handle  func(conn net.Conn, rd *bufio.Reader)
+		wantErr bool
+	}{
+		{"Valid", respond(keep), false},
+		{"TruncatedSegment", respond(func(b []byte) []byte { return b[:len(b)-3] }), true},
+		{"TruncatedLength", respond(func(b []byte) []byte { return []byte{0x80} }), true},
+		{"MismatchedN", respond(func(b []byte) []byte {
+			seg := pb.LogSegment{}
+			size, n := binary.Uvarint(b)
+			seg.UnmarshalVT(b[n : n+int(size)])
+			seg.N++
+
+			out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+seg.SizeVT())
+			return seg.MarshalAppendVT(out[:binary.PutUvarint(out, uint64(seg.SizeVT()))])
+		}), true},
+		{"ClosedConnection", func(conn net.Conn, rd *bufio.Reader) {
+			conn.Write(header)
+			readRequest(rd)
+		}, true},
+		{"WrongVersion", func(conn net.Conn, rd *bufio.Reader) {
+			conn.Write([]byte{'B', 'E', 'E', 'L', 'O', 'G', bl.LogFormatVersion, '\n'})
+		}, true},
+		{"WrongMagic", func(conn net.Conn, rd *bufio.Reader) {
+			conn.Write([]byte("BEELOX\x02\n"))
+		}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := listen(t, tc.handle)
+			seg, err := recov(addr, 1, 4)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got segment %v", seg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seg.First != 1 || seg.Last != 4 || seg.N != uint64(len(cmds)) || len(seg.Commands) != len(cmds) {
+				t.Fatalf("unexpected segment %v", seg)
+			}
+			for i := range cmds {
+				if seg.Commands[i].String() != cmds[i].String() {
+					t.Fatalf("got command %v, expected %v", seg.Commands[i].String(), cmds[i].String())
+				}
+			}
+		})
+	}
+}
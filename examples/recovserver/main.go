@@ -0,0 +1,129 @@
+// Command recovserver is an example TCP server of the Recovery service described on
+// pb/recovery.proto, serving reduced logs of an in-memory structure to replicas of
+// any language through plain protobuf framing.
+//
+// Usage:
+//
+//	recovserver [-addr :9000] [-n 10000] [-keys 100]
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// maxRequestLen bounds the length of received requests, which carry only two varints.
+const maxRequestLen = 64
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("recovserver: ")
+
+	addr := flag.String("addr", ":9000", "listen address")
+	n := flag.Uint64("n", 10000, "number of generated commands")
+	keys := flag.Int("keys", 100, "number of distinct keys on generated commands")
+	flag.Parse()
+
+	st, err := populate(*n, *keys)
+	if err != nil {
+		log.Fatalln("could not populate structure:", err.Error())
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	log.Printf("serving [1, %d] on %s\n", *n, ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		go func() {
+			if err := serve(conn, st); err != nil {
+				log.Printf("%s: %s\n", conn.RemoteAddr(), err.Error())
+			}
+		}()
+	}
+}
+
+// populate returns an in-memory structure with 'n' random writes over 'keys' keys.
+func populate(n uint64, keys int) (bl.Structure, error) {
+	st, err := bl.NewListHTWithConfig(bl.DefaultLogConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(1); i <= n; i++ {
+		cmd := pb.Command{
+			Id:    i,
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(rand.Intn(keys)),
+			Value: strconv.FormatUint(i, 10),
+		}
+		if err := st.Log(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// serve writes the format header into 'conn', then answers each received RecovRequest
+// with the reduced log of its interval, until the client closes the connection. Any
+// failure closes the connection, as defined by the Recovery framing.
+func serve(conn net.Conn, st bl.Structure) error {
+	defer conn.Close()
+	if _, err := conn.Write([]byte{'B', 'E', 'E', 'L', 'O', 'G', bl.ProtoLogFormatVersion, '\n'}); err != nil {
+		return err
+	}
+
+	rd := bufio.NewReader(conn)
+	wr := bufio.NewWriter(conn)
+	for {
+		req, err := readRequest(rd)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		cmds, err := st.Recov(req.First, req.Last)
+		if err != nil {
+			return err
+		}
+		if err := bl.AppendProtoSegment(wr, &cmds, req.First, req.Last); err != nil {
+			return err
+		}
+		if err := wr.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func readRequest(rd *bufio.Reader) (*pb.RecovRequest, error) {
+	size, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxRequestLen {
+		return nil, fmt.Errorf("invalid request length %d", size)
+	}
+
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(rd, raw); err != nil {
+		return nil, err
+	}
+	req := &pb.RecovRequest{}
+	return req, req.UnmarshalVT(raw)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	bl "github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// writeRequest writes 'req' into 'conn', prefixed by its length.
+func writeRequest(conn net.Conn, req *pb.RecovRequest) error {
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+req.SizeVT())
+	b = req.MarshalAppendVT(b[:binary.PutUvarint(b, uint64(req.SizeVT()))])
+	_, err := conn.Write(b)
+	return err
+}
+
+func TestServe(t *testing.T) {
+	st, err := populate(100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, srv := net.Pipe()
+	defer cli.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- serve(srv, st) }()
+	rd := bufio.NewReader(cli)
+
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hdr, []byte{'B', 'E', 'E', 'L', 'O', 'G', bl.ProtoLogFormatVersion, '\n'}) {
+		t.Fatalf("unexpected header %q", hdr)
+	}
+
+	// every request on the same connection is answered by a single segment
+	for _, req := range []pb.RecovRequest{{First: 1, Last: 100}, {First: 20, Last: 50}} {
+		if err := writeRequest(cli, &req); err != nil {
+			t.Fatal(err)
+		}
+		size, err := binary.ReadUvarint(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(rd, raw); err != nil {
+			t.Fatal(err)
+		}
+		var seg pb.LogSegment
+		if err := seg.UnmarshalVT(raw); err != nil {
+			t.Fatal(err)
+		}
+
+		exp, err := st.Recov(req.First, req.Last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seg.First != req.First || seg.Last != req.Last || seg.N != uint64(len(exp)) || len(seg.Commands) != len(exp) {
+			t.Fatalf("got segment [%d, %d] with %d commands, expected [%d, %d] with %d", seg.First, seg.Last, seg.N, req.First, req.Last, len(exp))
+		}
+	}
+
+	// closing the connection finishes the session without errors
+	cli.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeInvalidRequests(t *testing.T) {
+	st, err := populate(10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		raw   []byte
+		close bool // client closes the connection after writing 'raw'
+	}{
+		{"OversizedLength", []byte{maxRequestLen + 1}, false},
+		{"TruncatedRequest", []byte{4, 1 << 3, 1}, true},
+		{"TruncatedField", []byte{2, 1 << 3, 0x80}, false},
+		{"InvalidInterval", []byte{4, 1 << 3, 9, 2 << 3, 1}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			defer cli.Close()
+
+			done := make(chan error, 1)
+			go func() { done <- serve(srv, st) }()
+			go io.Copy(ioutil.Discard, cli)
+
+			cli.Write(tc.raw)
+			if tc.close {
+				cli.Close()
+			}
+
+			// failed requests close the connection
+			if err := <-done; err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
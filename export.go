@@ -0,0 +1,159 @@
+package beelog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Format ...
+type Format int8
+
+const (
+	// Text exports one command per line as 'op key value', utilized by prior
+	// experiment dumps.
+	Text Format = iota
+
+	// JSON exports one JSON object per line (i.e. JSON Lines), which can be
+	// directly inspected by tools like jq.
+	JSON
+
+	// CSV exports a header row followed by one record per command.
+	CSV
+)
+
+// String ...
+func (f Format) String() string {
+	switch f {
+	case Text:
+		return "text"
+	case JSON:
+		return "json"
+	case CSV:
+		return "csv"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFormat returns the export format named by 's' (i.e. "text", "json" or "csv").
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "text", "":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	case "csv":
+		return CSV, nil
+	default:
+		return Text, fmt.Errorf("unknown export format '%s'", s)
+	}
+}
+
+// exportedCommand is the JSON representation of a pb.Command.
+type exportedCommand struct {
+	ID        uint64   `json:"id"`
+	Op        string   `json:"op"`
+	Key       string   `json:"key"`
+	EndKey    string   `json:"endKey,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	Bytes     []byte   `json:"bytes,omitempty"`
+	Int       *int64   `json:"int,omitempty"`
+	Float     *float64 `json:"float,omitempty"`
+	Expected  string   `json:"expected,omitempty"`
+	ExpiresAt int64    `json:"expiresAt,omitempty"`
+	Term      uint64   `json:"term,omitempty"`
+	ClientID  string   `json:"clientId,omitempty"`
+	RequestID uint64   `json:"requestId,omitempty"`
+}
+
+// newExportedCommand returns the JSON representation of 'cmd', retaining the type
+// of its payload.
+func newExportedCommand(cmd *pb.Command) exportedCommand {
+	ec := exportedCommand{
+		ID:        cmd.Id,
+		Op:        cmd.Op.String(),
+		Key:       cmd.Key,
+		EndKey:    cmd.EndKey,
+		Value:     cmd.Value,
+		Expected:  cmd.Expected,
+		ExpiresAt: cmd.ExpiresAt,
+		Term:      cmd.Term,
+		ClientID:  cmd.ClientId,
+		RequestID: cmd.RequestId,
+	}
+
+	switch v := cmd.Typed.(type) {
+	case *pb.Command_BytesValue:
+		ec.Bytes = v.BytesValue
+
+	case *pb.Command_IntValue:
+		ec.Int = &v.IntValue
+
+	case *pb.Command_FloatValue:
+		ec.Float = &v.FloatValue
+	}
+	return ec
+}
+
+var csvHeader = []string{"id", "op", "key", "endKey", "value", "expected", "expiresAt", "term", "clientId", "requestId"}
+
+// ExportLog writes 'cmds' into 'w' following a human readable 'format', allowing
+// recovered or compacted logs to be inspected by external analysis tools.
+func ExportLog(w io.Writer, format Format, cmds []pb.Command) error {
+	switch format {
+	case Text:
+		for _, cmd := range cmds {
+			_, err := fmt.Fprintf(w, "%d %s %v\n", cmd.Op, cmd.Key, cmd.ValueString())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case JSON:
+		enc := json.NewEncoder(w)
+		for i := range cmds {
+			ec := newExportedCommand(&cmds[i])
+			if err := enc.Encode(&ec); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+
+		for _, cmd := range cmds {
+			rec := []string{
+				strconv.FormatUint(cmd.Id, 10),
+				cmd.Op.String(),
+				cmd.Key,
+				cmd.EndKey,
+				cmd.ValueString(),
+				cmd.Expected,
+				strconv.FormatInt(cmd.ExpiresAt, 10),
+				strconv.FormatUint(cmd.Term, 10),
+				cmd.ClientId,
+				strconv.FormatUint(cmd.RequestId, 10),
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return errors.New("unknown export format")
+	}
+}
@@ -0,0 +1,44 @@
+package beelog
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// KeyFilter informs if commands over 'key' must be retained during a filtered recovery,
+// allowing sharded applications to recover only their key range from a shared instance.
+type KeyFilter func(key string) bool
+
+// KeyPrefix returns a filter retaining only keys starting with 'prefix'.
+func KeyPrefix(prefix string) KeyFilter {
+	return func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+}
+
+// filterByKey returns the commands of 'cmds' whose keys are retained by 'f'. A nil
+// filter retains every command.
+func filterByKey(cmds []pb.Command, f KeyFilter) []pb.Command {
+	if f == nil {
+		return cmds
+	}
+
+	log := make([]pb.Command, 0, len(cmds))
+	for _, c := range cmds {
+		if f(c.Key) {
+			log = append(log, c)
+		}
+	}
+	return log
+}
+
+// marshalFilteredLog serializes an already filtered log, analogous to 'RecovBytes'.
+func marshalFilteredLog(cmds []pb.Command, p, n uint64) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(buff, &cmds, p, n); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
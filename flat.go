@@ -0,0 +1,370 @@
+package beelog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Codec is the encoding of each command on serialized logs.
+type Codec uint8
+
+const (
+	// ProtoCodec encodes commands as protobuf messages, following LogFormatVersion.
+	ProtoCodec Codec = iota
+
+	// FlatCodec encodes commands on a fixed layout, following FlatLogFormatVersion,
+	// where every field is read in place without parsing (see 'FlatCommand'). Logs
+	// recovered through RecovBytes (or memory mapped segments) are traversed by
+	// IterFlatLog without decoding or copying any command, at the cost of slightly
+	// larger segments than protobuf varints.
+	FlatCodec
+)
+
+// version returns the log format version written by the codec.
+func (c Codec) version() uint8 {
+	if c == FlatCodec {
+		return FlatLogFormatVersion
+	}
+	return LogFormatVersion
+}
+
+// codecOf returns the codec of commands serialized on log format 'version'.
+func codecOf(version uint8) Codec {
+	if version == FlatLogFormatVersion {
+		return FlatCodec
+	}
+	return ProtoCodec
+}
+
+// Flat commands are laid out as a fixed-size header followed by the raw content of
+// every variable length field, in the order of 'flatIp' to 'flatUnrecognized':
+//
+//	Id, ExpiresAt, Term, RequestId, numeric payload: 8 bytes each, little-endian
+//	Op, payload kind: 1 byte each
+//	length of each variable length field: 4 bytes each, little-endian
+//	content of each variable length field
+const (
+	flatIp = iota
+	flatKey
+	flatValue
+	flatExpected
+	flatClientID
+	flatEndKey
+	flatBytes
+	flatUnrecognized
+	flatFields
+)
+
+const (
+	flatLensOffset = 42
+	flatHeaderSize = flatLensOffset + 4*flatFields
+)
+
+// payload kinds of flat commands, identifying which Typed value is set
+const (
+	flatNoPayload = iota
+	flatBytesPayload
+	flatIntPayload
+	flatFloatPayload
+)
+
+var errInvalidFlatCommand = errors.New("invalid flat command encoding")
+
+// FlatCommand is a command serialized by FlatCodec, whose fields are read in place.
+// Strings and byte slices returned by its methods alias the underlying buffer, and
+// must not be retained after it's released (e.g. an unmapped segment).
+type FlatCommand []byte
+
+// Id ...
+func (fc FlatCommand) Id() uint64 { return binary.LittleEndian.Uint64(fc[0:]) }
+
+// ExpiresAt ...
+func (fc FlatCommand) ExpiresAt() int64 { return int64(binary.LittleEndian.Uint64(fc[8:])) }
+
+// Term ...
+func (fc FlatCommand) Term() uint64 { return binary.LittleEndian.Uint64(fc[16:]) }
+
+// RequestId ...
+func (fc FlatCommand) RequestId() uint64 { return binary.LittleEndian.Uint64(fc[24:]) }
+
+// Op ...
+func (fc FlatCommand) Op() pb.Command_Operation { return pb.Command_Operation(fc[40]) }
+
+// Ip ...
+func (fc FlatCommand) Ip() string { return aliasString(fc.field(flatIp)) }
+
+// Key ...
+func (fc FlatCommand) Key() string { return aliasString(fc.field(flatKey)) }
+
+// Value ...
+func (fc FlatCommand) Value() string { return aliasString(fc.field(flatValue)) }
+
+// Expected ...
+func (fc FlatCommand) Expected() string { return aliasString(fc.field(flatExpected)) }
+
+// ClientId ...
+func (fc FlatCommand) ClientId() string { return aliasString(fc.field(flatClientID)) }
+
+// EndKey ...
+func (fc FlatCommand) EndKey() string { return aliasString(fc.field(flatEndKey)) }
+
+// BytesValue returns the binary payload of the command, if any.
+func (fc FlatCommand) BytesValue() ([]byte, bool) {
+	return fc.field(flatBytes), fc[41] == flatBytesPayload
+}
+
+// IntValue returns the integer payload of the command, if any.
+func (fc FlatCommand) IntValue() (int64, bool) {
+	return int64(binary.LittleEndian.Uint64(fc[32:])), fc[41] == flatIntPayload
+}
+
+// FloatValue returns the floating point payload of the command, if any.
+func (fc FlatCommand) FloatValue() (float64, bool) {
+	return math.Float64frombits(binary.LittleEndian.Uint64(fc[32:])), fc[41] == flatFloatPayload
+}
+
+// Decode fills 'c' with every field of the flat command, where strings and byte
+// slices alias the flat command buffer.
+func (fc FlatCommand) Decode(c *pb.Command) {
+	*c = pb.Command{
+		Id:        fc.Id(),
+		Ip:        fc.Ip(),
+		Op:        fc.Op(),
+		Key:       fc.Key(),
+		Value:     fc.Value(),
+		Expected:  fc.Expected(),
+		ExpiresAt: fc.ExpiresAt(),
+		Term:      fc.Term(),
+		ClientId:  fc.ClientId(),
+		RequestId: fc.RequestId(),
+		EndKey:    fc.EndKey(),
+	}
+
+	switch fc[41] {
+	case flatBytesPayload:
+		c.Typed = &pb.Command_BytesValue{BytesValue: fc.field(flatBytes)}
+	case flatIntPayload:
+		v, _ := fc.IntValue()
+		c.Typed = &pb.Command_IntValue{IntValue: v}
+	case flatFloatPayload:
+		v, _ := fc.FloatValue()
+		c.Typed = &pb.Command_FloatValue{FloatValue: v}
+	}
+	if u := fc.field(flatUnrecognized); len(u) > 0 {
+		c.XXX_unrecognized = u
+	}
+}
+
+// field returns the content of the variable length field 'i'.
+func (fc FlatCommand) field(i int) []byte {
+	off := flatHeaderSize
+	for j := 0; j < i; j++ {
+		off += int(binary.LittleEndian.Uint32(fc[flatLensOffset+4*j:]))
+	}
+	l := int(binary.LittleEndian.Uint32(fc[flatLensOffset+4*i:]))
+	return fc[off : off+l : off+l]
+}
+
+// validate checks if the lengths of every field are consistent with the flat command
+// size, so its accessors never read out of bounds.
+func (fc FlatCommand) validate() error {
+	if len(fc) < flatHeaderSize || fc[41] > flatFloatPayload {
+		return errInvalidFlatCommand
+	}
+	size := uint64(flatHeaderSize)
+	for i := 0; i < flatFields; i++ {
+		size += uint64(binary.LittleEndian.Uint32(fc[flatLensOffset+4*i:]))
+	}
+	if size != uint64(len(fc)) {
+		return errInvalidFlatCommand
+	}
+	return nil
+}
+
+// flatFieldsOf returns the variable length fields of 'c', indexed by their layout.
+func flatFieldsOf(c *pb.Command) [flatFields]string {
+	var fs [flatFields]string
+	fs[flatIp], fs[flatKey], fs[flatValue], fs[flatExpected] = c.Ip, c.Key, c.Value, c.Expected
+	fs[flatClientID], fs[flatEndKey] = c.ClientId, c.EndKey
+	if v, ok := c.Typed.(*pb.Command_BytesValue); ok {
+		fs[flatBytes] = aliasString(v.BytesValue)
+	}
+	fs[flatUnrecognized] = aliasString(c.XXX_unrecognized)
+	return fs
+}
+
+// appendFlatCommand is analogous to 'appendCommand', appending 'c' encoded by
+// FlatCodec, prefixed by its big endian int32 encoded size.
+func appendFlatCommand(b []byte, c *pb.Command) []byte {
+	fs := flatFieldsOf(c)
+	sz := flatHeaderSize
+	for _, f := range fs {
+		sz += len(f)
+	}
+	if free := cap(b) - len(b); free < 4+sz {
+		nb := make([]byte, len(b), 2*cap(b)+4+sz)
+		copy(nb, b)
+		b = nb
+	}
+
+	var hdr [4 + flatHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(sz))
+	fixed := hdr[4:]
+	binary.LittleEndian.PutUint64(fixed[0:], c.Id)
+	binary.LittleEndian.PutUint64(fixed[8:], uint64(c.ExpiresAt))
+	binary.LittleEndian.PutUint64(fixed[16:], c.Term)
+	binary.LittleEndian.PutUint64(fixed[24:], c.RequestId)
+	fixed[40] = byte(c.Op)
+
+	switch v := c.Typed.(type) {
+	case *pb.Command_BytesValue:
+		fixed[41] = flatBytesPayload
+	case *pb.Command_IntValue:
+		fixed[41] = flatIntPayload
+		binary.LittleEndian.PutUint64(fixed[32:], uint64(v.IntValue))
+	case *pb.Command_FloatValue:
+		fixed[41] = flatFloatPayload
+		binary.LittleEndian.PutUint64(fixed[32:], math.Float64bits(v.FloatValue))
+	}
+	for i, f := range fs {
+		binary.LittleEndian.PutUint32(fixed[flatLensOffset+4*i:], uint32(len(f)))
+	}
+
+	b = append(b, hdr[:]...)
+	for _, f := range fs {
+		b = append(b, f...)
+	}
+	return b
+}
+
+// appendCommandWithCodec appends 'c' into 'b' encoded by 'codec', prefixed by its big
+// endian int32 encoded size.
+func appendCommandWithCodec(b []byte, codec Codec, c *pb.Command) []byte {
+	if codec == FlatCodec {
+		return appendFlatCommand(b, c)
+	}
+	return appendCommand(b, c)
+}
+
+// decodeCommand decodes the raw command 'raw' serialized on log format 'version' into
+// 'c', copying every field.
+func decodeCommand(version uint8, raw []byte, c *pb.Command) error {
+	if version != FlatLogFormatVersion {
+		return proto.Unmarshal(raw, c)
+	}
+	fc := FlatCommand(raw)
+	if err := fc.validate(); err != nil {
+		return err
+	}
+	fc.Decode(c)
+	cloneFlatFields(c)
+	return nil
+}
+
+// decodeCommandUnsafe is analogous to 'decodeCommand', but strings and byte slices
+// alias 'raw' instead of being copied.
+func decodeCommandUnsafe(version uint8, raw []byte, c *pb.Command) error {
+	if version != FlatLogFormatVersion {
+		return c.UnmarshalVTUnsafe(raw)
+	}
+	fc := FlatCommand(raw)
+	if err := fc.validate(); err != nil {
+		return err
+	}
+	fc.Decode(c)
+	return nil
+}
+
+// cloneFlatFields replaces every field of 'c' aliasing a flat command buffer by a copy.
+func cloneFlatFields(c *pb.Command) {
+	c.Ip, c.Key, c.Value = cloneString(c.Ip), cloneString(c.Key), cloneString(c.Value)
+	c.Expected, c.ClientId, c.EndKey = cloneString(c.Expected), cloneString(c.ClientId), cloneString(c.EndKey)
+	if v, ok := c.Typed.(*pb.Command_BytesValue); ok {
+		v.BytesValue = append([]byte{}, v.BytesValue...)
+	}
+	if c.XXX_unrecognized != nil {
+		c.XXX_unrecognized = append([]byte{}, c.XXX_unrecognized...)
+	}
+}
+
+func cloneString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return string([]byte(s))
+}
+
+// aliasString returns a string sharing the memory of 'b'.
+func aliasString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// MarshalLogWithCodec is analogous to 'MarshalLogIntoWriter', but encodes commands
+// through 'codec', writing the corresponding log format version. Every Unmarshal*
+// procedure interprets logs of both codecs.
+func MarshalLogWithCodec(logWr io.Writer, codec Codec, log *[]pb.Command, p, n uint64) error {
+	if _, err := logWr.Write(encodeVersionedLogHeader(codec.version(), p, n, len(*log))); err != nil {
+		return err
+	}
+	if err := marshalCommandsWithCodec(logWr, codec, log); err != nil {
+		return err
+	}
+
+	// manually write an add-hoc EOL (end-of-log) mark
+	_, err := fmt.Fprintln(logWr, "\nEOL")
+	return err
+}
+
+// IterFlatLog calls 'fn' for each command of the FlatCodec log 'log' (e.g. returned
+// by RecovBytes, or a memory mapped segment), in order, without decoding or copying
+// any of them. Iteration stops on the first error returned by 'fn'.
+func IterFlatLog(log []byte, fn func(fc FlatCommand) error) error {
+	rd := bytes.NewReader(log)
+	_, hdr, err := ReadLogHeader(rd)
+	if err != nil {
+		return err
+	}
+	if hdr.Version != FlatLogFormatVersion {
+		return fmt.Errorf("expected log format version %d, got %d", FlatLogFormatVersion, hdr.Version)
+	}
+
+	off := len(log) - rd.Len()
+	for j := 0; hdr.Len < 0 || j < hdr.Len; j++ {
+		if hdr.Len < 0 && off+4 > len(log) {
+			return nil
+		}
+		if off+4 > len(log) {
+			return fmt.Errorf("expected a log with %d commands, but got %d", hdr.Len, j)
+		}
+		cmdLen := int(int32(binary.BigEndian.Uint32(log[off:])))
+		off += 4
+
+		if cmdLen < 0 || cmdLen > MaxCommandSize || off+cmdLen > len(log) {
+			return fmt.Errorf("invalid command length %d at offset %d", cmdLen, off-4)
+		}
+		fc := FlatCommand(log[off : off+cmdLen : off+cmdLen])
+		if err := fc.validate(); err != nil {
+			return fmt.Errorf("%s at entry %d", err.Error(), j)
+		}
+		if err := fn(fc); err != nil {
+			return err
+		}
+		off += cmdLen
+	}
+
+	if !bytes.HasPrefix(log[off:], []byte("\nEOL\n")) {
+		return errors.New("expected EOL flag after the last command")
+	}
+	return nil
+}
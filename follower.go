@@ -0,0 +1,253 @@
+package beelog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// default interval between reads of a followed log once its end is reached
+	defaultFollowPoll = 50 * time.Millisecond
+
+	// number of bytes read from a followed log on each call
+	followChunkSize = 64 * 1024
+)
+
+// Follower tails a growing log file, decoding new commands as they are appended by a
+// concurrent writer (e.g. a traditional log header written by MarshalTradLogIntoWriter,
+// followed by MarshalAndAppendIntoWriter calls). Partially written commands are
+// retained until completed by later writes. Logs on beelog format are decoded until
+// their EOL mark, validating the number of commands recorded on their header, while
+// traditional logs are followed until closed. Truncated or replaced files are not
+// detected, and pb.LogSegment logs (ProtoLogFormatVersion) are not supported.
+type Follower struct {
+	fd   *os.File
+	poll time.Duration
+	cmds chan pb.Command
+	canc context.CancelFunc
+	done chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	hdr    LogHeader
+	offset int64
+}
+
+// NewFollower opens the log file 'fname' and starts following it from its beginning,
+// polling for new content every 'poll' interval once its end is reached, or a default
+// interval if zero. Decoded commands are emitted on the 'Commands' channel, until 'ctx'
+// is done, the follower is closed, or a failure is observed.
+func NewFollower(ctx context.Context, fname string, poll time.Duration) (*Follower, error) {
+	if poll < 0 {
+		return nil, fmt.Errorf("invalid poll interval %s, must be non-negative", poll)
+	}
+	if poll == 0 {
+		poll = defaultFollowPoll
+	}
+
+	fd, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	c, cancel := context.WithCancel(ctx)
+	f := &Follower{
+		fd:   fd,
+		poll: poll,
+		cmds: make(chan pb.Command, chanBuffSize),
+		canc: cancel,
+		done: make(chan struct{}),
+	}
+	go f.follow(c)
+	return f, nil
+}
+
+// Commands returns the channel of decoded commands, closed once the follower stops.
+// Err informs the reason after its close.
+func (f *Follower) Commands() <-chan pb.Command {
+	return f.cmds
+}
+
+// Err returns the failure that stopped the follower, or nil if it was closed, its
+// context is done, or a beelog log was entirely decoded.
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Header returns the header of the followed log, once read.
+func (f *Follower) Header() LogHeader {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hdr
+}
+
+// Offset returns the number of bytes of the followed log consumed by decoded commands,
+// including its header.
+func (f *Follower) Offset() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.offset
+}
+
+// Close stops the follower, closing the 'Commands' channel and the followed file.
+func (f *Follower) Close() error {
+	f.canc()
+	<-f.done
+	return f.fd.Close()
+}
+
+func (f *Follower) follow(ctx context.Context) {
+	defer close(f.done)
+	defer close(f.cmds)
+
+	var (
+		pending []byte
+		hdrRead bool
+		decoded int
+		pos     int
+	)
+	chunk := make([]byte, followChunkSize)
+
+	for {
+		n, err := f.fd.Read(chunk)
+		if err != nil && err != io.EOF {
+			f.fail(err)
+			return
+		}
+		pending = append(pending, chunk[:n]...)
+
+		if !hdrRead {
+			hdr, ln, ok, err := parseFollowedHeader(pending)
+			if err != nil {
+				f.fail(err)
+				return
+			}
+			if ok {
+				hdrRead = true
+				pos += ln
+				f.mu.Lock()
+				f.hdr = hdr
+				f.offset += int64(ln)
+				f.mu.Unlock()
+			}
+		}
+
+		if hdrRead {
+			for {
+				if f.hdr.Len >= 0 && decoded == f.hdr.Len {
+					// beelog logs are finished by their EOL mark
+					eol := pending[pos:]
+					if len(eol) < 5 {
+						break
+					}
+					if string(eol[:5]) != "\nEOL\n" {
+						f.fail(fmt.Errorf("expected EOL flag, got '%s'", eol[1:4]))
+					}
+					return
+				}
+
+				c, ln, ok, err := f.decodeFrame(pending[pos:])
+				if err != nil {
+					f.fail(fmt.Errorf("%s at entry %d", err.Error(), decoded))
+					return
+				}
+				if !ok {
+					break
+				}
+
+				select {
+				case f.cmds <- c:
+				case <-ctx.Done():
+					return
+				}
+				pos += ln
+				decoded++
+
+				f.mu.Lock()
+				f.offset += int64(ln)
+				f.mu.Unlock()
+			}
+		}
+
+		// discard decoded content, retaining any partially written command
+		pending = append(pending[:0], pending[pos:]...)
+		pos = 0
+
+		// more content may already be available, otherwise wait for the writer
+		if n > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.poll):
+		}
+	}
+}
+
+// decodeFrame decodes the first length prefixed command of 'b', returning its length
+// and false if the command is still partially written.
+func (f *Follower) decodeFrame(b []byte) (pb.Command, int, bool, error) {
+	var c pb.Command
+	if len(b) < 4 {
+		return c, 0, false, nil
+	}
+	cmdLen := int32(binary.BigEndian.Uint32(b[:4]))
+	if err := checkCommandLen(cmdLen); err != nil {
+		return c, 0, false, err
+	}
+	if len(b) < 4+int(cmdLen) {
+		return c, 0, false, nil
+	}
+
+	if err := decodeCommand(f.hdr.Version, b[4:4+cmdLen], &c); err != nil {
+		return c, 0, false, err
+	}
+	return c, 4 + int(cmdLen), true, nil
+}
+
+func (f *Follower) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// parseFollowedHeader interprets the log header at the beginning of 'b', returning its
+// length and false if the header is still partially written. Versioned headers span a
+// line more than legacy ones, carrying the format magic and version.
+func parseFollowedHeader(b []byte) (LogHeader, int, bool, error) {
+	lines := 3
+	if len(b) > 0 && b[0] == logFormatMagic[0] {
+		lines++
+		if len(b) > len(logFormatMagic) && b[len(logFormatMagic)] == ProtoLogFormatVersion {
+			return LogHeader{}, 0, false, fmt.Errorf("log format version %d can not be followed", ProtoLogFormatVersion)
+		}
+	}
+
+	ln := 0
+	for i := 0; i < lines; i++ {
+		j := bytes.IndexByte(b[ln:], '\n')
+		if j < 0 {
+			return LogHeader{}, 0, false, nil
+		}
+		ln += j + 1
+	}
+
+	_, hdr, err := ReadLogHeader(bytes.NewReader(b[:ln]))
+	if err != nil {
+		return hdr, 0, false, err
+	}
+	return hdr, ln, true, nil
+}
@@ -0,0 +1,165 @@
+package beelog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// LogFormatVersion is the current version of the serialized log format, written
+	// after 'logFormatMagic' by every Marshal* procedure.
+	LogFormatVersion uint8 = 1
+
+	// LegacyLogFormatVersion identifies logs without magic and version, which start
+	// directly on the interval indexes.
+	LegacyLogFormatVersion uint8 = 0
+
+	// ProtoLogFormatVersion identifies logs written by MarshalProtoLogIntoWriter,
+	// where the version header is followed by varint length-delimited pb.LogSegment
+	// messages instead of the text header and big-endian command frames.
+	ProtoLogFormatVersion uint8 = 2
+
+	// FlatLogFormatVersion identifies logs written through FlatCodec, following the
+	// same header, framing and EOL mark of LogFormatVersion, but with commands on
+	// the FlatCommand layout.
+	FlatLogFormatVersion uint8 = 3
+
+	// latestLogFormatVersion is the latest version interpreted by log readers.
+	latestLogFormatVersion = FlatLogFormatVersion
+)
+
+// logFormatMagic prefixes every versioned log. Its first byte is never present on
+// legacy logs, which always start with a decimal index.
+var logFormatMagic = []byte("BEELOG")
+
+// LogHeader is the metadata preceding the serialized commands of a log: the first
+// and last indexes of the retrieved command interval, and the number of commands,
+// or -1 for traditional (non-reduced) logs.
+type LogHeader struct {
+	Version uint8
+	First   uint64
+	Last    uint64
+	Len     int
+}
+
+// writeLogHeader writes the current format version header into 'w', following the
+// format (single quotes (') chars not present):
+//   'BEELOG''version byte'\n
+//   'p index'\n
+//   'n index'\n
+//   'len' cmds\n
+func writeLogHeader(w io.Writer, p, n uint64, ln int) error {
+	_, err := w.Write(encodeLogHeader(p, n, ln))
+	return err
+}
+
+func encodeLogHeader(p, n uint64, ln int) []byte {
+	return encodeVersionedLogHeader(LogFormatVersion, p, n, ln)
+}
+
+// encodeVersionedLogHeader is analogous to 'encodeLogHeader', but writes 'version'
+// instead of the current format version.
+func encodeVersionedLogHeader(version uint8, p, n uint64, ln int) []byte {
+	buff := bytes.NewBuffer(nil)
+	buff.Write(logFormatMagic)
+	buff.WriteByte(version)
+	fmt.Fprintf(buff, "\n%d\n%d\n%d\n", p, n, ln)
+	return buff.Bytes()
+}
+
+// MaxCommandSize bounds the serialized size of each command accepted by log decoders,
+// so corrupted or hostile logs can not trigger huge allocations during recovery.
+const MaxCommandSize = 64 << 20
+
+// maxPreallocCommands bounds the capacity preallocated from the number of commands
+// recorded on a log header, which is untrusted until every command is decoded.
+const maxPreallocCommands = 1 << 16
+
+// checkCommandLen validates the length prefix 'l' of a serialized command.
+func checkCommandLen(l int32) error {
+	if l < 0 || l > MaxCommandSize {
+		return fmt.Errorf("invalid command length %d, must be within [0, %d]", l, MaxCommandSize)
+	}
+	return nil
+}
+
+// preallocLen returns the capacity preallocated for a log of 'ln' commands.
+func preallocLen(ln int) int {
+	if ln < 0 {
+		return 0
+	}
+	if ln > maxPreallocCommands {
+		return maxPreallocCommands
+	}
+	return ln
+}
+
+// ReadLogHeader interprets the header of both versioned and legacy headerless logs
+// from 'rd'. The returned reader must be utilized to read the subsequent commands,
+// since a single byte may be consumed from 'rd' to detect the log version. Logs on
+// ProtoLogFormatVersion carry no text header, and are only interpreted by
+// UnmarshalLogFromReader and UnmarshalProtoLog.
+func ReadLogHeader(rd io.Reader) (io.Reader, LogHeader, error) {
+	rd, hdr, err := readLogVersion(rd)
+	if err != nil {
+		return nil, hdr, err
+	}
+	if hdr.Version == ProtoLogFormatVersion {
+		return nil, hdr, errors.New("log format version 2 has no text header, consider using UnmarshalProtoLog")
+	}
+
+	if err := readLogInterval(rd, &hdr); err != nil {
+		return nil, hdr, err
+	}
+	return rd, hdr, nil
+}
+
+// readLogInterval reads the retrieved log interval and length from 'rd' into 'hdr'.
+func readLogInterval(rd io.Reader, hdr *LogHeader) error {
+	_, err := fmt.Fscanf(rd, "%d\n%d\n%d\n", &hdr.First, &hdr.Last, &hdr.Len)
+	return err
+}
+
+// readLogVersion interprets the magic and version of logs from 'rd', if any, returning
+// a header with only its Version set.
+func readLogVersion(rd io.Reader) (io.Reader, LogHeader, error) {
+	var hdr LogHeader
+	var b [1]byte
+	if _, err := io.ReadFull(rd, b[:]); err != nil {
+		return nil, hdr, err
+	}
+
+	if b[0] != logFormatMagic[0] {
+		// legacy format, push back the already read byte
+		if bs, ok := rd.(io.ByteScanner); ok {
+			if err := bs.UnreadByte(); err != nil {
+				return nil, hdr, err
+			}
+		} else {
+			rd = io.MultiReader(bytes.NewReader(b[:]), rd)
+		}
+		hdr.Version = LegacyLogFormatVersion
+
+	} else {
+		rest := make([]byte, len(logFormatMagic)+1)
+		if _, err := io.ReadFull(rd, rest); err != nil {
+			return nil, hdr, err
+		}
+
+		if !bytes.Equal(rest[:len(logFormatMagic)-1], logFormatMagic[1:]) {
+			return nil, hdr, fmt.Errorf("invalid log format magic '%s'", append(b[:], rest[:len(logFormatMagic)-1]...))
+		}
+
+		hdr.Version = rest[len(logFormatMagic)-1]
+		if hdr.Version > latestLogFormatVersion {
+			return nil, hdr, fmt.Errorf("unsupported log format version %d, latest known is %d", hdr.Version, latestLogFormatVersion)
+		}
+
+		if rest[len(logFormatMagic)] != '\n' {
+			return nil, hdr, fmt.Errorf("malformed header on log format version %d", hdr.Version)
+		}
+	}
+	return rd, hdr, nil
+}
@@ -0,0 +1,109 @@
+package beelog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// fuzzSeeds returns serialized logs on both beelog and traditional formats, along
+// with truncated and corrupted variants.
+func fuzzSeeds(f *testing.F) [][]byte {
+	log := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "0"},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "1", Expected: "0"},
+		{Id: 3, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_IntValue{IntValue: -7}},
+	}
+
+	bl, trad, proto, flat := bytes.NewBuffer(nil), bytes.NewBuffer(nil), bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(bl, &log, 1, 3); err != nil {
+		f.Fatal(err)
+	}
+	if err := MarshalTradLogIntoWriter(trad, &log, 1, 3); err != nil {
+		f.Fatal(err)
+	}
+	if err := MarshalProtoLogIntoWriter(proto, &log, 1, 3); err != nil {
+		f.Fatal(err)
+	}
+	if err := MarshalLogWithCodec(flat, FlatCodec, &log, 1, 3); err != nil {
+		f.Fatal(err)
+	}
+
+	seeds := [][]byte{bl.Bytes(), trad.Bytes(), proto.Bytes(), proto.Bytes()[:proto.Len()/2], nil}
+	for _, s := range [][]byte{bl.Bytes(), trad.Bytes(), flat.Bytes()} {
+		seeds = append(seeds, s[:len(s)/2])
+
+		// huge command length prefix
+		hostile := append([]byte{}, s...)
+		copy(hostile[bytes.LastIndexByte(s[:len(s)/2], '\n')+1:], []byte{0x7f, 0xff, 0xff, 0xff})
+		seeds = append(seeds, hostile)
+	}
+	return seeds
+}
+
+func FuzzUnmarshalLogFromReader(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		UnmarshalLogFromReader(bytes.NewReader(data))
+	})
+}
+
+func FuzzUnmarshalLogFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		UnmarshalLogFromBytes(data)
+	})
+}
+
+func FuzzUnmarshalBeelog(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s, 3)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, ln int) {
+		unmarshalBeelog(bytes.NewReader(data), LogFormatVersion, ln)
+	})
+}
+
+func FuzzUnmarshalTradLog(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		unmarshalTradLog(bytes.NewReader(data), LogFormatVersion)
+	})
+}
+
+func FuzzUnmarshalProtoLog(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		UnmarshalProtoLog(bytes.NewReader(data))
+	})
+}
+
+func FuzzIterFlatLog(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		IterFlatLog(data, func(fc FlatCommand) error {
+			fc.Decode(&pb.Command{})
+			return nil
+		})
+	})
+}
+
+func FuzzLogDecoder(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		UnmarshalLogInto(bytes.NewReader(data), nil)
+	})
+}
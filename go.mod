@@ -1,8 +1,10 @@
 module github.com/Lz-Gustavo/beelog
 
-go 1.14
+go 1.18
 
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/golang/protobuf v1.4.2
+	google.golang.org/protobuf v1.23.0
+	gopkg.in/yaml.v2 v2.4.0
 )
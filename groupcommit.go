@@ -0,0 +1,88 @@
+package beelog
+
+import (
+	"sync"
+	"time"
+)
+
+// groupCommitter batches the fsync of log files persisted within a 'window' of time
+// on Sync configs, issuing a single Fsync per file for the entire batch. Files that
+// are overwritten within the same window (e.g. Immediately reduces without KeepAll)
+// are synced only once, since fsync applies to the underlying file regardless of the
+// descriptor used.
+type groupCommitter struct {
+	mu     sync.Mutex
+	window time.Duration
+	batch  *commitBatch // nil while no file is pending
+}
+
+// commitBatch holds the files committed within the same window, and the outcome of
+// their fsync, informed to every committer once 'done' is closed.
+type commitBatch struct {
+	pending map[string]Segment
+	done    chan struct{}
+	err     error
+}
+
+func newGroupCommitter(window time.Duration) *groupCommitter {
+	return &groupCommitter{window: window}
+}
+
+// commit registers 'seg', already written with the latest state of 'fn', to be synced
+// and closed on the next batch. Blocks until that batch is flushed, returning the first
+// error observed during it.
+func (gc *groupCommitter) commit(fn string, seg Segment) error {
+	gc.mu.Lock()
+	b := gc.batch
+	if b == nil {
+		b = &commitBatch{
+			pending: make(map[string]Segment),
+			done:    make(chan struct{}),
+		}
+		gc.batch = b
+		time.AfterFunc(gc.window, func() {
+			gc.flush()
+		})
+	}
+
+	// a newer write supersedes the pending descriptor of the same file, but a failed
+	// close could hide a write-back error, reported to the entire batch
+	if old, ok := b.pending[fn]; ok {
+		if err := old.Close(); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+	b.pending[fn] = seg
+	gc.mu.Unlock()
+
+	<-b.done
+	return b.err
+}
+
+// flush syncs and closes every pending file, releasing their committers. Returns the
+// first error observed.
+func (gc *groupCommitter) flush() error {
+	gc.mu.Lock()
+	b := gc.batch
+	gc.batch = nil
+	gc.mu.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	for _, seg := range b.pending {
+		if err := seg.Sync(); err != nil && b.err == nil {
+			b.err = err
+		}
+		if err := seg.Close(); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+	close(b.done)
+	return b.err
+}
+
+// close synchronously flushes every pending file, returning the first error observed.
+func (gc *groupCommitter) close() error {
+	return gc.flush()
+}
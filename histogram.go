@@ -0,0 +1,144 @@
+package beelog
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// every power-of-two range of values is split into 2^histSubBits linear buckets,
+	// bounding the relative error of recorded values to 1/2^histSubBits.
+	histSubBits   = 7
+	histSubCount  = 1 << histSubBits
+	histBucketLen = (64 - histSubBits) * histSubCount
+)
+
+// Histogram is a log-linear histogram of non-negative int64 values, in the likes of
+// HDR histograms. Memory is constant regardless of the number of recorded values,
+// and recorded values are retrieved with a relative error below 1%. The zero value
+// is an empty histogram ready to use. A Histogram is not safe for concurrent use.
+type Histogram struct {
+	counts     [histBucketLen]uint64
+	count      uint64
+	sum        float64
+	min, max   int64
+	hasRecords bool
+}
+
+// histIndex returns the bucket index of 'v'.
+func histIndex(v int64) int {
+	u := uint64(v)
+	if u < histSubCount {
+		return int(u)
+	}
+	shift := bits.Len64(u) - 1 - histSubBits
+	return (shift+1)*histSubCount + int(u>>uint(shift)) - histSubCount
+}
+
+// histValue returns the midpoint of values recorded on bucket 'idx'.
+func histValue(idx int) int64 {
+	if idx < histSubCount {
+		return int64(idx)
+	}
+	shift := uint(idx/histSubCount - 1)
+	low := uint64(idx%histSubCount+histSubCount) << shift
+	return int64(low + (uint64(1)<<shift)/2)
+}
+
+// Record adds 'v' to the histogram. Negative values are recorded as zero.
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.counts[histIndex(v)]++
+	h.count++
+	h.sum += float64(v)
+
+	if !h.hasRecords || v < h.min {
+		h.min = v
+	}
+	if !h.hasRecords || v > h.max {
+		h.max = v
+	}
+	h.hasRecords = true
+}
+
+// Merge adds every value recorded on 'o' to the histogram.
+func (h *Histogram) Merge(o *Histogram) {
+	if !o.hasRecords {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	h.sum += o.sum
+
+	if !h.hasRecords || o.min < h.min {
+		h.min = o.min
+	}
+	if !h.hasRecords || o.max > h.max {
+		h.max = o.max
+	}
+	h.hasRecords = true
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns the minimum recorded value, or zero if none.
+func (h *Histogram) Min() int64 {
+	return h.min
+}
+
+// Max returns the maximum recorded value, or zero if none.
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the arithmetic mean of recorded values, or zero if none.
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// Quantile returns an approximation of the 'q' quantile of recorded values, with
+// 'q' in [0, 1]. Returns zero if no value was recorded.
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var acc uint64
+	for i, c := range h.counts {
+		acc += c
+		if acc >= rank {
+			v := histValue(i)
+			// bucket midpoints may fall outside the recorded range
+			if v < h.min {
+				return h.min
+			}
+			if v > h.max {
+				return h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Reset discards every recorded value.
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
@@ -0,0 +1,86 @@
+package beelog
+
+import (
+	"sort"
+	"sync"
+)
+
+// holeSet tracks the indexes missing within the interval logged by a structure, as
+// disjoint intervals on ascending order.
+type holeSet struct {
+	mu    sync.Mutex
+	holes []IndexInterval
+}
+
+// Holes returns every interval of indexes never logged within the interval logged by
+// the structure (see LoggedInterval), on ascending order. Reads discarded on
+// IgnoreReads configs are not reported, neither are indexes covered by a restored
+// state. A consensus layer may verify that no entry was skipped within an interval
+// before trusting its reduced state.
+func (ld *logData) Holes() []IndexInterval {
+	return ld.stats.gaps.list()
+}
+
+// add records the missing interval [p, n], which must not overlap known ones.
+func (hs *holeSet) add(p, n uint64) {
+	i := sort.Search(len(hs.holes), func(i int) bool { return hs.holes[i].First > n })
+	hs.holes = append(hs.holes, IndexInterval{})
+	copy(hs.holes[i+1:], hs.holes[i:])
+	hs.holes[i] = IndexInterval{First: p, Last: n}
+}
+
+// fill removes every index within [p, n] from known holes, splitting partially
+// covered ones.
+func (hs *holeSet) fill(p, n uint64) {
+	i := sort.Search(len(hs.holes), func(i int) bool { return hs.holes[i].Last >= p })
+	if i == len(hs.holes) || hs.holes[i].First > n {
+		return
+	}
+
+	var kept []IndexInterval
+	j := i
+	for ; j < len(hs.holes) && hs.holes[j].First <= n; j++ {
+		h := hs.holes[j]
+		if h.First < p {
+			kept = append(kept, IndexInterval{First: h.First, Last: p - 1})
+		}
+		if h.Last > n {
+			kept = append(kept, IndexInterval{First: n + 1, Last: h.Last})
+		}
+	}
+	rest := append(kept, hs.holes[j:]...)
+	hs.holes = append(hs.holes[:i], rest...)
+}
+
+func (hs *holeSet) list() []IndexInterval {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.holes) == 0 {
+		return nil
+	}
+	return append([]IndexInterval(nil), hs.holes...)
+}
+
+// holesWithin returns the intervals of [in.First, in.Last] not covered by any of the
+// 'covered' ones, which may overlap.
+func holesWithin(in IndexInterval, covered []IndexInterval) []IndexInterval {
+	sort.Slice(covered, func(i, j int) bool { return covered[i].First < covered[j].First })
+
+	var holes []IndexInterval
+	next := in.First
+	for _, c := range covered {
+		if c.First > next {
+			holes = append(holes, IndexInterval{First: next, Last: c.First - 1})
+		}
+		if c.Last >= next {
+			if c.Last == in.Last {
+				return holes
+			}
+			next = c.Last + 1
+		}
+	}
+	if next <= in.Last {
+		holes = append(holes, IndexInterval{First: next, Last: in.Last})
+	}
+	return holes
+}
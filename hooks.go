@@ -0,0 +1,102 @@
+package beelog
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ReduceStats summarizes a completed reduce procedure, informed to 'Hooks.OnReduceDone'.
+type ReduceStats struct {
+	// First and Last are the indexes of the reduced interval
+	First, Last uint64
+
+	// number of commands retained after reduce
+	Commands int
+
+	// elapsed time executing the reduce algorithm, excluding persistence
+	Duration time.Duration
+
+	// error returned by the reduce algorithm, if any
+	Err error
+}
+
+// Hooks are user callbacks invoked on log lifecycle events by every structure, allowing
+// the integration of application specific metrics, backpressure and replication triggers.
+// Callbacks are invoked synchronously, possibly from background reduce routines and
+// within mutual exclusion scope, so they must return promptly and must not call the
+// structure back. Any nil callback is ignored.
+type Hooks struct {
+	// OnReduceStart is invoked before reducing the [p, n] interval.
+	OnReduceStart func(p, n uint64)
+
+	// OnReduceDone is invoked once a reduce procedure is finished, before its
+	// result is persisted.
+	OnReduceDone func(stats ReduceStats)
+
+	// OnPersist is invoked after a reduced log with 'bytes' length is written
+	// into 'file', and synced on Sync configs. Not invoked on in-memory configs.
+	OnPersist func(file string, bytes int64)
+
+	// OnRecovery is invoked once a recovery over the [p, n] interval is requested.
+	OnRecovery func(p, n uint64)
+
+	// OnEvict is invoked after a segment with 'bytes' length is removed from 'file'
+	// to comply with config.MaxDiskBytes.
+	OnEvict func(file string, bytes int64)
+}
+
+func (ld *logData) hookReduceStart(p, n uint64) time.Time {
+	if h := ld.config.Hooks; h != nil && h.OnReduceStart != nil {
+		h.OnReduceStart(p, n)
+	}
+	return time.Now()
+}
+
+func (ld *logData) hookReduceDone(p, n uint64, start time.Time, cmds int, err error) {
+	atomic.StoreInt64(&ld.stats.lastReduce, int64(time.Since(start)))
+	if err == nil && n >= p {
+		atomic.StoreUint64(&ld.stats.reduceIn, n-p+1)
+		atomic.StoreUint64(&ld.stats.reduceOut, uint64(cmds))
+	}
+	if h := ld.config.Hooks; h != nil && h.OnReduceDone != nil {
+		h.OnReduceDone(ReduceStats{
+			First:    p,
+			Last:     n,
+			Commands: cmds,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+}
+
+func (ld *logData) hookPersist(fn string, bytes int64) {
+	atomic.AddUint64(&ld.stats.persisted, uint64(bytes))
+	if h := ld.config.Hooks; h != nil && h.OnPersist != nil {
+		h.OnPersist(fn, bytes)
+	}
+}
+
+func (ld *logData) hookRecovery(p, n uint64) {
+	if h := ld.config.Hooks; h != nil && h.OnRecovery != nil {
+		h.OnRecovery(p, n)
+	}
+}
+
+func (ld *logData) hookEvict(fn string, bytes int64) {
+	if h := ld.config.Hooks; h != nil && h.OnEvict != nil {
+		h.OnEvict(fn, bytes)
+	}
+}
+
+// countingWriter counts the number of bytes written into 'w'.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,260 @@
+package beelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentInterval is the consensus interval [First, Last] covered by a persisted
+// segment, the disk it was persisted on, and the crc32 (Castagnoli) checksum of its
+// serialized content, if known. Disks index the configured paths (see
+// 'LogConfig.diskFnames'), where 0 is always the primary config.Fname.
+type segmentInterval struct {
+	Name        string
+	First, Last uint64
+	Disk        int
+	Checksum    uint32
+	HasChecksum bool
+}
+
+// manifestRecord is the JSON encoding of a segmentInterval on the manifest file. The
+// disk is omitted for segments on the primary one, matching records written before it
+// was recorded.
+type manifestRecord struct {
+	Name     string  `json:"name"`
+	First    uint64  `json:"first"`
+	Last     uint64  `json:"last"`
+	Disk     int     `json:"disk,omitempty"`
+	Checksum *uint32 `json:"crc32c,omitempty"`
+}
+
+// intervalIndex is the manifest of segments persisted on KeepAll configs, tracking
+// the interval, disk and checksum of each one. Segments striped across disks (i.e.
+// ParallelIO or Fnames on RoundRobin mode) are recorded on the single manifest of the
+// primary disk, while mirrored copies are not recorded. It allows recoveries to open only the
+// segments overlapping a requested interval, ordered by their intervals, instead of
+// listing and sorting every segment name. The manifest is stored alongside segments
+// (see 'indexFname'), where each new segment appends a single JSON record, and
+// it's lazily loaded on first use. Legacy 'first last name' records are still
+// interpreted.
+type intervalIndex struct {
+	mu     sync.Mutex
+	fname  string
+	loaded bool
+	segs   []segmentInterval // sorted by first index, then by name
+}
+
+func newIntervalIndex(fname string) *intervalIndex {
+	return &intervalIndex{fname: fname}
+}
+
+// indexFname returns the name of the interval index of segments derived from 'fn',
+// replacing its extension (e.g. './logstate.log' is mapped into './logstate.idx').
+func indexFname(fn string) string {
+	sep := strings.SplitAfter(fn, ".")
+	if len(sep) == 1 {
+		return fn + ".idx"
+	}
+	sep[len(sep)-1] = "idx"
+	return strings.Join(sep, "")
+}
+
+// add records 'seg' on the index, appending it to the index file on 'st'. A segment
+// rewritten under the same name replaces its prior interval.
+func (ix *intervalIndex) add(st LogStorage, seg segmentInterval) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.mayLoad(st); err != nil {
+		return err
+	}
+
+	fd, err := st.Append(ix.fname)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err = fd.Write(encodeManifestRecord(seg)); err != nil {
+		return err
+	}
+	ix.insert(seg)
+	return nil
+}
+
+// encodeManifestRecord returns the manifest record of 'seg', terminated by a newline.
+func encodeManifestRecord(seg segmentInterval) []byte {
+	rec := manifestRecord{Name: seg.Name, First: seg.First, Last: seg.Last, Disk: seg.Disk}
+	if seg.HasChecksum {
+		sum := seg.Checksum
+		rec.Checksum = &sum
+	}
+	// a struct of plain fields never fails to encode
+	raw, _ := json.Marshal(rec)
+	return append(raw, '\n')
+}
+
+// remove discards the records of every segment in 'names', rewriting the index file
+// on 'st' with the remaining records.
+func (ix *intervalIndex) remove(st LogStorage, names []string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.mayLoad(st); err != nil {
+		return err
+	}
+
+	rm := make(map[string]struct{}, len(names))
+	for _, fn := range names {
+		rm[fn] = struct{}{}
+	}
+	segs := make([]segmentInterval, 0, len(ix.segs))
+	for _, s := range ix.segs {
+		if _, ok := rm[s.Name]; !ok {
+			segs = append(segs, s)
+		}
+	}
+
+	fd, err := st.Create(ix.fname)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	wr := bufio.NewWriter(fd)
+	for _, s := range segs {
+		if _, err = wr.Write(encodeManifestRecord(s)); err != nil {
+			return err
+		}
+	}
+	if err = wr.Flush(); err != nil {
+		return err
+	}
+	ix.segs = segs
+	return nil
+}
+
+// overlapping returns every indexed segment whose interval overlaps [p, n], ordered
+// by their first index.
+func (ix *intervalIndex) overlapping(st LogStorage, p, n uint64) ([]segmentInterval, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.mayLoad(st); err != nil {
+		return nil, err
+	}
+
+	// segments starting after 'n' can be skipped through a binary search, but any
+	// prior one may still cover 'p'
+	end := sort.Search(len(ix.segs), func(i int) bool { return ix.segs[i].First > n })
+
+	segs := []segmentInterval{}
+	for _, s := range ix.segs[:end] {
+		if s.Last >= p {
+			segs = append(segs, s)
+		}
+	}
+	return segs, nil
+}
+
+// count returns the number of indexed segments.
+func (ix *intervalIndex) count(st LogStorage) (int, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.mayLoad(st); err != nil {
+		return 0, err
+	}
+	return len(ix.segs), nil
+}
+
+// mayLoad reads every record of the index file from 'st', if not yet loaded. A missing
+// index file is interpreted as an empty index.
+func (ix *intervalIndex) mayLoad(st LogStorage) error {
+	if ix.loaded {
+		return nil
+	}
+	if _, err := st.Size(ix.fname); err != nil {
+		ix.loaded = true
+		return nil
+	}
+
+	rd, err := st.ReadAt(ix.fname)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	sc := bufio.NewScanner(rd)
+	for ln := 1; sc.Scan(); ln++ {
+		seg, err := parseSegmentInterval(sc.Text())
+		if err != nil {
+			return fmt.Errorf("invalid record %d on interval index '%s', err: '%s'", ln, ix.fname, err.Error())
+		}
+		ix.insert(seg)
+	}
+	if err = sc.Err(); err != nil {
+		return err
+	}
+	ix.loaded = true
+	return nil
+}
+
+func (ix *intervalIndex) insert(seg segmentInterval) {
+	for i, s := range ix.segs {
+		if s.Name == seg.Name {
+			ix.segs = append(ix.segs[:i], ix.segs[i+1:]...)
+			break
+		}
+	}
+
+	i := sort.Search(len(ix.segs), func(i int) bool {
+		s := ix.segs[i]
+		return s.First > seg.First || (s.First == seg.First && s.Name > seg.Name)
+	})
+	ix.segs = append(ix.segs, segmentInterval{})
+	copy(ix.segs[i+1:], ix.segs[i:])
+	ix.segs[i] = seg
+}
+
+// parseSegmentInterval interprets a single manifest record, either a JSON object or a
+// legacy record formatted as 'first last name'.
+func parseSegmentInterval(rec string) (segmentInterval, error) {
+	if strings.HasPrefix(rec, "{") {
+		var mr manifestRecord
+		if err := json.Unmarshal([]byte(rec), &mr); err != nil {
+			return segmentInterval{}, err
+		}
+		if mr.Name == "" {
+			return segmentInterval{}, fmt.Errorf("missing segment name on '%s'", rec)
+		}
+		if mr.Disk < 0 {
+			return segmentInterval{}, fmt.Errorf("invalid disk %d on '%s'", mr.Disk, rec)
+		}
+		seg := segmentInterval{Name: mr.Name, First: mr.First, Last: mr.Last, Disk: mr.Disk}
+		if mr.Checksum != nil {
+			seg.Checksum, seg.HasChecksum = *mr.Checksum, true
+		}
+		return seg, nil
+	}
+
+	fs := strings.SplitN(rec, " ", 3)
+	if len(fs) != 3 || fs[2] == "" {
+		return segmentInterval{}, fmt.Errorf("expected 'first last name', got '%s'", rec)
+	}
+
+	first, err := strconv.ParseUint(fs[0], 10, 64)
+	if err != nil {
+		return segmentInterval{}, err
+	}
+	last, err := strconv.ParseUint(fs[1], 10, 64)
+	if err != nil {
+		return segmentInterval{}, err
+	}
+	return segmentInterval{Name: fs[2], First: first, Last: last}, nil
+}
@@ -0,0 +1,293 @@
+package beelog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// journal is an append-only write-ahead log of the commands recorded on a structure,
+// retaining them until persisted by a reduce on Journal configs. Commands are appended
+// following the traditional log format, so journals can be inspected as any other
+// non-reduced log.
+type journal struct {
+	mu     sync.Mutex
+	st     LogStorage
+	fn     string
+	seg    Segment
+	sync   bool
+	paused bool // set while replaying commands of a prior execution
+
+	// number and interval of currently journaled commands
+	len         int
+	first, last uint64
+}
+
+// journalFname returns the journal of a structure persisted at 'fn', replacing its
+// extension by ".wal" (e.g. "logstate.wal").
+func journalFname(fn string) string {
+	return strings.TrimSuffix(fn, filepath.Ext(fn)) + ".wal"
+}
+
+// openJournal opens the journal 'fn' on 'st', returning the commands journaled by a
+// prior execution on index order. A partially written command at its tail, left by a
+// crash during append, is discarded. The journal starts paused.
+func openJournal(st LogStorage, fn string, sync bool) (*journal, []pb.Command, error) {
+	cmds, err := readJournal(st, fn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed reading journal '%s', err: '%s'", fn, err.Error())
+	}
+
+	j := &journal{st: st, fn: fn, sync: sync, paused: true}
+	if err := j.rewrite(cmds); err != nil {
+		return nil, nil, err
+	}
+	return j, cmds, nil
+}
+
+// readJournal returns every command recorded on journal 'fn', or none if it doesnt
+// exist yet.
+func readJournal(st LogStorage, fn string) ([]pb.Command, error) {
+	if _, err := st.Size(fn); err != nil {
+		return nil, nil
+	}
+	seg, err := st.ReadAt(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer seg.Close()
+
+	rd, hdr, err := ReadLogHeader(bufio.NewReader(newSegmentCursor(seg)))
+	if err == io.EOF {
+		// crashed before the header was written
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if hdr.Len != -1 {
+		return nil, fmt.Errorf("expected a traditional log, got a reduced one with %d commands", hdr.Len)
+	}
+
+	cmds := make([]pb.Command, 0)
+	for {
+		var cmdLen int32
+		err := binary.Read(rd, binary.BigEndian, &cmdLen)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		raw := make([]byte, cmdLen)
+		if _, err = io.ReadFull(rd, raw); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		c := &pb.Command{}
+		if err = proto.Unmarshal(raw, c); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, *c)
+	}
+
+	// commands are appended concurrently by ConcTable views
+	sort.SliceStable(cmds, func(i, k int) bool {
+		return cmds[i].Id < cmds[k].Id
+	})
+	return cmds, nil
+}
+
+// append records 'cmd' at the end of the journal, syncing it on Sync configs.
+func (j *journal) append(cmd pb.Command) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.paused {
+		return nil
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := marshalCommandsIntoWriter(buff, &[]pb.Command{cmd}); err != nil {
+		return err
+	}
+	if _, err := buff.WriteTo(j.seg); err != nil {
+		return err
+	}
+	if j.sync {
+		if err := j.seg.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if j.len == 0 || cmd.Id < j.first {
+		j.first = cmd.Id
+	}
+	if cmd.Id > j.last {
+		j.last = cmd.Id
+	}
+	j.len++
+	return nil
+}
+
+// truncate discards every journaled command indexed within [p, n], already persisted
+// by a reduce. The journal is emptied if no other command is retained, otherwise it's
+// rewritten with the retained ones.
+func (j *journal) truncate(p, n uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.paused || j.len == 0 || n < j.first || p > j.last {
+		return nil
+	}
+	if p <= j.first && j.last <= n {
+		return j.rewrite(nil)
+	}
+
+	cmds, err := readJournal(j.st, j.fn)
+	if err != nil {
+		return err
+	}
+	retained := cmds[:0]
+	for _, c := range cmds {
+		if c.Id < p || c.Id > n {
+			retained = append(retained, c)
+		}
+	}
+	return j.rewrite(retained)
+}
+
+// rewrite replaces the content of the journal by 'cmds', opening it for later appends.
+// Must be called within mutual exclusion scope.
+func (j *journal) rewrite(cmds []pb.Command) error {
+	if j.seg != nil {
+		j.seg.Close()
+		j.seg = nil
+	}
+	seg, err := j.st.Create(j.fn)
+	if err != nil {
+		return err
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err = MarshalTradLogIntoWriter(buff, &cmds, 0, 0); err != nil {
+		seg.Close()
+		return err
+	}
+	if _, err = buff.WriteTo(seg); err != nil {
+		seg.Close()
+		return err
+	}
+	if j.sync {
+		if err = seg.Sync(); err != nil {
+			seg.Close()
+			return err
+		}
+	}
+
+	j.seg, j.len, j.first, j.last = seg, len(cmds), 0, 0
+	for i, c := range cmds {
+		if i == 0 || c.Id < j.first {
+			j.first = c.Id
+		}
+		if c.Id > j.last {
+			j.last = c.Id
+		}
+	}
+	return nil
+}
+
+// resume starts journaling appended commands, once those of a prior execution are
+// replayed.
+func (j *journal) resume() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.paused = false
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.seg == nil {
+		return nil
+	}
+	err := j.seg.Close()
+	j.seg = nil
+	return err
+}
+
+// openJournal opens the journal of the structure on Journal configs, returning the
+// commands journaled by a prior execution, later replayed by 'replayJournal'.
+func (ld *logData) openJournal() ([]pb.Command, error) {
+	if !ld.config.Journal {
+		return nil, nil
+	}
+	wal, cmds, err := openJournal(ld.storage(), journalFname(ld.config.Fname), ld.config.Sync)
+	if err != nil {
+		return nil, err
+	}
+	ld.wal = wal
+	return cmds, nil
+}
+
+// replayJournal records every command of 'cmds' through 'log' (i.e. the structure
+// LogCtx procedure), without journaling them again, then resumes journaling. Reduces
+// triggered by replayed commands retain them on the journal until the next reduce.
+func (ld *logData) replayJournal(cmds []pb.Command, log func(context.Context, pb.Command) error) error {
+	if ld.wal == nil {
+		return nil
+	}
+	for _, c := range cmds {
+		if err := log(context.Background(), c); err != nil {
+			return fmt.Errorf("failed replaying journaled command %d, err: '%s'", c.Id, err.Error())
+		}
+	}
+	ld.wal.resume()
+	return nil
+}
+
+// restoreJournal opens and replays the journal of the structure through 'log', on
+// Journal configs.
+func (ld *logData) restoreJournal(log func(context.Context, pb.Command) error) error {
+	cmds, err := ld.openJournal()
+	if err != nil {
+		return err
+	}
+	return ld.replayJournal(cmds, log)
+}
+
+// journalCommand records 'cmd' on the journal, if any, before it's logged on the
+// structure. Read commands are never journaled, since they dont modify any state. Must
+// be called within mutual exclusion scope.
+func (ld *logData) journalCommand(cmd pb.Command) error {
+	if ld.wal == nil || !(isWriteOp(cmd.Op) || isRangeDelete(cmd.Op)) {
+		return nil
+	}
+	return ld.wal.append(cmd)
+}
+
+// truncateJournal discards journaled commands within [p, n] once persisted, if any.
+func (ld *logData) truncateJournal(p, n uint64) error {
+	if ld.wal == nil {
+		return nil
+	}
+	return ld.wal.truncate(p, n)
+}
+
+// closeJournal closes the journal, if any, retaining its content to be replayed on
+// the next execution.
+func (ld *logData) closeJournal() {
+	if ld.wal != nil {
+		ld.wal.close()
+	}
+}
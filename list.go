@@ -1,8 +1,9 @@
 package beelog
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 
@@ -17,20 +18,27 @@ type listEntry struct {
 
 // ListHT ...
 type ListHT struct {
-	lt  *list
-	aux *stateTable
-	mu  sync.RWMutex
+	lt   *list
+	aux  *stateTable
+	mu   sync.RWMutex
+	canc context.CancelFunc
 	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
 }
 
 // NewListHT ...
 func NewListHT() *ListHT {
 	ht := make(stateTable, 0)
-	return &ListHT{
-		logData: logData{config: DefaultLogConfig()},
+	l := &ListHT{
+		logData: newLogData(DefaultLogConfig()),
 		lt:      &list{},
 		aux:     &ht,
 	}
+	l.ctx, l.canc = context.WithCancel(context.Background())
+	return l
 }
 
 // NewListHTWithConfig ...
@@ -41,11 +49,29 @@ func NewListHTWithConfig(cfg *LogConfig) (*ListHT, error) {
 	}
 
 	ht := make(stateTable, 0)
-	return &ListHT{
-		logData: logData{config: cfg},
+	l := &ListHT{
+		logData: newLogData(cfg),
 		lt:      &list{},
 		aux:     &ht,
-	}, nil
+	}
+
+	if err := l.restoreOnInit(l.logCtx); err != nil {
+		return nil, err
+	}
+
+	if err := l.initMeasure("list"); err != nil {
+		return nil, err
+	}
+
+	if err := l.restoreJournal(l.LogCtx); err != nil {
+		return nil, err
+	}
+
+	l.ctx, l.canc = context.WithCancel(context.Background())
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(l.ctx, cfg, &l.tick, l.reduceOnTick)
+	}
+	return l, nil
 }
 
 // Str returns a string representation of the list state, used for debug purposes.
@@ -62,20 +88,93 @@ func (l *ListHT) Str() string {
 
 // Len returns the list length.
 func (l *ListHT) Len() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.length()
+}
+
+func (l *ListHT) length() uint64 {
 	return l.lt.len
 }
 
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (l *ListHT) FirstIndex() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (l *ListHT) LastIndex() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (l *ListHT) Debug() DebugInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.debugInfo("list", l.lt.len)
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (l *ListHT) Stats() (Stats, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.stateTableStats(*l.aux, listEntryBytes+listNodeBytes)
+}
+
 // Log records the occurence of command 'cmd' on the provided index. Writes are
 // mapped as a new node on the underlying liked list, with a pointer to the newly
 // inserted state update on the update list for its particular key.
 func (l *ListHT) Log(cmd pb.Command) error {
+	return l.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (l *ListHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return l.seq.sequence(ctx, cmd, l.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (l *ListHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.ignoresRead(&cmd) {
+		return nil
+	}
+	l.measureBegin()
+	l.countLogged(&cmd)
+	l.recordPinned(&cmd)
+
+	if err := l.journalCommand(cmd); err != nil {
+		return err
+	}
+
+	if isRangeDelete(cmd.Op) {
+		// matching states are only discarded during reduce
+		l.tombs.add(cmd)
+		l.last = cmd.Id
+		l.measureLogged()
+		if l.config.Tick == Immediately {
+			return l.reduceLogCtx(ctx, l.first, l.last)
+		}
+		return l.mayTriggerReduce(ctx)
+	}
 
-	if cmd.Op != pb.Command_SET {
-		// TODO: treat 'l.first' attribution on GETs
+	if !isWriteOp(cmd.Op) {
+		l.attributeFirst(cmd.Id)
 		l.last = cmd.Id
-		return l.mayTriggerReduce()
+		l.measureLogged()
+		return l.mayTriggerReduce(ctx)
 	}
 
 	entry := &listEntry{
@@ -99,39 +198,79 @@ func (l *ListHT) Log(cmd pb.Command) error {
 	entry.ptr = lNode
 
 	// adjust first structure index
-	if l.lt.tail == nil {
-		l.first = entry.ind
-	}
+	l.attributeFirst(entry.ind)
 
 	// insert new entry on the main list
 	l.lt.push(entry)
 
 	// adjust last index once inserted
 	l.last = cmd.Id
+	l.measureLogged()
 
 	// immediately recovery entirely reduces the log to its minimal format
 	if l.config.Tick == Immediately {
-		return l.ReduceLog(l.first, l.last)
+		return l.reduceLogCtx(ctx, l.first, l.last)
 	}
-	return l.mayTriggerReduce()
+	return l.mayTriggerReduce(ctx)
 }
 
 // Recov returns a compacted log of commands, following the requested [p, n]
-// interval if 'Delayed' reduce is configured. On different period configurations,
-// the entire reduced log is always returned. On persistent configuration (i.e.
+// interval. 'Delayed' configs reduce the requested interval, while on different
+// period configurations the most recently reduced states are trimmed to [p, n],
+// merging every segment overlapping it on KeepAll configs. On persistent configuration (i.e.
 // 'inmem' false) the entire log is loaded and then unmarshaled, consider using
 // 'RecovBytes' calls instead.
 func (l *ListHT) Recov(p, n uint64) ([]pb.Command, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	return l.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (l *ListHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	sd, err := l.recovCtx(ctx, p, n, false)
+	return sd.Snapshot.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log.
+func (l *ListHT) RecovWithResult(p, n uint64) (RecovResult, error) {
+	sd, err := l.recovCtx(context.Background(), p, n, false)
+	return sd.Snapshot, err
+}
+
+// RecovSnapshotAndDelta is analogous to 'Recov', but also returns every write logged
+// within [p, n] after the recovered reduced state, not reduced.
+func (l *ListHT) RecovSnapshotAndDelta(p, n uint64) (SnapshotDelta, error) {
+	return l.recovCtx(context.Background(), p, n, true)
+}
+
+// recovCtx recovers the reduced state of [p, n], along with the commands logged after
+// it if 'delta' is set.
+func (l *ListHT) recovCtx(ctx context.Context, p, n uint64, delta bool) (SnapshotDelta, error) {
+	p, n, err := l.checkInterval(p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return SnapshotDelta{}, err
 	}
+	l.hookRecovery(p, n)
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	if err := l.mayExecuteLazyReduce(p, n); err != nil {
-		return nil, err
+	if err := l.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return SnapshotDelta{}, err
+	}
+	cmds, err := l.retrieveIntervalLogCtx(ctx, p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
 	}
-	return l.retrieveLog()
+	res := l.coverage(p, n, true)
+	res.Cmds = cmds
+	if !delta {
+		return SnapshotDelta{Snapshot: res}, nil
+	}
+	return l.snapshotAndDelta(res, p, n, l.deltaLog), nil
 }
 
 // RecovBytes returns an already serialized log, parsed from persistent storage
@@ -140,55 +279,190 @@ func (l *ListHT) Recov(p, n uint64) ([]pb.Command, error) {
 // interpretation from the byte stream follows a simple slicing protocol, where
 // the size of each command is binary encoded before the raw pbuff.
 func (l *ListHT) RecovBytes(p, n uint64) ([]byte, error) {
-	if n < p {
-		return nil, errors.New("invalid interval request, 'n' must be >= 'p'")
+	res, err := l.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log.
+func (l *ListHT) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
+	p, n, err := l.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
 	}
+	l.hookRecovery(p, n)
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	if err := l.mayExecuteLazyReduce(p, n); err != nil {
+	if err := l.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return RecovResult{}, err
+	}
+	raw, err := l.retrieveRawLog(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := l.coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (l *ListHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := l.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	l.hookRecovery(p, n)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if err := l.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return l.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (l *ListHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := l.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (l *ListHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := l.RecovFiltered(p, n, f)
+	if err != nil {
 		return nil, err
 	}
-	return l.retrieveRawLog(p, n)
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (l *ListHT) RecovSince(id uint64) ([]pb.Command, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.length() == 0 || id >= l.last {
+		return []pb.Command{}, nil
+	}
+
+	// the structure retains every logged state, even after reduce, so only the
+	// interval after 'id' is reduced, starting from the lower bound of 'id' + 1
+	// since 'id' may not be present on the structure
+	return l.applyRangeDeletes(GreedyListHT(l, id+1, l.last), id+1, l.last), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (l *ListHT) Snapshot() (*Snapshot, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.length() == 0 {
+		return newSnapshot(l.last, nil), nil
+	}
+	cmds := l.applyRangeDeletes(GreedyListHT(l, l.first, l.last), l.first, l.last)
+	return newSnapshot(l.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (l *ListHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := l.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written, or if its state was discarded by a later range
+// delete.
+func (l *ListHT) Get(key string) (pb.Command, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.getState(*l.aux, key)
+}
+
+// Keys returns every key with a state retained on the list, in ascending order.
+func (l *ListHT) Keys() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.stateKeys(*l.aux)
 }
 
 // ReduceLog applies the configured reduce algorithm and updates the current log state.
 // Must only be called within mutual exclusion scope.
 func (l *ListHT) ReduceLog(p, n uint64) error {
-	cmds, err := ApplyReduceAlgo(l, l.config.Alg, p, n)
+	return l.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (l *ListHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := l.hookReduceStart(p, n)
+	cmds, err := l.applyReduce(l, p, n)
+	l.hookReduceDone(p, n, start, len(cmds), err)
 	if err != nil {
 		return err
 	}
-	return l.updateLogState(cmds, p, n, false)
+	if err := l.updateLogStateCtx(ctx, cmds, p, n, 0); err != nil {
+		return err
+	}
+	if l.config.TruncateAfterReduce {
+		l.truncate(n)
+	}
+	l.measurePersisted(l.takeMeasure())
+	return nil
+}
+
+// retainedStates returns every state logged within [p, n], reduced by registered
+// reducers. Must only be called within mutual exclusion scope.
+func (l *ListHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return l.aux.statesWithin(p, n), nil
 }
 
 // mayTriggerReduce possibly triggers the reduce algorithm based on config params
 // (e.g. interval period reached). Must only be called within mutual exclusion scope.
-func (l *ListHT) mayTriggerReduce() error {
-	if l.config.Tick != Interval {
+func (l *ListHT) mayTriggerReduce(ctx context.Context) error {
+	if l.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		l.count++
 		return nil
 	}
-	l.count++
-	if l.count >= l.config.Period {
-		l.count = 0
-		return l.ReduceLog(l.first, l.last)
+	if !l.config.Tick.isPeriodic() {
+		return nil
+	}
+	if l.reachedReducePeriod(len(*l.aux)) {
+		return l.reduceLogCtx(ctx, l.first, l.last)
 	}
 	return nil
 }
 
 // mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
 // 'config.Period' wasnt reached yet.
-func (l *ListHT) mayExecuteLazyReduce(p, n uint64) error {
+func (l *ListHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
 	if l.config.Tick == Delayed {
-		err := l.ReduceLog(p, n)
+		err := l.reduceLogCtx(ctx, p, n)
 		if err != nil {
 			return err
 		}
 
-	} else if l.config.Tick == Interval && !l.firstReduceExists() {
+	} else if l.config.Tick.isScheduled() && !l.firstReduceExists() {
 		// must reduce the entire structure, just the desired interval would
-		// be incoherent with the Interval config
-		err := l.ReduceLog(l.first, l.last)
+		// be incoherent with scheduled configs
+		err := l.reduceLogCtx(ctx, l.first, l.last)
 		if err != nil {
 			return err
 		}
@@ -196,31 +470,99 @@ func (l *ListHT) mayExecuteLazyReduce(p, n uint64) error {
 	return nil
 }
 
-func (l *ListHT) searchEntryNodeByIndex(ind uint64) *listNode {
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (l *ListHT) reduceOnTick() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count == 0 {
+		return
+	}
+
+	// on failure, the counter is retained to retry on the next tick
+	err := l.config.Retry.do(context.Background(), func() error {
+		return l.ReduceLog(l.first, l.last)
+	})
+	if err != nil {
+		l.reportReduceErr(err)
+		return
+	}
+	l.count = 0
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once any in-flight reduce is finished.
+func (l *ListHT) SetConfig(cfg *LogConfig) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reconfigure(l.ctx, cfg, &l.tick, l.reduceOnTick)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (l *ListHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg := l.config.clone()
+	fn(cfg)
+	return l.reconfigure(l.ctx, cfg, &l.tick, l.reduceOnTick)
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, and flushes
+// any pending group commit.
+func (l *ListHT) Shutdown() {
+	if l.canc != nil {
+		l.canc()
+	}
+	if l.gc != nil {
+		l.gc.close()
+	}
+	l.closeJournal()
+	l.closeMeasure()
+}
+
+// searchEntryNodeByIndex returns the first node with index equal or greater than 'ind',
+// or nil if none is found, and whether its index equals 'ind'.
+func (l *ListHT) searchEntryNodeByIndex(ind uint64) (*listNode, bool) {
 	start := l.lt.first
 	last := l.lt.tail
 
-	var mid *listNode
 	for last != start {
-
-		mid = findMidInList(start, last)
+		mid := findMidInList(start, last)
 		ent := mid.val.(*listEntry)
 
-		// found in mid
-		if ent.ind == ind {
-			return mid
-
-			// greater
-		} else if ind > ent.ind {
+		// greater
+		if ind > ent.ind {
 			start = mid.next
 
-			// less
+			// less or equal, still the lower bound candidate
 		} else {
 			last = mid
 		}
 	}
-	// instead of nil, return the nearest element
-	return mid
+
+	// every entry is lower than 'ind'
+	if start == nil || start.val.(*listEntry).ind < ind {
+		return nil, false
+	}
+	return start, start.val.(*listEntry).ind == ind
+}
+
+// truncate drops every entry indexed up to 'n' and their states, on TruncateAfterReduce
+// config. Entries and the update list of each key are both on index order, so only their
+// heads are trimmed. Must only be called within mutual exclusion scope.
+func (l *ListHT) truncate(n uint64) {
+	for l.lt.first != nil && l.lt.first.val.(*listEntry).ind <= n {
+		ent := l.lt.pop().val.(*listEntry)
+		l.aux.popState(ent.key)
+	}
+
+	l.resetFirst(0, false)
+	if l.lt.first != nil {
+		l.resetFirst(l.lt.first.val.(*listEntry).ind, true)
+	}
+	l.tombs = l.tombs.after(n)
 }
 
 func (l *ListHT) resetVisitedValues() {
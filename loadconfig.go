@@ -0,0 +1,303 @@
+package beelog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigEnvPrefix prefixes the environment variables overriding configs loaded by
+// LoadLogConfig.
+const ConfigEnvPrefix = "BEELOG_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadLogConfig loads a LogConfig from the TOML or YAML file at 'path', identified by
+// its extension (i.e. ".toml", ".yaml" or ".yml"), starting from DefaultLogConfig.
+// Keys are matched to LogConfig fields ignoring case (e.g. "period" or "Period"),
+// enumerations are informed by their numeric values (e.g. Tick = 2 for Interval),
+// durations either as nanoseconds or strings parsed by time.ParseDuration (e.g.
+// "10ms"), and nested structs (e.g. Retry) as tables. Any loaded field is then
+// overridden by its ConfigEnvPrefix environment variable, the upper-cased field name
+// joined by underscores on nested structs (e.g. BEELOG_PERIOD or
+// BEELOG_RETRY_MAXATTEMPTS), where lists are separated by commas. The resulting
+// config is checked by ValidateConfig.
+//
+// Fields holding user implementations (i.e. Storage, ColdStorage, Encryption,
+// MeasureSink, Pinned and Hooks) cant be loaded from files, and should be assigned
+// to a config parsed by Load, which validates it only after they're set.
+func LoadLogConfig(path string) (*LogConfig, error) {
+	lc := DefaultLogConfig()
+	if err := lc.Load(path); err != nil {
+		return nil, err
+	}
+	if err := lc.ValidateConfig(); err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// Load overrides the fields of 'lc' informed by the config file at 'path' and by
+// environment variables, as in LoadLogConfig, without validating the result.
+func (lc *LogConfig) Load(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		if _, err := toml.Decode(string(raw), &fields); err != nil {
+			return fmt.Errorf("failed decoding config '%s', err: '%s'", path, err.Error())
+		}
+
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("failed decoding config '%s', err: '%s'", path, err.Error())
+		}
+
+	default:
+		return fmt.Errorf("unknown config file extension '%s', expected .toml, .yaml or .yml", ext)
+	}
+
+	v := reflect.ValueOf(lc).Elem()
+	if err := loadFields(v, fields, ""); err != nil {
+		return err
+	}
+	return loadEnv(v, ConfigEnvPrefix, "")
+}
+
+// loadFields assigns every value of 'fields' into the field of struct 'v' matching
+// its key, where 'path' names 'v' on error messages.
+func loadFields(v reflect.Value, fields map[string]interface{}, path string) error {
+	for key, raw := range fields {
+		f, name, ok := fieldByName(v, key)
+		if !ok {
+			return fmt.Errorf("unknown config field 'config.%s%s'", path, key)
+		}
+		if err := loadValue(f, raw, path+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByName returns the exported field of struct 'v' named 'key', ignoring case,
+// and its declared name.
+func fieldByName(v reflect.Value, key string) (reflect.Value, string, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.PkgPath == "" && strings.EqualFold(sf.Name, key) {
+			return v.Field(i), sf.Name, true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// loadValue assigns 'raw', as decoded from config files or environment variables,
+// into 'f', converting it into the field type.
+func loadValue(f reflect.Value, raw interface{}, name string) error {
+	if s, ok := raw.(string); ok && f.Kind() != reflect.String && f.Kind() != reflect.Slice {
+		return loadString(f, s, name)
+	}
+
+	switch {
+	case f.Type() == durationType:
+		switch r := raw.(type) {
+		case int:
+			f.SetInt(int64(r))
+			return nil
+		case int64:
+			f.SetInt(r)
+			return nil
+		}
+
+	case f.Kind() == reflect.Bool:
+		if b, ok := raw.(bool); ok {
+			f.SetBool(b)
+			return nil
+		}
+
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
+		if n, ok := toInt64(raw); ok && !f.OverflowInt(n) {
+			f.SetInt(n)
+			return nil
+		}
+
+	case f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
+		if n, ok := toInt64(raw); ok && n >= 0 && !f.OverflowUint(uint64(n)) {
+			f.SetUint(uint64(n))
+			return nil
+		}
+
+	case f.Kind() == reflect.Float32 || f.Kind() == reflect.Float64:
+		switch r := raw.(type) {
+		case float64:
+			f.SetFloat(r)
+			return nil
+		case int, int64:
+			n, _ := toInt64(r)
+			f.SetFloat(float64(n))
+			return nil
+		}
+
+	case f.Kind() == reflect.String:
+		if s, ok := raw.(string); ok {
+			f.SetString(s)
+			return nil
+		}
+
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+		switch r := raw.(type) {
+		case string:
+			return loadString(f, r, name)
+
+		case []interface{}:
+			l := reflect.MakeSlice(f.Type(), len(r), len(r))
+			for i, e := range r {
+				s, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("invalid value for config.%s, expected a list of strings", name)
+				}
+				l.Index(i).SetString(s)
+			}
+			f.Set(l)
+			return nil
+		}
+
+	case f.Kind() == reflect.Struct:
+		if m, ok := toStringMap(raw); ok {
+			return loadFields(f, m, name+".")
+		}
+
+	default:
+		return fmt.Errorf("config.%s cant be loaded from config files", name)
+	}
+	return fmt.Errorf("invalid value '%v' for config.%s", raw, name)
+}
+
+// loadString assigns the textual representation 's' into 'f', converting it into
+// the field type.
+func loadString(f reflect.Value, s string, name string) error {
+	var err error
+	switch {
+	case f.Type() == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			f.SetInt(int64(d))
+		}
+
+	case f.Kind() == reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			f.SetBool(b)
+		}
+
+	case f.Kind() >= reflect.Int && f.Kind() <= reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, f.Type().Bits()); err == nil {
+			f.SetInt(n)
+		}
+
+	case f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, f.Type().Bits()); err == nil {
+			f.SetUint(n)
+		}
+
+	case f.Kind() == reflect.Float32 || f.Kind() == reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(s, f.Type().Bits()); err == nil {
+			f.SetFloat(n)
+		}
+
+	case f.Kind() == reflect.String:
+		f.SetString(s)
+
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+		l := strings.Split(s, ",")
+		for i := range l {
+			l[i] = strings.TrimSpace(l[i])
+		}
+		f.Set(reflect.ValueOf(l).Convert(f.Type()))
+
+	default:
+		return fmt.Errorf("config.%s cant be loaded from config files", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value '%s' for config.%s, err: '%s'", s, name, err.Error())
+	}
+	return nil
+}
+
+// loadEnv overrides every loadable field of struct 'v' informed by an environment
+// variable, named by 'prefix' and the upper-cased field name.
+func loadEnv(v reflect.Value, prefix, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		env := prefix + strings.ToUpper(sf.Name)
+
+		if sf.Type.Kind() == reflect.Struct {
+			if err := loadEnv(v.Field(i), env+"_", path+sf.Name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if s, ok := os.LookupEnv(env); ok {
+			if err := loadString(v.Field(i), s, path+sf.Name); err != nil {
+				return fmt.Errorf("failed loading %s, err: '%s'", env, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func toInt64(raw interface{}) (int64, bool) {
+	switch r := raw.(type) {
+	case int:
+		return int64(r), true
+	case int64:
+		return r, true
+	case uint64:
+		if r <= math.MaxInt64 {
+			return int64(r), true
+		}
+	}
+	return 0, false
+}
+
+// toStringMap returns the table 'raw', as decoded by either TOML (i.e. string keys)
+// or YAML (i.e. interface keys).
+func toStringMap(raw interface{}) (map[string]interface{}, bool) {
+	switch r := raw.(type) {
+	case map[string]interface{}:
+		return r, true
+
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(r))
+		for k, e := range r {
+			s, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			m[s] = e
+		}
+		return m, true
+	}
+	return nil, false
+}
@@ -0,0 +1,717 @@
+package beelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// defaultMemtableSize is the number of unique keys buffered on a LSMLog memtable
+	// before it's flushed as a new sorted run.
+	defaultMemtableSize = 4000
+
+	// lsmLevelFanout is the number of runs a level accumulates before they are merged
+	// into a single run on the next level.
+	lsmLevelFanout = 4
+)
+
+// lsmRun is an immutable sequence of commands sorted by key, where each key retains
+// only its latest state, preceded by any prior states it depends on.
+type lsmRun struct {
+	name        string       // segment name, only set on persistent config
+	cmds        []pb.Command // only retained on inmem config
+	first, last uint64
+	keys        int
+}
+
+// LSMLog is a log-structured merge structure. Recent commands are buffered on an
+// in-memory table storing only the latest state of each key, which is flushed as a
+// sorted run once it reaches its configured size. Runs are organized on levels, and
+// once a level accumulates 'lsmLevelFanout' runs, they are merged by key into a single
+// run on the next level by a background compaction routine. On persistent config
+// (i.e. 'inmem' false), runs are written on different segments derived from
+// 'config.Fname', bounding memory usage to the size of the memtable.
+//
+// Reduce is driven by memtable flushes and compactions, so 'config.Tick' and its
+// related params are ignored.
+type LSMLog struct {
+	mem     minStateTable
+	levels  [][]*lsmRun
+	memSize int
+	len     uint64
+	seq     uint64 // atomic
+	mu      sync.RWMutex
+	canc    context.CancelFunc
+
+	compactReq  chan struct{}
+	compactDone chan struct{} // closed once the compaction routine returns
+	logData
+}
+
+// NewLSMLog ...
+func NewLSMLog(ctx context.Context) *LSMLog {
+	cfg := DefaultLogConfig()
+	cfg.Alg = MergeLSM
+
+	lg := newLSMLog(cfg, defaultMemtableSize)
+	ct, cancel := context.WithCancel(ctx)
+	lg.canc = cancel
+
+	go lg.handleCompaction(ct)
+	return lg
+}
+
+// NewLSMLogWithConfig ...
+func NewLSMLogWithConfig(ctx context.Context, cfg *LogConfig, memSize int) (*LSMLog, error) {
+	err := cfg.ValidateConfig()
+	if err != nil {
+		return nil, err
+	}
+	if memSize <= 0 {
+		return nil, errors.New("invalid config: a positive memtable size must be provided")
+	}
+	if cfg.Encryption != nil || cfg.Mmap || cfg.Journal || cfg.RestoreOnInit || cfg.Codec != ProtoCodec {
+		return nil, errors.New("invalid config: config.Encryption, config.Mmap, config.Journal, config.RestoreOnInit and config.Codec are unsupported on LSMLog structures")
+	}
+	if err := checkHistorySupported(cfg, LSMKind); err != nil {
+		return nil, err
+	}
+
+	lg := newLSMLog(cfg, memSize)
+	ct, cancel := context.WithCancel(ctx)
+	lg.canc = cancel
+
+	go lg.handleCompaction(ct)
+	return lg, nil
+}
+
+func newLSMLog(cfg *LogConfig, memSize int) *LSMLog {
+	return &LSMLog{
+		mem:         make(minStateTable, 0),
+		levels:      make([][]*lsmRun, 1),
+		memSize:     memSize,
+		compactReq:  make(chan struct{}, 1),
+		compactDone: make(chan struct{}),
+		logData:     newLogData(cfg),
+	}
+}
+
+// Str returns a string representation of the number of keys on the memtable and on
+// each run of every level, used for debug purposes.
+func (lg *LSMLog) Str() string {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	strs := []string{fmt.Sprintf("mem: %d", len(lg.mem))}
+	for i, lvl := range lg.levels {
+		runs := make([]string, 0, len(lvl))
+		for _, r := range lvl {
+			runs = append(runs, fmt.Sprintf("(%v-%v|%v)", r.first, r.last, r.keys))
+		}
+		strs = append(strs, fmt.Sprintf("L%d: [%s]", i, strings.Join(runs, " ")))
+	}
+	return strings.Join(strs, " ")
+}
+
+// Len returns the number of write commands logged on the structure.
+func (lg *LSMLog) Len() uint64 {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	return lg.length()
+}
+
+func (lg *LSMLog) length() uint64 {
+	return lg.len
+}
+
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (lg *LSMLog) FirstIndex() uint64 {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	return lg.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (lg *LSMLog) LastIndex() uint64 {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	return lg.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (lg *LSMLog) Debug() DebugInfo {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	info := lg.debugInfo("lsm", lg.len)
+	info.PendingReduces = len(lg.compactReq)
+	return info
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (lg *LSMLog) Stats() (Stats, error) {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	st, err := lg.baseStats()
+	st.Keys = len(lg.mem)
+	st.MemBytes = lg.mem.memBytes()
+	for _, lvl := range lg.levels {
+		for _, r := range lvl {
+			st.Keys += r.keys
+			if r.name != "" {
+				st.Segments++
+			}
+			for i := range r.cmds {
+				st.MemBytes += cmdBytes(&r.cmds[i])
+			}
+		}
+	}
+	return st, err
+}
+
+// Log records the occurence of command 'cmd' on the provided index. Writes update
+// the latest state of their key on the memtable, which is flushed as a sorted run
+// once it reaches the configured size.
+func (lg *LSMLog) Log(cmd pb.Command) error {
+	return lg.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. A flush triggered by the command observes 'ctx' before
+// persisting, in which case the command remains on the memtable and is flushed later.
+func (lg *LSMLog) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return lg.logData.seq.sequence(ctx, cmd, lg.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (lg *LSMLog) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isRangeDelete(cmd.Op) {
+		return ErrRangeDeleteUnsupported
+	}
+	if lg.ignoresRead(&cmd) {
+		return nil
+	}
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	lg.countLogged(&cmd)
+	lg.recordPinned(&cmd)
+
+	if !isWriteOp(cmd.Op) {
+		lg.attributeFirst(cmd.Id)
+		lg.last = cmd.Id
+		return nil
+	}
+
+	st := State{
+		ind: cmd.Id,
+		cmd: cmd,
+	}
+
+	// conditional updates must retain the state they were applied over
+	if prior, ok := lg.mem[cmd.Key]; ok && st.dependsOnPrior() {
+		st.prev = &prior
+	}
+	lg.mem[cmd.Key] = st
+
+	lg.attributeFirst(cmd.Id)
+	lg.last = cmd.Id
+	lg.len++
+
+	if len(lg.mem) < lg.memSize {
+		return nil
+	}
+	return lg.flushCtx(ctx)
+}
+
+// Recov returns the latest state of every key logged on the structure, merged from
+// the memtable and every run. Runs discard the original log intervals, so the entire
+// reduced log is always returned, independently of the requested [p, n] interval.
+func (lg *LSMLog) Recov(p, n uint64) ([]pb.Command, error) {
+	return lg.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the reading of persisted runs
+// once 'ctx' is done, returning ctx.Err().
+func (lg *LSMLog) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	p, n, err := lg.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	lg.hookRecovery(p, n)
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	cmds, err := lg.mergeStateCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if lg.config.AlgName != "" {
+		// runs discard the original log intervals, the entire state is always reduced
+		if cmds, err = lg.applyRegisteredReducer(LSMKind, cmds, 0, ^uint64(0)); err != nil {
+			return nil, err
+		}
+	}
+	return lg.withPinnedHistory(cmds, 0, ^uint64(0)), nil
+}
+
+// RecovBytes returns the serialized log of 'Recov', following the same slicing
+// protocol of other structures, where the size of each command is binary encoded
+// before the raw pbuff.
+func (lg *LSMLog) RecovBytes(p, n uint64) ([]byte, error) {
+	cmds, err := lg.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn).
+func (lg *LSMLog) RecovBytesStream(w io.Writer, p, n uint64) error {
+	cmds, err := lg.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return MarshalLogIntoWriter(w, &cmds, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (lg *LSMLog) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := lg.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (lg *LSMLog) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := lg.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (lg *LSMLog) RecovSince(id uint64) ([]pb.Command, error) {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	if lg.length() == 0 || id >= lg.last {
+		return []pb.Command{}, nil
+	}
+
+	cmds, err := lg.mergeStateCtx(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return filterSince(cmds, id), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands. Runs are read during creation, since later
+// compactions may remove them.
+func (lg *LSMLog) Snapshot() (*Snapshot, error) {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	if lg.length() == 0 {
+		return newSnapshot(lg.last, nil), nil
+	}
+	cmds, err := lg.mergeStateCtx(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshot(lg.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (lg *LSMLog) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := lg.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', searching the memtable and then
+// every run from the most recent one, read from persistent storage if necessary.
+// Returns false if the key was never written, or if a run could not be read, in which
+// case the failure is reported on the structure error channel.
+func (lg *LSMLog) Get(key string) (pb.Command, bool) {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	if st, ok := lg.mem[key]; ok {
+		return visibleState(&st.cmd, lg.liveState(&st.cmd, nil))
+	}
+
+	// lower levels and later runs retain the most recent states
+	for _, lvl := range lg.levels {
+		for i := len(lvl) - 1; i >= 0; i-- {
+			cmds, err := lg.readRun(context.Background(), lvl[i])
+			if err != nil {
+				lg.errs.report(fmt.Errorf("failed reading state of key '%s', err: %w", key, err))
+				return pb.Command{}, false
+			}
+			if cmd, ok := searchRun(cmds, key); ok {
+				return visibleState(cmd, lg.liveState(cmd, nil))
+			}
+		}
+	}
+	return pb.Command{}, false
+}
+
+// Keys returns every key with a state retained on the memtable or any run, in
+// ascending order. Runs that could not be read are reported on the structure error
+// channel, and their keys omitted.
+func (lg *LSMLog) Keys() []string {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+
+	// only the most recent state of each key is considered
+	latest := make(map[string]bool, len(lg.mem))
+	for k, st := range lg.mem {
+		latest[k] = lg.liveState(&st.cmd, nil)
+	}
+	for _, lvl := range lg.levels {
+		for i := len(lvl) - 1; i >= 0; i-- {
+			cmds, err := lg.readRun(context.Background(), lvl[i])
+			if err != nil {
+				lg.errs.report(fmt.Errorf("failed reading run keys, err: %w", err))
+				continue
+			}
+			for j := range cmds {
+				if _, seen := latest[cmds[j].Key]; seen || j+1 < len(cmds) && cmds[j+1].Key == cmds[j].Key {
+					continue
+				}
+				latest[cmds[j].Key] = lg.liveState(&cmds[j], nil)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(latest))
+	for k, live := range latest {
+		if live {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Flush writes the current memtable as a new sorted run, even if its configured size
+// wasnt reached yet.
+func (lg *LSMLog) Flush() error {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	return lg.flushCtx(context.Background())
+}
+
+// Shutdown stops the background compaction routine, waiting for any in-flight
+// compaction to finish, and flushes any pending group commit. The memtable is not
+// flushed, consider calling 'Flush' before.
+func (lg *LSMLog) Shutdown() {
+	if lg.canc != nil {
+		lg.canc()
+		<-lg.compactDone
+	}
+	if lg.gc != nil {
+		lg.gc.close()
+	}
+}
+
+// flushCtx writes the memtable as a new run on the first level, signaling the
+// compaction routine. Must only be called within mutual exclusion scope.
+func (lg *LSMLog) flushCtx(ctx context.Context) error {
+	if len(lg.mem) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := lg.hookReduceStart(lg.first, lg.last)
+	cmds := memtableRun(lg.mem)
+	lg.hookReduceDone(lg.first, lg.last, start, len(cmds), nil)
+
+	run, err := lg.writeRun(0, cmds)
+	if err != nil {
+		return err
+	}
+	lg.levels[0] = append(lg.levels[0], run)
+	lg.mem = make(minStateTable, 0)
+
+	select {
+	case lg.compactReq <- struct{}{}:
+	default:
+		// a compaction is already pending
+	}
+	return nil
+}
+
+// handleCompaction merges the runs of any level that reached 'lsmLevelFanout' runs
+// once signaled by a flush, until 'ctx' is cancelled.
+func (lg *LSMLog) handleCompaction(ctx context.Context) {
+	defer close(lg.compactDone)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-lg.compactReq:
+			// on failure, runs are retained and compacted on the next flush
+			lvl := 0
+			for lvl < lg.numLevels() {
+				var merged bool
+				err := lg.config.Retry.do(ctx, func() error {
+					var err error
+					merged, err = lg.compactLevel(ctx, lvl)
+					return err
+				})
+				if err != nil {
+					lg.reportReduceErr(err)
+					break
+				}
+
+				// a level may still accumulate enough runs for another compaction
+				if !merged {
+					lvl++
+				}
+			}
+		}
+	}
+}
+
+// numLevels returns the current number of levels.
+func (lg *LSMLog) numLevels() int {
+	lg.mu.RLock()
+	defer lg.mu.RUnlock()
+	return len(lg.levels)
+}
+
+// compactLevel merges the oldest 'lsmLevelFanout' runs of level 'lvl' into a single
+// run on the next level. Returns false if 'lvl' has less runs than required for a
+// compaction.
+func (lg *LSMLog) compactLevel(ctx context.Context, lvl int) (bool, error) {
+	lg.mu.RLock()
+	if lvl >= len(lg.levels) || len(lg.levels[lvl]) < lsmLevelFanout {
+		lg.mu.RUnlock()
+		return false, nil
+	}
+	olds := make([]*lsmRun, lsmLevelFanout)
+	copy(olds, lg.levels[lvl])
+	lg.mu.RUnlock()
+
+	// runs are immutable and only discarded by this routine, so they can be safely
+	// read outside mutual exclusion
+	runs := make([][]pb.Command, 0, len(olds))
+	for _, r := range olds {
+		cmds, err := lg.readRun(ctx, r)
+		if err != nil {
+			return false, err
+		}
+		runs = append(runs, cmds)
+	}
+
+	first, last := olds[0].first, olds[0].last
+	for _, r := range olds[1:] {
+		if r.first < first {
+			first = r.first
+		}
+		if r.last > last {
+			last = r.last
+		}
+	}
+
+	start := lg.hookReduceStart(first, last)
+	merged := mergeRuns(runs)
+	lg.hookReduceDone(first, last, start, len(merged), nil)
+
+	run, err := lg.writeRun(lvl+1, merged)
+	if err != nil {
+		return false, err
+	}
+
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	lg.levels[lvl] = append([]*lsmRun{}, lg.levels[lvl][lsmLevelFanout:]...)
+	if lvl+1 == len(lg.levels) {
+		lg.levels = append(lg.levels, nil)
+	}
+	lg.levels[lvl+1] = append(lg.levels[lvl+1], run)
+
+	// old segments are only deleted once no recovery is reading them
+	for _, r := range olds {
+		if r.name == "" {
+			continue
+		}
+		if err := lg.storage().Delete(r.name); err != nil {
+			lg.reportReduceErr(err)
+		}
+	}
+	return true, nil
+}
+
+// writeRun creates a new run on level 'lvl' from the key sorted 'cmds', persisting it
+// on a new segment if persistent config is set.
+func (lg *LSMLog) writeRun(lvl int, cmds []pb.Command) (*lsmRun, error) {
+	run := &lsmRun{keys: len(cmds)}
+	for i, c := range cmds {
+		if i == 0 || c.Id < run.first {
+			run.first = c.Id
+		}
+		if c.Id > run.last {
+			run.last = c.Id
+		}
+	}
+
+	if lg.config.Inmem {
+		run.cmds = cmds
+		return run, nil
+	}
+
+	run.name = lg.runName(lvl, atomic.AddUint64(&lg.seq, 1))
+	seg, err := lg.storage().Create(run.name)
+	if err != nil {
+		return nil, err
+	}
+	defer seg.Close()
+	cw := &countingWriter{w: seg}
+
+	if !lg.config.Sync {
+		if err = MarshalLogIntoWriter(cw, &cmds, run.first, run.last); err != nil {
+			return nil, err
+		}
+		lg.hookPersist(run.name, cw.n)
+		return run, nil
+	}
+
+	if err = MarshalBufferedLogIntoWriter(cw, &cmds, run.first, run.last); err != nil {
+		return nil, err
+	}
+	if err = seg.Sync(); err != nil {
+		return nil, err
+	}
+	lg.hookPersist(run.name, cw.n)
+	return run, nil
+}
+
+// readRun returns the commands of 'r', reading them from persistent storage if
+// necessary.
+func (lg *LSMLog) readRun(ctx context.Context, r *lsmRun) ([]pb.Command, error) {
+	if r.name == "" {
+		return r.cmds, nil
+	}
+
+	rd, err := lg.readSegment(r.name)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return UnmarshalLogFromReader(&ctxReader{ctx: ctx, r: rd})
+}
+
+// runName derives the segment name of the 'seq'-th run of level 'lvl' from
+// 'config.Fname' (e.g. './beelog.log' is mapped into './beelog.L0.1.log').
+func (lg *LSMLog) runName(lvl int, seq uint64) string {
+	ext := filepath.Ext(lg.config.Fname)
+	base := strings.TrimSuffix(lg.config.Fname, ext)
+	return fmt.Sprintf("%s.L%d.%d%s", base, lvl, seq, ext)
+}
+
+// mergeStateCtx merges the memtable and every run into the reduced log, ordered by
+// command indexes. Must only be called within mutual exclusion scope.
+func (lg *LSMLog) mergeStateCtx(ctx context.Context) ([]pb.Command, error) {
+	if lg.length() == 0 {
+		return nil, errors.New("empty structure")
+	}
+
+	runs := [][]pb.Command{memtableRun(lg.mem)}
+	for _, lvl := range lg.levels {
+		for _, r := range lvl {
+			cmds, err := lg.readRun(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, cmds)
+		}
+	}
+
+	log := mergeRuns(runs)
+	sort.SliceStable(log, func(i, j int) bool { return log[i].Id < log[j].Id })
+
+	if lg.config.DropExpired {
+		log = dropExpiredStates(log, time.Now().UnixNano())
+	}
+	return log, nil
+}
+
+// memtableRun returns the states of 'mem' sorted by key, each one preceded by any prior
+// states it depends on.
+func memtableRun(mem minStateTable) []pb.Command {
+	log := make([]pb.Command, 0, len(mem))
+	for _, st := range mem {
+		log = appendStateChain(log, &st)
+	}
+
+	// prior states share the same key and are already ordered by index
+	sort.SliceStable(log, func(i, j int) bool { return log[i].Key < log[j].Key })
+	return log
+}
+
+// mergeRuns merges the key sorted 'runs' into a single run, also sorted by key. Keys
+// present on multiple runs retain only their latest state, preceded by any prior
+// states it depends on, following the same policy of 'MergeSegments'.
+func mergeRuns(runs [][]pb.Command) []pb.Command {
+	cur := make([]int, len(runs))
+	log := []pb.Command{}
+
+	for {
+		// find the lowest key not yet merged among every run
+		var key string
+		found := false
+		for i, r := range runs {
+			if cur[i] < len(r) && (!found || r[cur[i]].Key < key) {
+				key, found = r[cur[i]].Key, true
+			}
+		}
+		if !found {
+			return log
+		}
+
+		kc := []pb.Command{}
+		for i, r := range runs {
+			for cur[i] < len(r) && r[cur[i]].Key == key {
+				kc = append(kc, r[cur[i]])
+				cur[i]++
+			}
+		}
+		log = append(log, latestKeyChain(kc)...)
+	}
+}
@@ -0,0 +1,106 @@
+package beelog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// buffers retaining more than 'marshalShrinkFactor' times the size of the latest
+	// serialized log are released, unless below 'marshalMinRetained' bytes
+	marshalShrinkFactor = 4
+	marshalMinRetained  = 64 * 1024
+
+	// size of chunks written by 'marshalCommandsIntoWriter', and the largest pooled
+	// command buffer retained
+	cmdFlushSize   = 32 * 1024
+	cmdMaxRetained = 4 * cmdFlushSize
+)
+
+// cmdBufferPool retains buffers utilized to serialize commands.
+var cmdBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, cmdFlushSize)
+		return &b
+	},
+}
+
+func getCmdBuffer() *[]byte {
+	b := cmdBufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// putCmdBuffer returns 'b' to the pool, unless it grew beyond 'cmdMaxRetained' bytes
+// (e.g. after a single huge command).
+func putCmdBuffer(b *[]byte) {
+	if cap(*b) <= cmdMaxRetained {
+		cmdBufferPool.Put(b)
+	}
+}
+
+// appendCommand appends 'c' into 'b', prefixed by its big endian int32 encoded size.
+// The command size is computed upfront, growing 'b' at most once, and it's marshaled
+// directly into the buffer.
+func appendCommand(b []byte, c *pb.Command) []byte {
+	sz := c.SizeVT()
+	if free := cap(b) - len(b); free < 4+sz {
+		nb := make([]byte, len(b), 2*cap(b)+4+sz)
+		copy(nb, b)
+		b = nb
+	}
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(sz))
+	b = append(b, l[:]...)
+	return c.MarshalAppendVT(b)
+}
+
+// marshalBuffer is a reusable serialization buffer of reduced logs, utilized on the
+// persistence path of each structure (or ConcTable view). Instead of a new buffer per
+// reduce, grown from an underestimated number of bytes, it is grown upfront to the
+// byte count of the previously serialized log. It is not safe for concurrent use.
+type marshalBuffer struct {
+	buf   bytes.Buffer
+	last  int
+	codec Codec
+}
+
+// serialize marshals 'log' into the buffer, returning its content. The returned slice
+// is only valid until the next use of the buffer.
+func (mb *marshalBuffer) serialize(log *[]pb.Command, p, n uint64) ([]byte, error) {
+	if c := mb.buf.Cap(); c > marshalMinRetained && c > marshalShrinkFactor*mb.last {
+		mb.buf = bytes.Buffer{}
+	}
+	mb.buf.Reset()
+	mb.buf.Grow(mb.last)
+
+	if err := MarshalLogWithCodec(&mb.buf, mb.codec, log, p, n); err != nil {
+		return nil, err
+	}
+	mb.last = mb.buf.Len()
+	return mb.buf.Bytes(), nil
+}
+
+// marshal is analogous to 'MarshalBufferedLogIntoWriter', serializing 'log' into
+// the buffer and writing it into 'w' on a single call.
+func (mb *marshalBuffer) marshal(w io.Writer, log *[]pb.Command, p, n uint64) error {
+	raw, err := mb.serialize(log, p, n)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(raw)
+	return err
+}
+
+// marshalBuffer returns the reusable serialization buffer of the structure, lazily
+// created so every ConcTable view retains its own.
+func (ld *logData) marshalBuffer() *marshalBuffer {
+	if ld.mbuf == nil {
+		ld.mbuf = &marshalBuffer{codec: ld.config.Codec}
+	}
+	return ld.mbuf
+}
@@ -0,0 +1,281 @@
+package beelog
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyHistograms aggregates every latency tuple captured on Measure config, as
+// the elapsed nanoseconds since the Init timestamp of each measured interval.
+type LatencyHistograms struct {
+	Write   Histogram
+	Fill    Histogram
+	Persist Histogram
+}
+
+func (lh *LatencyHistograms) record(d LatData) {
+	lh.Write.Record(d.Write - d.Init)
+	lh.Fill.Record(d.Fill - d.Init)
+	lh.Persist.Record(d.Persist - d.Init)
+}
+
+// latencyMeasure holds auxiliar variables to implement an in-deep latency analysis
+// on structure operations. Each measurement records when the first command of an
+// interval of 'interval' commands is received (init) and written (write), when the
+// last one is written (fill), and when the reduced state is persisted (perst).
+// Complete tuples are aggregated into histograms, and either streamed to the sink or
+// sampled into a fixed-size reservoir, keeping memory constant regardless of run
+// length.
+type latencyMeasure struct {
+	mu   sync.Mutex
+	sink MeasureSink
+	rng  *rand.Rand
+
+	// chance of drawing an interval for measurement, or the deterministic stride of
+	// measured intervals if 'every' is positive
+	rate  float64
+	every int
+
+	drawn    bool
+	absIndex int
+	msrIndex int
+	interval int
+	cur      LatData
+
+	// drawn measurements awaiting their persist timestamp
+	pending map[int]LatData
+	hists   LatencyHistograms
+
+	// uniform sample of 'resSize' raw tuples, out of 'seen' complete ones
+	reservoir []LatData
+	resSize   int
+	seen      int
+}
+
+func newLatencyMeasure(interval int, cfg *LogConfig, sink MeasureSink) *latencyMeasure {
+	rate := cfg.MeasureRate
+	if rate == 0 {
+		rate = 1
+	}
+	return &latencyMeasure{
+		sink:     sink,
+		rng:      cfg.newRand(),
+		rate:     rate,
+		every:    cfg.MeasureEvery,
+		interval: interval,
+		pending:  make(map[int]LatData),
+		resSize:  cfg.MeasureReservoir,
+	}
+}
+
+// openMeasureSink returns the configured MeasureSink of 'cfg', or a CSV sink over the
+// latency output file 'filename' if none is provided.
+func openMeasureSink(cfg *LogConfig, filename string) (MeasureSink, error) {
+	if cfg.MeasureSink != nil {
+		return cfg.MeasureSink, nil
+	}
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCSVSink(fd), nil
+}
+
+// beginCmd accounts a new command received by the structure, possibly drawing a new
+// measurement if it starts an interval.
+func (lm *latencyMeasure) beginCmd() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.absIndex++
+	if (lm.absIndex%lm.interval == 1 || lm.interval == 1) && lm.drawInterval() {
+		lm.cur = LatData{Init: time.Now().UnixNano()}
+		lm.drawn = true
+	}
+}
+
+// drawInterval informs if the interval started by the current command is measured.
+func (lm *latencyMeasure) drawInterval() bool {
+	if lm.every > 0 {
+		n := (lm.absIndex - 1) / lm.interval
+		return n%lm.every == 0
+	}
+	return lm.rng.Float64() < lm.rate
+}
+
+// cmdLogged records the write timestamp of the first command of a drawn interval,
+// or the fill timestamp of its last one.
+func (lm *latencyMeasure) cmdLogged() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if !lm.drawn {
+		return
+	}
+
+	if lm.absIndex%lm.interval == 1 {
+		// first command was written into table
+		lm.cur.Write = time.Now().UnixNano()
+
+	} else if lm.interval == 1 {
+		// special case of first and last command, which does not fall
+		// on first condition
+		lm.cur.Write = time.Now().UnixNano()
+		lm.cur.Fill = time.Now().UnixNano()
+
+	} else if lm.absIndex%lm.interval == 0 {
+		// last command, table filled
+		lm.cur.Fill = time.Now().UnixNano()
+	}
+}
+
+// filled informs if the drawn measurement, if any, already has its fill timestamp.
+func (lm *latencyMeasure) filled() bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.drawn && lm.cur.Fill != 0
+}
+
+// takeMeasure returns the index of the drawn measurement, now awaiting its persist
+// timestamp, and releases the draw of a new one. Returns -1 if none was drawn.
+func (lm *latencyMeasure) takeMeasure() int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if !lm.drawn {
+		return -1
+	}
+	id := lm.msrIndex
+	lm.pending[id] = lm.cur
+	lm.msrIndex++
+	lm.drawn = false
+	return id
+}
+
+// measurePersLatOf records the persist timestamp of measurement 'id', if any, and
+// aggregates its complete latency tuple.
+func (lm *latencyMeasure) measurePersLatOf(id int) {
+	if id == -1 {
+		return
+	}
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	d, ok := lm.pending[id]
+	if !ok {
+		return
+	}
+	delete(lm.pending, id)
+	d.Persist = time.Now().UnixNano()
+	lm.hists.record(d)
+
+	if lm.resSize <= 0 {
+		lm.sink.Record(d)
+		return
+	}
+
+	// reservoir sampling, each tuple has an equal chance of being retained
+	lm.seen++
+	if len(lm.reservoir) < lm.resSize {
+		lm.reservoir = append(lm.reservoir, d)
+	} else if j := lm.rng.Intn(lm.seen); j < lm.resSize {
+		lm.reservoir[j] = d
+	}
+}
+
+// histograms returns a copy of the aggregated latency histograms.
+func (lm *latencyMeasure) histograms() *LatencyHistograms {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	cp := lm.hists
+	return &cp
+}
+
+// flush records every sampled tuple into the sink, then flushes it.
+func (lm *latencyMeasure) flush() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, d := range lm.reservoir {
+		if err := lm.sink.Record(d); err != nil {
+			return err
+		}
+	}
+	lm.reservoir = lm.reservoir[:0]
+	lm.seen = 0
+	return lm.sink.Flush()
+}
+
+func (lm *latencyMeasure) close() {
+	lm.sink.Close()
+}
+
+// measureFname returns the latency output file of a structure named 'name' (e.g. "list")
+// configured by 'cfg'.
+func measureFname(name string, cfg *LogConfig) string {
+	return extractLocation(cfg.Fname) + "bl-" + name + "-" + strconv.Itoa(int(cfg.Period)) + "-latency.out"
+}
+
+// initMeasure enables latency measurement on Measure config, recording tuples into
+// the configured sink, or into the output file of the structure named 'name'.
+func (ld *logData) initMeasure(name string) error {
+	if !ld.config.Measure {
+		return nil
+	}
+	sink, err := openMeasureSink(ld.config, measureFname(name, ld.config))
+	if err != nil {
+		return err
+	}
+	ld.lm = newLatencyMeasure(int(ld.config.Period), ld.config, sink)
+	return nil
+}
+
+// measureBegin accounts a new command on Measure config. Must be called from mutual
+// exclusion scope, such as the following measure calls.
+func (ld *logData) measureBegin() {
+	if ld.lm != nil {
+		ld.lm.beginCmd()
+	}
+}
+
+// measureLogged records the write and fill timestamps of drawn measurements, once
+// the command is recorded.
+func (ld *logData) measureLogged() {
+	if ld.lm != nil {
+		ld.lm.cmdLogged()
+	}
+}
+
+// takeMeasure returns the index of a measurement whose interval was entirely logged,
+// awaiting the persistence of its reduced state. Returns -1 if none.
+func (ld *logData) takeMeasure() int {
+	if ld.lm == nil || !ld.lm.filled() {
+		return -1
+	}
+	return ld.lm.takeMeasure()
+}
+
+// measurePersisted records the persist timestamp of measurement 'id', if any.
+func (ld *logData) measurePersisted(id int) {
+	if ld.lm != nil {
+		ld.lm.measurePersLatOf(id)
+	}
+}
+
+// Latencies returns the aggregated latency histograms on Measure config, or nil if
+// latency measurement is disabled.
+func (ld *logData) Latencies() *LatencyHistograms {
+	if ld.lm == nil {
+		return nil
+	}
+	return ld.lm.histograms()
+}
+
+// closeMeasure flushes every recorded latency tuple into the sink, closing it.
+func (ld *logData) closeMeasure() {
+	if ld.lm != nil {
+		ld.lm.flush()
+		ld.lm.close()
+	}
+}
@@ -0,0 +1,235 @@
+package beelog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatData is a latency tuple captured by Measure config, composed of the unix
+// nanosecond timestamps of when the first command of a measured interval was
+// received (Init) and written (Write), when the last one was written (Fill), and
+// when the reduced state of the interval was persisted (Persist).
+type LatData struct {
+	Init    int64 `json:"init"`
+	Write   int64 `json:"write"`
+	Fill    int64 `json:"fill"`
+	Persist int64 `json:"persist"`
+}
+
+// MeasureSink receives every latency tuple captured by a structure on Measure
+// config. Implementations must be safe for concurrent use, since tuples are
+// recorded from reduce procedures.
+type MeasureSink interface {
+	// Record registers a new latency tuple, possibly buffering it until the next
+	// Flush call.
+	Record(d LatData) error
+
+	// Flush exports every buffered tuple.
+	Flush() error
+
+	// Close flushes any remaining tuple and releases sink resources.
+	Close() error
+}
+
+// writerSink implements a MeasureSink over an io.Writer, encoding each tuple with
+// 'enc' into a buffered writer.
+type writerSink struct {
+	mu  sync.Mutex
+	out io.Writer
+	buf *bufio.Writer
+	enc func(w io.Writer, d LatData) error
+}
+
+func (ws *writerSink) Record(d LatData) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.enc(ws.buf, d)
+}
+
+func (ws *writerSink) Flush() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.buf.Flush()
+}
+
+func (ws *writerSink) Close() error {
+	if err := ws.Flush(); err != nil {
+		return err
+	}
+	if cl, ok := ws.out.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// NewCSVSink returns a MeasureSink that writes each tuple as a "init,write,fill,persist"
+// line into 'w', the same format of the default latency output file. 'w' is closed
+// on Close if it implements io.Closer.
+func NewCSVSink(w io.Writer) MeasureSink {
+	return &writerSink{
+		out: w,
+		buf: bufio.NewWriter(w),
+		enc: func(w io.Writer, d LatData) error {
+			_, err := fmt.Fprintf(w, "%d,%d,%d,%d\n", d.Init, d.Write, d.Fill, d.Persist)
+			return err
+		},
+	}
+}
+
+// NewJSONLSink returns a MeasureSink that writes each tuple as a JSON object per line
+// into 'w'. 'w' is closed on Close if it implements io.Closer.
+func NewJSONLSink(w io.Writer) MeasureSink {
+	return &writerSink{
+		out: w,
+		buf: bufio.NewWriter(w),
+		enc: func(w io.Writer, d LatData) error {
+			return json.NewEncoder(w).Encode(d)
+		},
+	}
+}
+
+// OTLPSink is a MeasureSink that exports latency tuples as OpenTelemetry spans, encoded
+// over the OTLP/HTTP JSON protocol. Each tuple is a span starting at its Init and ending
+// at its Persist timestamps, with 'write' and 'fill' events. Spans are buffered until
+// Flush, or until 'BatchSize' spans are recorded.
+type OTLPSink struct {
+	Endpoint  string
+	Service   string
+	BatchSize int
+	Client    *http.Client
+
+	mu    sync.Mutex
+	spans []LatData
+}
+
+const defaultOTLPBatchSize = 512
+
+// NewOTLPSink returns an OTLPSink that posts spans of service 'service' to the traces
+// 'endpoint' of an OTLP collector (e.g. "http://localhost:4318/v1/traces").
+func NewOTLPSink(endpoint, service string) *OTLPSink {
+	return &OTLPSink{
+		Endpoint:  endpoint,
+		Service:   service,
+		BatchSize: defaultOTLPBatchSize,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Record ...
+func (s *OTLPSink) Record(d LatData) error {
+	s.mu.Lock()
+	s.spans = append(s.spans, d)
+	full := s.BatchSize > 0 && len(s.spans) >= s.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush posts every buffered span to the configured endpoint.
+func (s *OTLPSink) Flush() error {
+	s.mu.Lock()
+	spans := s.spans
+	s.spans = nil
+	s.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := encodeOTLPSpans(s.Service, spans)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(s.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("otlp export failed with status " + resp.Status)
+	}
+	return nil
+}
+
+// Close ...
+func (s *OTLPSink) Close() error {
+	return s.Flush()
+}
+
+type otlpAttr struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpEvent struct {
+	Name string `json:"name"`
+	Time string `json:"timeUnixNano"`
+}
+
+type otlpSpan struct {
+	TraceID string      `json:"traceId"`
+	SpanID  string      `json:"spanId"`
+	Name    string      `json:"name"`
+	Kind    int         `json:"kind"`
+	Start   string      `json:"startTimeUnixNano"`
+	End     string      `json:"endTimeUnixNano"`
+	Events  []otlpEvent `json:"events"`
+}
+
+// encodeOTLPSpans encodes 'spans' as an OTLP ExportTraceServiceRequest in JSON.
+func encodeOTLPSpans(service string, spans []LatData) ([]byte, error) {
+	otSpans := make([]otlpSpan, 0, len(spans))
+	for _, d := range spans {
+		id := make([]byte, 24)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		otSpans = append(otSpans, otlpSpan{
+			TraceID: hex.EncodeToString(id[:16]),
+			SpanID:  hex.EncodeToString(id[16:]),
+			Name:    "beelog.interval",
+			Kind:    1,
+			Start:   strconv.FormatInt(d.Init, 10),
+			End:     strconv.FormatInt(d.Persist, 10),
+			Events: []otlpEvent{
+				{Name: "write", Time: strconv.FormatInt(d.Write, 10)},
+				{Name: "fill", Time: strconv.FormatInt(d.Fill, 10)},
+			},
+		})
+	}
+
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{
+						{Key: "service.name", Value: map[string]string{"stringValue": service}},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/Lz-Gustavo/beelog"},
+						"spans": otSpans,
+					},
+				},
+			},
+		},
+	}
+	return json.Marshal(req)
+}
@@ -0,0 +1,138 @@
+package beelog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// MergeSegments combines the reduced logs persisted at 'files' (e.g. different ConcTable
+// views retrieved by 'RecovEntireLog') into a single minimal log written into 'w'. Keys
+// present on multiple segments are merged following a last-writer-wins policy over
+// command indexes, retaining only the latest state of each key, preceded by any prior
+// states it depends on (e.g. CAS chains). The merged log covers the union of all
+// segments intervals.
+func MergeSegments(files []string, w io.Writer) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no segments to merge")
+	}
+
+	var first, last uint64
+	all := make([]pb.Command, 0)
+
+	for i, fn := range files {
+		hdr, cmds, err := readSegmentFile(fn)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 || hdr.First < first {
+			first = hdr.First
+		}
+		if i == 0 || hdr.Last > last {
+			last = hdr.Last
+		}
+
+		all = append(all, cmds...)
+	}
+
+	log := mergeLatestStates(all)
+	return MarshalBufferedLogIntoWriter(w, &log, first, last)
+}
+
+// mergeLatestStates retains only the latest state of each key from 'cmds', preceded by
+// any prior states it depends on, ordered by their indexes.
+func mergeLatestStates(cmds []pb.Command) []pb.Command {
+	keys := make(map[string][]pb.Command)
+	tombs := rangeTombs{}
+	for _, c := range cmds {
+		if isWriteOp(c.Op) {
+			keys[c.Key] = append(keys[c.Key], c)
+
+		} else if isRangeDelete(c.Op) {
+			tombs.add(c)
+		}
+	}
+
+	log := make([]pb.Command, 0, len(keys))
+	for _, kc := range keys {
+		log = append(log, latestKeyChain(kc)...)
+	}
+
+	if len(tombs) > 0 {
+		sort.Slice(tombs, func(i, j int) bool { return tombs[i].Id < tombs[j].Id })
+		log = tombs.applyOn(log, 0, ^uint64(0))
+	}
+
+	sort.Slice(log, func(i, j int) bool { return log[i].Id < log[j].Id })
+	return log
+}
+
+// filterSince returns the commands of 'cmds' with indexes greater than 'id'.
+func filterSince(cmds []pb.Command, id uint64) []pb.Command {
+	log := make([]pb.Command, 0, len(cmds))
+	for _, c := range cmds {
+		if c.Id > id {
+			log = append(log, c)
+		}
+	}
+	return log
+}
+
+// filterInterval returns the commands of 'cmds' with indexes within [p, n].
+func filterInterval(cmds []pb.Command, p, n uint64) []pb.Command {
+	log := make([]pb.Command, 0, len(cmds))
+	for _, c := range cmds {
+		if c.Id >= p && c.Id <= n {
+			log = append(log, c)
+		}
+	}
+	return log
+}
+
+// latestKeyChain returns the latest state of a single key from its commands, preceded
+// by every prior state it depends on. Duplicated indexes (i.e. the same command present
+// on different segments) are only considered once.
+func latestKeyChain(cmds []pb.Command) []pb.Command {
+	sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].Id < cmds[j].Id })
+
+	chain := []pb.Command{}
+	for i, c := range cmds {
+		if i > 0 && cmds[i-1].Id == c.Id {
+			continue
+		}
+		if c.Op != pb.Command_CAS {
+			chain = chain[:0]
+		}
+		chain = append(chain, c)
+	}
+	return chain
+}
+
+func readSegmentFile(fn string) (LogHeader, []pb.Command, error) {
+	fd, err := os.OpenFile(fn, os.O_RDONLY, 0400)
+	if err != nil {
+		return LogHeader{}, nil, err
+	}
+	defer fd.Close()
+
+	rd, hdr, err := ReadLogHeader(bufio.NewReader(fd))
+	if err != nil {
+		return hdr, nil, fmt.Errorf("failed reading header of '%s', err: '%s'", fn, err.Error())
+	}
+
+	var cmds []pb.Command
+	if hdr.Len >= 0 {
+		cmds, err = unmarshalBeelog(rd, hdr.Version, hdr.Len)
+	} else {
+		cmds, err = unmarshalTradLog(rd, hdr.Version)
+	}
+	if err != nil {
+		return hdr, nil, fmt.Errorf("failed decoding '%s', err: '%s'", fn, err.Error())
+	}
+	return hdr, cmds, nil
+}
@@ -0,0 +1,152 @@
+package beelog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// errMmapUnsupported is returned by 'mmapFile' on platforms without mmap support,
+// where recovery falls back to buffered reads even if config.Mmap is set.
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// isFileStorage informs if 'st' persists segments on the local filesystem, the
+// default when no storage is configured.
+func isFileStorage(st LogStorage) bool {
+	if st == nil {
+		return true
+	}
+	switch st.(type) {
+	case *FileStorage, *DirectFileStorage:
+		return true
+	}
+	return false
+}
+
+// mappedLog is a read-only memory mapping of a persisted log state.
+type mappedLog struct {
+	data  []byte
+	unmap func() error
+}
+
+// openMappedLog maps the entire content of 'fn'. The returned mapping must be
+// released via 'close' after use, invalidating any slice of 'data'.
+func openMappedLog(fn string) (*mappedLog, error) {
+	fd, err := os.OpenFile(fn, os.O_RDONLY, 0400)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping remains valid after closing its descriptor
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// empty files cant be mapped
+	if info.Size() == 0 {
+		return &mappedLog{data: []byte{}, unmap: func() error { return nil }}, nil
+	}
+
+	data, unmap, err := mmapFile(fd, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return &mappedLog{data: data, unmap: unmap}, nil
+}
+
+func (ml *mappedLog) close() error {
+	return ml.unmap()
+}
+
+// retrieveMappedLog is analogous to 'retrieveLog', but unmarshals commands directly
+// from a memory mapping of 'fn'.
+func (ld *logData) retrieveMappedLog(fn string) ([]pb.Command, error) {
+	ml, err := openMappedLog(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer ml.close()
+	return UnmarshalLogFromBytes(ml.data)
+}
+
+// streamMappedLog is analogous to 'streamRawLog', writing a memory mapping of 'fn'
+// into 'w' without any intermediate buffer.
+func (ld *logData) streamMappedLog(w io.Writer, fn string) error {
+	ml, err := openMappedLog(fn)
+	if err != nil {
+		return err
+	}
+	defer ml.close()
+
+	_, err = w.Write(ml.data)
+	return err
+}
+
+// retrieveMappedRawLog is analogous to 'retrieveRawLog', copying a memory mapping of
+// 'fn' into a single exact sized allocation.
+func (ld *logData) retrieveMappedRawLog(fn string) ([]byte, error) {
+	ml, err := openMappedLog(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer ml.close()
+
+	raw := make([]byte, len(ml.data))
+	copy(raw, ml.data)
+	return raw, nil
+}
+
+// UnmarshalLogFromBytes is analogous to 'UnmarshalLogFromReader', but interprets an
+// already loaded log (e.g. a memory mapped file), slicing each serialized command
+// from 'log' instead of copying it into temporary buffers.
+func UnmarshalLogFromBytes(log []byte) ([]pb.Command, error) {
+	rd := bytes.NewReader(log)
+	_, hdr, err := ReadLogHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	ln := hdr.Len
+	if ln < 0 {
+		return unmarshalTradLog(rd, hdr.Version)
+	}
+
+	off := len(log) - rd.Len()
+	cmds := make([]pb.Command, 0, preallocLen(ln))
+	for j := 0; j < ln; j++ {
+		if off+4 > len(log) {
+			break
+		}
+		cmdLen := int(int32(binary.BigEndian.Uint32(log[off:])))
+		off += 4
+
+		if cmdLen < 0 || cmdLen > MaxCommandSize || off+cmdLen > len(log) {
+			return nil, fmt.Errorf("invalid command length %d at offset %d", cmdLen, off-4)
+		}
+
+		c := &pb.Command{}
+		if err := decodeCommand(hdr.Version, log[off:off+cmdLen], c); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, *c)
+		off += cmdLen
+	}
+
+	var eol string
+	_, err = fmt.Fscanf(bytes.NewReader(log[off:]), "\n%s\n", &eol)
+	if err != nil {
+		return nil, err
+	}
+
+	if eol != "EOL" {
+		return nil, fmt.Errorf("expected EOL flag, got '%s'", eol)
+	}
+	return cmds, nil
+}
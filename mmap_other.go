@@ -0,0 +1,10 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package beelog
+
+import "os"
+
+// mmapFile is unsupported on this platform, recovery falls back to buffered reads.
+func mmapFile(fd *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
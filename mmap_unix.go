@@ -0,0 +1,17 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package beelog
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps 'size' bytes of 'fd' as read-only shared memory.
+func mmapFile(fd *os.File, size int) ([]byte, func() error, error) {
+	data, err := syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
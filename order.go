@@ -0,0 +1,142 @@
+package beelog
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// ErrOutOfOrder is returned by Log procedures on RejectOutOfOrder and ReorderBuffer
+// configs, once the command Id is not greater than the latest one logged, including
+// duplicated Ids.
+var ErrOutOfOrder = errors.New("command id is out of order or duplicated")
+
+// OrderPolicy defines how commands logged with non-monotonic Ids are handled.
+type OrderPolicy int8
+
+const (
+	// UncheckedOrder records commands on their arrival order, without validating
+	// their Ids. Out of order or duplicated Ids result on undefined recoveries.
+	UncheckedOrder OrderPolicy = iota
+
+	// RejectOutOfOrder rejects commands whose Id is not greater than the latest one
+	// logged with ErrOutOfOrder.
+	RejectOutOfOrder
+
+	// OverwriteOutOfOrder overwrites the Id of commands not greater than the latest
+	// one logged by its successor, recording them as the most recent command.
+	OverwriteOutOfOrder
+
+	// ReorderBuffer retains commands logged ahead of a gap of Ids, up to
+	// config.ReorderWindow of them, recording them once the gap is filled. Once the
+	// window is exceeded, retained commands are recorded in order regardless of the
+	// gap. Retained commands are not covered by recoveries, and Ids already recorded
+	// or retained are rejected with ErrOutOfOrder.
+	ReorderBuffer
+)
+
+// sequencer applies the OrderPolicy of a structure on every logged command, recording
+// admitted ones in Id order.
+type sequencer struct {
+	policy OrderPolicy
+	window int
+
+	mu      sync.Mutex
+	started bool
+	last    uint64       // latest Id recorded
+	held    []pb.Command // ordered by Id, used only on ReorderBuffer config
+}
+
+func newSequencer(cfg *LogConfig) *sequencer {
+	return &sequencer{policy: cfg.Ordering, window: cfg.ReorderWindow}
+}
+
+// resume sets 'n' as the latest recorded Id, used once a persisted state is restored.
+func (sq *sequencer) resume(n uint64) {
+	if sq == nil {
+		return
+	}
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.started, sq.last = true, n
+}
+
+// sequence admits 'cmd' following the sequencer policy, recording every command
+// ready to be logged through 'logCmd'. A nil sequencer records 'cmd' unchecked. On
+// ReorderBuffer configs, the first failure of a previously retained command is
+// returned.
+func (sq *sequencer) sequence(ctx context.Context, cmd pb.Command, logCmd func(context.Context, pb.Command) error) error {
+	if sq == nil {
+		return logCmd(ctx, cmd)
+	}
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if !sq.started || cmd.Id > sq.last {
+		if sq.policy == ReorderBuffer && sq.started && cmd.Id != sq.last+1 {
+			return sq.hold(ctx, cmd, logCmd)
+		}
+		return sq.record(ctx, cmd, logCmd)
+	}
+
+	if sq.policy == OverwriteOutOfOrder {
+		cmd.Id = sq.last + 1
+		return sq.record(ctx, cmd, logCmd)
+	}
+	return ErrOutOfOrder
+}
+
+// record logs 'cmd', followed by every retained command made contiguous by it.
+func (sq *sequencer) record(ctx context.Context, cmd pb.Command, logCmd func(context.Context, pb.Command) error) error {
+	if err := logCmd(ctx, cmd); err != nil {
+		return err
+	}
+	sq.started, sq.last = true, cmd.Id
+
+	for len(sq.held) > 0 && sq.held[0].Id == sq.last+1 {
+		if err := sq.release(ctx, logCmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hold retains 'cmd' until its preceding gap is filled, recording the oldest retained
+// commands once config.ReorderWindow is exceeded.
+func (sq *sequencer) hold(ctx context.Context, cmd pb.Command, logCmd func(context.Context, pb.Command) error) error {
+	i := sort.Search(len(sq.held), func(i int) bool { return sq.held[i].Id >= cmd.Id })
+	if i < len(sq.held) && sq.held[i].Id == cmd.Id {
+		return ErrOutOfOrder
+	}
+	sq.held = append(sq.held, pb.Command{})
+	copy(sq.held[i+1:], sq.held[i:])
+	sq.held[i] = cmd
+
+	if len(sq.held) <= sq.window {
+		return nil
+	}
+
+	// skips the gap preceding the oldest retained command
+	if err := sq.release(ctx, logCmd); err != nil {
+		return err
+	}
+	for len(sq.held) > 0 && sq.held[0].Id == sq.last+1 {
+		if err := sq.release(ctx, logCmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release records the oldest retained command, discarding it even on failure.
+func (sq *sequencer) release(ctx context.Context, logCmd func(context.Context, pb.Command) error) error {
+	cmd := sq.held[0]
+	sq.held[0] = pb.Command{}
+	sq.held = sq.held[1:]
+
+	sq.last = cmd.Id
+	return logCmd(ctx, cmd)
+}
@@ -26,18 +26,30 @@ const (
 	Command_GET    Command_Operation = 0
 	Command_SET    Command_Operation = 1
 	Command_DELETE Command_Operation = 2
+	Command_CAS    Command_Operation = 3
+	// DELETE_PREFIX removes every key prefixed by Key, and DELETE_RANGE
+	// every key within [Key, EndKey). Both only affect states logged on
+	// prior indexes.
+	Command_DELETE_PREFIX Command_Operation = 4
+	Command_DELETE_RANGE  Command_Operation = 5
 )
 
 var Command_Operation_name = map[int32]string{
 	0: "GET",
 	1: "SET",
 	2: "DELETE",
+	3: "CAS",
+	4: "DELETE_PREFIX",
+	5: "DELETE_RANGE",
 }
 
 var Command_Operation_value = map[string]int32{
-	"GET":    0,
-	"SET":    1,
-	"DELETE": 2,
+	"GET":           0,
+	"SET":           1,
+	"DELETE":        2,
+	"CAS":           3,
+	"DELETE_PREFIX": 4,
+	"DELETE_RANGE":  5,
 }
 
 func (x Command_Operation) String() string {
@@ -49,14 +61,37 @@ func (Command_Operation) EnumDescriptor() ([]byte, []int) {
 }
 
 type Command struct {
-	Id                   uint64            `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"`
-	Ip                   string            `protobuf:"bytes,2,opt,name=Ip,proto3" json:"Ip,omitempty"`
-	Op                   Command_Operation `protobuf:"varint,3,opt,name=Op,proto3,enum=pb.Command_Operation" json:"Op,omitempty"`
-	Key                  string            `protobuf:"bytes,4,opt,name=Key,proto3" json:"Key,omitempty"`
-	Value                string            `protobuf:"bytes,5,opt,name=Value,proto3" json:"Value,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	Id    uint64            `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"`
+	Ip    string            `protobuf:"bytes,2,opt,name=Ip,proto3" json:"Ip,omitempty"`
+	Op    Command_Operation `protobuf:"varint,3,opt,name=Op,proto3,enum=pb.Command_Operation" json:"Op,omitempty"`
+	Key   string            `protobuf:"bytes,4,opt,name=Key,proto3" json:"Key,omitempty"`
+	Value string            `protobuf:"bytes,5,opt,name=Value,proto3" json:"Value,omitempty"`
+	// Expected is the value compared against the current key state on CAS
+	// operations, Value is only applied if both match.
+	Expected string `protobuf:"bytes,7,opt,name=Expected,proto3" json:"Expected,omitempty"`
+	// ExpiresAt is an optional unix timestamp, in nanoseconds, after which the
+	// key state expires and can be discarded by reduce procedures. Zero disables
+	// expiration.
+	ExpiresAt int64 `protobuf:"varint,8,opt,name=ExpiresAt,proto3" json:"ExpiresAt,omitempty"`
+	// Typed optionally carries a binary or numeric payload, avoiding its encoding
+	// into the string Value. Commands carry either a Value or a Typed payload.
+	//
+	// Types that are valid to be assigned to Typed:
+	//	*Command_BytesValue
+	//	*Command_IntValue
+	//	*Command_FloatValue
+	Typed isCommand_Typed `protobuf_oneof:"Typed"`
+	// Optional metadata of the consensus layer, never interpreted by beelog
+	// but preserved through every reduce and marshal procedure.
+	Term      uint64 `protobuf:"varint,12,opt,name=Term,proto3" json:"Term,omitempty"`
+	ClientId  string `protobuf:"bytes,13,opt,name=ClientId,proto3" json:"ClientId,omitempty"`
+	RequestId uint64 `protobuf:"varint,14,opt,name=RequestId,proto3" json:"RequestId,omitempty"`
+	// EndKey is the exclusive upper bound of the key range on DELETE_RANGE
+	// operations. An empty EndKey covers every key >= Key.
+	EndKey               string   `protobuf:"bytes,15,opt,name=EndKey,proto3" json:"EndKey,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Command) Reset()         { *m = Command{} }
@@ -119,6 +154,107 @@ func (m *Command) GetValue() string {
 	return ""
 }
 
+func (m *Command) GetExpected() string {
+	if m != nil {
+		return m.Expected
+	}
+	return ""
+}
+
+func (m *Command) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
+type isCommand_Typed interface {
+	isCommand_Typed()
+}
+
+type Command_BytesValue struct {
+	BytesValue []byte `protobuf:"bytes,9,opt,name=BytesValue,proto3,oneof"`
+}
+
+type Command_IntValue struct {
+	IntValue int64 `protobuf:"zigzag64,10,opt,name=IntValue,proto3,oneof"`
+}
+
+type Command_FloatValue struct {
+	FloatValue float64 `protobuf:"fixed64,11,opt,name=FloatValue,proto3,oneof"`
+}
+
+func (*Command_BytesValue) isCommand_Typed() {}
+
+func (*Command_IntValue) isCommand_Typed() {}
+
+func (*Command_FloatValue) isCommand_Typed() {}
+
+func (m *Command) GetTyped() isCommand_Typed {
+	if m != nil {
+		return m.Typed
+	}
+	return nil
+}
+
+func (m *Command) GetBytesValue() []byte {
+	if x, ok := m.GetTyped().(*Command_BytesValue); ok {
+		return x.BytesValue
+	}
+	return nil
+}
+
+func (m *Command) GetIntValue() int64 {
+	if x, ok := m.GetTyped().(*Command_IntValue); ok {
+		return x.IntValue
+	}
+	return 0
+}
+
+func (m *Command) GetFloatValue() float64 {
+	if x, ok := m.GetTyped().(*Command_FloatValue); ok {
+		return x.FloatValue
+	}
+	return 0
+}
+
+func (m *Command) GetTerm() uint64 {
+	if m != nil {
+		return m.Term
+	}
+	return 0
+}
+
+func (m *Command) GetClientId() string {
+	if m != nil {
+		return m.ClientId
+	}
+	return ""
+}
+
+func (m *Command) GetRequestId() uint64 {
+	if m != nil {
+		return m.RequestId
+	}
+	return 0
+}
+
+func (m *Command) GetEndKey() string {
+	if m != nil {
+		return m.EndKey
+	}
+	return ""
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Command) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Command_BytesValue)(nil),
+		(*Command_IntValue)(nil),
+		(*Command_FloatValue)(nil),
+	}
+}
+
 func init() {
 	proto.RegisterEnum("pb.Command_Operation", Command_Operation_name, Command_Operation_value)
 	proto.RegisterType((*Command)(nil), "pb.Command")
@@ -127,17 +263,27 @@ func init() {
 func init() { proto.RegisterFile("command.proto", fileDescriptor_213c0bb044472049) }
 
 var fileDescriptor_213c0bb044472049 = []byte{
-	// 179 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4d, 0xce, 0xcf, 0xcd,
-	0x4d, 0xcc, 0x4b, 0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x52, 0x5a, 0xcc,
-	0xc8, 0xc5, 0xee, 0x0c, 0x11, 0x15, 0xe2, 0xe3, 0x62, 0xf2, 0x4c, 0x91, 0x60, 0x54, 0x60, 0xd4,
-	0x60, 0x09, 0x62, 0xf2, 0x84, 0xf0, 0x0b, 0x24, 0x98, 0x14, 0x18, 0x35, 0x38, 0x83, 0x98, 0x3c,
-	0x0b, 0x84, 0x54, 0xb9, 0x98, 0xfc, 0x0b, 0x24, 0x98, 0x15, 0x18, 0x35, 0xf8, 0x8c, 0x44, 0xf5,
-	0x0a, 0x92, 0xf4, 0xa0, 0x1a, 0xf5, 0xfc, 0x0b, 0x52, 0x8b, 0x12, 0x4b, 0x32, 0xf3, 0xf3, 0x82,
-	0x98, 0xfc, 0x0b, 0x84, 0x04, 0xb8, 0x98, 0xbd, 0x53, 0x2b, 0x25, 0x58, 0xc0, 0xfa, 0x40, 0x4c,
-	0x21, 0x11, 0x2e, 0xd6, 0xb0, 0xc4, 0x9c, 0xd2, 0x54, 0x09, 0x56, 0xb0, 0x18, 0x84, 0xa3, 0xa4,
-	0xc9, 0xc5, 0x09, 0xd7, 0x28, 0xc4, 0xce, 0xc5, 0xec, 0xee, 0x1a, 0x22, 0xc0, 0x00, 0x62, 0x04,
-	0xbb, 0x86, 0x08, 0x30, 0x0a, 0x71, 0x71, 0xb1, 0xb9, 0xb8, 0xfa, 0xb8, 0x86, 0xb8, 0x0a, 0x30,
-	0x25, 0xb1, 0x81, 0x1d, 0x6c, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0x80, 0xfe, 0x3b, 0x92, 0xc1,
-	0x00, 0x00, 0x00,
+	// 345 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0x51, 0xab, 0xda, 0x30,
+	0x14, 0xc7, 0x4d, 0xda, 0x5a, 0x7b, 0xa6, 0x2e, 0x3b, 0x6c, 0x23, 0x0c, 0x1f, 0x82, 0x30, 0xe8,
+	0x53, 0x1f, 0xb6, 0x4f, 0xa0, 0x2e, 0x6a, 0xd9, 0x98, 0x23, 0x96, 0x6d, 0x6f, 0xa3, 0xda, 0x3c,
+	0x08, 0xda, 0x66, 0xb5, 0x82, 0x7e, 0xd7, 0xfb, 0x61, 0x2e, 0x49, 0x7b, 0xf5, 0xbe, 0x9d, 0xff,
+	0xef, 0xcf, 0x2f, 0x1c, 0x4e, 0x60, 0xb4, 0xaf, 0x4e, 0xa7, 0xbc, 0x2c, 0x12, 0x53, 0x57, 0x4d,
+	0x85, 0xd4, 0xec, 0xa6, 0x4f, 0x1e, 0x84, 0x8b, 0x96, 0xe2, 0x18, 0x68, 0x5a, 0x70, 0x22, 0x48,
+	0xec, 0x2b, 0x9a, 0xb6, 0xd9, 0x70, 0x2a, 0x48, 0x1c, 0x29, 0x9a, 0x1a, 0xfc, 0x0c, 0x74, 0x63,
+	0xb8, 0x27, 0x48, 0x3c, 0xfe, 0xf2, 0x21, 0x31, 0xbb, 0xa4, 0x13, 0x93, 0x8d, 0xd1, 0x75, 0xde,
+	0x1c, 0xaa, 0x52, 0xd1, 0x8d, 0x41, 0x06, 0xde, 0x77, 0x7d, 0xe3, 0xbe, 0xf3, 0xec, 0x88, 0xef,
+	0x21, 0xf8, 0x9d, 0x1f, 0x2f, 0x9a, 0x07, 0x8e, 0xb5, 0x01, 0x3f, 0xc1, 0x40, 0x5e, 0x8d, 0xde,
+	0x37, 0xba, 0xe0, 0xa1, 0x2b, 0xee, 0x19, 0x27, 0x10, 0xc9, 0xab, 0x39, 0xd4, 0xfa, 0x3c, 0x6b,
+	0xf8, 0x40, 0x90, 0xd8, 0x53, 0x0f, 0x80, 0x02, 0x60, 0x7e, 0x6b, 0xf4, 0xb9, 0x7d, 0x34, 0x12,
+	0x24, 0x1e, 0xae, 0x7b, 0xea, 0x15, 0xc3, 0x09, 0x0c, 0xd2, 0xb2, 0x69, 0x7b, 0x10, 0x24, 0xc6,
+	0x75, 0x4f, 0xdd, 0x89, 0xf5, 0x97, 0xc7, 0x2a, 0xef, 0xfa, 0x37, 0x82, 0xc4, 0xc4, 0xfa, 0x0f,
+	0x86, 0x08, 0x7e, 0xa6, 0xeb, 0x13, 0x1f, 0xba, 0x63, 0xb8, 0xd9, 0xee, 0xbb, 0x38, 0x1e, 0x74,
+	0xd9, 0xa4, 0x05, 0x1f, 0xb5, 0xfb, 0xbe, 0x64, 0xbb, 0xaf, 0xd2, 0xff, 0x2f, 0xfa, 0x6c, 0xcb,
+	0xb1, 0x93, 0x1e, 0x00, 0x3f, 0x42, 0x5f, 0x96, 0x85, 0x3d, 0xca, 0x5b, 0xe7, 0x75, 0x69, 0xfa,
+	0x07, 0xa2, 0xfb, 0xe9, 0x30, 0x04, 0x6f, 0x25, 0x33, 0xd6, 0xb3, 0xc3, 0x56, 0x66, 0x8c, 0x20,
+	0x40, 0xff, 0x9b, 0xfc, 0x21, 0x33, 0xc9, 0xa8, 0x85, 0x8b, 0xd9, 0x96, 0x79, 0xf8, 0x0e, 0x46,
+	0x2d, 0xfc, 0xf7, 0x4b, 0xc9, 0x65, 0xfa, 0x97, 0xf9, 0xc8, 0x60, 0xd8, 0x21, 0x35, 0xfb, 0xb9,
+	0x92, 0x2c, 0x98, 0x87, 0x10, 0x64, 0x37, 0xa3, 0x8b, 0x5d, 0xdf, 0xfd, 0xf4, 0xd7, 0xe7, 0x01,
+	0x00, 0xf1, 0x19, 0x64, 0x87, 0xfa, 0x01, 0x00, 0x00,
 }
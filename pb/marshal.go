@@ -0,0 +1,255 @@
+package pb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+// SizeVT returns the size of the wire encoding of the command, computed without any
+// reflection or allocation.
+func (m *Command) SizeVT() int {
+	n := 0
+	n += sizeVarintField(m.Id)
+	n += sizeBytesField(len(m.Ip))
+	n += sizeVarintField(uint64(m.Op))
+	n += sizeBytesField(len(m.Key))
+	n += sizeBytesField(len(m.Value))
+	n += sizeBytesField(len(m.Expected))
+	n += sizeVarintField(uint64(m.ExpiresAt))
+
+	switch v := m.Typed.(type) {
+	case *Command_BytesValue:
+		n += 1 + sizeVarint(uint64(len(v.BytesValue))) + len(v.BytesValue)
+	case *Command_IntValue:
+		n += 1 + sizeVarint(zigzag(v.IntValue))
+	case *Command_FloatValue:
+		n += 1 + 8
+	}
+
+	n += sizeVarintField(m.Term)
+	n += sizeBytesField(len(m.ClientId))
+	n += sizeVarintField(m.RequestId)
+	n += sizeBytesField(len(m.EndKey))
+	return n + len(m.XXX_unrecognized)
+}
+
+// MarshalAppendVT appends the wire encoding of the command into 'b', returning the
+// extended slice. The encoding is identical to proto.Marshal, with regular fields
+// ordered by their numbers followed by the oneof payload, but avoids the reflective
+// wrapping of each message, allocating only if 'b' lacks capacity (see 'SizeVT').
+func (m *Command) MarshalAppendVT(b []byte) []byte {
+	b = appendVarintField(b, 1, m.Id)
+	b = appendBytesField(b, 2, m.Ip)
+	b = appendVarintField(b, 3, uint64(m.Op))
+	b = appendBytesField(b, 4, m.Key)
+	b = appendBytesField(b, 5, m.Value)
+	b = appendBytesField(b, 7, m.Expected)
+	b = appendVarintField(b, 8, uint64(m.ExpiresAt))
+	b = appendVarintField(b, 12, m.Term)
+	b = appendBytesField(b, 13, m.ClientId)
+	b = appendVarintField(b, 14, m.RequestId)
+	b = appendBytesField(b, 15, m.EndKey)
+
+	// oneof fields are encoded whenever set, even with zero values, and after every
+	// regular field as done by the generated message info
+	switch v := m.Typed.(type) {
+	case *Command_BytesValue:
+		b = append(b, 9<<3|2)
+		b = appendVarint(b, uint64(len(v.BytesValue)))
+		b = append(b, v.BytesValue...)
+	case *Command_IntValue:
+		b = append(b, 10<<3)
+		b = appendVarint(b, zigzag(v.IntValue))
+	case *Command_FloatValue:
+		b = append(b, 11<<3|1)
+		var raw [8]byte
+		binary.LittleEndian.PutUint64(raw[:], math.Float64bits(v.FloatValue))
+		b = append(b, raw[:]...)
+	}
+	return append(b, m.XXX_unrecognized...)
+}
+
+// every field number is below 16, encoded into a single byte tag
+
+func sizeVarint(v uint64) int {
+	return (bits.Len64(v|1) + 6) / 7
+}
+
+func sizeVarintField(v uint64) int {
+	if v == 0 {
+		return 0
+	}
+	return 1 + sizeVarint(v)
+}
+
+func sizeBytesField(l int) int {
+	if l == 0 {
+		return 0
+	}
+	return 1 + sizeVarint(uint64(l)) + l
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, num byte, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(append(b, num<<3), v)
+}
+
+func appendBytesField(b []byte, num byte, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	b = appendVarint(append(b, num<<3|2), uint64(len(s)))
+	return append(b, s...)
+}
+
+// errTruncated is returned when decoding incomplete wire encodings.
+var errTruncated = errors.New("pb: truncated command encoding")
+
+// UnmarshalVT decodes the wire encoding 'b' into the command, resetting it first.
+// It's the counterpart of 'MarshalAppendVT', decoding without reflection.
+func (m *Command) UnmarshalVT(b []byte) error {
+	return m.unmarshal(b, false)
+}
+
+// UnmarshalVTUnsafe is analogous to 'UnmarshalVT', but string and bytes fields alias
+// 'b' instead of being copied, avoiding an allocation per field. 'b' must never be
+// modified while the command is in use.
+func (m *Command) UnmarshalVTUnsafe(b []byte) error {
+	return m.unmarshal(b, true)
+}
+
+func (m *Command) unmarshal(b []byte, alias bool) error {
+	*m = Command{}
+	str := func(v []byte) string {
+		if alias {
+			return unsafeString(v)
+		}
+		return string(v)
+	}
+
+	for len(b) > 0 {
+		tag, n := decodeVarint(b)
+		if n == 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		num, typ := tag>>3, tag&7
+
+		switch typ {
+		case 0:
+			v, n := decodeVarint(b)
+			if n == 0 {
+				return errTruncated
+			}
+			b = b[n:]
+
+			switch num {
+			case 1:
+				m.Id = v
+			case 3:
+				m.Op = Command_Operation(v)
+			case 8:
+				m.ExpiresAt = int64(v)
+			case 10:
+				m.Typed = &Command_IntValue{IntValue: int64(v>>1) ^ -int64(v&1)}
+			case 12:
+				m.Term = v
+			case 14:
+				m.RequestId = v
+			default:
+				m.XXX_unrecognized = appendVarint(appendVarint(m.XXX_unrecognized, tag), v)
+			}
+
+		case 1:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			if num == 11 {
+				m.Typed = &Command_FloatValue{FloatValue: math.Float64frombits(binary.LittleEndian.Uint64(b))}
+			} else {
+				m.XXX_unrecognized = append(appendVarint(m.XXX_unrecognized, tag), b[:8]...)
+			}
+			b = b[8:]
+
+		case 2:
+			l, n := decodeVarint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				return errTruncated
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+
+			switch num {
+			case 2:
+				m.Ip = str(v)
+			case 4:
+				m.Key = str(v)
+			case 5:
+				m.Value = str(v)
+			case 7:
+				m.Expected = str(v)
+			case 9:
+				if !alias {
+					v = append([]byte{}, v...)
+				}
+				m.Typed = &Command_BytesValue{BytesValue: v}
+			case 13:
+				m.ClientId = str(v)
+			case 15:
+				m.EndKey = str(v)
+			default:
+				m.XXX_unrecognized = append(appendVarint(appendVarint(m.XXX_unrecognized, tag), l), v...)
+			}
+
+		case 5:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			m.XXX_unrecognized = append(appendVarint(m.XXX_unrecognized, tag), b[:4]...)
+			b = b[4:]
+
+		default:
+			return fmt.Errorf("pb: unsupported wire type %d on field %d", typ, num)
+		}
+	}
+	return nil
+}
+
+// decodeVarint returns the varint encoded at the beginning of 'b', and its length in
+// bytes. A zero length is returned on truncated or overflowing encodings.
+func decodeVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// unsafeString returns a string sharing the memory of 'b'.
+func unsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,54 @@
+package pb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// HasTypedValue informs if the command carries a Typed payload instead of the
+// string Value.
+func (m *Command) HasTypedValue() bool {
+	return m.GetTyped() != nil
+}
+
+// ValueBytes returns the command payload as raw bytes, regardless of its type.
+// Numeric payloads are encoded as 8-byte big-endian values.
+func (m *Command) ValueBytes() []byte {
+	switch v := m.GetTyped().(type) {
+	case *Command_BytesValue:
+		return v.BytesValue
+
+	case *Command_IntValue:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(v.IntValue))
+		return b
+
+	case *Command_FloatValue:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v.FloatValue))
+		return b
+
+	default:
+		return []byte(m.GetValue())
+	}
+}
+
+// ValueString returns a printable representation of the command payload, regardless
+// of its type. Binary payloads are base64 encoded.
+func (m *Command) ValueString() string {
+	switch v := m.GetTyped().(type) {
+	case *Command_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+
+	case *Command_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+
+	case *Command_FloatValue:
+		return strconv.FormatFloat(v.FloatValue, 'g', -1, 64)
+
+	default:
+		return m.GetValue()
+	}
+}
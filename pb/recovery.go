@@ -0,0 +1,38 @@
+package pb
+
+// RecovRequest is the Go counterpart of the RecovRequest message on recovery.proto,
+// encoded without reflection.
+type RecovRequest struct {
+	First uint64
+	Last  uint64
+}
+
+// SizeVT returns the size of the wire encoding of the request.
+func (m *RecovRequest) SizeVT() int {
+	return sizeVarintField(m.First) + sizeVarintField(m.Last)
+}
+
+// MarshalAppendVT appends the wire encoding of the request into 'b', returning the
+// extended slice.
+func (m *RecovRequest) MarshalAppendVT(b []byte) []byte {
+	b = appendVarintField(b, 1, m.First)
+	return appendVarintField(b, 2, m.Last)
+}
+
+// UnmarshalVT decodes the wire encoding 'b' into the request, resetting it first.
+// Unknown fields are discarded.
+func (m *RecovRequest) UnmarshalVT(b []byte) error {
+	*m = RecovRequest{}
+	return forEachField(b, func(num uint64, v uint64, raw []byte) error {
+		if raw != nil {
+			return nil
+		}
+		switch num {
+		case 1:
+			m.First = v
+		case 2:
+			m.Last = v
+		}
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package pb
+
+import "fmt"
+
+// LogSegment is the Go counterpart of the LogSegment message on segment.proto, encoded
+// without reflection through the same procedures of Command's 'MarshalAppendVT'.
+type LogSegment struct {
+	First    uint64
+	Last     uint64
+	N        uint64
+	Commands []Command
+}
+
+// SizeVT returns the size of the wire encoding of the segment.
+func (m *LogSegment) SizeVT() int {
+	n := sizeVarintField(m.First) + sizeVarintField(m.Last) + sizeVarintField(m.N)
+	for i := range m.Commands {
+		n += 1 + sizeBytesLen(m.Commands[i].SizeVT())
+	}
+	return n
+}
+
+// MarshalAppendVT appends the wire encoding of the segment into 'b', returning the
+// extended slice.
+func (m *LogSegment) MarshalAppendVT(b []byte) []byte {
+	b = appendVarintField(b, 1, m.First)
+	b = appendVarintField(b, 2, m.Last)
+	b = appendVarintField(b, 3, m.N)
+
+	// repeated messages are always encoded, even if empty
+	for i := range m.Commands {
+		b = append(b, 4<<3|2)
+		b = appendVarint(b, uint64(m.Commands[i].SizeVT()))
+		b = m.Commands[i].MarshalAppendVT(b)
+	}
+	return b
+}
+
+// UnmarshalVT decodes the wire encoding 'b' into the segment, resetting it first.
+// Unknown fields are discarded.
+func (m *LogSegment) UnmarshalVT(b []byte) error {
+	*m = LogSegment{}
+	return forEachField(b, func(num uint64, v uint64, raw []byte) error {
+		switch num {
+		case 1:
+			m.First = v
+		case 2:
+			m.Last = v
+		case 3:
+			m.N = v
+		case 4:
+			if raw == nil {
+				return nil
+			}
+			m.Commands = append(m.Commands, Command{})
+			return m.Commands[len(m.Commands)-1].UnmarshalVT(raw)
+		}
+		return nil
+	})
+}
+
+// forEachField calls 'fn' for each field of the wire encoding 'b', with the decoded
+// value of varint fields or the content of length-delimited ones, where 'raw' is nil.
+// Fixed-size fields are skipped.
+func forEachField(b []byte, fn func(num uint64, v uint64, raw []byte) error) error {
+	for len(b) > 0 {
+		tag, n := decodeVarint(b)
+		if n == 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		num, typ := tag>>3, tag&7
+
+		switch typ {
+		case 0:
+			v, n := decodeVarint(b)
+			if n == 0 {
+				return errTruncated
+			}
+			b = b[n:]
+			if err := fn(num, v, nil); err != nil {
+				return err
+			}
+
+		case 1:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+
+		case 2:
+			l, n := decodeVarint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				return errTruncated
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := fn(num, 0, v); err != nil {
+				return err
+			}
+
+		case 5:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+
+		default:
+			return fmt.Errorf("pb: unsupported wire type %d on field %d", typ, num)
+		}
+	}
+	return nil
+}
+
+// sizeBytesLen returns the size of a length-delimited payload of 'l' bytes, without
+// its tag.
+func sizeBytesLen(l int) int {
+	return sizeVarint(uint64(l)) + l
+}
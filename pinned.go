@@ -0,0 +1,71 @@
+package beelog
+
+import (
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// PinKeys returns a filter matching only the provided keys, suited for pinning a
+// small set of keys (e.g. configuration or membership) on 'config.Pinned'.
+func PinKeys(keys ...string) KeyFilter {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return func(key string) bool {
+		_, ok := set[key]
+		return ok
+	}
+}
+
+// pinnedLog records every write over pinned keys (see 'config.Pinned'), on their
+// index order, retained during the entire structure lifetime.
+type pinnedLog struct {
+	mu   sync.Mutex
+	cmds []pb.Command
+}
+
+// recordPinned records 'cmd' on the pinned history if it writes a pinned key.
+func (ld *logData) recordPinned(cmd *pb.Command) {
+	if ld.pins == nil || !isWriteOp(cmd.Op) || !ld.config.Pinned(cmd.Key) {
+		return
+	}
+	ld.pins.mu.Lock()
+	ld.pins.cmds = append(ld.pins.cmds, *cmd)
+	ld.pins.mu.Unlock()
+}
+
+// withPinnedHistory replaces the reduced states of pinned keys on 'log' by their full
+// update history within [p, n], except for updates discarded by a later range delete.
+func (ld *logData) withPinnedHistory(log []pb.Command, p, n uint64) []pb.Command {
+	if ld.pins == nil {
+		return log
+	}
+	tombs := make([]pb.Command, 0, len(ld.tombs))
+	for _, t := range ld.tombs {
+		if t.Id >= p && t.Id <= n {
+			tombs = append(tombs, t)
+		}
+	}
+
+	ld.pins.mu.Lock()
+	hist := make([]pb.Command, 0)
+	for _, c := range ld.pins.cmds {
+		if c.Id >= p && c.Id <= n && !deletedByRange(tombs, &c) {
+			hist = append(hist, c)
+		}
+	}
+	ld.pins.mu.Unlock()
+
+	if len(hist) == 0 {
+		return log
+	}
+	cmds := make([]pb.Command, 0, len(log)+len(hist))
+	for _, c := range log {
+		if isRangeDelete(c.Op) || !ld.config.Pinned(c.Key) {
+			cmds = append(cmds, c)
+		}
+	}
+	return append(cmds, hist...)
+}
@@ -0,0 +1,106 @@
+package beelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// MarshalProtoLogIntoWriter is analogous to 'MarshalLogIntoWriter', but follows the
+// pure protobuf format (i.e. ProtoLogFormatVersion): the format magic and version,
+// followed by a single varint length-delimited pb.LogSegment with the [p, n] interval
+// and every command of 'log'. Additional segments may be appended to the same writer
+// through 'AppendProtoSegment'.
+func MarshalProtoLogIntoWriter(logWr io.Writer, log *[]pb.Command, p, n uint64) error {
+	hdr := append(append([]byte(nil), logFormatMagic...), ProtoLogFormatVersion, '\n')
+	if _, err := logWr.Write(hdr); err != nil {
+		return err
+	}
+	return AppendProtoSegment(logWr, log, p, n)
+}
+
+// AppendProtoSegment writes a varint length-delimited pb.LogSegment with the [p, n]
+// interval and every command of 'log' into 'logWr', without any format header.
+func AppendProtoSegment(logWr io.Writer, log *[]pb.Command, p, n uint64) error {
+	seg := pb.LogSegment{First: p, Last: n, N: uint64(len(*log)), Commands: *log}
+	size := seg.SizeVT()
+
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+size)
+	b = seg.MarshalAppendVT(b[:binary.PutUvarint(b, uint64(size))])
+	_, err := logWr.Write(b)
+	return err
+}
+
+// UnmarshalProtoLog returns every segment of the ProtoLogFormatVersion log contained
+// at 'logRd', in the order they were written. Segments whose number of commands does
+// not match their 'N' field, or truncated ones, are reported as errors.
+func UnmarshalProtoLog(logRd io.Reader) ([]pb.LogSegment, error) {
+	logRd, hdr, err := readLogVersion(logRd)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Version != ProtoLogFormatVersion {
+		return nil, fmt.Errorf("expected log format version %d, got %d", ProtoLogFormatVersion, hdr.Version)
+	}
+	return readProtoSegments(logRd)
+}
+
+// unmarshalProtoCommands returns the commands of every segment from 'rd', positioned
+// after the format header.
+func unmarshalProtoCommands(rd io.Reader) ([]pb.Command, error) {
+	segs, err := readProtoSegments(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) == 1 {
+		return segs[0].Commands, nil
+	}
+
+	var cmds []pb.Command
+	for _, s := range segs {
+		cmds = append(cmds, s.Commands...)
+	}
+	return cmds, nil
+}
+
+// readProtoSegments reads varint length-delimited segments from 'rd' until EOF.
+func readProtoSegments(rd io.Reader) ([]pb.LogSegment, error) {
+	br, ok := rd.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(rd)
+		br, rd = b, b
+	}
+
+	var segs []pb.LogSegment
+	for i := 0; ; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return segs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read the length of segment %d: %s", i, err.Error())
+		}
+		if size > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid length %d of segment %d", size, i)
+		}
+
+		// segments are read as they arrive, never preallocating from untrusted lengths
+		raw := bytes.NewBuffer(nil)
+		if _, err := io.CopyN(raw, rd, int64(size)); err != nil {
+			return nil, fmt.Errorf("truncated segment %d, expected %d bytes but got %d", i, size, raw.Len())
+		}
+
+		var seg pb.LogSegment
+		if err := seg.UnmarshalVT(raw.Bytes()); err != nil {
+			return nil, err
+		}
+		if seg.N != uint64(len(seg.Commands)) {
+			return nil, fmt.Errorf("expected segment %d with %d commands, but got %d", i, seg.N, len(seg.Commands))
+		}
+		segs = append(segs, seg)
+	}
+}
@@ -0,0 +1,96 @@
+package beelog
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// liveState informs if 'cmd', the latest state of its key, is visible to Get and
+// Keys queries: neither discarded by a later range delete on 'tombs', nor expired
+// on DropExpired configs.
+func (ld *logData) liveState(cmd *pb.Command, tombs []pb.Command) bool {
+	if deletedByRange(tombs, cmd) {
+		return false
+	}
+	if ld.config.DropExpired && cmd.ExpiresAt != 0 && cmd.ExpiresAt <= time.Now().UnixNano() {
+		return false
+	}
+	return true
+}
+
+// visibleState returns 'cmd' if it's 'live', or an empty command otherwise.
+func visibleState(cmd *pb.Command, live bool) (pb.Command, bool) {
+	if !live {
+		return pb.Command{}, false
+	}
+	return *cmd, true
+}
+
+// latest returns the most recent state logged for 'key' on the table, if any.
+func (ht stateTable) latest(key string) (*pb.Command, bool) {
+	l, ok := ht[key]
+	if !ok || l.tail == nil {
+		return nil, false
+	}
+	return &l.tail.val.(*State).cmd, true
+}
+
+// popState removes the oldest state logged for 'key', discarding the key once it
+// retains no state.
+func (ht stateTable) popState(key string) {
+	l, ok := ht[key]
+	if !ok {
+		return
+	}
+	if l.pop(); l.len == 0 {
+		delete(ht, key)
+	}
+}
+
+// getState returns the latest visible state of 'key' on 'ht'.
+func (ld *logData) getState(ht stateTable, key string) (pb.Command, bool) {
+	cmd, ok := ht.latest(key)
+	if !ok {
+		return pb.Command{}, false
+	}
+	return visibleState(cmd, ld.liveState(cmd, ld.tombs))
+}
+
+// stateKeys returns every key with a visible state on 'ht', in ascending order.
+func (ld *logData) stateKeys(ht stateTable) []string {
+	keys := make([]string, 0, len(ht))
+	for k := range ht {
+		if cmd, ok := ht.latest(k); ok && ld.liveState(cmd, ld.tombs) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// minStateKeys returns every key with a visible state on 'tbl', in ascending order.
+func (ld *logData) minStateKeys(tbl minStateTable) []string {
+	keys := make([]string, 0, len(tbl))
+	for k, st := range tbl {
+		if ld.liveState(&st.cmd, ld.tombs) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// searchRun returns the latest state of 'key' on the sorted run 'cmds', where each
+// key retains only its latest state, preceded by any prior states it depends on.
+func searchRun(cmds []pb.Command, key string) (*pb.Command, bool) {
+	i := sort.Search(len(cmds), func(i int) bool { return cmds[i].Key >= key })
+	if i == len(cmds) || cmds[i].Key != key {
+		return nil, false
+	}
+	for i+1 < len(cmds) && cmds[i+1].Key == key {
+		i++
+	}
+	return &cmds[i], true
+}
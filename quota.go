@@ -0,0 +1,109 @@
+package beelog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// diskQuota enforces config.MaxDiskBytes over the segments indexed by a structure.
+type diskQuota struct {
+	mu sync.Mutex // serializes evictions
+
+	// excludes recoveries of the entire log while segments are removed, if set
+	seg sync.Locker
+}
+
+// mayEvictSegments removes the oldest segments entirely shadowed by newer ones, as
+// on ConcTable's CollectSegments, once the segments indexed on the manifest exceed
+// config.MaxDiskBytes, until their size is within it. Segments holding the latest
+// state of any key, or range deletes, are never evicted, thus the quota may remain
+// exceeded. Each evicted segment is informed to 'Hooks.OnEvict'.
+func (ld *logData) mayEvictSegments() error {
+	if ld.quota == nil || ld.idx == nil {
+		return nil
+	}
+	ld.quota.mu.Lock()
+	defer ld.quota.mu.Unlock()
+
+	st := ld.storage()
+	segs, err := ld.idx.overlapping(st, 0, ^uint64(0))
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make(map[string]int64, len(segs))
+	for _, sg := range segs {
+		sz, err := st.Size(sg.Name)
+		if err != nil {
+			return fmt.Errorf("failed retrieving size of segment '%s', err: '%s'", sg.Name, err.Error())
+		}
+		sizes[sg.Name] = sz
+		total += sz
+	}
+	if total <= ld.config.MaxDiskBytes {
+		return nil
+	}
+
+	// newest segments first, shadowing the keys of older ones
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].Last > segs[j].Last })
+	seen := make(map[string]struct{})
+	var shadowed []segmentInterval
+	for _, sg := range segs {
+		cmds, _, err := ld.readPersistedSegment(sg.Name)
+		if err != nil {
+			return err
+		}
+		ok := true
+		for _, c := range cmds {
+			if isRangeDelete(c.Op) {
+				ok = false
+			}
+			if _, found := seen[c.Key]; !found {
+				ok = false
+				seen[c.Key] = struct{}{}
+			}
+		}
+		if ok {
+			shadowed = append(shadowed, sg)
+		}
+	}
+
+	// oldest shadowed segments are evicted first
+	var evicted []segmentInterval
+	for i := len(shadowed) - 1; i >= 0 && total > ld.config.MaxDiskBytes; i-- {
+		evicted = append(evicted, shadowed[i])
+		total -= sizes[shadowed[i].Name]
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+
+	if ld.quota.seg != nil {
+		ld.quota.seg.Lock()
+		defer ld.quota.seg.Unlock()
+	}
+
+	names := make([]string, 0, len(evicted))
+	for _, sg := range evicted {
+		names = append(names, sg.Name)
+	}
+	if err := ld.idx.remove(st, names); err != nil {
+		return err
+	}
+	for _, sg := range evicted {
+		if err := st.Delete(sg.Name); err != nil {
+			return err
+		}
+
+		// mirrored copies are not recorded on the manifest, and may be already missing
+		if ld.config.ParallelMode == Mirror {
+			for _, fn := range ld.config.diskFnames()[1:] {
+				st.Delete(segmentFname(fn, sg.First, sg.Last, ld.config.Naming))
+			}
+		}
+		ld.hookEvict(sg.Name, sizes[sg.Name])
+	}
+	return nil
+}
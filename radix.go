@@ -0,0 +1,580 @@
+package beelog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// radixNode is a node of a compressed radix tree, where 'label' is the portion of
+// the key on the edge from its parent. Children are sorted by their labels, which
+// never share a common first byte.
+type radixNode struct {
+	label    string
+	children []*radixNode
+
+	// latest state of the key ending on this node, nil if none
+	st *State
+}
+
+// child returns the child whose label starts with 'b', and its position on the
+// children slice, or the position it should be inserted if none is found.
+func (nd *radixNode) child(b byte) (*radixNode, int) {
+	i := sort.Search(len(nd.children), func(i int) bool {
+		return nd.children[i].label[0] >= b
+	})
+	if i < len(nd.children) && nd.children[i].label[0] == b {
+		return nd.children[i], i
+	}
+	return nil, i
+}
+
+// RadixHT stores the latest state of each key on a compressed radix tree, where keys
+// sharing a common prefix (e.g. 'user:123:cart' and 'user:123:name') store it only
+// once. Workloads over hierarchical keys retain less memory than on flat hash tables,
+// and keys under a particular prefix can be recovered without visiting the entire
+// structure.
+type RadixHT struct {
+	root *radixNode
+	keys int
+	len  uint64
+	mu   sync.Mutex
+	canc context.CancelFunc
+	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
+}
+
+// NewRadixHT ...
+func NewRadixHT() *RadixHT {
+	cfg := DefaultLogConfig()
+	cfg.Alg = IterRadix
+
+	rt := &RadixHT{
+		root:    &radixNode{},
+		logData: newLogData(cfg),
+	}
+	rt.ctx, rt.canc = context.WithCancel(context.Background())
+	return rt
+}
+
+// NewRadixHTWithConfig ...
+func NewRadixHTWithConfig(cfg *LogConfig) (*RadixHT, error) {
+	err := cfg.ValidateConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHistorySupported(cfg, RadixKind); err != nil {
+		return nil, err
+	}
+
+	rt := &RadixHT{
+		root:    &radixNode{},
+		logData: newLogData(cfg),
+	}
+
+	if err := rt.restoreOnInit(rt.logCtx); err != nil {
+		return nil, err
+	}
+	if err := rt.restoreJournal(rt.LogCtx); err != nil {
+		return nil, err
+	}
+
+	rt.ctx, rt.canc = context.WithCancel(context.Background())
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(rt.ctx, cfg, &rt.tick, rt.reduceOnTick)
+	}
+	return rt, nil
+}
+
+// Str returns a string representation of every key on the tree, on lexicographical
+// order, and the index of its latest state, used for debug purposes.
+func (rt *RadixHT) Str() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	var strs []string
+	walkRadixNode(rt.root, "", func(key string, st *State) {
+		strs = append(strs, fmt.Sprintf("(%v|%v)->", st.ind, key))
+	})
+	return strings.Join(strs, " ")
+}
+
+// Len returns the number of write commands logged on the structure.
+func (rt *RadixHT) Len() uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.length()
+}
+
+func (rt *RadixHT) length() uint64 {
+	return rt.len
+}
+
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (rt *RadixHT) FirstIndex() uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (rt *RadixHT) LastIndex() uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (rt *RadixHT) Debug() DebugInfo {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.debugInfo("radix", rt.len)
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (rt *RadixHT) Stats() (Stats, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	st, err := rt.baseStats()
+	st.Keys = rt.keys
+	st.MemBytes = radixMemBytes(rt.root)
+	return st, err
+}
+
+// Log records the occurence of command 'cmd' on the provided index. Writes replace the
+// latest state of their key on the tree, splitting any edge that only partially matches
+// the key.
+func (rt *RadixHT) Log(cmd pb.Command) error {
+	return rt.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (rt *RadixHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return rt.seq.sequence(ctx, cmd, rt.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (rt *RadixHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.ignoresRead(&cmd) {
+		return nil
+	}
+	rt.countLogged(&cmd)
+	rt.recordPinned(&cmd)
+
+	if err := rt.journalCommand(cmd); err != nil {
+		return err
+	}
+
+	if isRangeDelete(cmd.Op) {
+		// matching states are only discarded during reduce
+		rt.tombs.add(cmd)
+		rt.last = cmd.Id
+		if rt.config.Tick == Immediately {
+			return rt.reduceLogCtx(ctx, rt.first, rt.last)
+		}
+		return rt.mayTriggerReduce(ctx)
+	}
+
+	if !isWriteOp(cmd.Op) {
+		rt.attributeFirst(cmd.Id)
+		rt.last = cmd.Id
+		return rt.mayTriggerReduce(ctx)
+	}
+
+	st := &State{
+		ind: cmd.Id,
+		cmd: cmd,
+	}
+
+	nd := rt.insert(cmd.Key)
+	if nd.st == nil {
+		rt.keys++
+
+		// conditional updates must retain the state they were applied over
+	} else if st.dependsOnPrior() {
+		st.prev = nd.st
+	}
+	nd.st = st
+
+	// adjust first structure index
+	rt.attributeFirst(cmd.Id)
+	rt.last = cmd.Id
+	rt.len++
+
+	// Immediately recovery entirely reduces the log to its minimal format
+	if rt.config.Tick == Immediately {
+		return rt.reduceLogCtx(ctx, rt.first, rt.last)
+	}
+	return rt.mayTriggerReduce(ctx)
+}
+
+// Recov returns a compacted log of commands. Since only the latest state of each key
+// is retained, the entire reduced log is always returned, independently of the
+// requested [p, n] interval. On persistent configuration (i.e. 'inmem' false) the
+// entire log is loaded and then unmarshaled, consider using 'RecovBytes' calls instead.
+func (rt *RadixHT) Recov(p, n uint64) ([]pb.Command, error) {
+	return rt.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (rt *RadixHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	p, n, err := rt.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rt.hookRecovery(p, n)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if err := rt.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return nil, err
+	}
+	return rt.retrieveLogCtx(ctx)
+}
+
+// RecovPrefix returns the latest state of every key starting with 'prefix', visiting
+// only the subtree under it. States are read directly from the tree, so the recovered
+// log always reflects every logged command, independently of the reduce config.
+func (rt *RadixHT) RecovPrefix(p, n uint64, prefix string) ([]pb.Command, error) {
+	p, n, err := rt.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	rt.hookRecovery(p, n)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return IterRadixHTPrefix(rt, prefix), nil
+}
+
+// RecovBytes returns an already serialized log, parsed from persistent storage
+// or marshaled from the in-memory state. Its the most efficient approach on persistent
+// configuration, avoiding an extra marshaling step during recovery. The command
+// interpretation from the byte stream follows a simple slicing protocol, where
+// the size of each command is binary encoded before the raw pbuff.
+func (rt *RadixHT) RecovBytes(p, n uint64) ([]byte, error) {
+	p, n, err := rt.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+	rt.hookRecovery(p, n)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if err := rt.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return nil, err
+	}
+	return rt.retrieveRawLog(p, n)
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (rt *RadixHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := rt.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	rt.hookRecovery(p, n)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if err := rt.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return rt.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix). Consider 'RecovPrefix' for prefix filters, which avoids
+// visiting keys outside the prefix.
+func (rt *RadixHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := rt.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (rt *RadixHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := rt.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (rt *RadixHT) RecovSince(id uint64) ([]pb.Command, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.length() == 0 || id >= rt.last {
+		return []pb.Command{}, nil
+	}
+	cmds := rt.applyRangeDeletes(IterRadixHT(rt), rt.first, rt.last)
+	return filterSince(cmds, id), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (rt *RadixHT) Snapshot() (*Snapshot, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.length() == 0 {
+		return newSnapshot(rt.last, nil), nil
+	}
+	cmds := rt.applyRangeDeletes(IterRadixHT(rt), rt.first, rt.last)
+	return newSnapshot(rt.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (rt *RadixHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := rt.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written, or if its state was discarded by a later range
+// delete.
+func (rt *RadixHT) Get(key string) (pb.Command, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	nd, path := rt.searchPrefix(key)
+	if nd == nil || path != key || nd.st == nil || !rt.liveState(&nd.st.cmd, rt.tombs) {
+		return pb.Command{}, false
+	}
+	return nd.st.cmd, true
+}
+
+// Keys returns every key with a state retained on the tree, in ascending order.
+func (rt *RadixHT) Keys() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	keys := make([]string, 0, rt.keys)
+	walkRadixNode(rt.root, "", func(key string, st *State) {
+		if rt.liveState(&st.cmd, rt.tombs) {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// ReduceLog applies the configured reduce algorithm and updates the current log state.
+// Must only be called within mutual exclusion scope.
+func (rt *RadixHT) ReduceLog(p, n uint64) error {
+	return rt.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (rt *RadixHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := rt.hookReduceStart(p, n)
+	cmds, err := rt.applyReduce(rt, p, n)
+	rt.hookReduceDone(p, n, start, len(cmds), err)
+	if err != nil {
+		return err
+	}
+	return rt.updateLogStateCtx(ctx, cmds, p, n, 0)
+}
+
+// retainedStates returns the latest state of every key, reduced by registered reducers.
+// Must only be called within mutual exclusion scope.
+func (rt *RadixHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return IterRadixHT(rt), nil
+}
+
+// mayTriggerReduce possibly triggers the reduce algorithm based on config params
+// (e.g. interval period reached). Must only be called within mutual exclusion scope.
+func (rt *RadixHT) mayTriggerReduce(ctx context.Context) error {
+	if rt.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		rt.count++
+		return nil
+	}
+	if !rt.config.Tick.isPeriodic() {
+		return nil
+	}
+	if rt.reachedReducePeriod(rt.keys) {
+		return rt.reduceLogCtx(ctx, rt.first, rt.last)
+	}
+	return nil
+}
+
+// mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
+// 'config.Period' wasnt reached yet.
+func (rt *RadixHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
+	if rt.config.Tick == Delayed {
+		err := rt.reduceLogCtx(ctx, p, n)
+		if err != nil {
+			return err
+		}
+
+	} else if rt.config.Tick.isScheduled() && !rt.firstReduceExists() {
+		err := rt.reduceLogCtx(ctx, rt.first, rt.last)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (rt *RadixHT) reduceOnTick() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.count == 0 {
+		return
+	}
+
+	// on failure, the counter is retained to retry on the next tick
+	err := rt.config.Retry.do(context.Background(), func() error {
+		return rt.ReduceLog(rt.first, rt.last)
+	})
+	if err != nil {
+		rt.reportReduceErr(err)
+		return
+	}
+	rt.count = 0
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once any in-flight reduce is finished.
+func (rt *RadixHT) SetConfig(cfg *LogConfig) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.reconfigure(rt.ctx, cfg, &rt.tick, rt.reduceOnTick)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (rt *RadixHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	cfg := rt.config.clone()
+	fn(cfg)
+	return rt.reconfigure(rt.ctx, cfg, &rt.tick, rt.reduceOnTick)
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, and flushes
+// any pending group commit.
+func (rt *RadixHT) Shutdown() {
+	if rt.canc != nil {
+		rt.canc()
+	}
+	if rt.gc != nil {
+		rt.gc.close()
+	}
+	rt.closeJournal()
+}
+
+// insert returns the node of 'key', creating it if necessary. An edge sharing only
+// part of its label with 'key' is split on a new intermediate node.
+func (rt *RadixHT) insert(key string) *radixNode {
+	cur, rest := rt.root, key
+	for rest != "" {
+		c, i := cur.child(rest[0])
+		if c == nil {
+			nd := &radixNode{label: rest}
+			cur.children = append(cur.children, nil)
+			copy(cur.children[i+1:], cur.children[i:])
+			cur.children[i] = nd
+			return nd
+		}
+
+		l := commonPrefixLen(rest, c.label)
+		if l < len(c.label) {
+			// split the edge, the new node inherits the same first byte of 'c'
+			mid := &radixNode{
+				label:    c.label[:l],
+				children: []*radixNode{c},
+			}
+			c.label = c.label[l:]
+			cur.children[i] = mid
+			c = mid
+		}
+		cur, rest = c, rest[l:]
+	}
+	return cur
+}
+
+// searchPrefix returns the root of the subtree containing every key starting with
+// 'prefix', and the entire key leading to it, or nil if no key starts with 'prefix'.
+func (rt *RadixHT) searchPrefix(prefix string) (*radixNode, string) {
+	cur, path, rest := rt.root, "", prefix
+	for rest != "" {
+		c, _ := cur.child(rest[0])
+		if c == nil {
+			return nil, ""
+		}
+
+		if strings.HasPrefix(rest, c.label) {
+			cur, path, rest = c, path+c.label, rest[len(c.label):]
+			continue
+		}
+
+		// 'prefix' ends in the middle of an edge
+		if strings.HasPrefix(c.label, rest) {
+			return c, path + c.label
+		}
+		return nil, ""
+	}
+	return cur, path
+}
+
+// walkRadixNode visits every state under 'nd' on lexicographical order of their keys,
+// where 'path' is the entire key leading to 'nd'.
+func walkRadixNode(nd *radixNode, path string, visit func(key string, st *State)) {
+	if nd.st != nil {
+		visit(path, nd.st)
+	}
+	for _, c := range nd.children {
+		walkRadixNode(c, path+c.label, visit)
+	}
+}
+
+// commonPrefixLen returns the length of the longest common prefix of 'a' and 'b'.
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
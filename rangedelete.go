@@ -0,0 +1,137 @@
+package beelog
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// ErrRangeDeleteUnsupported is returned by 'Log()' calls informing a range delete
+// (i.e. DELETE_PREFIX or DELETE_RANGE) to structures that dont index states by key.
+var ErrRangeDeleteUnsupported = errors.New("range deletes are not supported on this structure")
+
+// isRangeDelete informs if 'op' removes every key within a range, instead of a single
+// key state.
+func isRangeDelete(op pb.Command_Operation) bool {
+	return op == pb.Command_DELETE_PREFIX || op == pb.Command_DELETE_RANGE
+}
+
+// keyRange returns the key interval [lo, hi) matched by the range delete 'cmd'. An
+// empty 'hi' represents an unbounded interval.
+func keyRange(cmd *pb.Command) (lo, hi string) {
+	if cmd.Op == pb.Command_DELETE_PREFIX {
+		return cmd.Key, prefixUpperBound(cmd.Key)
+	}
+	return cmd.Key, cmd.EndKey
+}
+
+// prefixUpperBound returns the smallest key greater than every key prefixed by 'p',
+// or an empty string if there is none (e.g. an empty prefix).
+func prefixUpperBound(p string) string {
+	b := []byte(p)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// coversKey informs if 'key' is matched by the range delete 'cmd'.
+func coversKey(cmd *pb.Command, key string) bool {
+	if cmd.Op == pb.Command_DELETE_PREFIX {
+		return strings.HasPrefix(key, cmd.Key)
+	}
+	return key >= cmd.Key && (cmd.EndKey == "" || key < cmd.EndKey)
+}
+
+// containsRange informs if every key matched by the range delete 'b' is also matched
+// by 'a'.
+func containsRange(a, b *pb.Command) bool {
+	alo, ahi := keyRange(a)
+	blo, bhi := keyRange(b)
+	return alo <= blo && (ahi == "" || (bhi != "" && bhi <= ahi))
+}
+
+// rangeTombs records the range deletes logged on a structure, on their index order.
+// Matching states are only discarded at reduce time, when the reduced log is known.
+type rangeTombs []pb.Command
+
+func (rt *rangeTombs) add(cmd pb.Command) {
+	*rt = append(*rt, cmd)
+}
+
+// after returns only the range deletes logged after index 'n', reusing the backing
+// array of 'rt'.
+func (rt rangeTombs) after(n uint64) rangeTombs {
+	kept := rt[:0]
+	for _, t := range rt {
+		if t.Id > n {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// applyOn drops from the reduced 'log' every state matched by a range delete logged
+// within [p, n] on a later index, and prepends those range deletes to it, so they are
+// applied before any surviving state on replay. Range deletes subsumed by a later one
+// are omitted, since every state they match is already discarded.
+func (rt rangeTombs) applyOn(log []pb.Command, p, n uint64) []pb.Command {
+	tombs := make([]pb.Command, 0, len(rt))
+	for _, t := range rt {
+		if t.Id >= p && t.Id <= n {
+			tombs = append(tombs, t)
+		}
+	}
+	if len(tombs) == 0 {
+		return log
+	}
+
+	cmds := make([]pb.Command, 0, len(tombs)+len(log))
+	for i := range tombs {
+		if !subsumedRange(tombs, i) {
+			cmds = append(cmds, tombs[i])
+		}
+	}
+
+	for _, c := range log {
+		if !deletedByRange(tombs, &c) {
+			cmds = append(cmds, c)
+		}
+	}
+	return cmds
+}
+
+// subsumedRange informs if 'tombs[i]' is contained by any later range delete on
+// 'tombs', sorted by index.
+func subsumedRange(tombs []pb.Command, i int) bool {
+	for j := i + 1; j < len(tombs); j++ {
+		if containsRange(&tombs[j], &tombs[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletedByRange informs if the state of 'cmd' is discarded by any range delete on
+// 'tombs' logged on a later index.
+func deletedByRange(tombs []pb.Command, cmd *pb.Command) bool {
+	if isRangeDelete(cmd.Op) {
+		return false
+	}
+	for i := range tombs {
+		if tombs[i].Id > cmd.Id && coversKey(&tombs[i], cmd.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRangeDeletes discards states of the reduced log 'cmds' matched by range deletes
+// logged on the structure. See 'rangeTombs.applyOn'.
+func (ld *logData) applyRangeDeletes(cmds []pb.Command, p, n uint64) []pb.Command {
+	return ld.tombs.applyOn(cmds, p, n)
+}
@@ -0,0 +1,74 @@
+package beelog
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// writeLimiter is a token bucket pacing the bytes persisted by reduces on WriteRate
+// configs, refilled by 'rate' bytes per second up to a burst of a single second. Writes
+// larger than the bucket are paced in chunks, so a huge segment doesnt saturate the
+// disk shared with the state machine.
+type writeLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens int64
+	last   time.Time
+}
+
+func newWriteLimiter(rate int64) *writeLimiter {
+	return &writeLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait reserves 'n' bytes from the bucket, blocking until they are available. Reserved
+// bytes may turn the bucket negative, delaying later callers accordingly.
+func (wl *writeLimiter) wait(n int) {
+	wl.mu.Lock()
+	now := time.Now()
+	wl.tokens += int64(now.Sub(wl.last)) * wl.rate / int64(time.Second)
+	if wl.tokens > wl.rate {
+		wl.tokens = wl.rate
+	}
+	wl.last = now
+	wl.tokens -= int64(n)
+	deficit := -wl.tokens
+	wl.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit * int64(time.Second) / wl.rate))
+	}
+}
+
+// limitedWriter paces every write into 'w' through 'wl'.
+type limitedWriter struct {
+	w  io.Writer
+	wl *writeLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if int64(len(chunk)) > lw.wl.rate {
+			chunk = chunk[:lw.wl.rate]
+		}
+		lw.wl.wait(len(chunk))
+
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttle returns 'w' paced by the configured write rate, if any.
+func (ld *logData) throttle(w io.Writer) io.Writer {
+	if ld.wlim == nil {
+		return w
+	}
+	return &limitedWriter{w: w, wl: ld.wlim}
+}
@@ -0,0 +1,189 @@
+package beelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Reconfigurable is implemented by structures whose config can be updated while
+// running, retaining their in-memory state: ListHT, ArrayHT, AVLTreeHT, SkipListHT,
+// RadixHT, CircBuffHT, ConcTable and ShardedConcTable.
+//
+// Only the reduce schedule (i.e. Tick, Period, MinPeriod, MaxPeriod and Duration),
+// Sync, and the output paths (i.e. Fname, SecondFname and Fnames) can be updated,
+// any other field must be retained. Output paths cant be updated on persistent KeepAll
+// configs, whose segments are indexed by config.Fname, and must retain the number of
+// configured disks. The latest state persisted on each prior path is copied into the
+// updated one, so recoveries are never served from an empty path, while prior files
+// are retained.
+type Reconfigurable interface {
+	// SetConfig atomically replaces the structure config by 'cfg', once every in-flight
+	// reduce is finished. The structure retains its own copy of 'cfg'.
+	SetConfig(cfg *LogConfig) error
+
+	// UpdateConfig is analogous to SetConfig, but updates a copy of the current config
+	// through 'fn', serialized with any other update.
+	UpdateConfig(fn func(cfg *LogConfig)) error
+}
+
+// clone returns a copy of the config, which can be modified without affecting 'lc'.
+func (lc *LogConfig) clone() *LogConfig {
+	cp := *lc
+	if lc.Fnames != nil {
+		cp.Fnames = append([]string(nil), lc.Fnames...)
+	}
+	return &cp
+}
+
+// reconfigured validates the update of the config into 'cfg' on a running structure,
+// returning a copy of 'cfg' to be retained by the structure.
+func (lc *LogConfig) reconfigured(cfg *LogConfig) (*LogConfig, error) {
+	if cfg == nil {
+		return nil, errors.New("invalid config: cant update into a nil config")
+	}
+	if err := cfg.ValidateConfig(); err != nil {
+		return nil, err
+	}
+
+	// every field other than the updatable ones must be retained
+	chk := *lc
+	chk.Tick, chk.Period, chk.MinPeriod, chk.MaxPeriod, chk.Duration = cfg.Tick, cfg.Period, cfg.MinPeriod, cfg.MaxPeriod, cfg.Duration
+	chk.Sync = cfg.Sync
+	chk.Fname, chk.SecondFname, chk.Fnames = cfg.Fname, cfg.SecondFname, cfg.Fnames
+	if name, ok := equalConfigs(&chk, cfg); !ok {
+		return nil, fmt.Errorf("invalid config: config.%s cant be updated on running structures", name)
+	}
+
+	if !lc.Inmem && !reflect.DeepEqual(lc.diskFnames(), cfg.diskFnames()) {
+		if lc.KeepAll {
+			return nil, errors.New("invalid config: output paths cant be updated on persistent KeepAll configs, whose segments are indexed by config.Fname")
+		}
+		if len(lc.diskFnames()) != len(cfg.diskFnames()) {
+			return nil, errors.New("invalid config: updated output paths must retain the number of configured disks")
+		}
+	}
+	return cfg.clone(), nil
+}
+
+// equalConfigs informs if every field of 'a' and 'b' are equal, or the name of the
+// first differing one otherwise. Functions are equal only if they're the same.
+func equalConfigs(a, b *LogConfig) (string, bool) {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() == reflect.Func {
+			if fa.Pointer() != fb.Pointer() {
+				return va.Type().Field(i).Name, false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			return va.Type().Field(i).Name, false
+		}
+	}
+	return "", true
+}
+
+// scheduleChanged informs if the reduce schedule of 'next' differs from the one of 'prev'.
+func scheduleChanged(prev, next *LogConfig) bool {
+	return prev.Tick != next.Tick || prev.Period != next.Period || prev.MinPeriod != next.MinPeriod ||
+		prev.MaxPeriod != next.MaxPeriod || prev.Duration != next.Duration
+}
+
+// migrateState copies the latest state persisted on each path of the current config
+// into the corresponding path of 'next', if any. Pending group commits are flushed
+// beforehand. Must be called once every in-flight reduce is finished.
+func (ld *logData) migrateState(next *LogConfig) error {
+	if ld.config.Inmem || ld.config.KeepAll {
+		return nil
+	}
+	if ld.gc != nil {
+		if err := ld.gc.flush(); err != nil {
+			return err
+		}
+	}
+
+	prev := ld.config.diskFnames()
+	for i, fn := range next.diskFnames() {
+		if fn == prev[i] {
+			continue
+		}
+		if _, err := ld.storage().Size(prev[i]); err != nil {
+			// no state persisted yet
+			continue
+		}
+		if err := copyState(ld.storage(), prev[i], fn); err != nil {
+			return fmt.Errorf("failed migrating state '%s' into '%s', err: '%s'", prev[i], fn, err.Error())
+		}
+	}
+	return nil
+}
+
+// copyState copies the persisted state 'src' into 'dest' on 'st', syncing it.
+func copyState(st LogStorage, src, dest string) error {
+	rd, err := st.ReadAt(src)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	seg, err := st.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(seg, newSegmentCursor(rd)); err != nil {
+		seg.Close()
+		return err
+	}
+	if err = seg.Sync(); err != nil {
+		seg.Close()
+		return err
+	}
+	return seg.Close()
+}
+
+// applyConfig replaces the config by 'next', previously validated by 'reconfigured',
+// discarding the adjusted period of Adaptive configs if the schedule changed. Logged
+// commands remain counted towards the next reduce.
+func (ld *logData) applyConfig(next *LogConfig) {
+	if scheduleChanged(ld.config, next) {
+		ld.adapt = nil
+	}
+	ld.config = next
+}
+
+// reconfigure validates and applies the update of a structure into 'cfg', migrating
+// its persisted state and relaunching its ticker routine, cancelled by 'canc' and
+// invoking 'reduce', on 'ctx'. Must be called within mutual exclusion scope, once
+// every in-flight reduce is finished.
+func (ld *logData) reconfigure(ctx context.Context, cfg *LogConfig, canc *context.CancelFunc, reduce func()) error {
+	next, err := ld.config.reconfigured(cfg)
+	if err != nil {
+		return err
+	}
+	if err := ld.migrateState(next); err != nil {
+		return err
+	}
+	if ld.config.Tick != next.Tick || ld.config.Duration != next.Duration {
+		relaunchReduceTicker(ctx, next, canc, reduce)
+	}
+	ld.applyConfig(next)
+	return nil
+}
+
+// relaunchReduceTicker stops the ticker routine cancelled by 'canc', if any, and
+// launches a new one on 'ctx' if 'cfg' is a TimeInterval config, replacing 'canc'.
+func relaunchReduceTicker(ctx context.Context, cfg *LogConfig, canc *context.CancelFunc, reduce func()) {
+	if *canc != nil {
+		(*canc)()
+		*canc = nil
+	}
+	if cfg.Tick == TimeInterval {
+		c, cancel := context.WithCancel(ctx)
+		*canc = cancel
+		launchReduceTicker(c, cfg.Duration, reduce)
+	}
+}
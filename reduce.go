@@ -1,7 +1,10 @@
 package beelog
 
 import (
+	"context"
 	"errors"
+	"runtime"
+	"sync"
 
 	"github.com/Lz-Gustavo/beelog/pb"
 )
@@ -37,14 +40,43 @@ const (
 
 	// IterConcTable ...
 	IterConcTable
+
+	// GreedySkip implements a greedy search over SkipListHT structures. The lower
+	// bound of the requested interval is located through the upper levels of the
+	// skip list, then the bottom level is iterated until the upper bound is surpassed.
+	GreedySkip
+
+	// MergeLSM merges the memtable and every sorted run of LSMLog structures by
+	// key, retaining only the latest state of each key. Runs discard the original
+	// log intervals, so the entire structure is always reduced.
+	MergeLSM
+
+	// IterRadix implements a depth-first traversal over RadixHT structures, on
+	// lexicographical order of keys. Only the latest state of each key is stored,
+	// so the entire structure is always reduced.
+	IterRadix
+
+	// IterBTree iterates over the linked leaves of BTreeHT structures, on key order,
+	// reading the latest state of each key from disk. Only the latest state of each
+	// key is stored, so the entire structure is always reduced.
+	IterBTree
 )
 
+// structLen returns the length of 's' without acquiring its lock, already held by
+// reduce callers.
+func structLen(s Structure) uint64 {
+	if l, ok := s.(interface{ length() uint64 }); ok {
+		return l.length()
+	}
+	return s.Len()
+}
+
 // ApplyReduceAlgo executes over a Structure the choosen Reducer algorithm, returning
 // a compacted log of commands within the requested [p, n] interval.
 //
 //  IMPORTANT: Unsafe operation. Use Recov() calls for a safe log retrieval.
 func ApplyReduceAlgo(s Structure, r Reducer, p, n uint64) ([]pb.Command, error) {
-	if s.Len() < 1 {
+	if structLen(s) < 1 {
 		return nil, errors.New("empty structure")
 	}
 
@@ -104,11 +136,55 @@ func ApplyReduceAlgo(s Structure, r Reducer, p, n uint64) ([]pb.Command, error)
 			return nil, errors.New("unsupported reduce algorithm for a CircBuffHT structure")
 		}
 
+	case *SkipListHT:
+		switch r {
+		case GreedySkip:
+			log = GreedySkipListHT(st, p, n)
+
+		default:
+			return nil, errors.New("unsupported reduce algorithm for a SkipListHT structure")
+		}
+
+	case *LSMLog:
+		switch r {
+		case MergeLSM:
+			var err error
+			log, err = MergeLSMLog(st)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, errors.New("unsupported reduce algorithm for a LSMLog structure")
+		}
+
+	case *RadixHT:
+		switch r {
+		case IterRadix:
+			log = IterRadixHT(st)
+
+		default:
+			return nil, errors.New("unsupported reduce algorithm for a RadixHT structure")
+		}
+
+	case *BTreeHT:
+		switch r {
+		case IterBTree:
+			var err error
+			log, err = IterBTreeHT(st)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, errors.New("unsupported reduce algorithm for a BTreeHT structure")
+		}
+
 	case *ConcTable:
 		switch r {
 		case IterConcTable:
-			view := st.retrieveCurrentViewCopy()
-			log = IterConcTableOnView(&view)
+			view, tombs := st.retrieveCurrentViewCopy(), st.currentRangeDeletes()
+			log = tombs.applyOn(IterConcTableOnView(&view), 0, ^uint64(0))
 
 		default:
 			return nil, errors.New("unsupported reduce algorithm for a ConcTable structure")
@@ -117,6 +193,13 @@ func ApplyReduceAlgo(s Structure, r Reducer, p, n uint64) ([]pb.Command, error)
 	default:
 		return nil, errors.New("unsupported log datastructure")
 	}
+
+	// structures indexing states by key discard those matched by range deletes
+	if rd, ok := s.(interface {
+		applyRangeDeletes([]pb.Command, uint64, uint64) []pb.Command
+	}); ok {
+		log = rd.applyRangeDeletes(log, p, n)
+	}
 	return log, nil
 }
 
@@ -206,7 +289,7 @@ func OldGreedyList(l *ListHT, p, n uint64) []pb.Command {
 func GreedyListHT(l *ListHT, p, n uint64) []pb.Command {
 	log := []pb.Command{}
 	l.resetVisitedValues()
-	first := l.searchEntryNodeByIndex(p)
+	first, _ := l.searchEntryNodeByIndex(p)
 
 	for i := first; i != nil; i = i.next {
 		ent := i.val.(*listEntry)
@@ -219,13 +302,9 @@ func GreedyListHT(l *ListHT, p, n uint64) []pb.Command {
 
 		// current key state not yet satisfied in log
 		if !st.visited {
-			var phi pb.Command
-			for j := ent.ptr; j != nil && j.val.(*State).ind <= n; j = j.next {
-				phi = j.val.(*State).cmd
-			}
-
-			// append only the last update of a particular key
-			log = append(log, phi)
+			// append only the last update of a particular key, and its
+			// dependencies if any
+			log = append(log, retainKeyChain(ent.ptr, p, n)...)
 			st.visited = true
 		}
 	}
@@ -237,9 +316,9 @@ func GreedyListHT(l *ListHT, p, n uint64) []pb.Command {
 func GreedyArrayHT(ar *ArrayHT, p, n uint64) []pb.Command {
 	log := []pb.Command{}
 	ar.resetVisitedValues()
-	first := ar.searchEntryPosByIndex(p)
+	first, _ := ar.searchEntryPosByIndex(p)
 
-	for i := first; i < ar.Len(); i++ {
+	for i := first; i < ar.length(); i++ {
 		ent := (*ar.arr)[i]
 
 		// reached the last index position
@@ -250,13 +329,28 @@ func GreedyArrayHT(ar *ArrayHT, p, n uint64) []pb.Command {
 
 		// current key state not yet satisfied in log
 		if !st.visited {
-			var phi pb.Command
-			for j := ent.ptr; j != nil && j.val.(*State).ind <= n; j = j.next {
-				phi = j.val.(*State).cmd
-			}
+			// append only the last update of a particular key, and its
+			// dependencies if any
+			log = append(log, retainKeyChain(ent.ptr, p, n)...)
+			st.visited = true
+		}
+	}
+	return log
+}
+
+// GreedySkipListHT implements a greedy search on top of SkipListHT structs.
+func GreedySkipListHT(sl *SkipListHT, p, n uint64) []pb.Command {
+	log := []pb.Command{}
+	sl.resetVisitedValues()
+
+	for ent := sl.searchEntryByIndex(p); ent != nil && ent.ind <= n; ent = ent.next[0] {
+		st := (*sl.aux)[ent.key]
 
-			// append only the last update of a particular key
-			log = append(log, phi)
+		// current key state not yet satisfied in log
+		if !st.visited {
+			// append only the last update of a particular key, and its
+			// dependencies if any
+			log = append(log, retainKeyChain(ent.ptr, p, n)...)
 			st.visited = true
 		}
 	}
@@ -280,13 +374,9 @@ func greedyRecur(avl *AVLTreeHT, k *avlTreeEntry, p, n uint64, log *[]pb.Command
 	// index in [p, n] interval and key not already satisfied on the log
 	if !(*avl.aux)[k.key].visited && k.ind >= p && k.ind <= n {
 
-		var phi pb.Command
-		for j := k.ptr; j != nil && j.val.(*State).ind <= n; j = j.next {
-			phi = j.val.(*State).cmd
-		}
-
-		// append only the last update of a particular key
-		*log = append(*log, phi)
+		// append only the last update of a particular key, and its
+		// dependencies if any
+		*log = append(*log, retainKeyChain((*avl.aux)[k.key].first, p, n)...)
 		(*avl.aux)[k.key].visited = true
 	}
 	if k.ind > p {
@@ -311,13 +401,9 @@ func IterBFSAVLTreeHT(avl *AVLTreeHT, p, n uint64) []pb.Command {
 		// index in [p, n] interval and key not already satisfied on the log
 		if !(*avl.aux)[u.key].visited && u.ind >= p && u.ind <= n {
 
-			var phi pb.Command
-			for j := u.ptr; j != nil && j.val.(*State).ind <= n; j = j.next {
-				phi = j.val.(*State).cmd
-			}
-
-			// append only the last update of a particular key
-			log = append(log, phi)
+			// append only the last update of a particular key, and its
+			// dependencies if any
+			log = append(log, retainKeyChain((*avl.aux)[u.key].first, p, n)...)
 			(*avl.aux)[u.key].visited = true
 		}
 
@@ -345,13 +431,9 @@ func IterDFSAVLTreeHT(avl *AVLTreeHT, p, n uint64) []pb.Command {
 		// index in [p, n] interval and key not already satisfied on the log
 		if !(*avl.aux)[u.key].visited && u.ind >= p && u.ind <= n {
 
-			var phi pb.Command
-			for j := u.ptr; j != nil && j.val.(*State).ind <= n; j = j.next {
-				phi = j.val.(*State).cmd
-			}
-
-			// append only the last update of a particular key
-			log = append(log, phi)
+			// append only the last update of a particular key, and its
+			// dependencies if any
+			log = append(log, retainKeyChain((*avl.aux)[u.key].first, p, n)...)
 			(*avl.aux)[u.key].visited = true
 		}
 
@@ -365,10 +447,60 @@ func IterDFSAVLTreeHT(avl *AVLTreeHT, p, n uint64) []pb.Command {
 	return log
 }
 
+// MergeLSMLog merges the memtable and every run of a LSMLog structure, reading persisted
+// runs if necessary, into a reduced log ordered by command indexes.
+func MergeLSMLog(lg *LSMLog) ([]pb.Command, error) {
+	return lg.mergeStateCtx(context.Background())
+}
+
+// IterRadixHT traverses the entire RadixHT structure, appending the latest state of
+// each key preceded by any prior states it depends on.
+func IterRadixHT(rt *RadixHT) []pb.Command {
+	return IterRadixHTPrefix(rt, "")
+}
+
+// IterRadixHTPrefix is analogous to IterRadixHT, but only traverses the subtree of
+// keys starting with 'prefix'.
+func IterRadixHTPrefix(rt *RadixHT, prefix string) []pb.Command {
+	log := []pb.Command{}
+	nd, path := rt.searchPrefix(prefix)
+	if nd == nil {
+		return log
+	}
+
+	walkRadixNode(nd, path, func(key string, st *State) {
+		log = appendStateChain(log, st)
+	})
+	return log
+}
+
+// IterBTreeHT traverses every leaf of a BTreeHT structure, appending the latest state
+// of each key preceded by any prior states it depends on.
+func IterBTreeHT(bt *BTreeHT) ([]pb.Command, error) {
+	log := []pb.Command{}
+	err := bt.walkLeaves(func(key string, v btreeValue) error {
+		cmds, err := bt.readValue(v)
+		if err != nil {
+			return err
+		}
+		log = append(log, cmds...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
 // IterCircBuffHT executes on top of a local copy of the log structure, parsing
 // the entire structure without any interval bound. During iteration, ignores
 // repetitive commands to a key already satisfied in log.
 func IterCircBuffHT(cp *buffCopy) []pb.Command {
+	return iterCircBuffHT(cp, nil)
+}
+
+// iterCircBuffHT implements IterCircBuffHT, ticking 'y' on each visited entry.
+func iterCircBuffHT(cp *buffCopy, y *yielder) []pb.Command {
 	log := []pb.Command{}
 	visited := make(map[string]bool, 0)
 
@@ -380,22 +512,140 @@ func IterCircBuffHT(cp *buffCopy) []pb.Command {
 
 		pos := modInt(((*cp).cur - 1 - i), (*cp).cap)
 		ent := (*cp).buf[pos]
-		st := (*cp).tbl[ent.key]
+		y.tick()
 
 		if _, ok := visited[ent.key]; !ok {
 			visited[ent.key] = true
-			log = append(log, st.cmd)
+			log = appendStateChain(log, ent.st)
 		}
 		i++
 	}
+
+	// spilled entries precede every buffered one
+	for j := len((*cp).spill) - 1; j >= 0; j-- {
+		ent := (*cp).spill[j]
+		y.tick()
+		if _, ok := visited[ent.key]; !ok {
+			visited[ent.key] = true
+			log = appendStateChain(log, ent.st)
+		}
+	}
 	return log
 }
 
 // IterConcTableOnView ...
 func IterConcTableOnView(tbl *minStateTable) []pb.Command {
+	return iterConcTableOnView(tbl, nil, nil)
+}
+
+// ParIterConcTableOnView implements IterConcTableOnView partitioning the keys of
+// 'tbl' across 'workers' goroutines, concatenating their results. Only worth on views
+// with a huge number of keys, where the overhead of spawning workers is amortized.
+func ParIterConcTableOnView(tbl *minStateTable, workers int) []pb.Command {
+	return parIterConcTableOnView(tbl, nil, workers, nil)
+}
+
+// iterConcTableOnView implements IterConcTableOnView, only retaining states of keys
+// accepted by 'f', if any filter is informed. Views with at least 'parIterThreshold'
+// keys are reduced in parallel, by no more workers than allowed by 'rt', if any.
+func iterConcTableOnView(tbl *minStateTable, f KeyFilter, rt *reduceThrottle) []pb.Command {
+	if w := rt.workers(runtime.GOMAXPROCS(0)); w > 1 && len(*tbl) >= parIterThreshold {
+		return parIterConcTableOnView(tbl, f, w, rt)
+	}
+	return seqIterConcTableOnView(tbl, f, rt.yielder())
+}
+
+func seqIterConcTableOnView(tbl *minStateTable, f KeyFilter, y *yielder) []pb.Command {
 	log := []pb.Command{}
-	for _, st := range *tbl {
-		log = append(log, st.cmd)
+	for k, st := range *tbl {
+		y.tick()
+		if f != nil && !f(k) {
+			continue
+		}
+		log = appendStateChain(log, &st)
 	}
 	return log
 }
+
+// parIterConcTableOnView collects the keys of 'tbl' and splits them into contiguous
+// partitions, each one reduced by a different goroutine. Since map iteration order
+// is already random, concatenating partitions yields an equivalent log.
+func parIterConcTableOnView(tbl *minStateTable, f KeyFilter, workers int, rt *reduceThrottle) []pb.Command {
+	keys := make([]string, 0, len(*tbl))
+	for k := range *tbl {
+		if f != nil && !f(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if workers > len(keys) {
+		workers = len(keys)
+	}
+	if workers <= 1 {
+		return seqIterConcTableOnView(tbl, f, rt.yielder())
+	}
+
+	parts := make([][]pb.Command, workers)
+	size := (len(keys) + workers - 1) / workers
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		beg, end := i*size, (i+1)*size
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		wg.Add(1)
+		go func(i int, ks []string) {
+			defer wg.Done()
+			y := rt.yielder()
+			log := make([]pb.Command, 0, len(ks))
+			for _, k := range ks {
+				y.tick()
+				st := (*tbl)[k]
+				log = appendStateChain(log, &st)
+			}
+			parts[i] = log
+		}(i, keys[beg:end])
+	}
+	wg.Wait()
+
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	log := make([]pb.Command, 0, n)
+	for _, p := range parts {
+		log = append(log, p...)
+	}
+	return log
+}
+
+// retainKeyChain returns the last update within [p, n] on the key list starting at
+// 'nd'. If that update depends on prior state (e.g. CAS), its predecessors are also
+// retained until a non-conditional update is found, preserving their original order.
+// AVL reducers dont visit nodes in index order, so the entire key list must be
+// informed, instead of the first visited node.
+func retainKeyChain(nd *listNode, p, n uint64) []pb.Command {
+	chain := []pb.Command{}
+	for j := nd; j != nil && j.val.(*State).ind <= n; j = j.next {
+		st := j.val.(*State)
+		if st.ind < p {
+			continue
+		}
+		if !st.dependsOnPrior() {
+			chain = chain[:0]
+		}
+		chain = append(chain, st.cmd)
+	}
+	return chain
+}
+
+// appendStateChain appends the command of 'st' into 'log', preceded by every
+// prior state it depends on, on their original order.
+func appendStateChain(log []pb.Command, st *State) []pb.Command {
+	if st.prev != nil {
+		log = appendStateChain(log, st.prev)
+	}
+	return append(log, st.cmd)
+}
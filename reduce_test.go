@@ -1,12 +1,13 @@
 package beelog
 
 import (
-	"fmt"
+	"context"
 	"math/rand"
 	"os"
-	"runtime"
+	"path/filepath"
+	"reflect"
 	"strconv"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
@@ -56,7 +57,12 @@ func TestListAlgos(t *testing.T) {
 			t.Log("Reduced Log:\n", log)
 			t.Log("Removed commands:", l.Len()-uint64(len(log)))
 		}
-		// TODO: implement a validation procedure...
+		// every write is retained on the structure, serving as the reference execution
+		orig, _ := l.(*ListHT).retainedStates(0, l.Len())
+		if res := VerifyReducedLog(orig, log); !res.Equivalent {
+			t.Log("test num", i, "diverged on keys:", res.Divergences)
+			t.FailNow()
+		}
 	}
 }
 
@@ -103,7 +109,12 @@ func TestArrayAlgos(t *testing.T) {
 			t.Log("Reduced Log:\n", log)
 			t.Log("Removed commands:", ar.Len()-uint64(len(log)))
 		}
-		// TODO: implement a validation procedure...
+		// every write is retained on the structure, serving as the reference execution
+		orig, _ := ar.(*ArrayHT).retainedStates(0, ar.Len())
+		if res := VerifyReducedLog(orig, log); !res.Equivalent {
+			t.Log("test num", i, "diverged on keys:", res.Divergences)
+			t.FailNow()
+		}
 	}
 }
 
@@ -182,6 +193,198 @@ func TestAVLTreeAlgos(t *testing.T) {
 	}
 }
 
+func TestSkipListAlgos(t *testing.T) {
+	testCases := []struct {
+		numCmds      uint64
+		writePercent int
+		diffKeys     int
+		p, n         uint64
+	}{
+		{20, 100, 5, 0, 20},
+		{2000, 50, 100, 0, 2000},
+		{2000, 50, 100, 500, 1500},
+	}
+
+	for i, tc := range testCases {
+		ch := make(chan pb.Command, tc.numCmds+1)
+		go createRandomLog(tc.numCmds, tc.diffKeys, tc.writePercent, ch)
+
+		sl, avl := NewSkipListHT(), NewAVLTreeHT()
+		for cmd := range ch {
+			// last command signal
+			if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+				break
+			}
+			if err := sl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if err := avl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		skipLog, err := ApplyReduceAlgo(sl, GreedySkip, tc.p, tc.n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avlLog, err := ApplyReduceAlgo(avl, GreedyAvl, tc.p, tc.n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(skipLog, avlLog) {
+			t.Log("test num", i, ": GreedySkip and GreedyAvl presented different results, incoherent")
+			t.FailNow()
+		}
+	}
+}
+
+func TestRadixAlgos(t *testing.T) {
+	testCases := []struct {
+		numCmds      uint64
+		writePercent int
+		diffKeys     int
+		prefixes     []string
+	}{
+		{20, 100, 5, []string{"", "user:", "user:1"}},
+		{2000, 50, 100, []string{"user:", "user:1", "user:10:", "order:", "none"}},
+		{2000, 90, 1000, []string{"user:2", "order:99", "user:999:cart"}},
+	}
+
+	for i, tc := range testCases {
+		ch := make(chan pb.Command, tc.numCmds+1)
+		go createRandomLog(tc.numCmds, tc.diffKeys, tc.writePercent, ch)
+
+		rt, avl := NewRadixHT(), NewAVLTreeHT()
+		for cmd := range ch {
+			// last command signal
+			if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+				break
+			}
+
+			// emulate hierarchical keys sharing common prefixes
+			if cmd.Id%2 == 0 {
+				cmd.Key = "user:" + cmd.Key + ":cart"
+			} else {
+				cmd.Key = "order:" + cmd.Key
+			}
+
+			if err := rt.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if err := avl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		radixLog, err := ApplyReduceAlgo(rt, IterRadix, 0, tc.numCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avlLog, err := ApplyReduceAlgo(avl, GreedyAvl, 0, tc.numCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(radixLog, avlLog) {
+			t.Log("test num", i, ": IterRadix and GreedyAvl presented different results, incoherent")
+			t.FailNow()
+		}
+
+		for _, pf := range tc.prefixes {
+			prefixLog, err := rt.RecovPrefix(0, tc.numCmds, pf)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			if !logsAreEquivalent(prefixLog, filterByKey(avlLog, KeyPrefix(pf))) {
+				t.Log("test num", i, ": prefix scan over", pf, "presented different results, incoherent")
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestBTreeAlgos(t *testing.T) {
+	testCases := []struct {
+		numCmds      uint64
+		writePercent int
+		diffKeys     int
+		cachePages   int
+	}{
+		{20, 100, 5, defaultBTreeCachePages},
+		{4000, 50, 100, 4},
+		{4000, 90, 2000, 4},
+	}
+
+	// long keys force splits and a deeper tree
+	pad := strings.Repeat("k", 100)
+
+	for i, tc := range testCases {
+		ch := make(chan pb.Command, tc.numCmds+1)
+		go createRandomLog(tc.numCmds, tc.diffKeys, tc.writePercent, ch)
+
+		bt, err := NewBTreeHTWithConfig(DefaultLogConfig(), "./btree-test", tc.cachePages)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avl := NewAVLTreeHT()
+
+		for cmd := range ch {
+			// last command signal
+			if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+				break
+			}
+			cmd.Key = pad + cmd.Key
+
+			if err := bt.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if err := avl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		btLog, err := ApplyReduceAlgo(bt, IterBTree, 0, tc.numCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avlLog, err := ApplyReduceAlgo(avl, GreedyAvl, 0, tc.numCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(btLog, avlLog) {
+			t.Log("test num", i, ": IterBTree and GreedyAvl presented different results, incoherent")
+			t.FailNow()
+		}
+		if len(bt.cache) > tc.cachePages {
+			t.Log("test num", i, ": page cache retained", len(bt.cache), "pages, expected at most", tc.cachePages)
+			t.FailNow()
+		}
+		bt.Shutdown()
+	}
+
+	fs, _ := filepath.Glob("./btree-test.*")
+	for _, f := range fs {
+		os.Remove(f)
+	}
+}
+
 func TestCircBuffAlgos(t *testing.T) {
 	debugOutput := false
 	testCases := []struct {
@@ -276,6 +479,112 @@ func TestConcTableAlgos(t *testing.T) {
 	}
 }
 
+func TestReducersRetainCASChain(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 2, Op: pb.Command_SET, Key: "b", Value: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "a", Value: "2"},
+		{Id: 4, Op: pb.Command_CAS, Key: "a", Expected: "2", Value: "3"},
+		{Id: 5, Op: pb.Command_SET, Key: "b", Value: "2"},
+		{Id: 6, Op: pb.Command_CAS, Key: "a", Expected: "3", Value: "4"},
+	}
+	expected := []pb.Command{cmds[2], cmds[3], cmds[4], cmds[5]}
+
+	testCases := []struct {
+		st  Structure
+		alg Reducer
+	}{
+		{NewListHT(), GreedyLt},
+		{NewArrayHT(), GreedyArray},
+		{NewAVLTreeHT(), GreedyAvl},
+		{NewAVLTreeHT(), IterBFSAvl},
+		{NewAVLTreeHT(), IterDFSAvl},
+		{NewCircBuffHT(context.TODO()), IterCircBuff},
+		{NewConcTable(context.TODO()), IterConcTable},
+	}
+
+	for _, tc := range testCases {
+		for _, c := range cmds {
+			if err := tc.st.Log(c); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		log, err := ApplyReduceAlgo(tc.st, tc.alg, 1, 6)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsRetainSameCommands(expected, log) {
+			t.Logf("alg %d didnt retain the CAS dependency chain", tc.alg)
+			t.Log("EXPC:", expected)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+	}
+}
+
+func TestCompactLogFile(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 50
+	dir := t.TempDir()
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	trad := make([]pb.Command, 0, nCmds)
+	for i := uint64(0); i < nCmds; i++ {
+		trad = append(trad, <-ch)
+	}
+
+	src := dir + "/trad.log"
+	fd, err := os.Create(src)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err = MarshalTradLogIntoWriter(fd, &trad, 0, nCmds-1); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	fd.Close()
+
+	// reference reduced log
+	lt := NewListHT()
+	for _, c := range trad {
+		lt.Log(c)
+	}
+	exp := GreedyListHT(lt, 0, nCmds-1)
+
+	algs := []Reducer{GreedyLt, GreedyArray, GreedyAvl, IterDFSAvl, IterCircBuff, IterConcTable}
+	for _, alg := range algs {
+		dst := dir + "/compacted-" + strconv.Itoa(int(alg)) + ".log"
+		if err := CompactLogFile(src, dst, alg); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		fd, err := os.Open(dst)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		log, err := UnmarshalLogFromReader(fd)
+		fd.Close()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(exp, log) {
+			t.Logf("offline compaction with alg %d returned an incoherent log", alg)
+			t.Log("EXPC:", exp)
+			t.Log("COMP:", log)
+			t.FailNow()
+		}
+	}
+}
+
 func BenchmarkAVLTreeAlgos(b *testing.B) {
 	scenarios := []struct {
 		numCmds      uint64
@@ -334,38 +643,48 @@ func BenchmarkAVLTreeAlgos(b *testing.B) {
 	}
 }
 
-// Dear dev, avoid crash on your IDE by running with:
-// go test -run none -bench BenchmarkAlgosThroughput -benchtime 1ns -benchmem -v
-func BenchmarkAlgosThroughput(b *testing.B) {
-
-	b.SetParallelism(runtime.NumCPU())
-	numCommands, diffKeys, writePercent := uint64(1000000), 1000, 50
-	log := make(chan pb.Command, numCommands)
-
-	// dummy goroutine that creates a random log of commands
-	go createRandomLog(numCommands, diffKeys, writePercent, log)
-
-	// deploy the different workers, each implementing a diff recov protocol
-	chA := make(chan pb.Command, 0)
-	chB := make(chan pb.Command, 0)
-	chC := make(chan pb.Command, 0)
-	chD := make(chan pb.Command, 0)
+func BenchmarkSkipListVsAVLTree(b *testing.B) {
+	scenarios := []struct {
+		numCmds      uint64
+		writePercent int
+		diffKeys     int
+		p, n         uint64
+	}{
+		{1000, 50, 100, 0, 1000},
+		{10000, 50, 1000, 0, 10000},
+		{100000, 50, 10000, 5000, 12000},
+	}
 
-	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
-	wg.Add(4)
+	for _, sc := range scenarios {
+		name := strconv.FormatUint(sc.numCmds, 10)
+		for _, id := range []uint8{2, 5} {
+			st, err := generateRandStructure(id, sc.numCmds, sc.writePercent, sc.diffKeys, nil)
+			if err != nil {
+				b.Log(err.Error())
+				b.FailNow()
+			}
 
-	go runTraditionalLog(chD, numCommands, mu, wg)
-	go runAlgorithm(GreedyAvl, chA, numCommands, mu, wg)
-	go runAlgorithm(IterBFSAvl, chB, numCommands, mu, wg)
-	go runAlgorithm(IterDFSAvl, chC, numCommands, mu, wg)
+			alg, label := GreedyAvl, "GreedyAvl-"
+			if id == 5 {
+				alg, label = GreedySkip, "GreedySkip-"
+			}
 
-	// fan-out that output to the different goroutines
-	go splitIntoWorkers(log, chA, chB, chC, chD)
+			b.Run(label+name+"-Reduce", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					ApplyReduceAlgo(st, alg, sc.p, sc.n)
+				}
+			})
 
-	// close the input log channel once all algorithms are executed
-	wg.Wait()
-	close(log)
+			b.Run(label+name+"-Log", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := generateRandStructure(id, sc.numCmds, sc.writePercent, sc.diffKeys, nil); err != nil {
+						b.Log(err.Error())
+						b.FailNow()
+					}
+				}
+			})
+		}
+	}
 }
 
 func createRandomLog(n uint64, dif, wrt int, out chan<- pb.Command) {
@@ -393,176 +712,441 @@ func createRandomLog(n uint64, dif, wrt int, out chan<- pb.Command) {
 	out <- pb.Command{}
 }
 
-func splitIntoWorkers(src <-chan pb.Command, wrks ...chan<- pb.Command) {
-	for {
-		select {
-		case cmd, ok := <-src:
-			if !ok {
-				return
-			}
-			for _, ch := range wrks {
-				// avoid blocking receive on the sync ch
-				go func(dest chan<- pb.Command, c pb.Command) {
-					dest <- c
-				}(ch, cmd)
-			}
+// logsRetainSameCommands checks if two logs contain the same commands, and if commands
+// over the same key are recorded on the same relative order.
+func logsRetainSameCommands(logA, logB []pb.Command) bool {
+	if len(logA) != len(logB) {
+		return false
+	}
+
+	byKeyA := make(map[string][]uint64)
+	byKeyB := make(map[string][]uint64)
+	for i := range logA {
+		byKeyA[logA[i].Key] = append(byKeyA[logA[i].Key], logA[i].Id)
+		byKeyB[logB[i].Key] = append(byKeyB[logB[i].Key], logB[i].Id)
+	}
+	return reflect.DeepEqual(byKeyA, byKeyB)
+}
+
+func TestParIterConcTableOnView(t *testing.T) {
+	nCmds, wrt, dif := uint64(20000), 80, 5000
+	ct := NewConcTable(context.Background())
+
+	ch := make(chan pb.Command, nCmds+1)
+	go createRandomLog(nCmds, dif, wrt, ch)
+
+	for cmd := range ch {
+		// last command signal
+		if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+			break
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	view := ct.retrieveCurrentViewCopy()
+	seq := IterConcTableOnView(&view)
+	if len(seq) == 0 {
+		t.Log("expected a non-empty reduced view")
+		t.FailNow()
+	}
+
+	for _, w := range []int{1, 2, 3, 8, 2 * dif} {
+		par := ParIterConcTableOnView(&view, w)
+		if !logsAreEquivalent(seq, par) {
+			t.Log("parallel reduce with", w, "workers presented different results, incoherent")
+			t.FailNow()
 		}
 	}
+	ct.Shutdown()
 }
 
-func runAlgorithm(alg Reducer, log <-chan pb.Command, n uint64, mu *sync.Mutex, wg *sync.WaitGroup) {
-	avl := NewAVLTreeHT()
-	var i uint64
-	defer wg.Done()
-
-	start := time.Now()
-	for {
-		select {
-		case cmd, ok := <-log:
-			if !ok {
-				return
+func BenchmarkAVLTreeConstruction(b *testing.B) {
+	nCmds, wrt, dif := uint64(10000), 50, 1000
+	cmds := make([]pb.Command, 0, nCmds)
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	for i := uint64(0); i < nCmds; i++ {
+		cmds = append(cmds, <-ch)
+	}
+
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			avl := NewAVLTreeHT()
+			for _, cmd := range cmds {
+				avl.Log(cmd)
 			}
+			GreedyAVLTreeHT(avl, 0, nCmds)
+		}
+	})
 
-			if i < n {
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		avl := NewAVLTreeHT()
+		for i := 0; i < b.N; i++ {
+			for _, cmd := range cmds {
 				avl.Log(cmd)
-				i++
+			}
+			GreedyAVLTreeHT(avl, 0, nCmds)
+			avl.Reset()
+		}
+	})
+}
 
-			} else {
-				// finished logging
-				goto BREAK
+func BenchmarkAVLTreeInsert(b *testing.B) {
+	for _, n := range []int{10000, 100000, 1000000} {
+		for _, iter := range []bool{false, true} {
+			label := "Recursive-"
+			if iter {
+				label = "Iterative-"
 			}
+
+			b.Run(label+strconv.Itoa(n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					av, err := NewAVLTreeHTWithConfig(&LogConfig{Inmem: true, Tick: Delayed, IterativeInsert: iter})
+					if err != nil {
+						b.Log(err.Error())
+						b.FailNow()
+					}
+					b.StartTimer()
+
+					for j := 0; j < n; j++ {
+						av.insert(&avlTreeEntry{ind: uint64(j)})
+					}
+				}
+			})
 		}
 	}
+}
 
-BREAK:
-	// elapsed time to interpret the sequence of commands and construct the tree struct
-	construct := time.Since(start)
-
-	var (
-		fn, id string
-		out    []pb.Command
+func TestRegisterReducer(t *testing.T) {
+	// retains every state, discarding any compaction
+	identity := func(states []pb.Command, p, n uint64) ([]pb.Command, error) {
+		return states, nil
+	}
+	for _, k := range []StructureKind{ListKind, RadixKind} {
+		if _, ok := lookupReducer("identity", k); ok {
+			continue // already registered by a previous run (e.g. -count)
+		}
+		if err := RegisterReducer("identity", k, identity); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if err := RegisterReducer("identity", ListKind, identity); err == nil {
+		t.Log("expected an error on duplicated registration")
+		t.FailNow()
+	}
+	if err := RegisterReducer("identity", StructureKind("unknown"), identity); err == nil {
+		t.Log("expected an error on unknown structure kind")
+		t.FailNow()
+	}
 
-		// elapsed time to recovery the entire log
-		recov time.Duration
-	)
+	cfg := LogConfig{Inmem: true, Tick: Delayed, AlgName: "identity"}
+	list, err := NewListHTWithConfig(&cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	radix, _ := NewRadixHTWithConfig(&cfg)
+	array, _ := NewArrayHTWithConfig(&cfg)
+
+	for i := 0; i < 100; i++ {
+		cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 10), Value: strconv.Itoa(i)}
+		for _, st := range []Structure{list, radix, array} {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+	}
 
-	switch alg {
-	case GreedyAvl:
-		start = time.Now()
-		out = GreedyAVLTreeHT(avl, 0, n)
-		recov = time.Since(start)
+	cmds, err := list.Recov(20, 79)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(cmds) != 60 || cmds[0].Id != 20 || cmds[59].Id != 79 {
+		t.Log("expected every state within [20, 79] on index order, got", len(cmds), "states")
+		t.FailNow()
+	}
 
-		id = "RecurGreedy Benchmark"
-		fn = "recurgreedy-bench.out"
-		break
+	// radix retains only the latest state of each key
+	cmds, err = radix.Recov(0, 99)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(cmds) != 10 {
+		t.Log("expected 10 states, got", len(cmds))
+		t.FailNow()
+	}
 
-	case IterBFSAvl:
-		start = time.Now()
-		out = IterBFSAVLTreeHT(avl, 0, n)
-		recov = time.Since(start)
+	if _, err := array.Recov(0, 99); err == nil {
+		t.Log("expected an error on a reducer not registered for arrays")
+		t.FailNow()
+	}
 
-		id = "IterBFS Benchmark"
-		fn = "iterbfs-bench.out"
-		break
+	invalid := LogConfig{Inmem: true, AlgName: "never-registered"}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on an unknown config.AlgName")
+		t.FailNow()
+	}
+}
 
-	case IterDFSAvl:
-		start = time.Now()
-		out = IterDFSAVLTreeHT(avl, 0, n)
-		recov = time.Since(start)
+func TestReduceKeepLastVersions(t *testing.T) {
+	versions := func(alg Reducer) *LogConfig {
+		return &LogConfig{Inmem: true, Tick: Delayed, Alg: alg, VersionsToKeep: 3}
+	}
+	list, _ := NewListHTWithConfig(versions(GreedyLt))
+	array, _ := NewArrayHTWithConfig(versions(GreedyArray))
+	avl, _ := NewAVLTreeHTWithConfig(versions(IterDFSAvl))
+	skip, _ := NewSkipListHTWithConfig(versions(GreedySkip))
+
+	for i, st := range []Structure{list, array, avl, skip} {
+		for j := 0; j < 100; j++ {
+			cmd := pb.Command{Id: uint64(j), Op: pb.Command_SET, Key: strconv.Itoa(j % 10), Value: strconv.Itoa(j)}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
 
-		id = "IterDFS Benchmark"
-		fn = "iterdfs-bench.out"
-		break
+		cmds, err := st.Recov(0, 99)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(cmds) != 30 {
+			t.Log("structure", i, "expected 30 states, got", len(cmds))
+			t.FailNow()
+		}
+		for _, c := range cmds {
+			if c.Id < 70 {
+				t.Log("structure", i, "retained an older version:", c.String())
+				t.FailNow()
+			}
+		}
+	}
 
-	default:
-		fmt.Println("unrecognized algorithm '", alg, "' provided")
-		return
+	// the oldest retained version still carries the states it depends on
+	chain := []pb.Command{
+		{Id: 0, Op: pb.Command_SET, Key: "a", Value: "0"},
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "2", Expected: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "b", Value: "0"},
+	}
+	if log := keepLastVersions(chain, 1); len(log) != 3 || log[0].Id != 1 {
+		t.Log("expected the CAS chain to be retained, got", log)
+		t.FailNow()
 	}
 
-	start = time.Now()
-	err := dumpLogIntoFile("./", fn, out)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	dump := time.Since(start)
-
-	mu.Lock()
-	fmt.Println(
-		"\n====================",
-		"\n===", id,
-		"\nRemoved cmds: ", n-uint64(len(out)),
-		"\nConstruction Time: ", construct,
-		"\nCompactation Time: ", recov,
-		"\nInstallation Time:", dump,
-		"\n====================",
-	)
-	mu.Unlock()
+	if _, err := NewRadixHTWithConfig(versions(IterRadix)); err == nil {
+		t.Log("expected an error on structures retaining only the latest states")
+		t.FailNow()
+	}
 }
 
-func runTraditionalLog(log <-chan pb.Command, n uint64, mu *sync.Mutex, wg *sync.WaitGroup) {
-	logfile := make([]pb.Command, 0, n)
-	var i uint64
-	defer wg.Done()
-
-	start := time.Now()
-	for {
-		select {
-		case cmd, ok := <-log:
-			if !ok {
-				return
+func TestReduceCompactWindow(t *testing.T) {
+	window := func(alg Reducer) *LogConfig {
+		return &LogConfig{Inmem: true, Tick: Delayed, Alg: alg, CompactWindow: 20}
+	}
+	list, _ := NewListHTWithConfig(window(GreedyLt))
+	avl, _ := NewAVLTreeHTWithConfig(window(IterDFSAvl))
+
+	for i, st := range []Structure{list, avl} {
+		for j := 0; j < 100; j++ {
+			cmd := pb.Command{Id: uint64(j), Op: pb.Command_SET, Key: strconv.Itoa(j % 10), Value: strconv.Itoa(j)}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
 			}
+		}
 
-			if i < n {
-				logfile = append(logfile, cmd)
-				i++
+		cmds, err := st.Recov(0, 99)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
 
-			} else {
-				// finished logging
-				goto BREAK
+		// latest state of each key before the window, then every command within it
+		if len(cmds) != 30 {
+			t.Log("structure", i, "expected 30 states, got", len(cmds))
+			t.FailNow()
+		}
+		for j, c := range cmds {
+			if c.Id != uint64(70+j) {
+				t.Log("structure", i, "expected index", 70+j, "got", c.Id)
+				t.FailNow()
 			}
 		}
 	}
 
-BREAK:
-	construct := time.Since(start)
-	fn := "traditionallog-bench.out"
+	if _, err := NewConcTableWithConfig(context.TODO(), 2, window(IterConcTable)); err == nil {
+		t.Log("expected an error on structures retaining only the latest states")
+		t.FailNow()
+	}
+}
 
-	start = time.Now()
-	err := dumpLogIntoFile("./", fn, logfile)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	dump := time.Since(start)
-
-	mu.Lock()
-	fmt.Println(
-		"\n====================",
-		"\n=== Traditional Log Benchmark",
-		"\nRemoved cmds:", n-uint64(len(logfile)),
-		"\nConstruction Time:", construct,
-		"\nCompactation Time: -",
-		"\nInstallation Time:", dump,
-		"\n====================",
-	)
-	mu.Unlock()
+func TestVerifyReducedLog(t *testing.T) {
+	original := []pb.Command{
+		{Id: 0, Op: pb.Command_SET, Key: "a", Value: "0"},
+		{Id: 1, Op: pb.Command_SET, Key: "b", Value: "0"},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "1", Expected: "0"},
+		{Id: 3, Op: pb.Command_GET, Key: "b"},
+		{Id: 4, Op: pb.Command_SET, Key: "c", Value: "0"},
+		{Id: 5, Op: pb.Command_DELETE_PREFIX, Key: "c"},
+		{Id: 6, Op: pb.Command_SET, Key: "b", Value: "1"},
+	}
+
+	// equivalent, even if recorded on a different order
+	reduced := []pb.Command{original[6], original[0], original[2]}
+	if res := VerifyReducedLog(original, reduced); !res.Equivalent {
+		t.Log("expected equivalent logs, got divergences:", res.Divergences)
+		t.FailNow()
+	}
+
+	// a CAS without the state it was applied over is never applied
+	reduced = []pb.Command{original[2], original[6], original[4]}
+	res := VerifyReducedLog(original, reduced)
+	if res.Equivalent || len(res.Divergences) != 2 {
+		t.Log("expected two divergent keys, got", res.Divergences)
+		t.FailNow()
+	}
+	if d := res.Divergences[0]; d.Key != "a" || d.Original == nil || d.Reduced != nil {
+		t.Log("expected key 'a' missing on the reduced log, got", d)
+		t.FailNow()
+	}
+	if d := res.Divergences[1]; d.Key != "c" || d.Original != nil || d.Reduced == nil {
+		t.Log("expected key 'c' missing on the original log, got", d)
+		t.FailNow()
+	}
 }
 
-func dumpLogIntoFile(folder, name string, log []pb.Command) error {
-	if _, exists := os.Stat(folder); os.IsNotExist(exists) {
-		os.Mkdir(folder, 0744)
+func TestSearchEntryByIndex(t *testing.T) {
+	// entries are logged on every tenth index, from 10 to 50
+	l, ar := NewListHT(), NewArrayHT()
+	for i := uint64(10); i <= 50; i += 10 {
+		cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.FormatUint(i, 10)}
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := ar.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
 	}
 
-	out, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0744)
-	if err != nil {
-		return err
+	testCases := []struct {
+		ind   uint64
+		pos   uint64
+		found bool
+	}{
+		{0, 0, false},
+		{10, 0, true},
+		{11, 1, false},
+		{30, 2, true},
+		{39, 3, false},
+		{50, 4, true},
+		{51, 5, false},
 	}
-	defer out.Close()
+	for _, tc := range testCases {
+		pos, found := ar.searchEntryPosByIndex(tc.ind)
+		if pos != tc.pos || found != tc.found {
+			t.Logf("array search of %d expected (%d, %t), got (%d, %t)", tc.ind, tc.pos, tc.found, pos, found)
+			t.FailNow()
+		}
 
+		nd, found := l.searchEntryNodeByIndex(tc.ind)
+		if found != tc.found || (nd == nil) != (tc.pos == 5) || (nd != nil && nd.val.(*listEntry).ind != 10*(tc.pos+1)) {
+			t.Logf("list search of %d expected (%d, %t), got (%v, %t)", tc.ind, 10*(tc.pos+1), tc.found, nd, found)
+			t.FailNow()
+		}
+	}
+
+	// empty structures have no lower bound
+	if pos, found := NewArrayHT().searchEntryPosByIndex(10); pos != 0 || found {
+		t.Logf("expected (0, false) on an empty array, got (%d, %t)", pos, found)
+		t.FailNow()
+	}
+	if nd, found := NewListHT().searchEntryNodeByIndex(10); nd != nil || found {
+		t.Logf("expected (nil, false) on an empty list, got (%v, %t)", nd, found)
+		t.FailNow()
+	}
+}
+
+func TestGreedyBoundaryIntervals(t *testing.T) {
+	nCmds, dif, wrt := uint64(500), 50, 50
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	l, ar := NewListHT(), NewArrayHT()
+	log := make([]pb.Command, 0, nCmds)
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		log = append(log, cmd)
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := ar.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// intervals starting and ending on reads, not mapped by any entry, and past the
+	// logged indexes
+	var reads []uint64
 	for _, cmd := range log {
-		_, err = fmt.Fprintf(out, "%d %s %v\n", cmd.Op, cmd.Key, cmd.Value)
-		if err != nil {
-			return err
+		if cmd.Op != pb.Command_SET {
+			reads = append(reads, cmd.Id)
+		}
+	}
+	if len(reads) < 2 {
+		t.Log("expected at least two reads on the generated log")
+		t.FailNow()
+	}
+	intervals := [][2]uint64{
+		{0, nCmds - 1},
+		{reads[0], nCmds - 1},
+		{0, reads[len(reads)-1]},
+		{reads[0], reads[len(reads)-1]},
+		{reads[len(reads)/2], reads[len(reads)/2]},
+		{nCmds - 1, nCmds + 100},
+		{nCmds + 1, nCmds + 100},
+	}
+
+	for _, in := range intervals {
+		p, n := in[0], in[1]
+		latest := make(map[string]string)
+		var count int
+		for _, cmd := range log {
+			if cmd.Id >= p && cmd.Id <= n && cmd.Op == pb.Command_SET {
+				if _, ok := latest[cmd.Key]; !ok {
+					count++
+				}
+				latest[cmd.Key] = cmd.ValueString()
+			}
+		}
+
+		for _, recv := range [][]pb.Command{GreedyListHT(l, p, n), GreedyArrayHT(ar, p, n)} {
+			if len(recv) != count {
+				t.Logf("expected %d commands on [%d, %d], got %d", count, p, n, len(recv))
+				t.FailNow()
+			}
+			for _, cmd := range recv {
+				if cmd.Id < p || cmd.Id > n || latest[cmd.Key] != cmd.ValueString() {
+					t.Logf("unexpected command %v on [%d, %d]", cmd, p, n)
+					t.FailNow()
+				}
+			}
 		}
 	}
-	return nil
 }
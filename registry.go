@@ -0,0 +1,203 @@
+package beelog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// StructureKind identifies each log structure on the reducer registry.
+type StructureKind string
+
+const (
+	ListKind      StructureKind = "list"
+	ArrayKind     StructureKind = "array"
+	AVLKind       StructureKind = "avl"
+	CircBuffKind  StructureKind = "circbuff"
+	ConcTableKind StructureKind = "conctable"
+	SkipListKind  StructureKind = "skiplist"
+	LSMKind       StructureKind = "lsm"
+	RadixKind     StructureKind = "radix"
+	BTreeKind     StructureKind = "btree"
+)
+
+// ReduceFunc is a custom reduce algorithm, compacting 'states' into the log of interval
+// [p, n] persisted and returned by recoveries. 'states' are every state retained by the
+// structure within [p, n], on index order: structures indexing every update of a key
+// (i.e. ListHT, ArrayHT, AVLTreeHT, CircBuffHT and SkipListHT) retain its entire
+// history, while others retain only its latest state, preceded by any prior states it
+// depends on. Range deletes are applied over the returned log, and must not be handled
+// by the algorithm.
+type ReduceFunc func(states []pb.Command, p, n uint64) ([]pb.Command, error)
+
+type reducerKey struct {
+	name string
+	kind StructureKind
+}
+
+var registry = struct {
+	sync.RWMutex
+	reducers map[reducerKey]ReduceFunc
+}{reducers: make(map[reducerKey]ReduceFunc)}
+
+// RegisterReducer registers 'fn' as the reduce algorithm 'name' of structures of
+// 'kind', resolved on configs informing the same 'config.AlgName'. An algorithm can be
+// registered for multiple kinds under the same name, but never twice for the same kind.
+func RegisterReducer(name string, kind StructureKind, fn ReduceFunc) error {
+	if name == "" || fn == nil {
+		return errors.New("must inform a reducer name and a non-nil ReduceFunc")
+	}
+	if !kind.valid() {
+		return fmt.Errorf("unknown structure kind '%s'", kind)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	k := reducerKey{name, kind}
+	if _, ok := registry.reducers[k]; ok {
+		return fmt.Errorf("reducer '%s' already registered for %s structures", name, kind)
+	}
+	registry.reducers[k] = fn
+	return nil
+}
+
+// lookupReducer returns the reduce algorithm registered as 'name' for 'kind', if any.
+func lookupReducer(name string, kind StructureKind) (ReduceFunc, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	fn, ok := registry.reducers[reducerKey{name, kind}]
+	return fn, ok
+}
+
+// isRegisteredReducer informs if 'name' is registered for any structure kind.
+func isRegisteredReducer(name string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+	for k := range registry.reducers {
+		if k.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (k StructureKind) valid() bool {
+	switch k {
+	case ListKind, ArrayKind, AVLKind, CircBuffKind, ConcTableKind, SkipListKind,
+		LSMKind, RadixKind, BTreeKind:
+		return true
+	}
+	return false
+}
+
+// kindOf returns the kind of structure 's'.
+func kindOf(s Structure) StructureKind {
+	switch s.(type) {
+	case *ListHT:
+		return ListKind
+	case *ArrayHT:
+		return ArrayKind
+	case *AVLTreeHT:
+		return AVLKind
+	case *CircBuffHT:
+		return CircBuffKind
+	case *ConcTable:
+		return ConcTableKind
+	case *SkipListHT:
+		return SkipListKind
+	case *LSMLog:
+		return LSMKind
+	case *RadixHT:
+		return RadixKind
+	case *BTreeHT:
+		return BTreeKind
+	}
+	return ""
+}
+
+// applyReduce reduces the interval [p, n] of 's', the structure embedding 'ld', through
+// the configured algorithm: a registered one if 'config.AlgName' is set, retaining the
+// history of keys if 'config.VersionsToKeep' or 'config.CompactWindow' are set, or
+// 'config.Alg' otherwise.
+// Must only be called within mutual exclusion scope.
+func (ld *logData) applyReduce(s Structure, p, n uint64) ([]pb.Command, error) {
+	if ld.config.AlgName == "" && !ld.config.retainsHistory() {
+		return ApplyReduceAlgo(s, ld.config.Alg, p, n)
+	}
+	if structLen(s) < 1 {
+		return nil, errors.New("empty structure")
+	}
+
+	rs, ok := s.(interface {
+		retainedStates(p, n uint64) ([]pb.Command, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("registered reducers and history retention are not supported on %s structures", kindOf(s))
+	}
+	states, err := rs.retainedStates(p, n)
+	if err != nil {
+		return nil, err
+	}
+	log, err := ld.reduceStates(kindOf(s), states, p, n)
+	if err != nil {
+		return nil, err
+	}
+	return ld.applyRangeDeletes(log, p, n), nil
+}
+
+// reduceStates reduces the retained 'states' of a structure of 'kind', either through
+// a registered algorithm or retaining the latest 'config.VersionsToKeep' of each key,
+// except for states within 'config.CompactWindow', retained verbatim.
+func (ld *logData) reduceStates(kind StructureKind, states []pb.Command, p, n uint64) ([]pb.Command, error) {
+	if ld.config.AlgName != "" {
+		return ld.applyRegisteredReducer(kind, states, p, n)
+	}
+	sort.SliceStable(states, func(i, j int) bool { return states[i].Id < states[j].Id })
+	return compactBeforeWindow(states, n, ld.config.CompactWindow, ld.config.VersionsToKeep), nil
+}
+
+// applyRegisteredReducer reduces 'states' of a structure of 'kind' through the
+// algorithm registered as 'config.AlgName'.
+func (ld *logData) applyRegisteredReducer(kind StructureKind, states []pb.Command, p, n uint64) ([]pb.Command, error) {
+	fn, ok := lookupReducer(ld.config.AlgName, kind)
+	if !ok {
+		return nil, fmt.Errorf("reducer '%s' is not registered for %s structures", ld.config.AlgName, kind)
+	}
+	sort.SliceStable(states, func(i, j int) bool { return states[i].Id < states[j].Id })
+	return fn(states, p, n)
+}
+
+// statesWithin returns every state logged within [p, n] on the table.
+func (ht stateTable) statesWithin(p, n uint64) []pb.Command {
+	states := make([]pb.Command, 0)
+	for _, l := range ht {
+		for nd := l.first; nd != nil; nd = nd.next {
+			st := nd.val.(*State)
+			if st.ind >= p && st.ind <= n {
+				states = append(states, st.cmd)
+			}
+		}
+	}
+	return states
+}
+
+// chainStates returns the latest state of every key on the table, preceded by any
+// prior states it depends on.
+func (tbl minStateTable) chainStates() []pb.Command {
+	return seqIterConcTableOnView(&tbl, nil, nil)
+}
+
+// bufferedStates returns every state buffered on the copy.
+func (cp *buffCopy) bufferedStates() []pb.Command {
+	states := make([]pb.Command, 0, cp.len+len(cp.spill))
+	for _, ent := range cp.spill {
+		states = append(states, ent.st.cmd)
+	}
+	for i := 0; i < cp.len; i++ {
+		states = append(states, cp.buf[modInt(cp.cur-cp.len+i, cp.cap)].st.cmd)
+	}
+	return states
+}
@@ -0,0 +1,107 @@
+package beelog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Applier is a user state machine, applying the commands of recovered logs.
+type Applier interface {
+	Apply(cmd pb.Command) error
+}
+
+// ApplierFunc adapts an ordinary function into an Applier.
+type ApplierFunc func(cmd pb.Command) error
+
+// Apply ...
+func (f ApplierFunc) Apply(cmd pb.Command) error {
+	return f(cmd)
+}
+
+// defaultProgressEvery is the default number of applied commands between progress
+// reports.
+const defaultProgressEvery = 1000
+
+// ReplayProgress reports the state of an ongoing replay.
+type ReplayProgress struct {
+	Applied int    // commands applied so far
+	Total   int    // commands on the replayed log
+	Last    uint64 // index of the latest applied command
+}
+
+// ReplayOptions configures Replay procedures. A nil options is valid, never reporting
+// progress.
+type ReplayOptions struct {
+	// invoked after every 'ProgressEvery' applied commands, and once after the last
+	// one. Zero 'ProgressEvery' reports every 1000 commands
+	Progress      func(ReplayProgress)
+	ProgressEvery int
+}
+
+// ReplayError is returned by Replay procedures once the Applier fails, interrupting
+// the replay. Every command prior to 'Id' was already applied.
+type ReplayError struct {
+	Id      uint64 // index of the failed command
+	Applied int    // commands successfully applied before the failure
+	Err     error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("failed applying command %d, after %d applied commands, err: '%s'", e.Id, e.Applied, e.Err.Error())
+}
+
+// Unwrap returns the error reported by the Applier.
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// Replay applies every command of the recovered log 'cmds' on 'ap', on their index
+// order. Reduced logs may record commands on any order (e.g. by key), but replaying
+// them by index always yields the state of the original execution, since range deletes
+// only precede states they dont discard, and conditional updates follow the states
+// they were applied over. 'cmds' is never modified. The replay is interrupted on the
+// first failed apply, returning a *ReplayError.
+func Replay(cmds []pb.Command, ap Applier, opts *ReplayOptions) error {
+	order := make([]int, len(cmds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return cmds[order[i]].Id < cmds[order[j]].Id })
+
+	var o ReplayOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.ProgressEvery <= 0 {
+		o.ProgressEvery = defaultProgressEvery
+	}
+
+	prog := ReplayProgress{Total: len(cmds)}
+	for _, i := range order {
+		if err := ap.Apply(cmds[i]); err != nil {
+			return &ReplayError{Id: cmds[i].Id, Applied: prog.Applied, Err: err}
+		}
+		prog.Applied++
+		prog.Last = cmds[i].Id
+
+		if o.Progress != nil && (prog.Applied%o.ProgressEvery == 0 || prog.Applied == prog.Total) {
+			o.Progress(prog)
+		}
+	}
+	return nil
+}
+
+// ReplayFromReader is analogous to Replay, but decodes the serialized log from 'rd',
+// on both beelog and traditional formats. The entire log is decoded before applying
+// any command, ordering them by index, where a truncated or corrupted log is reported
+// without applying any of its commands.
+func ReplayFromReader(rd io.Reader, ap Applier, opts *ReplayOptions) error {
+	cmds, err := UnmarshalLogInto(rd, nil)
+	if err != nil {
+		return err
+	}
+	return Replay(cmds, ap, opts)
+}
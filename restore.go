@@ -0,0 +1,133 @@
+package beelog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// loadPersistedState reads the latest state persisted by a prior execution, returning
+// its commands and covered interval [p, n]. On KeepAll configs, every segment recorded
+// on the manifest is merged into a single state, retaining the latest state of each
+// key. Returns false if no state was persisted yet.
+func (ld *logData) loadPersistedState() ([]pb.Command, uint64, uint64, bool, error) {
+	if ld.idx != nil {
+		segs, err := ld.idx.overlapping(ld.storage(), 0, ^uint64(0))
+		if err != nil || len(segs) == 0 {
+			return nil, 0, 0, false, err
+		}
+
+		var p, n uint64
+		all := make([]pb.Command, 0)
+		for i, sg := range segs {
+			cmds, hdr, err := ld.readPersistedSegment(sg.Name)
+			if err != nil {
+				return nil, 0, 0, false, err
+			}
+			if i == 0 || hdr.First < p {
+				p = hdr.First
+			}
+			if hdr.Last > n {
+				n = hdr.Last
+			}
+			all = append(all, cmds...)
+		}
+		return mergeLatestStates(all), p, n, true, nil
+	}
+
+	// on striped configs, the latest state may be persisted on any disk
+	fn, err := ld.stateFname()
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	if _, err := ld.storage().Size(fn); err != nil {
+		return nil, 0, 0, false, nil
+	}
+	cmds, hdr, err := ld.readPersistedSegment(fn)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	return cmds, hdr.First, hdr.Last, true, nil
+}
+
+// readPersistedSegment returns the commands and header of segment 'fn'.
+func (ld *logData) readPersistedSegment(fn string) ([]pb.Command, LogHeader, error) {
+	rd, err := ld.readSegment(fn)
+	if err != nil {
+		return nil, LogHeader{}, fmt.Errorf("failed while opening log '%s', err: '%s'", fn, err.Error())
+	}
+	defer rd.Close()
+
+	_, hdr, err := ReadLogHeader(newSegmentCursor(rd))
+	if err != nil {
+		return nil, hdr, fmt.Errorf("failed while reading log '%s', err: '%s'", fn, err.Error())
+	}
+	cmds, err := UnmarshalLogFromReader(newSegmentCursor(rd))
+	if err != nil {
+		return nil, hdr, fmt.Errorf("failed while decoding log '%s', err: '%s'", fn, err.Error())
+	}
+	return cmds, hdr, nil
+}
+
+// restoreOnInit loads the latest persisted state on RestoreOnInit configs, recording
+// each of its commands through 'logCmd'. Commands are recorded under a Delayed config,
+// never triggering reduces of the already persisted state. Once loaded, the structure
+// interval matches the restored one.
+func (ld *logData) restoreOnInit(logCmd func(ctx context.Context, cmd pb.Command) error) error {
+	if !ld.config.RestoreOnInit {
+		return nil
+	}
+	cmds, p, n, ok, err := ld.loadPersistedState()
+	if err != nil || !ok {
+		return err
+	}
+
+	cfg := ld.config
+	delayed := *cfg
+	delayed.Tick = Delayed
+	ld.config = &delayed
+	defer func() { ld.config = cfg }()
+
+	for _, c := range cmds {
+		if err := logCmd(context.Background(), c); err != nil {
+			return err
+		}
+	}
+	ld.first, ld.last, ld.logged = p, n, true
+	ld.stats.observeInterval(p, n)
+	ld.recordReduced(p, n)
+	ld.seq.resume(n)
+	return nil
+}
+
+// restoreOnInit loads the latest persisted state into the first view of the table on
+// RestoreOnInit configs. Called during construction, before any reduce routine is
+// launched.
+func (ct *ConcTable) restoreOnInit() error {
+	ld := &ct.logs[0]
+	if !ld.config.RestoreOnInit {
+		return nil
+	}
+	cmds, p, n, ok, err := ld.loadPersistedState()
+	if err != nil || !ok {
+		return err
+	}
+
+	for _, c := range cmds {
+		if isRangeDelete(c.Op) {
+			ld.tombs.add(c)
+			continue
+		}
+		ld.recordPinned(&c)
+		ct.views[0][c.Key] = State{ind: c.Id, cmd: c}
+		ct.order[0] = append(ct.order[0], buffEntry{ind: c.Id, key: c.Key})
+	}
+	ld.first, ld.last, ld.logged = p, n, true
+	ld.stats.observeInterval(p, n)
+	ld.recordReduced(p, n)
+	ld.seq.resume(n)
+	ld.viewBytes = ct.views[0].memBytes() + int64(len(ct.order[0]))*buffEntBytes
+	ct.lastInd = n
+	return nil
+}
@@ -0,0 +1,68 @@
+package beelog
+
+import "github.com/Lz-Gustavo/beelog/pb"
+
+// RecovResult is a recovered log along with the interval of indexes it covers, allowing
+// consumers to resume consensus from 'Last' + 1. Scheduled reduce configs (e.g.
+// Interval) only cover the interval of the latest reduce, never the commands logged
+// after it, while Delayed configs cover the requested interval.
+type RecovResult struct {
+	// recovered commands on RecovWithResult calls, or their serialized log on
+	// RecovBytesWithResult ones
+	Cmds []pb.Command
+	Raw  []byte
+
+	// First and Last are the indexes covered by the recovered log
+	First, Last uint64
+
+	// false if no reduced state covers any index of the requested interval, in which
+	// case First and Last are the requested indexes
+	Reduced bool
+}
+
+// ResultRecoverer is implemented by structures informing the interval of indexes
+// covered by recovered logs (i.e. ListHT, ArrayHT, AVLTreeHT, SkipListHT, CircBuffHT
+// and ConcTable).
+type ResultRecoverer interface {
+	RecovWithResult(p, n uint64) (RecovResult, error)
+	RecovBytesWithResult(p, n uint64) (RecovResult, error)
+}
+
+// recordReduced records [p, n] as the interval of the latest reduced state.
+func (ld *logData) recordReduced(p, n uint64) {
+	ld.reduced.Store(IndexInterval{First: p, Last: n})
+}
+
+// coverage returns the interval covered by a log recovered for [p, n], where the latest
+// reduced states are trimmed to the requested interval if 'trimmed', or returned
+// entirely otherwise. Delayed configs reduce the requested interval, and KeepAll ones
+// merge every segment since the first logged index. Indexes not yet logged are never
+// covered, since they may still be logged.
+func (ld *logData) coverage(p, n uint64, trimmed bool) RecovResult {
+	res := RecovResult{First: p, Last: n}
+	red, ok := ld.reduced.Load().(IndexInterval)
+	logged, found := ld.LoggedInterval()
+	if !ok || !found {
+		return res
+	}
+
+	if trimmed {
+		if ld.config.Tick != Delayed && ld.idx != nil {
+			red.First = logged.First
+		}
+		if p > red.First {
+			red.First = p
+		}
+		if n < red.Last {
+			red.Last = n
+		}
+	}
+	if logged.Last < red.Last {
+		red.Last = logged.Last
+	}
+	if red.First > red.Last {
+		return res
+	}
+	res.First, res.Last, res.Reduced = red.First, red.Last, true
+	return res
+}
@@ -0,0 +1,87 @@
+package beelog
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// errChanSize is the number of persistence failures retained on the channel
+// returned by 'Err()' calls, older failures are discarded once it fills.
+const errChanSize = 16
+
+// RetryPolicy configures how many times a failed reduce procedure executed by a
+// background routine (i.e. logger or ticker routines) is retried, backing off
+// exponentially between attempts.
+type RetryPolicy struct {
+	// total number of attempts, zero or one disables retries
+	MaxAttempts int
+
+	// delay before the first retry, doubled after each failed attempt
+	Backoff time.Duration
+
+	// upper bound of the delay between attempts, unbounded if zero
+	MaxBackoff time.Duration
+}
+
+// do executes 'fn' until it succeeds, the configured attempts are exhausted or 'ctx'
+// is cancelled, returning the last observed error.
+func (rp RetryPolicy) do(ctx context.Context, fn func() error) error {
+	delay := rp.Backoff
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= rp.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if rp.MaxBackoff > 0 && delay > rp.MaxBackoff {
+			delay = rp.MaxBackoff
+		}
+	}
+}
+
+// errorSink reports persistence failures of background routines to the application,
+// instead of terminating the process. Never blocks a reporting routine.
+type errorSink struct {
+	ch chan error
+}
+
+func newErrorSink() *errorSink {
+	return &errorSink{ch: make(chan error, errChanSize)}
+}
+
+// report publishes 'err', discarding the oldest retained failure if the channel
+// is full.
+func (es *errorSink) report(err error) {
+	for {
+		select {
+		case es.ch <- err:
+			return
+		default:
+		}
+
+		select {
+		case <-es.ch:
+		default:
+		}
+	}
+}
+
+// Err returns a channel reporting failures of reduce procedures executed by background
+// routines, after every attempt configured by 'config.Retry' is exhausted. Commands of
+// a failed reduce are retained on the structure, and persisted on the next one.
+func (ld *logData) Err() <-chan error {
+	return ld.errs.ch
+}
+
+// reportReduceErr publishes a reduce failure observed on a background routine.
+func (ld *logData) reportReduceErr(err error) {
+	ld.errs.report(fmt.Errorf("failed during reduce procedure, err: %w", err))
+}
@@ -0,0 +1,342 @@
+// Package s3storage implements a beelog.LogStorage over S3-compatible object
+// stores, intended for replicas archiving every reduced segment of KeepAll
+// configs on cheap long-term storage.
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog"
+)
+
+// ObjectClient is the minimal set of object store operations required by Storage.
+// Users adapt their preferred SDK client (e.g. aws-sdk-go's s3.S3 or minio-go),
+// already bound to a bucket, to this interface.
+type ObjectClient interface {
+	// PutObject uploads 'body' as the entire content of 'key'.
+	PutObject(ctx context.Context, key string, body io.Reader, size int64) error
+
+	// GetObject streams the content of 'key'.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// ListObjects returns every key starting with 'prefix'.
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+
+	// DeleteObject removes 'key'.
+	DeleteObject(ctx context.Context, key string) error
+
+	// HeadObject returns the size of 'key'.
+	HeadObject(ctx context.Context, key string) (int64, error)
+}
+
+// ErrNotFound should be returned (or wrapped) by ObjectClient implementations when
+// a requested key doesnt exist.
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a beelog.LogStorage persisting each segment as an object identified
+// by its name, prefixed by a configured key prefix. Segments are staged in memory
+// while being written, and asynchronously uploaded once closed, so logger routines
+// aren't delayed by network round trips. Segments pending upload remain visible to
+// every read procedure.
+type Storage struct {
+	ctx    context.Context
+	client ObjectClient
+	prefix string
+
+	mu      sync.Mutex
+	pending map[string]*pendingObject
+	lanes   map[string]*lane
+	wg      sync.WaitGroup
+	err     error
+}
+
+type pendingObject struct {
+	data []byte
+}
+
+// lane serializes every remote write (i.e. uploads and deletes) of a single name,
+// referenced by each procedure waiting on it.
+type lane struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a new object store backed storage, where every segment name is
+// prefixed by 'prefix' to compose its object key.
+func New(ctx context.Context, client ObjectClient, prefix string) *Storage {
+	return &Storage{
+		ctx:     ctx,
+		client:  client,
+		prefix:  prefix,
+		pending: make(map[string]*pendingObject),
+		lanes:   make(map[string]*lane),
+	}
+}
+
+// Create ...
+func (s *Storage) Create(name string) (beelog.Segment, error) {
+	return &segment{st: s, name: name}, nil
+}
+
+// Append ...
+func (s *Storage) Append(name string) (beelog.Segment, error) {
+	data, err := s.read(name)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	return &segment{st: s, name: name, data: data, off: int64(len(data))}, nil
+}
+
+// ReadAt ...
+func (s *Storage) ReadAt(name string) (beelog.SegmentReader, error) {
+	data, err := s.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{bytes.NewReader(data)}, nil
+}
+
+// List ...
+func (s *Storage) List(pattern string) ([]string, error) {
+	keys, err := s.client.ListObjects(s.ctx, s.prefix+literalPrefix(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	found := make(map[string]bool, len(keys)+len(s.pending))
+	for name := range s.pending {
+		found[name] = true
+	}
+	s.mu.Unlock()
+
+	for _, k := range keys {
+		found[strings.TrimPrefix(k, s.prefix)] = true
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		ok, err := filepath.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete ...
+func (s *Storage) Delete(name string) error {
+	// waits any in-flight upload of 'name', which would re-create it otherwise
+	unlock := s.lockName(name)
+	defer unlock()
+
+	s.mu.Lock()
+	_, wasPending := s.pending[name]
+	delete(s.pending, name)
+	s.mu.Unlock()
+
+	err := s.client.DeleteObject(s.ctx, s.prefix+name)
+	if err != nil && wasPending && isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Size ...
+func (s *Storage) Size(name string) (int64, error) {
+	s.mu.Lock()
+	po, ok := s.pending[name]
+	s.mu.Unlock()
+
+	if ok {
+		return int64(len(po.data)), nil
+	}
+	return s.client.HeadObject(s.ctx, s.prefix+name)
+}
+
+// Wait blocks until every pending upload is finished, returning the first error
+// observed by an asynchronous upload, if any.
+func (s *Storage) Wait() error {
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// read returns the latest content of 'name', either staged for upload or
+// retrieved from the object store.
+func (s *Storage) read(name string) ([]byte, error) {
+	s.mu.Lock()
+	po, ok := s.pending[name]
+	s.mu.Unlock()
+
+	if ok {
+		return po.data, nil
+	}
+
+	rd, err := s.client.GetObject(s.ctx, s.prefix+name)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return ioutil.ReadAll(rd)
+}
+
+// upload sends 'data' as the new content of 'name'. If 'async' is set, returns
+// immediately after staging 'data', with upload errors reported on 'Wait'. Uploads
+// of the same name are serialized, and dropped if superseded before starting.
+func (s *Storage) upload(name string, data []byte, async bool) error {
+	po := &pendingObject{data: data}
+	s.mu.Lock()
+	s.pending[name] = po
+	s.mu.Unlock()
+
+	if !async {
+		return s.put(name, po)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.put(name, po); err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (s *Storage) put(name string, po *pendingObject) error {
+	unlock := s.lockName(name)
+	defer unlock()
+
+	// content superseded by a newer upload or a delete is dropped, so a stale
+	// upload never overwrites the latest remote content
+	s.mu.Lock()
+	stale := s.pending[name] != po
+	s.mu.Unlock()
+	if stale {
+		return nil
+	}
+
+	err := s.client.PutObject(s.ctx, s.prefix+name, bytes.NewReader(po.data), int64(len(po.data)))
+
+	// only unstage if no newer content was staged meanwhile
+	s.mu.Lock()
+	if err == nil && s.pending[name] == po {
+		delete(s.pending, name)
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// lockName acquires the lane of 'name', returning the procedure that releases it.
+func (s *Storage) lockName(name string) func() {
+	s.mu.Lock()
+	l, ok := s.lanes[name]
+	if !ok {
+		l = &lane{}
+		s.lanes[name] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.lanes, name)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// segment stages writes in memory, uploading its content on Sync or Close.
+type segment struct {
+	st     *Storage
+	name   string
+	data   []byte
+	off    int64
+	dirty  bool
+	closed bool
+}
+
+func (sg *segment) Write(p []byte) (int, error) {
+	n, err := sg.WriteAt(p, sg.off)
+	sg.off += int64(n)
+	return n, err
+}
+
+func (sg *segment) WriteAt(p []byte, off int64) (int, error) {
+	if sg.closed {
+		return 0, errors.New("write on closed segment")
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if end := off + int64(len(p)); end > int64(len(sg.data)) {
+		sg.data = append(sg.data, make([]byte, end-int64(len(sg.data)))...)
+	}
+	copy(sg.data[off:], p)
+	sg.dirty = true
+	return len(p), nil
+}
+
+// Sync synchronously uploads the segment content.
+func (sg *segment) Sync() error {
+	if !sg.dirty {
+		return nil
+	}
+	sg.dirty = false
+	return sg.st.upload(sg.name, append([]byte(nil), sg.data...), false)
+}
+
+// Close asynchronously uploads any content not yet synced.
+func (sg *segment) Close() error {
+	if sg.closed {
+		return nil
+	}
+	sg.closed = true
+
+	if !sg.dirty {
+		return nil
+	}
+	sg.dirty = false
+	return sg.st.upload(sg.name, sg.data, true)
+}
+
+type segmentReader struct {
+	*bytes.Reader
+}
+
+func (sr *segmentReader) Close() error {
+	return nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// literalPrefix returns the longest prefix of 'pattern' without any filepath.Match
+// meta character, utilized to narrow object listings.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
@@ -0,0 +1,303 @@
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lz-Gustavo/beelog"
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// memClient is an in-memory ObjectClient, optionally blocking uploads until
+// 'release' is closed. If 'held' is set, only uploads of that content are blocked.
+type memClient struct {
+	mu      sync.Mutex
+	objs    map[string][]byte
+	release chan struct{}
+	held    []byte
+}
+
+func newMemClient() *memClient {
+	return &memClient{objs: make(map[string][]byte)}
+}
+
+func (mc *memClient) PutObject(ctx context.Context, key string, body io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if mc.release != nil && (mc.held == nil || bytes.Equal(data, mc.held)) {
+		<-mc.release
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.objs[key] = data
+	return nil
+}
+
+func (mc *memClient) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	data, ok := mc.objs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (mc *memClient) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	keys := make([]string, 0)
+	for k := range mc.objs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (mc *memClient) DeleteObject(ctx context.Context, key string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if _, ok := mc.objs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(mc.objs, key)
+	return nil
+}
+
+func (mc *memClient) HeadObject(ctx context.Context, key string) (int64, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	data, ok := mc.objs[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(data)), nil
+}
+
+func TestStoragePendingUploadsAreVisible(t *testing.T) {
+	mc := newMemClient()
+	mc.release = make(chan struct{})
+	st := New(context.Background(), mc, "archive/")
+
+	seg, err := st.Create("./logstate.100.log")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err = seg.Write([]byte("content")); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	seg.Close()
+
+	// upload is still blocked, but the segment must be listed and readable
+	names, err := st.List("./logstate.*log")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(names) != 1 || names[0] != "./logstate.100.log" {
+		t.Log("expected a single pending segment, got:", names)
+		t.FailNow()
+	}
+
+	rd, err := st.ReadAt(names[0])
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if data, _ := ioutil.ReadAll(rd); string(data) != "content" {
+		t.Log("pending segment returned unexpected content:", string(data))
+		t.FailNow()
+	}
+
+	close(mc.release)
+	if err := st.Wait(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if _, ok := mc.objs["archive/./logstate.100.log"]; !ok {
+		t.Log("segment wasnt uploaded with the configured prefix, objects:", mc.objs)
+		t.FailNow()
+	}
+}
+
+// stage creates 'name' with 'content', asynchronously uploaded on Close.
+func stage(t *testing.T, st *Storage, name, content string) {
+	seg, err := st.Create(name)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err = seg.Write([]byte(content)); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err = seg.Close(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestStorageDeletePendingUpload(t *testing.T) {
+	mc := newMemClient()
+	mc.release = make(chan struct{})
+	st := New(context.Background(), mc, "archive/")
+	stage(t, st, "./logstate.100.log", "content")
+
+	// delete either waits the in-flight upload, or cancels it before starting
+	done := make(chan error, 1)
+	go func() { done <- st.Delete("./logstate.100.log") }()
+	time.Sleep(50 * time.Millisecond)
+
+	close(mc.release)
+	if err := <-done; err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := st.Wait(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if _, ok := mc.objs["archive/./logstate.100.log"]; ok {
+		t.Log("deleted segment was re-created by its pending upload")
+		t.FailNow()
+	}
+	if _, err := st.Size("./logstate.100.log"); !isNotFound(err) {
+		t.Log("expected a not found error on a deleted segment, got:", err)
+		t.FailNow()
+	}
+}
+
+func TestStorageUploadsInOrder(t *testing.T) {
+	mc := newMemClient()
+	mc.release = make(chan struct{})
+	mc.held = []byte("old")
+	st := New(context.Background(), mc, "archive/")
+
+	// the upload of 'old' is blocked, and must not overwrite the newer content
+	// once released
+	stage(t, st, "./logstate.100.log", "old")
+	time.Sleep(50 * time.Millisecond)
+	stage(t, st, "./logstate.100.log", "new")
+	time.Sleep(50 * time.Millisecond)
+
+	close(mc.release)
+	if err := st.Wait(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if data := mc.objs["archive/./logstate.100.log"]; string(data) != "new" {
+		t.Log("expected the latest content to be uploaded, got:", string(data))
+		t.FailNow()
+	}
+	if len(st.pending) != 0 || len(st.lanes) != 0 {
+		t.Log("expected no pending uploads, got:", len(st.pending), len(st.lanes))
+		t.FailNow()
+	}
+}
+
+func TestStorageConcTableKeepAll(t *testing.T) {
+	nCmds, period := 1000, uint32(100)
+	mc := newMemClient()
+	st := New(context.Background(), mc, "archive/")
+
+	cfg := &beelog.LogConfig{
+		Inmem:   false,
+		KeepAll: true,
+		Alg:     beelog.IterConcTable,
+		Tick:    beelog.Interval,
+		Period:  period,
+		Fname:   "./logstate.log",
+		Storage: st,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ct, err := beelog.NewConcTableWithConfig(ctx, 2, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	for i := 0; i < nCmds; i++ {
+		cmd := pb.Command{
+			Id:    uint64(i),
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(i % 10),
+			Value: strconv.Itoa(i),
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// must wait concurrent persistence...
+	time.Sleep(time.Second)
+	if err := st.Wait(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	raw, num, err := ct.RecovEntireLog()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// the interval index of KeepAll configs is archived alongside segments
+	segs := make(map[string][]byte, len(mc.objs))
+	for k, data := range mc.objs {
+		if !strings.HasSuffix(k, ".idx") {
+			segs[k] = data
+		}
+	}
+
+	if exp := nCmds / int(period); num != exp || len(segs) != exp {
+		t.Logf("expected %d archived segments, recovered %d and found %d objects", exp, num, len(segs))
+		t.FailNow()
+	}
+
+	// every archived segment must be concatenated on the recovered log
+	var size int
+	for _, data := range segs {
+		if _, err := beelog.UnmarshalLogFromReader(bytes.NewReader(data)); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		size += len(data)
+	}
+	recovered, err := beelog.ReadEntireLogSegments(bytes.NewReader(raw))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	var recSize int
+	for _, data := range recovered {
+		recSize += len(data)
+	}
+	if len(recovered) != num || recSize != size {
+		t.Logf("expected %d recovered segments with %d bytes, got %d with %d", num, size, len(recovered), recSize)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,125 @@
+package beelog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CollectSegments removes every segment persisted on KeepAll configs whose content
+// is entirely shadowed by newer segments, i.e. each of its keys has a more recent
+// state persisted afterwards, along with its interval index record. The 'RetainSegments'
+// most recent segments, and those written within 'RetainDuration', are never removed.
+// Segments containing range deletes are also retained, since they may discard states
+// of any older segment. Returns the number of removed segments.
+func (ct *ConcTable) CollectSegments() (int, error) {
+	ix := ct.logs[0].idx
+	if ix == nil {
+		return 0, fmt.Errorf("segment collection is only supported on persistent configs with KeepAll set")
+	}
+	st := ct.logs[0].storage()
+
+	// a full interval always covers every indexed segment
+	segs, err := ix.overlapping(st, 0, ^uint64(0))
+	if err != nil {
+		return 0, err
+	}
+
+	// newest segments first, shadowing the keys of older ones
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].Last > segs[j].Last })
+	cfg := ct.logs[0].config
+	now := time.Now()
+
+	seen := make(map[string]struct{})
+	obsolete, mirrors := make([]string, 0), make([]string, 0)
+	for i, sg := range segs {
+		raw := ct.readEntireSegment(sg)
+		if raw.Err != nil {
+			return 0, raw.Err
+		}
+		cmds, err := UnmarshalLogFromBytes(raw.Raw)
+		if err != nil {
+			return 0, fmt.Errorf("failed decoding segment '%s', err: '%s'", sg.Name, err.Error())
+		}
+
+		shadowed := i >= cfg.RetainSegments && !ct.retainedByAge(st, sg.Name, now)
+		for _, c := range cmds {
+			if isRangeDelete(c.Op) {
+				shadowed = false
+			}
+			if _, ok := seen[c.Key]; !ok {
+				shadowed = false
+				seen[c.Key] = struct{}{}
+			}
+		}
+		if shadowed {
+			obsolete = append(obsolete, sg.Name)
+			if cfg.ParallelMode == Mirror {
+				for _, fn := range cfg.diskFnames()[1:] {
+					mirrors = append(mirrors, segmentFname(fn, sg.First, sg.Last, cfg.Naming))
+				}
+			}
+		}
+	}
+
+	if len(obsolete) == 0 {
+		return 0, nil
+	}
+
+	// recoveries of the entire log must not observe partially removed segments
+	ct.segMu.Lock()
+	defer ct.segMu.Unlock()
+
+	if err := ix.remove(st, obsolete); err != nil {
+		return 0, err
+	}
+	for i, fn := range obsolete {
+		if err := st.Delete(fn); err != nil {
+			return i, err
+		}
+	}
+
+	// mirrored copies are not recorded on the manifest, and may be already missing
+	for _, fn := range mirrors {
+		st.Delete(fn)
+	}
+	return len(obsolete), nil
+}
+
+// retainedByAge informs if segment 'fn' was written within the configured retention
+// duration. Segments on storages unable to inform modification times are always
+// retained by age if a duration is set.
+func (ct *ConcTable) retainedByAge(st LogStorage, fn string, now time.Time) bool {
+	d := ct.logs[0].config.RetainDuration
+	if d <= 0 {
+		return false
+	}
+	mt, ok := st.(ModTimeStorage)
+	if !ok {
+		return true
+	}
+	t, err := mt.ModTime(fn)
+	return err != nil || now.Sub(t) < d
+}
+
+// launchSegmentCollector periodically removes obsolete segments each 'd', reporting
+// failures on the table error channel.
+func (ct *ConcTable) launchSegmentCollector(ctx context.Context, d time.Duration) {
+	go func() {
+		tk := time.NewTicker(d)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-tk.C:
+				if _, err := ct.CollectSegments(); err != nil {
+					ct.logs[0].errs.report(fmt.Errorf("failed collecting obsolete segments, err: %w", err))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,535 @@
+package beelog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// number of shards of a ShardedConcTable when config.Shards is unset.
+	defaultShards int = 4
+
+	// FNV-1a 32b parameters, used to partition keys between shards.
+	fnvOffset32 uint32 = 2166136261
+	fnvPrime32  uint32 = 16777619
+)
+
+// ShardedConcTable partitions the key space into independent ConcTables, each with
+// its own cursor and views, reducing lock contention under heavy concurrent Log()
+// calls. Commands are mapped to shards by the hash of their keys, and recovery
+// procedures merge the output of every shard.
+type ShardedConcTable struct {
+	shards []*ConcTable
+	bounds BoundsPolicy
+	seq    *sequencer // nil on UncheckedOrder config
+
+	// the table config, from which the config of each shard is derived, guarded by
+	// 'cfgMu' during updates
+	config *LogConfig
+	cfgMu  sync.Mutex
+}
+
+// NewShardedConcTableWithConfig creates a new ShardedConcTable with 'cfg.Shards'
+// partitions, each one a ConcTable with 'concLvl' views. On persistent configs, the
+// shard identifier is applied on 'cfg.Fname' (and 'cfg.SecondFname') to avoid
+// conflicting log files between shards.
+func NewShardedConcTableWithConfig(ctx context.Context, concLvl int, cfg *LogConfig) (*ShardedConcTable, error) {
+	err := cfg.ValidateConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	n := cfg.Shards
+	if n == 0 {
+		n = defaultShards
+	}
+	sh := &ShardedConcTable{
+		shards: make([]*ConcTable, n, n),
+		bounds: cfg.RecovBounds,
+		config: cfg.clone(),
+	}
+	if cfg.Ordering != UncheckedOrder {
+		sh.seq = newSequencer(cfg)
+	}
+
+	for i := 0; i < n; i++ {
+		shCfg := shardConfig(cfg, i, n)
+		sh.shards[i], err = NewConcTableWithConfig(ctx, concLvl, &shCfg)
+		if err != nil {
+			return nil, err
+		}
+		sh.shards[i].logGlobs = diskLogGlobs(&shCfg, shardLogGlob)
+	}
+	if in, ok := sh.LoggedInterval(); ok {
+		sh.seq.resume(in.Last)
+	}
+	return sh, nil
+}
+
+// shardConfig returns the config of shard 'i' among 'n' shards of a table configured
+// by 'cfg'.
+func shardConfig(cfg *LogConfig, i, n int) LogConfig {
+	// each shard must persist into different files
+	shCfg := *cfg
+	if !cfg.Inmem {
+		shCfg.Fname = applyShardInFname(cfg.Fname, i)
+	}
+	if cfg.ParallelIO {
+		shCfg.SecondFname = applyShardInFname(cfg.SecondFname, i)
+	}
+	if len(cfg.Fnames) > 0 {
+		shCfg.Fnames = make([]string, len(cfg.Fnames))
+		for j, fn := range cfg.Fnames {
+			shCfg.Fnames[j] = applyShardInFname(fn, i)
+		}
+	}
+
+	// requested intervals are validated against every shard by the table, as well
+	// as command ordering, since each shard only observes a subset of Ids
+	shCfg.RecovBounds = BestEffortBounds
+	shCfg.Ordering, shCfg.ReorderWindow = UncheckedOrder, 0
+
+	// the disk quota and write rate are evenly split among shards
+	if cfg.MaxDiskBytes > 0 {
+		shCfg.MaxDiskBytes = (cfg.MaxDiskBytes + int64(n) - 1) / int64(n)
+	}
+	if cfg.WriteRate > 0 {
+		shCfg.WriteRate = (cfg.WriteRate + int64(n) - 1) / int64(n)
+	}
+	return shCfg
+}
+
+// Str returns a string representation of each shard state, used for debug purposes.
+func (sh *ShardedConcTable) Str() string {
+	strs := make([]string, 0, len(sh.shards))
+	for i, ct := range sh.shards {
+		strs = append(strs, fmt.Sprintf("shard %d: %s", i, ct.Str()))
+	}
+	return strings.Join(strs, "\n")
+}
+
+// Len returns the sum of the lengths of the current active view of each shard.
+func (sh *ShardedConcTable) Len() uint64 {
+	var l uint64
+	for _, ct := range sh.shards {
+		l += ct.Len()
+	}
+	return l
+}
+
+// FirstIndex returns the lowest first index among the current views of every shard
+// that recorded a command, or zero if none.
+func (sh *ShardedConcTable) FirstIndex() uint64 {
+	var first uint64
+	for _, ct := range sh.shards {
+		if f := ct.FirstIndex(); f > 0 && (first == 0 || f < first) {
+			first = f
+		}
+	}
+	return first
+}
+
+// LastIndex returns the most recent index recorded among every shard.
+func (sh *ShardedConcTable) LastIndex() uint64 {
+	var last uint64
+	for _, ct := range sh.shards {
+		if l := ct.LastIndex(); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+// LoggedInterval returns the interval of indexes logged among every shard, and false
+// if none was logged.
+func (sh *ShardedConcTable) LoggedInterval() (IndexInterval, bool) {
+	var (
+		in    IndexInterval
+		found bool
+	)
+	for _, ct := range sh.shards {
+		l, ok := ct.LoggedInterval()
+		if !ok {
+			continue
+		}
+		if !found || l.First < in.First {
+			in.First = l.First
+		}
+		if l.Last > in.Last {
+			in.Last = l.Last
+		}
+		found = true
+	}
+	return in, found
+}
+
+// Holes returns every interval of indexes never logged on any shard, within the logged
+// interval of the table. Since each shard only observes a subset of indexes, holes of
+// a particular shard are only reported if no other shard logged them.
+func (sh *ShardedConcTable) Holes() []IndexInterval {
+	in, ok := sh.LoggedInterval()
+	if !ok {
+		return nil
+	}
+
+	var covered []IndexInterval
+	for _, ct := range sh.shards {
+		l, ok := ct.LoggedInterval()
+		if !ok {
+			continue
+		}
+		next := l.First
+		for _, h := range ct.Holes() {
+			if h.First > next {
+				covered = append(covered, IndexInterval{First: next, Last: h.First - 1})
+			}
+			next = h.Last + 1
+		}
+		covered = append(covered, IndexInterval{First: next, Last: l.Last})
+	}
+	return holesWithin(in, covered)
+}
+
+// checkInterval validates the requested [p, n] interval against every shard, following
+// config.RecovBounds.
+func (sh *ShardedConcTable) checkInterval(p, n uint64) (uint64, uint64, error) {
+	if n < p {
+		return p, n, errors.New("invalid interval request, 'n' must be >= 'p'")
+	}
+	if sh.bounds == BestEffortBounds {
+		return p, n, nil
+	}
+	logged, ok := sh.LoggedInterval()
+	return boundInterval(sh.bounds, IndexInterval{First: p, Last: n}, logged, ok)
+}
+
+// Debug returns a report of each shard, aggregating their lengths and pending
+// reduces.
+func (sh *ShardedConcTable) Debug() DebugInfo {
+	info := DebugInfo{
+		Structure:   "sharded",
+		CurrentView: -1,
+		Shards:      make([]DebugInfo, 0, len(sh.shards)),
+	}
+	for _, ct := range sh.shards {
+		sd := ct.Debug()
+		info.Len += sd.Len
+		info.PendingReduces += sd.PendingReduces
+		info.Shards = append(info.Shards, sd)
+	}
+	if len(info.Shards) > 0 {
+		info.Config = info.Shards[0].Config
+	}
+	return info
+}
+
+// Stats aggregates the statistics of every shard, reporting the longest reduce
+// duration among them. The latest reduce of each shard is summed on the compaction
+// ratio counters.
+func (sh *ShardedConcTable) Stats() (Stats, error) {
+	var agg Stats
+	for _, ct := range sh.shards {
+		st, err := ct.Stats()
+		if err != nil {
+			return agg, err
+		}
+		agg.Keys += st.Keys
+		agg.Commands += st.Commands
+		agg.MemBytes += st.MemBytes
+		agg.Segments += st.Segments
+		agg.IngestedBytes += st.IngestedBytes
+		agg.PersistedBytes += st.PersistedBytes
+		agg.LastReduceInput += st.LastReduceInput
+		agg.LastReduceOutput += st.LastReduceOutput
+		if st.LastReduce > agg.LastReduce {
+			agg.LastReduce = st.LastReduce
+		}
+	}
+	return agg, nil
+}
+
+// Log records the occurence of command 'cmd' on the shard responsible for its key.
+func (sh *ShardedConcTable) Log(cmd pb.Command) error {
+	return sh.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', following the same semantics as ConcTable's 'LogCtx'.
+func (sh *ShardedConcTable) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return sh.seq.sequence(ctx, cmd, sh.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (sh *ShardedConcTable) logCtx(ctx context.Context, cmd pb.Command) error {
+	if !isRangeDelete(cmd.Op) {
+		return sh.shards[sh.shardOf(cmd.Key)].LogCtx(ctx, cmd)
+	}
+
+	// range deletes may match keys of every shard
+	for _, ct := range sh.shards {
+		if err := ct.LogCtx(ctx, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recov returns a compacted log of commands, merging the recovered log of each shard.
+// Since shards have disjoint key sets, their logs are simply concatenated. Follows
+// the same semantics as ConcTable's 'Recov' regarding the requested [p, n] interval.
+func (sh *ShardedConcTable) Recov(p, n uint64) ([]pb.Command, error) {
+	return sh.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', interrupting the recovery of remaining shards
+// once 'ctx' is done.
+func (sh *ShardedConcTable) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	p, n, err := sh.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []pb.Command{}
+	for _, ct := range sh.shards {
+		log, err := ct.RecovCtx(ctx, p, n)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, log...)
+	}
+	return cmds, nil
+}
+
+// RecovBytes returns the merged log of every shard, serialized as a single log
+// following the same slicing protocol of other structures.
+func (sh *ShardedConcTable) RecovBytes(p, n uint64) ([]byte, error) {
+	cmds, err := sh.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err = MarshalLogIntoWriter(buff, &cmds, p, n); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the merged log directly
+// into 'w'. Shard logs are still recovered in memory, since they must be merged before
+// serialization.
+func (sh *ShardedConcTable) RecovBytesStream(w io.Writer, p, n uint64) error {
+	cmds, err := sh.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return MarshalLogIntoWriter(w, &cmds, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' on every shard.
+func (sh *ShardedConcTable) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	p, n, err := sh.checkInterval(p, n)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []pb.Command{}
+	for _, ct := range sh.shards {
+		log, err := ct.RecovFiltered(p, n, f)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, log...)
+	}
+	return cmds, nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (sh *ShardedConcTable) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := sh.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id' on every shard, ordered by their indexes.
+func (sh *ShardedConcTable) RecovSince(id uint64) ([]pb.Command, error) {
+	cmds := []pb.Command{}
+	for _, ct := range sh.shards {
+		log, err := ct.RecovSince(id)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, log...)
+	}
+
+	// shards partition the key space, no merge is needed
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Id < cmds[j].Id })
+	return cmds, nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, merging the snapshot of each shard.
+func (sh *ShardedConcTable) Snapshot() (*Snapshot, error) {
+	var last uint64
+	snaps := make([]*Snapshot, 0, len(sh.shards))
+	for _, ct := range sh.shards {
+		s, err := ct.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		if s.Last() > last {
+			last = s.Last()
+		}
+		snaps = append(snaps, s)
+	}
+
+	return newLazySnapshot(last, func() ([]pb.Command, error) {
+		cmds := []pb.Command{}
+		for _, s := range snaps {
+			log, err := s.Recov()
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, log...)
+		}
+		return cmds, nil
+	}), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (sh *ShardedConcTable) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := sh.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key' on its shard. See ConcTable.Get.
+func (sh *ShardedConcTable) Get(key string) (pb.Command, bool) {
+	return sh.shards[sh.shardOf(key)].Get(key)
+}
+
+// Keys returns every key with a state retained on any shard, in ascending order.
+func (sh *ShardedConcTable) Keys() []string {
+	var keys []string
+	for _, ct := range sh.shards {
+		keys = append(keys, ct.Keys()...)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RecovEntireLog returns every segment persisted by each shard, framed into a single
+// envelope interpreted by 'DecodeEntireLogStream', and the total number of segments.
+func (sh *ShardedConcTable) RecovEntireLog() ([]byte, int, error) {
+	all := make([][]byte, 0)
+	for _, ct := range sh.shards {
+		segs, err := ct.entireLogSegments()
+		if err != nil {
+			return nil, 0, err
+		}
+		all = append(all, segs...)
+	}
+	return encodeEntireLog(all), len(all), nil
+}
+
+// RecovEntireLogInterval is analogous to 'RecovEntireLog', but only reads the segments
+// of each shard whose interval overlaps [p, n].
+func (sh *ShardedConcTable) RecovEntireLogInterval(p, n uint64) ([]byte, int, error) {
+	all := make([][]byte, 0)
+	for _, ct := range sh.shards {
+		segs, err := ct.entireLogSegmentsInterval(p, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		all = append(all, segs...)
+	}
+	return encodeEntireLog(all), len(all), nil
+}
+
+// SetConfig replaces the table config by 'cfg' (see Reconfigurable), then the config
+// of each shard, as on ConcTable's 'SetConfig'. Shards are sequentially updated, so
+// only each shard is atomically updated.
+func (sh *ShardedConcTable) SetConfig(cfg *LogConfig) error {
+	sh.cfgMu.Lock()
+	defer sh.cfgMu.Unlock()
+	return sh.setConfig(cfg)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// table config as in 'SetConfig'.
+func (sh *ShardedConcTable) UpdateConfig(fn func(cfg *LogConfig)) error {
+	sh.cfgMu.Lock()
+	defer sh.cfgMu.Unlock()
+	cfg := sh.config.clone()
+	fn(cfg)
+	return sh.setConfig(cfg)
+}
+
+// setConfig replaces the table config by 'cfg'. Must be called within mutual exclusion
+// scope over 'cfgMu'.
+func (sh *ShardedConcTable) setConfig(cfg *LogConfig) error {
+	next, err := sh.config.reconfigured(cfg)
+	if err != nil {
+		return err
+	}
+	for i, ct := range sh.shards {
+		shCfg := shardConfig(next, i, len(sh.shards))
+		err := ct.updateConfig(func(*LogConfig) *LogConfig { return &shCfg }, shardLogGlob)
+		if err != nil {
+			return fmt.Errorf("failed updating shard %d, err: '%s'", i, err.Error())
+		}
+	}
+	sh.config = next
+	return nil
+}
+
+// Shutdown ...
+func (sh *ShardedConcTable) Shutdown() {
+	for _, ct := range sh.shards {
+		ct.Shutdown()
+	}
+}
+
+// shardOf returns the shard identifier responsible for 'key', computing its FNV-1a
+// hash without allocating a new hash.Hash32.
+func (sh *ShardedConcTable) shardOf(key string) int {
+	h := fnvOffset32
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return int(h % uint32(len(sh.shards)))
+}
+
+// applyShardInFname inserts the shard identifier 'id' before the extension of 'fn'.
+//
+// Example:
+//   "/path/to/logstate.log" -> "/path/to/logstate.s1.log"
+func applyShardInFname(fn string, id int) string {
+	ext := filepath.Ext(fn)
+	return strings.TrimSuffix(fn, ext) + ".s" + strconv.Itoa(id) + ext
+}
+
+// shardLogGlob returns a pattern matching every log file of a shard, including the
+// index suffixes applied on KeepAll configs.
+func shardLogGlob(fn string) string {
+	ext := filepath.Ext(fn)
+	return strings.TrimSuffix(fn, ext) + ".*log"
+}
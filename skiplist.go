@@ -0,0 +1,589 @@
+package beelog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+const (
+	// maxSkipLevel bounds the number of levels of a SkipListHT, enough for 2^32
+	// entries under the default promotion probability.
+	maxSkipLevel = 32
+
+	// skipPromoteChance is the inverse of the probability of promoting an entry to
+	// the next level (i.e. 1/2).
+	skipPromoteChance = 2
+)
+
+type skipListEntry struct {
+	ind uint64
+	key string
+	ptr *listNode
+
+	// forward pointers, one for each level the entry was promoted to
+	next []*skipListEntry
+}
+
+// SkipListHT ...
+type SkipListHT struct {
+	head  *skipListEntry // sentinel, without any associated index
+	level int
+	aux   *stateTable
+	len   uint64
+	rnd   *rand.Rand
+	mu    sync.RWMutex
+	canc  context.CancelFunc
+	logData
+
+	// the ticker routine on TimeInterval config, relaunched on 'ctx' by config updates
+	ctx  context.Context
+	tick context.CancelFunc
+}
+
+// NewSkipListHT ...
+func NewSkipListHT() *SkipListHT {
+	ht := make(stateTable, 0)
+	cfg := DefaultLogConfig()
+	cfg.Alg = GreedySkip
+
+	sl := &SkipListHT{
+		head:    &skipListEntry{next: make([]*skipListEntry, maxSkipLevel)},
+		level:   1,
+		aux:     &ht,
+		rnd:     cfg.newRand(),
+		logData: newLogData(cfg),
+	}
+	sl.ctx, sl.canc = context.WithCancel(context.Background())
+	return sl
+}
+
+// NewSkipListHTWithConfig ...
+func NewSkipListHTWithConfig(cfg *LogConfig) (*SkipListHT, error) {
+	err := cfg.ValidateConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ht := make(stateTable, 0)
+	sl := &SkipListHT{
+		head:    &skipListEntry{next: make([]*skipListEntry, maxSkipLevel)},
+		level:   1,
+		aux:     &ht,
+		rnd:     cfg.newRand(),
+		logData: newLogData(cfg),
+	}
+
+	if err := sl.restoreOnInit(sl.logCtx); err != nil {
+		return nil, err
+	}
+	if err := sl.restoreJournal(sl.LogCtx); err != nil {
+		return nil, err
+	}
+
+	sl.ctx, sl.canc = context.WithCancel(context.Background())
+	if cfg.Tick == TimeInterval {
+		relaunchReduceTicker(sl.ctx, cfg, &sl.tick, sl.reduceOnTick)
+	}
+	return sl, nil
+}
+
+// Str returns a string representation of the bottom level of the skip list, which
+// contains every entry, used for debug purposes.
+func (sl *SkipListHT) Str() string {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	var strs []string
+	for i := sl.head.next[0]; i != nil; i = i.next[0] {
+		strs = append(strs, fmt.Sprintf("(%v|%v)->", i.ind, i.key))
+	}
+	return strings.Join(strs, " ")
+}
+
+// Len returns the number of entries on the skip list.
+func (sl *SkipListHT) Len() uint64 {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.length()
+}
+
+func (sl *SkipListHT) length() uint64 {
+	return sl.len
+}
+
+// FirstIndex returns the index of the first command recorded on the current log
+// interval, or zero if none.
+func (sl *SkipListHT) FirstIndex() uint64 {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.first
+}
+
+// LastIndex returns the index of the most recently recorded command.
+func (sl *SkipListHT) LastIndex() uint64 {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.last
+}
+
+// Debug returns a report of the structure internals, used for troubleshooting.
+func (sl *SkipListHT) Debug() DebugInfo {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.debugInfo("skiplist", sl.len)
+}
+
+// Stats returns a summary of the structure size and reduce costs. The number of
+// persisted segments is only unavailable on failures reading the segments manifest.
+func (sl *SkipListHT) Stats() (Stats, error) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.stateTableStats(*sl.aux, skipEntryBytes)
+}
+
+// Log records the occurence of command 'cmd' on the provided index. Writes are
+// mapped into a new entry on the skip list, with a pointer to the newly inserted
+// state update on the update list for its particular key.
+func (sl *SkipListHT) Log(cmd pb.Command) error {
+	return sl.LogCtx(context.Background(), cmd)
+}
+
+// LogCtx is analogous to 'Log', but returns ctx.Err() if 'ctx' is done before the
+// command is recorded. Reduces triggered by the command observe 'ctx' before persisting,
+// in which case the command remains recorded and is persisted by a later reduce.
+func (sl *SkipListHT) LogCtx(ctx context.Context, cmd pb.Command) error {
+	return sl.seq.sequence(ctx, cmd, sl.logCtx)
+}
+
+// logCtx records 'cmd' once admitted by config.Ordering.
+func (sl *SkipListHT) logCtx(ctx context.Context, cmd pb.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if isRangeDelete(cmd.Op) {
+		return ErrRangeDeleteUnsupported
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.ignoresRead(&cmd) {
+		return nil
+	}
+	sl.countLogged(&cmd)
+	sl.recordPinned(&cmd)
+
+	if err := sl.journalCommand(cmd); err != nil {
+		return err
+	}
+
+	if !isWriteOp(cmd.Op) {
+		sl.attributeFirst(cmd.Id)
+		sl.last = cmd.Id
+		return sl.mayTriggerReduce(ctx)
+	}
+
+	entry := &skipListEntry{
+		ind: cmd.Id,
+		key: cmd.Key,
+	}
+
+	// a write cmd always references a new state on the aux hash table
+	st := &State{
+		ind: cmd.Id,
+		cmd: cmd,
+	}
+
+	if !sl.insert(entry) {
+		return errors.New("cannot insert equal indexes on skip lists")
+	}
+
+	_, exists := (*sl.aux)[cmd.Key]
+	if !exists {
+		(*sl.aux)[cmd.Key] = &list{}
+	}
+
+	// add state to the list of updates in that particular key
+	entry.ptr = (*sl.aux)[cmd.Key].push(st)
+
+	// adjust last index once inserted
+	sl.last = cmd.Id
+
+	// Immediately recovery entirely reduces the log to its minimal format
+	if sl.config.Tick == Immediately {
+		return sl.reduceLogCtx(ctx, sl.first, sl.last)
+	}
+	return sl.mayTriggerReduce(ctx)
+}
+
+// Recov returns a compacted log of commands, following the requested [p, n]
+// interval. 'Delayed' configs reduce the requested interval, while on different
+// period configurations the most recently reduced states are trimmed to [p, n],
+// merging every segment overlapping it on KeepAll configs. On persistent configuration (i.e.
+// 'inmem' false) the entire log is loaded and then unmarshaled, consider using
+// 'RecovBytes' calls instead.
+func (sl *SkipListHT) Recov(p, n uint64) ([]pb.Command, error) {
+	return sl.RecovCtx(context.Background(), p, n)
+}
+
+// RecovCtx is analogous to 'Recov', but interrupts the lazy reduce and the reading
+// of persisted states once 'ctx' is done, returning ctx.Err().
+func (sl *SkipListHT) RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	sd, err := sl.recovCtx(ctx, p, n, false)
+	return sd.Snapshot.Cmds, err
+}
+
+// RecovWithResult is analogous to 'Recov', but also informs the interval of indexes
+// covered by the recovered log.
+func (sl *SkipListHT) RecovWithResult(p, n uint64) (RecovResult, error) {
+	sd, err := sl.recovCtx(context.Background(), p, n, false)
+	return sd.Snapshot, err
+}
+
+// RecovSnapshotAndDelta is analogous to 'Recov', but also returns every write logged
+// within [p, n] after the recovered reduced state, not reduced.
+func (sl *SkipListHT) RecovSnapshotAndDelta(p, n uint64) (SnapshotDelta, error) {
+	return sl.recovCtx(context.Background(), p, n, true)
+}
+
+// recovCtx recovers the reduced state of [p, n], along with the commands logged after
+// it if 'delta' is set.
+func (sl *SkipListHT) recovCtx(ctx context.Context, p, n uint64, delta bool) (SnapshotDelta, error) {
+	p, n, err := sl.checkInterval(p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return SnapshotDelta{}, err
+	}
+	sl.hookRecovery(p, n)
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	if err := sl.mayExecuteLazyReduce(ctx, p, n); err != nil {
+		return SnapshotDelta{}, err
+	}
+	cmds, err := sl.retrieveIntervalLogCtx(ctx, p, n)
+	if err != nil {
+		return SnapshotDelta{}, err
+	}
+	res := sl.coverage(p, n, true)
+	res.Cmds = cmds
+	if !delta {
+		return SnapshotDelta{Snapshot: res}, nil
+	}
+	return sl.snapshotAndDelta(res, p, n, sl.deltaLog), nil
+}
+
+// RecovBytes returns an already serialized log, parsed from persistent storage
+// or marshaled from the in-memory state. Its the most efficient approach on persistent
+// configuration, avoiding an extra marshaling step during recovery. The command
+// interpretation from the byte stream follows a simple slicing protocol, where
+// the size of each command is binary encoded before the raw pbuff.
+func (sl *SkipListHT) RecovBytes(p, n uint64) ([]byte, error) {
+	res, err := sl.RecovBytesWithResult(p, n)
+	return res.Raw, err
+}
+
+// RecovBytesWithResult is analogous to 'RecovBytes', but also informs the interval of
+// indexes covered by the serialized log.
+func (sl *SkipListHT) RecovBytesWithResult(p, n uint64) (RecovResult, error) {
+	p, n, err := sl.checkInterval(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	sl.hookRecovery(p, n)
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	if err := sl.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return RecovResult{}, err
+	}
+	raw, err := sl.retrieveRawLog(p, n)
+	if err != nil {
+		return RecovResult{}, err
+	}
+	res := sl.coverage(p, n, false)
+	res.Raw = raw
+	return res, nil
+}
+
+// RecovBytesStream is analogous to 'RecovBytes', but writes the serialized log
+// directly into 'w' (e.g. a net.Conn), avoiding buffering large logs in memory.
+func (sl *SkipListHT) RecovBytesStream(w io.Writer, p, n uint64) error {
+	p, n, err := sl.checkInterval(p, n)
+	if err != nil {
+		return err
+	}
+	sl.hookRecovery(p, n)
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	if err := sl.mayExecuteLazyReduce(context.Background(), p, n); err != nil {
+		return err
+	}
+	return sl.streamRawLog(w, p, n)
+}
+
+// RecovFiltered is analogous to 'Recov', but only returns commands over keys retained
+// by 'f' (e.g. KeyPrefix).
+func (sl *SkipListHT) RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error) {
+	cmds, err := sl.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+	return filterByKey(cmds, f), nil
+}
+
+// RecovBytesFiltered is analogous to 'RecovBytes', but only serializes commands over
+// keys retained by 'f'.
+func (sl *SkipListHT) RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error) {
+	cmds, err := sl.RecovFiltered(p, n, f)
+	if err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(cmds, p, n)
+}
+
+// RecovSince returns the latest state of every key updated after the consensus index
+// 'id', allowing slightly outdated replicas to fetch only their missing states instead
+// of the entire reduced log.
+func (sl *SkipListHT) RecovSince(id uint64) ([]pb.Command, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.length() == 0 || id >= sl.last {
+		return []pb.Command{}, nil
+	}
+
+	// the structure retains every logged state, even after reduce, so only the
+	// interval after 'id' is reduced, starting from the lower bound of 'id' + 1
+	// since 'id' may not be present on the structure
+	return GreedySkipListHT(sl, id+1, sl.last), nil
+}
+
+// Snapshot returns an immutable view of the reduced state of every command logged
+// so far, unaffected by later commands.
+func (sl *SkipListHT) Snapshot() (*Snapshot, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.length() == 0 {
+		return newSnapshot(sl.last, nil), nil
+	}
+	cmds := GreedySkipListHT(sl, sl.first, sl.last)
+	return newSnapshot(sl.last, cmds), nil
+}
+
+// DumpJSON recovers the log interval [p, n] and writes it into 'w' as JSON Lines,
+// allowing operators to inspect the compacted state.
+func (sl *SkipListHT) DumpJSON(w io.Writer, p, n uint64) error {
+	cmds, err := sl.Recov(p, n)
+	if err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, cmds)
+}
+
+// Get returns the latest state logged for 'key', without reducing the log. Returns
+// false if the key was never written, or if its state was discarded by a later range
+// delete.
+func (sl *SkipListHT) Get(key string) (pb.Command, bool) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.getState(*sl.aux, key)
+}
+
+// Keys returns every key with a state retained on the skip list, in ascending order.
+func (sl *SkipListHT) Keys() []string {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.stateKeys(*sl.aux)
+}
+
+// ReduceLog applies the configured reduce algorithm and updates the current log state.
+// Must only be called within mutual exclusion scope.
+func (sl *SkipListHT) ReduceLog(p, n uint64) error {
+	return sl.reduceLogCtx(context.Background(), p, n)
+}
+
+// reduceLogCtx is analogous to 'ReduceLog', but returns ctx.Err() without reducing or
+// persisting the log state if 'ctx' is done, either before or during the reduce.
+func (sl *SkipListHT) reduceLogCtx(ctx context.Context, p, n uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := sl.hookReduceStart(p, n)
+	cmds, err := sl.applyReduce(sl, p, n)
+	sl.hookReduceDone(p, n, start, len(cmds), err)
+	if err != nil {
+		return err
+	}
+	return sl.updateLogStateCtx(ctx, cmds, p, n, 0)
+}
+
+// retainedStates returns every state logged within [p, n], reduced by registered
+// reducers. Must only be called within mutual exclusion scope.
+func (sl *SkipListHT) retainedStates(p, n uint64) ([]pb.Command, error) {
+	return sl.aux.statesWithin(p, n), nil
+}
+
+// mayTriggerReduce possibly triggers the reduce algorithm based on config params
+// (e.g. interval period reached). Must only be called within mutual exclusion scope.
+func (sl *SkipListHT) mayTriggerReduce(ctx context.Context) error {
+	if sl.config.Tick == TimeInterval {
+		// reduce is later executed by the ticker routine
+		sl.count++
+		return nil
+	}
+	if !sl.config.Tick.isPeriodic() {
+		return nil
+	}
+	if sl.reachedReducePeriod(len(*sl.aux)) {
+		return sl.reduceLogCtx(ctx, sl.first, sl.last)
+	}
+	return nil
+}
+
+// mayExecuteLazyReduce triggers a reduce procedure if delayed config is set or first
+// 'config.Period' wasnt reached yet.
+func (sl *SkipListHT) mayExecuteLazyReduce(ctx context.Context, p, n uint64) error {
+	if sl.config.Tick == Delayed {
+		err := sl.reduceLogCtx(ctx, p, n)
+		if err != nil {
+			return err
+		}
+
+	} else if sl.config.Tick.isScheduled() && !sl.firstReduceExists() {
+		// must reduce the entire structure, just the desired interval would
+		// be incoherent with scheduled configs
+		err := sl.reduceLogCtx(ctx, sl.first, sl.last)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reduceOnTick reduces the entire structure if any command was logged since the last
+// reduce. Invoked by the ticker routine on TimeInterval config.
+func (sl *SkipListHT) reduceOnTick() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.count == 0 {
+		return
+	}
+
+	// on failure, the counter is retained to retry on the next tick
+	err := sl.config.Retry.do(context.Background(), func() error {
+		return sl.ReduceLog(sl.first, sl.last)
+	})
+	if err != nil {
+		sl.reportReduceErr(err)
+		return
+	}
+	sl.count = 0
+}
+
+// SetConfig atomically replaces the structure config by 'cfg' (see Reconfigurable),
+// once any in-flight reduce is finished.
+func (sl *SkipListHT) SetConfig(cfg *LogConfig) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.reconfigure(sl.ctx, cfg, &sl.tick, sl.reduceOnTick)
+}
+
+// UpdateConfig updates a copy of the current config through 'fn', then replaces the
+// structure config as in 'SetConfig'.
+func (sl *SkipListHT) UpdateConfig(fn func(cfg *LogConfig)) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	cfg := sl.config.clone()
+	fn(cfg)
+	return sl.reconfigure(sl.ctx, cfg, &sl.tick, sl.reduceOnTick)
+}
+
+// Shutdown stops the reduce ticker routine launched on TimeInterval config, and flushes
+// any pending group commit.
+func (sl *SkipListHT) Shutdown() {
+	if sl.canc != nil {
+		sl.canc()
+	}
+	if sl.gc != nil {
+		sl.gc.close()
+	}
+	sl.closeJournal()
+}
+
+// randomLevel draws the number of levels of a new entry, promoting it to each next
+// level with 1/skipPromoteChance probability.
+func (sl *SkipListHT) randomLevel() int {
+	lvl := 1
+	for lvl < maxSkipLevel && sl.rnd.Intn(skipPromoteChance) == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// insert places 'entry' on its index position in O(lg n) expected operations, where
+// 'n' is the number of entries. Unlike AVL trees, no rebalancing is needed. Returns
+// false if an entry with the same index is already present.
+func (sl *SkipListHT) insert(entry *skipListEntry) bool {
+	var update [maxSkipLevel]*skipListEntry
+	cur := sl.head
+
+	for i := sl.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && cur.next[i].ind < entry.ind {
+			cur = cur.next[i]
+		}
+		update[i] = cur
+	}
+	if nx := cur.next[0]; nx != nil && nx.ind == entry.ind {
+		return false
+	}
+
+	lvl := sl.randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			update[i] = sl.head
+		}
+		sl.level = lvl
+	}
+
+	entry.next = make([]*skipListEntry, lvl)
+	for i := 0; i < lvl; i++ {
+		entry.next[i] = update[i].next[i]
+		update[i].next[i] = entry
+	}
+
+	// adjust first structure index, lowered by out of order insertions
+	if !sl.logged || entry.ind < sl.first {
+		sl.resetFirst(entry.ind, true)
+	}
+	sl.len++
+	return true
+}
+
+// searchEntryByIndex returns the first entry with index equal or greater than 'ind',
+// or nil if none is found.
+func (sl *SkipListHT) searchEntryByIndex(ind uint64) *skipListEntry {
+	cur := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for cur.next[i] != nil && cur.next[i].ind < ind {
+			cur = cur.next[i]
+		}
+	}
+	return cur.next[0]
+}
+
+func (sl *SkipListHT) resetVisitedValues() {
+	for _, list := range *sl.aux {
+		list.visited = false
+	}
+}
@@ -0,0 +1,90 @@
+package beelog
+
+import (
+	"io"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Snapshot is an immutable point-in-time view of a structure, retaining the reduced
+// state of every command logged up to its creation. Later commands never affect an
+// already created snapshot, allowing backups to run while logging continues.
+//
+// Structures that only retain the latest state of each key (e.g. ConcTable, CircBuffHT)
+// copy their tables on creation, deferring the reduce until the snapshot is first
+// read. Others reduce their state during creation.
+type Snapshot struct {
+	last uint64
+
+	once   sync.Once
+	reduce func() ([]pb.Command, error)
+	cmds   []pb.Command
+	first  uint64
+	err    error
+}
+
+// newSnapshot returns a snapshot over the already reduced 'cmds'.
+func newSnapshot(last uint64, cmds []pb.Command) *Snapshot {
+	return newLazySnapshot(last, func() ([]pb.Command, error) {
+		return cmds, nil
+	})
+}
+
+// newLazySnapshot returns a snapshot whose reduced state is only computed by 'reduce'
+// on its first read. 'reduce' must only access state copied during creation.
+func newLazySnapshot(last uint64, reduce func() ([]pb.Command, error)) *Snapshot {
+	return &Snapshot{last: last, reduce: reduce}
+}
+
+// Last returns the index of the last command logged before the snapshot creation.
+func (s *Snapshot) Last() uint64 {
+	return s.last
+}
+
+// Recov returns the reduced log of the snapshot. The returned slice is a copy, and
+// can be safely modified by callers.
+func (s *Snapshot) Recov() ([]pb.Command, error) {
+	if err := s.materialize(); err != nil {
+		return nil, err
+	}
+	cmds := make([]pb.Command, len(s.cmds))
+	copy(cmds, s.cmds)
+	return cmds, nil
+}
+
+// RecovBytes returns the serialized reduced log of the snapshot, following the same
+// slicing protocol of structures 'RecovBytes' calls.
+func (s *Snapshot) RecovBytes() ([]byte, error) {
+	if err := s.materialize(); err != nil {
+		return nil, err
+	}
+	return marshalFilteredLog(s.cmds, s.first, s.last)
+}
+
+// ExportJSON writes the reduced log of the snapshot into 'w' as JSON Lines.
+func (s *Snapshot) ExportJSON(w io.Writer) error {
+	if err := s.materialize(); err != nil {
+		return err
+	}
+	return ExportLog(w, JSON, s.cmds)
+}
+
+// materialize computes the reduced state of the snapshot, once.
+func (s *Snapshot) materialize() error {
+	s.once.Do(func() {
+		s.cmds, s.err = s.reduce()
+		if s.err != nil {
+			return
+		}
+
+		// the reduced log covers from its oldest retained state
+		for i, c := range s.cmds {
+			if i == 0 || c.Id < s.first {
+				s.first = c.Id
+			}
+		}
+		s.reduce = nil
+	})
+	return s.err
+}
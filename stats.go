@@ -0,0 +1,195 @@
+package beelog
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Stats is a point-in-time summary of a structure size and reduce costs, allowing
+// operators to size 'config.Period' and ConcTable's 'concLevel' from live data.
+type Stats struct {
+	// unique keys with a state retained in memory, or indexed on disk by BTreeHT. On
+	// LSMLog, keys present on multiple runs are counted once per run
+	Keys int `json:"keys"`
+
+	// total number of commands logged since the structure was created
+	Commands uint64 `json:"commands"`
+
+	// estimation of the bytes retained in memory by logged states and their indexing
+	// containers, disregarding Go runtime overheads (e.g. map buckets load factor)
+	MemBytes int64 `json:"memBytes"`
+
+	// elapsed time of the latest reduce procedure, or zero if none
+	LastReduce time.Duration `json:"lastReduce"`
+
+	// number of segments persisted on disk, or zero on inmem configs
+	Segments int `json:"segments"`
+
+	// wire bytes of every logged command, and bytes written into persisted segments
+	// since the structure was created (see 'WriteAmplification')
+	IngestedBytes  uint64 `json:"ingestedBytes"`
+	PersistedBytes uint64 `json:"persistedBytes"`
+
+	// indexes covered by the latest reduced interval, and commands emitted by its
+	// reduce procedure (see 'CompactionRatio')
+	LastReduceInput  uint64 `json:"lastReduceInput"`
+	LastReduceOutput uint64 `json:"lastReduceOutput"`
+}
+
+// WriteAmplification returns the ratio between bytes persisted and bytes ingested by
+// the structure, or zero if no command was logged. Configs persisting the entire
+// reduced state on each reduce (e.g. Immediately tick) present ratios above one,
+// while ratios below one quantify the savings of log reduction over a traditional log.
+func (s Stats) WriteAmplification() float64 {
+	if s.IngestedBytes == 0 {
+		return 0
+	}
+	return float64(s.PersistedBytes) / float64(s.IngestedBytes)
+}
+
+// CompactionRatio returns the ratio between the indexes covered by the latest reduce
+// and the commands it emitted, or zero if no command was emitted yet.
+func (s Stats) CompactionRatio() float64 {
+	if s.LastReduceOutput == 0 {
+		return 0
+	}
+	return float64(s.LastReduceInput) / float64(s.LastReduceOutput)
+}
+
+const (
+	// approximate bytes retained by each map entry, besides its key content
+	mapEntryBytes = 48
+
+	stateBytes     = int64(unsafe.Sizeof(State{}) - unsafe.Sizeof(pb.Command{}))
+	listNodeBytes  = int64(unsafe.Sizeof(listNode{}))
+	listEntryBytes = int64(unsafe.Sizeof(listEntry{}))
+	avlEntryBytes  = int64(unsafe.Sizeof(avlTreeEntry{}))
+	buffEntBytes   = int64(unsafe.Sizeof(buffEntry{}))
+
+	// skip list entries retain two forward pointers on average
+	skipEntryBytes = int64(unsafe.Sizeof(skipListEntry{})) + 2*8
+)
+
+// cmdBytes estimates the bytes retained in memory by 'c'.
+func cmdBytes(c *pb.Command) int64 {
+	n := int64(unsafe.Sizeof(*c))
+	n += int64(len(c.Ip) + len(c.Key) + len(c.Value) + len(c.Expected) + len(c.ClientId) +
+		len(c.EndKey) + len(c.XXX_unrecognized))
+
+	switch v := c.Typed.(type) {
+	case *pb.Command_BytesValue:
+		n += int64(unsafe.Sizeof(*v)) + int64(len(v.BytesValue))
+	case *pb.Command_IntValue, *pb.Command_FloatValue:
+		n += 8
+	}
+	return n
+}
+
+// countLogged accounts a new command 'cmd' recorded on the structure.
+func (ld *logData) countLogged(cmd *pb.Command) {
+	atomic.AddUint64(&ld.stats.logged, 1)
+	atomic.AddUint64(&ld.stats.ingested, uint64(cmd.SizeVT()))
+	ld.stats.observeIndex(cmd.Id)
+}
+
+// baseStats returns the statistics tracked by every structure, including the number
+// of persisted segments.
+func (ld *logData) baseStats() (Stats, error) {
+	st := Stats{
+		Commands:         atomic.LoadUint64(&ld.stats.logged),
+		LastReduce:       time.Duration(atomic.LoadInt64(&ld.stats.lastReduce)),
+		IngestedBytes:    atomic.LoadUint64(&ld.stats.ingested),
+		PersistedBytes:   atomic.LoadUint64(&ld.stats.persisted),
+		LastReduceInput:  atomic.LoadUint64(&ld.stats.reduceIn),
+		LastReduceOutput: atomic.LoadUint64(&ld.stats.reduceOut),
+	}
+	if ld.config.Inmem {
+		return st, nil
+	}
+
+	if ld.idx != nil {
+		n, err := ld.idx.count(ld.storage())
+		if err != nil {
+			return st, err
+		}
+		st.Segments = n
+		return st, nil
+	}
+
+	for _, fn := range ld.config.diskFnames() {
+		if _, err := ld.storage().Size(fn); err == nil {
+			st.Segments++
+		}
+	}
+	return st, nil
+}
+
+// memBytes estimates the bytes retained by every state on the table, where each one
+// is also referenced by an index entry of 'entryBytes' on the structure container.
+func (ht stateTable) memBytes(entryBytes int64) int64 {
+	var n int64
+	for k, l := range ht {
+		n += mapEntryBytes + int64(len(k)) + int64(unsafe.Sizeof(list{}))
+		for nd := l.first; nd != nil; nd = nd.next {
+			n += listNodeBytes + entryBytes + stateBytes + cmdBytes(&nd.val.(*State).cmd)
+		}
+	}
+	return n
+}
+
+// memBytes estimates the bytes retained by every state on the table, including any
+// prior state retained by conditional commands.
+func (tbl minStateTable) memBytes() int64 {
+	var n int64
+	for k, st := range tbl {
+		n += mapEntryBytes + int64(len(k)) + chainBytes(&st)
+	}
+	return n
+}
+
+// chainBytes estimates the bytes retained by 'st' and every prior state it depends on.
+func chainBytes(st *State) int64 {
+	var n int64
+	for ; st != nil; st = st.prev {
+		n += stateBytes + cmdBytes(&st.cmd)
+	}
+	return n
+}
+
+// insertBytes estimates the variation of bytes retained by the table, and its index
+// ordered entries, once the write 'cmd' is recorded on it.
+func (tbl minStateTable) insertBytes(cmd *pb.Command) int64 {
+	n := stateBytes + cmdBytes(cmd) + buffEntBytes
+	prior, ok := tbl[cmd.Key]
+	if !ok {
+		return n + mapEntryBytes + int64(len(cmd.Key))
+	}
+
+	// replaced states are only retained by conditional updates
+	if cmd.Op != pb.Command_CAS {
+		n -= chainBytes(&prior)
+	}
+	return n
+}
+
+// stateTableStats returns the statistics of structures indexing states on a stateTable,
+// where each state is referenced by an entry of 'entryBytes'.
+func (ld *logData) stateTableStats(ht stateTable, entryBytes int64) (Stats, error) {
+	st, err := ld.baseStats()
+	st.Keys = len(ht)
+	st.MemBytes = ht.memBytes(entryBytes)
+	return st, err
+}
+
+// radixMemBytes estimates the bytes retained by every node and state under 'nd'.
+func radixMemBytes(nd *radixNode) int64 {
+	n := int64(unsafe.Sizeof(*nd)) + int64(len(nd.label)) + int64(cap(nd.children))*8
+	n += chainBytes(nd.st)
+	for _, c := range nd.children {
+		n += radixMemBytes(c)
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package beelog
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Segment is a writable log segment on a LogStorage.
+type Segment interface {
+	io.Writer
+	io.WriterAt
+	io.Closer
+
+	// Sync commits the current content of the segment to stable storage.
+	Sync() error
+}
+
+// SegmentReader is a readable log segment on a LogStorage.
+type SegmentReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// LogStorage abstracts the persistence of log segments, identified by their names
+// (e.g. file paths on the default filesystem storage). Implementations allow users
+// to plug different backends (e.g. object storages, memory-mapped files or test
+// fakes) without modifying the log serialization procedures.
+type LogStorage interface {
+	// Create creates a new segment 'name', truncating any prior content.
+	Create(name string) (Segment, error)
+
+	// Append opens the segment 'name' for writing at its end, creating it if it
+	// doesnt exist yet.
+	Append(name string) (Segment, error)
+
+	// ReadAt opens the segment 'name' for reading, either sequentially or at
+	// arbitrary offsets.
+	ReadAt(name string) (SegmentReader, error)
+
+	// List returns the names of every segment matching 'pattern', following
+	// the same syntax of filepath.Match.
+	List(pattern string) ([]string, error)
+
+	// Delete removes the segment 'name'.
+	Delete(name string) error
+
+	// Size returns the current size, in bytes, of the segment 'name'.
+	Size(name string) (int64, error)
+}
+
+// ModTimeStorage is optionally implemented by LogStorages able to inform when each
+// segment was last modified, required by duration-based retention of segments.
+type ModTimeStorage interface {
+	ModTime(name string) (time.Time, error)
+}
+
+// FileStorage is the default LogStorage, persisting segments as files on the local
+// filesystem, where segment names are file paths.
+type FileStorage struct{}
+
+// NewFileStorage returns a new filesystem storage.
+func NewFileStorage() *FileStorage {
+	return &FileStorage{}
+}
+
+// Create ...
+func (fs *FileStorage) Create(name string) (Segment, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+}
+
+// Append ...
+func (fs *FileStorage) Append(name string) (Segment, error) {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// O_APPEND is not used since it forbids WriteAt calls
+	if _, err = fd.Seek(0, io.SeekEnd); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return fd, nil
+}
+
+// ReadAt ...
+func (fs *FileStorage) ReadAt(name string) (SegmentReader, error) {
+	return os.OpenFile(name, os.O_RDONLY, 0400)
+}
+
+// List ...
+func (fs *FileStorage) List(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// Delete ...
+func (fs *FileStorage) Delete(name string) error {
+	return os.Remove(name)
+}
+
+// Size ...
+func (fs *FileStorage) Size(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ModTime ...
+func (fs *FileStorage) ModTime(name string) (time.Time, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// newSegmentCursor returns a new reader starting at the beginning of 'rd', independent
+// of any other cursor over the same segment.
+func newSegmentCursor(rd SegmentReader) io.Reader {
+	return io.NewSectionReader(rd, 0, math.MaxInt64)
+}
+
+// defaultStorage is utilized when no LogStorage is provided on config.
+var defaultStorage LogStorage = NewFileStorage()
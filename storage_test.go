@@ -0,0 +1,353 @@
+package beelog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// memStorage is an in-memory LogStorage, utilized to verify that structures
+// persist states exclusively through the configured storage.
+type memStorage struct {
+	mu   sync.Mutex
+	segs map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{segs: make(map[string][]byte)}
+}
+
+type memSegment struct {
+	ms   *memStorage
+	name string
+	off  int64
+}
+
+func (sg *memSegment) Write(p []byte) (int, error) {
+	n, err := sg.WriteAt(p, sg.off)
+	sg.off += int64(n)
+	return n, err
+}
+
+func (sg *memSegment) WriteAt(p []byte, off int64) (int, error) {
+	sg.ms.mu.Lock()
+	defer sg.ms.mu.Unlock()
+
+	data := sg.ms.segs[sg.name]
+	if end := off + int64(len(p)); end > int64(len(data)) {
+		data = append(data, make([]byte, end-int64(len(data)))...)
+	}
+	copy(data[off:], p)
+	sg.ms.segs[sg.name] = data
+	return len(p), nil
+}
+
+func (sg *memSegment) Sync() error  { return nil }
+func (sg *memSegment) Close() error { return nil }
+
+type memSegmentReader struct {
+	*bytes.Reader
+}
+
+func (sr *memSegmentReader) Close() error { return nil }
+
+func (ms *memStorage) Create(name string) (Segment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.segs[name] = nil
+	return &memSegment{ms: ms, name: name}, nil
+}
+
+func (ms *memStorage) Append(name string) (Segment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return &memSegment{ms: ms, name: name, off: int64(len(ms.segs[name]))}, nil
+}
+
+func (ms *memStorage) ReadAt(name string) (SegmentReader, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, ok := ms.segs[name]
+	if !ok {
+		return nil, errors.New("segment not found")
+	}
+	return &memSegmentReader{bytes.NewReader(append([]byte(nil), data...))}, nil
+}
+
+func (ms *memStorage) List(pattern string) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	names := make([]string, 0)
+	for name := range ms.segs {
+		ok, err := filepath.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (ms *memStorage) Delete(name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.segs, name)
+	return nil
+}
+
+func (ms *memStorage) Size(name string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, ok := ms.segs[name]
+	if !ok {
+		return 0, errors.New("segment not found")
+	}
+	return int64(len(data)), nil
+}
+
+func TestStructuresCustomStorage(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(0), uint64(1000)
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		ms := newMemStorage()
+		cfg := LogConfig{
+			Alg:     alg,
+			Tick:    Delayed,
+			Inmem:   false,
+			Fname:   "./custom-storage.log",
+			Storage: ms,
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		redLog, err := ApplyReduceAlgo(st, cfg.Alg, p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		raw, err := st.RecovBytes(p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if segs, _ := ms.List("./custom-storage*"); len(segs) == 0 {
+			t.Logf("structure '%T' didnt persist any segment on the configured storage", st)
+			t.FailNow()
+		}
+
+		log, err := deserializeRawLog(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(redLog, log) && !logsAreOnlyDelayed(redLog, log) {
+			t.Logf("structure '%T' recovered an incoherent log from storage", st)
+			t.Log("REDC:", redLog)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+	}
+
+	// nothing should be persisted on the local filesystem
+	if fs, _ := filepath.Glob("./custom-storage*"); len(fs) > 0 {
+		t.Log("found segments persisted outside the configured storage:", fs)
+		t.FailNow()
+	}
+}
+
+func TestDirectFileStorage(t *testing.T) {
+	fn := t.TempDir() + "/direct.log"
+	ds := NewDirectFileStorage()
+	ds.BufferSize = 3 * directAlign
+
+	seg, err := ds.Create(fn)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// unaligned writes, crossing buffer boundaries and synced mid-way
+	exp := make([]byte, 0)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 40; i++ {
+		b := make([]byte, r.Intn(2*directAlign))
+		r.Read(b)
+		if _, err := seg.Write(b); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		exp = append(exp, b...)
+
+		if i%10 == 0 {
+			if err := seg.Sync(); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+	}
+
+	// rewrites both on flushed and buffered content
+	for _, off := range []int{0, len(exp) - 3} {
+		patch := []byte{0xbe, 0xe1, 0x06}
+		if _, err := seg.WriteAt(patch, int64(off)); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		copy(exp[off:], patch)
+	}
+	tail := []byte("tail")
+	if _, err := seg.Write(tail); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	exp = append(exp, tail...)
+
+	if err := seg.Close(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !bytes.Equal(data, exp) {
+		t.Log("direct segment content differs, got", len(data), "bytes, expected", len(exp))
+		t.FailNow()
+	}
+
+	// structures persist and recover states through direct segments
+	cfg := &LogConfig{
+		Alg:     IterConcTable,
+		Tick:    Immediately,
+		Fname:   t.TempDir() + "/logstate.log",
+		Sync:    true,
+		Storage: ds,
+	}
+	st, err := generateRandStructure(4, 500, 50, 100, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := st.Recov(0, 499); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestTieredStorage(t *testing.T) {
+	nCmds, period, hot := 100, uint32(10), 3
+	dir, cold := t.TempDir(), newMemStorage()
+	cfg := &LogConfig{
+		Alg:         GreedyLt,
+		Tick:        Interval,
+		Period:      period,
+		KeepAll:     true,
+		Fname:       filepath.Join(dir, "logstate.log"),
+		ColdStorage: cold,
+		HotSegments: hot,
+	}
+	l, err := NewListHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	for i := 0; i < nCmds; i++ {
+		cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 15), Value: strconv.Itoa(i)}
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	l.tier.waitMigrations()
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "logstate.*.log"))
+	migrated, _ := cold.List(filepath.Join(dir, "logstate.*.log"))
+	if len(segs) != hot || len(migrated) != nCmds/int(period)-hot {
+		t.Log("expected", hot, "hot and", nCmds/int(period)-hot, "cold segments, got", segs, migrated)
+		t.FailNow()
+	}
+
+	// migrated segments are transparently read during recovery
+	log, err := l.Recov(0, uint64(nCmds-1))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	got := make(map[string]string)
+	for _, c := range log {
+		got[c.Key] = c.Value
+	}
+	for i := nCmds - 15; i < nCmds; i++ {
+		if v := got[strconv.Itoa(i%15)]; v != strconv.Itoa(i) {
+			t.Log("expected key", i%15, "recovered with value", i, "got", v)
+			t.FailNow()
+		}
+	}
+
+	invalid := LogConfig{Tick: Interval, Period: 10, Fname: cfg.Fname, ColdStorage: cold}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on cold storages without KeepAll")
+		t.FailNow()
+	}
+}
+
+func BenchmarkConcTableDirectIO(b *testing.B) {
+	storages := map[string]LogStorage{
+		"page-cache": NewFileStorage(),
+		"direct-io":  NewDirectFileStorage(),
+	}
+	for name, st := range storages {
+		b.Run(name, func(b *testing.B) {
+			cfg := &LogConfig{
+				Alg:     IterConcTable,
+				Tick:    Immediately,
+				Fname:   b.TempDir() + "/logstate.log",
+				Sync:    true,
+				Storage: st,
+			}
+			ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ct.Shutdown()
+
+			value := strings.Repeat("v", 1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 1000), Value: value}
+				if err := ct.Log(cmd); err != nil {
+					b.Fatal(err)
+				}
+			}
+			for ct.Pending() > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -2,17 +2,18 @@ package beelog
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Lz-Gustavo/beelog/pb"
-
-	"github.com/golang/protobuf/proto"
 )
 
 // Structure is an abstraction for the different log representation structures
@@ -20,9 +21,20 @@ import (
 type Structure interface {
 	Str() string
 	Len() uint64
+	FirstIndex() uint64
+	LastIndex() uint64
 	Log(cmd pb.Command) error
+	LogCtx(ctx context.Context, cmd pb.Command) error
 	Recov(p, n uint64) ([]pb.Command, error)
+	RecovCtx(ctx context.Context, p, n uint64) ([]pb.Command, error)
 	RecovBytes(p, n uint64) ([]byte, error)
+	RecovBytesStream(w io.Writer, p, n uint64) error
+	RecovSince(id uint64) ([]pb.Command, error)
+	RecovFiltered(p, n uint64, f KeyFilter) ([]pb.Command, error)
+	RecovBytesFiltered(p, n uint64, f KeyFilter) ([]byte, error)
+	Snapshot() (*Snapshot, error)
+	Get(key string) (pb.Command, bool)
+	Keys() []string
 }
 
 type listNode struct {
@@ -40,10 +52,11 @@ type list struct {
 // push inserts a new node with the argument value on the list, returning a
 // reference to it.
 func (l *list) push(v interface{}) *listNode {
-	nd := &listNode{
-		val: v,
-	}
+	return l.pushNode(&listNode{val: v})
+}
 
+// pushNode inserts an already allocated node on the list, returning it.
+func (l *list) pushNode(nd *listNode) *listNode {
 	// empty list, first element
 	if l.tail == nil {
 		l.first = nd
@@ -74,6 +87,31 @@ func (l *list) pop() *listNode {
 	return aux
 }
 
+// removeIf removes every node whose value satisfies 'f', retaining the relative order
+// of the others. Returns the number of removed nodes.
+func (l *list) removeIf(f func(v interface{}) bool) int {
+	var n int
+	var prev *listNode
+	for nd := l.first; nd != nil; nd = nd.next {
+		if !f(nd.val) {
+			prev = nd
+			continue
+		}
+
+		if prev == nil {
+			l.first = nd.next
+		} else {
+			prev.next = nd.next
+		}
+		if l.tail == nd {
+			l.tail = prev
+		}
+		l.len--
+		n++
+	}
+	return n
+}
+
 // similar to Floyd's tortoise and hare algorithm
 func findMidInList(start, last *listNode) *listNode {
 	if start == nil || last == nil {
@@ -97,6 +135,46 @@ func findMidInList(start, last *listNode) *listNode {
 type State struct {
 	ind uint64
 	cmd pb.Command
+
+	// prev references the prior state a conditional command (e.g. CAS) was
+	// applied over. Only set on minStateTable structures, since stateTable
+	// lists already record every update for a key.
+	prev *State
+}
+
+// dependsOnPrior informs if the state outcome is conditioned on the prior state
+// of its key, which must not be discarded by reduce procedures.
+func (st *State) dependsOnPrior() bool {
+	return st.cmd.Op == pb.Command_CAS
+}
+
+// isWriteOp informs if 'op' results in a state change, which must be tracked by
+// the log structures.
+func isWriteOp(op pb.Command_Operation) bool {
+	return op == pb.Command_SET || op == pb.Command_CAS
+}
+
+// ignoresRead informs if 'cmd' is a read discarded on IgnoreReads configs. Discarded
+// reads are still observed on the logged interval, never reported as holes.
+func (ld *logData) ignoresRead(cmd *pb.Command) bool {
+	if ld.config.Reads != IgnoreReads || isWriteOp(cmd.Op) || isRangeDelete(cmd.Op) {
+		return false
+	}
+	ld.stats.observeIndex(cmd.Id)
+	return true
+}
+
+// attributeFirst sets 'ind' as the first index of the current interval, unless a
+// prior command was already attributed since its last reset.
+func (ld *logData) attributeFirst(ind uint64) {
+	if !ld.logged {
+		ld.first, ld.logged = ind, true
+	}
+}
+
+// resetFirst restarts the current interval at 'ind', or at no index if 'ok' is false.
+func (ld *logData) resetFirst(ind uint64, ok bool) {
+	ld.first, ld.logged = ind, ok
 }
 
 // stateTable maps state updates for particular keys, stored as an underlying
@@ -106,101 +184,356 @@ type stateTable map[string]*list
 // logData is the general data for each implementation of Structure interface
 type logData struct {
 	config      *LogConfig
-	logged      bool
+	logged      bool // if 'first' was attributed on the current interval
 	first, last uint64
-	recentLog   *[]pb.Command // used only on Immediately inmem config
-	count       uint32        // used on Interval config
+	recentLog   *[]pb.Command   // used only on Immediately inmem config
+	count       uint32          // used on Interval, Adaptive and TimeInterval configs
+	adapt       *adaptivePeriod // used only on Adaptive config
+	gc          *groupCommitter // used only on Sync config with GroupCommit
+	idx         *intervalIndex  // used only on persistent KeepAll config
+	tombs       rangeTombs      // used only on structures indexing states by key
+	lm          *latencyMeasure // used only on Measure config, except on ConcTables
+	wal         *journal        // used only on Journal config
+	mbuf        *marshalBuffer  // reused by every serialized reduce
+	viewBytes   int64           // used only on ConcTable views, estimating their occupancy
+	pins        *pinnedLog      // used only on Pinned config, shared by every view of a ConcTable
+	errs        *errorSink
+	stats       *logStats       // shared by every view of a ConcTable
+	feed        *segmentFeed    // shared by every view of a ConcTable
+	reduced     atomic.Value    // IndexInterval of the latest reduced state, unset if none
+	seq         *sequencer      // nil on UncheckedOrder config, shared by every view of a ConcTable
+	quota       *diskQuota      // used only on MaxDiskBytes config, shared by every view of a ConcTable
+	tier        *tieredStorage  // used only on ColdStorage config, shared by every view of a ConcTable
+	wlim        *writeLimiter   // used only on WriteRate config, shared by every view of a ConcTable
+	cpu         *reduceThrottle // used only on MaxReduces or ReduceYield config, shared by every view of a ConcTable
+}
+
+// newLogData returns the general log data of a structure configured by 'cfg'.
+func newLogData(cfg *LogConfig) logData {
+	ld := logData{config: cfg, errs: newErrorSink(), stats: &logStats{}, feed: newSegmentFeed()}
+	if cfg.Sync && cfg.GroupCommit > 0 {
+		ld.gc = newGroupCommitter(cfg.GroupCommit)
+	}
+	if !cfg.Inmem && cfg.KeepAll {
+		ld.idx = newIntervalIndex(indexFname(cfg.Fname))
+	}
+	if cfg.Pinned != nil {
+		ld.pins = &pinnedLog{}
+	}
+	if cfg.Ordering != UncheckedOrder {
+		ld.seq = newSequencer(cfg)
+	}
+	if cfg.MaxDiskBytes > 0 {
+		ld.quota = &diskQuota{}
+	}
+	if cfg.ColdStorage != nil {
+		ld.tier = newTieredStorage(ld.storage(), cfg.ColdStorage)
+	}
+	if cfg.WriteRate > 0 {
+		ld.wlim = newWriteLimiter(cfg.WriteRate)
+	}
+	ld.cpu = newReduceThrottle(cfg)
+	return ld
+}
+
+// storage returns the configured LogStorage, or the default filesystem storage if
+// none was provided. On ColdStorage configs, both are combined into a tiered storage.
+func (ld *logData) storage() LogStorage {
+	if ld.tier != nil {
+		return ld.tier
+	}
+	if ld.config.Storage != nil {
+		return ld.config.Storage
+	}
+	return defaultStorage
 }
 
 func (ld *logData) retrieveLog() ([]pb.Command, error) {
+	return ld.retrieveLogCtx(context.Background())
+}
+
+// retrieveLogCtx is analogous to 'retrieveLog', but interrupts the reading of the
+// persisted state once 'ctx' is done, returning ctx.Err().
+func (ld *logData) retrieveLogCtx(ctx context.Context) ([]pb.Command, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if ld.config.Inmem {
 		return *ld.recentLog, nil
 	}
 
-	// recover from the most recent state at ld.config.Fname
-	fd, err := os.OpenFile(ld.config.Fname, os.O_RDONLY, 0644)
+	// recover from the most recent state at ld.config.Fname, or ld.config.SecondFname
+	fn, err := ld.stateFname()
 	if err != nil {
 		return nil, err
 	}
-	defer fd.Close()
-	return UnmarshalLogFromReader(fd)
+
+	if ld.config.Mmap {
+		cmds, err := ld.retrieveMappedLog(fn)
+		if err != errMmapUnsupported {
+			return cmds, err
+		}
+	}
+
+	rd, err := ld.readSegment(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return UnmarshalLogFromReader(&ctxReader{ctx: ctx, r: rd})
 }
 
-func (ld *logData) retrieveRawLog(p, n uint64) ([]byte, error) {
-	var rd io.Reader
-	if ld.config.Inmem {
-		buff := bytes.NewBuffer(nil)
-		err := MarshalLogIntoWriter(buff, ld.recentLog, p, n)
+// stateFname returns the file holding the most recent persisted state. On striped
+// configs (i.e. ParallelIO or Fnames), reduces are persisted on any disk, and the state
+// covering the highest index among every path is chosen, preferring the primary disk
+// on ties. Unreadable states are skipped on Mirror configs, where other disks retain
+// the same content.
+func (ld *logData) stateFname() (string, error) {
+	fns := ld.config.diskFnames()
+	if len(fns) == 1 {
+		return ld.config.Fname, nil
+	}
+
+	var (
+		fn    = ld.config.Fname
+		last  uint64
+		found bool
+	)
+	for _, f := range fns {
+		if _, err := ld.storage().Size(f); err != nil {
+			continue
+		}
+		rd, err := ld.readSegment(f)
 		if err != nil {
-			return nil, err
+			if ld.config.ParallelMode == Mirror {
+				continue
+			}
+			return "", err
+		}
+		_, hdr, err := ReadLogHeader(newSegmentCursor(rd))
+		rd.Close()
+		if err != nil {
+			if ld.config.ParallelMode == Mirror {
+				continue
+			}
+			return "", fmt.Errorf("failed while reading log '%s', err: '%s'", f, err.Error())
+		}
+		if !found || hdr.Last > last {
+			fn, last, found = f, hdr.Last, true
 		}
-		rd = buff
+	}
+	return fn, nil
+}
 
-	} else {
-		fd, err := os.OpenFile(ld.config.Fname, os.O_RDONLY, 0644)
+// retrieveIntervalLogCtx returns the reduced states within [p, n] on Interval and
+// Immediately configs, where the most recent reduce may cover a different interval
+// than the requested one. On KeepAll configs, every segment overlapping [p, n] on the
+// manifest is merged before trimming, instead of only the latest one. Delayed configs
+// already reduce the requested interval, and are retrieved as in 'retrieveLogCtx'.
+func (ld *logData) retrieveIntervalLogCtx(ctx context.Context, p, n uint64) ([]pb.Command, error) {
+	if ld.config.Tick == Delayed {
+		return ld.retrieveLogCtx(ctx)
+	}
+	if ld.idx == nil {
+		cmds, err := ld.retrieveLogCtx(ctx)
 		if err != nil {
 			return nil, err
 		}
-		defer fd.Close()
-		rd = fd
+		return filterInterval(cmds, p, n), nil
 	}
 
-	logs, err := ioutil.ReadAll(rd)
+	segs, err := ld.idx.overlapping(ld.storage(), p, n)
 	if err != nil {
 		return nil, err
 	}
-	return logs, nil
+	all := make([]pb.Command, 0)
+	for _, sg := range segs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		cmds, _, err := ld.readPersistedSegment(sg.Name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, cmds...)
+	}
+	return filterInterval(mergeLatestStates(all), p, n), nil
+}
+
+func (ld *logData) retrieveRawLog(p, n uint64) ([]byte, error) {
+	if !ld.config.Inmem && ld.config.Mmap {
+		fn, err := ld.stateFname()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := ld.retrieveMappedRawLog(fn)
+		if err != errMmapUnsupported {
+			return raw, err
+		}
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := ld.streamRawLog(buff, p, n); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// streamRawLog writes the serialized log state directly into 'w', marshaling the
+// in-memory state or copying from persistent storage without an intermediate buffer.
+func (ld *logData) streamRawLog(w io.Writer, p, n uint64) error {
+	if ld.config.Inmem {
+		return MarshalLogWithCodec(w, ld.config.Codec, ld.recentLog, p, n)
+	}
+
+	fn, err := ld.stateFname()
+	if err != nil {
+		return err
+	}
+
+	if ld.config.Mmap {
+		if err := ld.streamMappedLog(w, fn); err != errMmapUnsupported {
+			return err
+		}
+	}
+
+	rd, err := ld.readSegment(fn)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	_, err = io.Copy(w, rd)
+	return err
+}
+
+// updateLogStateCtx is analogous to 'updateLogState', but returns ctx.Err() without
+// persisting if 'ctx' is done. Writes are never interrupted once started, since a
+// partially written segment could corrupt the latest persisted state.
+func (ld *logData) updateLogStateCtx(ctx context.Context, lg []pb.Command, p, n uint64, disk int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ld.updateLogState(lg, p, n, disk)
 }
 
-func (ld *logData) updateLogState(lg []pb.Command, p, n uint64, secDisk bool) error {
+// updateLogState persists the reduced log 'lg' of interval [p, n] on the path of 'disk',
+// indexing the configured paths (see 'LogConfig.diskFnames'), where 0 is always the
+// primary config.Fname.
+func (ld *logData) updateLogState(lg []pb.Command, p, n uint64, disk int) error {
+	if ld.config.DropExpired {
+		lg = dropExpiredStates(lg, time.Now().UnixNano())
+	}
+	lg = ld.withPinnedHistory(lg, p, n)
+
 	if ld.config.Inmem {
 		// update the most recent inmem log state
 		ld.recentLog = &lg
-		return nil
+		ld.recordReduced(p, n)
+		return ld.publishSegment("", lg, p, n)
 	}
 
-	fn := ld.config.Fname
-	if secDisk {
-		if !ld.config.ParallelIO {
-			return fmt.Errorf("can not persist to secondary disk if ParallelIO is unset")
-		}
-		fn = ld.config.SecondFname
+	fns := ld.config.diskFnames()
+	if disk < 0 || disk >= len(fns) {
+		return fmt.Errorf("can not persist to disk %d, only %d paths are configured", disk, len(fns))
 	}
+	fn := fns[disk]
 
 	if ld.config.KeepAll {
 		// create a new state and and filename at ld.config.Fname
-		sep := strings.SplitAfter(fn, ".")
+		fn = segmentFname(fn, p, n, ld.config.Naming)
+	}
 
-		// modify last index
-		sep[len(sep)-1] = strconv.FormatUint(n, 10) + ".log"
-		fn = strings.Join(sep, "")
+	start := time.Now()
+	sum, err := ld.persistState(fn, lg, p, n)
+	if err != nil {
+		return err
 	}
+	atomic.StoreInt64(&ld.stats.lastPersist, int64(time.Since(start)))
 
-	if ld.config.Sync {
-		fd, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_SYNC, 0644)
+	// every new segment is recorded on the manifest with its interval and checksum,
+	// and emitted to subscribers, except for mirrored copies on secondary disks
+	if disk > 0 && ld.config.ParallelMode == Mirror {
+		return nil
+	}
+	if ld.idx != nil {
+		err = ld.idx.add(ld.storage(), segmentInterval{Name: fn, First: p, Last: n, Disk: disk, Checksum: sum, HasChecksum: true})
 		if err != nil {
 			return err
 		}
-		defer fd.Close()
-
-		err = MarshalBufferedLogIntoWriter(fd, &lg, p, n)
-		if err != nil {
+		if err = ld.mayEvictSegments(); err != nil {
 			return err
 		}
+		ld.mayMigrateSegments()
+	}
+	if err := ld.truncateJournal(p, n); err != nil {
+		return err
+	}
+	ld.recordReduced(p, n)
+	return ld.publishSegment(fn, lg, p, n)
+}
 
-	} else {
-		fd, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			return err
+// segmentFname returns the name of the segment covering [p, n] derived from 'fn', on
+// KeepAll configs, replacing its extension following the 'naming' scheme.
+func segmentFname(fn string, p, n uint64, naming SegmentNaming) string {
+	sep := strings.SplitAfter(fn, ".")
+	switch naming {
+	case IntervalNaming:
+		sep[len(sep)-1] = fmt.Sprintf("%020d-%020d.log", p, n)
+
+	default:
+		// modify last index
+		sep[len(sep)-1] = strconv.FormatUint(n, 10) + ".log"
+	}
+	return strings.Join(sep, "")
+}
+
+// persistState writes the reduced log 'lg' into a new segment 'fn', following the
+// configured encryption and durability params. Returns the crc32 (Castagnoli) checksum
+// of the serialized log, before any encryption.
+func (ld *logData) persistState(fn string, lg []pb.Command, p, n uint64) (uint32, error) {
+	if ld.config.Encryption != nil {
+		return ld.persistEncryptedState(fn, lg, p, n)
+	}
+
+	seg, err := ld.storage().Create(fn)
+	if err != nil {
+		return 0, err
+	}
+	sum := crc32.New(castagnoli)
+	cw := &countingWriter{w: ld.throttle(io.MultiWriter(seg, sum))}
+
+	if !ld.config.Sync {
+		defer seg.Close()
+		if err = MarshalLogWithCodec(cw, ld.config.Codec, &lg, p, n); err != nil {
+			return 0, err
 		}
-		defer fd.Close()
+		ld.hookPersist(fn, cw.n)
+		return sum.Sum32(), nil
+	}
 
-		err = MarshalLogIntoWriter(fd, &lg, p, n)
-		if err != nil {
-			return err
+	err = ld.marshalBuffer().marshal(cw, &lg, p, n)
+	if err != nil {
+		seg.Close()
+		return 0, err
+	}
+
+	if ld.gc != nil {
+		// durability is delegated to the group committer, which syncs and
+		// closes 'seg' along with its batch
+		if err = ld.gc.commit(fn, seg); err != nil {
+			return 0, err
 		}
+		ld.hookPersist(fn, cw.n)
+		return sum.Sum32(), nil
 	}
-	return nil
+
+	defer seg.Close()
+	if err = seg.Sync(); err != nil {
+		return 0, err
+	}
+	ld.hookPersist(fn, cw.n)
+	return sum.Sum32(), nil
 }
 
 func (ld *logData) appendToLogState(lg []pb.Command, p, n uint64) error {
@@ -211,24 +544,34 @@ func (ld *logData) appendToLogState(lg []pb.Command, p, n uint64) error {
 		return nil
 	}
 
+	if ld.config.Encryption != nil {
+		return fmt.Errorf("can not append commands to an encrypted log state")
+	}
+
 	// update the current state at ld.config.Fname
-	fd, err := os.OpenFile(ld.config.Fname, os.O_CREATE|os.O_WRONLY, 0644)
+	seg, err := ld.storage().Append(ld.config.Fname)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
+	defer seg.Close()
+
+	// update log indexes, recognizing the same format of 'UpdateLogIndexesInFile'
+	if _, err = seg.WriteAt(encodeVersionedLogHeader(ld.config.Codec.version(), p, n, len(lg)), 0); err != nil {
+		return err
+	}
 
-	if err = UpdateLogIndexesInFile(fd, p, n, len(lg)); err != nil {
+	buff := bytes.NewBuffer(nil)
+	if err = marshalCommandsWithCodec(buff, ld.config.Codec, &lg); err != nil {
 		return err
 	}
 
-	if err = MarshalAndAppendIntoWriter(fd, &lg); err != nil {
+	if _, err = buff.WriteTo(seg); err != nil {
 		return err
 	}
 	return nil
 }
 
-// firstReduceExists is execute on Interval tick config, and checks if a ReduceLog
+// firstReduceExists is execute on periodic tick configs, and checks if a ReduceLog
 // procedure was already executed. False is returned if no recent reduced state is
 // found (i.e. first 'ld.config.Period' wasnt reached yet).
 func (ld *logData) firstReduceExists() bool {
@@ -238,12 +581,89 @@ func (ld *logData) firstReduceExists() bool {
 
 	// disk config, found any state file
 	// TODO: verify if the found file has a matching interval?
-	if _, exists := os.Stat(ld.config.Fname); exists == nil {
+	if _, err := ld.storage().Size(ld.config.Fname); err == nil {
 		return true
 	}
+	for _, fn := range ld.config.diskFnames()[1:] {
+		if _, err := ld.storage().Size(fn); err == nil {
+			return true
+		}
+	}
 	return false
 }
 
+// reachedReducePeriod is executed on periodic tick configs, counting a new logged
+// command and informing if the reduce period was reached, reseting the counter if
+// so. On Adaptive config, 'occ' informs the number of unique keys currently on the
+// structure, and the effective period is adjusted once reached.
+func (ld *logData) reachedReducePeriod(occ int) bool {
+	ld.count++
+	if ld.config.Tick == Adaptive {
+		if ld.adapt == nil {
+			ld.adapt = newAdaptivePeriod(ld.config)
+		}
+		if ld.count < ld.adapt.period() {
+			return false
+		}
+		ld.adapt.adjust(ld.count, occ)
+
+	} else if ld.count < ld.config.Period {
+		return false
+	}
+	ld.count = 0
+	return true
+}
+
+// nextReachesReducePeriod informs if the next logged command would reach the reduce
+// period on periodic tick configs, without counting it.
+func (ld *logData) nextReachesReducePeriod() bool {
+	if ld.config.Tick == Adaptive {
+		if ld.adapt == nil {
+			ld.adapt = newAdaptivePeriod(ld.config)
+		}
+		return ld.count+1 >= ld.adapt.period()
+	}
+	return ld.count+1 >= ld.config.Period
+}
+
+// launchReduceTicker invokes 'reduce' every 'd' on a new goroutine, until 'ctx' is
+// cancelled. Used on TimeInterval configs.
+func launchReduceTicker(ctx context.Context, d time.Duration, reduce func()) {
+	go func() {
+		tk := time.NewTicker(d)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-tk.C:
+				reduce()
+			}
+		}
+	}()
+}
+
+// dropExpiredStates removes from a reduced log every key whose latest state expired
+// at 'now' (i.e. unix nanoseconds), including any prior state retained as dependency
+// of a conditional command. Keys without an expiration are never removed.
+func dropExpiredStates(log []pb.Command, now int64) []pb.Command {
+	latest := make(map[string]int64, len(log))
+	for _, c := range log {
+		latest[c.Key] = c.ExpiresAt
+	}
+
+	cmds := log[:0]
+	for _, c := range log {
+		if exp := latest[c.Key]; exp != 0 && exp <= now {
+			continue
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds
+}
+
 // RetainLogInterval receives an entire log and returns the corresponding log
 // matching [p, n] indexes.
 func RetainLogInterval(log *[]pb.Command, p, n uint64) []pb.Command {
@@ -263,30 +683,36 @@ func RetainLogInterval(log *[]pb.Command, p, n uint64) []pb.Command {
 // UnmarshalLogFromReader returns the entire log contained at 'logRd', interpreting commands
 // from the byte stream following a simple slicing protocol, where the size of each command
 // is binary encoded before each raw pbuff.
+//
+// Both versioned and legacy headerless logs are accepted, including the commands of
+// every segment on ProtoLogFormatVersion logs.
 func UnmarshalLogFromReader(logRd io.Reader) ([]pb.Command, error) {
-	var f, l uint64
-	var ln int
-
-	// read the retrieved log interval
-	_, err := fmt.Fscanf(logRd, "%d\n%d\n%d\n", &f, &l, &ln)
+	logRd, hdr, err := readLogVersion(logRd)
 	if err != nil {
 		return nil, err
 	}
+	if hdr.Version == ProtoLogFormatVersion {
+		return unmarshalProtoCommands(logRd)
+	}
+
+	if err := readLogInterval(logRd, &hdr); err != nil {
+		return nil, err
+	}
 
-	if ln >= 0 {
-		return unmarshalBeelog(logRd, ln)
+	if hdr.Len >= 0 {
+		return unmarshalBeelog(logRd, hdr.Version, hdr.Len)
 	}
-	return unmarshalTradLog(logRd)
+	return unmarshalTradLog(logRd, hdr.Version)
 }
 
-// beelog format starts with three integers: the first and the last indexes of the retrieved
+// beelog format starts with an optional magic and version header, followed by three integers: the first and the last indexes of the retrieved
 // command interval, and 'n', representing the number of commands on the log. Due to log
 // reduce procedures, the number of retrieved commands will possibly be less than the 'last - first'
 // difference. The numbers are followed by a sequence of 'n' serialized pbuff commands, each
 // prefixed by its binary encoded size, 32b, BigEndian format. An 'EOL' flag at tail is mandatory,
-// signaling a safe log creation.
-func unmarshalBeelog(rd io.Reader, ln int) ([]pb.Command, error) {
-	cmds := make([]pb.Command, 0, ln)
+// signaling a safe log creation. Commands are decoded following the format 'version'.
+func unmarshalBeelog(rd io.Reader, version uint8, ln int) ([]pb.Command, error) {
+	cmds := make([]pb.Command, 0, preallocLen(ln))
 	for j := 0; j < ln; j++ {
 		var cmdLen int32
 		err := binary.Read(rd, binary.BigEndian, &cmdLen)
@@ -295,9 +721,12 @@ func unmarshalBeelog(rd io.Reader, ln int) ([]pb.Command, error) {
 		} else if err != nil {
 			return nil, err
 		}
+		if err := checkCommandLen(cmdLen); err != nil {
+			return nil, err
+		}
 
 		raw := make([]byte, cmdLen)
-		_, err = rd.Read(raw)
+		_, err = io.ReadFull(rd, raw)
 		if err == io.EOF {
 			break
 		} else if err != nil {
@@ -305,7 +734,7 @@ func unmarshalBeelog(rd io.Reader, ln int) ([]pb.Command, error) {
 		}
 
 		c := &pb.Command{}
-		err = proto.Unmarshal(raw, c)
+		err = decodeCommand(version, raw, c)
 		if err != nil {
 			return nil, err
 		}
@@ -324,12 +753,12 @@ func unmarshalBeelog(rd io.Reader, ln int) ([]pb.Command, error) {
 	return cmds, nil
 }
 
-// traditional log format starts with three integers: the first and the last indexes of the
+// traditional log format starts with an optional magic and version header, followed by three integers: the first and the last indexes of the
 // retrieved command interval, and '-1', differentiating this log format from 'beelog'.
 // The numbers are followed by a sequence of serialized pbuff commands, each prefixed by
 // its binary encoded size, 32b, BigEndian format. Commands are parsed until EOF or
 // ErrUnexpectedEOF during file read.
-func unmarshalTradLog(rd io.Reader) ([]pb.Command, error) {
+func unmarshalTradLog(rd io.Reader, version uint8) ([]pb.Command, error) {
 	cmds := make([]pb.Command, 0)
 	for j := 0; ; j++ {
 		var cmdLen int32
@@ -339,9 +768,12 @@ func unmarshalTradLog(rd io.Reader) ([]pb.Command, error) {
 		} else if err != nil {
 			return nil, err
 		}
+		if err := checkCommandLen(cmdLen); err != nil {
+			return nil, err
+		}
 
 		raw := make([]byte, cmdLen)
-		_, err = rd.Read(raw)
+		_, err = io.ReadFull(rd, raw)
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		} else if err != nil {
@@ -349,7 +781,7 @@ func unmarshalTradLog(rd io.Reader) ([]pb.Command, error) {
 		}
 
 		c := &pb.Command{}
-		err = proto.Unmarshal(raw, c)
+		err = decodeCommand(version, raw, c)
 		if err != nil {
 			return nil, err
 		}
@@ -364,16 +796,15 @@ func unmarshalTradLog(rd io.Reader) ([]pb.Command, error) {
 //
 // Important: 'EOL' flag is not mandatory when limiting the number of commands. That allows a
 // concurrent interpretation of the log content while being written by an APPEND file descriptor.
+// Logs continuously written are better tailed by a Follower.
 func UnmarshalLogWithLenFromReader(logRd io.Reader, n int) ([]pb.Command, error) {
 	// read the retrieved log interval ln parsed, matching log format, but ignored
-	var f, l uint64
-	var ln int
-	_, err := fmt.Fscanf(logRd, "%d\n%d\n%d\n", &f, &l, &ln)
+	logRd, hdr, err := ReadLogHeader(logRd)
 	if err != nil {
 		return nil, err
 	}
 
-	cmds := make([]pb.Command, 0, n)
+	cmds := make([]pb.Command, 0, preallocLen(n))
 	for j := 0; j < n; j++ {
 		var commandLength int32
 		err := binary.Read(logRd, binary.BigEndian, &commandLength)
@@ -382,17 +813,20 @@ func UnmarshalLogWithLenFromReader(logRd io.Reader, n int) ([]pb.Command, error)
 		} else if err != nil {
 			return nil, err
 		}
+		if err := checkCommandLen(commandLength); err != nil {
+			return nil, err
+		}
 
 		raw := make([]byte, commandLength)
-		_, err = logRd.Read(raw)
-		if err == io.EOF {
+		_, err = io.ReadFull(logRd, raw)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			return nil, fmt.Errorf("expected a log with %d commands, but got %d", n, j)
 		} else if err != nil {
 			return nil, err
 		}
 
 		c := &pb.Command{}
-		err = proto.Unmarshal(raw, c)
+		err = decodeCommand(hdr.Version, raw, c)
 		if err != nil {
 			return nil, err
 		}
@@ -403,39 +837,10 @@ func UnmarshalLogWithLenFromReader(logRd io.Reader, n int) ([]pb.Command, error)
 
 // MarshalLogIntoWriter records the provided log indexes into 'logWr' writer, then marshals
 // the entire command log following a simple serialization procedure where the size of
-// each command is binary encoded before the raw pbuff. Commands are marshaled and written to
-// 'logWr' one by one.
+// each command is binary encoded before the raw pbuff. Commands are marshaled into a
+// pooled buffer, and written to 'logWr' in chunks.
 func MarshalLogIntoWriter(logWr io.Writer, log *[]pb.Command, p, n uint64) error {
-	// write requested delimiters for the current state and num
-	_, err := fmt.Fprintf(logWr, "%d\n%d\n%d\n", p, n, len(*log))
-	if err != nil {
-		return err
-	}
-
-	for _, c := range *log {
-		raw, err := proto.Marshal(&c)
-		if err != nil {
-			return err
-		}
-
-		// writing size of each serialized message as streaming delimiter
-		err = binary.Write(logWr, binary.BigEndian, int32(len(raw)))
-		if err != nil {
-			return err
-		}
-
-		_, err = logWr.Write(raw)
-		if err != nil {
-			return err
-		}
-	}
-
-	// manually write an add-hoc EOL (end-of-log) mark
-	_, err = fmt.Fprintln(logWr, "\nEOL")
-	if err != nil {
-		return err
-	}
-	return nil
+	return MarshalLogWithCodec(logWr, ProtoCodec, log, p, n)
 }
 
 // MarshalBufferedLogIntoWriter ...
@@ -455,27 +860,24 @@ func MarshalBufferedLogIntoWriter(logWr io.Writer, log *[]pb.Command, p, n uint6
 	return nil
 }
 
+// MarshalTradLogIntoWriter is analogous to 'MarshalLogIntoWriter', but follows the
+// traditional log format, where a '-1' length identifies a non-reduced log and no EOL
+// mark is written, allowing commands to be continuously appended.
+func MarshalTradLogIntoWriter(logWr io.Writer, log *[]pb.Command, p, n uint64) error {
+	err := writeLogHeader(logWr, p, n, -1)
+	if err != nil {
+		return err
+	}
+	return marshalCommandsIntoWriter(logWr, log)
+}
+
 // MarshalAndAppendIntoWriter marshals the entire command log following a simple serialization
 // procedure where the size of each command is binary encoded before the raw pbuff. After
 // serialization the entire byte sequence is appended to 'logWr' on a single call.
 func MarshalAndAppendIntoWriter(logWr io.WriteSeeker, log *[]pb.Command) error {
 	buff := bytes.NewBuffer(nil)
-	for _, c := range *log {
-		raw, err := proto.Marshal(&c)
-		if err != nil {
-			return err
-		}
-
-		// writing size of each serialized message as streaming delimiter
-		err = binary.Write(buff, binary.BigEndian, int32(len(raw)))
-		if err != nil {
-			return err
-		}
-
-		_, err = buff.Write(raw)
-		if err != nil {
-			return err
-		}
+	if err := marshalCommandsIntoWriter(buff, log); err != nil {
+		return err
 	}
 
 	_, err := logWr.Seek(0, io.SeekEnd)
@@ -489,8 +891,44 @@ func MarshalAndAppendIntoWriter(logWr io.WriteSeeker, log *[]pb.Command) error {
 	return nil
 }
 
+// marshalCommandsIntoWriter marshals each command of 'log' into 'w', prefixed by its
+// binary encoded size, without any log header or EOL mark. Commands are marshaled into
+// a single pooled buffer (see 'pb.Command.MarshalAppendVT'), flushed into 'w' once
+// 'cmdFlushSize' bytes are reached, instead of allocating a new slice per command.
+func marshalCommandsIntoWriter(w io.Writer, log *[]pb.Command) error {
+	return marshalCommandsWithCodec(w, ProtoCodec, log)
+}
+
+// marshalCommandsWithCodec is analogous to 'marshalCommandsIntoWriter', but encodes
+// each command through 'codec'.
+func marshalCommandsWithCodec(w io.Writer, codec Codec, log *[]pb.Command) error {
+	pooled := getCmdBuffer()
+	defer putCmdBuffer(pooled)
+
+	b := *pooled
+	defer func() { *pooled = b[:0] }()
+
+	for i := range *log {
+		b = appendCommandWithCodec(b, codec, &(*log)[i])
+		if len(b) >= cmdFlushSize {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			b = b[:0]
+		}
+	}
+
+	if len(b) > 0 {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UpdateLogIndexesInFile updates the persistent log indexes without unmarshaling then marshaling
 // the entire sequence. Recognizes the following format (single quotes (') chars not present):
+//   'BEELOG''version byte'\n
 //   'p index'\n
 //   'n index'\n
 //   'len' cdms\n
@@ -501,9 +939,5 @@ func UpdateLogIndexesInFile(fd *os.File, p, n uint64, ln int) error {
 		return err
 	}
 
-	_, err = fmt.Fprintf(fd, "%d\n%d\n%d\n", p, n, ln)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeLogHeader(fd, p, n, ln)
 }
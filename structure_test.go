@@ -4,19 +4,36 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Lz-Gustavo/beelog/pb"
 
 	"github.com/golang/protobuf/proto"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 func TestStructuresLog(t *testing.T) {
@@ -28,8 +45,10 @@ func TestStructuresLog(t *testing.T) {
 	arr := NewArrayHT()
 	buf := NewCircBuffHT(context.TODO())
 	ct := NewConcTable(context.TODO())
+	sl := NewSkipListHT()
+	rt := NewRadixHT()
 
-	for _, st := range []Structure{lt, arr, avl, buf, ct} {
+	for _, st := range []Structure{lt, arr, avl, buf, ct, sl, rt} {
 		// populate some SET commands
 		for i := first; i < n; i++ {
 			err := st.Log(pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i))})
@@ -105,6 +124,28 @@ func TestStructuresLog(t *testing.T) {
 			}
 			break
 
+		case *SkipListHT:
+			if tp.first != first {
+				t.Log("first cmd index is", tp.first, ", expected", first)
+				t.FailNow()
+			}
+			if tp.last != n {
+				t.Log("last cmd index is", tp.last, ", expected", n)
+				t.FailNow()
+			}
+			break
+
+		case *RadixHT:
+			if tp.first != first {
+				t.Log("first cmd index is", tp.first, ", expected", first)
+				t.FailNow()
+			}
+			if tp.last != n {
+				t.Log("last cmd index is", tp.last, ", expected", n)
+				t.FailNow()
+			}
+			break
+
 		case *ConcTable:
 			if tp.logs[tp.current].first != first {
 				t.Log("first cmd index is", tp.logs[tp.current].first, ", expected", first)
@@ -387,259 +428,4881 @@ func TestStructuresRecovBytesInterpretation(t *testing.T) {
 	}
 }
 
-func generateRandStructure(id uint8, n uint64, wrt, dif int, cfg *LogConfig) (Structure, error) {
-	srand := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(srand)
-	var st Structure
-	var err error
+func TestStructuresAdaptivePeriod(t *testing.T) {
+	nCmds, wrt, dif := uint64(4000), 50, 100
+	cfg := LogConfig{
+		Inmem:     true,
+		Tick:      Adaptive,
+		MinPeriod: 100,
+		MaxPeriod: 1000,
+	}
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
 
-	switch id {
-	case 0: // list
-		if cfg == nil {
-			st = NewListHT() // uses DefaultLogConfig underneath
-		} else {
-			st, err = NewListHTWithConfig(cfg)
-			if err != nil {
-				return nil, err
-			}
-		}
-		break
+	for id, alg := range algs {
+		cf := cfg
+		cf.Alg = alg
 
-	case 1: // array
-		if cfg == nil {
-			st = NewArrayHT()
-		} else {
-			st, err = NewArrayHTWithConfig(cfg)
-			if err != nil {
-				return nil, err
-			}
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
 		}
-		break
 
-	case 2: // avl
-		if cfg == nil {
-			st = NewAVLTreeHT()
-		} else {
-			st, err = NewAVLTreeHTWithConfig(cfg)
-			if err != nil {
-				return nil, err
-			}
+		var ld *logData
+		switch tp := st.(type) {
+		case *ListHT:
+			ld = &tp.logData
+		case *ArrayHT:
+			ld = &tp.logData
+		case *AVLTreeHT:
+			ld = &tp.logData
+		case *CircBuffHT:
+			ld = &tp.logData
+		case *ConcTable:
+			ld = &tp.logs[0]
 		}
-		break
 
-	case 3: // circbuff
-		if cfg == nil {
-			st = NewCircBuffHT(context.TODO())
-		} else {
-			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, int(n))
-			if err != nil {
-				return nil, err
-			}
+		if ld.adapt == nil {
+			t.Logf("structure '%T' never reached an adaptive period", st)
+			t.FailNow()
 		}
-		break
-
-	case 4: // conctable
-		if cfg == nil {
-			st = NewConcTable(context.TODO())
-		} else {
-			st, err = NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
-			if err != nil {
-				return nil, err
-			}
+		if per := ld.adapt.period(); per < cf.MinPeriod || per > cf.MaxPeriod {
+			t.Log("effective period", per, "out of configured bounds")
+			t.FailNow()
 		}
-		break
-
-	default:
-		return nil, fmt.Errorf("unknow structure '%d' requested", id)
 	}
 
-	for i := uint64(0); i < n; i++ {
-		var cmd pb.Command
-		if cn := r.Intn(100); cn < wrt {
-			cmd = pb.Command{
-				Id:    i,
-				Key:   strconv.Itoa(r.Intn(dif)),
-				Value: strconv.Itoa(r.Int()),
-				Op:    pb.Command_SET,
-			}
-
-		} else {
-			// only SETS states are needed
-			cmd = pb.Command{
-				Id: i,
-				Op: pb.Command_GET,
-			}
-		}
-		err = st.Log(cmd)
-		if err != nil {
-			return nil, err
-		}
+	invalid := LogConfig{Inmem: true, Tick: Adaptive, MinPeriod: 100, MaxPeriod: 10}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on MaxPeriod < MinPeriod")
+		t.FailNow()
 	}
-	return st, nil
 }
 
-// deserializeRawLog emulates the same procedure implemented by a recoverying
-// replica, interpreting the serialized log received from any byte stream.
-func deserializeRawLog(log []byte) ([]pb.Command, error) {
-	rd := bytes.NewReader(log)
-
-	// read the retrieved log interval
-	var f, l uint64
-	var ln int
-	_, err := fmt.Fscanf(rd, "%d\n%d\n%d\n", &f, &l, &ln)
-	if err != nil {
-		return nil, err
+func TestStructuresTimeInterval(t *testing.T) {
+	nCmds, wrt, dif := uint64(200), 50, 100
+	cfg := LogConfig{
+		Inmem:    true,
+		Tick:     TimeInterval,
+		Duration: 50 * time.Millisecond,
 	}
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
 
-	cmds := make([]pb.Command, 0, ln)
-	for j := 0; j < ln; j++ {
-		var commandLength int32
-		err := binary.Read(rd, binary.BigEndian, &commandLength)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-
-		serializedCmd := make([]byte, commandLength)
-		_, err = rd.Read(serializedCmd)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
-		}
+	for id, alg := range algs {
+		cf := cfg
+		cf.Alg = alg
 
-		c := &pb.Command{}
-		err = proto.Unmarshal(serializedCmd, c)
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
 		if err != nil {
-			return nil, err
+			t.Log(err.Error())
+			t.FailNow()
 		}
-		cmds = append(cmds, *c)
-	}
-
-	var eol string
-	_, err = fmt.Fscanf(rd, "\n%s\n", &eol)
-	if err != nil {
-		return nil, err
-	}
-
-	if eol != "EOL" {
-		return nil, fmt.Errorf("expected EOL flag, got '%s'", eol)
-	}
-	return cmds, nil
-}
 
-// logsAreEquivalent checks if two command log sequences are equivalent with
-// one another. In this context, two logs 'a' and 'b' are considered 'equivalent'
-// iff the deterministic, sequentialy execution of key-value commands on 'b'
-// yields the same final state observed on executing 'a'. Two recovered sequences
-// from beelog can have commands on different orders, which is safe as long as
-// if a log posses a command 'c' on index 'i', no other log records a command 'k'
-// on 'i' where 'k' != 'c'.
-func logsAreEquivalent(logA, logB []pb.Command) bool {
-	// not the same size, directly not equivalent
-	if len(logA) != len(logB) {
-		return false
-	}
+		// must wait the ticker routine...
+		time.Sleep(200 * time.Millisecond)
 
-	// they are already deeply equal, same values on the same positions
-	if reflect.DeepEqual(logA, logB) {
-		return true
-	}
+		var reduced bool
+		switch tp := st.(type) {
+		case *ListHT:
+			tp.mu.RLock()
+			reduced = tp.firstReduceExists()
+			tp.mu.RUnlock()
+			tp.Shutdown()
 
-	// apply each log on a hash table, checking if they have the same
-	// values for the same keys
-	htA := make(map[string]string)
-	htB := make(map[string]string)
+		case *ArrayHT:
+			tp.mu.RLock()
+			reduced = tp.firstReduceExists()
+			tp.mu.RUnlock()
+			tp.Shutdown()
 
-	for i := range logA {
-		htA[logA[i].Key] = logA[i].Value
-		htB[logB[i].Key] = logB[i].Value
-	}
-	return reflect.DeepEqual(htA, htB)
-}
+		case *AVLTreeHT:
+			tp.mu.RLock()
+			reduced = tp.firstReduceExists()
+			tp.mu.RUnlock()
+			tp.Shutdown()
 
-// logsAreOnlyDelayed checks wheter two log sequences are only delayed or incoherent
-// with each other. In this context, two 'equivalent' logs 'a' and 'b' are considered
-// 'delayed' with each other if:
-//   (i)  'cA' < 'minB' forall command 'cA' in 'a' AND
-//   (ii) 'cB' < 'minA' forall command 'cB' in 'b'
-//
-// where:
-//   -'minA' is the lowest index in the set of 'advanced' commands of 'a' from 'b'
-//   -'minB' is the lowest index in the set of 'advanced' commands of 'b' from 'a'
-//
-// 'advanced' commands:
-//  Let 'x' and 'y' be log commands, 'x' is considered 'advanced' from 'y' iff:
-//   (i)  'x' and 'y' operate over the same underlying key (i.e. 'x.Key' == 'y.Key') AND
-//   (ii) 'x' has a higher index than 'y' (i.e. 'x.Ind' > 'y.Ind')
-func logsAreOnlyDelayed(logA, logB []pb.Command) bool {
-	htA := make(map[string]uint64)
-	htB := make(map[string]uint64)
+		case *CircBuffHT:
+			tp.Shutdown()
+			reduced = tp.firstReduceExists()
 
-	// apply both logs on a hash table
-	for i := range logA {
-		htA[logA[i].Key] = logA[i].Id
+		case *ConcTable:
+			tp.Shutdown()
+			tp.mu[0].Lock()
+			reduced = tp.logs[0].firstReduceExists()
+			tp.mu[0].Unlock()
+		}
 
-		if i < len(logB) {
-			htB[logB[i].Key] = logB[i].Id
+		if !reduced {
+			t.Logf("structure '%T' didnt reduce after the configured duration", st)
+			t.FailNow()
 		}
 	}
+}
 
-	// store only the minor inconsistent index
-	var minIndexA, minIndexB uint64
+func TestStructuresDropExpired(t *testing.T) {
+	now := time.Now()
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 2, Op: pb.Command_SET, Key: "b", Value: "1", ExpiresAt: now.Add(time.Hour).UnixNano()},
+		{Id: 3, Op: pb.Command_SET, Key: "c", Value: "1", ExpiresAt: now.Add(-time.Hour).UnixNano()},
+		{Id: 4, Op: pb.Command_SET, Key: "d", Value: "1"},
+		{Id: 5, Op: pb.Command_SET, Key: "d", Value: "2", ExpiresAt: now.Add(-time.Second).UnixNano()},
+	}
+	expected := []pb.Command{cmds[0], cmds[1]}
 
-	for kA, indA := range htA {
-		// both logs have the same record index for a key 'kA'
-		if indA == htB[kA] {
-			continue
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		cfg := LogConfig{
+			Inmem:       true,
+			Tick:        Delayed,
+			Alg:         alg,
+			DropExpired: true,
 		}
 
-		if indA > htB[kA] {
-			// logA has an advance record for 'kA'
-			minIndexA = min(minIndexA, indA)
-
+		var st Structure
+		var err error
+		if id == 3 {
+			// circbuff must not reach its capacity
+			st, err = NewCircBuffHTWithConfig(context.TODO(), &cfg, len(cmds)+1)
 		} else {
-			// logB has an advance record for 'kA'
-			minIndexB = min(minIndexB, htB[kA])
+			st, err = generateRandStructure(uint8(id), 0, 0, 0, &cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
 		}
-	}
 
-	// iterate over B, if any key has a higher index than 'minorIndexA' then its wrong
-	for _, ind := range htB {
-		if ind > minIndexA {
-			return false
+		for _, c := range cmds {
+			if err := st.Log(c); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
 		}
-	}
 
-	// same for A regarding 'minorIndexB'
-	for _, ind := range htA {
-		if ind > minIndexB {
-			return false
+		log, err := st.Recov(1, 5)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(expected, log) {
+			t.Logf("structure '%T' didnt drop expired states", st)
+			t.Log("EXPC:", expected)
+			t.Log("RECV:", log)
+			t.FailNow()
 		}
 	}
-	return true
 }
 
-func cleanAllLogStates() error {
-	// TODO: think about a more restrictive pattern later
-	fs, err := filepath.Glob("./*.log")
-	if err != nil {
-		return err
-	}
+func TestStructuresRecovBytesStream(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(100), uint64(1500)
 
-	for _, f := range fs {
+	cfgs := []LogConfig{
+		{ // inmem stream recov
+			Tick:  Delayed,
+			Inmem: true,
+		},
+		{ // disk stream recov
+			Tick:  Delayed,
+			Inmem: false,
+			Fname: "./logstate.log",
+		},
+	}
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+
+	for id, alg := range algs {
+		for _, cf := range cfgs {
+			cf.Alg = alg
+			st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			redLog, err := ApplyReduceAlgo(st, cf.Alg, p, n)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			buff := bytes.NewBuffer(nil)
+			if err := st.RecovBytesStream(buff, p, n); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			log, err := deserializeRawLog(buff.Bytes())
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			if !logsAreEquivalent(redLog, log) && !logsAreOnlyDelayed(redLog, log) {
+				t.Logf("structure '%T' streamed an incoherent log", st)
+				t.Log("REDC:", redLog)
+				t.Log("RECV:", log)
+				t.FailNow()
+			}
+		}
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestStructuresMmapRecovery(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(0), uint64(1500)
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		cf := LogConfig{
+			Alg:   alg,
+			Tick:  Delayed,
+			Inmem: false,
+			Mmap:  true,
+			Fname: "./logstate.log",
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		redLog, err := ApplyReduceAlgo(st, cf.Alg, p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		raw, err := st.RecovBytes(p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		log, err := UnmarshalLogFromBytes(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(redLog, log) && !logsAreOnlyDelayed(redLog, log) {
+			t.Logf("structure '%T' recovered an incoherent log through mmap", st)
+			t.Log("REDC:", redLog)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+
+		cmds, err := st.Recov(p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(redLog, cmds) && !logsAreOnlyDelayed(redLog, cmds) {
+			t.Logf("structure '%T' recovered an incoherent log through mmap", st)
+			t.Log("REDC:", redLog)
+			t.Log("RECV:", cmds)
+			t.FailNow()
+		}
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestStructuresEncryption(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(0), uint64(1500)
+
+	kr, err := NewKeyRing(1, bytes.Repeat([]byte{0xbe}, 32))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		cf := LogConfig{
+			Alg:        alg,
+			Tick:       Delayed,
+			Inmem:      false,
+			Fname:      "./logstate.log",
+			Encryption: kr,
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		redLog, err := ApplyReduceAlgo(st, cf.Alg, p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		raw, err := st.RecovBytes(p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		log, err := deserializeRawLog(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(redLog, log) && !logsAreOnlyDelayed(redLog, log) {
+			t.Logf("structure '%T' recovered an incoherent encrypted log", st)
+			t.Log("REDC:", redLog)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+
+		// persisted segments must not be interpretable without decryption
+		fs, _ := filepath.Glob("./logstate*.log")
+		for _, fn := range fs {
+			seg, err := ioutil.ReadFile(fn)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if _, err := UnmarshalLogFromReader(bytes.NewReader(seg)); err == nil {
+				t.Logf("structure '%T' persisted a plaintext segment at '%s'", st, fn)
+				t.FailNow()
+			}
+		}
+	}
+
+	// segments sealed before a key rotation must remain readable
+	old, err := EncryptSegment(kr, []byte("before"))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := kr.Rotate(2, bytes.Repeat([]byte{0x10}, 16)); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if plain, err := DecryptSegment(kr, old); err != nil || string(plain) != "before" {
+		t.Log("failed decrypting segment sealed before rotation, err:", err)
+		t.FailNow()
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func TestLogFormatVersionHeader(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 2, Op: pb.Command_SET, Key: "b", Value: "2"},
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(buff, &cmds, 1, 2); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	versioned := buff.Bytes()
+
+	if !bytes.HasPrefix(versioned, append(logFormatMagic, LogFormatVersion)) {
+		t.Log("marshaled log doesnt start with the format magic and version")
+		t.FailNow()
+	}
+
+	// legacy headerless logs must still be interpreted
+	legacy := bytes.NewBuffer(nil)
+	fmt.Fprintf(legacy, "%d\n%d\n%d\n", 1, 2, len(cmds))
+	if err := marshalCommandsIntoWriter(legacy, &cmds); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	fmt.Fprintln(legacy, "\nEOL")
+
+	for _, raw := range [][]byte{versioned, legacy.Bytes()} {
+		fromRd, err := UnmarshalLogFromReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// a non ByteScanner reader must also support legacy logs
+		fromIo, err := UnmarshalLogFromReader(io.MultiReader(bytes.NewReader(raw)))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		fromBytes, err := UnmarshalLogFromBytes(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(cmds, fromRd) || !logsAreEquivalent(cmds, fromIo) || !logsAreEquivalent(cmds, fromBytes) {
+			t.Log("unmarshaled log differs from the original one")
+			t.FailNow()
+		}
+	}
+
+	// newer unknown versions must be rejected
+	future := append([]byte(nil), versioned...)
+	future[len(logFormatMagic)] = latestLogFormatVersion + 1
+	if _, err := UnmarshalLogFromReader(bytes.NewReader(future)); err == nil {
+		t.Log("expected an error on unknown log format version")
+		t.FailNow()
+	}
+}
+
+func TestProtoLogFormat(t *testing.T) {
+	first := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_IntValue{IntValue: -2}},
+	}
+	second := []pb.Command{
+		{Id: 5, Op: pb.Command_DELETE_RANGE, Key: "a", EndKey: "c"},
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalProtoLogIntoWriter(buff, &first, 1, 4); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := AppendProtoSegment(buff, &second, 5, 5); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	raw := buff.Bytes()
+
+	segs, err := UnmarshalProtoLog(io.MultiReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(segs) != 2 || segs[0].First != 1 || segs[0].Last != 4 || segs[1].First != 5 || segs[1].N != 1 {
+		t.Log("unexpected segments:", segs)
+		t.FailNow()
+	}
+	if !logsAreEquivalent(first, segs[0].Commands) || !logsAreEquivalent(second, segs[1].Commands) {
+		t.Log("unmarshaled segments differ from the original ones")
+		t.FailNow()
+	}
+
+	// segments are a plain protobuf encoding, decodable without beelog procedures
+	size, n := binary.Uvarint(raw[len(logFormatMagic)+2:])
+	var seg pb.LogSegment
+	if err := seg.UnmarshalVT(raw[len(logFormatMagic)+2+n : len(logFormatMagic)+2+n+int(size)]); err != nil || seg.N != 2 {
+		t.Log("could not decode the first segment:", err)
+		t.FailNow()
+	}
+
+	cmds, err := UnmarshalLogFromReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !logsAreEquivalent(append(first, second...), cmds) {
+		t.Log("expected the commands of every segment, got", cmds)
+		t.FailNow()
+	}
+
+	// truncated segments and the text header API must be rejected
+	if _, err := UnmarshalProtoLog(bytes.NewReader(raw[:len(raw)-2])); err == nil {
+		t.Log("expected an error on a truncated segment")
+		t.FailNow()
+	}
+	if _, _, err := ReadLogHeader(bytes.NewReader(raw)); err == nil {
+		t.Log("expected an error reading a text header from a protobuf log")
+		t.FailNow()
+	}
+}
+
+// logSegmentDescriptor returns the descriptor of the LogSegment message declared on
+// pb/segment.proto, resolved by the protobuf runtime independently of the hand-written
+// codecs of package pb.
+func logSegmentDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       protov2.String("segment.proto"),
+		Package:    protov2.String("pb"),
+		Syntax:     protov2.String("proto3"),
+		Dependency: []string{"command.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: protov2.String("LogSegment"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: protov2.String("First"), Number: protov2.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum()},
+				{Name: protov2.String("Last"), Number: protov2.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum()},
+				{Name: protov2.String("N"), Number: protov2.Int32(3), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum()},
+				{Name: protov2.String("Commands"), Number: protov2.Int32(4), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: protov2.String(".pb.Command")},
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	return fd.Messages().ByName("LogSegment")
+}
+
+func TestProtoLogStandardRuntime(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1", Ip: "10.0.0.1", ExpiresAt: 7, Term: 2, ClientId: "c", RequestId: 9},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "2", Expected: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_BytesValue{BytesValue: []byte{0, 1, 2}}},
+		{Id: 4, Op: pb.Command_SET, Key: "c", Typed: &pb.Command_IntValue{IntValue: -3}},
+		{Id: 5, Op: pb.Command_SET, Key: "d", Typed: &pb.Command_FloatValue{FloatValue: 0.25}},
+		{Id: 6, Op: pb.Command_DELETE_RANGE, Key: "e", EndKey: "f"},
+	}
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalProtoLogIntoWriter(buff, &cmds, 1, 6); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// after the format header, the delimited segment is decoded by the protobuf
+	// runtime alone
+	raw := buff.Bytes()[len(logFormatMagic)+2:]
+	size, n := binary.Uvarint(raw)
+	if n <= 0 || uint64(len(raw)-n) != size {
+		t.Log("invalid segment length", size, "on", len(raw)-n, "bytes")
+		t.FailNow()
+	}
+	md := logSegmentDescriptor(t)
+	seg := dynamicpb.NewMessage(md)
+	if err := protov2.Unmarshal(raw[n:], seg); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	fields := md.Fields()
+	if f, l, c := seg.Get(fields.ByName("First")).Uint(), seg.Get(fields.ByName("Last")).Uint(), seg.Get(fields.ByName("N")).Uint(); f != 1 || l != 6 || c != uint64(len(cmds)) {
+		t.Log("decoded segment [", f, ",", l, "] with", c, "commands, expected [ 1 , 6 ] with", len(cmds))
+		t.FailNow()
+	}
+
+	list := seg.Get(fields.ByName("Commands")).List()
+	if list.Len() != len(cmds) {
+		t.Log("decoded", list.Len(), "commands, expected", len(cmds))
+		t.FailNow()
+	}
+	for i := 0; i < list.Len(); i++ {
+		b, err := protov2.Marshal(list.Get(i).Message().Interface())
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		var cmd pb.Command
+		if err := proto.Unmarshal(b, &cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !proto.Equal(&cmd, &cmds[i]) {
+			t.Log("decoded command", cmd.String(), "expected", cmds[i].String())
+			t.FailNow()
+		}
+	}
+}
+
+func TestFlatCodec(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1", Ip: "10.0.0.1", ExpiresAt: 7, Term: 2, ClientId: "c", RequestId: 9},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "2", Expected: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_BytesValue{BytesValue: []byte{0, 1, 2}}},
+		{Id: 4, Op: pb.Command_SET, Key: "c", Typed: &pb.Command_IntValue{IntValue: -3}},
+		{Id: 5, Op: pb.Command_SET, Key: "d", Typed: &pb.Command_FloatValue{FloatValue: 0.25}},
+		{Id: 6, Op: pb.Command_DELETE_RANGE, Key: "e", EndKey: "f"},
+	}
+	equal := func(a, b []pb.Command) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !proto.Equal(&a[i], &b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalLogWithCodec(buff, FlatCodec, &cmds, 1, 6); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	raw := buff.Bytes()
+
+	fromRd, err := UnmarshalLogFromReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	fromBytes, err := UnmarshalLogFromBytes(raw)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	fromDec, err := UnmarshalLogInto(bytes.NewReader(raw), nil)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !equal(cmds, fromRd) || !equal(cmds, fromBytes) || !equal(cmds, fromDec) {
+		t.Log("unmarshaled flat log differs from the original one")
+		t.FailNow()
+	}
+
+	var keys []string
+	err = IterFlatLog(raw, func(fc FlatCommand) error {
+		keys = append(keys, fc.Key())
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "a", "b", "c", "d", "e"}) {
+		t.Log("unexpected flat iteration:", keys, err)
+		t.FailNow()
+	}
+
+	// inconsistent field lengths must be rejected, never read out of bounds
+	corrupted := append([]byte(nil), raw...)
+	corrupted[bytes.Index(raw, []byte("10.0.0.1"))-4*flatFields+4*flatKey] = 0xff
+	if _, err := UnmarshalLogFromReader(bytes.NewReader(corrupted)); err == nil {
+		t.Log("expected an error on a corrupted flat command")
+		t.FailNow()
+	}
+	if err := IterFlatLog(corrupted, func(FlatCommand) error { return nil }); err == nil {
+		t.Log("expected an error iterating a corrupted flat command")
+		t.FailNow()
+	}
+
+	// persisted segments and recovered bytes follow the configured codec
+	for _, mmap := range []bool{false, true} {
+		cfg := &LogConfig{Tick: Delayed, Alg: GreedyLt, Fname: t.TempDir() + "/flat.log", Codec: FlatCodec, Mmap: mmap}
+		l, err := NewListHTWithConfig(cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, c := range cmds {
+			if err := l.Log(c); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		rec, err := l.Recov(1, 6)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		raw, err := l.RecovBytes(1, 6)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		n := 0
+		if err := IterFlatLog(raw, func(FlatCommand) error { n++; return nil }); err != nil || n != len(rec) {
+			t.Log("expected", len(rec), "flat commands recovered, got", n, err)
+			t.FailNow()
+		}
+	}
+
+	if _, err := NewLSMLogWithConfig(context.TODO(), &LogConfig{Inmem: true, Tick: Delayed, Alg: MergeLSM, Codec: FlatCodec}, 2); err == nil {
+		t.Log("expected an error setting a codec on LSMLog")
+		t.FailNow()
+	}
+}
+
+func TestExportLog(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Value: "1"},
+		{Id: 2, Op: pb.Command_CAS, Key: "a", Value: "2", Expected: "1"},
+		{Id: 3, Op: pb.Command_SET, Key: "b,c", Value: "3", ExpiresAt: 10},
+		{Id: 4, Op: pb.Command_DELETE_RANGE, Key: "a", EndKey: "b"},
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := ExportLog(buff, JSON, cmds); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	dec := json.NewDecoder(buff)
+	for i := range cmds {
+		var ec exportedCommand
+		if err := dec.Decode(&ec); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if ec.ID != cmds[i].Id || ec.Op != cmds[i].Op.String() || ec.Key != cmds[i].Key || ec.EndKey != cmds[i].EndKey ||
+			ec.Value != cmds[i].Value || ec.Expected != cmds[i].Expected || ec.ExpiresAt != cmds[i].ExpiresAt {
+			t.Log("exported JSON command differs, expected", cmds[i], "got", ec)
+			t.FailNow()
+		}
+	}
+
+	buff.Reset()
+	if err := ExportLog(buff, CSV, cmds); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	recs, err := csv.NewReader(buff).ReadAll()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recs) != len(cmds)+1 || !reflect.DeepEqual(recs[0], csvHeader) || recs[3][2] != "b,c" {
+		t.Log("unexpected CSV export:", recs)
+		t.FailNow()
+	}
+
+	// range deletions retain their upper bound
+	if rec := recs[4]; rec[1] != "DELETE_RANGE" || rec[2] != "a" || rec[3] != "b" {
+		t.Log("unexpected CSV export of a range deletion:", rec)
+		t.FailNow()
+	}
+
+	if _, err := ParseFormat("yaml"); err == nil {
+		t.Log("expected an error on unknown export format")
+		t.FailNow()
+	}
+}
+
+func TestStructuresTypedPayload(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a", Typed: &pb.Command_BytesValue{BytesValue: []byte{0, 1, 255}}},
+		{Id: 2, Op: pb.Command_SET, Key: "b", Typed: &pb.Command_IntValue{IntValue: -42}},
+		{Id: 3, Op: pb.Command_SET, Key: "c", Typed: &pb.Command_FloatValue{FloatValue: 0.5}},
+		{Id: 4, Op: pb.Command_SET, Key: "d", Value: "plain"},
+	}
+	dir := t.TempDir()
+
+	for i := 0; i < 5; i++ {
+		cfg := &LogConfig{
+			Inmem: false,
+			Tick:  Delayed,
+			Alg:   []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}[i],
+			Fname: dir + "/typed-" + strconv.Itoa(i) + ".log",
+		}
+
+		var st Structure
+		var err error
+		switch i {
+		case 0:
+			st, err = NewListHTWithConfig(cfg)
+		case 1:
+			st, err = NewArrayHTWithConfig(cfg)
+		case 2:
+			st, err = NewAVLTreeHTWithConfig(cfg)
+		case 3:
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, len(cmds)+2)
+		case 4:
+			st, err = NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// leading command, since the first index can be skipped on array searches
+		if err := st.Log(pb.Command{Id: 0, Op: pb.Command_SET, Key: "z", Value: "0"}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, cmd := range cmds {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		log, err := st.Recov(0, uint64(len(cmds)))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		recov := make(map[string]pb.Command, len(log))
+		for _, cmd := range log {
+			recov[cmd.Key] = cmd
+		}
+		for _, cmd := range cmds {
+			rc, ok := recov[cmd.Key]
+			if !ok || !proto.Equal(&rc, &cmd) {
+				t.Log("typed payload not retained on structure", i, ", expected", cmd, "got", rc)
+				t.FailNow()
+			}
+		}
+	}
+
+	if v := cmds[1].ValueString(); v != "-42" {
+		t.Log("unexpected printable int payload:", v)
+		t.FailNow()
+	}
+	if b := cmds[0].ValueBytes(); !bytes.Equal(b, []byte{0, 1, 255}) {
+		t.Log("unexpected raw bytes payload:", b)
+		t.FailNow()
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := ExportLog(buff, JSON, cmds); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	var ec exportedCommand
+	if err := json.NewDecoder(buff).Decode(&ec); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !bytes.Equal(ec.Bytes, []byte{0, 1, 255}) {
+		t.Log("exported JSON command lost its bytes payload:", ec)
+		t.FailNow()
+	}
+}
+
+func TestStructuresMetadata(t *testing.T) {
+	nCmds, dif := 60, 10
+	cmds := make([]pb.Command, 0, nCmds)
+	for i := 0; i < nCmds; i++ {
+		cmds = append(cmds, pb.Command{
+			Id:        uint64(i),
+			Op:        pb.Command_SET,
+			Key:       strconv.Itoa(i % dif),
+			Value:     strconv.Itoa(i),
+			Term:      uint64(i/10 + 1),
+			ClientId:  "client-" + strconv.Itoa(i%3),
+			RequestId: uint64(1000 + i),
+		})
+	}
+	dir := t.TempDir()
+
+	for i := 0; i < 5; i++ {
+		cfg := &LogConfig{
+			Inmem: false,
+			Tick:  Delayed,
+			Alg:   []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}[i],
+			Fname: dir + "/metadata-" + strconv.Itoa(i) + ".log",
+		}
+
+		var st Structure
+		var err error
+		switch i {
+		case 0:
+			st, err = NewListHTWithConfig(cfg)
+		case 1:
+			st, err = NewArrayHTWithConfig(cfg)
+		case 2:
+			st, err = NewAVLTreeHTWithConfig(cfg)
+		case 3:
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, nCmds+1)
+		case 4:
+			st, err = NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		for _, cmd := range cmds {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// every recovered command must be byte-for-byte equal to the logged one
+		checkMetadata := func(log []pb.Command, path string) {
+			if len(log) != dif {
+				t.Log("expected", dif, "commands on structure", i, path, ", got", len(log))
+				t.FailNow()
+			}
+			for _, cmd := range log {
+				if !proto.Equal(&cmd, &cmds[cmd.Id]) {
+					t.Log("metadata not preserved on structure", i, path, ", expected", cmds[cmd.Id], "got", cmd)
+					t.FailNow()
+				}
+			}
+		}
+
+		// ConcTable views are advanced on each recovery
+		if i != 4 {
+			log, err := st.Recov(0, uint64(nCmds-1))
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			checkMetadata(log, "Recov")
+		}
+
+		raw, err := st.RecovBytes(0, uint64(nCmds-1))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		log, err := deserializeRawLog(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		checkMetadata(log, "RecovBytes")
+
+		buff := bytes.NewBuffer(nil)
+		if err := ExportLog(buff, JSON, log[:1]); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		var ec exportedCommand
+		if err := json.NewDecoder(buff).Decode(&ec); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if ec.Term != log[0].Term || ec.ClientID != log[0].ClientId || ec.RequestID != log[0].RequestId {
+			t.Log("exported JSON command lost its metadata:", ec)
+			t.FailNow()
+		}
+	}
+}
+
+func TestStructuresRecovSince(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 50
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cb, err := NewCircBuffHTWithConfig(ctx, DefaultLogConfig(), int(nCmds))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	sts := []Structure{NewListHT(), NewArrayHT(), NewAVLTreeHT(), NewSkipListHT(), cb, NewConcTable(ctx)}
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	ref := NewListHT()
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		ref.Log(cmd)
+		for _, st := range sts {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+	}
+
+	for _, id := range []uint64{0, nCmds / 2, nCmds - 10, nCmds} {
+		exp := []pb.Command{}
+		if id < nCmds-1 {
+			exp = GreedyListHT(ref, id+1, nCmds-1)
+		}
+
+		for _, st := range sts {
+			log, err := st.RecovSince(id)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			if !logsAreEquivalent(exp, log) {
+				t.Logf("structure '%T' returned an incoherent log since index %d", st, id)
+				t.Log("EXPC:", exp)
+				t.Log("RECV:", log)
+				t.FailNow()
+			}
+		}
+	}
+
+	// indexes not present on the structure start from their lower bound
+	sparse := []Structure{NewListHT(), NewArrayHT(), NewAVLTreeHT(), NewSkipListHT()}
+	ref = NewListHT()
+	for i := uint64(0); i < nCmds; i += 2 {
+		cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i) % dif), Value: strconv.Itoa(int(i))}
+		ref.Log(cmd)
+		for _, st := range sparse {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+	}
+
+	id := nCmds/2 + 1
+	exp := GreedyListHT(ref, id+1, nCmds-2)
+	for _, st := range sparse {
+		log, err := st.RecovSince(id)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(log) == 0 || !logsAreEquivalent(exp, log) {
+			t.Logf("structure '%T' returned an incoherent log since absent index %d", st, id)
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+	}
+}
+
+func TestCircBuffGrowth(t *testing.T) {
+	nCmds, dif, wrt, cap := uint64(2000), 300, 80, 100
+	cfgs := []*LogConfig{
+		{Inmem: true, Tick: Delayed, Alg: IterCircBuff, Growth: GrowWhenFull, MaxCap: 4 * int(nCmds)},
+		{Inmem: true, Tick: Delayed, Alg: IterCircBuff, Growth: SpillWhenFull},
+	}
+
+	for i, cfg := range cfgs {
+		cb, err := NewCircBuffHTWithConfig(context.TODO(), cfg, cap)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		ref := NewListHT()
+
+		ch := make(chan pb.Command, nCmds+1)
+		createRandomLog(nCmds, dif, wrt, ch)
+
+		for j := uint64(0); j < nCmds; j++ {
+			cmd := <-ch
+			ref.Log(cmd)
+			if err := cb.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// capacity overflow must not trigger a reduce on Delayed config
+		if cb.firstReduceExists() {
+			t.Log("config", i, ": buffer overflow forced a reduce on Delayed config")
+			t.FailNow()
+		}
+		if cfg.Growth == GrowWhenFull && cb.cap <= cap {
+			t.Log("config", i, ": expected buffer growth, capacity remained", cb.cap)
+			t.FailNow()
+		}
+
+		log, err := cb.Recov(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		exp := GreedyListHT(ref, 0, nCmds-1)
+
+		if !logsAreEquivalent(exp, log) {
+			t.Log("config", i, ": CircBuffHT returned an incoherent log after overflow")
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+		cb.Shutdown()
+	}
+}
+
+func TestAVLTreeReset(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 80
+	cfg := DefaultLogConfig()
+	cfg.Alg = GreedyAvl
+
+	avl, err := NewAVLTreeHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	for round := 0; round < 3; round++ {
+		ref := NewListHT()
+		ch := make(chan pb.Command, nCmds+1)
+		createRandomLog(nCmds, dif, wrt, ch)
+
+		for i := uint64(0); i < nCmds; i++ {
+			cmd := <-ch
+			ref.Log(cmd)
+			if err := avl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		log, err := avl.Recov(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		exp := GreedyListHT(ref, 0, nCmds-1)
+
+		if !logsAreEquivalent(exp, log) {
+			t.Log("round", round, ": AVLTreeHT returned an incoherent log after reset")
+			t.FailNow()
+		}
+		avl.Reset()
+
+		if avl.Len() != 0 {
+			t.Log("round", round, ": expected an empty tree after reset, got", avl.Len(), "nodes")
+			t.FailNow()
+		}
+	}
+
+	// every node after the first round must be recycled
+	stats := avl.AllocStats()
+	if stats.Reused == 0 || stats.Allocs-stats.Reused > uint64(stats.Capacity) {
+		t.Log("expected recycled allocations after reset, got", stats)
+		t.FailNow()
+	}
+}
+
+func TestAVLTreeIterativeInsert(t *testing.T) {
+	nNodes := 5000
+	recur := NewAVLTreeHT()
+	iter, err := NewAVLTreeHTWithConfig(&LogConfig{Inmem: true, Tick: Delayed, IterativeInsert: true})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// random insertion order, exercising every rotation case
+	for _, ind := range rand.Perm(nNodes) {
+		for _, av := range []*AVLTreeHT{recur, iter} {
+			if !av.insert(&avlTreeEntry{ind: uint64(ind)}) {
+				t.Log("failed to insert index", ind)
+				t.FailNow()
+			}
+		}
+	}
+
+	if iter.insert(&avlTreeEntry{ind: 0}) {
+		t.Log("iterative insert accepted a repeated index")
+		t.FailNow()
+	}
+	if iter.Len() != uint64(nNodes) {
+		t.Log("expected", nNodes, "nodes, got", iter.Len())
+		t.FailNow()
+	}
+
+	// both procedures must build the exact same tree
+	if recur.Str() != iter.Str() {
+		t.Log("iterative and recursive inserts built different trees")
+		t.FailNow()
+	}
+}
+
+func TestStructuresRangeDelete(t *testing.T) {
+	cmds := []pb.Command{
+		{Id: 1, Op: pb.Command_SET, Key: "a/1", Value: "1"},
+		{Id: 2, Op: pb.Command_SET, Key: "a/2", Value: "2"},
+		{Id: 3, Op: pb.Command_SET, Key: "b/1", Value: "3"},
+		{Id: 4, Op: pb.Command_DELETE_PREFIX, Key: "a/"},
+		{Id: 5, Op: pb.Command_SET, Key: "a/3", Value: "5"},
+		{Id: 6, Op: pb.Command_SET, Key: "c", Value: "6"},
+		{Id: 7, Op: pb.Command_DELETE_RANGE, Key: "b", EndKey: "c"},
+		{Id: 8, Op: pb.Command_SET, Key: "b/2", Value: "8"},
+		{Id: 9, Op: pb.Command_DELETE_PREFIX, Key: "a/"},
+	}
+
+	// the first prefix delete is subsumed by the last one
+	exp := []uint64{6, 7, 8, 9}
+
+	cfg := func(alg Reducer) *LogConfig {
+		return &LogConfig{Inmem: true, Tick: Delayed, Alg: alg}
+	}
+	lt, _ := NewListHTWithConfig(cfg(GreedyLt))
+	ar, _ := NewArrayHTWithConfig(cfg(GreedyArray))
+	av, _ := NewAVLTreeHTWithConfig(cfg(GreedyAvl))
+	rt, _ := NewRadixHTWithConfig(cfg(IterRadix))
+	ct, _ := NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg(IterConcTable))
+	sts := []Structure{lt, ar, av, rt, ct}
+
+	for _, st := range sts {
+		for _, cmd := range cmds {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		log, err := st.Recov(1, 9)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// range deletes must precede every remaining state
+		ids := make([]uint64, 0, len(log))
+		for i, c := range log {
+			if i > 0 && isRangeDelete(c.Op) && !isRangeDelete(log[i-1].Op) {
+				t.Logf("structure '%T' returned a range delete after a key state: %v", st, log)
+				t.FailNow()
+			}
+			ids = append(ids, c.Id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		if !reflect.DeepEqual(ids, exp) {
+			t.Logf("structure '%T' returned indexes %v, expected %v", st, ids, exp)
+			t.FailNow()
+		}
+	}
+	ct.Shutdown()
+
+	// merged segments also apply range deletes
+	merged := mergeLatestStates(cmds)
+	if len(merged) != len(exp) {
+		t.Log("expected", len(exp), "merged states, got", merged)
+		t.FailNow()
+	}
+
+	for _, st := range []Structure{NewCircBuffHT(context.TODO()), NewSkipListHT()} {
+		if err := st.Log(cmds[3]); err != ErrRangeDeleteUnsupported {
+			t.Logf("structure '%T' accepted a range delete, got err: %v", st, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestStructuresSnapshot(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 50
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cb, err := NewCircBuffHTWithConfig(ctx, DefaultLogConfig(), int(nCmds))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	sts := []Structure{NewListHT(), NewArrayHT(), NewAVLTreeHT(), cb, NewConcTable(ctx), NewSkipListHT(), NewRadixHT()}
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	ref := NewListHT()
+	logAll := func(n uint64) {
+		for i := uint64(0); i < n; i++ {
+			cmd := <-ch
+			ref.Log(cmd)
+			for _, st := range sts {
+				if err := st.Log(cmd); err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+			}
+		}
+	}
+
+	logAll(nCmds / 2)
+	exp := GreedyListHT(ref, 0, nCmds/2)
+
+	snaps := make([]*Snapshot, 0, len(sts))
+	for _, st := range sts {
+		s, err := st.Snapshot()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		snaps = append(snaps, s)
+	}
+
+	// commands logged after creation must not affect snapshots
+	logAll(nCmds / 2)
+
+	for i, s := range snaps {
+		log, err := s.Recov()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, log) {
+			t.Logf("snapshot of structure '%T' returned an incoherent log", sts[i])
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+
+		raw, err := s.RecovBytes()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		dec, err := UnmarshalLogFromReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, dec) {
+			t.Logf("serialized snapshot of structure '%T' returned an incoherent log", sts[i])
+			t.FailNow()
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := s.ExportJSON(buf); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("\n")); n != len(exp) {
+			t.Logf("snapshot of structure '%T' exported %d commands, expected %d", sts[i], n, len(exp))
+			t.FailNow()
+		}
+	}
+}
+
+func TestCircBuffImmediatelyConcurrent(t *testing.T) {
+	nCmds, dif, writers, cap := 1000, 50, 4, 16
+	cfg := &LogConfig{Inmem: true, Tick: Immediately, Alg: IterCircBuff}
+	cb, err := NewCircBuffHTWithConfig(context.TODO(), cfg, cap)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer cb.Shutdown()
+
+	// reduces executed by each Log call read their copy while others keep inserting,
+	// wrapping the buffer around many times
+	var id uint64
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < nCmds; i++ {
+				ind := atomic.AddUint64(&id, 1)
+				cmd := pb.Command{Id: ind, Op: pb.Command_SET, Key: strconv.Itoa(int(ind) % dif), Value: strconv.Itoa(int(ind))}
+				if err := cb.Log(cmd); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if l := cb.Len(); l > uint64(cap) {
+		t.Log("expected at most", cap, "buffered entries, got", l)
+		t.FailNow()
+	}
+	log, err := cb.Recov(0, id)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) == 0 || len(log) > cap {
+		t.Log("expected the reduced state of at most", cap, "entries, got", len(log))
+		t.FailNow()
+	}
+}
+
+func TestCircBuffCopyIsolation(t *testing.T) {
+	nCmds, dif, wrt, cap := uint64(1000), 200, 100, 300
+	cb, err := NewCircBuffHTWithConfig(context.TODO(), DefaultLogConfig(), cap)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	ref := NewListHT()
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	// copies reference the buffer instead of duplicating it, and must remain
+	// coherent while the buffer wraps around and restarts
+	var cps []buffCopy
+	var exps [][]pb.Command
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		ref.Log(cmd)
+		if err := cb.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if i%100 == 50 {
+			cb.mu.Lock()
+			cp := cb.createStateCopy()
+			cb.mu.Unlock()
+
+			cps = append(cps, cp)
+			exps = append(exps, GreedyListHT(ref, cp.first, cp.last))
+		}
+	}
+
+	for i := range cps {
+		if log := IterCircBuffHT(&cps[i]); !logsAreEquivalent(exps[i], log) {
+			t.Log("copy", i, "was modified by later insertions")
+			t.Log("EXPC:", exps[i])
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+	}
+	cb.Shutdown()
+}
+
+func TestStructuresLatencyMeasurement(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 50
+	cfg := &LogConfig{
+		Inmem:   true,
+		Measure: true,
+		Tick:    Interval,
+		Period:  200,
+		Fname:   filepath.Join(t.TempDir(), "logstate.log"),
+	}
+	names := []string{"list", "array", "avl", "circbuff"}
+
+	for i, id := range []uint8{0, 1, 2, 3} {
+		cfg.Alg = []Reducer{GreedyLt, GreedyArray, GreedyAvl, IterCircBuff}[i]
+		st, err := generateRandStructure(id, nCmds, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// reduces are asynchronous on CircBuff structures
+		if cb, ok := st.(*CircBuffHT); ok {
+			for len(cb.reduceReq) > 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		// latency tuples are flushed during shutdown
+		st.(interface{ Shutdown() }).Shutdown()
+
+		fn := measureFname(names[i], cfg)
+		fd, err := os.Open(fn)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		recs, err := csv.NewReader(fd).ReadAll()
+		fd.Close()
+		os.Remove(fn)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if len(recs) == 0 || len(recs) > int(nCmds/uint64(cfg.Period)) {
+			t.Log("structure", names[i], "recorded", len(recs), "latency tuples")
+			t.FailNow()
+		}
+		for _, r := range recs {
+			var prev int64
+			for _, v := range r {
+				ts, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || ts < prev {
+					t.Log("structure", names[i], "recorded an incoherent latency tuple:", r)
+					t.FailNow()
+				}
+				prev = ts
+			}
+		}
+	}
+}
+
+func TestLatencyReservoir(t *testing.T) {
+	nCmds, dif, wrt := uint64(4000), 100, 50
+	buf := bytes.NewBuffer(nil)
+	cfg := &LogConfig{
+		Inmem:            true,
+		Measure:          true,
+		Alg:              GreedyLt,
+		Tick:             Interval,
+		Period:           200,
+		Fname:            filepath.Join(t.TempDir(), "logstate.log"),
+		MeasureSink:      NewCSVSink(buf),
+		MeasureReservoir: 5,
+	}
+	st, err := generateRandStructure(0, nCmds, wrt, dif, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	l := st.(*ListHT)
+
+	// tuples are only aggregated before shutdown
+	if buf.Len() != 0 {
+		t.Log("expected no streamed tuple with a reservoir config, got:", buf.String())
+		t.FailNow()
+	}
+	hs := l.Latencies()
+	if hs == nil || hs.Persist.Count() <= uint64(cfg.MeasureReservoir) {
+		t.Log("expected more aggregated tuples than the reservoir size")
+		t.FailNow()
+	}
+	if hs.Persist.Min() < hs.Fill.Min() || hs.Persist.Quantile(0.5) <= 0 {
+		t.Log("incoherent latency histograms")
+		t.FailNow()
+	}
+
+	l.Shutdown()
+	recs, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(recs) != cfg.MeasureReservoir {
+		t.Log("expected", cfg.MeasureReservoir, "sampled tuples, got", len(recs))
+		t.FailNow()
+	}
+}
+
+func TestLatencyMeasureDeterministic(t *testing.T) {
+	draws := func(cfg *LogConfig) []bool {
+		lm := newLatencyMeasure(10, cfg, nil)
+		res := make([]bool, 0, 100)
+		for i := 0; i < 1000; i++ {
+			lm.absIndex++
+			if lm.absIndex%lm.interval == 1 {
+				res = append(res, lm.drawInterval())
+			}
+		}
+		return res
+	}
+
+	sampled := &LogConfig{MeasureRate: 0.3, Seed: 42}
+	if !reflect.DeepEqual(draws(sampled), draws(sampled)) {
+		t.Log("expected identical draws with the same config.Seed")
+		t.FailNow()
+	}
+
+	for i, drawn := range draws(&LogConfig{MeasureEvery: 4}) {
+		if drawn != (i%4 == 0) {
+			t.Log("expected every 4th interval measured, got a different draw on interval", i)
+			t.FailNow()
+		}
+	}
+
+	if err := (&LogConfig{Inmem: true, MeasureRate: 0.5, MeasureEvery: 2}).ValidateConfig(); err == nil {
+		t.Log("expected an error combining MeasureRate and MeasureEvery")
+		t.FailNow()
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h Histogram
+	for i := int64(1); i <= 100000; i++ {
+		h.Record(i * 1000)
+	}
+	if h.Count() != 100000 || h.Min() != 1000 || h.Max() != 100000000 {
+		t.Log("unexpected histogram bounds:", h.Count(), h.Min(), h.Max())
+		t.FailNow()
+	}
+
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+		exp := q * 100000000
+		got := float64(h.Quantile(q))
+		if rel := (got - exp) / exp; rel > 0.01 || rel < -0.01 {
+			t.Log("quantile", q, "expected", exp, "got", got)
+			t.FailNow()
+		}
+	}
+
+	var m Histogram
+	m.Record(0)
+	m.Merge(&h)
+	if m.Count() != h.Count()+1 || m.Min() != 0 || m.Max() != h.Max() {
+		t.Log("unexpected merged histogram bounds:", m.Count(), m.Min(), m.Max())
+		t.FailNow()
+	}
+}
+
+func TestMeasureSinks(t *testing.T) {
+	tuples := []LatData{{1, 2, 3, 4}, {5, 6, 7, 8}}
+	var (
+		mu   sync.Mutex
+		reqs [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		reqs = append(reqs, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	csvBuf, jsonBuf := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	sinks := []MeasureSink{NewCSVSink(csvBuf), NewJSONLSink(jsonBuf), NewOTLPSink(srv.URL, "test")}
+
+	for _, sk := range sinks {
+		for _, d := range tuples {
+			if err := sk.Record(d); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		if err := sk.Close(); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	if exp := "1,2,3,4\n5,6,7,8\n"; csvBuf.String() != exp {
+		t.Log("expected CSV output", exp, "got", csvBuf.String())
+		t.FailNow()
+	}
+
+	dec := json.NewDecoder(jsonBuf)
+	for _, exp := range tuples {
+		var d LatData
+		if err := dec.Decode(&d); err != nil || d != exp {
+			t.Log("expected JSONL tuple", exp, "got", d, err)
+			t.FailNow()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 {
+		t.Log("expected a single OTLP export, got", len(reqs))
+		t.FailNow()
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					Start string `json:"startTimeUnixNano"`
+					End   string `json:"endTimeUnixNano"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(reqs[0], &req); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != len(tuples) || spans[1].Start != "5" || spans[1].End != "8" {
+		t.Log("unexpected OTLP spans:", spans)
+		t.FailNow()
+	}
+}
+
+func TestStructuresRecovFiltered(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(0), nCmds-1
+	prefix := KeyPrefix("1")
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		cf := LogConfig{
+			Alg:   alg,
+			Tick:  Delayed,
+			Inmem: true,
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		redLog, err := ApplyReduceAlgo(st, cf.Alg, p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		exp := filterByKey(redLog, prefix)
+
+		log, err := st.RecovFiltered(p, n, prefix)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		raw, err := st.RecovBytesFiltered(p, n, prefix)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		fromRaw, err := deserializeRawLog(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(exp, log) || !logsAreEquivalent(exp, fromRaw) {
+			t.Logf("structure '%T' returned an incoherent filtered log", st)
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+
+		for _, c := range log {
+			if !strings.HasPrefix(c.Key, "1") {
+				t.Logf("structure '%T' returned key '%s' outside of the filter", st, c.Key)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestStructuresHooks(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	p, n := uint64(0), uint64(1000)
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		var mu sync.Mutex
+		var starts, recovs int
+		var done []ReduceStats
+		persisted := make(map[string]int64)
+
+		cf := LogConfig{
+			Alg:   alg,
+			Tick:  Delayed,
+			Inmem: false,
+			Fname: "./logstate.log",
+			Hooks: &Hooks{
+				OnReduceStart: func(p, n uint64) {
+					mu.Lock()
+					starts++
+					mu.Unlock()
+				},
+				OnReduceDone: func(stats ReduceStats) {
+					mu.Lock()
+					done = append(done, stats)
+					mu.Unlock()
+				},
+				OnPersist: func(file string, bytes int64) {
+					mu.Lock()
+					persisted[file] = bytes
+					mu.Unlock()
+				},
+				OnRecovery: func(p, n uint64) {
+					mu.Lock()
+					recovs++
+					mu.Unlock()
+				},
+			},
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		raw, err := st.RecovBytes(p, n)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		mu.Lock()
+		if recovs != 1 || starts == 0 || len(done) != starts {
+			t.Logf("structure '%T' invoked %d recovery, %d reduce start and %d reduce done hooks", st, recovs, starts, len(done))
+			t.FailNow()
+		}
+
+		log, err := deserializeRawLog(raw)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if last := done[len(done)-1]; last.Err != nil || last.Commands != len(log) {
+			t.Logf("structure '%T' informed incoherent reduce stats %+v, recovered %d cmds", st, last, len(log))
+			t.FailNow()
+		}
+
+		if sz, ok := persisted[cf.Fname]; !ok || sz != int64(len(raw)) {
+			t.Logf("structure '%T' informed %d persisted bytes, expected %d", st, sz, len(raw))
+			t.FailNow()
+		}
+		mu.Unlock()
+	}
+
+	if err := cleanAllLogStates(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+}
+
+func generateRandStructure(id uint8, n uint64, wrt, dif int, cfg *LogConfig) (Structure, error) {
+	srand := rand.NewSource(time.Now().UnixNano())
+	r := rand.New(srand)
+	var st Structure
+	var err error
+
+	switch id {
+	case 0: // list
+		if cfg == nil {
+			st = NewListHT() // uses DefaultLogConfig underneath
+		} else {
+			st, err = NewListHTWithConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	case 1: // array
+		if cfg == nil {
+			st = NewArrayHT()
+		} else {
+			st, err = NewArrayHTWithConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	case 2: // avl
+		if cfg == nil {
+			st = NewAVLTreeHT()
+		} else {
+			st, err = NewAVLTreeHTWithConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	case 3: // circbuff
+		if cfg == nil {
+			st = NewCircBuffHT(context.TODO())
+		} else {
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, int(n))
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	case 4: // conctable
+		if cfg == nil {
+			st = NewConcTable(context.TODO())
+		} else {
+			st, err = NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	case 5: // skiplist
+		if cfg == nil {
+			st = NewSkipListHT()
+		} else {
+			st, err = NewSkipListHTWithConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+
+	default:
+		return nil, fmt.Errorf("unknow structure '%d' requested", id)
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var cmd pb.Command
+		if cn := r.Intn(100); cn < wrt {
+			cmd = pb.Command{
+				Id:    i,
+				Key:   strconv.Itoa(r.Intn(dif)),
+				Value: strconv.Itoa(r.Int()),
+				Op:    pb.Command_SET,
+			}
+
+		} else {
+			// only SETS states are needed
+			cmd = pb.Command{
+				Id: i,
+				Op: pb.Command_GET,
+			}
+		}
+		err = st.Log(cmd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// deserializeRawLog emulates the same procedure implemented by a recoverying
+// replica, interpreting the serialized log received from any byte stream.
+func deserializeRawLog(log []byte) ([]pb.Command, error) {
+	rd := bytes.NewReader(log)
+
+	// read the retrieved log interval
+	_, hdr, err := ReadLogHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	ln := hdr.Len
+
+	cmds := make([]pb.Command, 0, ln)
+	for j := 0; j < ln; j++ {
+		var commandLength int32
+		err := binary.Read(rd, binary.BigEndian, &commandLength)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		serializedCmd := make([]byte, commandLength)
+		_, err = rd.Read(serializedCmd)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		c := &pb.Command{}
+		err = proto.Unmarshal(serializedCmd, c)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, *c)
+	}
+
+	var eol string
+	_, err = fmt.Fscanf(rd, "\n%s\n", &eol)
+	if err != nil {
+		return nil, err
+	}
+
+	if eol != "EOL" {
+		return nil, fmt.Errorf("expected EOL flag, got '%s'", eol)
+	}
+	return cmds, nil
+}
+
+// logsAreEquivalent checks if two command log sequences are equivalent with
+// one another. In this context, two logs 'a' and 'b' are considered 'equivalent'
+// iff the deterministic, sequentialy execution of key-value commands on 'b'
+// yields the same final state observed on executing 'a'. Two recovered sequences
+// from beelog can have commands on different orders, which is safe as long as
+// if a log posses a command 'c' on index 'i', no other log records a command 'k'
+// on 'i' where 'k' != 'c'.
+func logsAreEquivalent(logA, logB []pb.Command) bool {
+	// not the same size, directly not equivalent
+	if len(logA) != len(logB) {
+		return false
+	}
+
+	// they are already deeply equal, same values on the same positions
+	if reflect.DeepEqual(logA, logB) {
+		return true
+	}
+
+	// apply each log on a hash table, checking if they have the same
+	// values for the same keys
+	htA := make(map[string]string)
+	htB := make(map[string]string)
+
+	for i := range logA {
+		htA[logA[i].Key] = logA[i].ValueString()
+		htB[logB[i].Key] = logB[i].ValueString()
+	}
+	return reflect.DeepEqual(htA, htB)
+}
+
+// logsAreOnlyDelayed checks wheter two log sequences are only delayed or incoherent
+// with each other. In this context, two 'equivalent' logs 'a' and 'b' are considered
+// 'delayed' with each other if:
+//   (i)  'cA' < 'minB' forall command 'cA' in 'a' AND
+//   (ii) 'cB' < 'minA' forall command 'cB' in 'b'
+//
+// where:
+//   -'minA' is the lowest index in the set of 'advanced' commands of 'a' from 'b'
+//   -'minB' is the lowest index in the set of 'advanced' commands of 'b' from 'a'
+//
+// 'advanced' commands:
+//  Let 'x' and 'y' be log commands, 'x' is considered 'advanced' from 'y' iff:
+//   (i)  'x' and 'y' operate over the same underlying key (i.e. 'x.Key' == 'y.Key') AND
+//   (ii) 'x' has a higher index than 'y' (i.e. 'x.Ind' > 'y.Ind')
+func logsAreOnlyDelayed(logA, logB []pb.Command) bool {
+	htA := make(map[string]uint64)
+	htB := make(map[string]uint64)
+
+	// apply both logs on a hash table
+	for i := range logA {
+		htA[logA[i].Key] = logA[i].Id
+
+		if i < len(logB) {
+			htB[logB[i].Key] = logB[i].Id
+		}
+	}
+
+	// store only the minor inconsistent index
+	var minIndexA, minIndexB uint64
+
+	for kA, indA := range htA {
+		// both logs have the same record index for a key 'kA'
+		if indA == htB[kA] {
+			continue
+		}
+
+		if indA > htB[kA] {
+			// logA has an advance record for 'kA'
+			minIndexA = min(minIndexA, indA)
+
+		} else {
+			// logB has an advance record for 'kA'
+			minIndexB = min(minIndexB, htB[kA])
+		}
+	}
+
+	// iterate over B, if any key has a higher index than 'minorIndexA' then its wrong
+	for _, ind := range htB {
+		if ind > minIndexA {
+			return false
+		}
+	}
+
+	// same for A regarding 'minorIndexB'
+	for _, ind := range htA {
+		if ind > minIndexB {
+			return false
+		}
+	}
+	return true
+}
+
+func cleanAllLogStates() error {
+	// TODO: think about a more restrictive pattern later
+	fs, err := filepath.Glob("./*.log")
+	if err != nil {
+		return err
+	}
+
+	// interval indexes of KeepAll configs
+	idx, err := filepath.Glob("./*.idx")
+	if err != nil {
+		return err
+	}
+	fs = append(fs, idx...)
+
+	for _, f := range fs {
 		err := os.Remove(f)
 		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
-	return nil
+	return nil
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// failingStorage fails the creation of the next 'fails' segments, simulating
+// transient disk errors.
+type failingStorage struct {
+	*memStorage
+	fails int32
+}
+
+func (fs *failingStorage) Create(name string) (Segment, error) {
+	if atomic.AddInt32(&fs.fails, -1) >= 0 {
+		return nil, errors.New("transient storage failure")
+	}
+	return fs.memStorage.Create(name)
+}
+
+func TestStructuresReduceRetry(t *testing.T) {
+	nCmds, wrt, dif := uint64(100), 50, 10
+
+	// only structures persisting on background routines
+	for _, id := range []uint8{3, 4} {
+		fs := &failingStorage{memStorage: newMemStorage(), fails: 2}
+		cfg := &LogConfig{
+			Inmem:   false,
+			Tick:    Interval,
+			Period:  uint32(nCmds),
+			Fname:   "./retry-test.log",
+			Storage: fs,
+			Retry: RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+				MaxBackoff:  2 * time.Millisecond,
+			},
+		}
+		if id == 4 {
+			cfg.Alg = IterConcTable
+		} else {
+			cfg.Alg = IterCircBuff
+		}
+
+		st, err := generateRandStructure(id, nCmds, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		errs := st.(interface{ Err() <-chan error }).Err()
+
+		// transient failures must be recovered by retries
+		select {
+		case err := <-errs:
+			t.Log("unexpected reduce failure on structure", id, ":", err.Error())
+			t.FailNow()
+		case <-time.After(50 * time.Millisecond):
+		}
+		if atomic.LoadInt32(&fs.fails) >= 0 {
+			t.Log("expected every failure to be consumed on structure", id)
+			t.FailNow()
+		}
+
+		// persistent failures must be reported instead
+		atomic.StoreInt32(&fs.fails, 100)
+		for i := uint64(0); i < nCmds; i++ {
+			cmd := pb.Command{Id: nCmds + i, Op: pb.Command_SET, Key: strconv.Itoa(int(i) % dif), Value: "v"}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "transient storage failure") {
+				t.Log("unexpected reported error:", err.Error())
+				t.FailNow()
+			}
+		case <-time.After(time.Second):
+			t.Log("expected a reported reduce failure on structure", id)
+			t.FailNow()
+		}
+	}
+}
+
+func TestStructuresContext(t *testing.T) {
+	nCmds, wrt, dif := uint64(200), 50, 20
+	cfg := &LogConfig{
+		Inmem: true,
+		Tick:  Delayed,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 5; i++ {
+		cfg.Alg = []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}[i]
+		st, err := generateRandStructure(uint8(i), nCmds, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		l := st.Len()
+		cmd := pb.Command{Id: nCmds, Op: pb.Command_SET, Key: "0", Value: "0"}
+		if err := st.LogCtx(ctx, cmd); err != context.Canceled {
+			t.Log("expected a cancelled log on structure", i, ", got:", err)
+			t.FailNow()
+		}
+		if st.Len() != l {
+			t.Log("command was recorded after cancellation on structure", i)
+			t.FailNow()
+		}
+
+		if _, err := st.RecovCtx(ctx, 0, nCmds); err != context.Canceled {
+			t.Log("expected a cancelled recovery on structure", i, ", got:", err)
+			t.FailNow()
+		}
+		if _, err := st.RecovCtx(context.Background(), 0, nCmds); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// a log blocked behind a pending reduce must respect the deadline
+	ct := NewConcTable(context.Background())
+	ct.mu[ct.current].Lock()
+
+	dl, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cmd := pb.Command{Id: 0, Op: pb.Command_SET, Key: "0", Value: "0"}
+	if err := ct.LogCtx(dl, cmd); err != context.DeadlineExceeded {
+		t.Log("expected an exceeded deadline, got:", err)
+		t.FailNow()
+	}
+	ct.mu[ct.current].Unlock()
+
+	if err := ct.Log(cmd); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if ct.Len() != 1 {
+		t.Log("expected a single logged command, got", ct.Len())
+		t.FailNow()
+	}
+}
+
+func TestStructuresJournal(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable}
+	for id, alg := range algs {
+		cfg := &LogConfig{
+			Alg:     alg,
+			Tick:    Delayed,
+			Inmem:   false,
+			Journal: true,
+			Fname:   filepath.Join(t.TempDir(), "logstate.log"),
+		}
+
+		st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		redLog, err := ApplyReduceAlgo(st, alg, 0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// the prior structure is never shutdown, emulating a crash before any reduce
+		// while a command was partially appended
+		fd, err := os.OpenFile(journalFname(cfg.Fname), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		fd.Write([]byte{0, 0, 1})
+		fd.Close()
+
+		reopen := func() (Structure, error) {
+			switch id {
+			case 0:
+				return NewListHTWithConfig(cfg)
+			case 1:
+				return NewArrayHTWithConfig(cfg)
+			case 2:
+				return NewAVLTreeHTWithConfig(cfg)
+			case 3:
+				return NewCircBuffHTWithConfig(context.TODO(), cfg, int(nCmds))
+			default:
+				return NewConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+			}
+		}
+		rst, err := reopen()
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		log, err := rst.Recov(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(redLog, log) {
+			t.Logf("structure '%T' replayed an incoherent journal", rst)
+			t.Log("REDC:", redLog)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+
+		// persisted commands are truncated from the journal
+		cmds, err := readJournal(defaultStorage, journalFname(cfg.Fname))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(cmds) != 0 {
+			t.Logf("structure '%T' retained %d journaled commands after reduce", rst, len(cmds))
+			t.FailNow()
+		}
+		rst.(interface{ Shutdown() }).Shutdown()
+	}
+
+	cfg := &LogConfig{Inmem: true, Journal: true, Tick: Delayed}
+	if err := cfg.ValidateConfig(); err == nil {
+		t.Log("expected an error journaling an inmem config")
+		t.FailNow()
+	}
+}
+
+func TestLSMLogCompaction(t *testing.T) {
+	nCmds, wrt, dif, memSize := uint64(4000), 50, 100, 10
+	cfgs := []*LogConfig{
+		{Inmem: true, Tick: Delayed, Alg: MergeLSM},
+		{Inmem: false, Tick: Delayed, Alg: MergeLSM, Fname: filepath.Join(t.TempDir(), "lsm-test.log")},
+	}
+
+	for i, cfg := range cfgs {
+		lg, err := NewLSMLogWithConfig(context.Background(), cfg, memSize)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avl := NewAVLTreeHT()
+
+		ch := make(chan pb.Command, nCmds+1)
+		go createRandomLog(nCmds, dif, wrt, ch)
+
+		for cmd := range ch {
+			// last command signal
+			if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+				break
+			}
+			if err := lg.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if err := avl.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// first level must be eventually compacted by the background routine
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			lg.mu.RLock()
+			l0, lvls := len(lg.levels[0]), len(lg.levels)
+			lg.mu.RUnlock()
+
+			if l0 < lsmLevelFanout && lvls > 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Log("config", i, ": runs were not compacted,", lg.Str())
+				t.FailNow()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		lsmLog, err := lg.Recov(0, nCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		avlLog, err := ApplyReduceAlgo(avl, GreedyAvl, 0, nCmds)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if !logsAreEquivalent(lsmLog, avlLog) {
+			t.Log("config", i, ": MergeLSM and GreedyAvl presented different results, incoherent")
+			t.FailNow()
+		}
+		lg.Shutdown()
+	}
+}
+
+func TestBTreeHTRestore(t *testing.T) {
+	nCmds, wrt, dif := uint64(6000), 80, 1000
+	path := "./btree-restore"
+	cfg := &LogConfig{
+		Inmem: false,
+		Tick:  Delayed,
+		Alg:   IterBTree,
+		Fname: "./btree-restore.log",
+	}
+
+	bt, err := NewBTreeHTWithConfig(cfg, path, 8)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	avl := NewAVLTreeHT()
+
+	ch := make(chan pb.Command, nCmds+1)
+	go createRandomLog(nCmds, dif, wrt, ch)
+
+	for cmd := range ch {
+		// last command signal
+		if cmd.Id == 0 && cmd.Op == pb.Command_GET && cmd.Key == "" {
+			break
+		}
+
+		// conditional commands must retain their prior state after restore
+		if cmd.Id%7 == 0 && cmd.Op == pb.Command_SET {
+			cmd.Op = pb.Command_CAS
+		}
+		if err := bt.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := avl.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// trailing writes after the random log, until any is recorded after a checkpoint
+	end := nCmds
+	for ; end == nCmds || bt.walCmds == 0; end++ {
+		last := pb.Command{Id: end, Op: pb.Command_SET, Key: "last", Value: strconv.FormatUint(end, 10)}
+		if err := bt.Log(last); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := avl.Log(last); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// emulate a failure, discarding any dirty page not yet checkpointed
+	if bt.walCmds == 0 {
+		t.Log("expected commands recorded on the WAL after the last checkpoint")
+		t.FailNow()
+	}
+	bt.closeFiles()
+
+	bt, err = OpenBTreeHT(cfg, path, 8)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer bt.Shutdown()
+
+	if bt.Len() != avl.Len() {
+		t.Log("restored", bt.Len(), "commands, expected", avl.Len())
+		t.FailNow()
+	}
+
+	// persistent configs stream the reduced log directly from leaves
+	btLog, err := bt.Recov(0, end)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	avlLog, err := ApplyReduceAlgo(avl, GreedyAvl, 0, end)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if !logsAreEquivalent(btLog, avlLog) {
+		t.Log("restored BTreeHT and GreedyAvl presented different results, incoherent")
+		t.FailNow()
+	}
+
+	fs, _ := filepath.Glob(path + ".*")
+	for _, f := range fs {
+		os.Remove(f)
+	}
+}
+
+func TestStructuresRestoreOnInit(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 60
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	cmds := make([]pb.Command, nCmds)
+	for i := range cmds {
+		cmds[i] = <-ch
+	}
+
+	algs := map[uint8]Reducer{0: GreedyLt, 1: GreedyArray, 2: IterDFSAvl, 5: GreedySkip}
+	for _, id := range []uint8{0, 1, 2, 5} {
+		cfg := &LogConfig{
+			Alg:   algs[id],
+			Tick:  Delayed,
+			Fname: t.TempDir() + "/logstate.log",
+		}
+
+		st, err := generateRandStructure(id, 0, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, c := range cmds {
+			if err := st.Log(c); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// persists the reduced state of the entire log
+		exp, err := st.Recov(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		cfg.RestoreOnInit = true
+		rst, err := generateRandStructure(id, 0, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		if st.Len() == 0 || rst.Len() != uint64(len(exp)) {
+			t.Logf("structure '%T' restored %d commands, expected %d", rst, rst.Len(), len(exp))
+			t.FailNow()
+		}
+
+		log, err := rst.Recov(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, log) {
+			t.Logf("structure '%T' restored an incoherent state", rst)
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", log)
+			t.FailNow()
+		}
+	}
+
+	// the restored interval accepts any later command
+	cfg := &LogConfig{
+		Alg:           IterDFSAvl,
+		Tick:          Delayed,
+		Fname:         t.TempDir() + "/logstate.log",
+		RestoreOnInit: true,
+	}
+	av, err := NewAVLTreeHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if av.Len() != 0 {
+		t.Log("expected an empty structure with no persisted state, got", av.Len())
+		t.FailNow()
+	}
+
+	cfg.Inmem = true
+	if _, err := NewAVLTreeHTWithConfig(cfg); err == nil {
+		t.Log("expected an error on an in-memory RestoreOnInit config")
+		t.FailNow()
+	}
+	if _, err := NewCircBuffHTWithConfig(context.TODO(), &LogConfig{Tick: Delayed, Alg: IterCircBuff, Fname: cfg.Fname, RestoreOnInit: true}, 100); err == nil {
+		t.Log("expected an error on a CircBuffHT RestoreOnInit config")
+		t.FailNow()
+	}
+}
+
+func TestStructuresIntervalRecov(t *testing.T) {
+	nCmds, period, keys := 1000, uint32(100), 50
+	ct, err := NewConcTableWithConfig(context.Background(), defaultConcLvl, &LogConfig{
+		KeepAll: true,
+		Alg:     IterConcTable,
+		Tick:    Interval,
+		Period:  period,
+		Fname:   t.TempDir() + "/logstate.log",
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer ct.Shutdown()
+
+	l, err := NewListHTWithConfig(&LogConfig{
+		Alg:    GreedyLt,
+		Tick:   Interval,
+		Period: period,
+		Fname:  t.TempDir() + "/logstate.log",
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	for j := 0; j < nCmds; j++ {
+		cmd := pb.Command{
+			Id:    uint64(j),
+			Op:    pb.Command_SET,
+			Key:   strconv.Itoa(j % keys),
+			Value: strconv.Itoa(j),
+		}
+		if err := ct.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	for ct.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// KeepAll configs merge every segment overlapping the requested interval, each
+	// key retaining its latest state within it
+	for _, in := range [][2]uint64{{0, 999}, {200, 499}, {700, 799}} {
+		log, err := ct.Recov(in[0], in[1])
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if len(log) != keys {
+			t.Log("expected", keys, "states on", in, "got", len(log))
+			t.FailNow()
+		}
+		for _, c := range log {
+			if c.Id < in[1]-uint64(keys)+1 || c.Id > in[1] {
+				t.Log("unexpected state", c, "recovered on", in)
+				t.FailNow()
+			}
+		}
+	}
+
+	// only the latest reduced interval is retained otherwise, trimmed to [p, n]
+	log, err := l.Recov(950, 999)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) != keys {
+		t.Log("expected", keys, "states, got", len(log))
+		t.FailNow()
+	}
+	log, err = l.Recov(0, 899)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(log) != 0 {
+		t.Log("expected no states out of the latest reduced interval, got", len(log))
+		t.FailNow()
+	}
+}
+
+func TestMarshalBufferReuse(t *testing.T) {
+	nCmds, dif, wrt := uint64(5000), 1000, 100
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	log := make([]pb.Command, nCmds)
+	for i := range log {
+		log[i] = <-ch
+	}
+
+	exp := bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(exp, &log, 0, nCmds-1); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	mb := &marshalBuffer{}
+	var prev *byte
+	for i := 0; i < 3; i++ {
+		raw, err := mb.serialize(&log, 0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !bytes.Equal(raw, exp.Bytes()) {
+			t.Log("serialized log differs from 'MarshalLogIntoWriter' output")
+			t.FailNow()
+		}
+
+		// the same underlying array is reused once sized by the first reduce
+		if i > 0 && &raw[0] != prev {
+			t.Log("expected the buffer reused on reduce", i)
+			t.FailNow()
+		}
+		prev = &raw[0]
+	}
+
+	// oversized buffers are released after a much smaller reduce
+	small := log[:10]
+	if _, err := mb.serialize(&small, 0, 9); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := mb.serialize(&small, 0, 9); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if c := mb.buf.Cap(); c > marshalMinRetained {
+		t.Log("expected an oversized buffer to be released, retaining", c, "bytes")
+		t.FailNow()
+	}
+}
+
+func TestMarshalPooledCommands(t *testing.T) {
+	nCmds, dif, wrt := uint64(3000), 500, 100
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	log := make([]pb.Command, nCmds)
+	exp := bytes.NewBuffer(nil)
+	for i := range log {
+		log[i] = <-ch
+
+		// every field and typed payload must match the generated encoding
+		switch i % 5 {
+		case 1:
+			log[i].Typed = &pb.Command_BytesValue{BytesValue: []byte(log[i].Value)}
+			log[i].Value = ""
+		case 2:
+			log[i].Typed = &pb.Command_IntValue{IntValue: -int64(i)}
+		case 3:
+			log[i].Typed = &pb.Command_FloatValue{FloatValue: float64(i) / 3}
+			log[i].Term, log[i].ClientId, log[i].RequestId = uint64(i), "client", uint64(i)<<40
+		case 4:
+			log[i].Op, log[i].EndKey = pb.Command_DELETE_RANGE, "zz"
+			log[i].Expected, log[i].ExpiresAt, log[i].Ip = "exp", int64(i)-1500, "10.0.0.1"
+		}
+
+		// each command follows its int32 encoded size
+		raw, err := proto.Marshal(&log[i])
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		binary.Write(exp, binary.BigEndian, int32(len(raw)))
+		exp.Write(raw)
+	}
+
+	// pooled buffers must never leak content between calls
+	for i := 0; i < 2; i++ {
+		buff := bytes.NewBuffer(nil)
+		if err := marshalCommandsIntoWriter(buff, &log); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !bytes.Equal(buff.Bytes(), exp.Bytes()) {
+			t.Log("pooled serialization differs from per command marshaling")
+			t.FailNow()
+		}
+	}
+}
+
+func BenchmarkMarshalLogIntoWriter(b *testing.B) {
+	nCmds, dif, wrt := uint64(100000), 10000, 100
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	log := make([]pb.Command, nCmds)
+	for i := range log {
+		log[i] = <-ch
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := MarshalLogIntoWriter(ioutil.Discard, &log, 0, nCmds-1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLogDecoder(t *testing.T) {
+	nCmds, dif, wrt := uint64(3000), 500, 100
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	log := make([]pb.Command, nCmds)
+	for i := range log {
+		log[i] = <-ch
+		switch i % 4 {
+		case 1:
+			log[i].Typed = &pb.Command_BytesValue{BytesValue: []byte(log[i].Value)}
+		case 2:
+			log[i].Typed = &pb.Command_IntValue{IntValue: -int64(i)}
+		case 3:
+			log[i].Typed = &pb.Command_FloatValue{FloatValue: float64(i) / 3}
+			log[i].ClientId, log[i].RequestId = "client", uint64(i)<<40
+		}
+	}
+
+	marshalers := map[string]func(io.Writer, *[]pb.Command, uint64, uint64) error{
+		"beelog": MarshalLogIntoWriter,
+		"trad":   MarshalTradLogIntoWriter,
+	}
+	for name, marshal := range marshalers {
+		buff := bytes.NewBuffer(nil)
+		if err := marshal(buff, &log, 0, nCmds-1); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		exp, err := UnmarshalLogFromReader(bytes.NewReader(buff.Bytes()))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// iterated commands reuse the same instance
+		dec, err := NewLogDecoder(bytes.NewReader(buff.Bytes()))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		i := 0
+		for ; dec.Next(); i++ {
+			if i >= len(exp) || !proto.Equal(dec.Command(), &exp[i]) {
+				t.Log(name, "iterated command", i, "differs from the unmarshaled log")
+				t.FailNow()
+			}
+		}
+		if err := dec.Err(); err != nil || i != len(exp) {
+			t.Log(name, "expected", len(exp), "iterated commands, got", i, "err:", err)
+			t.FailNow()
+		}
+
+		// commands decoded into a reused slice must remain valid across logs
+		dst := make([]pb.Command, 0, 10)
+		for j := 0; j < 2; j++ {
+			dst, err = UnmarshalLogInto(bytes.NewReader(buff.Bytes()), dst[:0])
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if len(dst) != len(exp) {
+				t.Log(name, "expected", len(exp), "decoded commands, got", len(dst))
+				t.FailNow()
+			}
+			for k := range dst {
+				if !proto.Equal(&dst[k], &exp[k]) {
+					t.Log(name, "decoded command", k, "differs from the unmarshaled log")
+					t.FailNow()
+				}
+			}
+		}
+	}
+
+	// beelog logs missing commands or the EOL mark must fail
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(buff, &log, 0, nCmds-1); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for _, cut := range []int{5, buff.Len() / 2} {
+		if _, err := UnmarshalLogInto(bytes.NewReader(buff.Bytes()[:buff.Len()-cut]), nil); err == nil {
+			t.Log("expected an error on a log truncated by", cut, "bytes")
+			t.FailNow()
+		}
+	}
+}
+
+func BenchmarkUnmarshalLog(b *testing.B) {
+	nCmds, dif, wrt := uint64(100000), 10000, 100
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	log := make([]pb.Command, nCmds)
+	for i := range log {
+		log[i] = <-ch
+	}
+	buff := bytes.NewBuffer(nil)
+	if err := MarshalLogIntoWriter(buff, &log, 0, nCmds-1); err != nil {
+		b.Fatal(err)
+	}
+	raw := buff.Bytes()
+
+	b.Run("FromReader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := UnmarshalLogFromReader(bytes.NewReader(raw)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Into", func(b *testing.B) {
+		b.ReportAllocs()
+		dst := make([]pb.Command, 0, nCmds)
+		for i := 0; i < b.N; i++ {
+			var err error
+			if dst, err = UnmarshalLogInto(bytes.NewReader(raw), dst[:0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Iterator", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			dec, err := NewLogDecoder(bytes.NewReader(raw))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for dec.Next() {
+			}
+			if err := dec.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestStructuresConcurrentAccessors(t *testing.T) {
+	nCmds, dif, wrt := uint64(5000), 200, 50
+	cfg := LogConfig{
+		Inmem:  true,
+		Tick:   Interval,
+		Period: 500,
+	}
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+
+	for id, alg := range algs {
+		cf := cfg
+		cf.Alg = alg
+
+		// logs the first command, with index zero
+		st, err := generateRandStructure(uint8(id), 1, wrt, dif, &cf)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		done := make(chan struct{})
+		fails := make(chan string, 1)
+		go func() {
+			defer close(fails)
+			var prev uint64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				st.Len()
+				first, last := st.FirstIndex(), st.LastIndex()
+				if last < prev || first > last {
+					fails <- fmt.Sprintf("inconsistent indexes, first: %d, last: %d, prev last: %d", first, last, prev)
+					return
+				}
+				prev = last
+			}
+		}()
+
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for i := uint64(1); i < nCmds; i++ {
+			cmd := pb.Command{Id: i, Op: pb.Command_GET}
+			if r.Intn(100) < wrt {
+				cmd.Op, cmd.Key, cmd.Value = pb.Command_SET, strconv.Itoa(r.Intn(dif)), strconv.Itoa(r.Int())
+			}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		close(done)
+		if msg, ok := <-fails; ok {
+			t.Log("structure", id, msg)
+			t.FailNow()
+		}
+
+		if last := st.LastIndex(); last != nCmds-1 {
+			t.Log("structure", id, "expected last index", nCmds-1, "got", last)
+			t.FailNow()
+		}
+		if sh, ok := st.(interface{ Shutdown() }); ok {
+			sh.Shutdown()
+		}
+	}
+}
+
+func TestStructuresGetKeys(t *testing.T) {
+	nCmds, wrt, dif := uint64(3000), 50, 200
+	dir := t.TempDir()
+	delayed := func(alg Reducer) *LogConfig {
+		return &LogConfig{Inmem: true, Tick: Delayed, Alg: alg}
+	}
+
+	list, _ := NewListHTWithConfig(delayed(GreedyLt))
+	array, _ := NewArrayHTWithConfig(delayed(GreedyArray))
+	avl, _ := NewAVLTreeHTWithConfig(delayed(IterDFSAvl))
+	cb, _ := NewCircBuffHTWithConfig(context.TODO(), delayed(IterCircBuff), int(nCmds))
+	ct, _ := NewConcTableWithConfig(context.TODO(), defaultConcLvl, delayed(IterConcTable))
+	skip, _ := NewSkipListHTWithConfig(delayed(GreedySkip))
+	radix, _ := NewRadixHTWithConfig(delayed(IterRadix))
+	lsm, _ := NewLSMLogWithConfig(context.TODO(), delayed(MergeLSM), 50)
+	bt, err := NewBTreeHTWithConfig(&LogConfig{Tick: Delayed, Alg: IterBTree, Fname: dir + "/bt.log"}, dir+"/bt", 8)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer lsm.Shutdown()
+	defer bt.Shutdown()
+	defer ct.Shutdown()
+
+	structs := []Structure{list, array, avl, cb, ct, skip, radix, lsm, bt}
+	exp := make(map[string]pb.Command)
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		for _, st := range structs {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		if cmd.Op == pb.Command_SET {
+			exp[cmd.Key] = cmd
+		}
+	}
+
+	expKeys := make([]string, 0, len(exp))
+	for k := range exp {
+		expKeys = append(expKeys, k)
+	}
+	sort.Strings(expKeys)
+
+	for i, st := range structs {
+		for k, cmd := range exp {
+			got, ok := st.Get(k)
+			if !ok || !proto.Equal(&got, &cmd) {
+				t.Log("structure", i, "returned a different state for key", k, ", got:", got.String(), "exp:", cmd.String())
+				t.FailNow()
+			}
+		}
+		if _, ok := st.Get("never-written"); ok {
+			t.Log("structure", i, "returned a state for an unknown key")
+			t.FailNow()
+		}
+		if keys := st.Keys(); !reflect.DeepEqual(keys, expKeys) {
+			t.Log("structure", i, "returned", len(keys), "keys, expected", len(expKeys))
+			t.FailNow()
+		}
+	}
+
+	// range deletes hide matching states before any reduce
+	del := pb.Command{Id: nCmds, Op: pb.Command_DELETE_PREFIX, Key: "1"}
+	for i, st := range []Structure{list, array, avl, ct, radix} {
+		if err := st.Log(del); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, k := range st.Keys() {
+			if strings.HasPrefix(k, "1") {
+				t.Log("structure", i, "returned key", k, "deleted by prefix")
+				t.FailNow()
+			}
+			if _, ok := st.Get(k); !ok {
+				t.Log("structure", i, "listed key", k, "without a state")
+				t.FailNow()
+			}
+		}
+		if _, ok := st.Get("1"); ok {
+			t.Log("structure", i, "returned a state deleted by prefix")
+			t.FailNow()
+		}
+	}
+}
+
+func TestStructuresStats(t *testing.T) {
+	nCmds, wrt, dif := uint64(2000), 50, 100
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+
+	for _, keepAll := range []bool{false, true} {
+		for id, alg := range algs {
+			cfg := &LogConfig{
+				Tick:    Interval,
+				Period:  500,
+				Alg:     alg,
+				KeepAll: keepAll,
+				Fname:   t.TempDir() + "/stats.log",
+			}
+			st, err := generateRandStructure(uint8(id), nCmds, wrt, dif, cfg)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			// reduces of CircBuff and ConcTable are asynchronous
+			var stats Stats
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				stats, err = st.(interface{ Stats() (Stats, error) }).Stats()
+				if err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+				if stats.Segments >= 2 || !keepAll && stats.Segments == 1 || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if stats.Commands != nCmds {
+				t.Log("structure", id, "expected", nCmds, "logged commands, got", stats.Commands)
+				t.FailNow()
+			}
+			// every view of ConcTables may already be reduced, retaining no state
+			empty := stats.Keys == 0 || stats.MemBytes == 0
+			if stats.Keys > dif || stats.LastReduce == 0 || empty && alg != IterConcTable {
+				t.Log("structure", id, "reported inconsistent stats:", stats)
+				t.FailNow()
+			}
+
+			if stats.IngestedBytes == 0 || stats.PersistedBytes == 0 || stats.WriteAmplification() == 0 {
+				t.Log("structure", id, "reported no ingested or persisted bytes:", stats)
+				t.FailNow()
+			}
+			if stats.LastReduceOutput == 0 || stats.CompactionRatio() < 1 {
+				t.Log("structure", id, "reported an inconsistent compaction ratio:", stats)
+				t.FailNow()
+			}
+
+			// every reduce of KeepAll configs persists a new segment
+			if stats.Segments < 1 || keepAll && stats.Segments < 2 {
+				t.Log("structure", id, "reported", stats.Segments, "segments, keepAll:", keepAll)
+				t.FailNow()
+			}
+			if sh, ok := st.(interface{ Shutdown() }); ok {
+				sh.Shutdown()
+			}
+		}
+	}
+}
+
+func TestStructuresPinnedKeys(t *testing.T) {
+	dir := t.TempDir()
+	pinned := func(alg Reducer) *LogConfig {
+		return &LogConfig{Inmem: true, Tick: Delayed, Alg: alg, Pinned: PinKeys("members")}
+	}
+
+	list, _ := NewListHTWithConfig(pinned(GreedyLt))
+	array, _ := NewArrayHTWithConfig(pinned(GreedyArray))
+	avl, _ := NewAVLTreeHTWithConfig(pinned(IterDFSAvl))
+	skip, _ := NewSkipListHTWithConfig(pinned(GreedySkip))
+	radix, _ := NewRadixHTWithConfig(pinned(IterRadix))
+	lsm, _ := NewLSMLogWithConfig(context.TODO(), pinned(MergeLSM), 2)
+	bt, err := NewBTreeHTWithConfig(&LogConfig{Tick: Delayed, Alg: IterBTree, Fname: dir + "/bt.log", Pinned: PinKeys("members")}, dir+"/bt", 8)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer lsm.Shutdown()
+	defer bt.Shutdown()
+
+	structs := []Structure{list, array, avl, skip, radix, lsm, bt}
+	log := []pb.Command{
+		{Id: 0, Op: pb.Command_SET, Key: "members", Value: "a"},
+		{Id: 1, Op: pb.Command_SET, Key: "x", Value: "0"},
+		{Id: 2, Op: pb.Command_SET, Key: "members", Value: "a,b"},
+		{Id: 3, Op: pb.Command_SET, Key: "x", Value: "1"},
+		{Id: 4, Op: pb.Command_SET, Key: "members", Value: "b"},
+	}
+
+	for i, st := range structs {
+		for _, cmd := range log {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		cmds, err := st.Recov(0, 4)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// every update of the pinned key is retained, but only the latest of 'x'
+		hist, others := make([]string, 0), 0
+		for _, c := range cmds {
+			if c.Key == "members" {
+				hist = append(hist, c.Value)
+			} else {
+				others++
+			}
+		}
+		if !reflect.DeepEqual(hist, []string{"a", "a,b", "b"}) || others != 1 {
+			t.Log("structure", i, "returned an unexpected reduced log:", cmds)
+			t.FailNow()
+		}
+	}
+
+	// only updates within the requested interval are retained
+	cmds, err := list.Recov(1, 3)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for _, c := range cmds {
+		if c.Key == "members" && c.Id != 2 {
+			t.Log("expected only the pinned update within [1, 3], got", c.String())
+			t.FailNow()
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	nCmds, wrt, dif := uint64(3000), 50, 100
+	radix, _ := NewRadixHTWithConfig(&LogConfig{Inmem: true, Tick: Delayed, Alg: IterRadix})
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	exp := make(map[string]string)
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		if err := radix.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if cmd.Op == pb.Command_SET {
+			exp[cmd.Key] = cmd.Value
+		}
+	}
+
+	// reduced logs of radix trees are ordered by key
+	raw, err := radix.RecovBytes(0, nCmds)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	var last uint64
+	reports := 0
+	got := make(map[string]string)
+	ap := ApplierFunc(func(cmd pb.Command) error {
+		if cmd.Id < last {
+			return fmt.Errorf("command %d applied after %d", cmd.Id, last)
+		}
+		last = cmd.Id
+		got[cmd.Key] = cmd.Value
+		return nil
+	})
+	opts := &ReplayOptions{ProgressEvery: 10, Progress: func(ReplayProgress) { reports++ }}
+
+	if err := ReplayFromReader(bytes.NewReader(raw), ap, opts); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Log("replayed state differs from the original execution")
+		t.FailNow()
+	}
+	if want := (len(exp) + 9) / 10; reports != want {
+		t.Log("expected", want, "progress reports, got", reports)
+		t.FailNow()
+	}
+
+	// replays are interrupted on the first failure
+	fail := errors.New("apply failure")
+	cmds := []pb.Command{{Id: 2, Op: pb.Command_SET}, {Id: 0, Op: pb.Command_SET}, {Id: 1, Op: pb.Command_SET}}
+	applied := 0
+	err = Replay(cmds, ApplierFunc(func(cmd pb.Command) error {
+		if cmd.Id == 1 {
+			return fail
+		}
+		applied++
+		return nil
+	}), nil)
+
+	var rerr *ReplayError
+	if !errors.As(err, &rerr) || !errors.Is(err, fail) || rerr.Id != 1 || rerr.Applied != 1 || applied != 1 {
+		t.Log("expected a replay failure on command 1, got", err)
+		t.FailNow()
+	}
+}
+
+func TestStructuresSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type subscriber interface {
+		Structure
+		Subscribe(buf int) (<-chan SegmentEvent, func())
+		Shutdown()
+	}
+	nCmds, period := uint64(1000), uint32(100)
+
+	for _, inmem := range []bool{true, false} {
+		cfg := LogConfig{
+			Inmem:   inmem,
+			KeepAll: !inmem,
+			Tick:    Interval,
+			Period:  period,
+			Fname:   filepath.Join(t.TempDir(), "logstate.log"),
+		}
+		ctCfg, cbCfg := cfg, cfg
+		ctCfg.Alg, cbCfg.Alg = IterConcTable, IterCircBuff
+		cbCfg.Fname = filepath.Join(t.TempDir(), "logstate.log")
+
+		ct, err := NewConcTableWithConfig(ctx, 2, &ctCfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		cb, err := NewCircBuffHTWithConfig(ctx, &cbCfg, int(2*period))
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		for _, st := range []subscriber{ct, cb} {
+			ch, unsub := st.Subscribe(int(nCmds / uint64(period)))
+			for i := uint64(1); i <= nCmds; i++ {
+				cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i % 10)), Value: "value"}
+				if err := st.Log(cmd); err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+			}
+
+			var last uint64
+			for last < nCmds {
+				select {
+				case ev := <-ch:
+					if ev.Last <= last || ev.Missed != 0 {
+						t.Logf("structure '%T' emitted an unexpected event %d-%d after %d, missing %d", st, ev.First, ev.Last, last, ev.Missed)
+						t.FailNow()
+					}
+					if inmem != (ev.File == "") {
+						t.Logf("structure '%T' emitted file '%s' on inmem config %v", st, ev.File, inmem)
+						t.FailNow()
+					}
+					log, err := deserializeRawLog(ev.Raw)
+					if err != nil {
+						t.Log(err.Error())
+						t.FailNow()
+					}
+					if len(log) == 0 || len(log) > 10 {
+						t.Logf("structure '%T' emitted %d commands, expected at most one per key", st, len(log))
+						t.FailNow()
+					}
+					last = ev.Last
+
+				case <-time.After(5 * time.Second):
+					t.Logf("structure '%T' emitted events only until %d", st, last)
+					t.FailNow()
+				}
+			}
+
+			unsub()
+			if _, ok := <-ch; ok {
+				t.Logf("structure '%T' emitted events after unsubscribe", st)
+				t.FailNow()
+			}
+
+			st.Shutdown()
+			if _, ok := <-func() <-chan SegmentEvent { ch, _ := st.Subscribe(1); return ch }(); ok {
+				t.Logf("structure '%T' accepted a subscription after shutdown", st)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestFollower(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nCmds := 200
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(uint64(nCmds), 10, 50, ch)
+	log := make([]pb.Command, 0, nCmds)
+	for i := 0; i < nCmds; i++ {
+		log = append(log, <-ch)
+	}
+	fn := filepath.Join(t.TempDir(), "trad.log")
+
+	fd, err := os.Create(fn)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer fd.Close()
+
+	// header only, commands are appended after the follower started
+	if err := MarshalTradLogIntoWriter(fd, &[]pb.Command{}, 0, 0); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	fw, err := NewFollower(ctx, fn, time.Millisecond)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	half := log[:nCmds/2]
+	if err := MarshalAndAppendIntoWriter(fd, &half); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// the remaining commands are written in two steps, splitting a frame
+	buf := bytes.NewBuffer(nil)
+	rest := log[nCmds/2:]
+	if err := marshalCommandsIntoWriter(buf, &rest); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	raw := buf.Bytes()
+
+	for i := range log {
+		if i == nCmds/2 {
+			if _, err := fd.Write(raw[:3]); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			time.Sleep(10 * time.Millisecond)
+			if _, err := fd.Write(raw[3:]); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		select {
+		case c, ok := <-fw.Commands():
+			if !ok {
+				t.Log("follower stopped after", i, "commands, err:", fw.Err())
+				t.FailNow()
+			}
+			if !proto.Equal(&c, &log[i]) {
+				t.Logf("expected command %v on position %d, got %v", log[i], i, c)
+				t.FailNow()
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Log("follower decoded only", i, "commands")
+			t.FailNow()
+		}
+	}
+
+	if err := fw.Close(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, ok := <-fw.Commands(); ok || fw.Err() != nil {
+		t.Log("expected a closed follower without errors, got:", fw.Err())
+		t.FailNow()
+	}
+	if sz, _ := fd.Seek(0, io.SeekCurrent); fw.Offset() != sz {
+		t.Logf("expected an offset of %d bytes, got %d", sz, fw.Offset())
+		t.FailNow()
+	}
+
+	// beelog logs are decoded until their EOL mark
+	blFn := filepath.Join(t.TempDir(), "beelog.log")
+	blFd, err := os.Create(blFn)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer blFd.Close()
+	if err := MarshalLogIntoWriter(blFd, &log, 1, uint64(nCmds)); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	fw, err = NewFollower(ctx, blFn, time.Millisecond)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer fw.Close()
+
+	var decoded int
+	for range fw.Commands() {
+		decoded++
+	}
+	if decoded != nCmds || fw.Err() != nil {
+		t.Logf("expected %d commands, got %d, err: %v", nCmds, decoded, fw.Err())
+		t.FailNow()
+	}
+}
+
+func TestCircBuffCheckpoint(t *testing.T) {
+	nCmds, dif, wrt, cap := uint64(220), 20, 70, 1000
+	cfg := LogConfig{
+		Inmem:           true,
+		Tick:            Delayed,
+		Alg:             IterCircBuff,
+		CheckpointFname: filepath.Join(t.TempDir(), "ring.ckpt"),
+		CheckpointEvery: 50,
+	}
+	cb, err := NewCircBuffHTWithConfig(context.TODO(), &cfg, cap)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	// conditional updates retain their prior states on checkpoints
+	log := make([]pb.Command, 0, nCmds)
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		if cmd.Op == pb.Command_SET && i%7 == 0 {
+			cmd.Op, cmd.Expected = pb.Command_CAS, "expected"
+		}
+		log = append(log, cmd)
+		if err := cb.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// emulate a crash, where only checkpointed commands are recovered
+	restoreAndCompare := func(ckpts uint64) {
+		ref := NewListHT()
+		for _, cmd := range log[:ckpts*uint64(cfg.CheckpointEvery)] {
+			ref.Log(cmd)
+		}
+
+		rcb, err := NewCircBuffHTWithConfig(context.TODO(), &cfg, cap)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		defer rcb.Shutdown()
+
+		if rcb.LastIndex() != ref.LastIndex() {
+			t.Logf("expected last index %d after restore, got %d", ref.LastIndex(), rcb.LastIndex())
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(rcb.Keys(), ref.Keys()) {
+			t.Log("restored keys", rcb.Keys(), "differ from", ref.Keys())
+			t.FailNow()
+		}
+
+		exp := GreedyListHT(ref, ref.FirstIndex(), ref.LastIndex())
+		recv, err := rcb.Recov(rcb.FirstIndex(), rcb.LastIndex())
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, recv) {
+			t.Log("restored log differs after", ckpts, "checkpoints")
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", recv)
+			t.FailNow()
+		}
+	}
+	restoreAndCompare(nCmds / uint64(cfg.CheckpointEvery))
+
+	// a partially written checkpoint falls back to the prior one
+	fns := checkpointFnames(cfg.CheckpointFname)
+	raw, err := ioutil.ReadFile(fns[1])
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(fns[1], raw[:len(raw)/2], 0644); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	restoreAndCompare(nCmds/uint64(cfg.CheckpointEvery) - 1)
+
+	// explicit checkpoints capture every logged command
+	if err := cb.Checkpoint(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	cb.Shutdown()
+
+	rcb, err := NewCircBuffHTWithConfig(context.TODO(), &cfg, cap)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer rcb.Shutdown()
+	if rcb.LastIndex() != log[nCmds-1].Id {
+		t.Logf("expected last index %d after an explicit checkpoint, got %d", log[nCmds-1].Id, rcb.LastIndex())
+		t.FailNow()
+	}
+
+	invalid := LogConfig{Inmem: true, Tick: Delayed, CheckpointEvery: 10}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on periodic checkpoints without a CheckpointFname")
+		t.FailNow()
+	}
+}
+
+func TestAVLTreeRemoval(t *testing.T) {
+	nCmds, dif, wrt := uint64(2000), 100, 100
+	cfg := &LogConfig{Inmem: true, Tick: Delayed, Alg: IterDFSAvl}
+	av, err := NewAVLTreeHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	log := make([]pb.Command, 0, nCmds)
+	for i := uint64(0); i < nCmds; i++ {
+		cmd := <-ch
+		log = append(log, cmd)
+		if err := av.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	// validates ordering, heights and balance of every node, returning their count
+	var checkTree func(nd *avlTreeEntry, lo, hi uint64) (uint64, int)
+	checkTree = func(nd *avlTreeEntry, lo, hi uint64) (uint64, int) {
+		if nd == nil {
+			return 0, 0
+		}
+		if nd.ind < lo || nd.ind > hi {
+			t.Log("node", nd.ind, "out of its subtree bounds", lo, hi)
+			t.FailNow()
+		}
+		ln, lh := checkTree(nd.left, lo, nd.ind-1)
+		rn, rh := checkTree(nd.right, nd.ind+1, hi)
+		if h := max(lh, rh) + 1; nd.height != h || lh-rh > 1 || rh-lh > 1 {
+			t.Log("node", nd.ind, "has height", nd.height, "over unbalanced subtrees of height", lh, rh)
+			t.FailNow()
+		}
+		return ln + rn + 1, nd.height
+	}
+
+	compare := func(retired map[string]bool, p uint64) {
+		n, _ := checkTree(av.root, 0, ^uint64(0))
+		ref := NewListHT()
+		for _, cmd := range log {
+			if !retired[cmd.Key] && cmd.Id >= p {
+				ref.Log(cmd)
+			}
+		}
+		if n != av.Len() || n != ref.Len() {
+			t.Logf("expected %d nodes, tree has %d and informs %d", ref.Len(), n, av.Len())
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(av.Keys(), ref.Keys()) {
+			t.Log("tree keys", av.Keys(), "differ from", ref.Keys())
+			t.FailNow()
+		}
+
+		exp := GreedyListHT(ref, p, nCmds-1)
+		recv, err := av.Recov(p, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, recv) {
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", recv)
+			t.FailNow()
+		}
+	}
+
+	retired := make(map[string]bool)
+	for i := 0; i < dif/2; i++ {
+		key := strconv.Itoa(i)
+		if l, ok := (*av.aux)[key]; ok && av.RetireKey(key) != int(l.len) {
+			t.Log("expected", l.len, "retired nodes of key", key)
+			t.FailNow()
+		}
+		retired[key] = true
+	}
+	compare(retired, 0)
+
+	if av.Prune(nCmds/2) == 0 {
+		t.Log("expected nodes removed by prune")
+		t.FailNow()
+	}
+	if av.FirstIndex() < nCmds/2 {
+		t.Log("expected a first index after", nCmds/2, "got", av.FirstIndex())
+		t.FailNow()
+	}
+	compare(retired, nCmds/2)
+
+	// nodes are still inserted on a pruned tree
+	av.Prune(nCmds)
+	if av.Len() != 0 || av.FirstIndex() != 0 {
+		t.Log("expected an empty tree, got", av.Len(), "nodes")
+		t.FailNow()
+	}
+	if err := av.Log(pb.Command{Id: nCmds, Op: pb.Command_SET, Key: "key", Value: "value"}); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if cmd, ok := av.Get("key"); !ok || cmd.Id != nCmds || av.FirstIndex() != nCmds {
+		t.Log("expected the latest inserted state, got", cmd)
+		t.FailNow()
+	}
+}
+
+func TestStructuresTruncateAfterReduce(t *testing.T) {
+	nCmds, dif, wrt, period := uint64(1050), 50, 50, uint32(100)
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	log := make([]pb.Command, 0, nCmds)
+	for i := uint64(0); i < nCmds; i++ {
+		log = append(log, <-ch)
+	}
+
+	// latest write of each key until the last reduced index
+	last := nCmds - nCmds%uint64(period) - 1
+	latest := make(map[string]pb.Command)
+	for _, cmd := range log[:last+1] {
+		if cmd.Op == pb.Command_SET {
+			latest[cmd.Key] = cmd
+		}
+	}
+	exp := make([]pb.Command, 0, len(latest))
+	for _, cmd := range latest {
+		exp = append(exp, cmd)
+	}
+
+	tests := []struct {
+		id  uint8
+		alg Reducer
+	}{
+		{0, GreedyLt},
+		{1, GreedyArray},
+		{2, IterDFSAvl},
+	}
+	for _, tc := range tests {
+		cfg := &LogConfig{
+			Alg:                 tc.alg,
+			Tick:                Interval,
+			Period:              period,
+			KeepAll:             true,
+			Fname:               filepath.Join(t.TempDir(), "logstate.log"),
+			TruncateAfterReduce: true,
+		}
+		st, err := generateRandStructure(tc.id, 0, wrt, dif, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, cmd := range log {
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+
+		// only entries logged after the last reduce are retained
+		if st.Len() >= uint64(period) {
+			t.Logf("structure '%T' retained %d entries after truncation", st, st.Len())
+			t.FailNow()
+		}
+		if st.Len() > 0 && st.FirstIndex() <= last {
+			t.Logf("structure '%T' retained first index %d after truncation", st, st.FirstIndex())
+			t.FailNow()
+		}
+
+		recv, err := st.Recov(0, last)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, recv) {
+			t.Logf("structure '%T' recovered a different log after truncation", st)
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", recv)
+			t.FailNow()
+		}
+	}
+
+	invalid := LogConfig{Inmem: true, Tick: Interval, Period: 10, TruncateAfterReduce: true}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on truncation over inmem configs")
+		t.FailNow()
+	}
+}
+
+func TestArrayHTCompaction(t *testing.T) {
+	nCmds, dif, wrt, period := uint64(20000), 100, 50, uint32(100)
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+
+	cfg := &LogConfig{
+		Alg:                 GreedyArray,
+		Tick:                Interval,
+		Period:              period,
+		KeepAll:             true,
+		Fname:               filepath.Join(t.TempDir(), "logstate.log"),
+		TruncateAfterReduce: true,
+	}
+	ar, err := NewArrayHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	// the backing array is reused under sustained load, never grown
+	base := &(*ar.arr)[:1][0]
+	for i := uint64(0); i < nCmds; i++ {
+		if err := ar.Log(<-ch); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if c := cap(*ar.arr); c != arrayCapacity(cfg) || &(*ar.arr)[:1][0] != base {
+		t.Logf("expected the initial backing array of capacity %d, got one of %d", arrayCapacity(cfg), c)
+		t.FailNow()
+	}
+	for _, ent := range (*ar.arr)[len(*ar.arr):cap(*ar.arr)] {
+		if ent.ptr != nil {
+			t.Log("stale entry retained after compaction:", ent)
+			t.FailNow()
+		}
+	}
+
+	// arrays grown by a burst are reallocated once drained
+	for i := 0; i < 5*arrayCapacity(cfg); i++ {
+		*ar.arr = append(*ar.arr, listEntry{ind: nCmds + uint64(i)})
+	}
+	ar.compact(len(*ar.arr) - 10)
+	if l, c := len(*ar.arr), cap(*ar.arr); l != 10 || c != arrayCapacity(cfg) || (*ar.arr)[0].ind != nCmds+uint64(5*arrayCapacity(cfg)-10) {
+		t.Logf("expected 10 retained entries on capacity %d, got %d on capacity %d", arrayCapacity(cfg), l, c)
+		t.FailNow()
+	}
+}
+
+func BenchmarkArrayHTSustainedLoad(b *testing.B) {
+	nCmds, dif, wrt := uint64(100000), 1000, 50
+	ch := make(chan pb.Command, nCmds+1)
+	createRandomLog(nCmds, dif, wrt, ch)
+	log := make([]pb.Command, nCmds)
+	for i := range log {
+		log[i] = <-ch
+	}
+
+	for _, truncate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("truncate=%t", truncate), func(b *testing.B) {
+			cfg := &LogConfig{
+				Alg:                 GreedyArray,
+				Tick:                Interval,
+				Period:              1000,
+				KeepAll:             true,
+				Fname:               filepath.Join(b.TempDir(), "logstate.log"),
+				TruncateAfterReduce: truncate,
+			}
+			ar, err := NewArrayHTWithConfig(cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cmd := log[i%len(log)]
+				cmd.Id = uint64(i)
+				if err := ar.Log(cmd); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			var ms runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&ms)
+			b.ReportMetric(float64(cap(*ar.arr)), "entries-cap")
+			b.ReportMetric(float64(ms.HeapInuse), "heap-B")
+		})
+	}
+}
+
+func TestRecovBounds(t *testing.T) {
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+	newStructure := func(id int, bp BoundsPolicy) Structure {
+		cfg := DefaultLogConfig()
+		cfg.Alg = algs[id]
+		cfg.RecovBounds = bp
+
+		var st Structure
+		var err error
+		if algs[id] == IterCircBuff {
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, 100)
+		} else {
+			st, err = generateRandStructure(uint8(id), 0, 50, 10, cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		return st
+	}
+
+	for id := range algs {
+		// an empty structure has no logged interval
+		st := newStructure(id, StrictBounds)
+		var ie *IntervalError
+		if _, err := st.Recov(0, 10); !errors.As(err, &ie) || !ie.Empty {
+			t.Logf("expected an empty interval error on '%T', got: %v", st, err)
+			t.FailNow()
+		}
+
+		sts := make(map[BoundsPolicy]Structure)
+		for _, bp := range []BoundsPolicy{BestEffortBounds, StrictBounds, ClampBounds} {
+			sts[bp] = newStructure(id, bp)
+			for i := uint64(10); i < 60; i++ {
+				cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i % 7)), Value: strconv.Itoa(int(i))}
+				if err := sts[bp].Log(cmd); err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+			}
+		}
+
+		logged := IndexInterval{First: 10, Last: 59}
+		if in, ok := sts[StrictBounds].(interface {
+			LoggedInterval() (IndexInterval, bool)
+		}).LoggedInterval(); !ok || in != logged {
+			t.Logf("expected logged interval %v on '%T', got %v", logged, st, in)
+			t.FailNow()
+		}
+
+		testCases := []struct {
+			bp     BoundsPolicy
+			p, n   uint64
+			reject bool
+		}{
+			{BestEffortBounds, 100, 200, false},
+			{StrictBounds, 10, 59, false},
+			{StrictBounds, 0, 30, true},
+			{StrictBounds, 30, 60, true},
+			{ClampBounds, 0, 100, false},
+			{ClampBounds, 0, 9, true},
+			{ClampBounds, 60, 100, true},
+		}
+		for _, tc := range testCases {
+			_, err := sts[tc.bp].Recov(tc.p, tc.n)
+			if tc.reject != (err != nil) {
+				t.Logf("unexpected result recovering [%d, %d] with policy %d on '%T', err: %v", tc.p, tc.n, tc.bp, st, err)
+				t.FailNow()
+			}
+			if tc.reject && (!errors.As(err, &ie) || ie.Logged != logged) {
+				t.Logf("expected an interval error reporting %v, got: %v", logged, err)
+				t.FailNow()
+			}
+		}
+
+		// clamped requests recover the entire logged interval
+		exp, err := sts[StrictBounds].Recov(10, 59)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		recv, err := sts[ClampBounds].Recov(0, 100)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !logsAreEquivalent(exp, recv) {
+			t.Logf("clamped recovery on '%T' differs from the logged interval one", st)
+			t.Log("EXPC:", exp)
+			t.Log("RECV:", recv)
+			t.FailNow()
+		}
+	}
+
+	cfg := DefaultLogConfig()
+	cfg.Alg, cfg.RecovBounds, cfg.Shards = IterConcTable, StrictBounds, 2
+	sh, err := NewShardedConcTableWithConfig(context.TODO(), defaultConcLvl, cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for i := uint64(10); i < 60; i++ {
+		if err := sh.Log(pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i))}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if _, err := sh.Recov(10, 59); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := sh.Recov(5, 59); err == nil {
+		t.Log("expected an interval error on sharded tables")
+		t.FailNow()
+	}
+
+	invalid := DefaultLogConfig()
+	invalid.RecovBounds = ClampBounds + 1
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on unknown bounds policy")
+		t.FailNow()
+	}
+}
+
+func TestRecovWithResult(t *testing.T) {
+	nCmds := uint64(250)
+	logCmds := func(st Structure) {
+		for i := uint64(0); i < nCmds; i++ {
+			cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i % 20)), Value: strconv.Itoa(int(i))}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+	}
+
+	// scheduled reduces only cover the latest reduced interval, [0, 199]
+	for id, alg := range []Reducer{GreedyLt, GreedyArray, IterDFSAvl, 5: GreedySkip} {
+		if id == 3 || id == 4 {
+			continue
+		}
+		cfg := &LogConfig{Inmem: true, Alg: alg, Tick: Interval, Period: 100}
+		st, err := generateRandStructure(uint8(id), 0, 50, 10, cfg)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		logCmds(st)
+		rr := st.(ResultRecoverer)
+
+		res, err := rr.RecovWithResult(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !res.Reduced || res.First != 0 || res.Last != 199 {
+			t.Logf("expected '%T' to cover [0, 199], got %+v", st, res)
+			t.FailNow()
+		}
+		for _, cmd := range res.Cmds {
+			if cmd.Id > res.Last {
+				t.Logf("command %d recovered past the covered interval on '%T'", cmd.Id, st)
+				t.FailNow()
+			}
+		}
+
+		res, err = rr.RecovWithResult(50, 120)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !res.Reduced || res.First != 50 || res.Last != 120 {
+			t.Logf("expected '%T' to cover [50, 120], got %+v", st, res)
+			t.FailNow()
+		}
+
+		// commands logged after the latest reduce are not covered
+		res, err = rr.RecovWithResult(220, 240)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if res.Reduced || len(res.Cmds) > 0 || res.First != 220 || res.Last != 240 {
+			t.Logf("expected '%T' to cover nothing on [220, 240], got %+v", st, res)
+			t.FailNow()
+		}
+
+		res, err = rr.RecovBytesWithResult(0, nCmds-1)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !res.Reduced || res.First != 0 || res.Last != 199 || len(res.Raw) == 0 {
+			t.Logf("expected '%T' serialized log to cover [0, 199], got [%d, %d]", st, res.First, res.Last)
+			t.FailNow()
+		}
+	}
+
+	// delayed reduces cover the requested interval, up to the last logged index
+	for id, alg := range []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip} {
+		cfg := DefaultLogConfig()
+		cfg.Alg = alg
+
+		var st Structure
+		var err error
+		if alg == IterCircBuff {
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, int(2*nCmds))
+		} else {
+			st, err = generateRandStructure(uint8(id), 0, 50, 10, cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		logCmds(st)
+
+		res, err := st.(ResultRecoverer).RecovWithResult(0, 1000)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if !res.Reduced || res.First != 0 || res.Last != nCmds-1 || len(res.Cmds) != 20 {
+			t.Logf("expected '%T' to cover [0, %d] with 20 states, got [%d, %d] with %d", st, nCmds-1, res.First, res.Last, len(res.Cmds))
+			t.FailNow()
+		}
+	}
+}
+
+func TestReadLogging(t *testing.T) {
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+	testCases := []struct {
+		mode                 ReadLogging
+		first, last, counted uint64
+	}{
+		{IndexReads, 10, 13, 4},
+		{IgnoreReads, 12, 12, 1},
+	}
+
+	for _, tc := range testCases {
+		for id := range algs {
+			cfg := DefaultLogConfig()
+			cfg.Alg = algs[id]
+			cfg.Reads = tc.mode
+
+			var st Structure
+			var err error
+			if algs[id] == IterCircBuff {
+				st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, 100)
+			} else {
+				st, err = generateRandStructure(uint8(id), 0, 50, 10, cfg)
+			}
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			// reads precede the first write of an empty structure
+			cmds := []pb.Command{
+				{Id: 10, Op: pb.Command_GET, Key: "a"},
+				{Id: 11, Op: pb.Command_GET, Key: "b"},
+				{Id: 12, Op: pb.Command_SET, Key: "a", Value: "12"},
+				{Id: 13, Op: pb.Command_GET, Key: "a"},
+			}
+			for _, c := range cmds {
+				if err := st.Log(c); err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+			}
+
+			if st.FirstIndex() != tc.first || st.LastIndex() != tc.last {
+				t.Logf("expected interval [%d, %d] on '%T' with mode %d, got [%d, %d]",
+					tc.first, tc.last, st, tc.mode, st.FirstIndex(), st.LastIndex())
+				t.FailNow()
+			}
+
+			stats, err := st.(interface{ Stats() (Stats, error) }).Stats()
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if stats.Commands != tc.counted {
+				t.Logf("expected %d commands accounted on '%T' with mode %d, got %d", tc.counted, st, tc.mode, stats.Commands)
+				t.FailNow()
+			}
+
+			log, err := st.Recov(tc.first, tc.last)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if len(log) != 1 || log[0].Id != 12 {
+				t.Logf("expected only the write on '%T' with mode %d, got %v", st, tc.mode, log)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestCommandOrdering(t *testing.T) {
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+	set := func(id uint64) pb.Command {
+		return pb.Command{Id: id, Op: pb.Command_SET, Key: strconv.Itoa(int(id)), Value: "v"}
+	}
+	testCases := []struct {
+		policy OrderPolicy
+		window int
+		ids    []uint64
+		errs   []bool
+		last   uint64
+		keys   map[uint64]string
+	}{
+		{RejectOutOfOrder, 0, []uint64{1, 2, 3, 2, 5, 4}, []bool{false, false, false, true, false, true}, 5,
+			map[uint64]string{1: "1", 2: "2", 3: "3", 5: "5"}},
+		{OverwriteOutOfOrder, 0, []uint64{1, 2, 3, 2}, []bool{false, false, false, false}, 4,
+			map[uint64]string{1: "1", 3: "3", 4: "2"}},
+		{ReorderBuffer, 2, []uint64{1, 3, 4, 2, 4, 6, 7, 8}, []bool{false, false, false, false, true, false, false, false}, 8,
+			map[uint64]string{1: "1", 2: "2", 3: "3", 4: "4", 6: "6", 7: "7", 8: "8"}},
+	}
+
+	for _, tc := range testCases {
+		for id := range algs {
+			cfg := DefaultLogConfig()
+			cfg.Alg = algs[id]
+			cfg.Ordering = tc.policy
+			cfg.ReorderWindow = tc.window
+
+			var st Structure
+			var err error
+			if algs[id] == IterCircBuff {
+				st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, 100)
+			} else {
+				st, err = generateRandStructure(uint8(id), 0, 50, 10, cfg)
+			}
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			for i, ind := range tc.ids {
+				err := st.Log(set(ind))
+				if (err == ErrOutOfOrder) != tc.errs[i] || (err != nil && err != ErrOutOfOrder) {
+					t.Logf("unexpected result logging id %d on '%T' with policy %d, got: %v", ind, st, tc.policy, err)
+					t.FailNow()
+				}
+			}
+
+			if st.LastIndex() != tc.last {
+				t.Logf("expected last index %d on '%T' with policy %d, got %d", tc.last, st, tc.policy, st.LastIndex())
+				t.FailNow()
+			}
+			log, err := st.Recov(0, tc.last)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			// overwritten commands are recorded on their new index
+			got := make(map[uint64]string, len(log))
+			for _, c := range log {
+				got[c.Id] = c.Key
+			}
+			if !reflect.DeepEqual(got, tc.keys) {
+				t.Logf("expected commands %v on '%T' with policy %d, got %v", tc.keys, st, tc.policy, got)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestHoles(t *testing.T) {
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+	type holeReporter interface {
+		Structure
+		Holes() []IndexInterval
+	}
+
+	sts := make([]holeReporter, 0, len(algs)+1)
+	for id := range algs {
+		cfg := DefaultLogConfig()
+		cfg.Alg = algs[id]
+
+		var st Structure
+		var err error
+		if algs[id] == IterCircBuff {
+			st, err = NewCircBuffHTWithConfig(context.TODO(), cfg, 100)
+		} else {
+			st, err = generateRandStructure(uint8(id), 0, 50, 10, cfg)
+		}
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		sts = append(sts, st.(holeReporter))
+	}
+	sh, err := NewShardedConcTableWithConfig(context.TODO(), defaultConcLvl, DefaultLogConfig())
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	sts = append(sts, sh)
+
+	steps := []struct {
+		ind   uint64
+		holes []IndexInterval
+	}{
+		{5, nil},
+		{6, nil},
+		{9, []IndexInterval{{7, 8}}},
+		{12, []IndexInterval{{7, 8}, {10, 11}}},
+		{7, []IndexInterval{{8, 8}, {10, 11}}},
+		{2, []IndexInterval{{3, 4}, {8, 8}, {10, 11}}},
+		{11, []IndexInterval{{3, 4}, {8, 8}, {10, 10}}},
+		{8, []IndexInterval{{3, 4}, {10, 10}}},
+	}
+	for _, st := range sts {
+		for _, s := range steps {
+			cmd := pb.Command{Id: s.ind, Op: pb.Command_SET, Key: strconv.Itoa(int(s.ind)), Value: "v"}
+			if err := st.Log(cmd); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+			if got := st.Holes(); !reflect.DeepEqual(got, s.holes) {
+				t.Logf("expected holes %v on '%T' after index %d, got %v", s.holes, st, s.ind, got)
+				t.FailNow()
+			}
+		}
+	}
 }
 
-func min(a, b uint64) uint64 {
-	if a < b {
-		return a
+func TestRecovSnapshotAndDelta(t *testing.T) {
+	algs := []Reducer{GreedyLt, GreedyArray, IterDFSAvl, IterCircBuff, IterConcTable, GreedySkip}
+	nCmds := uint64(25)
+
+	for _, id := range []int{0, 1, 2, 5} {
+		for _, tick := range []ReduceInterval{Delayed, Interval} {
+			cfg := DefaultLogConfig()
+			cfg.Alg = algs[id]
+			cfg.Tick = tick
+			cfg.Period = 10
+
+			st, err := generateRandStructure(uint8(id), 0, 50, 10, cfg)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			// expected state is the latest write of each key
+			expected := make(map[string]string)
+			for i := uint64(1); i <= nCmds; i++ {
+				cmd := pb.Command{Id: i, Op: pb.Command_SET, Key: strconv.Itoa(int(i % 4)), Value: strconv.Itoa(int(i))}
+				if i%6 == 0 {
+					cmd = pb.Command{Id: i, Op: pb.Command_GET, Key: cmd.Key}
+				} else {
+					expected[cmd.Key] = cmd.Value
+				}
+				if err := st.Log(cmd); err != nil {
+					t.Log(err.Error())
+					t.FailNow()
+				}
+			}
+
+			sd, err := st.(DeltaRecoverer).RecovSnapshotAndDelta(1, nCmds)
+			if err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+
+			snapLast := nCmds
+			if tick == Interval {
+				snapLast = 20
+			}
+			if !sd.Snapshot.Reduced || sd.Snapshot.Last != snapLast || sd.Last != nCmds {
+				t.Logf("expected snapshot up to %d and delta up to %d on '%T' with tick %d, got %d and %d",
+					snapLast, nCmds, st, tick, sd.Snapshot.Last, sd.Last)
+				t.FailNow()
+			}
+
+			// delta carries every write after the snapshot, on index order
+			var writes []uint64
+			for i := snapLast + 1; i <= nCmds; i++ {
+				if i%6 != 0 {
+					writes = append(writes, i)
+				}
+			}
+			if len(sd.Delta) != len(writes) {
+				t.Logf("expected delta commands %v on '%T' with tick %d, got %v", writes, st, tick, sd.Delta)
+				t.FailNow()
+			}
+			for i, c := range sd.Delta {
+				if c.Id != writes[i] {
+					t.Logf("expected delta commands %v on '%T' with tick %d, got %v", writes, st, tick, sd.Delta)
+					t.FailNow()
+				}
+			}
+
+			got := make(map[string]string)
+			for _, c := range append(sd.Snapshot.Cmds, sd.Delta...) {
+				got[c.Key] = c.Value
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Logf("expected state %v on '%T' with tick %d, got %v", expected, st, tick, got)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestDiskQuota(t *testing.T) {
+	nCmds, period, quota := 200, uint32(10), int64(600)
+	var evicted []string
+	cfg := &LogConfig{
+		Alg:                 GreedyLt,
+		Tick:                Interval,
+		Period:              period,
+		KeepAll:             true,
+		Fname:               filepath.Join(t.TempDir(), "logstate.log"),
+		TruncateAfterReduce: true,
+		MaxDiskBytes:        quota,
+		Hooks: &Hooks{
+			OnEvict: func(fn string, bytes int64) { evicted = append(evicted, fn) },
+		},
+	}
+	l, err := NewListHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	for i := 0; i < nCmds; i++ {
+		cmd := pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 5), Value: strconv.Itoa(i)}
+		if i == 3 {
+			// a key never written again retains the oldest segment
+			cmd.Key = "unique"
+		}
+		if err := l.Log(cmd); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+
+	segs, err := l.idx.overlapping(l.storage(), 0, ^uint64(0))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	var total int64
+	for _, sg := range segs {
+		sz, err := l.storage().Size(sg.Name)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		total += sz
+	}
+	if total > quota || len(evicted) == 0 {
+		t.Log("expected segments within", quota, "bytes after evictions, got", total, "bytes and", len(evicted), "evictions")
+		t.FailNow()
+	}
+	if len(segs)+len(evicted) != nCmds/int(period) {
+		t.Log("expected", nCmds/int(period), "persisted segments, got", len(segs), "indexed and", len(evicted), "evicted")
+		t.FailNow()
+	}
+
+	for _, fn := range evicted {
+		if _, err := os.Stat(fn); !os.IsNotExist(err) {
+			t.Log("expected evicted segment", fn, "to be removed")
+			t.FailNow()
+		}
+	}
+	retained := make(map[uint64]bool)
+	for _, sg := range segs {
+		retained[sg.Last] = true
+	}
+	if !retained[uint64(period)-1] || !retained[uint64(nCmds)-1] {
+		t.Log("expected the oldest and newest segments to be retained, got", segs)
+		t.FailNow()
+	}
+
+	// the latest state of every key must survive evictions
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Last < segs[j].Last })
+	got := make(map[string]string)
+	for _, sg := range segs {
+		log, _, err := l.readPersistedSegment(sg.Name)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		for _, c := range log {
+			got[c.Key] = c.Value
+		}
+	}
+	exp := map[string]string{"unique": "3"}
+	for i := nCmds - 5; i < nCmds; i++ {
+		exp[strconv.Itoa(i%5)] = strconv.Itoa(i)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Log("expected", exp, "recovered after evictions, got", got)
+		t.FailNow()
+	}
+
+	invalid := LogConfig{Inmem: true, Tick: Interval, Period: 10, MaxDiskBytes: quota}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on disk quotas over inmem configs")
+		t.FailNow()
+	}
+}
+
+func TestWriteRate(t *testing.T) {
+	rate := int64(1 << 20)
+	wl := newWriteLimiter(rate)
+
+	// the first second worth of bytes is immediately available, pacing the remaining
+	start := time.Now()
+	lw := &limitedWriter{w: ioutil.Discard, wl: wl}
+	if n, err := lw.Write(make([]byte, rate+rate/2)); err != nil || int64(n) != rate+rate/2 {
+		t.Log("failed writing through limiter, wrote", n, "bytes, err:", err)
+		t.FailNow()
+	}
+	if el := time.Since(start); el < 400*time.Millisecond {
+		t.Log("expected writes paced by the limiter, took", el)
+		t.FailNow()
+	}
+
+	cfg := &LogConfig{
+		Alg:       GreedyLt,
+		Tick:      Interval,
+		Period:    10,
+		Fname:     filepath.Join(t.TempDir(), "logstate.log"),
+		WriteRate: rate,
+	}
+	l, err := NewListHTWithConfig(cfg)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for i := 0; i < 20; i++ {
+		if err := l.Log(pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i), Value: strconv.Itoa(i)}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if _, err := l.Recov(10, 19); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	invalid := LogConfig{Inmem: true, Tick: Interval, Period: 10, WriteRate: rate}
+	if err := invalid.ValidateConfig(); err == nil {
+		t.Log("expected an error on write rates over inmem configs")
+		t.FailNow()
+	}
+}
+
+func TestLoadLogConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"beelog.toml": `
+Inmem = false
+KeepAll = true
+Fname = "/tmp/logstate.log"
+tick = 2
+period = 1000
+Codec = 1
+Naming = 1
+GCInterval = "1m"
+RetainSegments = 4
+MaxDiskBytes = 1048576
+WriteRate = 4096
+Fnames = ["/tmp/logstate.log", "/tmp/second.log"]
+
+[Retry]
+MaxAttempts = 3
+Backoff = "10ms"
+`,
+		"beelog.yaml": `
+inmem: false
+keepall: true
+fname: /tmp/logstate.log
+tick: 2
+period: 1000
+codec: 1
+naming: 1
+gcinterval: 1m
+retainsegments: 4
+maxdiskbytes: 1048576
+writerate: 4096
+fnames: [/tmp/logstate.log, /tmp/second.log]
+retry:
+  maxattempts: 3
+  backoff: 10000000
+`,
+	}
+
+	for name, content := range files {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		cfg, err := LoadLogConfig(fn)
+		if err != nil {
+			t.Log(name, err.Error())
+			t.FailNow()
+		}
+		exp := &LogConfig{
+			KeepAll:        true,
+			Fname:          "/tmp/logstate.log",
+			Tick:           Interval,
+			Period:         1000,
+			Codec:          FlatCodec,
+			Naming:         IntervalNaming,
+			GCInterval:     time.Minute,
+			RetainSegments: 4,
+			MaxDiskBytes:   1 << 20,
+			WriteRate:      4096,
+			Fnames:         []string{"/tmp/logstate.log", "/tmp/second.log"},
+			Retry:          RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond},
+		}
+		if !reflect.DeepEqual(cfg, exp) {
+			t.Log(name, ": expected config", exp, "got", cfg)
+			t.FailNow()
+		}
+	}
+
+	// environment variables override loaded fields
+	fn := filepath.Join(dir, "beelog.toml")
+	t.Setenv("BEELOG_PERIOD", "20")
+	t.Setenv("BEELOG_RETRY_MAXATTEMPTS", "5")
+	t.Setenv("BEELOG_FNAMES", "/tmp/logstate.log, /tmp/third.log")
+	cfg, err := LoadLogConfig(fn)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if cfg.Period != 20 || cfg.Retry.MaxAttempts != 5 || cfg.Fnames[1] != "/tmp/third.log" {
+		t.Log("expected overridden fields, got", cfg)
+		t.FailNow()
+	}
+
+	// loaded configs are validated, and unknown or unloadable fields rejected
+	t.Setenv("BEELOG_PERIOD", "0")
+	if _, err := LoadLogConfig(fn); err == nil {
+		t.Log("expected an error on an invalid config")
+		t.FailNow()
+	}
+	invalid := map[string]string{
+		"unknown.toml": "Periodd = 10",
+		"storage.toml": `Storage = "/tmp"`,
+		"period.yml":   "period: -1",
+		"config.json":  "{}",
+	}
+	for name, content := range invalid {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if _, err := LoadLogConfig(fn); err == nil {
+			t.Log(name, ": expected an error loading the config")
+			t.FailNow()
+		}
+	}
+}
+
+func TestStructuresSetConfig(t *testing.T) {
+	ctx := context.Background()
+	structs := []struct {
+		alg   Reducer
+		build func(cfg *LogConfig) (Structure, error)
+	}{
+		{GreedyLt, func(cfg *LogConfig) (Structure, error) { return NewListHTWithConfig(cfg) }},
+		{GreedyArray, func(cfg *LogConfig) (Structure, error) { return NewArrayHTWithConfig(cfg) }},
+		{IterDFSAvl, func(cfg *LogConfig) (Structure, error) { return NewAVLTreeHTWithConfig(cfg) }},
+		{GreedySkip, func(cfg *LogConfig) (Structure, error) { return NewSkipListHTWithConfig(cfg) }},
+		{IterRadix, func(cfg *LogConfig) (Structure, error) { return NewRadixHTWithConfig(cfg) }},
+		{IterCircBuff, func(cfg *LogConfig) (Structure, error) { return NewCircBuffHTWithConfig(ctx, cfg, 1000) }},
+		{IterConcTable, func(cfg *LogConfig) (Structure, error) { return NewConcTableWithConfig(ctx, 2, cfg) }},
+		{IterConcTable, func(cfg *LogConfig) (Structure, error) {
+			cfg.Shards = 2
+			return NewShardedConcTableWithConfig(ctx, 2, cfg)
+		}},
+	}
+
+	// waits every reduce pending on background routines
+	wait := func(st Structure) {
+		tables := []Structure{st}
+		if sh, ok := st.(*ShardedConcTable); ok {
+			tables = tables[:0]
+			for _, ct := range sh.shards {
+				tables = append(tables, ct)
+			}
+		}
+		for _, tb := range tables {
+			if pd, ok := tb.(interface{ Pending() int }); ok {
+				for pd.Pending() > 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+	}
+
+	// informs if a state persisted on any file matching 'pattern' holds 'key'
+	persisted := func(pattern, key string) bool {
+		fs, _ := filepath.Glob(pattern)
+		for _, fn := range fs {
+			raw, err := ioutil.ReadFile(fn)
+			if err != nil {
+				continue
+			}
+			cmds, err := UnmarshalLogFromBytes(raw)
+			if err != nil {
+				continue
+			}
+			for _, c := range cmds {
+				if c.Key == key {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, s := range structs {
+		dir := t.TempDir()
+		st, err := s.build(&LogConfig{Alg: s.alg, Tick: Interval, Period: 10, Fname: filepath.Join(dir, "a.log")})
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		rc, ok := st.(Reconfigurable)
+		if !ok {
+			t.Logf("structure '%T' is not reconfigurable", st)
+			t.FailNow()
+		}
+
+		for i := 0; i < 20; i++ {
+			if err := st.Log(pb.Command{Id: uint64(i), Op: pb.Command_SET, Key: strconv.Itoa(i % 5), Value: strconv.Itoa(i)}); err != nil {
+				t.Log(err.Error())
+				t.FailNow()
+			}
+		}
+		wait(st)
+
+		// the persisted state is migrated into the updated path
+		err = rc.UpdateConfig(func(cfg *LogConfig) {
+			cfg.Fname = filepath.Join(dir, "b.log")
+			cfg.Period = 5
+		})
+		if err != nil {
+			t.Logf("%T: %s", st, err.Error())
+			t.FailNow()
+		}
+		if !persisted(filepath.Join(dir, "b*.log"), "0") {
+			t.Logf("structure '%T' didnt migrate its persisted state", st)
+			t.FailNow()
+		}
+
+		// reduces are scheduled by the ticker routine once updated into TimeInterval
+		err = rc.UpdateConfig(func(cfg *LogConfig) {
+			cfg.Tick, cfg.Duration = TimeInterval, 10*time.Millisecond
+		})
+		if err != nil {
+			t.Logf("%T: %s", st, err.Error())
+			t.FailNow()
+		}
+		if err := st.Log(pb.Command{Id: 20, Op: pb.Command_SET, Key: "x", Value: "20"}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		time.Sleep(100 * time.Millisecond)
+		wait(st)
+		if !persisted(filepath.Join(dir, "b*.log"), "x") {
+			t.Logf("structure '%T' didnt reduce after the updated duration", st)
+			t.FailNow()
+		}
+
+		// only the schedule, Sync and output paths can be updated
+		if err := rc.UpdateConfig(func(cfg *LogConfig) { cfg.KeepAll = true }); err == nil {
+			t.Logf("structure '%T' updated a fixed config field", st)
+			t.FailNow()
+		}
+		if err := rc.SetConfig(&LogConfig{Alg: s.alg, Tick: Interval}); err == nil {
+			t.Logf("structure '%T' updated into an invalid config", st)
+			t.FailNow()
+		}
+		if err := rc.UpdateConfig(func(cfg *LogConfig) { cfg.Sync = true }); err != nil {
+			t.Logf("%T: %s", st, err.Error())
+			t.FailNow()
+		}
+		st.(interface{ Shutdown() }).Shutdown()
+	}
+
+	// output paths of KeepAll configs are bound to their manifest
+	l, err := NewListHTWithConfig(&LogConfig{Alg: GreedyLt, Tick: Delayed, KeepAll: true, Fname: filepath.Join(t.TempDir(), "a.log")})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	defer l.Shutdown()
+	if err := l.UpdateConfig(func(cfg *LogConfig) { cfg.Fname += ".new" }); err == nil {
+		t.Log("expected an error updating the output path of a KeepAll config")
+		t.FailNow()
+	}
+}
+
+func TestStructuresSetConfigConcurrent(t *testing.T) {
+	ctx := context.Background()
+	nCmds, writers, updates := 400, 2, 20
+	builds := []func(cfg *LogConfig) (Structure, error){
+		func(cfg *LogConfig) (Structure, error) {
+			cfg.Alg = GreedyLt
+			return NewListHTWithConfig(cfg)
+		},
+		func(cfg *LogConfig) (Structure, error) {
+			cfg.Alg = IterCircBuff
+			return NewCircBuffHTWithConfig(ctx, cfg, 2*nCmds*writers)
+		},
+		func(cfg *LogConfig) (Structure, error) {
+			cfg.Alg = IterConcTable
+			return NewConcTableWithConfig(ctx, 2, cfg)
+		},
+	}
+
+	for _, build := range builds {
+		st, err := build(&LogConfig{Inmem: true, Tick: Delayed})
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		rc := st.(Reconfigurable)
+		if err := st.Log(pb.Command{Id: 0, Op: pb.Command_SET, Key: "0", Value: "0"}); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+
+		// configs are updated while concurrently logged and recovered, reduces only
+		// executed by recoveries
+		var id uint64
+		var wg sync.WaitGroup
+		errs := make(chan error, writers+2)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < nCmds; i++ {
+					ind := atomic.AddUint64(&id, 1)
+					if err := st.Log(pb.Command{Id: ind, Op: pb.Command_SET, Key: strconv.Itoa(i % 10), Value: strconv.Itoa(i)}); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				if _, err := st.Recov(0, atomic.LoadUint64(&id)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := rc.UpdateConfig(func(cfg *LogConfig) {
+					cfg.Period, cfg.Sync = uint32(5+i%2*5), i%2 == 0
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			t.Logf("%T: %s", st, err.Error())
+			t.FailNow()
+		}
+		st.(interface{ Shutdown() }).Shutdown()
+	}
+
+	// ticker routines relaunched by config updates are bound to the structure, so
+	// none is left running after shutdown
+	dir := t.TempDir()
+	l, err := NewListHTWithConfig(&LogConfig{Alg: GreedyLt, Tick: Delayed, Fname: filepath.Join(dir, "a.log")})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	l.Shutdown()
+	err = l.UpdateConfig(func(cfg *LogConfig) {
+		cfg.Tick, cfg.Duration = TimeInterval, time.Millisecond
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := l.Log(pb.Command{Id: 0, Op: pb.Command_SET, Key: "x", Value: "0"}); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if fs, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(fs) > 0 {
+		t.Log("expected no reduce scheduled after shutdown, got", fs)
+		t.FailNow()
 	}
-	return b
 }
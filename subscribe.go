@@ -0,0 +1,120 @@
+package beelog
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// SegmentEvent describes a reduced log state produced by a structure, emitted to every
+// subscriber once it's persisted (or retained in memory, on Inmem configs).
+type SegmentEvent struct {
+	// First and Last are the indexes of the reduced interval
+	First, Last uint64
+
+	// segment file the state was persisted into, empty on Inmem configs
+	File string
+
+	// serialized reduced log, on the same format returned by 'RecovBytes'
+	Raw []byte
+
+	// number of events missed by the subscriber since its previous event, discarded
+	// while its channel was full. Any missed event requires a full recovery (e.g.
+	// RecovEntireLog) to restore a consistent state
+	Missed uint64
+}
+
+// segmentSub is a single subscriber of a segmentFeed.
+type segmentSub struct {
+	ch     chan SegmentEvent
+	missed uint64
+}
+
+// segmentFeed fans out every reduced segment of a structure to its subscribers.
+// Events are never blocked by slow subscribers, which instead observe the number of
+// discarded events on the next delivered one.
+type segmentFeed struct {
+	mu     sync.Mutex
+	subs   map[*segmentSub]struct{}
+	closed bool
+}
+
+func newSegmentFeed() *segmentFeed {
+	return &segmentFeed{subs: make(map[*segmentSub]struct{})}
+}
+
+// subscribe registers a new subscriber with a channel of 'buf' capacity, returning
+// the channel and a function that unregisters and closes it.
+func (f *segmentFeed) subscribe(buf int) (<-chan SegmentEvent, func()) {
+	if buf < 0 {
+		buf = 0
+	}
+	sub := &segmentSub{ch: make(chan SegmentEvent, buf)}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	f.subs[sub] = struct{}{}
+
+	return sub.ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[sub]; ok {
+			delete(f.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// active informs if any subscriber is registered, avoiding the serialization of
+// reduced logs without subscribers.
+func (f *segmentFeed) active() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs) > 0
+}
+
+// publish emits 'ev' to every subscriber, without blocking.
+func (f *segmentFeed) publish(ev SegmentEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		e := ev
+		e.Missed = sub.missed
+		select {
+		case sub.ch <- e:
+			sub.missed = 0
+		default:
+			sub.missed++
+		}
+	}
+}
+
+// close closes every subscriber channel, rejecting new subscriptions.
+func (f *segmentFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		close(sub.ch)
+		delete(f.subs, sub)
+	}
+	f.closed = true
+}
+
+// publishSegment serializes the reduced log 'lg' of [p, n], persisted on 'fn', and
+// emits it to every subscriber of the structure.
+func (ld *logData) publishSegment(fn string, lg []pb.Command, p, n uint64) error {
+	if ld.feed == nil || !ld.feed.active() {
+		return nil
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := MarshalLogWithCodec(buf, ld.config.Codec, &lg, p, n); err != nil {
+		return err
+	}
+	ld.feed.publish(SegmentEvent{First: p, Last: n, File: fn, Raw: buf.Bytes()})
+	return nil
+}
@@ -0,0 +1,73 @@
+package beelog
+
+import "runtime"
+
+// reduceThrottle bounds the CPU consumed by reduces executed on background routines
+// of ConcTable and CircBuffHT structures on MaxReduces or ReduceYield configs, so
+// huge view scans dont compete with the Log path of callers on small machines.
+type reduceThrottle struct {
+	sem   chan struct{} // nil if the number of concurrent reduces is unbounded
+	yield int
+}
+
+// newReduceThrottle returns the reduce throttle configured by 'cfg', or nil if none
+// was configured.
+func newReduceThrottle(cfg *LogConfig) *reduceThrottle {
+	if cfg.MaxReduces <= 0 && cfg.ReduceYield <= 0 {
+		return nil
+	}
+	rt := &reduceThrottle{yield: cfg.ReduceYield}
+	if cfg.MaxReduces > 0 {
+		rt.sem = make(chan struct{}, cfg.MaxReduces)
+	}
+	return rt
+}
+
+// acquire blocks until a new reduce computation is allowed to run. Safe to be called
+// on nil throttles.
+func (rt *reduceThrottle) acquire() {
+	if rt != nil && rt.sem != nil {
+		rt.sem <- struct{}{}
+	}
+}
+
+// release finishes a reduce computation started by 'acquire'.
+func (rt *reduceThrottle) release() {
+	if rt != nil && rt.sem != nil {
+		<-rt.sem
+	}
+}
+
+// workers returns the number of goroutines a single reduce may spawn, out of 'w',
+// never exceeding the number of concurrent reduces.
+func (rt *reduceThrottle) workers(w int) int {
+	if rt != nil && rt.sem != nil && w > cap(rt.sem) {
+		return cap(rt.sem)
+	}
+	return w
+}
+
+// yielder returns a new yielder for a single scan, safe to be called on nil throttles.
+func (rt *reduceThrottle) yielder() *yielder {
+	if rt == nil {
+		return nil
+	}
+	return &yielder{every: rt.yield}
+}
+
+// yielder yields the processor every 'every' visited entries of a scan.
+type yielder struct {
+	every, n int
+}
+
+// tick counts a visited entry, yielding the processor once 'every' entries are
+// visited. Safe to be called on nil yielders.
+func (y *yielder) tick() {
+	if y == nil || y.every <= 0 {
+		return
+	}
+	if y.n++; y.n >= y.every {
+		y.n = 0
+		runtime.Gosched()
+	}
+}
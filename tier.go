@@ -0,0 +1,209 @@
+package beelog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tieredStorage combines the hot and cold storages of ColdStorage configs. New segments
+// (and the manifest) are always written on the hot storage, while reads transparently
+// fall back to the cold one for segments already migrated. Segments are migrated
+// asynchronously by 'mayMigrateSegments', keeping only the latest config.HotSegments
+// ones on the hot storage.
+type tieredStorage struct {
+	hot, cold LogStorage
+
+	mu sync.Mutex // serializes migrations and removals of segments
+
+	running bool // if a migration routine is launched, guarded by 'mu'
+	pending bool // if a new migration pass was requested while running, guarded by 'mu'
+	wg      sync.WaitGroup
+}
+
+func newTieredStorage(hot, cold LogStorage) *tieredStorage {
+	return &tieredStorage{hot: hot, cold: cold}
+}
+
+// Create ...
+func (ts *tieredStorage) Create(name string) (Segment, error) {
+	return ts.hot.Create(name)
+}
+
+// Append ...
+func (ts *tieredStorage) Append(name string) (Segment, error) {
+	return ts.hot.Append(name)
+}
+
+// ReadAt ...
+func (ts *tieredStorage) ReadAt(name string) (SegmentReader, error) {
+	rd, err := ts.hot.ReadAt(name)
+	if err == nil {
+		return rd, nil
+	}
+	if rd, cerr := ts.cold.ReadAt(name); cerr == nil {
+		return rd, nil
+	}
+	return nil, err
+}
+
+// List ...
+func (ts *tieredStorage) List(pattern string) ([]string, error) {
+	hot, err := ts.hot.List(pattern)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := ts.cold.List(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// a segment may be listed on both while migrated
+	seen := make(map[string]struct{}, len(hot))
+	for _, fn := range hot {
+		seen[fn] = struct{}{}
+	}
+	for _, fn := range cold {
+		if _, ok := seen[fn]; !ok {
+			hot = append(hot, fn)
+		}
+	}
+	return hot, nil
+}
+
+// Delete ...
+func (ts *tieredStorage) Delete(name string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	herr := ts.hot.Delete(name)
+	if _, err := ts.cold.Size(name); err != nil {
+		return herr
+	}
+	return ts.cold.Delete(name)
+}
+
+// Size ...
+func (ts *tieredStorage) Size(name string) (int64, error) {
+	sz, err := ts.hot.Size(name)
+	if err == nil {
+		return sz, nil
+	}
+	if sz, cerr := ts.cold.Size(name); cerr == nil {
+		return sz, nil
+	}
+	return 0, err
+}
+
+// ModTime ...
+func (ts *tieredStorage) ModTime(name string) (time.Time, error) {
+	st := ts.hot
+	if _, err := ts.hot.Size(name); err != nil {
+		st = ts.cold
+	}
+	mt, ok := st.(ModTimeStorage)
+	if !ok {
+		return time.Time{}, fmt.Errorf("storage of segment '%s' is unable to inform modification times", name)
+	}
+	return mt.ModTime(name)
+}
+
+// mayMigrateSegments launches a migration of every segment older than the latest
+// config.HotSegments ones into the cold storage, if not already running. A request
+// issued during a migration triggers another pass once it finishes. Failures are
+// reported through 'Err()' calls.
+func (ld *logData) mayMigrateSegments() {
+	ts := ld.tier
+	if ts == nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.running {
+		ts.pending = true
+		return
+	}
+	ts.running = true
+	ts.wg.Add(1)
+
+	go func() {
+		defer ts.wg.Done()
+		for {
+			if err := ld.migrateSegments(); err != nil {
+				ld.errs.report(fmt.Errorf("failed migrating segments to cold storage, err: %w", err))
+			}
+
+			ts.mu.Lock()
+			if !ts.pending {
+				ts.running = false
+				ts.mu.Unlock()
+				return
+			}
+			ts.pending = false
+			ts.mu.Unlock()
+		}
+	}()
+}
+
+// migrateSegments copies every segment older than the latest config.HotSegments ones,
+// still on the hot storage, into the cold storage, then removes its hot copy. Segments
+// concurrently removed (e.g. by CollectSegments) are skipped.
+func (ld *logData) migrateSegments() error {
+	ts := ld.tier
+	segs, err := ld.idx.overlapping(ts, 0, ^uint64(0))
+	if err != nil {
+		return err
+	}
+	if len(segs) <= ld.config.HotSegments {
+		return nil
+	}
+
+	// newest segments first, retained on the hot storage
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].Last > segs[j].Last })
+	for _, sg := range segs[ld.config.HotSegments:] {
+		if err := ts.migrate(sg.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate moves segment 'fn' from the hot into the cold storage, if still on the hot
+// one. The hot copy is only removed once the cold one is synced, so concurrent reads
+// always find either copy.
+func (ts *tieredStorage) migrate(fn string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	rd, err := ts.hot.ReadAt(fn)
+	if err != nil {
+		// already migrated or removed
+		return nil
+	}
+	defer rd.Close()
+
+	seg, err := ts.cold.Create(fn)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(seg, newSegmentCursor(rd)); err != nil {
+		seg.Close()
+		return fmt.Errorf("failed copying segment '%s', err: '%s'", fn, err.Error())
+	}
+	if err = seg.Sync(); err != nil {
+		seg.Close()
+		return err
+	}
+	if err = seg.Close(); err != nil {
+		return err
+	}
+	return ts.hot.Delete(fn)
+}
+
+// waitMigrations blocks until every launched migration finishes.
+func (ts *tieredStorage) waitMigrations() {
+	ts.wg.Wait()
+}
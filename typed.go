@@ -0,0 +1,219 @@
+package beelog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// KeyCodec converts typed keys into the string keys of logged commands, and back.
+// Distinct keys must be encoded into distinct strings.
+type KeyCodec[K comparable] interface {
+	EncodeKey(key K) string
+	DecodeKey(key string) (K, error)
+}
+
+// ValueCodec writes typed values into the payload of logged commands, and reads them
+// back, possibly utilizing the Typed payload to avoid string conversions.
+type ValueCodec[V any] interface {
+	EncodeValue(cmd *pb.Command, val V)
+	DecodeValue(cmd *pb.Command) (V, error)
+}
+
+// StringCodec encodes string keys and values as is.
+type StringCodec struct{}
+
+// EncodeKey ...
+func (StringCodec) EncodeKey(key string) string { return key }
+
+// DecodeKey ...
+func (StringCodec) DecodeKey(key string) (string, error) { return key, nil }
+
+// EncodeValue ...
+func (StringCodec) EncodeValue(cmd *pb.Command, val string) { cmd.Value = val }
+
+// DecodeValue ...
+func (StringCodec) DecodeValue(cmd *pb.Command) (string, error) { return cmd.ValueString(), nil }
+
+// IntCodec encodes int64 keys as their decimal representation, and int64 values as
+// the IntValue payload. Encoded keys are not ordered numerically (e.g. on Keys).
+type IntCodec struct{}
+
+// EncodeKey ...
+func (IntCodec) EncodeKey(key int64) string { return strconv.FormatInt(key, 10) }
+
+// DecodeKey ...
+func (IntCodec) DecodeKey(key string) (int64, error) { return strconv.ParseInt(key, 10, 64) }
+
+// EncodeValue ...
+func (IntCodec) EncodeValue(cmd *pb.Command, val int64) {
+	cmd.Typed = &pb.Command_IntValue{IntValue: val}
+}
+
+// DecodeValue ...
+func (IntCodec) DecodeValue(cmd *pb.Command) (int64, error) {
+	if v, ok := cmd.Typed.(*pb.Command_IntValue); ok {
+		return v.IntValue, nil
+	}
+	return strconv.ParseInt(cmd.Value, 10, 64)
+}
+
+// FloatCodec encodes float64 values as the FloatValue payload.
+type FloatCodec struct{}
+
+// EncodeValue ...
+func (FloatCodec) EncodeValue(cmd *pb.Command, val float64) {
+	cmd.Typed = &pb.Command_FloatValue{FloatValue: val}
+}
+
+// DecodeValue ...
+func (FloatCodec) DecodeValue(cmd *pb.Command) (float64, error) {
+	if v, ok := cmd.Typed.(*pb.Command_FloatValue); ok {
+		return v.FloatValue, nil
+	}
+	return strconv.ParseFloat(cmd.Value, 64)
+}
+
+// BytesCodec encodes binary keys as strings, and binary values as the BytesValue
+// payload. Fixed-size keys (e.g. UUIDs) are better represented by arrays on a custom
+// KeyCodec, since slices are not comparable.
+type BytesCodec struct{}
+
+// EncodeValue ...
+func (BytesCodec) EncodeValue(cmd *pb.Command, val []byte) {
+	cmd.Typed = &pb.Command_BytesValue{BytesValue: val}
+}
+
+// DecodeValue ...
+func (BytesCodec) DecodeValue(cmd *pb.Command) ([]byte, error) {
+	return cmd.ValueBytes(), nil
+}
+
+// Entry is a typed command recovered from a TypedStructure. Value is only set on
+// write operations, and EndKey on DELETE_RANGE commands with a bounded range.
+// Deletes logged through TypedStructure are recovered as DELETE entries.
+type Entry[K comparable, V any] struct {
+	Id     uint64
+	Op     pb.Command_Operation
+	Key    K
+	EndKey K
+	Value  V
+}
+
+// TypedStructure is the typed counterpart of Structure, logging and recovering keys
+// of type K and values of type V instead of raw commands.
+type TypedStructure[K comparable, V any] interface {
+	Set(ctx context.Context, id uint64, key K, val V) error
+	Delete(ctx context.Context, id uint64, key K) error
+	Get(key K) (V, bool, error)
+	Keys() ([]K, error)
+	Recov(p, n uint64) ([]Entry[K, V], error)
+	Untyped() Structure
+}
+
+// Typed adapts any Structure into a TypedStructure, converting keys and values to
+// and from logged commands through the provided codecs. Commands are still logged,
+// reduced and serialized as pb.Command, retaining compatibility with every format
+// and tool over the untyped structure.
+type Typed[K comparable, V any] struct {
+	s    Structure
+	keys KeyCodec[K]
+	vals ValueCodec[V]
+}
+
+// NewTyped returns a TypedStructure over 's', encoding keys through 'keys' and values
+// through 'vals'.
+func NewTyped[K comparable, V any](s Structure, keys KeyCodec[K], vals ValueCodec[V]) *Typed[K, V] {
+	return &Typed[K, V]{s: s, keys: keys, vals: vals}
+}
+
+// Set logs a SET of 'key' to 'val' on index 'id'.
+func (tp *Typed[K, V]) Set(ctx context.Context, id uint64, key K, val V) error {
+	cmd := pb.Command{Id: id, Op: pb.Command_SET, Key: tp.keys.EncodeKey(key)}
+	tp.vals.EncodeValue(&cmd, val)
+	return tp.s.LogCtx(ctx, cmd)
+}
+
+// Delete removes 'key' on index 'id'. Since single key DELETE commands are not
+// interpreted by reduce procedures, it's logged as a DELETE_RANGE covering only the
+// encoded key, which is recovered as a DELETE entry.
+func (tp *Typed[K, V]) Delete(ctx context.Context, id uint64, key K) error {
+	k := tp.keys.EncodeKey(key)
+	return tp.s.LogCtx(ctx, pb.Command{Id: id, Op: pb.Command_DELETE_RANGE, Key: k, EndKey: k + "\x00"})
+}
+
+// Get returns the latest value logged for 'key', following the same semantics as
+// Structure's 'Get'.
+func (tp *Typed[K, V]) Get(key K) (V, bool, error) {
+	var val V
+	cmd, ok := tp.s.Get(tp.keys.EncodeKey(key))
+	if !ok {
+		return val, false, nil
+	}
+	val, err := tp.vals.DecodeValue(&cmd)
+	if err != nil {
+		return val, false, err
+	}
+	return val, true, nil
+}
+
+// Keys returns every key with a visible state on the structure, ordered by their
+// encoded representation.
+func (tp *Typed[K, V]) Keys() ([]K, error) {
+	raw := tp.s.Keys()
+	keys := make([]K, 0, len(raw))
+	for _, k := range raw {
+		key, err := tp.keys.DecodeKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode key '%s': %s", k, err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Recov returns the typed entries of the compacted log recovered from [p, n],
+// following the same semantics as Structure's 'Recov'. Other range deletes are
+// returned with their prefix or bounds decoded as keys, failing if not decodable.
+func (tp *Typed[K, V]) Recov(p, n uint64) ([]Entry[K, V], error) {
+	cmds, err := tp.s.Recov(p, n)
+	if err != nil {
+		return nil, err
+	}
+
+	ents := make([]Entry[K, V], 0, len(cmds))
+	for i := range cmds {
+		ent, err := tp.decodeEntry(&cmds[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not decode command %d: %s", cmds[i].Id, err.Error())
+		}
+		ents = append(ents, ent)
+	}
+	return ents, nil
+}
+
+// Untyped returns the underlying structure, allowing access to the raw command API.
+func (tp *Typed[K, V]) Untyped() Structure {
+	return tp.s
+}
+
+func (tp *Typed[K, V]) decodeEntry(cmd *pb.Command) (Entry[K, V], error) {
+	ent := Entry[K, V]{Id: cmd.Id, Op: cmd.Op}
+	var err error
+	if ent.Key, err = tp.keys.DecodeKey(cmd.Key); err != nil {
+		return ent, err
+	}
+	if cmd.Op == pb.Command_DELETE_RANGE && cmd.EndKey == cmd.Key+"\x00" {
+		ent.Op = pb.Command_DELETE
+	} else if cmd.Op == pb.Command_DELETE_RANGE && cmd.EndKey != "" {
+		if ent.EndKey, err = tp.keys.DecodeKey(cmd.EndKey); err != nil {
+			return ent, err
+		}
+	}
+	if isWriteOp(cmd.Op) {
+		ent.Value, err = tp.vals.DecodeValue(cmd)
+	}
+	return ent, err
+}
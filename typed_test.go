@@ -0,0 +1,87 @@
+package beelog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+var _ TypedStructure[int64, float64] = (*Typed[int64, float64])(nil)
+
+func TestTypedStructure(t *testing.T) {
+	ctx := context.Background()
+	list, err := NewListHTWithConfig(&LogConfig{Inmem: true, Tick: Delayed, Alg: GreedyLt})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	tp := NewTyped[int64, float64](list, IntCodec{}, FloatCodec{})
+
+	for i, key := range []int64{10, 2, 10, 7} {
+		if err := tp.Set(ctx, uint64(i), key, float64(i)/2); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+	}
+	if err := tp.Delete(ctx, 4, 7); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	if val, ok, err := tp.Get(10); err != nil || !ok || val != 1 {
+		t.Log("expected key 10 to hold 1, got", val, ok, err)
+		t.FailNow()
+	}
+	if _, ok, _ := tp.Get(7); ok {
+		t.Log("expected key 7 to be deleted")
+		t.FailNow()
+	}
+	keys, err := tp.Keys()
+	if err != nil || !reflect.DeepEqual(keys, []int64{10, 2}) {
+		t.Log("unexpected keys:", keys, err)
+		t.FailNow()
+	}
+
+	ents, err := tp.Recov(0, 4)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	exp := map[int64]Entry[int64, float64]{
+		2:  {Id: 1, Op: pb.Command_SET, Key: 2, Value: 0.5},
+		10: {Id: 2, Op: pb.Command_SET, Key: 10, Value: 1},
+		7:  {Id: 4, Op: pb.Command_DELETE, Key: 7},
+	}
+	if len(ents) != len(exp) {
+		t.Log("expected", len(exp), "reduced entries, got", ents)
+		t.FailNow()
+	}
+	for _, e := range ents {
+		if e != exp[e.Key] {
+			t.Log("unexpected reduced entry:", e)
+			t.FailNow()
+		}
+	}
+
+	// typed payloads are retained on the untyped API
+	cmd, _ := tp.Untyped().Get("2")
+	if v, ok := cmd.Typed.(*pb.Command_FloatValue); !ok || v.FloatValue != 0.5 {
+		t.Log("expected a float payload, got", cmd)
+		t.FailNow()
+	}
+
+	if _, err := NewTyped[int64, string](list, IntCodec{}, StringCodec{}).Recov(0, 4); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if err := list.Log(pb.Command{Id: 5, Op: pb.Command_SET, Key: "x", Value: "y"}); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := tp.Keys(); err == nil {
+		t.Log("expected an error decoding a non-numeric key")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,93 @@
+package beelog
+
+import (
+	"sort"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Divergence is a key whose final state differs between two executions, where a nil
+// state represents an absent key.
+type Divergence struct {
+	Key      string
+	Original *pb.Command
+	Reduced  *pb.Command
+}
+
+// VerifyResult reports the outcome of VerifyReducedLog, listing every divergent key
+// on ascending order.
+type VerifyResult struct {
+	Equivalent  bool
+	Divergences []Divergence
+}
+
+// VerifyReducedLog executes both 'original' and 'reduced' command sequences, each on
+// a fresh in-memory key-value model, and reports if they yield the same final state.
+// Commands are applied on their index order, since reduced logs may record commands
+// on any order (e.g. by key). The model applies SETs, DELETEs, range deletes and CASs,
+// which only succeed if the current value of their key (empty if absent) equals
+// 'Expected'. Reads are ignored, and states are compared by their values.
+func VerifyReducedLog(original, reduced []pb.Command) VerifyResult {
+	a, b := executeLog(original), executeLog(reduced)
+	res := VerifyResult{Divergences: make([]Divergence, 0)}
+
+	for k, ca := range a {
+		cb, ok := b[k]
+		if !ok || ca.ValueString() != cb.ValueString() {
+			d := Divergence{Key: k, Original: ca}
+			if ok {
+				d.Reduced = cb
+			}
+			res.Divergences = append(res.Divergences, d)
+		}
+	}
+	for k, cb := range b {
+		if _, ok := a[k]; !ok {
+			res.Divergences = append(res.Divergences, Divergence{Key: k, Reduced: cb})
+		}
+	}
+
+	sort.Slice(res.Divergences, func(i, j int) bool {
+		return res.Divergences[i].Key < res.Divergences[j].Key
+	})
+	res.Equivalent = len(res.Divergences) == 0
+	return res
+}
+
+// executeLog applies 'log' on an empty key-value model, returning the command that
+// defined the final state of each key.
+func executeLog(log []pb.Command) map[string]*pb.Command {
+	cmds := make([]*pb.Command, len(log))
+	for i := range log {
+		cmds[i] = &log[i]
+	}
+	sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].Id < cmds[j].Id })
+
+	kv := make(map[string]*pb.Command)
+	for _, c := range cmds {
+		switch c.Op {
+		case pb.Command_SET:
+			kv[c.Key] = c
+
+		case pb.Command_CAS:
+			var cur string
+			if st, ok := kv[c.Key]; ok {
+				cur = st.ValueString()
+			}
+			if cur == c.Expected {
+				kv[c.Key] = c
+			}
+
+		case pb.Command_DELETE:
+			delete(kv, c.Key)
+
+		case pb.Command_DELETE_PREFIX, pb.Command_DELETE_RANGE:
+			for k := range kv {
+				if coversKey(c, k) {
+					delete(kv, k)
+				}
+			}
+		}
+	}
+	return kv
+}
@@ -0,0 +1,77 @@
+package beelog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// retainsHistory informs if reduced logs retain more than the latest state of each
+// key, either through 'VersionsToKeep' or 'CompactWindow'.
+func (lc *LogConfig) retainsHistory() bool {
+	return lc.VersionsToKeep > 1 || lc.CompactWindow > 0
+}
+
+// checkHistorySupported returns an error if 'cfg' retains multiple versions of each
+// key, unsupported on structures of 'kind' that only retain the latest state of a key.
+func checkHistorySupported(cfg *LogConfig, kind StructureKind) error {
+	if cfg.retainsHistory() {
+		return fmt.Errorf("invalid config: config.VersionsToKeep and config.CompactWindow are unsupported on %s structures, which retain only the latest state of each key", kind)
+	}
+	return nil
+}
+
+// compactBeforeWindow retains every state of 'states', sorted by index, within the
+// latest 'w' indexes of the reduced interval [p, n] verbatim, while older ones are
+// reduced to the latest 'k' states of each key.
+func compactBeforeWindow(states []pb.Command, n, w uint64, k int) []pb.Command {
+	if w == 0 {
+		return keepLastVersions(states, k)
+	}
+	if n < w {
+		return states
+	}
+	i := sort.Search(len(states), func(i int) bool { return states[i].Id > n-w })
+	log := keepLastVersions(states[:i], k)
+	return append(log, states[i:]...)
+}
+
+// keepLastVersions returns the latest 'k' states of each key on 'states', sorted by
+// index, preserving their order. Conditional updates (i.e. CAS) retained as the oldest
+// version of a key also retain the states they were applied over, as done by every
+// reducer.
+func keepLastVersions(states []pb.Command, k int) []pb.Command {
+	type versions struct {
+		kept   int
+		closed bool
+	}
+	seen := make(map[string]*versions)
+	keep := make([]bool, len(states))
+
+	for i := len(states) - 1; i >= 0; i-- {
+		c := &states[i]
+		if !isWriteOp(c.Op) {
+			continue
+		}
+		v, ok := seen[c.Key]
+		if !ok {
+			v = &versions{}
+			seen[c.Key] = v
+		}
+		if v.closed {
+			continue
+		}
+		keep[i] = true
+		v.kept++
+		v.closed = v.kept >= k && c.Op != pb.Command_CAS
+	}
+
+	log := make([]pb.Command, 0, len(states))
+	for i, c := range states {
+		if keep[i] {
+			log = append(log, c)
+		}
+	}
+	return log
+}
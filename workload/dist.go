@@ -0,0 +1,144 @@
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Distribution identifies how keys of generated commands are selected.
+type Distribution string
+
+const (
+	// Uniform selects every key with the same probability.
+	Uniform Distribution = "uniform"
+
+	// Zipfian concentrates commands on a few popular keys, following a zipfian
+	// distribution with constant 'Config.Theta' over key indexes.
+	Zipfian Distribution = "zipfian"
+
+	// Latest is analogous to Zipfian, but the most recently inserted keys are the
+	// most popular ones.
+	Latest Distribution = "latest"
+
+	// Hotspot selects keys of a hot set, the first 'Config.HotSetFraction' of the key
+	// space, on 'Config.HotOpFraction' of the commands, and uniformly selects any other
+	// key on the remaining ones.
+	Hotspot Distribution = "hotspot"
+)
+
+// ParseDistribution returns the Distribution identified by 's', defaulting to Uniform
+// if none is informed.
+func ParseDistribution(s string) (Distribution, error) {
+	switch d := Distribution(s); d {
+	case "":
+		return Uniform, nil
+
+	case Uniform, Zipfian, Latest, Hotspot:
+		return d, nil
+
+	default:
+		return "", fmt.Errorf("unknown key distribution '%s'", s)
+	}
+}
+
+// keyChooser selects key indexes within [0, n), where 'n' may grow between calls as
+// new keys are inserted.
+type keyChooser interface {
+	next(r *rand.Rand, n int) int
+}
+
+func newKeyChooser(cfg *Config) keyChooser {
+	switch cfg.Dist {
+	case Zipfian:
+		return &zipfian{theta: cfg.Theta}
+
+	case Latest:
+		return &latest{zipfian{theta: cfg.Theta}}
+
+	case Hotspot:
+		return &hotspot{set: cfg.HotSetFraction, ops: cfg.HotOpFraction}
+
+	default:
+		return uniform{}
+	}
+}
+
+type uniform struct{}
+
+func (uniform) next(r *rand.Rand, n int) int {
+	return r.Intn(n)
+}
+
+// zipfian implements the zipfian generator of YCSB (Gray et al., "Quickly Generating
+// Billion-Record Synthetic Databases"), where index 0 is the most popular one. Unlike
+// math/rand.Zipf, it supports constants lower than one, such as the 0.99 default of
+// YCSB. The zeta constant is incrementally computed as the key space grows.
+type zipfian struct {
+	theta float64
+	n     int
+	zetan float64
+	zeta2 float64
+	alpha float64
+	eta   float64
+}
+
+func (z *zipfian) next(r *rand.Rand, n int) int {
+	if n != z.n {
+		z.resize(n)
+	}
+	u := r.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+math.Pow(0.5, z.theta) {
+		return 1
+	}
+	i := int(float64(n) * math.Pow(z.eta*u-z.eta+1, z.alpha))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+// resize updates the zeta constant into a key space of 'n' keys, only accounting
+// the new keys if the space grew.
+func (z *zipfian) resize(n int) {
+	from := z.n
+	if n < z.n || z.n == 0 {
+		from, z.zetan = 0, 0
+		z.zeta2 = 1 + math.Pow(0.5, z.theta)
+		z.alpha = 1 / (1 - z.theta)
+	}
+	for i := from + 1; i <= n; i++ {
+		z.zetan += 1 / math.Pow(float64(i), z.theta)
+	}
+	z.n = n
+	z.eta = (1 - math.Pow(2/float64(n), 1-z.theta)) / (1 - z.zeta2/z.zetan)
+}
+
+// latest selects recently inserted keys following a zipfian distribution over their
+// recency.
+type latest struct {
+	z zipfian
+}
+
+func (l *latest) next(r *rand.Rand, n int) int {
+	return n - 1 - l.z.next(r, n)
+}
+
+type hotspot struct {
+	set, ops float64
+}
+
+func (h *hotspot) next(r *rand.Rand, n int) int {
+	hot := int(h.set * float64(n))
+	if hot < 1 {
+		hot = 1
+	}
+	if hot >= n || r.Float64() < h.ops {
+		return r.Intn(hot)
+	}
+	return hot + r.Intn(n-hot)
+}
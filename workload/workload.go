@@ -0,0 +1,213 @@
+// Package workload generates command streams following the key distributions and
+// operation mixes of YCSB (i.e. uniform, zipfian, latest and hotspot keys, and the
+// core workloads A to F), usable by the simulator, benchmarks and users' own tests
+// of beelog structures.
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+// Config configures a Generator. Zero values are replaced by defaults.
+type Config struct {
+	// initial number of distinct keys, grown by each insert
+	Keys int
+
+	// key distribution, and its parameters: the zipfian constant of Zipfian and
+	// Latest distributions, and the hot set and hot operations fractions of Hotspot
+	Dist           Distribution
+	Theta          float64
+	HotSetFraction float64
+	HotOpFraction  float64
+
+	// proportions of each operation, normalized by their sum. Commands only read or
+	// write single keys, so scans are generated as reads of their start key, and
+	// read-modify-writes as a read followed by an update of the same key. If none is
+	// provided, half of the operations are reads and half are updates
+	Read            float64
+	Update          float64
+	Insert          float64
+	Scan            float64
+	ReadModifyWrite float64
+
+	// seed of the generated stream, reproducing the stream of prior runs
+	Seed int64
+}
+
+const (
+	defaultKeys           = 1000
+	defaultTheta          = 0.99
+	defaultHotSetFraction = 0.2
+	defaultHotOpFraction  = 0.8
+)
+
+func (c *Config) setDefaults() {
+	if c.Keys == 0 {
+		c.Keys = defaultKeys
+	}
+	if c.Dist == "" {
+		c.Dist = Uniform
+	}
+	if c.Theta == 0 {
+		c.Theta = defaultTheta
+	}
+	if c.HotSetFraction == 0 {
+		c.HotSetFraction = defaultHotSetFraction
+	}
+	if c.HotOpFraction == 0 {
+		c.HotOpFraction = defaultHotOpFraction
+	}
+	if c.Read+c.Update+c.Insert+c.Scan+c.ReadModifyWrite == 0 {
+		c.Read, c.Update = 0.5, 0.5
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Keys < 0 {
+		return errors.New("number of keys must be non-negative")
+	}
+	if _, err := ParseDistribution(string(c.Dist)); err != nil {
+		return err
+	}
+	if c.Theta <= 0 || c.Theta == 1 {
+		return errors.New("zipfian constant must be positive and different than one")
+	}
+	if c.HotSetFraction < 0 || c.HotSetFraction > 1 || c.HotOpFraction < 0 || c.HotOpFraction > 1 {
+		return errors.New("hotspot fractions must be in the [0, 1] range")
+	}
+	if c.Read < 0 || c.Update < 0 || c.Insert < 0 || c.Scan < 0 || c.ReadModifyWrite < 0 {
+		return errors.New("operation proportions must be non-negative")
+	}
+	return nil
+}
+
+// YCSB returns the config of the YCSB core workload 'mix' (i.e. "a" to "f", ignoring
+// case) over 'keys' initial keys:
+//
+//	A: 50% reads and 50% updates, zipfian keys
+//	B: 95% reads and 5% updates, zipfian keys
+//	C: only reads, zipfian keys
+//	D: 95% reads and 5% inserts, latest keys
+//	E: 95% scans and 5% inserts, zipfian keys
+//	F: 50% reads and 50% read-modify-writes, zipfian keys
+func YCSB(mix string, keys int) (Config, error) {
+	cfg := Config{Keys: keys, Dist: Zipfian}
+	switch strings.ToLower(mix) {
+	case "a":
+		cfg.Read, cfg.Update = 0.5, 0.5
+	case "b":
+		cfg.Read, cfg.Update = 0.95, 0.05
+	case "c":
+		cfg.Read = 1
+	case "d":
+		cfg.Read, cfg.Insert, cfg.Dist = 0.95, 0.05, Latest
+	case "e":
+		cfg.Scan, cfg.Insert = 0.95, 0.05
+	case "f":
+		cfg.Read, cfg.ReadModifyWrite = 0.5, 0.5
+	default:
+		return Config{}, fmt.Errorf("unknown ycsb workload '%s'", mix)
+	}
+	return cfg, nil
+}
+
+// Generator generates a stream of commands with sequential Ids, starting at zero.
+// Generators are not safe for concurrent use.
+type Generator struct {
+	cfg     Config
+	r       *rand.Rand
+	keys    keyChooser
+	n       int
+	id      uint64
+	pending []pb.Command
+}
+
+// New returns a new generator configured by 'cfg'.
+func New(cfg Config) (*Generator, error) {
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Generator{
+		cfg:  cfg,
+		r:    rand.New(rand.NewSource(cfg.Seed)),
+		keys: newKeyChooser(&cfg),
+		n:    cfg.Keys,
+	}, nil
+}
+
+// Keys returns the current number of distinct keys, including inserted ones.
+func (g *Generator) Keys() int {
+	return g.n
+}
+
+// Next returns the next command of the stream.
+func (g *Generator) Next() pb.Command {
+	if len(g.pending) > 0 {
+		cmd := g.pending[0]
+		g.pending = g.pending[1:]
+		return cmd
+	}
+
+	c := &g.cfg
+	total := c.Read + c.Update + c.Insert + c.Scan + c.ReadModifyWrite
+	op := g.r.Float64() * total
+
+	switch {
+	case op < c.Read:
+		return g.read(g.nextKey())
+
+	case op < c.Read+c.Update:
+		return g.write(g.nextKey())
+
+	case op < c.Read+c.Update+c.Insert:
+		key := g.n
+		g.n++
+		return g.write(key)
+
+	case op < c.Read+c.Update+c.Insert+c.Scan:
+		return g.read(g.nextKey())
+
+	default:
+		key := g.nextKey()
+		cmd := g.read(key)
+		g.pending = append(g.pending, g.write(key))
+		return cmd
+	}
+}
+
+// Commands returns the next 'n' commands of the stream.
+func (g *Generator) Commands(n uint64) []pb.Command {
+	log := make([]pb.Command, 0, n)
+	for i := uint64(0); i < n; i++ {
+		log = append(log, g.Next())
+	}
+	return log
+}
+
+// nextKey returns the index of the next selected key, inserting the first one on
+// empty key spaces.
+func (g *Generator) nextKey() int {
+	if g.n == 0 {
+		g.n = 1
+	}
+	return g.keys.next(g.r, g.n)
+}
+
+func (g *Generator) read(key int) pb.Command {
+	cmd := pb.Command{Id: g.id, Op: pb.Command_GET, Key: strconv.Itoa(key)}
+	g.id++
+	return cmd
+}
+
+func (g *Generator) write(key int) pb.Command {
+	cmd := pb.Command{Id: g.id, Op: pb.Command_SET, Key: strconv.Itoa(key), Value: strconv.Itoa(g.r.Int())}
+	g.id++
+	return cmd
+}
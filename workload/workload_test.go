@@ -0,0 +1,128 @@
+package workload
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Lz-Gustavo/beelog/pb"
+)
+
+func TestDistributions(t *testing.T) {
+	n, keys := uint64(20000), 1000
+	for _, dist := range []Distribution{Uniform, Zipfian, Hotspot} {
+		g, err := New(Config{Keys: keys, Dist: dist, Read: 1})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		freq := make(map[int]int)
+		for i, c := range g.Commands(n) {
+			if c.Id != uint64(i) || c.Op != pb.Command_GET {
+				t.Fatalf("%s: unexpected command %v at position %d", dist, c, i)
+			}
+			k, _ := strconv.Atoi(c.Key)
+			if k < 0 || k >= keys {
+				t.Fatalf("%s: key %d out of the key space", dist, k)
+			}
+			freq[k]++
+		}
+
+		hot := 0
+		for k := 0; k < keys/5; k++ {
+			hot += freq[k]
+		}
+		share := float64(hot) / float64(n)
+
+		switch dist {
+		case Uniform:
+			if share < 0.15 || share > 0.25 {
+				t.Fatalf("uniform: expected ~20%% of commands on the first fifth of keys, got %.2f", share)
+			}
+		case Zipfian:
+			if freq[0] <= freq[keys/2] || share < 0.6 {
+				t.Fatalf("zipfian: expected commands concentrated on the first keys, got %.2f", share)
+			}
+		case Hotspot:
+			if share < 0.75 || share > 0.85 {
+				t.Fatalf("hotspot: expected ~80%% of commands on the hot set, got %.2f", share)
+			}
+		}
+	}
+}
+
+func TestYCSB(t *testing.T) {
+	n, keys := uint64(10000), 1000
+	for _, mix := range []string{"a", "b", "c", "d", "e", "f"} {
+		cfg, err := YCSB(mix, keys)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		g, err := New(cfg)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		log := g.Commands(n)
+
+		var writes int
+		for i, c := range log {
+			if c.Op == pb.Command_SET {
+				writes++
+
+				// updates of read-modify-writes follow the read of the same key
+				if mix == "f" && (i == 0 || log[i-1].Op != pb.Command_GET || log[i-1].Key != c.Key) {
+					t.Fatalf("f: expected a read of key '%s' before its update", c.Key)
+				}
+			}
+		}
+		share := float64(writes) / float64(n)
+
+		switch mix {
+		case "c":
+			if writes != 0 {
+				t.Fatalf("c: expected only reads, got %d writes", writes)
+			}
+		case "b", "d", "e":
+			if share < 0.03 || share > 0.07 {
+				t.Fatalf("%s: expected ~5%% of writes, got %.2f", mix, share)
+			}
+		}
+
+		// inserts grow the key space, and latest keys are the most popular
+		if mix == "d" {
+			if g.Keys() <= keys {
+				t.Fatal("d: expected inserted keys")
+			}
+			var reads, recent int
+			for _, c := range log {
+				if k, _ := strconv.Atoi(c.Key); c.Op == pb.Command_GET {
+					reads++
+					if k >= keys/2 {
+						recent++
+					}
+				}
+			}
+			if share := float64(recent) / float64(reads); share < 0.7 {
+				t.Fatalf("d: expected reads concentrated on recent keys, got %.2f", share)
+			}
+		}
+	}
+
+	if _, err := YCSB("g", keys); err == nil {
+		t.Fatal("expected an error on unknown ycsb workloads")
+	}
+}
+
+func TestGeneratorSeed(t *testing.T) {
+	cfg, _ := YCSB("a", 100)
+	cfg.Seed = 7
+	a, _ := New(cfg)
+	b, _ := New(cfg)
+	if !reflect.DeepEqual(a.Commands(500), b.Commands(500)) {
+		t.Fatal("expected identical streams from the same seed")
+	}
+
+	if _, err := New(Config{Dist: "pareto"}); err == nil {
+		t.Fatal("expected an error on unknown distributions")
+	}
+}